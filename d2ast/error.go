@@ -8,8 +8,22 @@ package d2ast
 type Error struct {
 	Range   Range  `json:"range"`
 	Message string `json:"errmsg"`
+	// Fixes are the edits that would resolve the error, best first.
+	Fixes []Fix `json:"fixes,omitempty"`
 }
 
 func (e Error) Error() string {
 	return e.Message
 }
+
+// Fix is a machine-applicable fix for an Error.
+type Fix struct {
+	Title string     `json:"title"`
+	Edits []TextEdit `json:"edits"`
+}
+
+// TextEdit replaces the text of Range with NewText. An empty range inserts NewText.
+type TextEdit struct {
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
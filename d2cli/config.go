@@ -0,0 +1,299 @@
+package d2cli
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"oss.terrastruct.com/util-go/xdefer"
+	"oss.terrastruct.com/util-go/xmain"
+
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2format"
+	"oss.terrastruct.com/d2/d2parser"
+	"oss.terrastruct.com/d2/d2plugin"
+	"oss.terrastruct.com/d2/lib/imgbundler"
+)
+
+// configDir returns the directory of the user configuration of d2, which contains
+// config.d2 and the plugins installed with d2 plugin install.
+func configDir(ms *xmain.State) (string, error) {
+	if dir := ms.Env.Getenv("D2_CONFIG_DIR"); dir != "" {
+		return ms.AbsPath(dir), nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "d2"), nil
+}
+
+// installedPluginsDir returns the directory d2 plugin install copies plugins to.
+func installedPluginsDir(ms *xmain.State) (string, error) {
+	dir, err := configDir(ms)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "plugins"), nil
+}
+
+// userConfig is the configuration in config.d2 of the configuration directory, e.g.
+//
+//	plugins: [~/d2/plugins; ./d2plugin-elk.wasm]
+//	plugin-checksums: {
+//	  elk: sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//	}
+//	browser: /usr/bin/chromium
+//	image-headers: {
+//	  "https://artifacts.example.com/icons/": {
+//	    Authorization: 'Bearer ${ARTIFACTS_TOKEN}'
+//	  }
+//	}
+type userConfig struct {
+	// Plugins are the paths to search for plugins. Relative paths are relative to the
+	// configuration directory.
+	Plugins []string
+	// PluginChecksums pins the checksums of plugins, see d2plugin.ListOptions.
+	PluginChecksums map[string]string
+	// Browser is the path of the browser to rasterize with instead of downloading Chromium,
+	// set with d2 playwright use.
+	Browser string
+	// ImageHeaders are the headers to fetch remote images with by URL pattern, see
+	// imgbundler.FetchOptions. ${VAR} in their values is replaced by the environment variable
+	// so that secrets need not be written in the file.
+	ImageHeaders []imgbundler.HeaderRule
+}
+
+func readConfig(ms *xmain.State) (_ *userConfig, err error) {
+	cfg := &userConfig{}
+	dir, err := configDir(ms)
+	if err != nil {
+		return cfg, nil
+	}
+	fp := filepath.Join(dir, "config.d2")
+	defer xdefer.Errorf(&err, "failed to read %s", fp)
+
+	b, err := os.ReadFile(fp)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ast, err := d2parser.Parse(fp, bytes.NewReader(b), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, n := range ast.Nodes {
+		k := n.MapKey
+		if k == nil || k.Key == nil || len(k.Edges) > 0 || len(k.Key.Path) != 1 {
+			continue
+		}
+		switch k.Key.Path[0].Unbox().ScalarString() {
+		case "plugins":
+			var values []d2ast.Node
+			switch {
+			case k.Value.Array != nil:
+				for _, an := range k.Value.Array.Nodes {
+					values = append(values, an.Unbox())
+				}
+			case k.Value.Unbox() != nil:
+				values = []d2ast.Node{k.Value.Unbox()}
+			}
+			cfg.Plugins = nil
+			for _, v := range values {
+				s, ok := v.(d2ast.String)
+				if !ok {
+					return nil, d2parser.Errorf(v, "plugins must be paths")
+				}
+				cfg.Plugins = append(cfg.Plugins, expandPath(dir, s.ScalarString()))
+			}
+		case "plugin-checksums":
+			if k.Value.Map == nil {
+				return nil, d2parser.Errorf(k, "plugin-checksums must be a map of plugin names to checksums")
+			}
+			cfg.PluginChecksums = make(map[string]string)
+			for _, n := range k.Value.Map.Nodes {
+				ck := n.MapKey
+				if ck == nil {
+					continue
+				}
+				s, ok := ck.Value.Unbox().(d2ast.String)
+				if ck.Key == nil || len(ck.Key.Path) != 1 || !ok {
+					return nil, d2parser.Errorf(ck, "plugin-checksums must be a map of plugin names to checksums")
+				}
+				cfg.PluginChecksums[ck.Key.Path[0].Unbox().ScalarString()] = s.ScalarString()
+			}
+		case "browser":
+			s, ok := k.Value.Unbox().(d2ast.String)
+			if !ok {
+				return nil, d2parser.Errorf(k, "browser must be a path")
+			}
+			cfg.Browser = expandPath(dir, s.ScalarString())
+		case "image-headers":
+			cfg.ImageHeaders, err = readImageHeaders(ms, k)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return cfg, nil
+}
+
+func readImageHeaders(ms *xmain.State, k *d2ast.Key) ([]imgbundler.HeaderRule, error) {
+	const usage = "image-headers must be a map of URL patterns to maps of headers"
+	if k.Value.Map == nil {
+		return nil, d2parser.Errorf(k, usage)
+	}
+	var rules []imgbundler.HeaderRule
+	for _, n := range k.Value.Map.Nodes {
+		pk := n.MapKey
+		if pk == nil {
+			continue
+		}
+		if pk.Key == nil || len(pk.Key.Path) != 1 || pk.Value.Map == nil {
+			return nil, d2parser.Errorf(pk, usage)
+		}
+		rule := imgbundler.HeaderRule{
+			Pattern: pk.Key.Path[0].Unbox().ScalarString(),
+			Header:  make(http.Header),
+		}
+		for _, n := range pk.Value.Map.Nodes {
+			hk := n.MapKey
+			if hk == nil {
+				continue
+			}
+			s, ok := hk.Value.Unbox().(d2ast.String)
+			if hk.Key == nil || len(hk.Key.Path) != 1 || !ok {
+				return nil, d2parser.Errorf(hk, usage)
+			}
+			rule.Header.Set(hk.Key.Path[0].Unbox().ScalarString(), os.Expand(s.ScalarString(), ms.Env.Getenv))
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// setConfig sets the top level key of config.d2 to value, or removes it if value is empty,
+// keeping the rest of the file.
+func setConfig(ms *xmain.State, key, value string) (err error) {
+	dir, err := configDir(ms)
+	if err != nil {
+		return err
+	}
+	fp := filepath.Join(dir, "config.d2")
+	defer xdefer.Errorf(&err, "failed to write %s", fp)
+
+	b, err := os.ReadFile(fp)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	ast, err := d2parser.Parse(fp, bytes.NewReader(b), nil)
+	if err != nil {
+		return err
+	}
+	nodes := make([]d2ast.MapNodeBox, 0, len(ast.Nodes)+1)
+	for _, n := range ast.Nodes {
+		k := n.MapKey
+		if k != nil && k.Key != nil && len(k.Edges) == 0 && len(k.Key.Path) == 1 && k.Key.Path[0].Unbox().ScalarString() == key {
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	ast.Nodes = nodes
+	out := d2format.Format(ast)
+	if value != "" {
+		// Appended after the formatting, which puts nodes without ranges on the same line.
+		out = strings.TrimSuffix(out, "\n")
+		if out != "" {
+			out += "\n"
+		}
+		out += d2format.Format(&d2ast.Key{
+			Key:   d2ast.MakeKeyPath([]string{key}),
+			Value: d2ast.MakeValueBox(d2ast.RawString(value, false)),
+		}) + "\n"
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fp, []byte(out), 0644)
+}
+
+// pluginListOptions returns the options to list plugins with: paths of --plugin-path
+// first, then those of config.d2 and the directory of installed plugins.
+func pluginListOptions(ms *xmain.State, pathFlag string, sandbox, sandboxNetwork bool) (d2plugin.ListOptions, error) {
+	opts := d2plugin.ListOptions{
+		Sandbox:             sandbox,
+		SandboxAllowNetwork: sandboxNetwork,
+	}
+	for _, p := range filepath.SplitList(pathFlag) {
+		if p != "" {
+			opts.Paths = append(opts.Paths, expandPath(ms.PWD, p))
+		}
+	}
+	cfg, err := readConfig(ms)
+	if err != nil {
+		return opts, err
+	}
+	opts.Paths = append(opts.Paths, cfg.Plugins...)
+	if dir, err := installedPluginsDir(ms); err == nil {
+		opts.Paths = append(opts.Paths, dir)
+	}
+	opts.Checksums = cfg.PluginChecksums
+	return opts, nil
+}
+
+// stringFlagArg returns the value of the string flag name in args, or def if it's not
+// passed. Plugin flags are read from args as plugins are listed before flags are parsed.
+func stringFlagArg(args []string, name, def string) string {
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			break
+		}
+		if v, ok := strings.CutPrefix(a, "--"+name+"="); ok {
+			def = v
+		} else if a == "--"+name && i+1 < len(args) {
+			def = args[i+1]
+			i++
+		}
+	}
+	return def
+}
+
+// boolFlagArg is stringFlagArg for bool flags.
+func boolFlagArg(args []string, name string, def bool) bool {
+	for _, a := range args {
+		if a == "--" {
+			break
+		}
+		if a == "--"+name {
+			def = true
+		} else if v, ok := strings.CutPrefix(a, "--"+name+"="); ok {
+			if b, err := strconv.ParseBool(v); err == nil {
+				def = b
+			}
+		}
+	}
+	return def
+}
+
+// expandPath expands a leading ~ and makes p absolute relative to dir.
+func expandPath(dir, p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			p = filepath.Join(home, p[1:])
+		}
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(dir, p)
+	}
+	return p
+}
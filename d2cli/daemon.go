@@ -0,0 +1,440 @@
+package d2cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"oss.terrastruct.com/util-go/cmdlog"
+	"oss.terrastruct.com/util-go/xdefer"
+	"oss.terrastruct.com/util-go/xmain"
+	"oss.terrastruct.com/util-go/xos"
+
+	"oss.terrastruct.com/d2/lib/png"
+	"oss.terrastruct.com/d2/lib/textmeasure"
+	"oss.terrastruct.com/d2/lib/version"
+)
+
+// The daemon protocol lets d2 invocations with --daemon forward renders to a background
+// process started with the daemon subcommand, which keeps what is slow to set up warm
+// across invocations: the browser PNG, PDF, PPTX and GIF exports are rasterized with and
+// the measurements of text.
+//
+//  1. The client connects to the Unix socket of the daemon, starting the daemon first if
+//     it isn't listening.
+//  2. It writes a daemonRequest with its arguments, environment and working directory.
+//  3. The daemon runs them as the client would have, writing a daemonResponse for every
+//     write to stdout or stderr and a last one with the error the client exits with.
+//
+// Requests and responses are JSON values written one after the other. The daemon serves
+// requests one at a time and exits once idle for daemonIdleTimeout.
+type daemonRequest struct {
+	Version string   `json:"version"`
+	Args    []string `json:"args,omitempty"`
+	Env     []string `json:"env,omitempty"`
+	PWD     string   `json:"pwd,omitempty"`
+	// Stop asks the daemon to exit.
+	Stop bool `json:"stop,omitempty"`
+}
+
+type daemonResponse struct {
+	Stdout []byte `json:"stdout,omitempty"`
+	Stderr []byte `json:"stderr,omitempty"`
+
+	Done  bool   `json:"done,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Code is the code of an xmain.ExitError.
+	Code int `json:"code,omitempty"`
+	// Usage is set if Error is the message of an xmain.UsageError.
+	Usage bool `json:"usage,omitempty"`
+	// Mismatch is set instead of running the request if the daemon is of another version
+	// than the client. The daemon exits so that the next invocation starts a new one.
+	Mismatch bool `json:"mismatch,omitempty"`
+}
+
+func (resp daemonResponse) err() error {
+	switch {
+	case resp.Usage:
+		return xmain.UsageErrorf("%s", resp.Error)
+	case resp.Code != 0:
+		return xmain.ExitError{Code: resp.Code, Message: resp.Error}
+	case resp.Error != "":
+		return errors.New(resp.Error)
+	}
+	return nil
+}
+
+const daemonIdleTimeout = time.Minute * 30
+
+// daemonSocket returns the path of the socket of the daemon, $D2_DAEMON_SOCKET or
+// daemon.sock in the d2 directory of the user cache directory.
+func daemonSocket(ms *xmain.State) (string, error) {
+	if p := ms.Env.Getenv("D2_DAEMON_SOCKET"); p != "" {
+		return ms.AbsPath(p), nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "d2", "daemon.sock"), nil
+}
+
+type daemonContextKey struct{}
+
+// daemon is the state kept warm across the requests a daemon serves.
+type daemon struct {
+	measureCache *textmeasure.Cache
+
+	pw        png.Playwright
+	pwStarted bool
+}
+
+func daemonFromContext(ctx context.Context) *daemon {
+	d, _ := ctx.Value(daemonContextKey{}).(*daemon)
+	return d
+}
+
+// playwright returns the browser of the daemon, starting it on first use with browserPath,
+// see png.InitPlaywright.
+func (d *daemon) playwright(browserPath string) (png.Playwright, error) {
+	if d.pwStarted {
+		return d.pw, nil
+	}
+	pw, err := png.InitPlaywright(browserPath)
+	if err != nil {
+		return png.Playwright{}, err
+	}
+	d.pw = pw
+	d.pwStarted = true
+	return d.pw, nil
+}
+
+func (d *daemon) cleanup() error {
+	if !d.pwStarted {
+		return nil
+	}
+	return d.pw.Cleanup()
+}
+
+func daemonCmd(ctx context.Context, ms *xmain.State) error {
+	args := ms.Opts.Flags.Args()[1:]
+	if len(args) == 1 && args[0] == "stop" {
+		return stopDaemon(ms)
+	}
+	if len(args) > 0 {
+		return xmain.UsageErrorf("daemon subcommand accepts no arguments but stop")
+	}
+	return serveDaemon(ctx, ms)
+}
+
+func serveDaemon(ctx context.Context, ms *xmain.State) (err error) {
+	sock, err := daemonSocket(ms)
+	if err != nil {
+		return err
+	}
+	defer xdefer.Errorf(&err, "failed to serve on %s", sock)
+
+	if conn, err := net.Dial("unix", sock); err == nil {
+		conn.Close()
+		return errors.New("a daemon is already listening")
+	}
+	err = os.MkdirAll(filepath.Dir(sock), 0700)
+	if err != nil {
+		return err
+	}
+	// The socket of a daemon that didn't exit cleanly.
+	os.Remove(sock)
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	d := &daemon{
+		measureCache: textmeasure.NewCache(),
+	}
+	defer func() {
+		cleanupErr := d.cleanup()
+		if err == nil {
+			err = cleanupErr
+		}
+	}()
+
+	var closed atomic.Bool
+	closeListener := func() {
+		closed.Store(true)
+		l.Close()
+	}
+	stopAfter := context.AfterFunc(ctx, closeListener)
+	defer stopAfter()
+	idle := time.AfterFunc(daemonIdleTimeout, closeListener)
+	defer idle.Stop()
+
+	ms.Log.Info.Printf("listening on %s", sock)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if closed.Load() {
+				return nil
+			}
+			return err
+		}
+		if !idle.Stop() {
+			// The listener was just closed for being idle.
+			conn.Close()
+			return nil
+		}
+		stop := d.serve(ctx, ms, conn)
+		if stop {
+			return nil
+		}
+		idle.Reset(daemonIdleTimeout)
+	}
+}
+
+// serve runs the request read from conn. It returns whether the daemon must exit.
+func (d *daemon) serve(ctx context.Context, ms *xmain.State, conn net.Conn) (stop bool) {
+	defer conn.Close()
+
+	var req daemonRequest
+	dec := json.NewDecoder(conn)
+	err := dec.Decode(&req)
+	if err != nil {
+		ms.Log.Warn.Printf("failed to read request: %v", err)
+		return false
+	}
+	w := &daemonWriter{
+		enc: json.NewEncoder(conn),
+	}
+	if req.Stop {
+		w.send(daemonResponse{Done: true})
+		return true
+	}
+	if req.Version != version.Version {
+		ms.Log.Info.Printf("exiting for d2 %s", req.Version)
+		w.send(daemonResponse{Mismatch: true})
+		return true
+	}
+
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, daemonContextKey{}, d))
+	defer cancel()
+	go func() {
+		// Nothing else is read so this returns once the client disconnects, e.g. because it
+		// was interrupted.
+		io.Copy(io.Discard, dec.Buffered())
+		io.Copy(io.Discard, conn)
+		cancel()
+	}()
+
+	env := xos.NewEnv(req.Env)
+	ms2 := &xmain.State{
+		Name:   ms.Name,
+		Stdin:  strings.NewReader(""),
+		Stdout: daemonStream{w, false},
+		Stderr: daemonStream{w, true},
+		Env:    env,
+		Opts:   xmain.NewOpts(env, req.Args),
+		PWD:    req.PWD,
+	}
+	ms2.Log = cmdlog.New(env, ms2.Stderr)
+	// Requests are served one at a time so the working directory can be that of the
+	// client, for anything that isn't relative to ms.PWD.
+	if wd, err := os.Getwd(); err == nil {
+		defer os.Chdir(wd)
+	}
+	err = os.Chdir(req.PWD)
+	if err != nil {
+		ms.Log.Warn.Printf("failed to change directory to %s: %v", req.PWD, err)
+	}
+
+	err = Run(ctx, ms2)
+	resp := daemonResponse{Done: true}
+	if err != nil {
+		var eerr xmain.ExitError
+		var uerr xmain.UsageError
+		switch {
+		case errors.As(err, &eerr):
+			resp.Code = eerr.Code
+			resp.Error = eerr.Message
+		case errors.As(err, &uerr):
+			resp.Usage = true
+			resp.Error = uerr.Message
+		default:
+			resp.Error = err.Error()
+		}
+	}
+	w.send(resp)
+	return false
+}
+
+// daemonWriter writes responses to a client, from any goroutine of the request.
+type daemonWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (w *daemonWriter) send(resp daemonResponse) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(resp)
+}
+
+// daemonStream is the stdout or stderr of a request.
+type daemonStream struct {
+	w      *daemonWriter
+	stderr bool
+}
+
+func (s daemonStream) Write(p []byte) (int, error) {
+	var resp daemonResponse
+	if s.stderr {
+		resp.Stderr = p
+	} else {
+		resp.Stdout = p
+	}
+	err := s.w.send(resp)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (daemonStream) Close() error { return nil }
+
+// forwardToDaemon runs the invocation of ms in the daemon, starting it if it isn't
+// listening. forwarded is false if the daemon couldn't run it, in which case the caller
+// runs it itself.
+func forwardToDaemon(ctx context.Context, ms *xmain.State) (forwarded bool, err error) {
+	sock, err := daemonSocket(ms)
+	if err != nil {
+		return false, err
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		err = startDaemon(ms, sock)
+		if err != nil {
+			return false, err
+		}
+		conn, err = dialDaemon(ctx, sock)
+		if err != nil {
+			return false, err
+		}
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	err = json.NewEncoder(conn).Encode(daemonRequest{
+		Version: version.Version,
+		Args:    ms.Opts.Args,
+		Env:     ms.Env.Environ(),
+		PWD:     ms.PWD,
+	})
+	if err != nil {
+		return false, err
+	}
+	dec := json.NewDecoder(conn)
+	for {
+		var resp daemonResponse
+		err := dec.Decode(&resp)
+		if err != nil {
+			if !forwarded {
+				return false, err
+			}
+			return true, fmt.Errorf("lost connection to the daemon: %w", err)
+		}
+		if resp.Mismatch {
+			return false, errors.New("the daemon is of another version")
+		}
+		forwarded = true
+		if len(resp.Stdout) > 0 {
+			ms.Stdout.Write(resp.Stdout)
+		}
+		if len(resp.Stderr) > 0 {
+			ms.Stderr.Write(resp.Stderr)
+		}
+		if resp.Done {
+			return true, resp.err()
+		}
+	}
+}
+
+// startDaemon starts the daemon subcommand of the running executable in the background.
+func startDaemon(ms *xmain.State, sock string) (err error) {
+	defer xdefer.Errorf(&err, "failed to start daemon")
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(sock), 0700)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, "daemon")
+	cmd.Env = ms.Env.Environ()
+	cmd.Dir = filepath.Dir(sock)
+	detach(cmd)
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	ms.Log.Debug.Printf("started daemon with pid %d", cmd.Process.Pid)
+	return cmd.Process.Release()
+}
+
+// dialDaemon connects to a daemon that was just started once it's listening.
+func dialDaemon(ctx context.Context, sock string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	for {
+		conn, err := net.Dial("unix", sock)
+		if err == nil {
+			return conn, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("daemon didn't start listening: %w", err)
+		case <-time.After(time.Millisecond * 50):
+		}
+	}
+}
+
+func stopDaemon(ms *xmain.State) error {
+	sock, err := daemonSocket(ms)
+	if err != nil {
+		return err
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		ms.Log.Info.Printf("no daemon is listening on %s", humanPath(sock))
+		return nil
+	}
+	defer conn.Close()
+	err = json.NewEncoder(conn).Encode(daemonRequest{
+		Version: version.Version,
+		Stop:    true,
+	})
+	if err != nil {
+		return err
+	}
+	var resp daemonResponse
+	err = json.NewDecoder(conn).Decode(&resp)
+	if err != nil {
+		return err
+	}
+	ms.Log.Success.Printf("stopped daemon listening on %s", humanPath(sock))
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package d2cli
+
+import "os/exec"
+
+// detach is a no-op where processes cannot be started in a new session.
+func detach(cmd *exec.Cmd) {}
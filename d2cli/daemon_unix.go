@@ -0,0 +1,15 @@
+//go:build unix
+
+package d2cli
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detach starts cmd in a new session so that it outlives the terminal it was started from.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
+	}
+}
@@ -1,7 +1,12 @@
 package d2cli
 
 import (
+	"context"
 	"path/filepath"
+
+	"oss.terrastruct.com/util-go/xmain"
+
+	"oss.terrastruct.com/d2/d2plugin"
 )
 
 type exportExtension string
@@ -11,8 +16,10 @@ const PNG exportExtension = ".png"
 const PPTX exportExtension = ".pptx"
 const PDF exportExtension = ".pdf"
 const SVG exportExtension = ".svg"
+const HTML exportExtension = ".html"
+const JSON exportExtension = ".json"
 
-var SUPPORTED_EXTENSIONS = []exportExtension{SVG, PNG, PDF, PPTX, GIF}
+var SUPPORTED_EXTENSIONS = []exportExtension{SVG, PNG, PDF, PPTX, GIF, HTML, JSON}
 
 func getExportExtension(outputPath string) exportExtension {
 	ext := filepath.Ext(outputPath)
@@ -25,6 +32,56 @@ func getExportExtension(outputPath string) exportExtension {
 	return exportExtension(SVG)
 }
 
+// outputRenderer returns the renderer plugin of the format of outputPath if d2 does not
+// export to it itself.
+func outputRenderer(ctx context.Context, ps []d2plugin.Plugin, outputPath string) (d2plugin.RenderingPlugin, error) {
+	ext := filepath.Ext(outputPath)
+	for _, kext := range SUPPORTED_EXTENSIONS {
+		if kext == exportExtension(ext) {
+			return nil, nil
+		}
+	}
+	return d2plugin.FindRenderer(ctx, ps, ext)
+}
+
+// checkOutputFormat returns a usage error if the flags of ms cannot be used to export to
+// outputPath. Errors parsing the flags are left to their own checks.
+func checkOutputFormat(ms *xmain.State, outputPath string, animateInterval int64) error {
+	if filepath.Ext(outputPath) == ".ppt" {
+		return xmain.UsageErrorf("D2 does not support ppt exports, did you mean \"pptx\"?")
+	}
+	format := getExportExtension(outputPath)
+	if outputPath != "-" {
+		if animateInterval > 0 && !format.supportsAnimation() {
+			return xmain.UsageErrorf("-animate-interval can only be used when exporting to SVG or GIF.\nYou provided: %s", filepath.Ext(outputPath))
+		} else if animateInterval <= 0 && format.requiresAnimationInterval() {
+			return xmain.UsageErrorf("-animate-interval must be greater than 0 for %s outputs.\nYou provided: %d", format, animateInterval)
+		}
+	}
+	if format == SVG {
+		return nil
+	}
+	if tr, err := animateTransition(ms); err == nil && (tr.Duration > 0 || tr.Easing != "") && animateInterval > 0 {
+		return xmain.UsageErrorf("--animate-transition-duration and --animate-easing can only be used when exporting to SVG.\nYou provided: %s", filepath.Ext(outputPath))
+	}
+	if singleFile, _ := ms.Opts.Flags.GetBool("single-file"); singleFile && animateInterval <= 0 {
+		return xmain.UsageErrorf("--single-file can only be used when exporting to SVG.\nYou provided: %s", filepath.Ext(outputPath))
+	}
+	if morph, _ := ms.Opts.Flags.GetBool("animate-morph"); morph && animateInterval > 0 {
+		return xmain.UsageErrorf("--animate-morph can only be used when exporting to SVG.\nYou provided: %s", filepath.Ext(outputPath))
+	}
+	if playback, err := animatePlayback(ms); err == nil && (playback.Loops > 0 || playback.Alternate || playback.DelayMS > 0 || playback.AutoplayVisible) && animateInterval > 0 {
+		return xmain.UsageErrorf("--animate-loops, --animate-direction, --animate-delay and --animate-autoplay can only be used when exporting to SVG.\nYou provided: %s", filepath.Ext(outputPath))
+	}
+	if controls, _ := ms.Opts.Flags.GetBool("animate-controls"); controls && animateInterval > 0 {
+		return xmain.UsageErrorf("--animate-controls can only be used when exporting to SVG.\nYou provided: %s", filepath.Ext(outputPath))
+	}
+	if id, box, err := cropOption(ms); err == nil && (id != "" || box != nil) && format != PNG {
+		return xmain.UsageErrorf("--crop can only be used when exporting to SVG or PNG.\nYou provided: %s", filepath.Ext(outputPath))
+	}
+	return nil
+}
+
 func (ex exportExtension) supportsAnimation() bool {
 	return ex == SVG || ex == GIF
 }
@@ -38,5 +95,5 @@ func (ex exportExtension) requiresPNGRenderer() bool {
 }
 
 func (ex exportExtension) supportsDarkTheme() bool {
-	return ex == SVG
+	return ex == SVG || ex == HTML
 }
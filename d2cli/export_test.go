@@ -73,6 +73,22 @@ func TestOutputFormat(t *testing.T) {
 			requiresAnimationInterval: true,
 			requiresPngRender:         true,
 		},
+		{
+			outputPath:                "/out.json",
+			extension:                 JSON,
+			supportsDarkTheme:         false,
+			supportsAnimation:         false,
+			requiresAnimationInterval: false,
+			requiresPngRender:         false,
+		},
+		{
+			outputPath:                "/out.html",
+			extension:                 HTML,
+			supportsDarkTheme:         true,
+			supportsAnimation:         false,
+			requiresAnimationInterval: false,
+			requiresPngRender:         false,
+		},
 	}
 
 	for _, tc := range testCases {
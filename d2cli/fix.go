@@ -0,0 +1,136 @@
+package d2cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"oss.terrastruct.com/util-go/xdefer"
+
+	"oss.terrastruct.com/util-go/xmain"
+
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2parser"
+)
+
+// maxFixPasses bounds how many times a file is recompiled to fix errors that only surface
+// once others are fixed.
+const maxFixPasses = 8
+
+func fixCmd(ctx context.Context, ms *xmain.State) (err error) {
+	defer xdefer.Errorf(&err, "failed to fix")
+
+	ms.Opts = xmain.NewOpts(ms.Env, ms.Opts.Flags.Args()[1:])
+	if len(ms.Opts.Args) == 0 {
+		return xmain.UsageErrorf("fix must be passed at least one file to be fixed")
+	}
+
+	for _, inputPath := range ms.Opts.Args {
+		if inputPath != "-" {
+			inputPath = ms.AbsPath(inputPath)
+			d, err := os.Stat(inputPath)
+			if err == nil && d.IsDir() {
+				inputPath = filepath.Join(inputPath, "index.d2")
+			}
+		}
+
+		input, err := ms.ReadPath(inputPath)
+		if err != nil {
+			return err
+		}
+
+		output := input
+		fixed := 0
+		for i := 0; i < maxFixPasses; i++ {
+			var n int
+			output, n = applyFixes(inputPath, output)
+			if n == 0 {
+				break
+			}
+			fixed += n
+		}
+		if !bytes.Equal(output, input) {
+			if err := ms.WritePath(inputPath, output); err != nil {
+				return err
+			}
+		}
+		if fixed > 0 && inputPath != "-" {
+			if fixed == 1 {
+				ms.Log.Info.Printf("applied 1 fix to %s", ms.HumanPath(inputPath))
+			} else {
+				ms.Log.Info.Printf("applied %d fixes to %s", fixed, ms.HumanPath(inputPath))
+			}
+		}
+
+		// Report what could not be fixed.
+		_, _, err = d2compiler.Compile(inputPath, bytes.NewReader(output), nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyFixes compiles input and applies the first fix of each of its errors. Fixes that
+// edit other files or overlap the edits of an earlier fix are skipped. It returns the fixed
+// input and the number of fixes applied.
+func applyFixes(inputPath string, input []byte) ([]byte, int) {
+	_, _, err := d2compiler.Compile(inputPath, bytes.NewReader(input), nil)
+	var pe *d2parser.ParseError
+	if !errors.As(err, &pe) {
+		return input, 0
+	}
+
+	var edits []d2ast.TextEdit
+	n := 0
+	for _, e := range pe.Errors {
+		if len(e.Fixes) == 0 || !fixable(inputPath, e.Fixes[0], edits) {
+			continue
+		}
+		edits = append(edits, e.Fixes[0].Edits...)
+		n++
+	}
+	if n == 0 {
+		return input, 0
+	}
+
+	// Apply from the end so that earlier offsets stay valid.
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].Range.Start.Byte > edits[j].Range.Start.Byte
+	})
+	output := append([]byte{}, input...)
+	for i, edit := range edits {
+		if i > 0 && edit == edits[i-1] {
+			// The same object may be created for several errors.
+			continue
+		}
+		start, end := edit.Range.Start.Byte, edit.Range.End.Byte
+		output = append(output[:start:start], append([]byte(edit.NewText), output[end:]...)...)
+	}
+	return output, n
+}
+
+func fixable(inputPath string, fix d2ast.Fix, prev []d2ast.TextEdit) bool {
+	for _, edit := range fix.Edits {
+		if edit.Range.Path != inputPath {
+			return false
+		}
+		for _, p := range prev {
+			if edit == p {
+				continue
+			}
+			if edit.Range.Start.Byte < p.Range.End.Byte && p.Range.Start.Byte < edit.Range.End.Byte {
+				return false
+			}
+			// Insertions at the same offset would be ambiguously ordered.
+			if edit.Range.Start.Byte == p.Range.Start.Byte {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -17,6 +17,23 @@ import (
 func fmtCmd(ctx context.Context, ms *xmain.State) (err error) {
 	defer xdefer.Errorf(&err, "failed to fmt")
 
+	// Options passed as flags override those of each file's config.
+	flagOpts := make(map[string]string)
+	for _, key := range []string{"indent-width", "tabs", "quotes", "braces", "sort-keys"} {
+		if ms.Opts.Flags.Changed("fmt-" + key) {
+			flagOpts[key] = ms.Opts.Flags.Lookup("fmt-" + key).Value.String()
+		}
+	}
+	var flagOptsErr error
+	for key, value := range flagOpts {
+		if err := (&d2format.Options{}).Set(key, value); err != nil {
+			flagOptsErr = xmain.UsageErrorf("--fmt-%s: %v", key, err)
+		}
+	}
+	if flagOptsErr != nil {
+		return flagOptsErr
+	}
+
 	ms.Opts = xmain.NewOpts(ms.Env, ms.Opts.Flags.Args()[1:])
 	if len(ms.Opts.Args) == 0 {
 		return xmain.UsageErrorf("fmt must be passed at least one file to be formatted")
@@ -41,7 +58,17 @@ func fmtCmd(ctx context.Context, ms *xmain.State) (err error) {
 			return err
 		}
 
-		output := []byte(d2format.Format(m))
+		opts, err := d2format.ConfigOptions(m)
+		if err != nil {
+			return err
+		}
+		for key, value := range flagOpts {
+			if err := opts.Set(key, value); err != nil {
+				return err
+			}
+		}
+
+		output := []byte(d2format.FormatWithOptions(m, opts))
 		if !bytes.Equal(output, input) {
 			if err := ms.WritePath(inputPath, output); err != nil {
 				return err
@@ -20,11 +20,24 @@ func help(ms *xmain.State) {
 	fmt.Fprintf(ms.Stdout, `%[1]s %[2]s
 Usage:
   %[1]s [--watch=false] [--theme=0] file.d2 [file.svg | file.png]
+  %[1]s -o file.pdf file.d2 ...
+  %[1]s --watch file.d2 file.svg file.png ...
   %[1]s layout [name]
   %[1]s fmt file.d2 ...
+  %[1]s fix file.d2 ...
+  %[1]s validate [--format=text] file.d2 ...
+  %[1]s lsp
+  %[1]s plugin list | install file | remove name
+  %[1]s playwright install | status | clean | use path
+  %[1]s highlight [--format=ansi] file.d2
+  %[1]s daemon [stop]
 
 %[1]s compiles and renders file.d2 to file.svg | file.png
 It defaults to file.svg if an output path is not provided.
+With -o, every argument is an input, and several are combined into one PDF.
+With --watch, every argument after file.d2 is an output, all exported from each compilation.
+file.html outputs are pages that walk through the boards as they are scrolled.
+file.json outputs are the laid out diagram, as read by d2target.Unmarshal.
 
 Use - to have d2 read from stdin or write to stdout.
 
@@ -38,6 +51,19 @@ Subcommands:
   %[1]s layout [name] - Display long help for a particular layout engine, including its configuration options
   %[1]s themes - Lists available themes
   %[1]s fmt file.d2 ... - Format passed files
+  %[1]s fix file.d2 ... - Apply the suggested fix of every error in passed files that has one
+  %[1]s validate [--format=text|json] file.d2 ... - Compile passed files without rendering them and print their errors with their positions
+  %[1]s lsp - Run a language server over stdin and stdout for editor integrations
+  %[1]s plugin list - Lists available plugins and where they were found
+  %[1]s plugin install file - Install the plugin binary or WASM module file to the plugins directory of the user configuration
+  %[1]s plugin remove name - Remove the installed plugin name
+  %[1]s playwright install - Download Playwright and Chromium, which rasterize PNG, GIF, PDF and PPTX exports, or only Playwright with a browser set with playwright use
+  %[1]s playwright status - Print where Playwright and its browser are installed
+  %[1]s playwright clean - Remove the downloads of Playwright and Chromium
+  %[1]s playwright use path | default - Rasterize with the installed Chromium, Chrome or Edge at path instead of downloading Chromium, or download it again with default
+  %[1]s highlight [--format=ansi|html] file.d2 - Print file.d2 syntax highlighted for a terminal or HTML page
+  %[1]s daemon - Serve the renders of invocations with --daemon, which start it in the background on first use
+  %[1]s daemon stop - Stop the daemon
 
 See more docs and the source code at https://oss.terrastruct.com/d2.
 Hosted icons at https://icons.terrastruct.com.
@@ -108,8 +134,11 @@ func longLayoutHelp(ctx context.Context, ms *xmain.State, ps []d2plugin.Plugin)
 	}
 
 	plocation := pinfo.Type
-	if pinfo.Type == "binary" {
+	switch pinfo.Type {
+	case "binary":
 		plocation = fmt.Sprintf("executable plugin at %s", humanPath(pinfo.Path))
+	case "wasm":
+		plocation = fmt.Sprintf("WASM plugin at %s", humanPath(pinfo.Path))
 	}
 
 	if !strings.HasSuffix(pinfo.LongHelp, "\n") {
@@ -0,0 +1,37 @@
+package d2cli
+
+import (
+	"context"
+
+	"oss.terrastruct.com/util-go/xdefer"
+
+	"oss.terrastruct.com/util-go/xmain"
+
+	"oss.terrastruct.com/d2/d2highlight"
+)
+
+func highlightCmd(ctx context.Context, ms *xmain.State, format string) (err error) {
+	defer xdefer.Errorf(&err, "failed to highlight")
+
+	args := ms.Opts.Flags.Args()[1:]
+	if len(args) != 1 {
+		return xmain.UsageErrorf("highlight must be passed exactly one file, or - for stdin")
+	}
+	inputPath := args[0]
+	if inputPath != "-" {
+		inputPath = ms.AbsPath(inputPath)
+	}
+	input, err := ms.ReadPath(inputPath)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "", "ansi":
+		return d2highlight.ANSI(ms.Stdout, string(input))
+	case "html":
+		return d2highlight.HTML(ms.Stdout, string(input))
+	default:
+		return xmain.UsageErrorf("highlight --format must be html or ansi, got %q", format)
+	}
+}
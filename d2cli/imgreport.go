@@ -0,0 +1,40 @@
+package d2cli
+
+import (
+	"context"
+	"encoding/json"
+
+	"oss.terrastruct.com/util-go/xmain"
+
+	"oss.terrastruct.com/d2/lib/imgbundler"
+)
+
+type imageReportContextKey struct{}
+
+// imageReport is the report of the images bundled by compilations, for --img-report.
+type imageReport struct {
+	path   string
+	report imgbundler.Report
+}
+
+func withImageReport(ctx context.Context, r *imageReport) context.Context {
+	ctx = imgbundler.WithReport(ctx, &r.report)
+	return context.WithValue(ctx, imageReportContextKey{}, r)
+}
+
+// imageReportFromContext returns the image report of ctx, nil if --img-report wasn't passed.
+func imageReportFromContext(ctx context.Context) *imageReport {
+	r, _ := ctx.Value(imageReportContextKey{}).(*imageReport)
+	return r
+}
+
+// write writes the images bundled so far to the path of r as JSON.
+func (r *imageReport) write(ms *xmain.State) error {
+	b, err := json.MarshalIndent(struct {
+		Images []imgbundler.ReportImage `json:"images"`
+	}{r.report.Images()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ms.WritePath(r.path, append(b, '\n'))
+}
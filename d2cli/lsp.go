@@ -0,0 +1,16 @@
+package d2cli
+
+import (
+	"context"
+
+	"oss.terrastruct.com/util-go/xmain"
+
+	"oss.terrastruct.com/d2/d2lsp"
+)
+
+func lspCmd(ctx context.Context, ms *xmain.State) error {
+	if len(ms.Opts.Flags.Args()) > 1 {
+		return xmain.UsageErrorf("lsp subcommand accepts no arguments, it communicates over stdin and stdout")
+	}
+	return d2lsp.Serve(ctx, ms.Stdin, ms.Stdout)
+}
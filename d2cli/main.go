@@ -2,6 +2,7 @@ package d2cli
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,11 +12,14 @@ import (
 	"os/exec"
 	"os/user"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/playwright-community/playwright-go"
 	"github.com/spf13/pflag"
 	"go.uber.org/multierr"
 
@@ -23,18 +27,23 @@ import (
 	"oss.terrastruct.com/util-go/xmain"
 
 	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2export"
 	"oss.terrastruct.com/d2/d2graph"
 	"oss.terrastruct.com/d2/d2lib"
 	"oss.terrastruct.com/d2/d2parser"
 	"oss.terrastruct.com/d2/d2plugin"
 	"oss.terrastruct.com/d2/d2renderers/d2animate"
 	"oss.terrastruct.com/d2/d2renderers/d2fonts"
+	"oss.terrastruct.com/d2/d2renderers/d2scroll"
+	"oss.terrastruct.com/d2/d2renderers/d2singlefile"
 	"oss.terrastruct.com/d2/d2renderers/d2svg"
 	"oss.terrastruct.com/d2/d2renderers/d2svg/appendix"
 	"oss.terrastruct.com/d2/d2target"
 	"oss.terrastruct.com/d2/d2themes"
 	"oss.terrastruct.com/d2/d2themes/d2themescatalog"
 	"oss.terrastruct.com/d2/lib/background"
+	"oss.terrastruct.com/d2/lib/color"
+	"oss.terrastruct.com/d2/lib/geo"
 	"oss.terrastruct.com/d2/lib/imgbundler"
 	ctxlog "oss.terrastruct.com/d2/lib/log"
 	"oss.terrastruct.com/d2/lib/pdf"
@@ -59,6 +68,7 @@ func Run(ctx context.Context, ms *xmain.State) (err error) {
 	if err != nil {
 		return err
 	}
+	outputFlag := ms.Opts.String("", "output", "o", "", "path to write the output to, with which every argument is an input. Several inputs are combined into one PDF with a section per input")
 	hostFlag := ms.Opts.String("HOST", "host", "h", "localhost", "host listening address when used with watch")
 	portFlag := ms.Opts.String("PORT", "port", "p", "0", "port listening address when used with watch")
 	bundleFlag, err := ms.Opts.Bool("D2_BUNDLE", "bundle", "b", true, "when outputting SVG, bundle all assets and layers into the output file")
@@ -74,15 +84,56 @@ func Run(ctx context.Context, ms *xmain.State) (err error) {
 		ms.Log.Warn.Printf("Invalid DEBUG flag value ignored")
 		debugFlag = go2.Pointer(false)
 	}
-	imgCacheFlag, err := ms.Opts.Bool("IMG_CACHE", "img-cache", "", true, "in watch mode, images used in icons are cached for subsequent compilations. This should be disabled if images might change.")
+	imgCacheFlag, err := ms.Opts.Bool("IMG_CACHE", "img-cache", "", true, "in watch mode, remote images used in icons are cached for subsequent compilations. This should be disabled if they might change. Local images are always read again.")
 	if err != nil {
 		return err
 	}
+	imgConcurrencyFlag, err := ms.Opts.Int64("D2_IMG_CONCURRENCY", "img-concurrency", "", 16, "the maximum number of remote images fetched at once, across all boards.")
+	if err != nil {
+		return err
+	}
+	imgTimeoutFlag, err := ms.Opts.Int64("D2_IMG_TIMEOUT", "img-timeout", "", 60, "the maximum number of seconds a request for a remote image may take.")
+	if err != nil {
+		return err
+	}
+	imgRetriesFlag, err := ms.Opts.Int64("D2_IMG_RETRIES", "img-retries", "", 0, "the number of times a request for a remote image is retried after a network error or a 429 or 5xx response.")
+	if err != nil {
+		return err
+	}
+	imgRetryBackoffFlag, err := ms.Opts.Int64("D2_IMG_RETRY_BACKOFF", "img-retry-backoff", "", 500, "the number of milliseconds to wait before the first retry of a remote image, doubled on every following retry.")
+	if err != nil {
+		return err
+	}
+	offlineFlag, err := ms.Opts.Bool("D2_OFFLINE", "offline", "", false, "bundle remote images and resolve plugin imports only from $D2_CACHE_DIR, where they are cached when fetched, failing on those never fetched.")
+	if err != nil {
+		return err
+	}
+	noSanitizeFlag, err := ms.Opts.Bool("D2_NO_SANITIZE", "no-sanitize", "", false, "bundle SVG images as they are instead of removing their scripts, event handlers and references to external resources, which are only safe in trusted images.")
+	if err != nil {
+		return err
+	}
+	imgWarnSizeFlag, err := ms.Opts.Int64("D2_IMG_WARN_SIZE", "img-warn-size", "", 0, "the size in kilobytes of bundled images above which they are warned about, after downscaling with --img-max-dimension. 0 never warns.")
+	if err != nil {
+		return err
+	}
+	imgMaxSizeFlag, err := ms.Opts.Int64("D2_IMG_MAX_SIZE", "img-max-size", "", 0, "the size in kilobytes of bundled images above which bundling them fails, after downscaling with --img-max-dimension. 0 bundles images of any size.")
+	if err != nil {
+		return err
+	}
+	imgMaxDimensionFlag, err := ms.Opts.Int64("D2_IMG_MAX_DIMENSION", "img-max-dimension", "", 0, "the width and height in pixels that bundled PNG and JPEG images larger than it are downscaled to fit within, keeping their aspect ratio. 0 bundles them as they are.")
+	if err != nil {
+		return err
+	}
+	imgRootFlag := ms.Opts.String("D2_IMG_ROOT", "img-root", "", "", "directory to resolve the relative paths of local images against, instead of the directory of the input file. Those of imported files stay relative to where they're imported from.")
+	imgReportFlag := ms.Opts.String("D2_IMG_REPORT", "img-report", "", "", "path to write a JSON report to of which images were bundled, skipped or failed to be bundled and why. Images that fail to be bundled are drawn as a placeholder showing their URL.")
 	layoutFlag := ms.Opts.String("D2_LAYOUT", "layout", "l", "dagre", `the layout engine used`)
+	routerFlag := ms.Opts.String("D2_ROUTER", "router", "", "", `plugin that routes connections after the layout engine places shapes, e.g. an orthogonal router used with dagre`)
+	mutatorFlag := ms.Opts.String("D2_MUTATOR", "mutator", "", "", `plugin that modifies every board before the layout engine places shapes, e.g. to add a legend`)
 	themeFlag, err := ms.Opts.Int64("D2_THEME", "theme", "t", 0, "the diagram theme ID")
 	if err != nil {
 		return err
 	}
+	themeFileFlag := ms.Opts.String("D2_THEME_FILE", "theme-file", "", "", "path to a .json or .d2 file defining a theme with an id, a name and all of its colors, which is added to the catalog of themes and used unless -t[heme] sets another. Dark themes have IDs from 200 to 299.")
 	darkThemeFlag, err := ms.Opts.Int64("D2_DARK_THEME", "dark-theme", "", -1, "the theme to use when the viewer's browser is in dark mode. When left unset -theme is used for both light and dark mode. Be aware that explicit styles set in D2 code will still be applied and this may produce unexpected results. We plan on resolving this by making style maps in D2 light/dark mode specific. See https://github.com/terrastruct/d2/issues/831.")
 	if err != nil {
 		return err
@@ -95,10 +146,100 @@ func Run(ctx context.Context, ms *xmain.State) (err error) {
 	if err != nil {
 		return err
 	}
+	_, err = ms.Opts.Int64("D2_ANIMATE_TRANSITION_DURATION", "animate-transition-duration", "", 0, "the time in milliseconds each board of --animate-interval SVGs takes to fade in over the previous one, unless the transition keyword of the board sets it.")
+	if err != nil {
+		return err
+	}
+	_ = ms.Opts.String("D2_ANIMATE_EASING", "animate-easing", "", "", "the CSS easing function of the fades of --animate-interval SVGs, e.g. ease-in-out or cubic-bezier(0.4, 0, 0.2, 1), unless the transition keyword of the board sets it.")
+	_, err = ms.Opts.Bool("D2_SINGLE_FILE", "single-file", "", false, "compose all the boards of SVG exports into 1 SVG, in which links to boards navigate within it, instead of a folder with a SVG per board. Multi-board diagrams can only be written to stdout with it.")
+	if err != nil {
+		return err
+	}
+	animateControlsFlag, err := ms.Opts.Bool("D2_ANIMATE_CONTROLS", "animate-controls", "", false, "add play/pause, step back and forward buttons and a scrubber below --animate-interval SVGs, to step through the boards. The controls need scripts, so they are hidden where SVGs are shown as images.")
+	if err != nil {
+		return err
+	}
+	_, err = ms.Opts.Bool("D2_ANIMATE_MORPH", "animate-morph", "", false, "move and scale the shapes in consecutive boards of --animate-interval SVGs from their place in one board to their place in the next, instead of only fading between the boards. Boards fade over 500 milliseconds unless --animate-transition-duration or their transition keyword set it.")
+	if err != nil {
+		return err
+	}
+	_, err = ms.Opts.Int64("D2_ANIMATE_LOOPS", "animate-loops", "", 0, "the number of times --animate-interval SVGs play before stopping on their last board, 1 to play once. 0 loops forever.")
+	if err != nil {
+		return err
+	}
+	_ = ms.Opts.String("D2_ANIMATE_DIRECTION", "animate-direction", "", "normal", "the direction --animate-interval SVGs play in: normal to cut back to the first board after the last, or alternate to play every other loop backwards.")
+	_, err = ms.Opts.Int64("D2_ANIMATE_DELAY", "animate-delay", "", 0, "the time in milliseconds the first board of --animate-interval SVGs is shown before they start playing.")
+	if err != nil {
+		return err
+	}
+	_ = ms.Opts.String("D2_ANIMATE_AUTOPLAY", "animate-autoplay", "", "always", "when --animate-interval SVGs start playing: always as soon as they load, or visible once they are first scrolled into view. visible needs scripts, so SVGs shown as images play right away.")
+	_ = ms.Opts.String("D2_ANIMATE_FRAMES", "animate-frames", "", "", "if given, every board of --animate-interval animations is also exported to its own PNG or SVG file, at the path with the number of the frame, starting at 1, formatted in, e.g. out/frame-%02d.png.")
 	timeoutFlag, err := ms.Opts.Int64("D2_TIMEOUT", "timeout", "", 120, "the maximum number of seconds that D2 runs for before timing out and exiting. When rendering a large diagram, it is recommended to increase this value")
 	if err != nil {
 		return err
 	}
+	profileFlag := ms.Opts.String("", "profile", "", "", "write profiles of the run for performance issues: a comma separated list of cpu, mem and trace, each optionally followed by =file, e.g. cpu=out.pprof.")
+	_, err = ms.Opts.Bool("", "timings", "", false, "print how long parsing and the measuring, layout, export, render and rasterization of every board took.")
+	if err != nil {
+		return err
+	}
+	_, err = ms.Opts.Bool("D2_INCREMENTAL", "incremental", "", false, "only rasterize the boards of PNG, PDF, PPTX and GIF exports that changed since the last export to the same output, reusing the PNGs of the others from $D2_CACHE_DIR.")
+	if err != nil {
+		return err
+	}
+	pdfRasterFlag, err := ms.Opts.Bool("D2_PDF_RASTER", "pdf-raster", "", false, "draw the boards of PDF exports as PNG screenshots, as boards with markdown, LaTeX or SVG icons always are, rather than from their shapes and connections.")
+	if err != nil {
+		return err
+	}
+	pptxRasterFlag, err := ms.Opts.Bool("D2_PPTX_RASTER", "pptx-raster", "", false, "draw the slides of PPTX exports as PNG screenshots, as boards with markdown, LaTeX, code, classes, SQL tables or images always are, rather than as shapes, connectors and text boxes that can be edited in PowerPoint.")
+	if err != nil {
+		return err
+	}
+	pptxEditableFlag, err := ms.Opts.Bool("D2_PPTX_EDITABLE", "pptx-editable", "", true, "draw the slides of PPTX exports as shapes, connectors and text boxes that can be edited in PowerPoint, as they are by default. --pptx-editable=false is the same as --pptx-raster.")
+	if err != nil {
+		return err
+	}
+	_, err = ms.Opts.Bool("D2_PPTX_TOOLTIP_CALLOUTS", "pptx-tooltip-callouts", "", false, "draw the tooltips of shapes as callouts next to them on the slides of PPTX exports, as they cannot be hovered in slides. They are in the speaker notes regardless.")
+	if err != nil {
+		return err
+	}
+	_, err = ms.Opts.Bool("D2_PPTX_TITLE_SLIDES", "pptx-title-slides", "", false, "open PPTX exports of diagrams with several boards with a title slide of the title of the diagram, the date and the version of D2, and divide them into sections with a slide before each top-level layer.")
+	if err != nil {
+		return err
+	}
+	pptxTemplateFlag := ms.Opts.String("D2_PPTX_TEMPLATE", "pptx-template", "", "", "path to a .pptx or .potx whose slide masters, layouts, theme, fonts and logos PPTX exports are made from. The slides are laid on its blank layout and take its slide size.")
+	_ = ms.Opts.String("D2_PPTX_SLIDE_SIZE", "pptx-slide-size", "", "auto", "the size of the slides of PPTX exports: 16:9, 4:3, a size in inches such as 13.33x7.5, or auto for the size of the --pptx-template or else 16:9.")
+	_ = ms.Opts.String("D2_PPTX_SCALING", "pptx-scaling", "", "fit", "how boards are scaled onto the slides of PPTX exports: fit to fit them within slides, fill to fill slides with them, cutting off what overflows, or actual to draw them at their actual size.")
+	_ = ms.Opts.String("D2_PPTX_STEPS", "pptx-steps", "", "slides", "how steps boards are exported to PPTX: slides for a slide per step, or animate for a single slide per steps board revealing each step on a click like --animate-interval. Steps that cannot be drawn as native PowerPoint shapes are exported as slides.")
+	_ = ms.Opts.String("D2_PDF_PAGE_SIZE", "pdf-page-size", "", "auto", "the size of the pages of PDF exports: A4, Letter, or auto to size each page to its board.")
+	_ = ms.Opts.String("D2_PDF_ORIENTATION", "pdf-orientation", "", "auto", "the orientation of A4 and Letter pages of PDF exports: portrait, landscape, or auto to follow the orientation of each board.")
+	_, err = ms.Opts.Int64("D2_PDF_MARGIN", "pdf-margin", "", 36, "the margin around boards on A4 and Letter pages of PDF exports, in points.")
+	if err != nil {
+		return err
+	}
+	_, err = ms.Opts.Bool("D2_PDF_TILE", "pdf-tile", "", false, "tile boards too large for A4 and Letter pages of PDF exports across pages at full size rather than shrinking them to fit.")
+	if err != nil {
+		return err
+	}
+	_, err = ms.Opts.Bool("D2_PDF_TOC", "pdf-toc", "", false, "start PDF exports with a table of contents linking to every board.")
+	if err != nil {
+		return err
+	}
+	ms.Opts.String("D2_PDF_HEADER", "pdf-header", "", "", `stamped at the top of every page of PDF exports: up to three parts separated by | aligned left, center and right, in which {board}, {title}, {date}, {page} and {pages} are filled in, e.g. "{title}|{board}|{date}". Overrides vars.d2-config.pdf-header.`)
+	ms.Opts.String("D2_PDF_FOOTER", "pdf-footer", "", "", `stamped at the bottom of every page of PDF exports, like --pdf-header, e.g. "Page {page} of {pages}". Overrides vars.d2-config.pdf-footer.`)
+	ms.Opts.String("D2_PDF_TITLE", "pdf-title", "", "", "the title in the metadata of PDF exports.")
+	ms.Opts.String("D2_PDF_AUTHOR", "pdf-author", "", "", "the author in the metadata of PDF exports.")
+	ms.Opts.String("D2_PDF_SUBJECT", "pdf-subject", "", "", "the subject in the metadata of PDF exports.")
+	ms.Opts.String("D2_PDF_KEYWORDS", "pdf-keywords", "", "", "the keywords in the metadata of PDF exports.")
+	_, err = ms.Opts.Bool("D2_PDF_XMP", "pdf-xmp", "", false, "embed the metadata of PDF exports as an XMP packet too.")
+	if err != nil {
+		return err
+	}
+	ms.Opts.String("D2_PDF_USER_PASSWORD", "pdf-user-password", "", "", "encrypt PDF exports with the password needed to open them. Prefer setting $D2_PDF_USER_PASSWORD to keep it out of the shell history.")
+	ms.Opts.String("D2_PDF_OWNER_PASSWORD", "pdf-owner-password", "", "", "encrypt PDF exports with the password giving full access to them. Prefer setting $D2_PDF_OWNER_PASSWORD to keep it out of the shell history.")
+	ms.Opts.String("D2_PDF_PERMISSIONS", "pdf-permissions", "", "print", "what encrypted PDF exports can be opened for without the owner password, a comma separated list of print, modify, copy and annotate, or none.")
+	ms.Opts.String("D2_PDF_STANDARD", "pdf-standard", "", "", "the standard PDF exports conform to: pdfa-2b for PDF/A-2b archival PDFs.")
+	layoutBudgetFlag := ms.Opts.String("D2_LAYOUT_BUDGET", "layout-budget", "", "", "the time the layout of each board may take, e.g. 10s, before dagre and ELK fall back to a cheaper configuration with a warning instead of running into the timeout.")
 
 	versionFlag, err := ms.Opts.Bool("", "version", "v", false, "get the version")
 	if err != nil {
@@ -113,10 +254,55 @@ func Run(ctx context.Context, ms *xmain.State) (err error) {
 	if err != nil {
 		return err
 	}
+	_, err = ms.Opts.Bool("D2_SEQ_NUMBERS", "seq-numbers", "", false, "prefix the label of every message of sequence diagrams with its number in its diagram. Overrides vars.d2-config.sequence-numbering.")
+	if err != nil {
+		return err
+	}
 	scaleFlag, err := ms.Opts.Float64("SCALE", "scale", "", -1, "scale the output. E.g., 0.5 to halve the default size. Default -1 means that SVG's will fit to screen and all others will use their default render size. Setting to 1 turns off SVG fitting to screen.")
 	if err != nil {
 		return err
 	}
+	_, err = ms.Opts.Float64("D2_PNG_SCALE", "png-scale", "", png.SCALE, "the number of pixels of PNG exports per pixel of the SVG, e.g. 4 for exports twice as sharp as the default. The resolution of the PNG is set to match, 96 DPI per pixel. It also sets the sharpness of GIF frames and of the screenshots of raster PDF and PPTX exports, e.g. for print.")
+	if err != nil {
+		return err
+	}
+	_, err = ms.Opts.Int64("D2_WIDTH", "width", "", 0, "the width of PNG exports and GIF frames in pixels, overriding --png-scale. With --height, the diagram is fit and centered within them, else the height follows its aspect ratio.")
+	if err != nil {
+		return err
+	}
+	_, err = ms.Opts.Int64("D2_HEIGHT", "height", "", 0, "the height of PNG exports and GIF frames in pixels, overriding --png-scale. With --width, the diagram is fit and centered within them, else the width follows its aspect ratio.")
+	if err != nil {
+		return err
+	}
+	_ = ms.Opts.String("D2_BACKGROUND", "background", "", "theme", "the background of PNG exports: theme for the background of the theme, transparent, or a named color or hex code such as white or #f0ff3a. It overrides the fill of the root of the diagram.")
+	_, err = ms.Opts.Int64("D2_PNG_TILE_SIZE", "png-tile-size", "", 0, "split PNG exports into a grid of square tiles of this many pixels, written with a manifest and an index.html viewing them with Leaflet to a directory named after the output, for boards too large for one PNG. 0 exports single PNGs.")
+	if err != nil {
+		return err
+	}
+	rasterEngineFlag := ms.Opts.String("D2_RASTER_ENGINE", "raster-engine", "", "auto", "how boards of PNG, GIF and raster PDF and PPTX exports are rasterized: auto draws them without a browser unless they have markdown, LaTeX, code, classes, SQL tables, images, icons, tooltips or links, native always does, approximating those, for environments that cannot install Chromium, and browser always uses a headless browser.")
+	_, err = ms.Opts.Bool("D2_PNG_METADATA", "png-metadata", "", true, "embed metadata in PNG exports: the version of D2, a SHA-256 hash of the source, and the title, author and fields set by the flags below. false writes byte-reproducible PNGs across versions.")
+	if err != nil {
+		return err
+	}
+	_ = ms.Opts.String("D2_PNG_TITLE", "png-title", "", "", "the title embedded in PNG exports, the label of the diagram if unset.")
+	_ = ms.Opts.String("D2_PNG_AUTHOR", "png-author", "", "", "the author embedded in PNG exports.")
+	_ = ms.Opts.String("D2_PNG_METADATA_FIELDS", "png-metadata-fields", "", "", "custom key-values embedded in PNG exports, such as team=infra,env=prod.")
+	_ = ms.Opts.String("D2_CROP", "crop", "", "", "export only a region of boards to SVG or PNG, to zoom in on part of a diagram: the absolute ID of a shape such as container.id, or the coordinates of a box as x,y,width,height. The region is padded with --pad.")
+	_, err = ms.Opts.Int64("", "fmt-indent-width", "", 2, "fmt: number of spaces per level of indentation. Overrides vars.d2-config.fmt.indent-width.")
+	if err != nil {
+		return err
+	}
+	_, err = ms.Opts.Bool("", "fmt-tabs", "", false, "fmt: indent with tabs instead of spaces. Overrides vars.d2-config.fmt.tabs.")
+	if err != nil {
+		return err
+	}
+	_ = ms.Opts.String("", "fmt-quotes", "", "preserve", "fmt: rewrite quoted strings with double or single quotes where possible: preserve, double or single. Overrides vars.d2-config.fmt.quotes.")
+	_ = ms.Opts.String("", "fmt-braces", "", "preserve", "fmt: keep maps written on one line on one line, or expand every map: preserve or expand. Overrides vars.d2-config.fmt.braces.")
+	_, err = ms.Opts.Bool("", "fmt-sort-keys", "", false, "fmt: sort keys within runs of keys not separated by blank lines. Overrides vars.d2-config.fmt.sort-keys.")
+	if err != nil {
+		return err
+	}
+	formatFlag := ms.Opts.String("", "format", "", "", "output format of the highlight subcommand, ansi or html, defaulting to ansi, and of the validate subcommand, text or json, defaulting to text.")
 	targetFlag := ms.Opts.String("", "target", "", "*", "target board to render. Pass an empty string to target root board. If target ends with '*', it will be rendered with all of its scenarios, steps, and layers. Otherwise, only the target board will be rendered. E.g. --target='' to render root board only or --target='layers.x.*' to render layer 'x' with all of its children.")
 
 	fontRegularFlag := ms.Opts.String("D2_FONT_REGULAR", "font-regular", "", "", "path to .ttf file to use for the regular font. If none provided, Source Sans Pro Regular is used.")
@@ -124,7 +310,25 @@ func Run(ctx context.Context, ms *xmain.State) (err error) {
 	fontBoldFlag := ms.Opts.String("D2_FONT_BOLD", "font-bold", "", "", "path to .ttf file to use for the bold font. If none provided, Source Sans Pro Bold is used.")
 	fontSemiboldFlag := ms.Opts.String("D2_FONT_SEMIBOLD", "font-semibold", "", "", "path to .ttf file to use for the semibold font. If none provided, Source Sans Pro Semibold is used.")
 
-	plugins, err := d2plugin.ListPlugins(ctx)
+	pluginPathFlag := ms.Opts.String("D2_PLUGIN_PATH", "plugin-path", "", "", "list of plugin binaries, WASM modules or directories of them to search before $PATH, separated like $PATH.")
+	daemonFlag, err := ms.Opts.Bool("D2_DAEMON", "daemon", "", false, "render in a background daemon, started on first use, that keeps the browser of PNG, PDF, PPTX and GIF exports and text measurements warm across invocations.")
+	if err != nil {
+		return err
+	}
+	pluginSandboxFlag, err := ms.Opts.Bool("D2_PLUGIN_SANDBOX", "plugin-sandbox", "", false, "run binary plugins with a minimal environment, in an empty temporary directory and without network access. Fails where the OS cannot isolate plugins from the network, see --plugin-sandbox-network.")
+	if err != nil {
+		return err
+	}
+	pluginSandboxNetworkFlag, err := ms.Opts.Bool("D2_PLUGIN_SANDBOX_NETWORK", "plugin-sandbox-network", "", false, "run sandboxed plugins with network access, with a warning, where the OS cannot isolate them from the network instead of failing.")
+	if err != nil {
+		return err
+	}
+
+	listOpts, err := pluginListOptions(ms, stringFlagArg(ms.Opts.Args, "plugin-path", *pluginPathFlag), boolFlagArg(ms.Opts.Args, "plugin-sandbox", *pluginSandboxFlag), boolFlagArg(ms.Opts.Args, "plugin-sandbox-network", *pluginSandboxNetworkFlag))
+	if err != nil {
+		return err
+	}
+	plugins, err := d2plugin.ListPluginsWithOptions(ctx, listOpts)
 	if err != nil {
 		return err
 	}
@@ -132,6 +336,11 @@ func Run(ctx context.Context, ms *xmain.State) (err error) {
 	if err != nil {
 		return err
 	}
+	iconProviders, err := d2plugin.IconProviders(ctx, plugins)
+	if err != nil {
+		return err
+	}
+	ctx = imgbundler.WithIconProviders(ctx, iconProviders)
 
 	err = ms.Opts.Flags.Parse(ms.Opts.Args)
 	if !errors.Is(err, pflag.ErrHelp) && err != nil {
@@ -147,11 +356,20 @@ func Run(ctx context.Context, ms *xmain.State) (err error) {
 	if err != nil {
 		return xmain.UsageErrorf("failed to load specified fonts: %v", err)
 	}
+	if *pptxTemplateFlag != "" {
+		t, err := loadPPTXTemplate(ms, *pptxTemplateFlag)
+		if err != nil {
+			return xmain.UsageErrorf("failed to load PPTX template: %v", err)
+		}
+		ctx = withPPTXTemplate(ctx, t)
+	}
 
 	if len(ms.Opts.Flags.Args()) > 0 {
 		switch ms.Opts.Flags.Arg(0) {
+		case "playwright":
+			return playwrightCmd(ms)
 		case "init-playwright":
-			return initPlaywright()
+			return playwrightInstall(ms)
 		case "layout":
 			return layoutCmd(ctx, ms, plugins)
 		case "themes":
@@ -159,6 +377,18 @@ func Run(ctx context.Context, ms *xmain.State) (err error) {
 			return nil
 		case "fmt":
 			return fmtCmd(ctx, ms)
+		case "fix":
+			return fixCmd(ctx, ms)
+		case "validate":
+			return validateCmd(ctx, ms, plugins, *formatFlag)
+		case "lsp":
+			return lspCmd(ctx, ms)
+		case "plugin":
+			return pluginCmd(ctx, ms, plugins)
+		case "highlight":
+			return highlightCmd(ctx, ms, *formatFlag)
+		case "daemon":
+			return daemonCmd(ctx, ms)
 		case "version":
 			if len(ms.Opts.Flags.Args()) > 1 {
 				return xmain.UsageErrorf("version subcommand accepts no arguments")
@@ -180,9 +410,81 @@ func Run(ctx context.Context, ms *xmain.State) (err error) {
 	if timeoutFlag != nil {
 		os.Setenv("D2_TIMEOUT", fmt.Sprintf("%d", *timeoutFlag))
 	}
+	if !*pptxEditableFlag {
+		*pptxRasterFlag = true
+	} else if *pptxRasterFlag && (ms.Opts.Flags.Changed("pptx-editable") || ms.Env.Getenv("D2_PPTX_EDITABLE") != "") {
+		return xmain.UsageErrorf("--pptx-editable cannot be combined with --pptx-raster")
+	}
+	if *layoutBudgetFlag != "" {
+		if _, err := parseLayoutBudget(*layoutBudgetFlag); err != nil {
+			return xmain.UsageErrorf("invalid --layout-budget: %v", err)
+		}
+	}
+	if *imgConcurrencyFlag <= 0 {
+		return xmain.UsageErrorf("--img-concurrency must be positive")
+	}
+	if *imgTimeoutFlag <= 0 {
+		return xmain.UsageErrorf("--img-timeout must be positive")
+	}
+	if *imgRetriesFlag < 0 {
+		return xmain.UsageErrorf("--img-retries must not be negative")
+	}
+	if *imgRetryBackoffFlag <= 0 {
+		return xmain.UsageErrorf("--img-retry-backoff must be positive")
+	}
+	if *imgWarnSizeFlag < 0 {
+		return xmain.UsageErrorf("--img-warn-size must not be negative")
+	}
+	if *imgMaxSizeFlag < 0 {
+		return xmain.UsageErrorf("--img-max-size must not be negative")
+	}
+	if *imgMaxDimensionFlag < 0 {
+		return xmain.UsageErrorf("--img-max-dimension must not be negative")
+	}
+	var imgCacheDir string
+	if dir, err := cacheDir(ms); err != nil {
+		if *offlineFlag {
+			return fmt.Errorf("--offline needs a cache directory: %w", err)
+		}
+		ms.Log.Warn.Printf("remote images and imports are not cached: %v", err)
+	} else {
+		imgCacheDir = filepath.Join(dir, "images")
+		ctx = d2plugin.WithImportCache(ctx, filepath.Join(dir, "imports"), *offlineFlag)
+	}
+	cfg, err := readConfig(ms)
+	if err != nil {
+		return err
+	}
+	ctx = imgbundler.WithFetchOptions(ctx, imgbundler.FetchOptions{
+		Concurrency: int(*imgConcurrencyFlag),
+		Timeout:     time.Duration(*imgTimeoutFlag) * time.Second,
+		Retries:     int(*imgRetriesFlag),
+		Backoff:     time.Duration(*imgRetryBackoffFlag) * time.Millisecond,
+		CacheDir:    imgCacheDir,
+		Offline:     *offlineFlag,
+		Headers:     cfg.ImageHeaders,
+	})
+	if *noSanitizeFlag {
+		ctx = imgbundler.WithoutSanitizing(ctx)
+	}
+	if *imgRootFlag != "" {
+		ctx = imgbundler.WithImageRoot(ctx, ms.AbsPath(*imgRootFlag))
+	}
+	ctx = imgbundler.WithSizeLimits(ctx, imgbundler.SizeLimits{
+		WarnSize:     *imgWarnSizeFlag * 1024,
+		MaxSize:      *imgMaxSizeFlag * 1024,
+		MaxDimension: int(*imgMaxDimensionFlag),
+	})
+	if *imgReportFlag != "" {
+		ctx = withImageReport(ctx, &imageReport{path: ms.AbsPath(*imgReportFlag)})
+	}
 
 	var inputPath string
 	var outputPath string
+	// inputPaths are all inputs, which are several only with --output.
+	var inputPaths []string
+	// outputPaths are all outputs, which are several only in watch mode.
+	var outputPaths []string
 
 	if len(ms.Opts.Flags.Args()) == 0 {
 		if versionFlag != nil && *versionFlag {
@@ -191,39 +493,137 @@ func Run(ctx context.Context, ms *xmain.State) (err error) {
 		}
 		help(ms)
 		return nil
-	} else if len(ms.Opts.Flags.Args()) >= 3 {
+	} else if *outputFlag == "" && len(ms.Opts.Flags.Args()) >= 3 && !*watchFlag {
 		return xmain.UsageErrorf("too many arguments passed")
 	}
 
-	if len(ms.Opts.Flags.Args()) >= 1 {
-		inputPath = ms.Opts.Flags.Arg(0)
-	}
-	if len(ms.Opts.Flags.Args()) >= 2 {
-		outputPath = ms.Opts.Flags.Arg(1)
+	if *outputFlag != "" {
+		inputPaths = ms.Opts.Flags.Args()
+		outputPaths = []string{*outputFlag}
 	} else {
-		if inputPath == "-" {
-			outputPath = "-"
+		inputPaths = ms.Opts.Flags.Args()[:1]
+		if len(ms.Opts.Flags.Args()) >= 2 {
+			outputPaths = ms.Opts.Flags.Args()[1:]
+		} else if inputPaths[0] == "-" {
+			outputPaths = []string{"-"}
 		} else {
-			outputPath = renameExt(inputPath, ".svg")
+			outputPaths = []string{renameExt(inputPaths[0], ".svg")}
 		}
 	}
-	if inputPath != "-" {
-		inputPath = ms.AbsPath(inputPath)
-		d, err := os.Stat(inputPath)
+	outputPath = outputPaths[0]
+	for i, p := range inputPaths {
+		if p == "-" {
+			if len(inputPaths) > 1 {
+				return xmain.UsageErrorf("reading input from stdin cannot be combined with other inputs")
+			}
+			continue
+		}
+		p = ms.AbsPath(p)
+		d, err := os.Stat(p)
 		if err == nil && d.IsDir() {
-			inputPath = filepath.Join(inputPath, "index.d2")
+			p = filepath.Join(p, "index.d2")
 		}
+		inputPaths[i] = p
 	}
-	if filepath.Ext(outputPath) == ".ppt" {
-		return xmain.UsageErrorf("D2 does not support ppt exports, did you mean \"pptx\"?")
-	}
+	inputPath = inputPaths[0]
 	outputFormat := getExportExtension(outputPath)
-	if outputPath != "-" {
-		outputPath = ms.AbsPath(outputPath)
-		if *animateIntervalFlag > 0 && !outputFormat.supportsAnimation() {
-			return xmain.UsageErrorf("-animate-interval can only be used when exporting to SVG or GIF.\nYou provided: %s", filepath.Ext(outputPath))
-		} else if *animateIntervalFlag <= 0 && outputFormat.requiresAnimationInterval() {
-			return xmain.UsageErrorf("-animate-interval must be greater than 0 for %s outputs.\nYou provided: %d", outputFormat, *animateIntervalFlag)
+	if len(inputPaths) > 1 && outputFormat != PDF {
+		return xmain.UsageErrorf("several inputs can only be combined into a PDF.\nYou provided: %s", filepath.Ext(outputPath))
+	}
+	for i, p := range outputPaths {
+		if p == "-" && len(outputPaths) > 1 {
+			return xmain.UsageErrorf("writing output to stdout cannot be combined with other outputs")
+		}
+		if p != "-" {
+			outputPaths[i] = ms.AbsPath(p)
+		}
+		err = checkOutputFormat(ms, outputPaths[i], *animateIntervalFlag)
+		if err != nil {
+			return err
+		}
+	}
+	outputPath = outputPaths[0]
+	if tr, err := animateTransition(ms); err != nil {
+		return err
+	} else if tr.Duration > 0 || tr.Easing != "" {
+		if *animateIntervalFlag <= 0 {
+			return xmain.UsageErrorf("--animate-transition-duration and --animate-easing can only be used with --animate-interval")
+		}
+	}
+	if singleFile, _ := ms.Opts.Flags.GetBool("single-file"); singleFile {
+		if *animateIntervalFlag > 0 {
+			return xmain.UsageErrorf("--single-file cannot be used with --animate-interval, which already packages the boards as 1 SVG")
+		}
+	}
+	if morph, _ := ms.Opts.Flags.GetBool("animate-morph"); morph {
+		if *animateIntervalFlag <= 0 {
+			return xmain.UsageErrorf("--animate-morph can only be used with --animate-interval")
+		}
+	}
+	if playback, err := animatePlayback(ms); err != nil {
+		return err
+	} else if playback.Loops > 0 || playback.Alternate || playback.DelayMS > 0 || playback.AutoplayVisible {
+		if *animateIntervalFlag <= 0 {
+			return xmain.UsageErrorf("--animate-loops, --animate-direction, --animate-delay and --animate-autoplay can only be used with --animate-interval")
+		}
+	}
+	frames, err := animateFrames(ms)
+	if err != nil {
+		return err
+	}
+	if frames != "" && *animateIntervalFlag <= 0 {
+		return xmain.UsageErrorf("--animate-frames can only be used with --animate-interval")
+	}
+	// PNG frames are rasterized like PNG exports, whatever the format of the animation.
+	requiresPNGRenderer := filepath.Ext(frames) == string(PNG)
+	for _, p := range outputPaths {
+		requiresPNGRenderer = requiresPNGRenderer || getExportExtension(p).requiresPNGRenderer()
+	}
+	if *animateControlsFlag && *animateIntervalFlag <= 0 {
+		return xmain.UsageErrorf("--animate-controls can only be used with --animate-interval")
+	}
+
+	if _, err := pdfPageOptions(ms); err != nil {
+		return err
+	}
+	if _, err := pptxSlideOptions(ms); err != nil {
+		return err
+	}
+	if _, err := pptxAnimateSteps(ms); err != nil {
+		return err
+	}
+	if _, err := pngSize(ms); err != nil {
+		return err
+	}
+	if _, err := pngBackground(ms); err != nil {
+		return err
+	}
+	if _, err := pngTileSize(ms); err != nil {
+		return err
+	}
+	if _, err := pngMetadata(ms); err != nil {
+		return err
+	}
+	if _, err := rasterEngine(ms); err != nil {
+		return err
+	}
+	if _, _, err := cropOption(ms); err != nil {
+		return err
+	}
+	if _, _, err := pdfDocumentOptions(ms); err != nil {
+		return err
+	}
+
+	if *themeFileFlag != "" {
+		theme, err := loadThemeFile(ms, *themeFileFlag)
+		if err != nil {
+			return err
+		}
+		if !ms.Opts.Flags.Changed("theme") && ms.Env.Getenv("D2_THEME") == "" {
+			err = ms.Opts.Flags.Set("theme", strconv.FormatInt(theme.ID, 10))
+			if err != nil {
+				return err
+			}
 		}
 	}
 
@@ -280,15 +680,63 @@ func Run(ctx context.Context, ms *xmain.State) (err error) {
 		scale = scaleFlag
 	}
 
-	if !outputFormat.supportsDarkTheme() {
+	supportsDarkTheme := false
+	for _, p := range outputPaths {
+		supportsDarkTheme = supportsDarkTheme || getExportExtension(p).supportsDarkTheme()
+	}
+	if !supportsDarkTheme {
 		if darkThemeFlag != nil {
 			ms.Log.Warn.Printf("--dark-theme cannot be used while exporting to another format other than .svg")
 			darkThemeFlag = nil
 		}
 	}
+	d := daemonFromContext(ctx)
+	if *daemonFlag && d == nil && !*watchFlag && len(inputPaths) == 1 && inputPath != "-" && outputPath != "-" {
+		forwarded, err := forwardToDaemon(ctx, ms)
+		if forwarded {
+			return err
+		}
+		ms.Log.Debug.Printf("rendering without daemon: %v", err)
+	}
+
+	if *profileFlag != "" {
+		stopProfiles, err := startProfiles(ms, *profileFlag)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			stopErr := stopProfiles()
+			if err == nil {
+				err = stopErr
+			}
+		}()
+	}
+
 	var pw png.Playwright
-	if outputFormat.requiresPNGRenderer() {
-		pw, err = png.InitPlaywright()
+	var measureCache *textmeasure.Cache
+	if d != nil {
+		measureCache = d.measureCache
+		if requiresPNGRenderer && *rasterEngineFlag != "native" {
+			pw, err = d.playwright(browserPath(ms))
+			if err != nil {
+				return err
+			}
+		}
+	} else if (outputFormat == PDF && !*pdfRasterFlag || outputFormat == PPTX && !*pptxRasterFlag || *rasterEngineFlag != "browser") && !*watchFlag {
+		// Vector PDF and native PPTX exports, and boards rasterized without a browser, only
+		// start the browser for the boards that need it, see renderPDF, renderPPTX and
+		// rasterize.
+		defer func() {
+			if pw.Browser == nil {
+				return
+			}
+			cleanupErr := pw.Cleanup()
+			if err == nil {
+				err = cleanupErr
+			}
+		}()
+	} else if requiresPNGRenderer && *rasterEngineFlag != "native" {
+		pw, err = png.InitPlaywright(browserPath(ms))
 		if err != nil {
 			return err
 		}
@@ -316,19 +764,26 @@ func Run(ctx context.Context, ms *xmain.State) (err error) {
 		if *targetFlag != "*" {
 			return xmain.UsageErrorf("-w[atch] cannot be combined with --target")
 		}
+		if len(inputPaths) > 1 {
+			return xmain.UsageErrorf("-w[atch] cannot be combined with several inputs")
+		}
 		w, err := newWatcher(ctx, ms, watcherOpts{
 			plugins:         plugins,
 			layout:          layoutFlag,
+			router:          *routerFlag,
+			mutator:         *mutatorFlag,
 			renderOpts:      renderOpts,
 			animateInterval: *animateIntervalFlag,
 			host:            *hostFlag,
 			port:            *portFlag,
 			inputPath:       inputPath,
-			outputPath:      outputPath,
+			outputPaths:     outputPaths,
 			bundle:          *bundleFlag,
 			forceAppendix:   *forceAppendixFlag,
 			pw:              pw,
 			fontFamily:      fontFamily,
+			measureCache:    textmeasure.NewCache(),
+			layoutCache:     d2lib.NewLayoutCache(),
 		})
 		if err != nil {
 			return err
@@ -359,7 +814,25 @@ func Run(ctx context.Context, ms *xmain.State) (err error) {
 	ctx, cancel := timelib.WithTimeout(ctx, time.Minute*2)
 	defer cancel()
 
-	_, written, err := compile(ctx, ms, plugins, nil, layoutFlag, renderOpts, fontFamily, *animateIntervalFlag, inputPath, outputPath, boardPath, noChildren, *bundleFlag, *forceAppendixFlag, pw.Page)
+	if len(inputPaths) > 1 {
+		start := time.Now()
+		merge := &pdfMerge{}
+		mergeCtx := withPDFMerge(ctx, merge)
+		for _, inputPath := range inputPaths {
+			_, _, err := compile(mergeCtx, ms, plugins, nil, layoutFlag, *routerFlag, *mutatorFlag, renderOpts, fontFamily, *animateIntervalFlag, inputPath, []string{outputPath}, boardPath, noChildren, *bundleFlag, *forceAppendixFlag, &pw, measureCache, nil)
+			if err != nil {
+				return fmt.Errorf("failed to compile %s: %w", ms.HumanPath(inputPath), err)
+			}
+		}
+		err = merge.export(ms, outputPath)
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", ms.HumanPath(outputPath), err)
+		}
+		ms.Log.Success.Printf("successfully combined %d inputs into %s in %s", len(inputPaths), ms.HumanPath(outputPath), time.Since(start))
+		return nil
+	}
+
+	_, written, err := compile(ctx, ms, plugins, nil, layoutFlag, *routerFlag, *mutatorFlag, renderOpts, fontFamily, *animateIntervalFlag, inputPath, []string{outputPath}, boardPath, noChildren, *bundleFlag, *forceAppendixFlag, &pw, measureCache, nil)
 	if err != nil {
 		if written {
 			return fmt.Errorf("failed to fully compile (partial render written) %s: %w", ms.HumanPath(inputPath), err)
@@ -369,6 +842,22 @@ func Run(ctx context.Context, ms *xmain.State) (err error) {
 	return nil
 }
 
+// parseLayoutBudget parses a --layout-budget duration like 10s. Plain numbers are seconds
+// like --timeout.
+func parseLayoutBudget(s string) (time.Duration, error) {
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		s = fmt.Sprintf("%gs", n)
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%v is not positive", d)
+	}
+	return d, nil
+}
+
 func LayoutResolver(ctx context.Context, ms *xmain.State, plugins []d2plugin.Plugin) func(engine string) (d2graph.LayoutGraph, error) {
 	cached := make(map[string]d2graph.LayoutGraph)
 	return func(engine string) (d2graph.LayoutGraph, error) {
@@ -384,6 +873,14 @@ func LayoutResolver(ctx context.Context, ms *xmain.State, plugins []d2plugin.Plu
 			return nil, err
 		}
 
+		info, err := plugin.Info(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if d2plugin.HasFeature(info, d2plugin.ROUTES_EDGES_ONLY) {
+			return nil, xmain.UsageErrorf(`"%s" only routes connections: use it with --router along with a layout engine`, engine)
+		}
+
 		err = d2plugin.HydratePluginOpts(ctx, ms, plugin)
 		if err != nil {
 			return nil, err
@@ -413,16 +910,15 @@ func RouterResolver(ctx context.Context, ms *xmain.State, plugins []d2plugin.Plu
 		if err != nil {
 			return nil, err
 		}
-		hasRouter := false
-		for _, feat := range pluginInfo.Features {
-			if feat == d2plugin.ROUTES_EDGES {
-				hasRouter = true
-				break
-			}
-		}
-		if !hasRouter {
+		if !d2plugin.HasFeature(pluginInfo, d2plugin.ROUTES_EDGES) && !d2plugin.HasFeature(pluginInfo, d2plugin.ROUTES_EDGES_ONLY) {
 			return nil, nil
 		}
+		if d2plugin.HasFeature(pluginInfo, d2plugin.ROUTES_EDGES_ONLY) {
+			err = d2plugin.HydratePluginOpts(ctx, ms, plugin)
+			if err != nil {
+				return nil, err
+			}
+		}
 		routingPlugin, ok := plugin.(d2plugin.RoutingPlugin)
 		if !ok {
 			return nil, fmt.Errorf("plugin has routing feature but does not implement RoutingPlugin")
@@ -434,26 +930,134 @@ func RouterResolver(ctx context.Context, ms *xmain.State, plugins []d2plugin.Plu
 	}
 }
 
-func compile(ctx context.Context, ms *xmain.State, plugins []d2plugin.Plugin, fs fs.FS, layout *string, renderOpts d2svg.RenderOpts, fontFamily *d2fonts.FontFamily, animateInterval int64, inputPath, outputPath string, boardPath []string, noChildren, bundle, forceAppendix bool, page playwright.Page) (_ []byte, written bool, _ error) {
-	start := time.Now()
-	input, err := ms.ReadPath(inputPath)
-	if err != nil {
-		return nil, false, err
-	}
-
+func MutatorResolver(ctx context.Context, ms *xmain.State, plugins []d2plugin.Plugin) func(engine string) (d2graph.MutateGraph, error) {
+	cached := make(map[string]d2graph.MutateGraph)
+	return func(engine string) (d2graph.MutateGraph, error) {
+		if c, ok := cached[engine]; ok {
+			return c, nil
+		}
+
+		plugin, err := d2plugin.FindPlugin(ctx, plugins, engine)
+		if err != nil {
+			if errors.Is(err, exec.ErrNotFound) {
+				return nil, layoutNotFound(ctx, plugins, engine)
+			}
+			return nil, err
+		}
+
+		pluginInfo, err := plugin.Info(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !d2plugin.HasFeature(pluginInfo, d2plugin.MUTATES_GRAPH) {
+			return nil, nil
+		}
+		err = d2plugin.HydratePluginOpts(ctx, ms, plugin)
+		if err != nil {
+			return nil, err
+		}
+		mutatingPlugin, ok := plugin.(d2plugin.MutatingPlugin)
+		if !ok {
+			return nil, fmt.Errorf("plugin has graph mutation feature but does not implement MutatingPlugin")
+		}
+
+		mutateGraph := d2graph.MutateGraph(mutatingPlugin.MutateGraph)
+		cached[engine] = mutateGraph
+		return mutateGraph, nil
+	}
+}
+
+// boardNotFoundError is returned by compile for a board path that isn't a board of the
+// diagram.
+type boardNotFoundError []string
+
+func (e boardNotFoundError) Error() string {
+	return fmt.Sprintf(`render target "%s" not found`, strings.Join(e, "."))
+}
+
+// compile compiles inputPath once and exports it to every path of outputPaths, returning
+// the export to the first, which is an SVG for all but PDFs.
+func compile(ctx context.Context, ms *xmain.State, plugins []d2plugin.Plugin, fs fs.FS, layout *string, router, mutator string, renderOpts d2svg.RenderOpts, fontFamily *d2fonts.FontFamily, animateInterval int64, inputPath string, outputPaths []string, boardPath []string, noChildren, bundle, forceAppendix bool, pw *png.Playwright, measureCache *textmeasure.Cache, layoutCache *d2lib.LayoutCache) (_ []byte, written bool, err error) {
+	start := time.Now()
+	// Boards share the images they have in common.
+	ctx = imgbundler.WithBundleCache(ctx)
+	if r := imageReportFromContext(ctx); r != nil {
+		// Combined inputs are reported together, and recompilations only report themselves.
+		if pdfMergeFromContext(ctx) == nil {
+			r.report.Reset()
+		}
+		defer func() {
+			err = multierr.Combine(err, r.write(ms))
+		}()
+	}
+	var t *timings
+	if b, _ := ms.Opts.Flags.GetBool("timings"); b {
+		t = newTimings(start)
+		ctx = withTimings(ctx, t)
+		defer func() {
+			if err == nil {
+				ms.Log.Info.Printf("timings of %s:", ms.HumanPath(inputPath))
+				err = t.write(ms.Stderr)
+			}
+		}()
+	}
+	input, err := ms.ReadPath(inputPath)
+	if err != nil {
+		return nil, false, err
+	}
+	ctx = withSourceHash(ctx, input)
+
 	ruler, err := textmeasure.NewRuler()
 	if err != nil {
 		return nil, false, err
 	}
+	if measureCache != nil {
+		ruler.Cache = measureCache
+	}
+
+	fs, err = d2plugin.ImportFS(ctx, plugins, fs)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var layoutBudget time.Duration
+	if b, _ := ms.Opts.Flags.GetString("layout-budget"); b != "" {
+		layoutBudget, err = parseLayoutBudget(b)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	var seqNumbers *bool
+	if ms.Opts.Flags.Changed("seq-numbers") || ms.Env.Getenv("D2_SEQ_NUMBERS") != "" {
+		b, _ := ms.Opts.Flags.GetBool("seq-numbers")
+		seqNumbers = &b
+	}
 
 	opts := &d2lib.CompileOptions{
-		Ruler:          ruler,
-		FontFamily:     fontFamily,
-		InputPath:      inputPath,
-		LayoutResolver: LayoutResolver(ctx, ms, plugins),
-		Layout:         layout,
-		RouterResolver: RouterResolver(ctx, ms, plugins),
-		FS:             fs,
+		Ruler:           ruler,
+		FontFamily:      fontFamily,
+		InputPath:       inputPath,
+		LayoutResolver:  LayoutResolver(ctx, ms, plugins),
+		Layout:          layout,
+		RouterResolver:  RouterResolver(ctx, ms, plugins),
+		MutatorResolver: MutatorResolver(ctx, ms, plugins),
+		FS:              fs,
+		OnWarning: func(w d2lib.Warning) {
+			ms.Log.Warn.Print(w.String())
+		},
+		LayoutBudget:      layoutBudget,
+		LayoutCache:       layoutCache,
+		SequenceNumbering: seqNumbers,
+	}
+	if t != nil {
+		opts.OnProgress = t.progress
+	}
+	if router != "" {
+		opts.Router = &router
+	}
+	if mutator != "" {
+		opts.Mutator = &mutator
 	}
 
 	if os.Getenv("D2_LSP_MODE") == "1" {
@@ -485,34 +1089,30 @@ func compile(ctx context.Context, ms *xmain.State, plugins []d2plugin.Plugin, fs
 		return nil, false, err
 	}
 	cancel()
-
-	diagram = diagram.GetBoard(boardPath)
-	if diagram == nil {
-		return nil, false, fmt.Errorf(`render target "%s" not found`, strings.Join(boardPath, "."))
+	t.indexBoards(diagram)
+	stats := ruler.Cache.Stats()
+	ms.Log.Debug.Printf("text measurement cache: %d hits, %d misses, %d entries", stats.Hits, stats.Misses, stats.Entries)
+	if layoutCache != nil {
+		stats := layoutCache.Stats()
+		ms.Log.Debug.Printf("layout cache: %d hits, %d misses, %d entries", stats.Hits, stats.Misses, stats.Entries)
 	}
-	if noChildren {
-		diagram.Layers = nil
-		diagram.Scenarios = nil
-		diagram.Steps = nil
+
+	if diagram.GetBoard(boardPath) == nil {
+		return nil, false, boardNotFoundError(boardPath)
 	}
 
 	plugin, _ := d2plugin.FindPlugin(ctx, plugins, *opts.Layout)
 
-	if animateInterval > 0 {
-		masterID, err := diagram.HashID()
-		if err != nil {
-			return nil, false, err
-		}
-		renderOpts.MasterID = masterID
-	}
-
 	pinfo, err := plugin.Info(ctx)
 	if err != nil {
 		return nil, false, err
 	}
 	plocation := pinfo.Type
-	if pinfo.Type == "binary" {
+	switch pinfo.Type {
+	case "binary":
 		plocation = fmt.Sprintf("executable plugin at %s", humanPath(pinfo.Path))
+	case "wasm":
+		plocation = fmt.Sprintf("WASM plugin at %s", humanPath(pinfo.Path))
 	}
 	ms.Log.Debug.Printf("using layout plugin %s (%s)", *opts.Layout, plocation)
 
@@ -526,10 +1126,127 @@ func compile(ctx context.Context, ms *xmain.State, plugins []d2plugin.Plugin, fs
 		return nil, false, err
 	}
 
+	var out []byte
+	for i, outputPath := range outputPaths {
+		d := diagram
+		if i < len(outputPaths)-1 {
+			// Exports relink the boards of the diagram to their outputs.
+			d, err = copyDiagram(diagram)
+			if err != nil {
+				return out, written, err
+			}
+			t.indexBoards(d)
+		}
+		o, w, err := export(ctx, ms, start, plugins, plugin, renderOpts, ruler, pw, animateInterval, inputPath, outputPath, d, boardPath, noChildren, bundle, forceAppendix)
+		if i == 0 {
+			out, written = o, w
+		}
+		if err != nil {
+			if len(outputPaths) > 1 {
+				err = fmt.Errorf("failed to export to %s: %w", ms.HumanPath(outputPath), err)
+			}
+			return out, written, err
+		}
+	}
+	return out, written, nil
+}
+
+// copyDiagram returns a deep copy of diagram and its boards.
+func copyDiagram(diagram *d2target.Diagram) (*d2target.Diagram, error) {
+	b, err := d2target.Marshal(diagram)
+	if err != nil {
+		return nil, err
+	}
+	return d2target.Unmarshal(b)
+}
+
+// export exports the board at boardPath of diagram, the root compiled from inputPath since
+// start, to outputPath.
+func export(ctx context.Context, ms *xmain.State, start time.Time, plugins []d2plugin.Plugin, plugin d2plugin.Plugin, renderOpts d2svg.RenderOpts, ruler *textmeasure.Ruler, pw *png.Playwright, animateInterval int64, inputPath, outputPath string, diagram *d2target.Diagram, boardPath []string, noChildren, bundle, forceAppendix bool) (_ []byte, written bool, err error) {
+	t := timingsFromContext(ctx)
+	var rc *rasterCache
+	if b, _ := ms.Opts.Flags.GetBool("incremental"); b && getExportExtension(outputPath).requiresPNGRenderer() {
+		rc, err = openRasterCache(ms, outputPath)
+		if err != nil {
+			return nil, false, err
+		}
+		ctx = withRasterCache(ctx, rc)
+		defer func() {
+			if err == nil {
+				err = rc.save(ms)
+			}
+		}()
+	}
+	rc.indexBoards(diagram)
+
+	diagram = diagram.GetBoard(boardPath)
+	if noChildren {
+		diagram.Layers = nil
+		diagram.Scenarios = nil
+		diagram.Steps = nil
+	}
+	if !getExportExtension(outputPath).supportsDarkTheme() {
+		renderOpts.DarkThemeID = nil
+	}
+
+	singleFile, _ := ms.Opts.Flags.GetBool("single-file")
+	singleFile = singleFile && (len(diagram.Layers) > 0 || len(diagram.Scenarios) > 0 || len(diagram.Steps) > 0)
+	// HTML exports are scrolled through the boards composed into their page.
+	scroll := getExportExtension(outputPath) == HTML
+	if animateInterval > 0 || singleFile || scroll {
+		masterID, err := diagram.HashID()
+		if err != nil {
+			return nil, false, err
+		}
+		renderOpts.MasterID = masterID
+	}
+
+	renderer, err := outputRenderer(ctx, plugins, outputPath)
+	if err != nil {
+		return nil, false, err
+	}
+	if renderer != nil {
+		renderStart := time.Now()
+		out, err := renderer.Render(ctx, diagram)
+		t.rendered(diagram, renderStart)
+		if err != nil {
+			return nil, false, err
+		}
+		err = os.MkdirAll(filepath.Dir(outputPath), 0755)
+		if err != nil {
+			return nil, false, err
+		}
+		err = ms.WritePath(outputPath, out)
+		if err != nil {
+			return nil, false, err
+		}
+		ms.Log.Success.Printf("successfully compiled %s to %s in %s", ms.HumanPath(inputPath), ms.HumanPath(outputPath), time.Since(start))
+		// The SVG is only for the preview of watch mode.
+		svg, err := d2svg.Render(diagram, &renderOpts)
+		return svg, true, err
+	}
+
 	ext := getExportExtension(outputPath)
 	switch ext {
+	case JSON:
+		out, err := d2target.Marshal(diagram)
+		if err != nil {
+			return nil, false, err
+		}
+		err = os.MkdirAll(filepath.Dir(outputPath), 0755)
+		if err != nil {
+			return nil, false, err
+		}
+		err = ms.WritePath(outputPath, out)
+		if err != nil {
+			return nil, false, err
+		}
+		ms.Log.Success.Printf("successfully compiled %s to %s in %s", ms.HumanPath(inputPath), ms.HumanPath(outputPath), time.Since(start))
+		// The SVG is only for the preview of watch mode.
+		svg, err := d2svg.Render(diagram, &renderOpts)
+		return svg, true, err
 	case GIF:
-		svg, pngs, err := renderPNGsForGIF(ctx, ms, plugin, renderOpts, ruler, page, inputPath, diagram)
+		svg, pngs, err := renderPNGsForGIF(ctx, ms, plugin, renderOpts, ruler, pw, inputPath, diagram)
 		if err != nil {
 			return nil, false, err
 		}
@@ -547,17 +1264,25 @@ func compile(ctx context.Context, ms *xmain.State, plugins []d2plugin.Plugin, fs
 		}
 		dur := time.Since(start)
 		ms.Log.Success.Printf("successfully compiled %s to %s in %s", ms.HumanPath(inputPath), ms.HumanPath(outputPath), dur)
+		err = writeAnimationFrames(ctx, ms, plugin, renderOpts, inputPath, bundle, forceAppendix, pw, ruler, diagram)
+		if err != nil {
+			return nil, false, err
+		}
 		return svg, true, nil
 	case PDF:
-		pageMap := buildBoardIDToIndex(diagram, nil, nil)
+		pageMap := d2export.BoardIndexes(diagram)
 		path := []pdf.BoardTitle{
 			{Name: diagram.Root.Label, BoardID: "root"},
 		}
-		pdf, err := renderPDF(ctx, ms, plugin, renderOpts, inputPath, outputPath, page, ruler, diagram, nil, path, pageMap, diagram.Root.Label != "")
+		pdf, err := renderPDF(ctx, ms, plugin, renderOpts, inputPath, outputPath, pw, ruler, diagram, nil, nil, path, pageMap, diagram.Root.Label != "")
 		if err != nil {
 			return pdf, false, err
 		}
 		dur := time.Since(start)
+		if pdfMergeFromContext(ctx) != nil {
+			ms.Log.Success.Printf("successfully compiled %s in %s", ms.HumanPath(inputPath), dur)
+			return pdf, false, nil
+		}
 		ms.Log.Success.Printf("successfully compiled %s to %s in %s", ms.HumanPath(inputPath), ms.HumanPath(outputPath), dur)
 		return pdf, true, nil
 	case PPTX:
@@ -569,12 +1294,22 @@ func compile(ctx context.Context, ms *xmain.State, plugins []d2plugin.Plugin, fs
 		rootName := getFileName(outputPath)
 		// version must be only numbers to avoid issues with PowerPoint
 		p := pptx.NewPresentation(rootName, description, rootName, username, version.OnlyNumbers(), diagram.Root.Label != "")
+		if t := pptxTemplateFromContext(ctx); t != nil {
+			p.SetTemplate(t)
+		}
+		slideOpts, err := pptxSlideOptions(ms)
+		if err != nil {
+			return nil, false, err
+		}
+		p.SetSlideOptions(*slideOpts)
 
-		boardIdToIndex := buildBoardIDToIndex(diagram, nil, nil)
+		boardIdToIndex := d2export.BoardIndexes(diagram)
+		mergeAnimatedSteps(ms, renderOpts, diagram, "root", boardIdToIndex)
+		addTitleSlideIndexes(ms, diagram, boardIdToIndex)
 		path := []pptx.BoardTitle{
 			{Name: "root", BoardID: "root", LinkToSlide: boardIdToIndex["root"] + 1},
 		}
-		svg, err := renderPPTX(ctx, ms, p, plugin, renderOpts, ruler, inputPath, outputPath, page, diagram, path, boardIdToIndex)
+		svg, err := renderPPTX(ctx, ms, p, plugin, renderOpts, ruler, inputPath, outputPath, pw, diagram, nil, path, boardIdToIndex)
 		if err != nil {
 			return nil, false, err
 		}
@@ -587,7 +1322,12 @@ func compile(ctx context.Context, ms *xmain.State, plugins []d2plugin.Plugin, fs
 		return svg, true, nil
 	default:
 		compileDur := time.Since(start)
-		if animateInterval <= 0 {
+		if singleFile || scroll {
+			// Rename all the "root.layers.x" to the boards within the composed SVG or page
+			anchors := make(map[string]string)
+			resolveAnchors("root", renderOpts.MasterID, diagram, anchors)
+			relinkAnchors(diagram, anchors)
+		} else if animateInterval <= 0 {
 			// Rename all the "root.layers.x" to the paths that the boards get output to
 			linkToOutput, err := resolveLinks("root", outputPath, diagram)
 			if err != nil {
@@ -602,9 +1342,9 @@ func compile(ctx context.Context, ms *xmain.State, plugins []d2plugin.Plugin, fs
 		var boards [][]byte
 		var err error
 		if noChildren {
-			boards, err = renderSingle(ctx, ms, compileDur, plugin, renderOpts, inputPath, outputPath, bundle, forceAppendix, page, ruler, diagram)
+			boards, err = renderSingle(ctx, ms, compileDur, plugin, renderOpts, inputPath, outputPath, bundle, forceAppendix, pw, ruler, diagram)
 		} else {
-			boards, err = render(ctx, ms, compileDur, plugin, renderOpts, inputPath, outputPath, bundle, forceAppendix, page, ruler, diagram)
+			boards, err = render(ctx, ms, compileDur, plugin, renderOpts, inputPath, outputPath, bundle, forceAppendix, pw, ruler, diagram)
 		}
 		if err != nil {
 			return nil, false, err
@@ -612,11 +1352,31 @@ func compile(ctx context.Context, ms *xmain.State, plugins []d2plugin.Plugin, fs
 		var out []byte
 		if len(boards) > 0 {
 			out = boards[0]
-			if animateInterval > 0 {
-				out, err = d2animate.Wrap(diagram, boards, renderOpts, int(animateInterval))
+			if animateInterval > 0 || singleFile || scroll {
+				if scroll {
+					out, err = d2scroll.Wrap(diagram, boards, renderOpts)
+				} else if singleFile {
+					out, err = d2singlefile.Wrap(diagram, boards, renderOpts)
+				} else {
+					var transitions []d2target.Transition
+					transitions, err = boardTransitions(ms, diagram, animateInterval)
+					if err != nil {
+						return nil, false, err
+					}
+					var opts d2animate.Options
+					opts, err = animatePlayback(ms)
+					if err != nil {
+						return nil, false, err
+					}
+					opts.Transitions = transitions
+					opts.Controls, _ = ms.Opts.Flags.GetBool("animate-controls")
+					opts.Morph, _ = ms.Opts.Flags.GetBool("animate-morph")
+					out, err = d2animate.WrapWithOptions(diagram, boards, renderOpts, opts)
+				}
 				if err != nil {
 					return nil, false, err
 				}
+				out = imgbundler.Dedupe(out)
 				err = os.MkdirAll(filepath.Dir(outputPath), 0755)
 				if err != nil {
 					return nil, false, err
@@ -626,6 +1386,12 @@ func compile(ctx context.Context, ms *xmain.State, plugins []d2plugin.Plugin, fs
 					return nil, false, err
 				}
 				ms.Log.Success.Printf("successfully compiled %s to %s in %s", ms.HumanPath(inputPath), ms.HumanPath(outputPath), time.Since(start))
+				if animateInterval > 0 {
+					err = writeAnimationFrames(ctx, ms, plugin, renderOpts, inputPath, bundle, forceAppendix, pw, ruler, diagram)
+					if err != nil {
+						return nil, false, err
+					}
+				}
 			}
 		}
 		return out, true, nil
@@ -739,7 +1505,97 @@ func relink(currDiagramPath string, d *d2target.Diagram, linkToOutput map[string
 	return nil
 }
 
-func render(ctx context.Context, ms *xmain.State, compileDur time.Duration, plugin d2plugin.Plugin, opts d2svg.RenderOpts, inputPath, outputPath string, bundle, forceAppendix bool, page playwright.Page, ruler *textmeasure.Ruler, diagram *d2target.Diagram) ([][]byte, error) {
+// resolveAnchors maps the path of every board of diagram that is not a folder to the id of
+// the board in the SVG composed by --single-file, numbered in the order of collectBoards.
+func resolveAnchors(currDiagramPath, diagramHash string, diagram *d2target.Diagram, anchors map[string]string) {
+	if !diagram.IsFolderOnly {
+		anchors[currDiagramPath] = "#" + d2singlefile.BoardID(diagramHash, len(anchors))
+	}
+	for _, dl := range diagram.Layers {
+		resolveAnchors(strings.Join([]string{currDiagramPath, "layers", dl.Name}, "."), diagramHash, dl, anchors)
+	}
+	for _, dl := range diagram.Scenarios {
+		resolveAnchors(strings.Join([]string{currDiagramPath, "scenarios", dl.Name}, "."), diagramHash, dl, anchors)
+	}
+	for _, dl := range diagram.Steps {
+		resolveAnchors(strings.Join([]string{currDiagramPath, "steps", dl.Name}, "."), diagramHash, dl, anchors)
+	}
+}
+
+// relinkAnchors points the links to boards of d and its boards to their anchors.
+func relinkAnchors(d *d2target.Diagram, anchors map[string]string) {
+	for i, shape := range d.Shapes {
+		if anchor, ok := anchors[shape.Link]; ok {
+			d.Shapes[i].Link = anchor
+		}
+	}
+	for _, board := range d.Layers {
+		relinkAnchors(board, anchors)
+	}
+	for _, board := range d.Scenarios {
+		relinkAnchors(board, anchors)
+	}
+	for _, board := range d.Steps {
+		relinkAnchors(board, anchors)
+	}
+}
+
+// render renders diagram and all its boards, in parallel, and returns them in the order of
+// the boards of the diagram.
+func render(ctx context.Context, ms *xmain.State, compileDur time.Duration, plugin d2plugin.Plugin, opts d2svg.RenderOpts, inputPath, outputPath string, bundle, forceAppendix bool, pw *png.Playwright, ruler *textmeasure.Ruler, diagram *d2target.Diagram) ([][]byte, error) {
+	var jobs []boardRender
+	err := collectBoards(diagram, outputPath, opts.MasterID != "", &jobs)
+	if err != nil {
+		return nil, err
+	}
+
+	boards := make([][]byte, len(jobs))
+	errs := make([]error, len(jobs))
+	var failed atomic.Bool
+	sem := make(chan struct{}, runtime.NumCPU())
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		sem <- struct{}{}
+		if failed.Load() {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			start := time.Now()
+			boards[i], errs[i] = _render(ctx, ms, plugin, opts, inputPath, job.outputPath, bundle, forceAppendix, pw, ruler, job.diagram)
+			if errs[i] != nil {
+				failed.Store(true)
+				return
+			}
+			dur := compileDur + time.Since(start)
+			if opts.MasterID == "" {
+				ms.Log.Success.Printf("successfully compiled %s to %s in %s", ms.HumanPath(inputPath), ms.HumanPath(job.outputPath), dur)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return boards, nil
+}
+
+// boardRender is a board to render to outputPath.
+type boardRender struct {
+	diagram    *d2target.Diagram
+	outputPath string
+}
+
+// collectBoards appends the boards of diagram that are not folders to jobs, diagram first,
+// with the paths they are written to. Boards composed into one output are not written to
+// paths of their own, so they can be written to stdout.
+func collectBoards(diagram *d2target.Diagram, outputPath string, composed bool, jobs *[]boardRender) error {
 	if diagram.Name != "" {
 		ext := filepath.Ext(outputPath)
 		outputPath = strings.TrimSuffix(outputPath, ext)
@@ -749,17 +1605,24 @@ func render(ctx context.Context, ms *xmain.State, compileDur time.Duration, plug
 
 	boardOutputPath := outputPath
 	if len(diagram.Layers) > 0 || len(diagram.Scenarios) > 0 || len(diagram.Steps) > 0 {
-		if outputPath == "-" {
-			// TODO it can if composed into one
-			return nil, fmt.Errorf("multiboard output cannot be written to stdout")
+		if outputPath == "-" && !composed {
+			return fmt.Errorf("multiboard output cannot be written to stdout, unless composed into 1 SVG with --single-file")
 		}
 		// Boards with subboards must be self-contained folders.
 		ext := filepath.Ext(boardOutputPath)
 		boardOutputPath = strings.TrimSuffix(boardOutputPath, ext)
-		os.RemoveAll(boardOutputPath)
+		if outputPath != "-" {
+			os.RemoveAll(boardOutputPath)
+		}
 		boardOutputPath = filepath.Join(boardOutputPath, "index")
 		boardOutputPath += ext
 	}
+	if !diagram.IsFolderOnly {
+		*jobs = append(*jobs, boardRender{
+			diagram:    diagram,
+			outputPath: boardOutputPath,
+		})
+	}
 
 	layersOutputPath := outputPath
 	if len(diagram.Scenarios) > 0 || len(diagram.Steps) > 0 {
@@ -783,48 +1646,188 @@ func render(ctx context.Context, ms *xmain.State, compileDur time.Duration, plug
 		stepsOutputPath += ext
 	}
 
-	var boards [][]byte
 	for _, dl := range diagram.Layers {
-		childrenBoards, err := render(ctx, ms, compileDur, plugin, opts, inputPath, layersOutputPath, bundle, forceAppendix, page, ruler, dl)
+		err := collectBoards(dl, layersOutputPath, composed, jobs)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		boards = append(boards, childrenBoards...)
 	}
 	for _, dl := range diagram.Scenarios {
-		childrenBoards, err := render(ctx, ms, compileDur, plugin, opts, inputPath, scenariosOutputPath, bundle, forceAppendix, page, ruler, dl)
+		err := collectBoards(dl, scenariosOutputPath, composed, jobs)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		boards = append(boards, childrenBoards...)
 	}
 	for _, dl := range diagram.Steps {
-		childrenBoards, err := render(ctx, ms, compileDur, plugin, opts, inputPath, stepsOutputPath, bundle, forceAppendix, page, ruler, dl)
+		err := collectBoards(dl, stepsOutputPath, composed, jobs)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		boards = append(boards, childrenBoards...)
 	}
+	return nil
+}
 
-	if !diagram.IsFolderOnly {
-		start := time.Now()
-		out, err := _render(ctx, ms, plugin, opts, inputPath, boardOutputPath, bundle, forceAppendix, page, ruler, diagram)
-		if err != nil {
-			return boards, err
+// animateTransition returns the transition to the boards of animated SVGs set by the
+// flags, without their interval.
+func animateTransition(ms *xmain.State) (d2target.Transition, error) {
+	duration, _ := ms.Opts.Flags.GetInt64("animate-transition-duration")
+	easing, _ := ms.Opts.Flags.GetString("animate-easing")
+	if duration < 0 {
+		return d2target.Transition{}, xmain.UsageErrorf("--animate-transition-duration must be 0 or greater.\nYou provided: %d", duration)
+	}
+	if easing != "" && !d2target.ValidEasing(easing) {
+		return d2target.Transition{}, xmain.UsageErrorf("--animate-easing must be one of %s, or cubic-bezier(x1, y1, x2, y2).\nYou provided: %s", strings.Join(d2target.Easings, ", "), easing)
+	}
+	return d2target.Transition{
+		Duration: int(duration),
+		Easing:   easing,
+	}, nil
+}
+
+// animatePlayback returns the options of animated SVGs that set how they play, set by the
+// flags.
+func animatePlayback(ms *xmain.State) (d2animate.Options, error) {
+	loops, _ := ms.Opts.Flags.GetInt64("animate-loops")
+	direction, _ := ms.Opts.Flags.GetString("animate-direction")
+	delay, _ := ms.Opts.Flags.GetInt64("animate-delay")
+	autoplay, _ := ms.Opts.Flags.GetString("animate-autoplay")
+	if loops < 0 {
+		return d2animate.Options{}, xmain.UsageErrorf("--animate-loops must be 0 or greater.\nYou provided: %d", loops)
+	}
+	if direction != "normal" && direction != "alternate" {
+		return d2animate.Options{}, xmain.UsageErrorf("--animate-direction must be normal or alternate.\nYou provided: %s", direction)
+	}
+	if delay < 0 {
+		return d2animate.Options{}, xmain.UsageErrorf("--animate-delay must be 0 or greater.\nYou provided: %d", delay)
+	}
+	if autoplay != "always" && autoplay != "visible" {
+		return d2animate.Options{}, xmain.UsageErrorf("--animate-autoplay must be always or visible.\nYou provided: %s", autoplay)
+	}
+	return d2animate.Options{
+		Loops:           int(loops),
+		Alternate:       direction == "alternate",
+		DelayMS:         int(delay),
+		AutoplayVisible: autoplay == "visible",
+	}, nil
+}
+
+// frameVerbRegex matches the verbs that format the frame numbers of --animate-frames.
+var frameVerbRegex = regexp.MustCompile(`%[-+ #0]*[0-9]*d`)
+
+// animateFrames returns the path of the frames of animations set by the flags, with a
+// verb formatting the number of each frame, or "" if they are not exported.
+func animateFrames(ms *xmain.State) (string, error) {
+	frames, _ := ms.Opts.Flags.GetString("animate-frames")
+	if frames == "" {
+		return "", nil
+	}
+	verbs := strings.ReplaceAll(frames, "%%", "")
+	if len(frameVerbRegex.FindAllString(verbs, -1)) != 1 || strings.Count(verbs, "%") != 1 {
+		return "", xmain.UsageErrorf("--animate-frames must contain 1 verb formatting the frame number, e.g. %%d or %%02d.\nYou provided: %s", frames)
+	}
+	if ext := filepath.Ext(frames); ext != string(PNG) && ext != string(SVG) {
+		return "", xmain.UsageErrorf("--animate-frames can only export PNG or SVG files.\nYou provided: %s", ext)
+	}
+	return frames, nil
+}
+
+// writeAnimationFrames exports every board of the animation of diagram, in the order it
+// shows them, to its own file at the path of --animate-frames, if given.
+func writeAnimationFrames(ctx context.Context, ms *xmain.State, plugin d2plugin.Plugin, opts d2svg.RenderOpts, inputPath string, bundle, forceAppendix bool, pw *png.Playwright, ruler *textmeasure.Ruler, diagram *d2target.Diagram) error {
+	frames, err := animateFrames(ms)
+	if err != nil || frames == "" {
+		return err
+	}
+	frames = ms.AbsPath(frames)
+	// Frames are standalone diagrams rather than boards of the animation.
+	opts.MasterID = ""
+	n := 0
+	var walk func(d *d2target.Diagram) error
+	walk = func(d *d2target.Diagram) error {
+		if !d.IsFolderOnly {
+			n++
+			// Without a MasterID, _render writes the board to its path itself.
+			_, err := _render(ctx, ms, plugin, opts, inputPath, fmt.Sprintf(frames, n), bundle, forceAppendix, pw, ruler, d)
+			if err != nil {
+				return err
+			}
 		}
-		dur := compileDur + time.Since(start)
-		if opts.MasterID == "" {
-			ms.Log.Success.Printf("successfully compiled %s to %s in %s", ms.HumanPath(inputPath), ms.HumanPath(boardOutputPath), dur)
+		for _, dl := range d.Layers {
+			if err := walk(dl); err != nil {
+				return err
+			}
+		}
+		for _, dl := range d.Scenarios {
+			if err := walk(dl); err != nil {
+				return err
+			}
+		}
+		for _, dl := range d.Steps {
+			if err := walk(dl); err != nil {
+				return err
+			}
 		}
-		boards = append([][]byte{out}, boards...)
+		return nil
 	}
+	err = walk(diagram)
+	if err != nil {
+		return err
+	}
+	ms.Log.Success.Printf("successfully exported %d frames to %s", n, ms.HumanPath(filepath.Dir(frames)))
+	return nil
+}
 
-	return boards, nil
+// boardTransitions returns the transitions to the boards of diagram in the order render
+// renders them: those of the flags, overridden by the transition keyword of each board.
+func boardTransitions(ms *xmain.State, diagram *d2target.Diagram, animateInterval int64) ([]d2target.Transition, error) {
+	defaults, err := animateTransition(ms)
+	if err != nil {
+		return nil, err
+	}
+	defaults.Interval = int(animateInterval)
+	var transitions []d2target.Transition
+	var walk func(d *d2target.Diagram)
+	walk = func(d *d2target.Diagram) {
+		if !d.IsFolderOnly {
+			transitions = append(transitions, defaults.Override(d.Transition))
+		}
+		for _, dl := range d.Layers {
+			walk(dl)
+		}
+		for _, dl := range d.Scenarios {
+			walk(dl)
+		}
+		for _, dl := range d.Steps {
+			walk(dl)
+		}
+	}
+	walk(diagram)
+	return transitions, nil
+}
+
+// boardPaths returns the paths of the boards of diagram, the root, like root.layers.x.
+func boardPaths(diagram *d2target.Diagram) map[*d2target.Diagram]string {
+	paths := make(map[*d2target.Diagram]string)
+	var walk func(d *d2target.Diagram, path string)
+	walk = func(d *d2target.Diagram, path string) {
+		paths[d] = path
+		for _, dl := range d.Layers {
+			walk(dl, path+"."+LAYERS+"."+dl.Name)
+		}
+		for _, dl := range d.Scenarios {
+			walk(dl, path+"."+SCENARIOS+"."+dl.Name)
+		}
+		for _, dl := range d.Steps {
+			walk(dl, path+"."+STEPS+"."+dl.Name)
+		}
+	}
+	walk(diagram, "root")
+	return paths
 }
 
-func renderSingle(ctx context.Context, ms *xmain.State, compileDur time.Duration, plugin d2plugin.Plugin, opts d2svg.RenderOpts, inputPath, outputPath string, bundle, forceAppendix bool, page playwright.Page, ruler *textmeasure.Ruler, diagram *d2target.Diagram) ([][]byte, error) {
+func renderSingle(ctx context.Context, ms *xmain.State, compileDur time.Duration, plugin d2plugin.Plugin, opts d2svg.RenderOpts, inputPath, outputPath string, bundle, forceAppendix bool, pw *png.Playwright, ruler *textmeasure.Ruler, diagram *d2target.Diagram) ([][]byte, error) {
 	start := time.Now()
-	out, err := _render(ctx, ms, plugin, opts, inputPath, outputPath, bundle, forceAppendix, page, ruler, diagram)
+	out, err := _render(ctx, ms, plugin, opts, inputPath, outputPath, bundle, forceAppendix, pw, ruler, diagram)
 	if err != nil {
 		return [][]byte{}, err
 	}
@@ -835,49 +1838,61 @@ func renderSingle(ctx context.Context, ms *xmain.State, compileDur time.Duration
 	return [][]byte{out}, nil
 }
 
-func _render(ctx context.Context, ms *xmain.State, plugin d2plugin.Plugin, opts d2svg.RenderOpts, inputPath, outputPath string, bundle, forceAppendix bool, page playwright.Page, ruler *textmeasure.Ruler, diagram *d2target.Diagram) ([]byte, error) {
+func _render(ctx context.Context, ms *xmain.State, plugin d2plugin.Plugin, opts d2svg.RenderOpts, inputPath, outputPath string, bundle, forceAppendix bool, pw *png.Playwright, ruler *textmeasure.Ruler, diagram *d2target.Diagram) ([]byte, error) {
+	start := time.Now()
+	t := timingsFromContext(ctx)
 	toPNG := getExportExtension(outputPath) == PNG
+	if toPNG {
+		background, err := pngBackground(ms)
+		if err != nil {
+			return nil, err
+		}
+		if background != "" {
+			diagram.Root.Fill = background
+		}
+	}
 	var scale *float64
 	if opts.Scale != nil {
 		scale = opts.Scale
 	} else if toPNG {
 		scale = go2.Pointer(1.)
 	}
-	svg, err := d2svg.Render(diagram, &d2svg.RenderOpts{
-		Pad:                opts.Pad,
-		Sketch:             opts.Sketch,
-		Center:             opts.Center,
-		ThemeID:            opts.ThemeID,
-		DarkThemeID:        opts.DarkThemeID,
-		MasterID:           opts.MasterID,
-		ThemeOverrides:     opts.ThemeOverrides,
-		DarkThemeOverrides: opts.DarkThemeOverrides,
-		Scale:              scale,
-	})
+	crop, err := cropBox(ms, diagram)
 	if err != nil {
 		return nil, err
 	}
-
-	svg, err = plugin.PostProcess(ctx, svg)
-	if err != nil {
-		return svg, err
+	renderOpts := &d2svg.RenderOpts{
+		Pad:                 opts.Pad,
+		Sketch:              opts.Sketch,
+		Center:              opts.Center,
+		ThemeID:             opts.ThemeID,
+		DarkThemeID:         opts.DarkThemeID,
+		MasterID:            opts.MasterID,
+		ThemeOverrides:      opts.ThemeOverrides,
+		DarkThemeOverrides:  opts.DarkThemeOverrides,
+		Scale:               scale,
+		Crop:                crop,
+		NoElementAnimations: toPNG,
 	}
-
 	cacheImages := ms.Env.Getenv("IMG_CACHE") == "1"
 	l := simplelog.FromCmdLog(ms.Log)
-	svg, bundleErr := imgbundler.BundleLocal(ctx, l, inputPath, svg, cacheImages)
-	if bundle {
-		var bundleErr2 error
-		svg, bundleErr2 = imgbundler.BundleRemote(ctx, l, svg, cacheImages)
-		bundleErr = multierr.Combine(bundleErr, bundleErr2)
-	}
-	if forceAppendix && !toPNG {
-		svg = appendix.Append(diagram, ruler, svg)
+	svg, bundleErr := d2export.RenderSVG(ctx, diagram, d2export.Opts{
+		RenderOpts:   *renderOpts,
+		InputPath:    inputPath,
+		Logger:       l,
+		Ruler:        ruler,
+		PostProcess:  plugin.PostProcess,
+		BundleRemote: bundle,
+		Appendix:     forceAppendix && !toPNG,
+		CacheImages:  cacheImages,
+	})
+	if svg == nil {
+		return nil, bundleErr
 	}
 
 	out := svg
 	if toPNG {
-		svg := appendix.Append(diagram, ruler, svg)
+		svg := d2export.AppendAppendix(diagram, ruler, svg)
 
 		if !bundle {
 			var bundleErr2 error
@@ -885,15 +1900,55 @@ func _render(ctx context.Context, ms *xmain.State, plugin d2plugin.Plugin, opts
 			bundleErr = multierr.Combine(bundleErr, bundleErr2)
 		}
 
-		out, err = ConvertSVG(ms, page, svg)
+		t.rendered(diagram, start)
+		size, err := pngSize(ms)
+		if err != nil {
+			return svg, err
+		}
+		tileSize, err := pngTileSize(ms)
 		if err != nil {
 			return svg, err
 		}
-		out, err = png.AddExif(out)
+		if tileSize > 0 {
+			err = writePNGTiles(ctx, ms, pw, renderOpts, diagram, svg, size, tileSize, outputPath)
+			if err != nil {
+				return svg, err
+			}
+			return svg, bundleErr
+		}
+		conv, err := rasterize(ctx, ms, pw, renderOpts, diagram, svg, size)
 		if err != nil {
 			return svg, err
 		}
+		out, err = waitPNG(ms, conv)
+		if err != nil {
+			return svg, err
+		}
+		meta, err := pngMetadata(ms)
+		if err != nil {
+			return svg, err
+		}
+		if meta != nil {
+			meta.Source = sourceHashFromContext(ctx)
+			if meta.Title == "" {
+				meta.Title = diagram.Root.Label
+			}
+			out, err = png.AddExif(out, *meta)
+			if err != nil {
+				return svg, err
+			}
+		}
+		if dpi := size.DPI(); dpi > 0 {
+			out, err = png.SetDPI(out, dpi)
+			if err != nil {
+				return svg, err
+			}
+		}
 	} else {
+		t.rendered(diagram, start)
+		if opts.MasterID == "" {
+			out = imgbundler.Dedupe(out)
+		}
 		if len(out) > 0 && out[len(out)-1] != '\n' {
 			out = append(out, '\n')
 		}
@@ -915,70 +1970,92 @@ func _render(ctx context.Context, ms *xmain.State, plugin d2plugin.Plugin, opts
 	return svg, nil
 }
 
-func renderPDF(ctx context.Context, ms *xmain.State, plugin d2plugin.Plugin, opts d2svg.RenderOpts, inputPath, outputPath string, page playwright.Page, ruler *textmeasure.Ruler, diagram *d2target.Diagram, doc *pdf.GoFPDF, boardPath []pdf.BoardTitle, pageMap map[string]int, includeNav bool) (svg []byte, err error) {
-	var isRoot bool
-	if doc == nil {
-		doc = pdf.Init()
-		isRoot = true
-	}
-
-	if !diagram.IsFolderOnly {
-		rootFill := diagram.Root.Fill
-		// gofpdf will print the png img with a slight filter
-		// make the bg fill within the png transparent so that the pdf bg fill is the only bg color present
-		diagram.Root.Fill = "transparent"
+// playwrightMu guards starting the browser shared by the boards render renders in parallel.
+var playwrightMu sync.Mutex
 
-		var scale *float64
-		if opts.Scale != nil {
-			scale = opts.Scale
-		} else {
-			scale = go2.Pointer(1.)
-		}
+// launchPlaywright starts the browser of pw unless it is running, for the exports that only
+// start it for the boards they cannot draw without one.
+func launchPlaywright(ms *xmain.State, pw *png.Playwright) (err error) {
+	playwrightMu.Lock()
+	defer playwrightMu.Unlock()
+	if pw.Browser != nil {
+		return nil
+	}
+	*pw, err = png.InitPlaywright(browserPath(ms))
+	return err
+}
 
-		svg, err = d2svg.Render(diagram, &d2svg.RenderOpts{
-			Pad:     opts.Pad,
-			Sketch:  opts.Sketch,
-			Center:  opts.Center,
-			Scale:   scale,
-			ThemeID: opts.ThemeID,
-		})
+func renderPDF(ctx context.Context, ms *xmain.State, plugin d2plugin.Plugin, opts d2svg.RenderOpts, inputPath, outputPath string, pw *png.Playwright, ruler *textmeasure.Ruler, diagram *d2target.Diagram, doc *pdf.GoFPDF, pages *[]func() error, boardPath []pdf.BoardTitle, pageMap map[string]int, includeNav bool) (svg []byte, err error) {
+	var isRoot bool
+	merge := pdfMergeFromContext(ctx)
+	if doc == nil && merge != nil && merge.doc != nil {
+		doc = merge.doc
+		pages = &merge.pages
+		isRoot = true
+	} else if doc == nil {
+		pageOpts, err := pdfPageOptions(ms)
 		if err != nil {
 			return nil, err
 		}
-
-		svg, err = plugin.PostProcess(ctx, svg)
+		if config := diagram.Config; config != nil {
+			if pageOpts.Header == "" && config.PDFHeader != nil {
+				pageOpts.Header = *config.PDFHeader
+			}
+			if pageOpts.Footer == "" && config.PDFFooter != nil {
+				pageOpts.Footer = *config.PDFFooter
+			}
+		}
+		metadata, protection, err := pdfDocumentOptions(ms)
 		if err != nil {
-			return svg, err
+			return nil, err
 		}
-
-		cacheImages := ms.Env.Getenv("IMG_CACHE") == "1"
-		l := simplelog.FromCmdLog(ms.Log)
-		svg, bundleErr := imgbundler.BundleLocal(ctx, l, inputPath, svg, cacheImages)
-		svg, bundleErr2 := imgbundler.BundleRemote(ctx, l, svg, cacheImages)
-		bundleErr = multierr.Combine(bundleErr, bundleErr2)
-		if bundleErr != nil {
-			return svg, bundleErr
+		doc = pdf.Init(pageOpts)
+		doc.SetMetadata(metadata)
+		if protection != nil {
+			err = doc.SetProtection(*protection)
+			if err != nil {
+				return nil, err
+			}
 		}
-		svg = appendix.Append(diagram, ruler, svg)
-
-		pngImg, err := ConvertSVG(ms, page, svg)
-		if err != nil {
-			return svg, err
+		// Pages are added in order once all boards are rendered, so that their PNGs are
+		// converted in parallel in the meantime.
+		pages = &[]func() error{}
+		if merge != nil {
+			merge.doc = doc
+			pages = &merge.pages
+			merge.themeID = *opts.ThemeID
+			merge.fill = diagram.Root.Fill
 		}
+		isRoot = true
+	}
 
-		viewboxSlice := appendix.FindViewboxSlice(svg)
-		viewboxX, err := strconv.ParseFloat(viewboxSlice[0], 64)
-		if err != nil {
-			return svg, err
+	var section string
+	if isRoot && merge != nil {
+		section = diagram.Root.Label
+		if section == "" {
+			section = strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath))
 		}
-		viewboxY, err := strconv.ParseFloat(viewboxSlice[1], 64)
+		*pages = append(*pages, func() error {
+			doc.BeginSection(section)
+			return nil
+		})
+	}
+
+	rootFill := diagram.Root.Fill
+	if !diagram.IsFolderOnly {
+		start := time.Now()
+		exportOpts, err := pageExportOpts(ms, plugin, opts, ruler, inputPath, pw)
 		if err != nil {
-			return svg, err
+			return nil, err
 		}
-		err = doc.AddPDFPage(pngImg, boardPath, *opts.ThemeID, rootFill, diagram.Shapes, *opts.Pad, viewboxX, viewboxY, pageMap, includeNav)
+		exportOpts.Raster, _ = ms.Opts.Flags.GetBool("pdf-raster")
+		var addPage func() error
+		svg, addPage, err = d2export.PDFPage(ctx, doc, diagram, boardPath, pageMap, includeNav, exportOpts)
 		if err != nil {
 			return svg, err
 		}
+		timingsFromContext(ctx).rendered(diagram, start)
+		*pages = append(*pages, addPage)
 	}
 
 	for _, dl := range diagram.Layers {
@@ -986,7 +2063,7 @@ func renderPDF(ctx context.Context, ms *xmain.State, plugin d2plugin.Plugin, opt
 			Name:    dl.Root.Label,
 			BoardID: strings.Join([]string{boardPath[len(boardPath)-1].BoardID, LAYERS, dl.Name}, "."),
 		})
-		_, err := renderPDF(ctx, ms, plugin, opts, inputPath, "", page, ruler, dl, doc, path, pageMap, includeNav)
+		_, err := renderPDF(ctx, ms, plugin, opts, inputPath, "", pw, ruler, dl, doc, pages, path, pageMap, includeNav)
 		if err != nil {
 			return nil, err
 		}
@@ -996,7 +2073,7 @@ func renderPDF(ctx context.Context, ms *xmain.State, plugin d2plugin.Plugin, opt
 			Name:    dl.Root.Label,
 			BoardID: strings.Join([]string{boardPath[len(boardPath)-1].BoardID, SCENARIOS, dl.Name}, "."),
 		})
-		_, err := renderPDF(ctx, ms, plugin, opts, inputPath, "", page, ruler, dl, doc, path, pageMap, includeNav)
+		_, err := renderPDF(ctx, ms, plugin, opts, inputPath, "", pw, ruler, dl, doc, pages, path, pageMap, includeNav)
 		if err != nil {
 			return nil, err
 		}
@@ -1006,13 +2083,28 @@ func renderPDF(ctx context.Context, ms *xmain.State, plugin d2plugin.Plugin, opt
 			Name:    dl.Root.Label,
 			BoardID: strings.Join([]string{boardPath[len(boardPath)-1].BoardID, STEPS, dl.Name}, "."),
 		})
-		_, err := renderPDF(ctx, ms, plugin, opts, inputPath, "", page, ruler, dl, doc, path, pageMap, includeNav)
+		_, err := renderPDF(ctx, ms, plugin, opts, inputPath, "", pw, ruler, dl, doc, pages, path, pageMap, includeNav)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	if isRoot {
+	if isRoot && merge != nil {
+		// The pages are added by pdfMerge once all inputs are rendered.
+		merge.sections = append(merge.sections, pdf.Section{Name: section, Boards: pdfBoards(diagram, boardPath)})
+	} else if isRoot {
+		if toc, _ := ms.Opts.Flags.GetBool("pdf-toc"); toc {
+			err := doc.AddTOCPages([]pdf.Section{{Boards: pdfBoards(diagram, boardPath)}}, *opts.ThemeID, rootFill)
+			if err != nil {
+				return svg, err
+			}
+		}
+		for _, addPage := range *pages {
+			err := addPage()
+			if err != nil {
+				return svg, err
+			}
+		}
 		err := doc.Export(outputPath)
 		if err != nil {
 			return nil, err
@@ -1022,105 +2114,424 @@ func renderPDF(ctx context.Context, ms *xmain.State, plugin d2plugin.Plugin, opt
 	return svg, nil
 }
 
-func renderPPTX(ctx context.Context, ms *xmain.State, presentation *pptx.Presentation, plugin d2plugin.Plugin, opts d2svg.RenderOpts, ruler *textmeasure.Ruler, inputPath, outputPath string, page playwright.Page, diagram *d2target.Diagram, boardPath []pptx.BoardTitle, boardIDToIndex map[string]int) ([]byte, error) {
-	var svg []byte
-	if !diagram.IsFolderOnly {
-		// gofpdf will print the png img with a slight filter
-		// make the bg fill within the png transparent so that the pdf bg fill is the only bg color present
-		diagram.Root.Fill = "transparent"
+// pdfBoards returns the paths of diagram, at boardPath, and all its boards in the order
+// renderPDF adds them.
+func pdfBoards(diagram *d2target.Diagram, boardPath []pdf.BoardTitle) [][]pdf.BoardTitle {
+	boards := [][]pdf.BoardTitle{boardPath}
+	boardID := boardPath[len(boardPath)-1].BoardID
+	for _, b := range []struct {
+		kind   string
+		boards []*d2target.Diagram
+	}{
+		{LAYERS, diagram.Layers},
+		{SCENARIOS, diagram.Scenarios},
+		{STEPS, diagram.Steps},
+	} {
+		for _, dl := range b.boards {
+			path := append(append([]pdf.BoardTitle(nil), boardPath...), pdf.BoardTitle{
+				Name:    dl.Root.Label,
+				BoardID: strings.Join([]string{boardID, b.kind, dl.Name}, "."),
+			})
+			boards = append(boards, pdfBoards(dl, path)...)
+		}
+	}
+	return boards
+}
 
-		var scale *float64
-		if opts.Scale != nil {
-			scale = opts.Scale
-		} else {
-			scale = go2.Pointer(1.)
-		}
+// pdfPageOptions returns the options of the pages of PDF exports set by the flags.
+func pdfPageOptions(ms *xmain.State) (*pdf.PageOptions, error) {
+	size, _ := ms.Opts.Flags.GetString("pdf-page-size")
+	orientation, _ := ms.Opts.Flags.GetString("pdf-orientation")
+	margin, _ := ms.Opts.Flags.GetInt64("pdf-margin")
+	tile, _ := ms.Opts.Flags.GetBool("pdf-tile")
+	header, _ := ms.Opts.Flags.GetString("pdf-header")
+	footer, _ := ms.Opts.Flags.GetString("pdf-footer")
+
+	size = strings.ToLower(size)
+	if _, ok := pdf.PageSizes[size]; !ok && size != "auto" {
+		return nil, xmain.UsageErrorf("--pdf-page-size must be A4, Letter or auto.\nYou provided: %s", size)
+	}
+	orientation = strings.ToLower(orientation)
+	switch orientation {
+	case "auto", "portrait", "landscape":
+	default:
+		return nil, xmain.UsageErrorf("--pdf-orientation must be portrait, landscape or auto.\nYou provided: %s", orientation)
+	}
+	if margin < 0 {
+		return nil, xmain.UsageErrorf("--pdf-margin must be at least 0.\nYou provided: %d", margin)
+	}
+	return &pdf.PageOptions{
+		Size:        size,
+		Orientation: orientation,
+		Margin:      float64(margin),
+		Tile:        tile,
+		Header:      header,
+		Footer:      footer,
+	}, nil
+}
 
-		var err error
+// pngBackground returns the fill of the root of PNG exports set by the flags, or "" for the
+// one of the diagram.
+func pngBackground(ms *xmain.State) (string, error) {
+	background, _ := ms.Opts.Flags.GetString("background")
+	if background == "theme" {
+		return "", nil
+	}
+	if go2.Contains(color.NamedColors, strings.ToLower(background)) {
+		return strings.ToLower(background), nil
+	}
+	if color.ColorHexRegex.MatchString(background) {
+		return background, nil
+	}
+	return "", xmain.UsageErrorf("--background must be theme, transparent, a named color or a hex code such as #f0ff3a.\nYou provided: %s", background)
+}
 
-		svg, err = d2svg.Render(diagram, &d2svg.RenderOpts{
-			Pad:    opts.Pad,
-			Sketch: opts.Sketch,
-			Center: opts.Center,
-			Scale:  scale,
-		})
-		if err != nil {
-			return nil, err
+// pngSize returns the size of PNG exports set by the flags.
+func pngSize(ms *xmain.State) (png.Size, error) {
+	scale, _ := ms.Opts.Flags.GetFloat64("png-scale")
+	width, _ := ms.Opts.Flags.GetInt64("width")
+	height, _ := ms.Opts.Flags.GetInt64("height")
+	if scale <= 0 {
+		return png.Size{}, xmain.UsageErrorf("--png-scale must be greater than 0.\nYou provided: %v", scale)
+	}
+	if width < 0 {
+		return png.Size{}, xmain.UsageErrorf("--width must be 0 or greater.\nYou provided: %d", width)
+	}
+	if height < 0 {
+		return png.Size{}, xmain.UsageErrorf("--height must be 0 or greater.\nYou provided: %d", height)
+	}
+	size := png.Size{
+		Width:  int(width),
+		Height: int(height),
+	}
+	if scale != png.SCALE {
+		size.Scale = scale
+	}
+	return size, nil
+}
+
+// pngMetadata returns the metadata embedded in PNG exports set by the flags, nil with
+// --png-metadata=false. Its source is left to each input.
+func pngMetadata(ms *xmain.State) (*png.Metadata, error) {
+	if enabled, _ := ms.Opts.Flags.GetBool("png-metadata"); !enabled {
+		return nil, nil
+	}
+	title, _ := ms.Opts.Flags.GetString("png-title")
+	author, _ := ms.Opts.Flags.GetString("png-author")
+	fields, _ := ms.Opts.Flags.GetString("png-metadata-fields")
+	meta := &png.Metadata{
+		Title:  title,
+		Author: author,
+	}
+	if fields == "" {
+		return meta, nil
+	}
+	meta.Fields = make(map[string]string)
+	for _, field := range strings.Split(fields, ",") {
+		k, v, ok := strings.Cut(field, "=")
+		if !ok || !validMetadataKey(k) {
+			return nil, xmain.UsageErrorf("--png-metadata-fields must be comma separated key=value pairs, with keys of at most 79 printable ASCII characters other than Title, Author and Source.\nYou provided: %s", fields)
 		}
+		meta.Fields[k] = v
+	}
+	return meta, nil
+}
 
-		svg, err = plugin.PostProcess(ctx, svg)
+// validMetadataKey returns whether k is a keyword of PNG text chunks. Keywords also can't be
+// the ones of the title, author and source.
+func validMetadataKey(k string) bool {
+	if len(k) == 0 || len(k) > 79 || k != strings.TrimSpace(k) {
+		return false
+	}
+	switch k {
+	case "Title", "Author", "Source":
+		return false
+	}
+	for _, r := range k {
+		if r < ' ' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+type sourceHashContextKey struct{}
+
+// withSourceHash records the SHA-256 hash of input, the source being exported, for the
+// metadata of PNGs.
+func withSourceHash(ctx context.Context, input []byte) context.Context {
+	return context.WithValue(ctx, sourceHashContextKey{}, fmt.Sprintf("sha256:%x", sha256.Sum256(input)))
+}
+
+func sourceHashFromContext(ctx context.Context) string {
+	h, _ := ctx.Value(sourceHashContextKey{}).(string)
+	return h
+}
+
+// rasterEngine returns the engine boards are rasterized with set by --raster-engine.
+func rasterEngine(ms *xmain.State) (string, error) {
+	engine, _ := ms.Opts.Flags.GetString("raster-engine")
+	switch engine {
+	case "auto", "native", "browser":
+		return engine, nil
+	}
+	return "", xmain.UsageErrorf("--raster-engine must be auto, native or browser.\nYou provided: %s", engine)
+}
+
+// rasterNative returns whether diagram, rendered with opts, is rasterized without a browser,
+// see --raster-engine and png.CanDrawNative. The native engine warns of what it approximates.
+func rasterNative(ms *xmain.State, opts *d2svg.RenderOpts, diagram *d2target.Diagram) bool {
+	engine, _ := rasterEngine(ms)
+	sketch := opts.Sketch != nil && *opts.Sketch
+	switch engine {
+	case "browser":
+		return false
+	case "native":
+		approximations := png.NativeApproximations(diagram)
+		if sketch {
+			approximations = append(approximations, "sketch")
+		}
+		if len(approximations) > 0 {
+			ms.Log.Warn.Printf("%s is rasterized without a browser, which approximates its %s", boardName(diagram), strings.Join(approximations, ", "))
+		}
+		return true
+	}
+	return !sketch && png.CanDrawNative(diagram)
+}
+
+// rasterize starts converting svg, the render of diagram with opts, into a PNG of size. It is
+// drawn without a browser when rasterNative, else the browser is launched if it wasn't yet.
+func rasterize(ctx context.Context, ms *xmain.State, pw *png.Playwright, opts *d2svg.RenderOpts, diagram *d2target.Diagram, svg []byte, size png.Size) (*png.Conversion, error) {
+	if rasterNative(ms, opts, diagram) {
+		return convertNative(ctx, opts, diagram, size), nil
+	}
+	err := launchPlaywright(ms, pw)
+	if err != nil {
+		return nil, err
+	}
+	return convertPNGSize(ctx, pw, diagram, svg, size), nil
+}
+
+// convertNative is d2export.ConvertNative timed.
+func convertNative(ctx context.Context, opts *d2svg.RenderOpts, diagram *d2target.Diagram, size png.Size) *png.Conversion {
+	conv := d2export.ConvertNative(diagram, opts, size)
+	timingsFromContext(ctx).rasterizing(diagram, conv)
+	return conv
+}
+
+// boardName returns the name of diagram in messages.
+func boardName(diagram *d2target.Diagram) string {
+	if diagram.Name == "" {
+		return "the root board"
+	}
+	return "board " + diagram.Name
+}
+
+// cropOption returns the region of boards exported set by --crop: either the absolute ID of a
+// shape, or the box of its coordinates. Both are empty to export whole boards.
+func cropOption(ms *xmain.State) (id string, box *geo.Box, err error) {
+	crop, _ := ms.Opts.Flags.GetString("crop")
+	if crop == "" {
+		return "", nil, nil
+	}
+	parts := strings.Split(crop, ",")
+	if len(parts) != 4 {
+		return crop, nil, nil
+	}
+	var coords [4]float64
+	for i, part := range parts {
+		coords[i], err = strconv.ParseFloat(strings.TrimSpace(part), 64)
 		if err != nil {
-			return nil, err
+			return crop, nil, nil
 		}
+	}
+	if coords[2] <= 0 || coords[3] <= 0 {
+		return "", nil, xmain.UsageErrorf("--crop must be the ID of a shape or x,y,width,height with a width and height greater than 0.\nYou provided: %s", crop)
+	}
+	return "", geo.NewBox(geo.NewPoint(coords[0], coords[1]), coords[2], coords[3]), nil
+}
 
-		cacheImages := ms.Env.Getenv("IMG_CACHE") == "1"
-		l := simplelog.FromCmdLog(ms.Log)
-		svg, bundleErr := imgbundler.BundleLocal(ctx, l, inputPath, svg, cacheImages)
-		svg, bundleErr2 := imgbundler.BundleRemote(ctx, l, svg, cacheImages)
-		bundleErr = multierr.Combine(bundleErr, bundleErr2)
-		if bundleErr != nil {
-			return nil, bundleErr
+// cropBox returns the box of diagram exported set by --crop, nil for the whole diagram.
+func cropBox(ms *xmain.State, diagram *d2target.Diagram) (*geo.Box, error) {
+	id, box, err := cropOption(ms)
+	if err != nil || id == "" {
+		return box, err
+	}
+	for _, s := range diagram.Shapes {
+		if s.ID == id {
+			return geo.NewBox(geo.NewPoint(float64(s.Pos.X), float64(s.Pos.Y)), float64(s.Width), float64(s.Height)), nil
 		}
+	}
+	return nil, fmt.Errorf("--crop: %s has no shape %s", boardName(diagram), id)
+}
 
-		svg = appendix.Append(diagram, ruler, svg)
+// pptxSlideOptions returns the options of the slides of PPTX exports set by the flags.
+func pptxSlideOptions(ms *xmain.State) (*pptx.SlideOptions, error) {
+	size, _ := ms.Opts.Flags.GetString("pptx-slide-size")
+	scaling, _ := ms.Opts.Flags.GetString("pptx-scaling")
 
-		pngImg, err := ConvertSVG(ms, page, svg)
+	var opts pptx.SlideOptions
+	if size != "auto" {
+		var err error
+		opts.Width, opts.Height, err = pptx.ParseSlideSize(size)
 		if err != nil {
-			return nil, err
+			return nil, xmain.UsageErrorf("--pptx-slide-size must be 16:9, 4:3, a size in inches between 1 and 56 such as 13.33x7.5, or auto.\nYou provided: %s", size)
 		}
+	}
+	opts.Scaling = strings.ToLower(scaling)
+	switch opts.Scaling {
+	case pptx.ScalingFit, pptx.ScalingFill, pptx.ScalingActual:
+	default:
+		return nil, xmain.UsageErrorf("--pptx-scaling must be fit, fill or actual.\nYou provided: %s", scaling)
+	}
+	return &opts, nil
+}
 
-		slide, err := presentation.AddSlide(pngImg, boardPath)
-		if err != nil {
-			return nil, err
+// pptxAnimateSteps returns whether steps boards are exported to PPTX as single slides that
+// reveal each step on a click.
+func pptxAnimateSteps(ms *xmain.State) (bool, error) {
+	steps, _ := ms.Opts.Flags.GetString("pptx-steps")
+	switch strings.ToLower(steps) {
+	case "slides":
+		return false, nil
+	case "animate":
+		return true, nil
+	}
+	return false, xmain.UsageErrorf("--pptx-steps must be slides or animate.\nYou provided: %s", steps)
+}
+
+// pptxAnimatedSteps returns whether the steps of diagram are drawn on a single slide
+// revealing each of them on a click. Steps with boards of their own are not.
+func pptxAnimatedSteps(ms *xmain.State, diagram *d2target.Diagram, opts d2svg.RenderOpts) bool {
+	if animate, _ := pptxAnimateSteps(ms); !animate || len(diagram.Steps) == 0 {
+		return false
+	}
+	for _, step := range diagram.Steps {
+		if step.IsFolderOnly || len(step.Layers) > 0 || len(step.Scenarios) > 0 || len(step.Steps) > 0 || !pptxNative(ms, step, opts) {
+			return false
 		}
+	}
+	return true
+}
 
-		viewboxSlice := appendix.FindViewboxSlice(svg)
-		viewboxX, err := strconv.ParseFloat(viewboxSlice[0], 64)
+// pptxTitleSlides returns whether the PPTX export of the root board diagram opens with a
+// title slide and has a section slide before each top-level layer. Diagrams of a single board
+// do not.
+func pptxTitleSlides(ms *xmain.State, diagram *d2target.Diagram) bool {
+	titleSlides, _ := ms.Opts.Flags.GetBool("pptx-title-slides")
+	return titleSlides && len(diagram.Layers)+len(diagram.Scenarios)+len(diagram.Steps) > 0
+}
+
+// pdfDocumentOptions returns the metadata and encryption of PDF exports per the flags,
+// protection being nil when they are not encrypted.
+func pdfDocumentOptions(ms *xmain.State) (m pdf.Metadata, protection *pdf.Protection, _ error) {
+	m.Title, _ = ms.Opts.Flags.GetString("pdf-title")
+	m.Author, _ = ms.Opts.Flags.GetString("pdf-author")
+	m.Subject, _ = ms.Opts.Flags.GetString("pdf-subject")
+	m.Keywords, _ = ms.Opts.Flags.GetString("pdf-keywords")
+	m.XMP, _ = ms.Opts.Flags.GetBool("pdf-xmp")
+	m.Standard, _ = ms.Opts.Flags.GetString("pdf-standard")
+	m.Standard = strings.ToLower(m.Standard)
+	if m.Standard != "" && m.Standard != pdf.PDFA2B {
+		return m, nil, xmain.UsageErrorf("--pdf-standard must be %s.\nYou provided: %s", strings.Join(pdf.Standards, " or "), m.Standard)
+	}
+
+	var p pdf.Protection
+	p.UserPassword, _ = ms.Opts.Flags.GetString("pdf-user-password")
+	p.OwnerPassword, _ = ms.Opts.Flags.GetString("pdf-owner-password")
+	permissions, _ := ms.Opts.Flags.GetString("pdf-permissions")
+	if permissions != "none" {
+		for _, name := range strings.Split(permissions, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if _, ok := pdf.PermissionFlags[name]; !ok {
+				return m, nil, xmain.UsageErrorf("--pdf-permissions must be a comma separated list of %s, or none.\nYou provided: %s", strings.Join(pdf.Permissions(), ", "), permissions)
+			}
+			p.Permissions = append(p.Permissions, name)
+		}
+	}
+	if p.UserPassword == "" && p.OwnerPassword == "" {
+		return m, nil, nil
+	}
+	if m.Standard == pdf.PDFA2B {
+		return m, nil, xmain.UsageErrorf("PDF/A exports cannot be encrypted with --pdf-user-password or --pdf-owner-password")
+	}
+	return m, &p, nil
+}
+
+// pageExportOpts returns the options of the pages of PDF and slides of PPTX exports of the
+// boards of inputPath, rendered with opts.
+func pageExportOpts(ms *xmain.State, plugin d2plugin.Plugin, opts d2svg.RenderOpts, ruler *textmeasure.Ruler, inputPath string, pw *png.Playwright) (d2export.Opts, error) {
+	size, err := pngSize(ms)
+	if err != nil {
+		return d2export.Opts{}, err
+	}
+	return d2export.Opts{
+		RenderOpts:  opts,
+		InputPath:   inputPath,
+		Logger:      simplelog.FromCmdLog(ms.Log),
+		Ruler:       ruler,
+		PostProcess: plugin.PostProcess,
+		CacheImages: ms.Env.Getenv("IMG_CACHE") == "1",
+		Size:        size,
+		Rasterize: func(ctx context.Context, diagram *d2target.Diagram, renderOpts *d2svg.RenderOpts, svg []byte, size png.Size) (*png.Conversion, error) {
+			return rasterize(ctx, ms, pw, renderOpts, diagram, svg, size)
+		},
+	}, nil
+}
+
+func renderPPTX(ctx context.Context, ms *xmain.State, presentation *pptx.Presentation, plugin d2plugin.Plugin, opts d2svg.RenderOpts, ruler *textmeasure.Ruler, inputPath, outputPath string, pw *png.Playwright, diagram *d2target.Diagram, slides *[]func() error, boardPath []pptx.BoardTitle, boardIDToIndex map[string]int) ([]byte, error) {
+	isRoot := slides == nil
+	if isRoot {
+		// Slides are added in order once all boards are rendered, so that their PNGs are
+		// converted in parallel in the meantime.
+		slides = &[]func() error{}
+		if pptxTitleSlides(ms, diagram) {
+			*slides = append(*slides, func() error {
+				title := diagram.Root.Label
+				if title == "" {
+					title = presentation.Title
+				}
+				presentation.AddTitleSlide(title, fmt.Sprintf("%s\nD2 %s", time.Now().Format("January 2, 2006"), version.Version))
+				return nil
+			})
+		}
+	}
+	var svg []byte
+	if !diagram.IsFolderOnly {
+		start := time.Now()
+		exportOpts, err := pageExportOpts(ms, plugin, opts, ruler, inputPath, pw)
 		if err != nil {
 			return nil, err
 		}
-		viewboxY, err := strconv.ParseFloat(viewboxSlice[1], 64)
+		exportOpts.Raster, _ = ms.Opts.Flags.GetBool("pptx-raster")
+		exportOpts.PPTXCallouts, _ = ms.Opts.Flags.GetBool("pptx-tooltip-callouts")
+		var addSlide func() error
+		svg, addSlide, err = d2export.PPTXSlide(ctx, presentation, diagram, boardPath, boardIDToIndex, exportOpts)
 		if err != nil {
 			return nil, err
 		}
-
-		// Draw links
-		for _, shape := range diagram.Shapes {
-			if shape.Link == "" {
-				continue
-			}
-
-			linkX := png.SCALE * (float64(shape.Pos.X) - viewboxX - float64(shape.StrokeWidth))
-			linkY := png.SCALE * (float64(shape.Pos.Y) - viewboxY - float64(shape.StrokeWidth))
-			linkWidth := png.SCALE * (float64(shape.Width) + float64(shape.StrokeWidth*2))
-			linkHeight := png.SCALE * (float64(shape.Height) + float64(shape.StrokeWidth*2))
-			link := &pptx.Link{
-				Left:    int(linkX),
-				Top:     int(linkY),
-				Width:   int(linkWidth),
-				Height:  int(linkHeight),
-				Tooltip: shape.Link,
-			}
-			slide.AddLink(link)
-			key, err := d2parser.ParseKey(shape.Link)
-			if err != nil || key.Path[0].Unbox().ScalarString() != "root" {
-				// External link
-				link.ExternalUrl = shape.Link
-			} else if pageNum, ok := boardIDToIndex[shape.Link]; ok {
-				// Internal link
-				link.SlideIndex = pageNum + 1
-			}
-		}
+		timingsFromContext(ctx).rendered(diagram, start)
+		*slides = append(*slides, addSlide)
 	}
 
 	for _, dl := range diagram.Layers {
 		boardID := strings.Join([]string{boardPath[len(boardPath)-1].BoardID, LAYERS, dl.Name}, ".")
+		if isRoot && pptxTitleSlides(ms, diagram) {
+			title := dl.Root.Label
+			if title == "" {
+				title = dl.Name
+			}
+			*slides = append(*slides, func() error {
+				presentation.AddSectionSlide(title, boardID)
+				return nil
+			})
+		}
 		path := append(boardPath, pptx.BoardTitle{
 			Name:        dl.Name,
 			BoardID:     boardID,
 			LinkToSlide: boardIDToIndex[boardID] + 1,
 		})
-		_, err := renderPPTX(ctx, ms, presentation, plugin, opts, ruler, inputPath, "", page, dl, path, boardIDToIndex)
+		_, err := renderPPTX(ctx, ms, presentation, plugin, opts, ruler, inputPath, "", pw, dl, slides, path, boardIDToIndex)
 		if err != nil {
 			return nil, err
 		}
@@ -1132,27 +2543,66 @@ func renderPPTX(ctx context.Context, ms *xmain.State, presentation *pptx.Present
 			BoardID:     boardID,
 			LinkToSlide: boardIDToIndex[boardID] + 1,
 		})
-		_, err := renderPPTX(ctx, ms, presentation, plugin, opts, ruler, inputPath, "", page, dl, path, boardIDToIndex)
+		_, err := renderPPTX(ctx, ms, presentation, plugin, opts, ruler, inputPath, "", pw, dl, slides, path, boardIDToIndex)
 		if err != nil {
 			return nil, err
 		}
 	}
-	for _, dl := range diagram.Steps {
-		boardID := strings.Join([]string{boardPath[len(boardPath)-1].BoardID, STEPS, dl.Name}, ".")
-		path := append(boardPath, pptx.BoardTitle{
-			Name:        dl.Name,
+	if pptxAnimatedSteps(ms, diagram, opts) {
+		steps := diagram.Steps
+		// The slide is titled by the first step, the one it starts on.
+		boardID := strings.Join([]string{boardPath[len(boardPath)-1].BoardID, STEPS, steps[0].Name}, ".")
+		path := append(append([]pptx.BoardTitle(nil), boardPath...), pptx.BoardTitle{
+			Name:        steps[0].Name,
 			BoardID:     boardID,
 			LinkToSlide: boardIDToIndex[boardID] + 1,
 		})
-		_, err := renderPPTX(ctx, ms, presentation, plugin, opts, ruler, inputPath, "", page, dl, path, boardIDToIndex)
-		if err != nil {
-			return nil, err
+		*slides = append(*slides, func() error {
+			slide, err := presentation.AddStepsSlide(steps, *opts.ThemeID, *opts.Pad, path, func(link string) (string, int) {
+				return d2export.PPTXLink(link, boardIDToIndex)
+			})
+			if err != nil {
+				return err
+			}
+			slide.Notes = pptx.Notes(steps[len(steps)-1])
+			return nil
+		})
+	} else {
+		for _, dl := range diagram.Steps {
+			boardID := strings.Join([]string{boardPath[len(boardPath)-1].BoardID, STEPS, dl.Name}, ".")
+			path := append(boardPath, pptx.BoardTitle{
+				Name:        dl.Name,
+				BoardID:     boardID,
+				LinkToSlide: boardIDToIndex[boardID] + 1,
+			})
+			_, err := renderPPTX(ctx, ms, presentation, plugin, opts, ruler, inputPath, "", pw, dl, slides, path, boardIDToIndex)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if isRoot {
+		for _, addSlide := range *slides {
+			err := addSlide()
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 
 	return svg, nil
 }
 
+// pptxNative returns whether the slide of diagram is drawn as native PowerPoint shapes
+// rather than a PNG.
+func pptxNative(ms *xmain.State, diagram *d2target.Diagram, opts d2svg.RenderOpts) bool {
+	if raster, _ := ms.Opts.Flags.GetBool("pptx-raster"); raster || opts.Sketch != nil && *opts.Sketch {
+		return false
+	}
+	return pptx.CanDrawNative(diagram)
+}
+
 // newExt must include leading .
 func renameExt(fp string, newExt string) string {
 	ext := filepath.Ext(fp)
@@ -1188,14 +2638,6 @@ func populateLayoutOpts(ctx context.Context, ms *xmain.State, ps []d2plugin.Plug
 	return nil
 }
 
-func initPlaywright() error {
-	pw, err := png.InitPlaywright()
-	if err != nil {
-		return err
-	}
-	return pw.Cleanup()
-}
-
 func loadFont(ms *xmain.State, path string) ([]byte, error) {
 	if filepath.Ext(path) != ".ttf" {
 		return nil, fmt.Errorf("expected .ttf file but %s has extension %s", path, filepath.Ext(path))
@@ -1208,6 +2650,35 @@ func loadFont(ms *xmain.State, path string) ([]byte, error) {
 	return ttf, nil
 }
 
+type pptxTemplateContextKey struct{}
+
+func withPPTXTemplate(ctx context.Context, t *pptx.Template) context.Context {
+	return context.WithValue(ctx, pptxTemplateContextKey{}, t)
+}
+
+func pptxTemplateFromContext(ctx context.Context) *pptx.Template {
+	t, _ := ctx.Value(pptxTemplateContextKey{}).(*pptx.Template)
+	return t
+}
+
+func loadPPTXTemplate(ms *xmain.State, path string) (*pptx.Template, error) {
+	switch filepath.Ext(path) {
+	case ".pptx", ".potx":
+	default:
+		return nil, fmt.Errorf("expected .pptx or .potx file but %s has extension %s", path, filepath.Ext(path))
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template at %s: %v", path, err)
+	}
+	t, err := pptx.ParseTemplate(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	ms.Log.Info.Printf("PPTX template %s loaded", filepath.Base(path))
+	return t, nil
+}
+
 func loadFonts(ms *xmain.State, pathToRegular, pathToItalic, pathToBold, pathToSemibold string) (*d2fonts.FontFamily, error) {
 	if pathToRegular == "" && pathToItalic == "" && pathToBold == "" && pathToSemibold == "" {
 		return nil, nil
@@ -1251,33 +2722,73 @@ const LAYERS = "layers"
 const STEPS = "steps"
 const SCENARIOS = "scenarios"
 
-// buildBoardIDToIndex returns a map from board path to page int
-// To map correctly, it must follow the same traversal of pdf/pptx building
-func buildBoardIDToIndex(diagram *d2target.Diagram, dictionary map[string]int, path []string) map[string]int {
-	newPath := append(path, diagram.Name)
-	if dictionary == nil {
-		dictionary = map[string]int{}
-		newPath[0] = "root"
-	}
-
-	key := strings.Join(newPath, ".")
-	dictionary[key] = len(dictionary)
-
+// mergeAnimatedSteps points the steps of the boards whose steps are drawn on a single slide,
+// see pptxAnimatedSteps, to that slide in boardIDToIndex, moving up the slides after them.
+func mergeAnimatedSteps(ms *xmain.State, opts d2svg.RenderOpts, diagram *d2target.Diagram, boardID string, boardIDToIndex map[string]int) {
 	for _, dl := range diagram.Layers {
-		buildBoardIDToIndex(dl, dictionary, append(newPath, LAYERS))
+		mergeAnimatedSteps(ms, opts, dl, strings.Join([]string{boardID, LAYERS, dl.Name}, "."), boardIDToIndex)
 	}
 	for _, dl := range diagram.Scenarios {
-		buildBoardIDToIndex(dl, dictionary, append(newPath, SCENARIOS))
+		mergeAnimatedSteps(ms, opts, dl, strings.Join([]string{boardID, SCENARIOS, dl.Name}, "."), boardIDToIndex)
 	}
-	for _, dl := range diagram.Steps {
-		buildBoardIDToIndex(dl, dictionary, append(newPath, STEPS))
+	if !pptxAnimatedSteps(ms, diagram, opts) {
+		for _, dl := range diagram.Steps {
+			mergeAnimatedSteps(ms, opts, dl, strings.Join([]string{boardID, STEPS, dl.Name}, "."), boardIDToIndex)
+		}
+		return
 	}
+	slide := boardIDToIndex[strings.Join([]string{boardID, STEPS, diagram.Steps[0].Name}, ".")]
+	for _, dl := range diagram.Steps[1:] {
+		key := strings.Join([]string{boardID, STEPS, dl.Name}, ".")
+		index := boardIDToIndex[key]
+		for k, i := range boardIDToIndex {
+			if i > index {
+				boardIDToIndex[k] = i - 1
+			}
+		}
+		boardIDToIndex[key] = slide
+	}
+}
 
-	return dictionary
+// addTitleSlideIndexes moves the slides of boardIDToIndex after the title slide and before
+// each of the section slides of top-level layers, see pptxTitleSlides.
+func addTitleSlideIndexes(ms *xmain.State, diagram *d2target.Diagram, boardIDToIndex map[string]int) {
+	if !pptxTitleSlides(ms, diagram) {
+		return
+	}
+	for k := range boardIDToIndex {
+		boardIDToIndex[k]++
+	}
+	for _, dl := range diagram.Layers {
+		section := boardIDToIndex[strings.Join([]string{"root", LAYERS, dl.Name}, ".")]
+		for k, i := range boardIDToIndex {
+			if i >= section {
+				boardIDToIndex[k] = i + 1
+			}
+		}
+	}
+}
+
+func renderPNGsForGIF(ctx context.Context, ms *xmain.State, plugin d2plugin.Plugin, opts d2svg.RenderOpts, ruler *textmeasure.Ruler, pw *png.Playwright, inputPath string, diagram *d2target.Diagram) (svg []byte, pngs [][]byte, err error) {
+	svg, convs, err := convertGIFBoards(ctx, ms, plugin, opts, ruler, pw, inputPath, diagram)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, conv := range convs {
+		pngImg, err := waitPNG(ms, conv)
+		if err != nil {
+			return nil, nil, err
+		}
+		pngs = append(pngs, pngImg)
+	}
+	return svg, pngs, nil
 }
 
-func renderPNGsForGIF(ctx context.Context, ms *xmain.State, plugin d2plugin.Plugin, opts d2svg.RenderOpts, ruler *textmeasure.Ruler, page playwright.Page, inputPath string, diagram *d2target.Diagram) (svg []byte, pngs [][]byte, err error) {
+// convertGIFBoards renders every board of diagram and starts converting them to PNGs, which
+// run in parallel.
+func convertGIFBoards(ctx context.Context, ms *xmain.State, plugin d2plugin.Plugin, opts d2svg.RenderOpts, ruler *textmeasure.Ruler, pw *png.Playwright, inputPath string, diagram *d2target.Diagram) (svg []byte, convs []*png.Conversion, err error) {
 	if !diagram.IsFolderOnly {
+		start := time.Now()
 
 		var scale *float64
 		if opts.Scale != nil {
@@ -1285,12 +2796,14 @@ func renderPNGsForGIF(ctx context.Context, ms *xmain.State, plugin d2plugin.Plug
 		} else {
 			scale = go2.Pointer(1.)
 		}
-		svg, err = d2svg.Render(diagram, &d2svg.RenderOpts{
-			Pad:    opts.Pad,
-			Sketch: opts.Sketch,
-			Center: opts.Center,
-			Scale:  scale,
-		})
+		renderOpts := &d2svg.RenderOpts{
+			Pad:                 opts.Pad,
+			Sketch:              opts.Sketch,
+			Center:              opts.Center,
+			Scale:               scale,
+			NoElementAnimations: true,
+		}
+		svg, err = d2svg.Render(diagram, renderOpts)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -1311,45 +2824,55 @@ func renderPNGsForGIF(ctx context.Context, ms *xmain.State, plugin d2plugin.Plug
 
 		svg = appendix.Append(diagram, ruler, svg)
 
-		pngImg, err := ConvertSVG(ms, page, svg)
+		timingsFromContext(ctx).rendered(diagram, start)
+		size, err := pngSize(ms)
 		if err != nil {
 			return nil, nil, err
 		}
-		pngs = append(pngs, pngImg)
+		conv, err := rasterize(ctx, ms, pw, renderOpts, diagram, svg, size)
+		if err != nil {
+			return nil, nil, err
+		}
+		convs = append(convs, conv)
 	}
 
 	for _, dl := range diagram.Layers {
-		_, layerPNGs, err := renderPNGsForGIF(ctx, ms, plugin, opts, ruler, page, inputPath, dl)
+		_, layerConvs, err := convertGIFBoards(ctx, ms, plugin, opts, ruler, pw, inputPath, dl)
 		if err != nil {
 			return nil, nil, err
 		}
-		pngs = append(pngs, layerPNGs...)
+		convs = append(convs, layerConvs...)
 	}
 	for _, dl := range diagram.Scenarios {
-		_, scenarioPNGs, err := renderPNGsForGIF(ctx, ms, plugin, opts, ruler, page, inputPath, dl)
+		_, scenarioConvs, err := convertGIFBoards(ctx, ms, plugin, opts, ruler, pw, inputPath, dl)
 		if err != nil {
 			return nil, nil, err
 		}
-		pngs = append(pngs, scenarioPNGs...)
+		convs = append(convs, scenarioConvs...)
 	}
 	for _, dl := range diagram.Steps {
-		_, stepsPNGs, err := renderPNGsForGIF(ctx, ms, plugin, opts, ruler, page, inputPath, dl)
+		_, stepsConvs, err := convertGIFBoards(ctx, ms, plugin, opts, ruler, pw, inputPath, dl)
 		if err != nil {
 			return nil, nil, err
 		}
-		pngs = append(pngs, stepsPNGs...)
+		convs = append(convs, stepsConvs...)
 	}
 
-	return svg, pngs, nil
+	return svg, convs, nil
+}
+
+func ConvertSVG(ms *xmain.State, pw *png.Playwright, svg []byte) ([]byte, error) {
+	return waitPNG(ms, pw.Convert(svg))
 }
 
-func ConvertSVG(ms *xmain.State, page playwright.Page, svg []byte) ([]byte, error) {
+// waitPNG waits for a conversion started with png.Playwright.Convert.
+func waitPNG(ms *xmain.State, c *png.Conversion) ([]byte, error) {
 	cancel := background.Repeat(func() {
 		ms.Log.Info.Printf("converting to PNG...")
 	}, time.Second*5)
 	defer cancel()
 
-	return png.ConvertSVG(page, svg)
+	return c.Wait()
 }
 
 func AnimatePNGs(ms *xmain.State, pngs [][]byte, animIntervalMs int) ([]byte, error) {
@@ -0,0 +1,50 @@
+package d2cli
+
+import (
+	"context"
+
+	"oss.terrastruct.com/util-go/xmain"
+
+	"oss.terrastruct.com/d2/lib/pdf"
+)
+
+type pdfMergeContextKey struct{}
+
+// pdfMerge combines the PDFs of several inputs into one, with a section per input. The
+// first input sets the options of the document, and its pages are added once all inputs
+// are rendered so that the table of contents can list them all.
+type pdfMerge struct {
+	doc      *pdf.GoFPDF
+	pages    []func() error
+	sections []pdf.Section
+	themeID  int64
+	fill     string
+}
+
+func withPDFMerge(ctx context.Context, m *pdfMerge) context.Context {
+	return context.WithValue(ctx, pdfMergeContextKey{}, m)
+}
+
+// pdfMergeFromContext returns the PDF being combined into of ctx, nil when exporting a
+// single input.
+func pdfMergeFromContext(ctx context.Context) *pdfMerge {
+	m, _ := ctx.Value(pdfMergeContextKey{}).(*pdfMerge)
+	return m
+}
+
+// export adds the pages of all inputs and writes the combined PDF to outputPath.
+func (m *pdfMerge) export(ms *xmain.State, outputPath string) error {
+	if toc, _ := ms.Opts.Flags.GetBool("pdf-toc"); toc {
+		err := m.doc.AddTOCPages(m.sections, m.themeID, m.fill)
+		if err != nil {
+			return err
+		}
+	}
+	for _, addPage := range m.pages {
+		err := addPage()
+		if err != nil {
+			return err
+		}
+	}
+	return m.doc.Export(outputPath)
+}
@@ -0,0 +1,145 @@
+package d2cli
+
+import (
+	"fmt"
+	"os"
+
+	"oss.terrastruct.com/util-go/xmain"
+
+	"oss.terrastruct.com/d2/lib/png"
+)
+
+func playwrightCmd(ms *xmain.State) error {
+	args := ms.Opts.Flags.Args()[1:]
+	if len(args) == 0 {
+		return xmain.UsageErrorf("playwright subcommand must be passed one of install, status, clean or use")
+	}
+	switch args[0] {
+	case "install":
+		if len(args) > 1 {
+			return xmain.UsageErrorf("playwright install accepts no arguments")
+		}
+		return playwrightInstall(ms)
+	case "status":
+		if len(args) > 1 {
+			return xmain.UsageErrorf("playwright status accepts no arguments")
+		}
+		return playwrightStatus(ms)
+	case "clean":
+		if len(args) > 1 {
+			return xmain.UsageErrorf("playwright clean accepts no arguments")
+		}
+		return playwrightClean(ms)
+	case "use":
+		if len(args) != 2 {
+			return xmain.UsageErrorf("playwright use must be passed the path of a Chromium, Chrome or Edge executable, or default")
+		}
+		return playwrightUse(ms, args[1])
+	default:
+		return xmain.UsageErrorf("unknown playwright subcommand %q, expected one of install, status, clean or use", args[0])
+	}
+}
+
+// browserPath returns the browser set with d2 playwright use, "" to download Chromium.
+// Errors of the configuration are reported when listing plugins.
+func browserPath(ms *xmain.State) string {
+	cfg, err := readConfig(ms)
+	if err != nil {
+		return ""
+	}
+	return cfg.Browser
+}
+
+// playwrightInstall downloads Playwright and Chromium, or only Playwright with a browser set
+// with d2 playwright use, and checks that the browser launches.
+func playwrightInstall(ms *xmain.State) error {
+	browser := browserPath(ms)
+	pw, err := png.InitPlaywright(browser)
+	if err != nil {
+		return err
+	}
+	err = pw.Cleanup()
+	if err != nil {
+		return err
+	}
+	if browser != "" {
+		ms.Log.Success.Printf("installed Playwright and launched %s", humanPath(browser))
+	} else {
+		ms.Log.Success.Printf("installed Playwright and Chromium")
+	}
+	return nil
+}
+
+func playwrightStatus(ms *xmain.State) error {
+	s, err := png.Status()
+	if err != nil {
+		return err
+	}
+	installed := "not installed"
+	if s.DriverInstalled {
+		installed = "installed"
+	}
+	fmt.Fprintf(ms.Stdout, "driver: %s (%s)\n", humanPath(s.DriverDir), installed)
+	if browser := browserPath(ms); browser != "" {
+		fmt.Fprintf(ms.Stdout, "browser: %s (set with d2 playwright use)\n", humanPath(browser))
+		if _, err := os.Stat(browser); err != nil {
+			ms.Log.Warn.Printf("%s does not exist", humanPath(browser))
+		}
+	} else if len(s.Browsers) == 0 {
+		fmt.Fprintf(ms.Stdout, "browser: Chromium in %s (not installed)\n", humanPath(s.BrowsersDir))
+	} else {
+		for _, b := range s.Browsers {
+			fmt.Fprintf(ms.Stdout, "browser: %s (installed)\n", humanPath(b))
+		}
+	}
+	return nil
+}
+
+// playwrightClean removes the downloads of Playwright. Browsers set with d2 playwright use
+// are left alone.
+func playwrightClean(ms *xmain.State) error {
+	s, err := png.Status()
+	if err != nil {
+		return err
+	}
+	removed, err := png.Clean(s)
+	for _, p := range removed {
+		ms.Log.Info.Printf("removed %s", humanPath(p))
+	}
+	if err != nil {
+		return err
+	}
+	if len(removed) == 0 {
+		ms.Log.Success.Printf("Playwright is not installed")
+	} else {
+		ms.Log.Success.Printf("removed Playwright")
+	}
+	return nil
+}
+
+// playwrightUse records in the user configuration the browser to rasterize with instead of
+// downloading Chromium, or to download it again with default.
+func playwrightUse(ms *xmain.State, path string) error {
+	if path == "default" {
+		err := setConfig(ms, "browser", "")
+		if err != nil {
+			return err
+		}
+		ms.Log.Success.Printf("d2 will download Chromium to rasterize")
+		return nil
+	}
+	path = ms.AbsPath(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return xmain.UsageErrorf("playwright use must be passed the path of a Chromium, Chrome or Edge executable.\nYou provided: %s", path)
+	}
+	if info.IsDir() {
+		return xmain.UsageErrorf("playwright use must be passed the path of a Chromium, Chrome or Edge executable, not a directory.\nYou provided: %s", path)
+	}
+	err = setConfig(ms, "browser", path)
+	if err != nil {
+		return err
+	}
+	ms.Log.Success.Printf("d2 will rasterize with %s. Run d2 playwright install to check that it launches", humanPath(path))
+	return nil
+}
@@ -0,0 +1,158 @@
+package d2cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"oss.terrastruct.com/util-go/xdefer"
+	"oss.terrastruct.com/util-go/xmain"
+
+	"oss.terrastruct.com/d2/d2plugin"
+)
+
+func pluginCmd(ctx context.Context, ms *xmain.State, ps []d2plugin.Plugin) error {
+	args := ms.Opts.Flags.Args()[1:]
+	if len(args) == 0 {
+		return xmain.UsageErrorf("plugin subcommand must be passed one of list, install or remove")
+	}
+	switch args[0] {
+	case "list":
+		if len(args) > 1 {
+			return xmain.UsageErrorf("plugin list accepts no arguments")
+		}
+		return pluginList(ctx, ms, ps)
+	case "install":
+		if len(args) != 2 {
+			return xmain.UsageErrorf("plugin install must be passed the path of a plugin binary or WASM module")
+		}
+		return pluginInstall(ctx, ms, args[1])
+	case "remove":
+		if len(args) != 2 {
+			return xmain.UsageErrorf("plugin remove must be passed the name of an installed plugin")
+		}
+		return pluginRemove(ms, args[1])
+	default:
+		return xmain.UsageErrorf("unknown plugin subcommand %q, expected one of list, install or remove", args[0])
+	}
+}
+
+func pluginList(ctx context.Context, ms *xmain.State, ps []d2plugin.Plugin) error {
+	pinfos, err := d2plugin.ListPluginInfos(ctx, ps)
+	if err != nil {
+		return err
+	}
+	for _, p := range pinfos {
+		if p.Type == "bundled" {
+			fmt.Fprintf(ms.Stdout, "%s (bundled)\n", p.Name)
+		} else {
+			fmt.Fprintf(ms.Stdout, "%s (%s) %s\n", p.Name, p.Type, humanPath(p.Path))
+		}
+	}
+	return nil
+}
+
+// pluginInstall copies the plugin at fp to the directory of installed plugins under the
+// name of its plugin, replacing any plugin installed with the same name.
+func pluginInstall(ctx context.Context, ms *xmain.State, fp string) (err error) {
+	defer xdefer.Errorf(&err, "failed to install %s", fp)
+
+	fp = ms.AbsPath(fp)
+	info, err := d2plugin.Open(fp).Info(ctx)
+	if err != nil {
+		return err
+	}
+	dir, err := installedPluginsDir(ms)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	name := "d2plugin-" + info.Name
+	if strings.HasSuffix(fp, ".wasm") {
+		name += ".wasm"
+	}
+	err = removeInstalled(dir, info.Name)
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(dir, name)
+	err = copyFile(dst, fp)
+	if err != nil {
+		return err
+	}
+	checksum, err := d2plugin.Checksum(dst)
+	if err != nil {
+		return err
+	}
+	ms.Log.Success.Printf("installed %s to %s with sha256:%s", info.Name, humanPath(dst), checksum)
+	return nil
+}
+
+func pluginRemove(ms *xmain.State, name string) error {
+	dir, err := installedPluginsDir(ms)
+	if err != nil {
+		return err
+	}
+	found, err := installedPlugin(dir, name)
+	if err != nil {
+		return err
+	}
+	if found == "" {
+		return xmain.UsageErrorf("plugin %q is not installed in %s", name, humanPath(dir))
+	}
+	err = os.Remove(found)
+	if err != nil {
+		return err
+	}
+	ms.Log.Success.Printf("removed %s", name)
+	return nil
+}
+
+// installedPlugin returns the path of the plugin named name installed in dir, if any.
+func installedPlugin(dir, name string) (string, error) {
+	for _, fn := range []string{"d2plugin-" + name, "d2plugin-" + name + ".wasm"} {
+		fp := filepath.Join(dir, fn)
+		_, err := os.Stat(fp)
+		if err == nil {
+			return fp, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+func removeInstalled(dir, name string) error {
+	fp, err := installedPlugin(dir, name)
+	if err != nil || fp == "" {
+		return err
+	}
+	return os.Remove(fp)
+}
+
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
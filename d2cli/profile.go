@@ -0,0 +1,96 @@
+package d2cli
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strings"
+
+	"go.uber.org/multierr"
+
+	"oss.terrastruct.com/util-go/xmain"
+)
+
+// profileFiles are the files profiles are written to when --profile doesn't name one.
+var profileFiles = map[string]string{
+	"cpu":   "d2.cpu.pprof",
+	"mem":   "d2.mem.pprof",
+	"trace": "d2.trace",
+}
+
+// startProfiles starts the profiles of --profile, a comma separated list of cpu, mem and
+// trace, each optionally followed by =file, e.g. cpu=out.pprof,trace. The returned function
+// stops them and writes them out.
+func startProfiles(ms *xmain.State, spec string) (_ func() error, err error) {
+	type profile struct {
+		kind string
+		f    *os.File
+	}
+	var profiles []profile
+	stop := func() error {
+		var err error
+		for _, p := range profiles {
+			switch p.kind {
+			case "cpu":
+				pprof.StopCPUProfile()
+			case "mem":
+				// Up to date statistics of allocations are only collected on GC.
+				runtime.GC()
+				err = multierr.Combine(err, pprof.WriteHeapProfile(p.f))
+			case "trace":
+				trace.Stop()
+			}
+			err = multierr.Combine(err, p.f.Close())
+			ms.Log.Info.Printf("wrote %s profile to %s", p.kind, ms.HumanPath(p.f.Name()))
+		}
+		return err
+	}
+	defer func() {
+		if err != nil {
+			stop()
+		}
+	}()
+
+	type profileSpec struct {
+		kind string
+		path string
+	}
+	var specs []profileSpec
+	seen := make(map[string]bool)
+	for _, s := range strings.Split(spec, ",") {
+		kind, path, _ := strings.Cut(strings.TrimSpace(s), "=")
+		def, ok := profileFiles[kind]
+		if !ok {
+			return nil, xmain.UsageErrorf("unknown --profile %q: expected cpu, mem or trace", kind)
+		}
+		if seen[kind] {
+			return nil, xmain.UsageErrorf("--profile %s given twice", kind)
+		}
+		seen[kind] = true
+		if path == "" {
+			path = def
+		}
+		specs = append(specs, profileSpec{kind: kind, path: path})
+	}
+
+	for _, ps := range specs {
+		f, err := os.Create(ms.AbsPath(ps.path))
+		if err != nil {
+			return nil, err
+		}
+		switch ps.kind {
+		case "cpu":
+			err = pprof.StartCPUProfile(f)
+		case "trace":
+			err = trace.Start(f)
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to start %s profile: %w", ps.kind, err)
+		}
+		profiles = append(profiles, profile{kind: ps.kind, f: f})
+	}
+	return stop, nil
+}
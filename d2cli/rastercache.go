@@ -0,0 +1,234 @@
+package d2cli
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"oss.terrastruct.com/util-go/xdefer"
+	"oss.terrastruct.com/util-go/xmain"
+
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/png"
+	"oss.terrastruct.com/d2/lib/version"
+)
+
+type rasterCacheContextKey struct{}
+
+// rasterCache lets --incremental exports reuse the PNGs of the boards that did not change
+// since the last export to the same output, so that re-exporting a deck of many boards
+// after editing one only rasterizes that one.
+//
+// The PNGs are stored by the hash of the SVG they were rasterized from. The manifest of
+// every output records the hashes of its boards, to compare with on the next export and
+// to remove the PNGs no longer used by it.
+type rasterCache struct {
+	dir          string
+	manifestPath string
+	prev         rasterManifest
+
+	mu     sync.Mutex
+	next   rasterManifest
+	boards map[*d2target.Diagram]string
+	// pending are the conversions to store once done, by hash.
+	pending map[string]*png.Conversion
+	reused  int
+}
+
+type rasterManifest struct {
+	// Boards maps the paths of boards to the hashes of their SVGs.
+	Boards map[string]string `json:"boards"`
+}
+
+// cacheDir returns $D2_CACHE_DIR, which defaults to d2 in the user cache directory, e.g.
+// $XDG_CACHE_HOME/d2.
+func cacheDir(ms *xmain.State) (string, error) {
+	if dir := ms.Env.Getenv("D2_CACHE_DIR"); dir != "" {
+		return ms.AbsPath(dir), nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "d2"), nil
+}
+
+// rasterCacheDir returns $D2_CACHE_DIR/rasters.
+func rasterCacheDir(ms *xmain.State) (string, error) {
+	dir, err := cacheDir(ms)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "rasters"), nil
+}
+
+// openRasterCache reads the manifest of the last export to outputPath, if any.
+func openRasterCache(ms *xmain.State, outputPath string) (_ *rasterCache, err error) {
+	defer xdefer.Errorf(&err, "failed to open raster cache")
+
+	dir, err := rasterCacheDir(ms)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256([]byte(ms.AbsPath(outputPath)))
+	rc := &rasterCache{
+		dir:          dir,
+		manifestPath: filepath.Join(dir, hex.EncodeToString(h[:8])+".manifest.json"),
+		next: rasterManifest{
+			Boards: make(map[string]string),
+		},
+		boards:  make(map[*d2target.Diagram]string),
+		pending: make(map[string]*png.Conversion),
+	}
+
+	b, err := os.ReadFile(rc.manifestPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return rc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &rc.prev)
+	if err != nil {
+		// A corrupt manifest only loses the PNGs it records.
+		ms.Log.Warn.Printf("ignoring corrupt raster cache manifest %s: %v", rc.manifestPath, err)
+		rc.prev = rasterManifest{}
+	}
+	return rc, nil
+}
+
+func withRasterCache(ctx context.Context, rc *rasterCache) context.Context {
+	return context.WithValue(ctx, rasterCacheContextKey{}, rc)
+}
+
+// rasterCacheFromContext returns the raster cache of ctx, nil without --incremental. All
+// methods of rasterCache work on nil, without caching.
+func rasterCacheFromContext(ctx context.Context) *rasterCache {
+	rc, _ := ctx.Value(rasterCacheContextKey{}).(*rasterCache)
+	return rc
+}
+
+// indexBoards records the paths of the boards of diagram, the root, which identify them
+// across exports.
+func (rc *rasterCache) indexBoards(diagram *d2target.Diagram) {
+	if rc == nil {
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for d, path := range boardPaths(diagram) {
+		rc.boards[d] = path
+	}
+}
+
+// convert starts converting svg, the render of diagram, into a PNG unless the board
+// rendered to the same SVG in the last export.
+func (rc *rasterCache) convert(pw *png.Playwright, diagram *d2target.Diagram, svg []byte, size png.Size) *png.Conversion {
+	if rc == nil {
+		return pw.ConvertSize(svg, size)
+	}
+	key := rasterKey(svg, size)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	path, ok := rc.boards[diagram]
+	if !ok {
+		return pw.ConvertSize(svg, size)
+	}
+	rc.next.Boards[path] = key
+	if c, ok := rc.pending[key]; ok {
+		return c
+	}
+	if rc.prev.Boards[path] == key {
+		b, err := os.ReadFile(rc.pngPath(key))
+		if err == nil {
+			rc.reused++
+			return png.Converted(b)
+		}
+	}
+	c := pw.ConvertSize(svg, size)
+	rc.pending[key] = c
+	return c
+}
+
+// rasterKey returns the hash of svg the PNG of size rasterized from it is stored by. Versions
+// of d2 may rasterize differently.
+func rasterKey(svg []byte, size png.Size) string {
+	hash := sha256.New()
+	hash.Write([]byte(version.Version))
+	hash.Write([]byte{0})
+	if size != (png.Size{}) {
+		fmt.Fprintf(hash, "%v", size)
+		hash.Write([]byte{0})
+	}
+	hash.Write(svg)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (rc *rasterCache) pngPath(key string) string {
+	return filepath.Join(rc.dir, key+".png")
+}
+
+// save stores the PNGs converted, writes the manifest of the export and removes the PNGs
+// of the last export that are no longer used. It must only be called once the export
+// succeeded.
+func (rc *rasterCache) save(ms *xmain.State) (err error) {
+	if rc == nil {
+		return nil
+	}
+	defer xdefer.Errorf(&err, "failed to save raster cache")
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	for key, c := range rc.pending {
+		b, err := c.Wait()
+		if err != nil {
+			continue
+		}
+		err = os.WriteFile(rc.pngPath(key), b, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	used := make(map[string]bool, len(rc.next.Boards))
+	for _, key := range rc.next.Boards {
+		used[key] = true
+	}
+	for _, key := range rc.prev.Boards {
+		if !used[key] {
+			os.Remove(rc.pngPath(key))
+		}
+	}
+
+	b, err := json.Marshal(rc.next)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(rc.manifestPath, b, 0644)
+	if err != nil {
+		return err
+	}
+	ms.Log.Debug.Printf("reused %d of %d rasterized boards", rc.reused, len(rc.next.Boards))
+	return nil
+}
+
+// convertPNGSize starts converting svg, the render of diagram, into a PNG of size with the
+// browser, reusing that of the last export with --incremental.
+func convertPNGSize(ctx context.Context, pw *png.Playwright, diagram *d2target.Diagram, svg []byte, size png.Size) *png.Conversion {
+	c := rasterCacheFromContext(ctx).convert(pw, diagram, svg, size)
+	timingsFromContext(ctx).rasterizing(diagram, c)
+	return c
+}
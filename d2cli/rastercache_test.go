@@ -0,0 +1,80 @@
+package d2cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/util-go/cmdlog"
+	"oss.terrastruct.com/util-go/xmain"
+	"oss.terrastruct.com/util-go/xos"
+
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/png"
+)
+
+func TestRasterCache(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	env := xos.NewEnv([]string{"D2_CACHE_DIR=" + dir})
+	ms := &xmain.State{
+		Env: env,
+		Log: cmdlog.NewTB(env, t),
+		PWD: dir,
+	}
+
+	root := d2target.NewDiagram()
+	layer := d2target.NewDiagram()
+	layer.Name = "x"
+	root.Layers = append(root.Layers, layer)
+
+	rootSVG := []byte(`<svg>root</svg>`)
+	layerSVG := []byte(`<svg>x</svg>`)
+
+	// Seed the cache as if both boards were exported to out.pdf before.
+	rc, err := openRasterCache(ms, "out.pdf")
+	assert.Nil(t, err)
+	err = os.WriteFile(rc.pngPath(rasterKey(rootSVG, png.Size{})), []byte("root png"), 0644)
+	assert.Nil(t, err)
+	err = os.WriteFile(rc.pngPath(rasterKey(layerSVG, png.Size{})), []byte("x png"), 0644)
+	assert.Nil(t, err)
+	manifest, err := json.Marshal(rasterManifest{
+		Boards: map[string]string{
+			"root":          rasterKey(rootSVG, png.Size{}),
+			"root.layers.x": rasterKey(layerSVG, png.Size{}),
+		},
+	})
+	assert.Nil(t, err)
+	err = os.WriteFile(rc.manifestPath, manifest, 0644)
+	assert.Nil(t, err)
+
+	rc, err = openRasterCache(ms, "out.pdf")
+	assert.Nil(t, err)
+	rc.indexBoards(root)
+	// Unchanged boards never reach the browser, which is nil here.
+	b, err := rc.convert(nil, root, rootSVG, png.Size{}).Wait()
+	assert.Nil(t, err)
+	assert.Equal(t, "root png", string(b))
+
+	// The layer was removed so its PNG is no longer used.
+	root.Layers = nil
+	err = rc.save(ms)
+	assert.Nil(t, err)
+	_, err = os.Stat(rc.pngPath(rasterKey(rootSVG, png.Size{})))
+	assert.Nil(t, err)
+	_, err = os.Stat(rc.pngPath(rasterKey(layerSVG, png.Size{})))
+	assert.True(t, os.IsNotExist(err))
+
+	manifest, err = os.ReadFile(rc.manifestPath)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"boards":{"root":"`+rasterKey(rootSVG, png.Size{})+`"}}`, string(manifest))
+
+	// Other outputs have their own manifests.
+	rc, err = openRasterCache(ms, filepath.Join("other", "out.pdf"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(rc.prev.Boards))
+}
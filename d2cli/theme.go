@@ -0,0 +1,110 @@
+package d2cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"oss.terrastruct.com/util-go/xdefer"
+	"oss.terrastruct.com/util-go/xmain"
+
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2parser"
+	"oss.terrastruct.com/d2/d2themes"
+	"oss.terrastruct.com/d2/d2themes/d2themescatalog"
+)
+
+// loadThemeFile reads the theme of a .json file, or of a .d2 file with the same keys, and
+// registers it in the catalog.
+func loadThemeFile(ms *xmain.State, path string) (_ d2themes.Theme, err error) {
+	defer xdefer.Errorf(&err, "failed to load theme file %s", path)
+
+	b, err := ms.ReadPath(ms.AbsPath(path))
+	if err != nil {
+		return d2themes.Theme{}, err
+	}
+	switch filepath.Ext(path) {
+	case ".json":
+	case ".d2":
+		ast, err := d2parser.Parse(path, bytes.NewReader(b), nil)
+		if err != nil {
+			return d2themes.Theme{}, err
+		}
+		b, err = json.Marshal(d2ASTToJSON(ast))
+		if err != nil {
+			return d2themes.Theme{}, err
+		}
+	default:
+		return d2themes.Theme{}, fmt.Errorf("expected .json or .d2 file but got extension %s", filepath.Ext(path))
+	}
+
+	var probe struct {
+		ID *int64 `json:"id"`
+	}
+	err = json.Unmarshal(b, &probe)
+	if err != nil {
+		return d2themes.Theme{}, err
+	}
+	if probe.ID == nil {
+		return d2themes.Theme{}, errors.New("missing id")
+	}
+	var theme d2themes.Theme
+	err = json.Unmarshal(b, &theme)
+	if err != nil {
+		return d2themes.Theme{}, err
+	}
+	err = d2themescatalog.Register(theme)
+	if err != nil {
+		return d2themes.Theme{}, err
+	}
+	ms.Log.Debug.Printf("registered theme %s (ID: %d) from %s", theme.Name, theme.ID, ms.HumanPath(path))
+	return theme, nil
+}
+
+// d2ASTToJSON returns the JSON value of the keys and scalars of m, e.g. to decode a theme
+// written in D2 as JSON.
+func d2ASTToJSON(m *d2ast.Map) map[string]interface{} {
+	v := make(map[string]interface{})
+	for _, n := range m.Nodes {
+		k := n.MapKey
+		if k == nil || k.Key == nil || len(k.Edges) > 0 {
+			continue
+		}
+		// Nested paths such as colors.b1 set the keys of nested objects.
+		obj := v
+		path := k.Key.Path
+		for _, p := range path[:len(path)-1] {
+			name := p.Unbox().ScalarString()
+			child, ok := obj[name].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				obj[name] = child
+			}
+			obj = child
+		}
+		name := path[len(path)-1].Unbox().ScalarString()
+		switch {
+		case k.Value.Map != nil:
+			child, ok := obj[name].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				obj[name] = child
+			}
+			for ck, cv := range d2ASTToJSON(k.Value.Map) {
+				child[ck] = cv
+			}
+		case k.Value.Unbox() != nil:
+			switch sv := k.Value.Unbox().(type) {
+			case *d2ast.Number:
+				obj[name] = json.Number(sv.Raw)
+			case *d2ast.Boolean:
+				obj[name] = sv.Value
+			case d2ast.String:
+				obj[name] = sv.ScalarString()
+			}
+		}
+	}
+	return v
+}
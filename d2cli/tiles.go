@@ -0,0 +1,167 @@
+package d2cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"oss.terrastruct.com/util-go/xdefer"
+	"oss.terrastruct.com/util-go/xmain"
+
+	"oss.terrastruct.com/d2/d2renderers/d2svg"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/png"
+)
+
+// tileManifest describes the tiles of a PNG export split with --png-tile-size.
+type tileManifest struct {
+	// Width and Height are the dimensions of the whole PNG in pixels.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// TileSize is the width and height of the tiles. Tiles on the right and bottom edges are
+	// transparent past the PNG.
+	TileSize int `json:"tileSize"`
+	Columns  int `json:"columns"`
+	Rows     int `json:"rows"`
+	// Tiles is the path of the tiles relative to the manifest, {x} and {y} being their column
+	// and row.
+	Tiles string `json:"tiles"`
+}
+
+// pngTileSize returns the size of the tiles of PNG exports set by the flags, 0 for single PNGs.
+func pngTileSize(ms *xmain.State) (int, error) {
+	tileSize, _ := ms.Opts.Flags.GetInt64("png-tile-size")
+	if tileSize < 0 {
+		return 0, xmain.UsageErrorf("--png-tile-size must be 0 or greater.\nYou provided: %d", tileSize)
+	}
+	return int(tileSize), nil
+}
+
+// writePNGTiles writes the PNG of size of diagram, rendered as svg with opts, as tiles of
+// tileSize pixels to the directory of outputPath without its extension, along with their
+// manifest and an index.html viewing them with Leaflet, for boards too large for one PNG.
+func writePNGTiles(ctx context.Context, ms *xmain.State, pw *png.Playwright, opts *d2svg.RenderOpts, diagram *d2target.Diagram, svg []byte, size png.Size, tileSize int, outputPath string) (err error) {
+	defer xdefer.Errorf(&err, "failed to write PNG tiles")
+
+	if outputPath == "-" {
+		return xmain.UsageErrorf("--png-tile-size cannot be used with output to stdout")
+	}
+	width, height, err := png.FullSize(svg, size)
+	if err != nil {
+		return err
+	}
+	m := tileManifest{
+		Width:    width,
+		Height:   height,
+		TileSize: tileSize,
+		Columns:  (width + tileSize - 1) / tileSize,
+		Rows:     (height + tileSize - 1) / tileSize,
+		Tiles:    "tiles/{x}/{y}.png",
+	}
+
+	native := rasterNative(ms, opts, diagram)
+	if !native {
+		err = launchPlaywright(ms, pw)
+		if err != nil {
+			return err
+		}
+	}
+	t := timingsFromContext(ctx)
+	convs := make([]*png.Conversion, 0, m.Columns*m.Rows)
+	for x := 0; x < m.Columns; x++ {
+		for y := 0; y < m.Rows; y++ {
+			tile := size
+			tile.Region = image.Rect(x*tileSize, y*tileSize, (x+1)*tileSize, (y+1)*tileSize)
+			var conv *png.Conversion
+			if native {
+				conv = convertNative(ctx, opts, diagram, tile)
+			} else {
+				conv = pw.ConvertSize(svg, tile)
+				t.rasterizing(diagram, conv)
+			}
+			convs = append(convs, conv)
+		}
+	}
+
+	dir := strings.TrimSuffix(outputPath, filepath.Ext(outputPath))
+	err = os.RemoveAll(filepath.Join(dir, "tiles"))
+	if err != nil {
+		return err
+	}
+	for x := 0; x < m.Columns; x++ {
+		err = os.MkdirAll(filepath.Join(dir, "tiles", fmt.Sprint(x)), 0755)
+		if err != nil {
+			return err
+		}
+		for y := 0; y < m.Rows; y++ {
+			out, err := waitPNG(ms, convs[x*m.Rows+y])
+			if err != nil {
+				return err
+			}
+			err = ms.WritePath(filepath.Join(dir, "tiles", fmt.Sprint(x), fmt.Sprintf("%d.png", y)), out)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ms.WritePath(filepath.Join(dir, "manifest.json"), append(b, '\n'))
+	if err != nil {
+		return err
+	}
+	title := diagram.Root.Label
+	if title == "" {
+		title = filepath.Base(dir)
+	}
+	err = ms.WritePath(filepath.Join(dir, "index.html"), []byte(tileViewer(title, m)))
+	if err != nil {
+		return err
+	}
+	ms.Log.Info.Printf("wrote %d PNG tiles of %dx%d to %s", len(convs), width, height, ms.HumanPath(dir))
+	return nil
+}
+
+// tileViewer returns an HTML page viewing the tiles of m with Leaflet. The manifest is
+// inlined as pages opened from files cannot fetch it.
+func tileViewer(title string, m tileManifest) string {
+	manifest, _ := json.Marshal(m)
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<title>%s</title>
+	<link rel="stylesheet" href="https://unpkg.com/leaflet@1.9.4/dist/leaflet.css">
+	<script src="https://unpkg.com/leaflet@1.9.4/dist/leaflet.js"></script>
+	<style>html, body, #map { height: 100%%; margin: 0; background: #fff; }</style>
+</head>
+<body>
+	<div id="map"></div>
+	<script>
+		const manifest = %s;
+		const map = L.map("map", { crs: L.CRS.Simple, minZoom: -8, maxZoom: 3, zoomSnap: 0.25 });
+		const bounds = L.latLngBounds(map.unproject([0, manifest.height], 0), map.unproject([manifest.width, 0], 0));
+		L.tileLayer(manifest.tiles, {
+			tileSize: manifest.tileSize,
+			minNativeZoom: 0,
+			maxNativeZoom: 0,
+			minZoom: -8,
+			maxZoom: 3,
+			bounds: bounds,
+			noWrap: true,
+		}).addTo(map);
+		map.fitBounds(bounds);
+	</script>
+</body>
+</html>
+`, html.EscapeString(title), manifest)
+}
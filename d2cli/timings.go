@@ -0,0 +1,168 @@
+package d2cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"oss.terrastruct.com/d2/d2lib"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/png"
+)
+
+type timingsContextKey struct{}
+
+// timings collects how long every stage of every board of a compilation takes, for
+// --timings.
+type timings struct {
+	mu sync.Mutex
+
+	start time.Time
+	// last is when the last stage reported by d2lib ended.
+	last  time.Time
+	parse time.Duration
+
+	boards  []*boardTimings
+	byPath  map[string]*boardTimings
+	byBoard map[*d2target.Diagram]*boardTimings
+}
+
+type boardTimings struct {
+	path    string
+	measure time.Duration
+	layout  time.Duration
+	export  time.Duration
+	render  time.Duration
+	rasters []*png.Conversion
+}
+
+func newTimings(start time.Time) *timings {
+	return &timings{
+		start:   start,
+		last:    start,
+		byPath:  make(map[string]*boardTimings),
+		byBoard: make(map[*d2target.Diagram]*boardTimings),
+	}
+}
+
+func withTimings(ctx context.Context, t *timings) context.Context {
+	return context.WithValue(ctx, timingsContextKey{}, t)
+}
+
+// timingsFromContext returns the timings of ctx, nil if --timings wasn't passed. All
+// methods of timings are no-ops on nil.
+func timingsFromContext(ctx context.Context) *timings {
+	t, _ := ctx.Value(timingsContextKey{}).(*timings)
+	return t
+}
+
+func (t *timings) board(path string) *boardTimings {
+	b, ok := t.byPath[path]
+	if !ok {
+		b = &boardTimings{path: path}
+		t.byPath[path] = b
+		t.boards = append(t.boards, b)
+	}
+	return b
+}
+
+// progress is d2lib.CompileOptions.OnProgress. Every stage of a board lasts from the end
+// of the previous stage reported, as boards are compiled one after the other.
+func (t *timings) progress(p d2lib.Progress) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	dur := now.Sub(t.last)
+	t.last = now
+	if p.Stage == d2lib.ProgressParsed {
+		t.parse += dur
+		return
+	}
+	b := t.board(strings.Join(append([]string{"root"}, p.BoardPath...), "."))
+	switch p.Stage {
+	case d2lib.ProgressMeasured:
+		b.measure += dur
+	case d2lib.ProgressLaidOut:
+		b.layout += dur
+	case d2lib.ProgressExported:
+		b.export += dur
+	}
+}
+
+// indexBoards maps the boards of diagram, the root, to the paths progress reported them
+// with, for the stages after compilation that only know of boards.
+func (t *timings) indexBoards(diagram *d2target.Diagram) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for d, path := range boardPaths(diagram) {
+		t.byBoard[d] = t.board(path)
+	}
+}
+
+// rendered adds the time since start to the render time of diagram.
+func (t *timings) rendered(diagram *d2target.Diagram, start time.Time) {
+	if t == nil {
+		return
+	}
+	dur := time.Since(start)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if b, ok := t.byBoard[diagram]; ok {
+		b.render += dur
+	}
+}
+
+// rasterizing adds the time c takes to the raster time of diagram. It is only read once
+// all boards are done so it doesn't wait for c.
+func (t *timings) rasterizing(diagram *d2target.Diagram, c *png.Conversion) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if b, ok := t.byBoard[diagram]; ok {
+		b.rasters = append(b.rasters, c)
+	}
+}
+
+// write writes the summary of t to w. Boards rendered in parallel make the total less
+// than the sum of the stages.
+func (t *timings) write(w io.Writer) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "board\tmeasure\tlayout\texport\trender\traster\n")
+	for _, b := range t.boards {
+		var raster time.Duration
+		for _, c := range b.rasters {
+			raster += c.Duration()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", b.path, formatTiming(b.measure), formatTiming(b.layout), formatTiming(b.export), formatTiming(b.render), formatTiming(raster))
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "parse: %s, total: %s\n", formatTiming(t.parse), formatTiming(time.Since(t.start)))
+	return err
+}
+
+func formatTiming(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+}
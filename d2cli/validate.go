@@ -0,0 +1,147 @@
+package d2cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"oss.terrastruct.com/util-go/xdefer"
+
+	"oss.terrastruct.com/util-go/xmain"
+
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2parser"
+	"oss.terrastruct.com/d2/d2plugin"
+)
+
+// validateCmd compiles the passed files without laying them out or rendering them and
+// prints every error found, to lint diagrams in CI without the cost of rendering.
+func validateCmd(ctx context.Context, ms *xmain.State, plugins []d2plugin.Plugin, format string) (err error) {
+	defer xdefer.Errorf(&err, "failed to validate")
+
+	args := ms.Opts.Flags.Args()[1:]
+	if len(args) == 0 {
+		return xmain.UsageErrorf("validate must be passed at least one file to be validated")
+	}
+	switch format {
+	case "", "text", "json":
+	default:
+		return xmain.UsageErrorf("validate --format must be text or json, got %q", format)
+	}
+
+	// Imports may be resolved by plugins.
+	fs, err := d2plugin.ImportFS(ctx, plugins, nil)
+	if err != nil {
+		return err
+	}
+
+	diags := []diagnostic{}
+	invalid := 0
+	for _, inputPath := range args {
+		if inputPath != "-" {
+			inputPath = ms.AbsPath(inputPath)
+			d, err := os.Stat(inputPath)
+			if err == nil && d.IsDir() {
+				inputPath = filepath.Join(inputPath, "index.d2")
+			}
+		}
+
+		var fileDiags []diagnostic
+		input, err := ms.ReadPath(inputPath)
+		if err != nil {
+			fileDiags = []diagnostic{{
+				Path:     ms.HumanPath(inputPath),
+				Severity: "error",
+				Message:  err.Error(),
+			}}
+		} else {
+			_, _, err = d2compiler.Compile(inputPath, bytes.NewReader(input), &d2compiler.CompileOptions{
+				FS: fs,
+			})
+			fileDiags = toDiagnostics(ms, inputPath, err)
+		}
+		if len(fileDiags) > 0 {
+			invalid++
+		}
+		diags = append(diags, fileDiags...)
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(ms.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(diags)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, d := range diags {
+			fmt.Fprintln(ms.Stdout, d)
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d files are invalid", invalid, len(args))
+	}
+	return nil
+}
+
+// diagnostic is an error reported by validate. Lines and columns start at 1 and are 0 for
+// errors without a position, like a file that cannot be read.
+type diagnostic struct {
+	Path      string `json:"path"`
+	Line      int    `json:"line,omitempty"`
+	Column    int    `json:"column,omitempty"`
+	EndLine   int    `json:"endLine,omitempty"`
+	EndColumn int    `json:"endColumn,omitempty"`
+	Severity  string `json:"severity"`
+	Message   string `json:"message"`
+}
+
+func (d diagnostic) String() string {
+	if d.Line == 0 {
+		return fmt.Sprintf("%s: %s", d.Path, d.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", d.Path, d.Line, d.Column, d.Message)
+}
+
+// toDiagnostics returns the diagnostics of err, the error of compiling the file at
+// inputPath. Errors within imported files are reported at their position in them.
+func toDiagnostics(ms *xmain.State, inputPath string, err error) []diagnostic {
+	if err == nil {
+		return nil
+	}
+	var pe *d2parser.ParseError
+	if !errors.As(err, &pe) {
+		return []diagnostic{{
+			Path:     ms.HumanPath(inputPath),
+			Severity: "error",
+			Message:  err.Error(),
+		}}
+	}
+	diags := make([]diagnostic, 0, len(pe.Errors))
+	for _, e := range pe.Errors {
+		diags = append(diags, toDiagnostic(ms, inputPath, e))
+	}
+	return diags
+}
+
+func toDiagnostic(ms *xmain.State, inputPath string, e d2ast.Error) diagnostic {
+	path := e.Range.Path
+	if path == "" {
+		path = inputPath
+	}
+	return diagnostic{
+		Path:      ms.HumanPath(path),
+		Line:      e.Range.Start.Line + 1,
+		Column:    e.Range.Start.Column + 1,
+		EndLine:   e.Range.End.Line + 1,
+		EndColumn: e.Range.End.Column + 1,
+		Severity:  "error",
+		Message:   strings.TrimPrefix(e.Message, e.Range.String()+": "),
+	}
+}
@@ -27,10 +27,13 @@ import (
 
 	"oss.terrastruct.com/util-go/xmain"
 
+	"oss.terrastruct.com/d2/d2lib"
 	"oss.terrastruct.com/d2/d2plugin"
 	"oss.terrastruct.com/d2/d2renderers/d2fonts"
 	"oss.terrastruct.com/d2/d2renderers/d2svg"
+	"oss.terrastruct.com/d2/lib/imgbundler"
 	"oss.terrastruct.com/d2/lib/png"
+	"oss.terrastruct.com/d2/lib/textmeasure"
 )
 
 // Enabled with the build tag "dev".
@@ -44,19 +47,26 @@ var staticFS embed.FS
 
 type watcherOpts struct {
 	layout          *string
+	router          string
+	mutator         string
 	plugins         []d2plugin.Plugin
 	renderOpts      d2svg.RenderOpts
 	animateInterval int64
 	host            string
 	port            string
 	inputPath       string
-	outputPath      string
+	outputPaths     []string
 	boardPath       string
 	pwd             string
 	bundle          bool
 	forceAppendix   bool
 	pw              png.Playwright
 	fontFamily      *d2fonts.FontFamily
+	// measureCache is shared by every compilation.
+	measureCache *textmeasure.Cache
+	// layoutCache is shared by every compilation so that only the boards that changed are
+	// laid out again.
+	layoutCache *d2lib.LayoutCache
 }
 
 type watcher struct {
@@ -397,6 +407,16 @@ func (w *watcher) replaceWatchList(ctx context.Context, paths []string) error {
 	return nil
 }
 
+// requiresBrowser returns whether any output of w is rasterized by the browser.
+func (w *watcher) requiresBrowser() bool {
+	for _, p := range w.outputPaths {
+		if filepath.Ext(p) == ".png" || filepath.Ext(p) == ".pdf" {
+			return true
+		}
+	}
+	return false
+}
+
 func (w *watcher) compileLoop(ctx context.Context) error {
 	firstCompile := true
 	for {
@@ -411,7 +431,7 @@ func (w *watcher) compileLoop(ctx context.Context) error {
 			recompiledPrefix = "re"
 		}
 
-		if (filepath.Ext(w.outputPath) == ".png" || filepath.Ext(w.outputPath) == ".pdf") && !w.pw.Browser.IsConnected() {
+		if w.requiresBrowser() && !w.pw.Browser.IsConnected() {
 			newPW, err := w.pw.RestartBrowser()
 			if err != nil {
 				broadcastErr := fmt.Errorf("issue encountered with PNG exporter: %w", err)
@@ -430,7 +450,14 @@ func (w *watcher) compileLoop(ctx context.Context) error {
 		if w.boardPath != "" {
 			boardPath = strings.Split(w.boardPath, string(os.PathSeparator))
 		}
-		svg, _, err := compile(ctx, w.ms, w.plugins, &fs, w.layout, w.renderOpts, w.fontFamily, w.animateInterval, w.inputPath, w.outputPath, boardPath, false, w.bundle, w.forceAppendix, w.pw.Page)
+		compileCtx := imgbundler.WithReadFiles(ctx, fs.track)
+		svg, _, err := compile(compileCtx, w.ms, w.plugins, &fs, w.layout, w.router, w.mutator, w.renderOpts, w.fontFamily, w.animateInterval, w.inputPath, w.outputPaths, boardPath, false, w.bundle, w.forceAppendix, &w.pw, w.measureCache, w.layoutCache)
+		var notFound boardNotFoundError
+		if errors.As(err, &notFound) {
+			// Links that aren't boards are followed like URLs, which may be local pages.
+			w.ms.Log.Warn.Printf("%v, rendering the root board", err)
+			svg, _, err = compile(compileCtx, w.ms, w.plugins, &fs, w.layout, w.router, w.mutator, w.renderOpts, w.fontFamily, w.animateInterval, w.inputPath, w.outputPaths, nil, false, w.bundle, w.forceAppendix, &w.pw, w.measureCache, w.layoutCache)
+		}
 		w.boardpathMu.Unlock()
 		errs := ""
 		if err != nil {
@@ -442,7 +469,7 @@ func (w *watcher) compileLoop(ctx context.Context) error {
 			errs = err.Error()
 			w.ms.Log.Error.Print(errs)
 		}
-		err = w.replaceWatchList(ctx, fs.opened)
+		err = w.replaceWatchList(ctx, fs.paths())
 		if err != nil {
 			return err
 		}
@@ -512,7 +539,7 @@ func (w *watcher) handleRoot(hw http.ResponseWriter, r *http.Request) {
 	<div id="d2-err" style="display: none"></div>
 	<div id="d2-svg-container"></div>
 </body>
-</html>`, filepath.Base(w.outputPath), w.devMode)
+</html>`, filepath.Base(w.outputPaths[0]), w.devMode)
 
 	w.boardpathMu.Lock()
 	// if path is "/x.svg", we just want "x"
@@ -653,15 +680,30 @@ func wsHeartbeat(ctx context.Context, c *websocket.Conn) {
 	}
 }
 
-// trackedFS is OS's FS with the addition that it tracks which files are opened successfully
+// trackedFS is OS's FS with the addition that it tracks which files are opened successfully,
+// along with the local images read while rendering
 type trackedFS struct {
+	mu     sync.Mutex
 	opened []string
 }
 
 func (tfs *trackedFS) Open(name string) (fs.File, error) {
 	f, err := os.Open(name)
 	if err == nil {
-		tfs.opened = append(tfs.opened, name)
+		tfs.track(name)
 	}
 	return f, err
 }
+
+// track records path as opened. Images are bundled concurrently.
+func (tfs *trackedFS) track(path string) {
+	tfs.mu.Lock()
+	defer tfs.mu.Unlock()
+	tfs.opened = append(tfs.opened, path)
+}
+
+func (tfs *trackedFS) paths() []string {
+	tfs.mu.Lock()
+	defer tfs.mu.Unlock()
+	return tfs.opened
+}
@@ -6,6 +6,9 @@ import (
 	"io"
 	"io/fs"
 	"net/url"
+	"os"
+	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -16,8 +19,11 @@ import (
 	"oss.terrastruct.com/d2/d2graph"
 	"oss.terrastruct.com/d2/d2ir"
 	"oss.terrastruct.com/d2/d2parser"
+	"oss.terrastruct.com/d2/d2renderers/d2latex"
 	"oss.terrastruct.com/d2/d2target"
 	"oss.terrastruct.com/d2/lib/color"
+	"oss.terrastruct.com/d2/lib/shape"
+	"oss.terrastruct.com/d2/lib/svg"
 	"oss.terrastruct.com/d2/lib/textmeasure"
 )
 
@@ -39,6 +45,15 @@ func Compile(p string, r io.Reader, opts *CompileOptions) (*d2graph.Graph, *d2ta
 	if err != nil {
 		return nil, nil, err
 	}
+	return CompileAST(ast, opts)
+}
+
+// CompileAST compiles ast, as parsed by d2parser.Parse. The returned graph references the
+// nodes of ast, e.g. for d2oracle to edit ast through it.
+func CompileAST(ast *d2ast.Map, opts *CompileOptions) (*d2graph.Graph, *d2target.Config, error) {
+	if opts == nil {
+		opts = &CompileOptions{}
+	}
 
 	ir, _, err := d2ir.Compile(ast, &d2ir.CompileOptions{
 		UTF16Pos: opts.UTF16Pos,
@@ -48,7 +63,7 @@ func Compile(p string, r io.Reader, opts *CompileOptions) (*d2graph.Graph, *d2ta
 		return nil, nil, err
 	}
 
-	g, err := compileIR(ast, ir)
+	g, err := compileIR(ast, ir, opts.FS)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -59,12 +74,18 @@ func Compile(p string, r io.Reader, opts *CompileOptions) (*d2graph.Graph, *d2ta
 	if err != nil {
 		return nil, nil, err
 	}
+	err = compileLatex(g, config)
+	if err != nil {
+		return nil, nil, err
+	}
 	return g, config, nil
 }
 
-func compileIR(ast *d2ast.Map, m *d2ir.Map) (*d2graph.Graph, error) {
+func compileIR(ast *d2ast.Map, m *d2ir.Map, fsys fs.FS) (*d2graph.Graph, error) {
 	c := &compiler{
-		err: &d2parser.ParseError{},
+		err:       &d2parser.ParseError{},
+		inputPath: ast.Range.Path,
+		fs:        fsys,
 	}
 
 	g := d2graph.NewGraph()
@@ -117,7 +138,11 @@ func (c *compiler) compileBoardsField(g *d2graph.Graph, ir *d2ir.Map, fieldName
 			continue
 		}
 		if g.GetBoard(f.Name) != nil {
-			c.errorf(f.References[0].AST(), "board name %v already used by another board", f.Name)
+			var fixes []d2ast.Fix
+			if len(f.References) == 1 {
+				fixes = removeFix(fmt.Sprintf("Remove duplicate board %q", f.Name), f.References[0].Context_.Key)
+			}
+			c.errorfWithFixes(f.References[0].AST(), fixes, "board name %v already used by another board", f.Name)
 			continue
 		}
 		g2 := d2graph.NewGraph()
@@ -202,16 +227,26 @@ func findFieldAST(ast *d2ast.Map, f *d2ir.Field) *d2ast.Map {
 
 type compiler struct {
 	err *d2parser.ParseError
+	// inputPath is the path of the file compiled, which imports the others.
+	inputPath string
+	// fs is the file system SVG files of custom shapes are read from, the OS one if nil.
+	fs fs.FS
 }
 
 func (c *compiler) errorf(n d2ast.Node, f string, v ...interface{}) {
+	c.errorfWithFixes(n, nil, f, v...)
+}
+
+// errorfWithFixes is errorf with fixes that resolve the error attached.
+func (c *compiler) errorfWithFixes(n d2ast.Node, fixes []d2ast.Fix, f string, v ...interface{}) {
 	err := d2parser.Errorf(n, f, v...).(d2ast.Error)
+	err.Fixes = fixes
 	if c.err.ErrorsLookup == nil {
-		c.err.ErrorsLookup = make(map[d2ast.Error]struct{})
+		c.err.ErrorsLookup = make(map[string]struct{})
 	}
-	if _, ok := c.err.ErrorsLookup[err]; !ok {
+	if _, ok := c.err.ErrorsLookup[err.Message]; !ok {
 		c.err.Errors = append(c.err.Errors, err)
-		c.err.ErrorsLookup[err] = struct{}{}
+		c.err.ErrorsLookup[err.Message] = struct{}{}
 	}
 }
 
@@ -219,13 +254,19 @@ func (c *compiler) compileMap(obj *d2graph.Object, m *d2ir.Map) {
 	class := m.GetField("class")
 	if class != nil {
 		var classNames []string
+		// classNodes holds the value of each class name, to fix.
+		var classNodes []d2ast.Scalar
+		inArray := false
 		if class.Primary() != nil {
 			classNames = append(classNames, class.Primary().String())
+			classNodes = append(classNodes, class.Primary().Value)
 		} else if class.Composite != nil {
 			if arr, ok := class.Composite.(*d2ir.Array); ok {
+				inArray = true
 				for _, class := range arr.Values {
 					if scalar, ok := class.(*d2ir.Scalar); ok {
 						classNames = append(classNames, scalar.Value.ScalarString())
+						classNodes = append(classNodes, scalar.Value)
 					} else {
 						c.errorf(class.LastPrimaryKey(), "invalid value in array")
 					}
@@ -235,7 +276,7 @@ func (c *compiler) compileMap(obj *d2graph.Object, m *d2ir.Map) {
 			c.errorf(class.LastRef().AST(), "class missing value")
 		}
 
-		for _, className := range classNames {
+		for i, className := range classNames {
 			classMap := m.GetClassMap(className)
 			if classMap != nil {
 				c.compileMap(obj, classMap)
@@ -243,15 +284,21 @@ func (c *compiler) compileMap(obj *d2graph.Object, m *d2ir.Map) {
 				if strings.Contains(className, ",") {
 					split := strings.Split(className, ",")
 					allFound := true
+					var trimmed []string
 					for _, maybeClassName := range split {
 						maybeClassName = strings.TrimSpace(maybeClassName)
+						trimmed = append(trimmed, maybeClassName)
 						if m.GetClassMap(maybeClassName) == nil {
 							allFound = false
 							break
 						}
 					}
 					if allFound {
-						c.errorf(class.LastRef().AST(), `class "%s" not found. Did you mean to use ";" to separate array items?`, className)
+						fixed := strings.Join(trimmed, "; ")
+						if !inArray {
+							fixed = "[" + fixed + "]"
+						}
+						c.errorfWithFixes(class.LastRef().AST(), replaceFix(`Use ";" to separate array items`, classNodes[i], fixed), `class "%s" not found. Did you mean to use ";" to separate array items?`, className)
 					}
 				}
 			}
@@ -319,6 +366,14 @@ func (c *compiler) compileField(obj *d2graph.Object, f *d2ir.Field) {
 		return
 	} else if f.Name == "vars" {
 		return
+	} else if f.Name == "description" && obj.Parent == nil {
+		// Only a keyword at the top level of boards, so that objects and columns can still be
+		// named description.
+		c.compileDescription(obj.Graph, f)
+		return
+	} else if f.Name == "transition" && obj.Parent == nil {
+		c.compileTransition(obj.Graph, f)
+		return
 	} else if f.Name == "source-arrowhead" || f.Name == "target-arrowhead" {
 		c.errorf(f.LastRef().AST(), `%#v can only be used on connections`, f.Name)
 		return
@@ -383,6 +438,96 @@ func (c *compiler) compileField(obj *d2graph.Object, f *d2ir.Field) {
 	}
 }
 
+func (c *compiler) compileDescription(g *d2graph.Graph, f *d2ir.Field) {
+	if f.Primary() == nil || f.Map() != nil {
+		c.errorf(f.LastRef().AST(), "description must be set to a string")
+		return
+	}
+	g.Description = f.Primary().Value.ScalarString()
+}
+
+func (c *compiler) compileTransition(g *d2graph.Graph, f *d2ir.Field) {
+	if f.Primary() != nil || f.Map() == nil {
+		c.errorf(f.LastRef().AST(), "transition must be set to a map of interval, duration and easing")
+		return
+	}
+	if len(f.Map().Edges) > 0 {
+		c.errorf(f.Map().Edges[0].LastRef().AST(), "transition cannot contain an edge")
+		return
+	}
+	tr := &d2target.Transition{}
+	for _, tf := range f.Map().Fields {
+		if tf.Primary() == nil || tf.Map() != nil {
+			c.errorf(tf.LastRef().AST(), "transition %s must be set to a value", tf.Name)
+			continue
+		}
+		v := tf.Primary().Value.ScalarString()
+		switch tf.Name {
+		case "interval", "duration":
+			ms, err := strconv.Atoi(v)
+			if tf.Name == "interval" {
+				if err != nil || ms <= 0 {
+					c.errorf(tf.LastPrimaryKey(), "transition interval must be a number of milliseconds greater than 0")
+					continue
+				}
+				tr.Interval = ms
+			} else {
+				if err != nil || ms < 0 {
+					c.errorf(tf.LastPrimaryKey(), "transition duration must be a number of milliseconds")
+					continue
+				}
+				tr.Duration = ms
+			}
+		case "easing":
+			if !d2target.ValidEasing(v) {
+				c.errorf(tf.LastPrimaryKey(), "transition easing must be one of %s, or cubic-bezier(x1, y1, x2, y2)", strings.Join(d2target.Easings, ", "))
+				continue
+			}
+			tr.Easing = v
+		default:
+			c.errorf(tf.LastRef().AST(), "%s is an invalid transition field, must be one of interval, duration or easing", tf.Name)
+		}
+	}
+	g.Transition = tr
+}
+
+// resolveImportedIcon rewrites iconURL, if it's the relative path of a local image in p, a
+// file imported by the input, to be relative to the input like the images of its own, so
+// that imported files use the images next to them wherever they're imported from.
+func (c *compiler) resolveImportedIcon(iconURL *url.URL, p string) {
+	if p == "" || p == c.inputPath || iconURL.Scheme != "" || iconURL.Host != "" || iconURL.Path == "" || path.IsAbs(iconURL.Path) {
+		return
+	}
+	if _, ok := d2ir.ImportScheme(p); ok {
+		return
+	}
+	rel, err := filepath.Rel(filepath.FromSlash(path.Dir(c.inputPath)), filepath.FromSlash(path.Dir(p)))
+	if err != nil {
+		return
+	}
+	iconURL.Path = path.Join(filepath.ToSlash(rel), iconURL.Path)
+	iconURL.RawPath = ""
+}
+
+// readShapeSVG returns the path data of the SVG file at p, relative to the file at
+// importingPath that sets it as a shape-path.
+func (c *compiler) readShapeSVG(p, importingPath string) (string, error) {
+	if !path.IsAbs(p) {
+		p = path.Join(path.Dir(importingPath), p)
+	}
+	var b []byte
+	var err error
+	if c.fs == nil {
+		b, err = os.ReadFile(p)
+	} else {
+		b, err = fs.ReadFile(c.fs, p)
+	}
+	if err != nil {
+		return "", err
+	}
+	return svg.ExtractPathData(b)
+}
+
 func (c *compiler) compileLabel(attrs *d2graph.Attributes, f d2ir.Node) {
 	scalar := f.Primary().Value
 	switch scalar := scalar.(type) {
@@ -508,7 +653,7 @@ func (c *compiler) compileReserved(attrs *d2graph.Attributes, f *d2ir.Field) {
 		in := d2target.IsShape(scalar.ScalarString())
 		_, isArrowhead := d2target.Arrowheads[scalar.ScalarString()]
 		if !in && !isArrowhead {
-			c.errorf(scalar, "unknown shape %q", scalar.ScalarString())
+			c.errorfWithFixes(scalar, didYouMean(scalar, scalar.ScalarString(), d2target.Shapes), "unknown shape %q", scalar.ScalarString())
 			return
 		}
 		attrs.Shape.Value = scalar.ScalarString()
@@ -517,12 +662,31 @@ func (c *compiler) compileReserved(attrs *d2graph.Attributes, f *d2ir.Field) {
 			attrs.Language = d2target.ShapeText
 		}
 		attrs.Shape.MapKey = f.LastPrimaryKey()
+	case "shape-path":
+		pathData := scalar.ScalarString()
+		if strings.EqualFold(path.Ext(pathData), ".svg") {
+			var err error
+			pathData, err = c.readShapeSVG(pathData, scalar.GetRange().Path)
+			if err != nil {
+				c.errorf(scalar, "failed to read shape-path %q: %v", scalar.ScalarString(), err)
+				return
+			}
+		}
+		if err := shape.ValidatePath(pathData); err != nil {
+			c.errorf(scalar, "bad shape-path %q: %v", scalar.ScalarString(), err)
+			return
+		}
+		attrs.ShapePath = &d2graph.Scalar{}
+		attrs.ShapePath.Value = scalar.ScalarString()
+		attrs.ShapePath.MapKey = f.LastPrimaryKey()
+		attrs.ShapePathData = pathData
 	case "icon":
 		iconURL, err := url.Parse(scalar.ScalarString())
 		if err != nil {
 			c.errorf(scalar, "bad icon url %#v: %s", scalar.ScalarString(), err)
 			return
 		}
+		c.resolveImportedIcon(iconURL, scalar.GetRange().Path)
 		attrs.Icon = iconURL
 		c.compilePosition(attrs, f)
 	case "near":
@@ -585,10 +749,19 @@ func (c *compiler) compileReserved(attrs *d2graph.Attributes, f *d2ir.Field) {
 		attrs.Link = &d2graph.Scalar{}
 		attrs.Link.Value = scalar.ScalarString()
 		attrs.Link.MapKey = f.LastPrimaryKey()
+	case "animate":
+		_, err := d2target.ParseAnimation(scalar.ScalarString())
+		if err != nil {
+			c.errorf(scalar, "%s", err)
+			return
+		}
+		attrs.Animate = &d2graph.Scalar{}
+		attrs.Animate.Value = scalar.ScalarString()
+		attrs.Animate.MapKey = f.LastPrimaryKey()
 	case "direction":
 		dirs := []string{"up", "down", "right", "left"}
 		if !go2.Contains(dirs, scalar.ScalarString()) {
-			c.errorf(scalar, `direction must be one of %v, got %q`, strings.Join(dirs, ", "), scalar.ScalarString())
+			c.errorfWithFixes(scalar, didYouMean(scalar, scalar.ScalarString(), dirs), `direction must be one of %v, got %q`, strings.Join(dirs, ", "), scalar.ScalarString())
 			return
 		}
 		attrs.Direction.Value = scalar.ScalarString()
@@ -685,7 +858,7 @@ func (c *compiler) compileStyle(attrs *d2graph.Attributes, m *d2ir.Map) {
 
 func (c *compiler) compileStyleField(attrs *d2graph.Attributes, f *d2ir.Field) {
 	if _, ok := d2graph.StyleKeywords[strings.ToLower(f.Name)]; !ok {
-		c.errorf(f.LastRef().AST(), `invalid style keyword: "%s"`, f.Name)
+		c.errorfWithFixes(f.LastRef().AST(), didYouMean(f.LastRef().(*d2ir.FieldReference).String, f.Name, sortedKeys(d2graph.StyleKeywords)), `invalid style keyword: "%s"`, f.Name)
 		return
 	}
 	if f.Primary() == nil {
@@ -1018,12 +1191,19 @@ func (c *compiler) validateKey(obj *d2graph.Object, f *d2ir.Field) {
 			if strings.EqualFold(obj.Shape.Value, d2target.ShapeImage) && obj.Icon == nil {
 				c.errorf(f.LastPrimaryKey(), `image shape must include an "icon" field`)
 			}
+			if strings.EqualFold(obj.Shape.Value, d2target.ShapeCustom) && obj.ShapePath == nil {
+				c.errorf(f.LastPrimaryKey(), `custom shape must include a "shape-path" field`)
+			}
 
 			in := d2target.IsShape(obj.Shape.Value)
 			_, arrowheadIn := d2target.Arrowheads[obj.Shape.Value]
 			if !in && arrowheadIn {
 				c.errorf(f.LastPrimaryKey(), fmt.Sprintf(`invalid shape, can only set "%s" for arrowheads`, obj.Shape.Value))
 			}
+		case "shape-path":
+			if !strings.EqualFold(obj.Shape.Value, d2target.ShapeCustom) {
+				c.errorf(f.LastPrimaryKey(), `"shape-path" keyword can only be used in "custom" shapes`)
+			}
 		case "constraint":
 			if !strings.EqualFold(obj.Shape.Value, d2target.ShapeSQLTable) {
 				c.errorf(f.LastPrimaryKey(), `"constraint" keyword can only be used in "sql_table" shapes`)
@@ -1113,7 +1293,7 @@ func (c *compiler) validateNear(g *d2graph.Graph) {
 					continue
 				}
 			} else {
-				c.errorf(obj.NearKey, "near key %#v must be the absolute path to a shape or one of the following constants: %s", d2format.Format(obj.NearKey), strings.Join(d2graph.NearConstantsArray, ", "))
+				c.errorfWithFixes(obj.NearKey, nearFixes(g, obj.NearKey), "near key %#v must be the absolute path to a shape or one of the following constants: %s", d2format.Format(obj.NearKey), strings.Join(d2graph.NearConstantsArray, ", "))
 				continue
 			}
 		}
@@ -1431,6 +1611,34 @@ func compileConfig(ir *d2ir.Map) (*d2target.Config, error) {
 		config.LayoutEngine = go2.Pointer(f.Primary().Value.ScalarString())
 	}
 
+	f = configMap.GetField("latex-display")
+	if f != nil {
+		val, _ := strconv.ParseBool(f.Primary().Value.ScalarString())
+		config.LatexDisplay = &val
+	}
+
+	f = configMap.GetField("latex-numbering")
+	if f != nil {
+		val, _ := strconv.ParseBool(f.Primary().Value.ScalarString())
+		config.LatexNumbering = &val
+	}
+
+	f = configMap.GetField("sequence-numbering")
+	if f != nil {
+		val, _ := strconv.ParseBool(f.Primary().Value.ScalarString())
+		config.SequenceNumbering = &val
+	}
+
+	f = configMap.GetField("pdf-header")
+	if f != nil {
+		config.PDFHeader = go2.Pointer(f.Primary().Value.ScalarString())
+	}
+
+	f = configMap.GetField("pdf-footer")
+	if f != nil {
+		config.PDFFooter = go2.Pointer(f.Primary().Value.ScalarString())
+	}
+
 	f = configMap.GetField("theme-overrides")
 	if f != nil {
 		overrides, err := compileThemeOverrides(f.Map())
@@ -1451,6 +1659,92 @@ func compileConfig(ir *d2ir.Map) (*d2target.Config, error) {
 	return config, nil
 }
 
+// compileLatex expands the macros defined in the latex blocks of each board in the latex
+// blocks of the board and its scenarios and steps, and applies the latex-display and
+// latex-numbering configs. It rewrites the labels in place so that measurement and
+// rendering both see the final equation. Blocks that only define macros are left empty,
+// for d2lib to drop before layout.
+func compileLatex(g *d2graph.Graph, config *d2target.Config) error {
+	err := &d2parser.ParseError{}
+	compileBoardLatex(g, nil, config, err)
+	if !err.Empty() {
+		return err
+	}
+	return nil
+}
+
+// compileBoardLatex compiles the latex blocks of g, which inherits the macros of the boards
+// it is a scenario or step of.
+func compileBoardLatex(g *d2graph.Graph, inherited d2latex.Macros, config *d2target.Config, err *d2parser.ParseError) {
+	macros := make(d2latex.Macros, len(inherited))
+	for name, m := range inherited {
+		macros[name] = m
+	}
+
+	var objects []*d2graph.Object
+	for _, obj := range g.Objects {
+		if obj.Language != "latex" {
+			continue
+		}
+		rest, parseErr := d2latex.ParseMacros(obj.Label.Value, macros)
+		if parseErr != nil {
+			err.Errors = append(err.Errors, d2parser.Errorf(obj.Label.MapKey, "invalid latex macro: %v", parseErr).(d2ast.Error))
+			continue
+		}
+		if strings.TrimSpace(rest) == "" {
+			obj.Label.Value = ""
+			if len(obj.ChildrenArray) > 0 || hasEdges(g, obj) {
+				err.Errors = append(err.Errors, d2parser.Errorf(obj.Label.MapKey, "latex block that only defines macros cannot have children or connections").(d2ast.Error))
+			}
+			continue
+		}
+		obj.Label.Value = rest
+		objects = append(objects, obj)
+	}
+
+	display := config != nil && config.LatexDisplay != nil && *config.LatexDisplay
+	numbering := config != nil && config.LatexNumbering != nil && *config.LatexNumbering
+	n := 0
+	for _, obj := range objects {
+		s, expandErr := d2latex.ExpandMacros(obj.Label.Value, macros)
+		if expandErr != nil {
+			err.Errors = append(err.Errors, d2parser.Errorf(obj.Label.MapKey, "invalid latex: %v", expandErr).(d2ast.Error))
+			continue
+		}
+		if numbering {
+			var numbered bool
+			s, numbered = d2latex.Number(s, n+1)
+			if numbered {
+				n++
+			}
+		}
+		if display {
+			s = d2latex.Display(s)
+		}
+		obj.Label.Value = s
+	}
+
+	// Layers don't inherit from their parent board, so neither do their macros.
+	for _, b := range g.Layers {
+		compileBoardLatex(b, nil, config, err)
+	}
+	for _, b := range g.Scenarios {
+		compileBoardLatex(b, macros, config, err)
+	}
+	for _, b := range g.Steps {
+		compileBoardLatex(b, macros, config, err)
+	}
+}
+
+func hasEdges(g *d2graph.Graph, obj *d2graph.Object) bool {
+	for _, e := range g.Edges {
+		if e.Src == obj || e.Dst == obj {
+			return true
+		}
+	}
+	return false
+}
+
 func compileThemeOverrides(m *d2ir.Map) (*d2target.ThemeOverrides, error) {
 	if m == nil {
 		return nil, nil
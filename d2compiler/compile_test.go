@@ -52,6 +52,140 @@ x: {
 
 			},
 		},
+		{
+			name: "board_description",
+
+			text: `description: Walk through the happy path first
+x: {
+  description
+}
+t: {
+  shape: sql_table
+  description: text
+}
+layers: {
+  l: {
+    description: |md
+      Then the failures
+    |
+  }
+}
+`,
+			assertions: func(t *testing.T, g *d2graph.Graph) {
+				tassert.Equal(t, "Walk through the happy path first", g.Description)
+				tassert.Equal(t, "Then the failures", g.Layers[0].Description)
+				tassert.Equal(t, 3, len(g.Objects))
+				tassert.Equal(t, "x.description", g.Objects[1].AbsID())
+				tassert.Equal(t, 1, len(g.Objects[2].SQLTable.Columns))
+			},
+		},
+		{
+			name:   "board_description_map",
+			text:   `description: {x}`,
+			expErr: `d2/testdata/d2compiler/TestCompile/board_description_map.d2:1:1: description must be set to a string`,
+		},
+		{
+			name: "board_transition",
+
+			text: `transition: {
+  interval: 2000
+}
+x: {
+  transition
+}
+steps: {
+  1: {
+    transition: {
+      duration: 300
+      easing: cubic-bezier(0.4, 0, 0.2, 1)
+    }
+  }
+  2: {
+    transition.easing: ease-in
+  }
+}
+`,
+			assertions: func(t *testing.T, g *d2graph.Graph) {
+				tassert.Equal(t, &d2target.Transition{Interval: 2000}, g.Transition)
+				tassert.Equal(t, &d2target.Transition{Interval: 2000, Duration: 300, Easing: "cubic-bezier(0.4, 0, 0.2, 1)"}, g.Steps[0].Transition)
+				tassert.Equal(t, &d2target.Transition{Interval: 2000, Duration: 300, Easing: "ease-in"}, g.Steps[1].Transition)
+				tassert.Equal(t, "x.transition", g.Objects[1].AbsID())
+			},
+		},
+		{
+			name: "board_transition_invalid",
+			text: `transition: {
+  interval: 0
+  duration: soon
+  easing: bounce
+  speed: 2
+}
+layers: {
+  l: {
+    transition: fast
+  }
+}
+`,
+			expErr: `d2/testdata/d2compiler/TestCompile/board_transition_invalid.d2:2:3: transition interval must be a number of milliseconds greater than 0
+d2/testdata/d2compiler/TestCompile/board_transition_invalid.d2:3:3: transition duration must be a number of milliseconds
+d2/testdata/d2compiler/TestCompile/board_transition_invalid.d2:4:3: transition easing must be one of linear, ease, ease-in, ease-out, ease-in-out, step-start, step-end, or cubic-bezier(x1, y1, x2, y2)
+d2/testdata/d2compiler/TestCompile/board_transition_invalid.d2:5:3: speed is an invalid transition field, must be one of interval, duration or easing
+d2/testdata/d2compiler/TestCompile/board_transition_invalid.d2:9:5: transition must be set to a map of interval, duration and easing`,
+		},
+		{
+			name: "element_animate",
+			text: `x: {animate: fade-in}
+y: {animate: fade-out 3}
+x -> y: {animate: fade-in 2}
+`,
+			assertions: func(t *testing.T, g *d2graph.Graph) {
+				tassert.Equal(t, "fade-in", g.Objects[0].Animate.Value)
+				tassert.Equal(t, "fade-out 3", g.Objects[1].Animate.Value)
+				tassert.Equal(t, "fade-in 2", g.Edges[0].Animate.Value)
+			},
+		},
+		{
+			name: "element_animate_invalid",
+			text: `x: {animate: bounce}
+y: {animate: fade-in 0}
+z: {animate: fade-in 2 slowly}
+`,
+			expErr: `d2/testdata/d2compiler/TestCompile/element_animate_invalid.d2:1:14: animate effect must be one of fade-in, fade-out, got "bounce"
+d2/testdata/d2compiler/TestCompile/element_animate_invalid.d2:2:14: animate order must be a positive integer, got "0"
+d2/testdata/d2compiler/TestCompile/element_animate_invalid.d2:3:14: animate must be an effect followed by its order, e.g. "fade-in 2", got "fade-in 2 slowly"`,
+		},
+		{
+			name: "custom_shape",
+			text: `x: {shape: custom; shape-path: "M 0 0 L 10 0 L 5 10 Z"}
+y: {shape: custom; shape-path: shapes/star.svg}
+`,
+			files: map[string]string{
+				"shapes/star.svg": `<svg xmlns="http://www.w3.org/2000/svg"><defs><rect width="1" height="1"/></defs><path d="M 12 2 L 22 9 L 2 9 Z"/></svg>`,
+			},
+			assertions: func(t *testing.T, g *d2graph.Graph) {
+				tassert.Equal(t, "M 0 0 L 10 0 L 5 10 Z", g.Objects[0].ShapePathData)
+				tassert.Equal(t, "shapes/star.svg", g.Objects[1].ShapePath.Value)
+				tassert.Equal(t, "M 12 2 L 22 9 L 2 9 Z", g.Objects[1].ShapePathData)
+			},
+		},
+		{
+			name: "custom_shape_invalid",
+			text: `x: {shape: custom; shape-path: "hello"}
+y: {shape: custom; shape-path: "M 0 0 L 10 0"}
+z: {shape: custom; shape-path: missing.svg}
+`,
+			expErr: `d2/testdata/d2compiler/TestCompile/custom_shape_invalid.d2:1:32: bad shape-path "hello": path data must only contain SVG path commands and numbers
+d2/testdata/d2compiler/TestCompile/custom_shape_invalid.d2:2:32: bad shape-path "M 0 0 L 10 0": path data must draw an area
+d2/testdata/d2compiler/TestCompile/custom_shape_invalid.d2:3:32: failed to read shape-path "missing.svg": open d2/testdata/d2compiler/TestCompile/missing.svg: no such file or directory`,
+		},
+		{
+			name: "custom_shape_without_path",
+			text: `x: {shape: custom}
+y: {shape-path: "M 0 0 L 10 0 L 5 10 Z"}
+`,
+			expErr: `d2/testdata/d2compiler/TestCompile/custom_shape_without_path.d2:1:5: custom shape must include a "shape-path" field
+d2/testdata/d2compiler/TestCompile/custom_shape_without_path.d2:2:5: "shape-path" keyword can only be used in "custom" shapes`,
+		},
 		{
 			name: "basic_style",
 
@@ -2902,6 +3036,36 @@ qa: {
 				tassert.Equal(t, "Qa Environment", g.Objects[2].Label.Value)
 			},
 		},
+		{
+			name: "imported_icon_paths",
+			text: `a.icon: logo.svg
+b: @sub/b
+c: @sub/c
+d: @sub/d
+`,
+			files: map[string]string{
+				"sub/b.d2": `icon: ./logo.svg`,
+				"sub/c.d2": `shape: image
+icon: ../assets/c.png`,
+				"sub/d.d2": `x.icon: https://icons.terrastruct.com/essentials/004-picture.svg
+y.icon: /assets/y.svg`,
+			},
+			assertions: func(t *testing.T, g *d2graph.Graph) {
+				icons := make(map[string]string)
+				for _, obj := range g.Objects {
+					if obj.Icon != nil {
+						icons[obj.AbsID()] = obj.Icon.String()
+					}
+				}
+				tassert.Equal(t, map[string]string{
+					"a":   "logo.svg",
+					"b":   "sub/logo.svg",
+					"c":   "assets/c.png",
+					"d.x": "https://icons.terrastruct.com/essentials/004-picture.svg",
+					"d.y": "/assets/y.svg",
+				}, icons)
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -2968,6 +3132,7 @@ func TestCompile2(t *testing.T) {
 	t.Run("nulls", testNulls)
 	t.Run("vars", testVars)
 	t.Run("globs", testGlobs)
+	t.Run("fixes", testFixes)
 }
 
 func testBoards(t *testing.T) {
@@ -4101,6 +4266,106 @@ x -> y
 					assert.Equal(t, true, *config.Sketch)
 				},
 			},
+			{
+				name: "latex",
+				run: func(t *testing.T) {
+					g, config := assertCompile(t, `
+vars: {
+	d2-config: {
+    latex-display: true
+    latex-numbering: true
+  }
+}
+
+defs: |latex \\newcommand{\\RR}{\\mathbb{R}} \\newcommand{\\pair}[2]{(#1, #2)}|
+a: |latex x \\in \\RR|
+b: |latex \\pair{a}{b} \\notag|
+c: |latex \\pair{a}{b}|
+`, "")
+					assert.Equal(t, true, *config.LatexDisplay)
+					assert.Equal(t, true, *config.LatexNumbering)
+					assert.Equal(t, "", g.Objects[0].Label.Value)
+					assert.Equal(t, `\\displaystyle{x \\in {\\mathbb{R}} \\qquad(1)}`, g.Objects[1].Label.Value)
+					assert.Equal(t, `\\displaystyle{{(a, b)}}`, g.Objects[2].Label.Value)
+					assert.Equal(t, `\\displaystyle{{(a, b)} \\qquad(2)}`, g.Objects[3].Label.Value)
+				},
+			},
+			{
+				name: "latex-boards",
+				run: func(t *testing.T) {
+					g, _ := assertCompile(t, `
+defs: |latex \\newcommand{\\RR}{\\mathbb{R}}|
+a: |latex \\RR|
+
+layers: {
+  x: {
+    defs: |latex \\newcommand{\\pair}[2]{(#1, #2)}|
+    b: |latex \\pair{\\RR}{\\RR}|
+  }
+  y: {
+    c: |latex \\pair{a}{b}|
+  }
+}
+
+scenarios: {
+  s: {
+    d: |latex \\RR|
+  }
+}
+`, "")
+					assert.Equal(t, `{\\mathbb{R}}`, g.Objects[1].Label.Value)
+					// Layers don't inherit the macros of their parent board.
+					assert.Equal(t, `{(\\RR, \\RR)}`, g.Layers[0].Objects[1].Label.Value)
+					// Macros of sibling boards are not shared.
+					assert.Equal(t, `\\pair{a}{b}`, g.Layers[1].Objects[0].Label.Value)
+					// Scenarios do.
+					assert.Equal(t, `{\\mathbb{R}}`, g.Scenarios[0].Objects[2].Label.Value)
+				},
+			},
+			{
+				name: "latex-macros-connected",
+				run: func(t *testing.T) {
+					assertCompile(t, `
+defs: |latex \\newcommand{\\RR}{\\mathbb{R}}|
+defs -> a
+`, `d2/testdata/d2compiler/TestCompile2/vars/config/latex-macros-connected.d2:2:1: latex block that only defines macros cannot have children or connections`)
+				},
+			},
+			{
+				name: "sequence-numbering",
+				run: func(t *testing.T) {
+					g, config := assertCompile(t, `
+vars: {
+	d2-config: {
+    sequence-numbering: true
+  }
+}
+
+shape: sequence_diagram
+a -> b: hi
+`, "")
+					assert.Equal(t, true, *config.SequenceNumbering)
+					// Messages are numbered by d2lib, so that edits keep their labels.
+					assert.Equal(t, "hi", g.Edges[0].Label.Value)
+				},
+			},
+			{
+				name: "pdf",
+				run: func(t *testing.T) {
+					_, config := assertCompile(t, `
+vars: {
+	d2-config: {
+    pdf-header: "{title}|{board}|{date}"
+    pdf-footer: "Page {page} of {pages}"
+  }
+}
+
+x -> y
+`, "")
+					assert.Equal(t, "{title}|{board}|{date}", *config.PDFHeader)
+					assert.Equal(t, "Page {page} of {pages}", *config.PDFFooter)
+				},
+			},
 			{
 				name: "invalid",
 				run: func(t *testing.T) {
@@ -4115,6 +4380,35 @@ x -> y
 `, `d2/testdata/d2compiler/TestCompile2/vars/config/invalid.d2:4:5: expected a boolean for "sketch", got "lol"`)
 				},
 			},
+			{
+				name: "fmt",
+				run: func(t *testing.T) {
+					assertCompile(t, `
+vars: {
+	d2-config: {
+    fmt: {
+      indent-width: 4
+      sort-keys: true
+    }
+  }
+}
+`, "")
+				},
+			},
+			{
+				name: "invalid-fmt",
+				run: func(t *testing.T) {
+					assertCompile(t, `
+vars: {
+	d2-config: {
+    fmt: {
+      quotes: backticks
+    }
+  }
+}
+`, `d2/testdata/d2compiler/TestCompile2/vars/config/invalid-fmt.d2:5:7: expected one of preserve, double or single for "quotes", got "backticks"`)
+				},
+			},
 			{
 				name: "not-root",
 				run: func(t *testing.T) {
@@ -4490,6 +4784,56 @@ a -> b
 	}
 }
 
+func testFixes(t *testing.T) {
+	t.Parallel()
+
+	tca := []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{
+			name: "unknown_shape",
+			run: func(t *testing.T) {
+				assertCompile(t, `x.shape: cirle`, `d2/testdata/d2compiler/TestCompile2/fixes/unknown_shape.d2:1:10: unknown shape "cirle"`)
+			},
+		},
+		{
+			name: "unknown_shape_far",
+			run: func(t *testing.T) {
+				assertCompile(t, `x.shape: star`, `d2/testdata/d2compiler/TestCompile2/fixes/unknown_shape_far.d2:1:10: unknown shape "star"`)
+			},
+		},
+		{
+			name: "style_keyword",
+			run: func(t *testing.T) {
+				assertCompile(t, `x.style.fil: red`, `d2/testdata/d2compiler/TestCompile2/fixes/style_keyword.d2:1:9: invalid style keyword: "fil"`)
+			},
+		},
+		{
+			name: "direction",
+			run: func(t *testing.T) {
+				assertCompile(t, `direction: rigth`, `d2/testdata/d2compiler/TestCompile2/fixes/direction.d2:1:12: direction must be one of up, down, right, left, got "rigth"`)
+			},
+		},
+		{
+			name: "near_object",
+			run: func(t *testing.T) {
+				assertCompile(t, `x.near: y.z
+a
+`, `d2/testdata/d2compiler/TestCompile2/fixes/near_object.d2:1:9: near key "y.z" must be the absolute path to a shape or one of the following constants: top-left, top-center, top-right, center-left, center-right, bottom-left, bottom-center, bottom-right`)
+			},
+		},
+	}
+
+	for _, tc := range tca {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			tc.run(t)
+		})
+	}
+}
+
 func assertCompile(t *testing.T, text string, expErr string) (*d2graph.Graph, *d2target.Config) {
 	d2Path := fmt.Sprintf("d2/testdata/d2compiler/%v.d2", t.Name())
 	g, config, err := d2compiler.Compile(d2Path, strings.NewReader(text), nil)
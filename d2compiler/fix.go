@@ -0,0 +1,114 @@
+package d2compiler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2format"
+	"oss.terrastruct.com/d2/d2graph"
+)
+
+// didYouMean returns a fix replacing n with the candidate closest to got, if any is close
+// enough to be a likely typo.
+func didYouMean(n d2ast.Node, got string, candidates []string) []d2ast.Fix {
+	s, ok := closest(got, candidates)
+	if !ok {
+		return nil
+	}
+	return replaceFix(fmt.Sprintf("Change to %q", s), n, s)
+}
+
+func replaceFix(title string, n d2ast.Node, newText string) []d2ast.Fix {
+	return []d2ast.Fix{{
+		Title: title,
+		Edits: []d2ast.TextEdit{{
+			Range:   n.GetRange(),
+			NewText: newText,
+		}},
+	}}
+}
+
+func removeFix(title string, n d2ast.Node) []d2ast.Fix {
+	return replaceFix(title, n, "")
+}
+
+// nearFixes returns the fixes of a near key that refers to neither an object nor a
+// constant: changing it to the closest constant, or creating the object it refers to at the
+// end of the board.
+func nearFixes(g *d2graph.Graph, nearKey *d2ast.KeyPath) []d2ast.Fix {
+	var fixes []d2ast.Fix
+	key := d2format.Format(nearKey)
+	if len(nearKey.Path) == 1 {
+		fixes = append(fixes, didYouMean(nearKey, key, d2graph.NearConstantsArray)...)
+	}
+	if g.AST != nil && len(g.AST.Nodes) > 0 {
+		end := g.AST.Nodes[len(g.AST.Nodes)-1].Unbox().GetRange().End
+		fixes = append(fixes, d2ast.Fix{
+			Title: fmt.Sprintf("Create %s", key),
+			Edits: []d2ast.TextEdit{{
+				Range: d2ast.Range{
+					Path:  g.AST.Range.Path,
+					Start: end,
+					End:   end,
+				},
+				NewText: "\n" + key,
+			}},
+		})
+	}
+	return fixes
+}
+
+// closest returns the candidate with the least edit distance to s, ignoring case. Only
+// candidates within a third of the length of s are considered.
+func closest(s string, candidates []string) (string, bool) {
+	s = strings.ToLower(s)
+	maxDist := len(s) / 3
+	if maxDist < 1 {
+		maxDist = 1
+	}
+	best, bestDist := "", maxDist+1
+	for _, c := range candidates {
+		d := editDistance(s, strings.ToLower(c))
+		if d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best, best != ""
+}
+
+// editDistance returns the number of insertions, deletions, substitutions and
+// transpositions of adjacent bytes that turn a into b.
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
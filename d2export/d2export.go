@@ -0,0 +1,342 @@
+// Package d2export exports compiled diagrams to the formats of the d2 CLI: SVG, PNG, PDF and
+// PPTX, for programs that embed D2 rather than shell out to the CLI.
+package d2export
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.uber.org/multierr"
+
+	"oss.terrastruct.com/d2/d2renderers/d2svg"
+	"oss.terrastruct.com/d2/d2renderers/d2svg/appendix"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/imgbundler"
+	"oss.terrastruct.com/d2/lib/pdf"
+	"oss.terrastruct.com/d2/lib/png"
+	"oss.terrastruct.com/d2/lib/pptx"
+	"oss.terrastruct.com/d2/lib/simplelog"
+	"oss.terrastruct.com/d2/lib/textmeasure"
+	"oss.terrastruct.com/d2/lib/version"
+	"oss.terrastruct.com/util-go/go2"
+)
+
+type Format string
+
+const (
+	SVG  Format = "svg"
+	PNG  Format = "png"
+	PDF  Format = "pdf"
+	PPTX Format = "pptx"
+)
+
+type Opts struct {
+	// RenderOpts are the options boards are rendered with. The pages of PDF exports and the
+	// slides of PPTX exports only use its pad, sketch, center, scale and theme.
+	RenderOpts d2svg.RenderOpts
+
+	// InputPath is the path of the file the diagram was compiled from, which the relative
+	// paths of local images are resolved against.
+	InputPath string
+	// Logger logs the bundling of images and conversions to PNG. Nothing is logged if nil.
+	Logger simplelog.Logger
+	// Ruler measures the texts of appendices. One is created if nil.
+	Ruler *textmeasure.Ruler
+	// PostProcess, if set, transforms every SVG before its images are bundled, like
+	// d2plugin.Plugin.PostProcess.
+	PostProcess func(ctx context.Context, svg []byte) ([]byte, error)
+	// BundleRemote bundles the remote images of SVG exports, which other formats always
+	// bundle.
+	BundleRemote bool
+	// Appendix appends the tooltips and links of SVG exports below them, as other formats
+	// always do.
+	Appendix bool
+	// CacheImages caches remote images in memory across exports.
+	CacheImages bool
+
+	// Size is the size of PNG exports. Only its scale applies to the PNGs of PDF pages and
+	// PPTX slides, which are sized by their boards.
+	Size png.Size
+	// Raster draws the pages of PDF exports and the slides of PPTX exports as PNGs, as boards
+	// are anyway when they use what cannot be drawn as vector or native shapes.
+	Raster bool
+	// Rasterize, if set, starts converting svg, diagram rendered with renderOpts, into a PNG
+	// of size. By default boards are drawn without a browser when png.CanDrawNative, or else
+	// with Playwright.
+	Rasterize func(ctx context.Context, diagram *d2target.Diagram, renderOpts *d2svg.RenderOpts, svg []byte, size png.Size) (*png.Conversion, error)
+	// Playwright is the browser boards are rasterized with by default. Export launches one
+	// when a board needs it if nil, and closes it once done.
+	Playwright *png.Playwright
+
+	PDFPage     pdf.PageOptions
+	PDFMetadata pdf.Metadata
+
+	// PPTXTitle, PPTXDescription and PPTXAuthor are the properties of PPTX exports.
+	PPTXTitle       string
+	PPTXDescription string
+	PPTXAuthor      string
+	// PPTXTemplate, if set, is the presentation PPTX exports are made from.
+	PPTXTemplate *pptx.Template
+	PPTXSlide    pptx.SlideOptions
+	// PPTXCallouts adds the tooltips of shapes to PPTX slides as callouts.
+	PPTXCallouts bool
+}
+
+// Export exports diagram to format. SVG and PNG exports are of diagram alone, while PDF and
+// PPTX exports have a page or slide for diagram and for every board below it. Images that
+// fail to be bundled into SVG exports are drawn as a placeholder showing their URL, and the
+// error is returned along with the SVG.
+func Export(ctx context.Context, diagram *d2target.Diagram, format Format, opts Opts) (_ []byte, err error) {
+	opts, err = opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Rasterize == nil && opts.Playwright == nil {
+		pw := &png.Playwright{}
+		opts.Playwright = pw
+		defer func() {
+			if pw.Browser != nil {
+				err = multierr.Combine(err, pw.Cleanup())
+			}
+		}()
+	}
+
+	switch format {
+	case SVG:
+		svg, err := RenderSVG(ctx, diagram, opts)
+		if opts.RenderOpts.MasterID == "" {
+			svg = imgbundler.Dedupe(svg)
+		}
+		return svg, err
+	case PNG:
+		return exportPNG(ctx, diagram, opts)
+	case PDF:
+		return exportPDF(ctx, diagram, opts)
+	case PPTX:
+		return exportPPTX(ctx, diagram, opts)
+	}
+	return nil, fmt.Errorf("unsupported export format %q", format)
+}
+
+// RenderSVG renders diagram into an SVG with opts.RenderOpts, post processed and with its
+// images bundled. Images that fail to be bundled are drawn as a placeholder showing their
+// URL, and the error is returned along with the SVG, which is nil on other errors.
+func RenderSVG(ctx context.Context, diagram *d2target.Diagram, opts Opts) ([]byte, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	svg, err := d2svg.Render(diagram, &opts.RenderOpts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.PostProcess != nil {
+		svg, err = opts.PostProcess(ctx, svg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	svg, bundleErr := imgbundler.BundleLocal(ctx, opts.Logger, opts.InputPath, svg, opts.CacheImages)
+	if opts.BundleRemote {
+		var bundleErr2 error
+		svg, bundleErr2 = imgbundler.BundleRemote(ctx, opts.Logger, svg, opts.CacheImages)
+		bundleErr = multierr.Combine(bundleErr, bundleErr2)
+	}
+	if opts.Appendix {
+		svg = AppendAppendix(diagram, opts.Ruler, svg)
+	}
+	return svg, bundleErr
+}
+
+// rulerMu guards rulers shared by boards exported in parallel.
+var rulerMu sync.Mutex
+
+// AppendAppendix is appendix.Append for boards exported in parallel with the same ruler.
+func AppendAppendix(diagram *d2target.Diagram, ruler *textmeasure.Ruler, svg []byte) []byte {
+	rulerMu.Lock()
+	defer rulerMu.Unlock()
+	return appendix.Append(diagram, ruler, svg)
+}
+
+func exportPNG(ctx context.Context, diagram *d2target.Diagram, opts Opts) ([]byte, error) {
+	if opts.RenderOpts.Scale == nil {
+		opts.RenderOpts.Scale = go2.Pointer(1.)
+	}
+	opts.RenderOpts.NoElementAnimations = true
+	opts.BundleRemote = true
+	opts.Appendix = true
+	svg, err := RenderSVG(ctx, diagram, opts)
+	if err != nil {
+		return nil, err
+	}
+	conv, err := opts.rasterize(ctx, diagram, &opts.RenderOpts, svg, opts.Size)
+	if err != nil {
+		return nil, err
+	}
+	out, err := wait(opts.Logger, conv)
+	if err != nil {
+		return nil, err
+	}
+	if dpi := opts.Size.DPI(); dpi > 0 {
+		return png.SetDPI(out, dpi)
+	}
+	return out, nil
+}
+
+func exportPDF(ctx context.Context, diagram *d2target.Diagram, opts Opts) ([]byte, error) {
+	doc := pdf.Init(&opts.PDFPage)
+	doc.SetMetadata(opts.PDFMetadata)
+	pageMap := BoardIndexes(diagram)
+	includeNav := diagram.Root.Label != ""
+
+	var pages []func() error
+	err := walkBoards(diagram, func(boards []*d2target.Diagram, boardIDs []string) error {
+		board := boards[len(boards)-1]
+		if board.IsFolderOnly {
+			return nil
+		}
+		boardPath := make([]pdf.BoardTitle, len(boards))
+		for i, b := range boards {
+			boardPath[i] = pdf.BoardTitle{Name: b.Root.Label, BoardID: boardIDs[i]}
+		}
+		_, addPage, err := PDFPage(ctx, doc, board, boardPath, pageMap, includeNav, opts)
+		if err != nil {
+			return err
+		}
+		pages = append(pages, addPage)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, addPage := range pages {
+		err = addPage()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	err = doc.ExportTo(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func exportPPTX(ctx context.Context, diagram *d2target.Diagram, opts Opts) ([]byte, error) {
+	title := opts.PPTXTitle
+	if title == "" {
+		title = diagram.Root.Label
+	}
+	// The version must only be numbers for PowerPoint to open presentations.
+	p := pptx.NewPresentation(title, opts.PPTXDescription, title, opts.PPTXAuthor, version.OnlyNumbers(), diagram.Root.Label != "")
+	if opts.PPTXTemplate != nil {
+		p.SetTemplate(opts.PPTXTemplate)
+	}
+	p.SetSlideOptions(opts.PPTXSlide)
+	boardIDToIndex := BoardIndexes(diagram)
+
+	var slides []func() error
+	err := walkBoards(diagram, func(boards []*d2target.Diagram, boardIDs []string) error {
+		board := boards[len(boards)-1]
+		if board.IsFolderOnly {
+			return nil
+		}
+		boardPath := make([]pptx.BoardTitle, len(boards))
+		for i, b := range boards {
+			name := b.Name
+			if i == 0 {
+				name = "root"
+			}
+			boardPath[i] = pptx.BoardTitle{Name: name, BoardID: boardIDs[i], LinkToSlide: boardIDToIndex[boardIDs[i]] + 1}
+		}
+		_, addSlide, err := PPTXSlide(ctx, p, board, boardPath, boardIDToIndex, opts)
+		if err != nil {
+			return err
+		}
+		slides = append(slides, addSlide)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, addSlide := range slides {
+		err = addSlide()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	err = p.Save(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// walkBoards calls fn on diagram and every board below it, in the order of their pages:
+// layers, scenarios and then steps. boards are the boards from diagram to the one fn is
+// called on, and boardIDs their IDs.
+func walkBoards(diagram *d2target.Diagram, fn func(boards []*d2target.Diagram, boardIDs []string) error) error {
+	var walk func(boards []*d2target.Diagram, boardIDs []string) error
+	walk = func(boards []*d2target.Diagram, boardIDs []string) error {
+		err := fn(boards, boardIDs)
+		if err != nil {
+			return err
+		}
+		board := boards[len(boards)-1]
+		boardID := boardIDs[len(boardIDs)-1]
+		for _, b := range []struct {
+			kind   string
+			boards []*d2target.Diagram
+		}{
+			{"layers", board.Layers},
+			{"scenarios", board.Scenarios},
+			{"steps", board.Steps},
+		} {
+			for _, dl := range b.boards {
+				// Siblings append to the same slices.
+				err = walk(
+					append(boards[:len(boards):len(boards)], dl),
+					append(boardIDs[:len(boardIDs):len(boardIDs)], strings.Join([]string{boardID, b.kind, dl.Name}, ".")),
+				)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk([]*d2target.Diagram{diagram}, []string{"root"})
+}
+
+// BoardIndexes returns the indexes of the pages or slides of diagram and every board below
+// it by board ID, e.g. root.layers.x, for links between them.
+func BoardIndexes(diagram *d2target.Diagram) map[string]int {
+	indexes := make(map[string]int)
+	walkBoards(diagram, func(_ []*d2target.Diagram, boardIDs []string) error {
+		indexes[boardIDs[len(boardIDs)-1]] = len(indexes)
+		return nil
+	})
+	return indexes
+}
+
+func (opts Opts) withDefaults() (Opts, error) {
+	if opts.Logger == nil {
+		opts.Logger = simplelog.Make(nil, nil, nil)
+	}
+	if opts.Ruler == nil {
+		ruler, err := textmeasure.NewRuler()
+		if err != nil {
+			return opts, err
+		}
+		opts.Ruler = ruler
+	}
+	return opts, nil
+}
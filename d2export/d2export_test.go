@@ -0,0 +1,113 @@
+package d2export_test
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"strings"
+	"testing"
+
+	tassert "github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/util-go/assert"
+	"oss.terrastruct.com/util-go/go2"
+
+	"oss.terrastruct.com/d2/d2export"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2dagrelayout"
+	"oss.terrastruct.com/d2/d2lib"
+	"oss.terrastruct.com/d2/d2renderers/d2svg"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/log"
+	png2 "oss.terrastruct.com/d2/lib/png"
+	"oss.terrastruct.com/d2/lib/pptx"
+	"oss.terrastruct.com/d2/lib/textmeasure"
+)
+
+func compile(t *testing.T, ctx context.Context, ruler *textmeasure.Ruler, input string) *d2target.Diagram {
+	diagram, _, err := d2lib.Compile(ctx, input, &d2lib.CompileOptions{
+		Ruler: ruler,
+		LayoutResolver: func(engine string) (d2graph.LayoutGraph, error) {
+			return d2dagrelayout.DefaultLayout, nil
+		},
+		Layout: go2.Pointer("dagre"),
+	}, nil)
+	assert.Success(t, err)
+	return diagram
+}
+
+func TestExport(t *testing.T) {
+	t.Parallel()
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	ruler, err := textmeasure.NewRuler()
+	assert.Success(t, err)
+	// Exported without a browser, which only some features of diagrams need.
+	input := `a -> b: hi
+layers: {
+  x: {
+    c -> d
+  }
+}
+`
+	opts := d2export.Opts{Ruler: ruler}
+
+	svg, err := d2export.Export(ctx, compile(t, ctx, ruler, input), d2export.SVG, opts)
+	assert.Success(t, err)
+	tassert.True(t, strings.Contains(string(svg), "<svg"))
+
+	out, err := d2export.Export(ctx, compile(t, ctx, ruler, input), d2export.PNG, opts)
+	assert.Success(t, err)
+	_, err = png.DecodeConfig(bytes.NewReader(out))
+	assert.Success(t, err)
+
+	out, err = d2export.Export(ctx, compile(t, ctx, ruler, input), d2export.PDF, opts)
+	assert.Success(t, err)
+	tassert.True(t, bytes.HasPrefix(out, []byte("%PDF")))
+	tassert.Equal(t, 2, bytes.Count(out, []byte("/Type /Page\n")))
+
+	out, err = d2export.Export(ctx, compile(t, ctx, ruler, input), d2export.PPTX, opts)
+	assert.Success(t, err)
+	assert.Success(t, pptx.Validate(out, 2))
+
+	var rasterized []string
+	opts.Rasterize = func(ctx context.Context, diagram *d2target.Diagram, renderOpts *d2svg.RenderOpts, svg []byte, size png2.Size) (*png2.Conversion, error) {
+		rasterized = append(rasterized, diagram.Name)
+		return d2export.ConvertNative(diagram, renderOpts, size), nil
+	}
+	opts.Raster = true
+	out, err = d2export.Export(ctx, compile(t, ctx, ruler, input), d2export.PPTX, opts)
+	assert.Success(t, err)
+	assert.Success(t, pptx.Validate(out, 2))
+	tassert.Equal(t, []string{"", "x"}, rasterized)
+
+	_, err = d2export.Export(ctx, compile(t, ctx, ruler, input), "gif", opts)
+	tassert.EqualError(t, err, `unsupported export format "gif"`)
+}
+
+func TestBoardIndexes(t *testing.T) {
+	t.Parallel()
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	ruler, err := textmeasure.NewRuler()
+	assert.Success(t, err)
+	diagram := compile(t, ctx, ruler, `a
+layers: {
+  x: {
+    b
+    steps: {
+      1: {c}
+    }
+  }
+}
+scenarios: {
+  y: {d}
+}
+`)
+	tassert.Equal(t, map[string]int{
+		"root":                  0,
+		"root.layers.x":         1,
+		"root.layers.x.steps.1": 2,
+		"root.scenarios.y":      3,
+	}, d2export.BoardIndexes(diagram))
+}
@@ -0,0 +1,327 @@
+package d2export
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.uber.org/multierr"
+
+	"oss.terrastruct.com/d2/d2parser"
+	"oss.terrastruct.com/d2/d2renderers/d2svg"
+	"oss.terrastruct.com/d2/d2renderers/d2svg/appendix"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/background"
+	"oss.terrastruct.com/d2/lib/imgbundler"
+	"oss.terrastruct.com/d2/lib/pdf"
+	"oss.terrastruct.com/d2/lib/png"
+	"oss.terrastruct.com/d2/lib/pptx"
+	"oss.terrastruct.com/d2/lib/simplelog"
+	"oss.terrastruct.com/util-go/go2"
+)
+
+// PDFPage renders diagram, at boardPath, for a page of doc and starts rasterizing it unless
+// it is drawn as vector shapes. The page is only added by addPage, so that pages are added in
+// order while the boards of several are rasterized in parallel. pageMap is the index of the
+// page of every board, see BoardIndexes, and includeNav adds the titles of boards above them.
+func PDFPage(ctx context.Context, doc *pdf.GoFPDF, diagram *d2target.Diagram, boardPath []pdf.BoardTitle, pageMap map[string]int, includeNav bool, opts Opts) (svg []byte, addPage func() error, err error) {
+	opts, err = opts.withDefaults()
+	if err != nil {
+		return nil, nil, err
+	}
+	rootFill := diagram.Root.Fill
+	// gofpdf prints PNGs with a slight filter, so the fill of boards is left to the page to
+	// not be printed twice.
+	diagram.Root.Fill = "transparent"
+	svg, renderOpts, err := pageSVG(ctx, diagram, opts)
+	if err != nil {
+		return svg, nil, err
+	}
+	// Siblings append to the same boardPath.
+	boardPath = append([]pdf.BoardTitle(nil), boardPath...)
+	themeID, pad := *renderOpts.ThemeID, *renderOpts.Pad
+
+	images, vector, err := vectorImages(ctx, diagram, opts)
+	if err != nil {
+		return svg, nil, err
+	}
+	if vector {
+		return svg, func() error {
+			return doc.AddVectorPage(diagram, images, boardPath, themeID, rootFill, pad, *renderOpts.Scale, pageMap, includeNav)
+		}, nil
+	}
+
+	size, pngScale := pageSize(opts)
+	conv, err := opts.rasterize(ctx, diagram, renderOpts, svg, size)
+	if err != nil {
+		return svg, nil, err
+	}
+	viewboxX, viewboxY, err := viewboxOrigin(svg)
+	if err != nil {
+		return svg, nil, err
+	}
+	shapes := diagram.Shapes
+	return svg, func() error {
+		pngImg, err := wait(opts.Logger, conv)
+		if err != nil {
+			return err
+		}
+		return doc.AddPDFPage(pngImg, pngScale, boardPath, themeID, rootFill, shapes, pad, viewboxX, viewboxY, pageMap, includeNav)
+	}, nil
+}
+
+// PPTXSlide renders diagram, at boardPath, for a slide of presentation and starts
+// rasterizing it unless it is drawn as native shapes. The slide is only added by addSlide,
+// like PDFPage. boardIDToIndex is the index of the slide of every board, for links.
+func PPTXSlide(ctx context.Context, presentation *pptx.Presentation, diagram *d2target.Diagram, boardPath []pptx.BoardTitle, boardIDToIndex map[string]int, opts Opts) (svg []byte, addSlide func() error, err error) {
+	opts, err = opts.withDefaults()
+	if err != nil {
+		return nil, nil, err
+	}
+	diagram.Root.Fill = "transparent"
+	svg, renderOpts, err := pageSVG(ctx, diagram, opts)
+	if err != nil {
+		return svg, nil, err
+	}
+	// Siblings append to the same boardPath.
+	boardPath = append([]pptx.BoardTitle(nil), boardPath...)
+	themeID, pad := *renderOpts.ThemeID, *renderOpts.Pad
+	linkTo := func(link string) (string, int) {
+		return PPTXLink(link, boardIDToIndex)
+	}
+
+	if !opts.Raster && !isSketch(renderOpts) && pptx.CanDrawNative(diagram) {
+		return svg, func() error {
+			slide, err := presentation.AddNativeSlide(diagram, themeID, pad, boardPath, linkTo)
+			if err != nil {
+				return err
+			}
+			slide.Notes = pptx.Notes(diagram)
+			if opts.PPTXCallouts {
+				tl, _ := diagram.BoundingBox()
+				addPPTXCallouts(presentation, slide, diagram.Shapes, float64(tl.X-int(pad)), float64(tl.Y-int(pad)), 1)
+			}
+			return nil
+		}, nil
+	}
+
+	size, pngScale := pageSize(opts)
+	conv, err := opts.rasterize(ctx, diagram, renderOpts, svg, size)
+	if err != nil {
+		return svg, nil, err
+	}
+	viewboxX, viewboxY, err := viewboxOrigin(svg)
+	if err != nil {
+		return svg, nil, err
+	}
+	shapes := diagram.Shapes
+	return svg, func() error {
+		pngImg, err := wait(opts.Logger, conv)
+		if err != nil {
+			return err
+		}
+		slide, err := presentation.AddSlide(pngImg, pngScale, boardPath)
+		if err != nil {
+			return err
+		}
+		addPPTXLinks(slide, shapes, viewboxX, viewboxY, boardIDToIndex)
+		slide.Notes = pptx.Notes(diagram)
+		if opts.PPTXCallouts {
+			addPPTXCallouts(presentation, slide, shapes, viewboxX, viewboxY, png.SCALE)
+		}
+		return nil
+	}, nil
+}
+
+// pageSVG renders diagram into the SVG of a PDF page or PPTX slide, with all its images
+// bundled and its appendix, returning the options it was rendered with.
+func pageSVG(ctx context.Context, diagram *d2target.Diagram, opts Opts) ([]byte, *d2svg.RenderOpts, error) {
+	renderOpts := &d2svg.RenderOpts{
+		Pad:                 opts.RenderOpts.Pad,
+		Sketch:              opts.RenderOpts.Sketch,
+		Center:              opts.RenderOpts.Center,
+		Scale:               opts.RenderOpts.Scale,
+		ThemeID:             opts.RenderOpts.ThemeID,
+		NoElementAnimations: true,
+	}
+	if renderOpts.Pad == nil {
+		renderOpts.Pad = go2.Pointer(int64(d2svg.DEFAULT_PADDING))
+	}
+	if renderOpts.Scale == nil {
+		renderOpts.Scale = go2.Pointer(1.)
+	}
+	if renderOpts.ThemeID == nil {
+		renderOpts.ThemeID = go2.Pointer(int64(0))
+	}
+	svg, err := d2svg.Render(diagram, renderOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.PostProcess != nil {
+		svg, err = opts.PostProcess(ctx, svg)
+		if err != nil {
+			return svg, nil, err
+		}
+	}
+
+	svg, bundleErr := imgbundler.BundleLocal(ctx, opts.Logger, opts.InputPath, svg, opts.CacheImages)
+	svg, bundleErr2 := imgbundler.BundleRemote(ctx, opts.Logger, svg, opts.CacheImages)
+	bundleErr = multierr.Combine(bundleErr, bundleErr2)
+	if bundleErr != nil {
+		return svg, nil, bundleErr
+	}
+	return AppendAppendix(diagram, opts.Ruler, svg), renderOpts, nil
+}
+
+// vectorImages fetches the images of diagram for pdf.AddVectorPage, vector being false if
+// the page of diagram is rasterized instead.
+func vectorImages(ctx context.Context, diagram *d2target.Diagram, opts Opts) (_ map[string]pdf.Image, vector bool, _ error) {
+	if opts.Raster || isSketch(&opts.RenderOpts) {
+		return nil, false, nil
+	}
+	images := make(map[string]pdf.Image)
+	for _, href := range pdf.ImageHrefs(diagram) {
+		data, mimeType, err := imgbundler.Image(ctx, opts.Logger, opts.InputPath, href, opts.CacheImages)
+		if err != nil {
+			return nil, false, err
+		}
+		images[href] = pdf.Image{Data: data, MimeType: mimeType}
+	}
+	return images, pdf.CanDrawVector(diagram, images), nil
+}
+
+// pageSize returns the size PDF pages and PPTX slides are rasterized at, which are sized by
+// their boards rather than the width and height of opts.Size, and its scale.
+func pageSize(opts Opts) (png.Size, float64) {
+	size := opts.Size
+	size.Width = 0
+	size.Height = 0
+	scale := size.Scale
+	if scale == 0 {
+		scale = png.SCALE
+	}
+	return size, scale
+}
+
+// viewboxOrigin returns the top left of the viewbox of svg.
+func viewboxOrigin(svg []byte) (x, y float64, err error) {
+	viewboxSlice := appendix.FindViewboxSlice(svg)
+	x, err = strconv.ParseFloat(viewboxSlice[0], 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	y, err = strconv.ParseFloat(viewboxSlice[1], 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return x, y, nil
+}
+
+// addPPTXLinks adds the links of shapes to slide.
+func addPPTXLinks(slide *pptx.Slide, shapes []d2target.Shape, viewboxX, viewboxY float64, boardIDToIndex map[string]int) {
+	for _, shape := range shapes {
+
+		if shape.Link == "" {
+			continue
+		}
+
+		linkX := png.SCALE * (float64(shape.Pos.X) - viewboxX - float64(shape.StrokeWidth))
+		linkY := png.SCALE * (float64(shape.Pos.Y) - viewboxY - float64(shape.StrokeWidth))
+		linkWidth := png.SCALE * (float64(shape.Width) + float64(shape.StrokeWidth*2))
+		linkHeight := png.SCALE * (float64(shape.Height) + float64(shape.StrokeWidth*2))
+		link := &pptx.Link{
+			Left:    int(linkX),
+			Top:     int(linkY),
+			Width:   int(linkWidth),
+			Height:  int(linkHeight),
+			Tooltip: shape.Link,
+		}
+		slide.AddLink(link)
+		link.ExternalUrl, link.SlideIndex = PPTXLink(shape.Link, boardIDToIndex)
+	}
+}
+
+// addPPTXCallouts adds the tooltips of shapes to slide as callouts, the drawing of the slide
+// having its top left at x, y and scale pixels per pixel of the board.
+func addPPTXCallouts(presentation *pptx.Presentation, slide *pptx.Slide, shapes []d2target.Shape, x, y, scale float64) {
+	for _, shape := range shapes {
+		if shape.Tooltip == "" {
+			continue
+		}
+		presentation.AddCallout(slide, shape.Tooltip,
+			scale*(float64(shape.Pos.X)-x),
+			scale*(float64(shape.Pos.Y)-y),
+			scale*float64(shape.Width),
+			scale*float64(shape.Height),
+		)
+	}
+}
+
+// PPTXLink returns the URL of an external link or else the number of the slide of the board
+// an internal link goes to, 0 if it has none.
+func PPTXLink(link string, boardIDToIndex map[string]int) (externalUrl string, slideIndex int) {
+	key, err := d2parser.ParseKey(link)
+	if err != nil || key.Path[0].Unbox().ScalarString() != "root" {
+		// External link
+		return link, 0
+	}
+	if pageNum, ok := boardIDToIndex[link]; ok {
+		// Internal link
+		return "", pageNum + 1
+	}
+	return "", 0
+}
+
+// rasterize starts converting svg, diagram rendered with renderOpts, into a PNG of size with
+// opts.Rasterize, or else without a browser when possible.
+func (opts Opts) rasterize(ctx context.Context, diagram *d2target.Diagram, renderOpts *d2svg.RenderOpts, svg []byte, size png.Size) (*png.Conversion, error) {
+	if opts.Rasterize != nil {
+		return opts.Rasterize(ctx, diagram, renderOpts, svg, size)
+	}
+	if !isSketch(renderOpts) && png.CanDrawNative(diagram) {
+		return ConvertNative(diagram, renderOpts, size), nil
+	}
+	if opts.Playwright == nil {
+		return nil, fmt.Errorf("a browser is needed to rasterize board %q", diagram.Name)
+	}
+	if opts.Playwright.Browser == nil {
+		pw, err := png.InitPlaywright("")
+		if err != nil {
+			return nil, err
+		}
+		*opts.Playwright = pw
+	}
+	return opts.Playwright.ConvertSize(svg, size), nil
+}
+
+// ConvertNative is png.ConvertNative of diagram rendered with renderOpts.
+func ConvertNative(diagram *d2target.Diagram, renderOpts *d2svg.RenderOpts, size png.Size) *png.Conversion {
+	var themeID int64
+	if renderOpts.ThemeID != nil {
+		themeID = *renderOpts.ThemeID
+	}
+	pad := int64(d2svg.DEFAULT_PADDING)
+	if renderOpts.Pad != nil {
+		pad = *renderOpts.Pad
+	}
+	var scale float64
+	if renderOpts.Scale != nil {
+		scale = *renderOpts.Scale
+	}
+	return png.ConvertNative(diagram, themeID, pad, scale, renderOpts.Crop, size)
+}
+
+// wait waits for conv, logging that it is still converting every 5 seconds.
+func wait(l simplelog.Logger, conv *png.Conversion) ([]byte, error) {
+	cancel := background.Repeat(func() {
+		l.Info("converting to PNG...")
+	}, time.Second*5)
+	defer cancel()
+
+	return conv.Wait()
+}
+
+func isSketch(renderOpts *d2svg.RenderOpts) bool {
+	return renderOpts.Sketch != nil && *renderOpts.Sketch
+}
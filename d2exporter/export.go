@@ -27,6 +27,8 @@ func Export(ctx context.Context, g *d2graph.Graph, fontFamily *d2fonts.FontFamil
 	}
 	diagram.Name = g.Name
 	diagram.IsFolderOnly = g.IsFolderOnly
+	diagram.Description = g.Description
+	diagram.Transition = g.Transition
 	if fontFamily == nil {
 		fontFamily = go2.Pointer(d2fonts.SourceSansPro)
 	}
@@ -179,6 +181,8 @@ func toShape(obj *d2graph.Object, g *d2graph.Graph) d2target.Shape {
 		if obj.ContentAspectRatio != nil {
 			shape.ContentAspectRatio = go2.Pointer(*obj.ContentAspectRatio)
 		}
+	case d2target.ShapeCustom:
+		shape.ShapePath = obj.ShapePathData
 	}
 	shape.Label = text.Text
 	shape.LabelWidth = text.Dimensions.Width
@@ -194,6 +198,7 @@ func toShape(obj *d2graph.Object, g *d2graph.Graph) d2target.Shape {
 	if obj.Tooltip != nil {
 		shape.Tooltip = obj.Tooltip.Value
 	}
+	shape.Animate = toAnimation(obj.Animate)
 	if obj.Link != nil {
 		shape.Link = obj.Link.Value
 		shape.PrettyLink = toPrettyLink(g, obj.Link.Value)
@@ -332,6 +337,7 @@ func toConnection(edge *d2graph.Edge, theme *d2themes.Theme) d2target.Connection
 	if edge.Tooltip != nil {
 		connection.Tooltip = edge.Tooltip.Value
 	}
+	connection.Animate = toAnimation(edge.Animate)
 	connection.Icon = edge.Icon
 
 	if edge.Style.Italic != nil {
@@ -364,12 +370,10 @@ func toConnection(edge *d2graph.Edge, theme *d2themes.Theme) d2target.Connection
 	if edge.LabelPercentage != nil {
 		connection.LabelPercentage = float64(float32(*edge.LabelPercentage))
 	}
-	connection.Route = make([]*geo.Point, 0, len(edge.Route))
-	for i := range edge.Route {
-		p := edge.Route[i].Copy()
+	connection.Route = geo.Points(edge.Route).Copy()
+	for _, p := range connection.Route {
 		p.TruncateDecimals()
 		p.TruncateFloat32()
-		connection.Route = append(connection.Route, p)
 	}
 
 	connection.IsCurve = edge.IsCurve
@@ -379,3 +383,16 @@ func toConnection(edge *d2graph.Edge, theme *d2themes.Theme) d2target.Connection
 
 	return *connection
 }
+
+// toAnimation returns the animation of the animate keyword set to s, validated by the
+// compiler.
+func toAnimation(s *d2graph.Scalar) *d2target.Animation {
+	if s == nil {
+		return nil
+	}
+	a, err := d2target.ParseAnimation(s.Value)
+	if err != nil {
+		return nil
+	}
+	return &a
+}
@@ -313,6 +313,68 @@ a -> b
 	assert.NotEqual(t, hashA, hashB)
 }
 
+func TestStableIDs(t *testing.T) {
+	ctx := context.Background()
+	ctx = log.WithTB(ctx, t, nil)
+	ctx = log.Leveled(ctx, slog.LevelDebug)
+
+	compileStable := func(d2 string) *d2target.Diagram {
+		ruler, _ := textmeasure.NewRuler()
+		d, _, err := d2lib.Compile(ctx, d2, &d2lib.CompileOptions{
+			Ruler:          ruler,
+			LayoutResolver: layoutResolver,
+			Layout:         go2.Pointer("dagre"),
+			StableIDs:      true,
+		}, nil)
+		assert.Success(t, err)
+		return d
+	}
+
+	da := compileStable(`a -> b
+layers: {
+  x: {
+    a
+  }
+}
+`)
+	db := compileStable(`c
+A -> b: hello
+a.style.fill: red
+layers: {
+  x: {
+    a
+  }
+}
+`)
+
+	stableID := func(d *d2target.Diagram, id string) string {
+		for _, s := range d.Shapes {
+			if s.ID == id {
+				return s.StableID
+			}
+		}
+		for _, c := range d.Connections {
+			if c.ID == id {
+				return c.StableID
+			}
+		}
+		t.Fatalf("%s not found", id)
+		return ""
+	}
+
+	tassert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, stableID(da, "a"))
+	tassert.Equal(t, stableID(da, "a"), stableID(db, "A"))
+	tassert.Equal(t, stableID(da, "b"), stableID(db, "b"))
+	tassert.Equal(t, stableID(da, "(a -> b)[0]"), stableID(db, "(A -> b)[0]"))
+	tassert.NotEqual(t, stableID(da, "a"), stableID(da, "b"))
+	tassert.NotEqual(t, stableID(da, "a"), stableID(da.Layers[0], "a"))
+	tassert.Equal(t, stableID(da.Layers[0], "a"), stableID(db.Layers[0], "a"))
+
+	d, err := compile(ctx, "a")
+	assert.Success(t, err)
+	tassert.Equal(t, "", d.Shapes[0].StableID)
+}
+
 func layoutResolver(engine string) (d2graph.LayoutGraph, error) {
 	return d2dagrelayout.DefaultLayout, nil
 }
@@ -86,3 +86,14 @@ func escapeUnquotedValue(s string, inKey bool) string {
 	}
 	return b.String()
 }
+
+// canSingleQuote reports whether s means the same written with single quotes, which do not
+// substitute variables or support escape sequences.
+func canSingleQuote(s *d2ast.DoubleQuotedString) bool {
+	for _, b := range s.Value {
+		if b.Substitution != nil || b.String == nil || strings.ContainsRune(*b.String, '\n') {
+			return false
+		}
+	}
+	return true
+}
@@ -10,23 +10,44 @@ import (
 
 // TODO: edges with shared path should be fmted as <rel>.(x -> y)
 func Format(n d2ast.Node) string {
-	var p printer
+	return FormatWithOptions(n, nil)
+}
+
+// FormatWithOptions is like Format but writes the style configured by opts.
+func FormatWithOptions(n d2ast.Node, opts *Options) string {
+	if opts == nil {
+		opts = &Options{}
+	}
+	p := printer{
+		opts:       opts,
+		indentUnit: opts.indentUnit(),
+	}
 	p.node(n)
 	return p.sb.String()
 }
 
 type printer struct {
-	sb        strings.Builder
-	indentStr string
-	inKey     bool
+	sb         strings.Builder
+	opts       *Options
+	indentUnit string
+	indentStr  string
+	inKey      bool
 }
 
 func (p *printer) indent() {
-	p.indentStr += " " + " "
+	p.indentStr += p.indentUnit
 }
 
 func (p *printer) deindent() {
-	p.indentStr = p.indentStr[:len(p.indentStr)-2]
+	p.indentStr = p.indentStr[:len(p.indentStr)-len(p.indentUnit)]
+}
+
+// oneLine reports whether the map or array with range r is written on one line.
+func (p *printer) oneLine(r d2ast.Range, empty bool) bool {
+	if p.opts.Braces == BracesExpand && !empty {
+		return false
+	}
+	return r.OneLine()
 }
 
 func (p *printer) newline() {
@@ -49,10 +70,22 @@ func (p *printer) node(n d2ast.Node) {
 	case *d2ast.UnquotedString:
 		p.interpolationBoxes(n.Value, false)
 	case *d2ast.DoubleQuotedString:
+		if p.opts.Quotes == QuotesSingle && canSingleQuote(n) {
+			p.sb.WriteByte('\'')
+			p.sb.WriteString(escapeSingleQuotedValue(n.ScalarString()))
+			p.sb.WriteByte('\'')
+			return
+		}
 		p.sb.WriteByte('"')
 		p.interpolationBoxes(n.Value, true)
 		p.sb.WriteByte('"')
 	case *d2ast.SingleQuotedString:
+		if p.opts.Quotes == QuotesDouble {
+			p.sb.WriteByte('"')
+			p.sb.WriteString(escapeDoubledQuotedValue(n.Value, p.inKey))
+			p.sb.WriteByte('"')
+			return
+		}
 		p.sb.WriteByte('\'')
 		if n.Raw == "" {
 			n.Raw = escapeSingleQuotedValue(n.Value)
@@ -226,8 +259,9 @@ func (p *printer) _import(i *d2ast.Import) {
 }
 
 func (p *printer) array(a *d2ast.Array) {
+	oneLine := p.oneLine(a.Range, len(a.Nodes) == 0)
 	p.sb.WriteByte('[')
-	if !a.Range.OneLine() {
+	if !oneLine {
 		p.indent()
 	}
 
@@ -245,7 +279,7 @@ func (p *printer) array(a *d2ast.Array) {
 			}
 		}
 
-		if !a.Range.OneLine() {
+		if !oneLine {
 			if prev != a {
 				if n.GetRange().Start.Line-prev.GetRange().End.Line > 1 {
 					p.sb.WriteByte('\n')
@@ -260,7 +294,7 @@ func (p *printer) array(a *d2ast.Array) {
 		prev = n
 	}
 
-	if !a.Range.OneLine() {
+	if !oneLine {
 		p.deindent()
 		p.newline()
 	}
@@ -268,20 +302,27 @@ func (p *printer) array(a *d2ast.Array) {
 }
 
 func (p *printer) _map(m *d2ast.Map) {
+	oneLine := p.oneLine(m.Range, len(m.Nodes) == 0)
 	if !m.IsFileMap() {
 		p.sb.WriteByte('{')
-		if !m.Range.OneLine() {
+		if !oneLine {
 			p.indent()
 		}
 	}
 
+	nodes := m.Nodes
+	var blankBefore map[d2ast.Node]bool
+	if p.opts.SortKeys {
+		nodes, blankBefore = sortMapNodes(nodes)
+	}
+
 	layerNodes := []d2ast.MapNodeBox{}
 	scenarioNodes := []d2ast.MapNodeBox{}
 	stepNodes := []d2ast.MapNodeBox{}
 
 	prev := d2ast.Node(m)
-	for i := 0; i < len(m.Nodes); i++ {
-		nb := m.Nodes[i]
+	for i := 0; i < len(nodes); i++ {
+		nb := nodes[i]
 		n := nb.Unbox()
 		// extract out layer, scenario, and step nodes and skip
 		if nb.IsBoardNode() {
@@ -306,9 +347,13 @@ func (p *printer) _map(m *d2ast.Map) {
 			}
 		}
 
-		if !m.Range.OneLine() {
+		if !oneLine {
 			if prev != m {
-				if n.GetRange().Start.Line-prev.GetRange().End.Line > 1 {
+				if blankBefore != nil {
+					if blankBefore[n] {
+						p.sb.WriteByte('\n')
+					}
+				} else if n.GetRange().Start.Line-prev.GetRange().End.Line > 1 {
 					p.sb.WriteByte('\n')
 				}
 			}
@@ -344,7 +389,7 @@ func (p *printer) _map(m *d2ast.Map) {
 	}
 
 	if !m.IsFileMap() {
-		if !m.Range.OneLine() {
+		if !oneLine {
 			p.deindent()
 			p.newline()
 		}
@@ -861,3 +861,280 @@ func TestEdge(t *testing.T) {
 	assert.String(t, `x -> y`, d2format.Format(mk.Edges[0]))
 	assert.String(t, `[0]`, d2format.Format(mk.EdgeIndex))
 }
+
+func TestFormatWithOptions(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		opts d2format.Options
+		in   string
+		exp  string
+	}{
+		{
+			name: "indent_width",
+			opts: d2format.Options{IndentWidth: 4},
+			in: `a: {
+b: {
+c
+}
+}
+`,
+			exp: `a: {
+    b: {
+        c
+    }
+}
+`,
+		},
+		{
+			name: "tabs",
+			opts: d2format.Options{Tabs: true, IndentWidth: 4},
+			in: `a: {
+b: |md
+  # hi
+|
+}
+`,
+			exp: "a: {\n\tb: |md\n\t\t# hi\n\t|\n}\n",
+		},
+		{
+			name: "double_quotes",
+			opts: d2format.Options{Quotes: d2format.QuotesDouble},
+			in: `'a"b': 'it''s $5'
+`,
+			exp: `"a\"b": "it's \$5"
+`,
+		},
+		{
+			name: "single_quotes",
+			opts: d2format.Options{Quotes: d2format.QuotesSingle},
+			in: `"a": "it's"
+b: "${x}"
+c: "x\ny"
+`,
+			exp: `'a': 'it''s'
+b: "${x}"
+c: "x\ny"
+`,
+		},
+		{
+			name: "expand_braces",
+			opts: d2format.Options{Braces: d2format.BracesExpand},
+			in: `a: {shape: circle; style: {fill: red}}
+b: {}
+c: [1; 2]
+`,
+			exp: `a: {
+  shape: circle
+  style: {
+    fill: red
+  }
+}
+b
+c: [
+  1
+  2
+]
+`,
+		},
+		{
+			name: "sort_keys",
+			opts: d2format.Options{SortKeys: true},
+			in: `c
+# b is the second.
+b -> a
+b.style.fill: red # red
+a: {shape: circle; label: A}
+b: {style.fill: blue}
+
+z
+y
+...@imported
+x
+w
+`,
+			exp: `a: {label: A; shape: circle}
+b.style.fill: red # red
+b: {style.fill: blue}
+c
+# b is the second.
+b -> a
+
+y
+z
+...@imported
+w
+x
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ast, err := d2parser.Parse(fmt.Sprintf("%s.d2", t.Name()), strings.NewReader(tc.in), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.String(t, tc.exp, d2format.FormatWithOptions(ast, &tc.opts))
+		})
+	}
+}
+
+func TestConfigOptions(t *testing.T) {
+	t.Parallel()
+
+	ast, err := d2parser.Parse("", strings.NewReader(`vars: {
+  d2-config: {
+    fmt: {
+      indent-width: 4
+      quotes: single
+    }
+  }
+}
+vars.d2-config.fmt.sort-keys: true
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := d2format.ConfigOptions(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *opts != (d2format.Options{IndentWidth: 4, Quotes: d2format.QuotesSingle, SortKeys: true}) {
+		t.Fatalf("unexpected options: %#v", opts)
+	}
+
+	ast, err = d2parser.Parse("", strings.NewReader(`vars.d2-config.fmt.braces: curly`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d2format.ConfigOptions(ast)
+	assert.ErrorString(t, err, `1:28: expected one of preserve or expand for "braces", got "curly"`)
+}
+
+func TestFormatRange(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		opts *d2format.Options
+		in   string
+		// sel is the selected text of in, which must be unique.
+		sel string
+		exp string
+	}{
+		{
+			name: "top_level",
+			in: `a   ->   b
+c  :  {shape:   circle}
+d   ->   e
+`,
+			sel: "c  :",
+			exp: `a   ->   b
+c: {shape: circle}
+d   ->   e
+`,
+		},
+		{
+			name: "nested",
+			in: `a   ->   b
+x: {
+     y   ->   z
+  w    # comment
+        v:   {
+  u
+  }
+}
+`,
+			sel: "z\n  w",
+			exp: `a   ->   b
+x: {
+  y -> z
+  w # comment
+        v:   {
+  u
+  }
+}
+`,
+		},
+		{
+			name: "multiple",
+			in: `a   ->   b
+
+c    -> d
+  # hi
+e
+f  ->  g
+`,
+			sel: "b\n\nc    -> d\n  # hi\ne",
+			exp: `a -> b
+
+c -> d
+# hi
+e
+f  ->  g
+`,
+		},
+		{
+			name: "one_line_map",
+			in: `x   : {
+  y: {a  ;   b}
+}
+`,
+			sel: "a",
+			exp: `x   : {
+  y: {a; b}
+}
+`,
+		},
+		{
+			name: "options",
+			opts: &d2format.Options{IndentWidth: 4},
+			in: `x: {
+  y: {
+a
+  }
+}
+`,
+			sel: "y",
+			exp: `x: {
+    y: {
+        a
+    }
+}
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			start := strings.Index(tc.in, tc.sel)
+			if start == -1 || strings.Count(tc.in, tc.sel) != 1 {
+				t.Fatalf("selection %q is not unique", tc.sel)
+			}
+			e, ok, err := d2format.FormatRange("", tc.in, start, start+len(tc.sel), tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Fatal("expected an edit")
+			}
+			assert.String(t, tc.exp, e.Apply(tc.in))
+		})
+	}
+
+	_, ok, err := d2format.FormatRange("", "a\n\n\nb\n", 3, 3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("expected no edit of a blank line")
+	}
+}
@@ -0,0 +1,256 @@
+package d2format
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"oss.terrastruct.com/d2/d2ast"
+)
+
+// Options configures the style Format writes. The zero value is the canonical style.
+type Options struct {
+	// IndentWidth is the number of spaces per level of indentation. It defaults to 2 and is
+	// ignored with Tabs.
+	IndentWidth int
+	// Tabs indents with a tab per level instead of spaces.
+	Tabs bool
+	// Quotes normalizes the quotes of strings that can be written with either.
+	Quotes QuoteStyle
+	// Braces controls whether maps written on one line stay on one line.
+	Braces BraceStyle
+	// SortKeys sorts the keys of maps. See sortMapNodes for which keys are sorted.
+	SortKeys bool
+}
+
+type QuoteStyle string
+
+const (
+	// QuotesPreserve keeps strings quoted as written.
+	QuotesPreserve QuoteStyle = "preserve"
+	// QuotesDouble rewrites single quoted strings with double quotes.
+	QuotesDouble QuoteStyle = "double"
+	// QuotesSingle rewrites double quoted strings without substitutions with single quotes.
+	QuotesSingle QuoteStyle = "single"
+)
+
+type BraceStyle string
+
+const (
+	// BracesPreserve keeps maps on one line if they were written on one line.
+	BracesPreserve BraceStyle = "preserve"
+	// BracesExpand writes every nonempty map with one key per line.
+	BracesExpand BraceStyle = "expand"
+)
+
+// ConfigPath is the path of the map within a D2 file that configures how it is formatted.
+var ConfigPath = []string{"vars", "d2-config", "fmt"}
+
+// Set sets the option of a key of the fmt config map, e.g. indent-width, to value.
+func (o *Options) Set(key, value string) error {
+	switch key {
+	case "indent-width":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 16 {
+			return fmt.Errorf(`expected an integer between 1 and 16 for "%s", got "%s"`, key, value)
+		}
+		o.IndentWidth = n
+	case "tabs":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf(`expected a boolean for "%s", got "%s"`, key, value)
+		}
+		o.Tabs = b
+	case "quotes":
+		switch q := QuoteStyle(value); q {
+		case QuotesPreserve, QuotesDouble, QuotesSingle:
+			o.Quotes = q
+		default:
+			return fmt.Errorf(`expected one of preserve, double or single for "%s", got "%s"`, key, value)
+		}
+	case "braces":
+		switch b := BraceStyle(value); b {
+		case BracesPreserve, BracesExpand:
+			o.Braces = b
+		default:
+			return fmt.Errorf(`expected one of preserve or expand for "%s", got "%s"`, key, value)
+		}
+	case "sort-keys":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf(`expected a boolean for "%s", got "%s"`, key, value)
+		}
+		o.SortKeys = b
+	default:
+		return fmt.Errorf(`"%s" is not a valid fmt config`, key)
+	}
+	return nil
+}
+
+// ConfigOptions returns the options configured within the vars.d2-config.fmt map of m, the
+// AST of a file. Only the file itself is read, not its imports, so that formatting does
+// not depend on other files.
+func ConfigOptions(m *d2ast.Map) (*Options, error) {
+	opts := &Options{}
+	var err error
+	walkConfig(m, nil, func(path []string, v d2ast.Scalar) {
+		if err != nil || len(path) != len(ConfigPath)+1 {
+			return
+		}
+		for i, k := range ConfigPath {
+			if path[i] != k {
+				return
+			}
+		}
+		if err2 := opts.Set(path[len(path)-1], v.ScalarString()); err2 != nil {
+			err = configError(v, err2)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// walkConfig calls fn with the path and value of every scalar key within the config map
+// of m, through both nested maps and dotted keys.
+func walkConfig(m *d2ast.Map, prefix []string, fn func([]string, d2ast.Scalar)) {
+	if m == nil {
+		return
+	}
+	for _, n := range m.Nodes {
+		mk := n.MapKey
+		if mk == nil || mk.Key == nil || len(mk.Edges) > 0 {
+			continue
+		}
+		path := append(append([]string{}, prefix...), KeyPath(mk.Key)...)
+		// Only descend into the config path.
+		isPrefix := true
+		for i := 0; i < len(path) && i < len(ConfigPath); i++ {
+			if path[i] != ConfigPath[i] {
+				isPrefix = false
+				break
+			}
+		}
+		if !isPrefix {
+			continue
+		}
+		if mk.Value.Map != nil {
+			walkConfig(mk.Value.Map, path, fn)
+		} else if s, ok := mk.Value.Unbox().(d2ast.Scalar); ok && s != nil {
+			fn(path, s)
+		}
+	}
+}
+
+// configError positions err at n the way d2parser.Errorf does.
+func configError(n d2ast.Node, err error) error {
+	return d2ast.Error{
+		Range:   n.GetRange(),
+		Message: fmt.Sprintf("%s: %s", n.GetRange(), err),
+	}
+}
+
+func (o *Options) indentUnit() string {
+	if o.Tabs {
+		return "\t"
+	}
+	width := o.IndentWidth
+	if width <= 0 {
+		width = 2
+	}
+	return strings.Repeat(" ", width)
+}
+
+// sortMapNodes returns nodes with runs of keys sorted, along with the nodes that blank
+// lines should precede as their ranges are out of order. Keys are only sorted within runs
+// not separated by blank lines, imports or spread substitutions, which would change what
+// they apply to. Full line comments directly above a key and comments after it on the same
+// line move with it.
+//
+// Keys are ordered by their first element, and edges after every other key by their
+// endpoints, both stably so that keys on the same object or edge, whose order may matter,
+// keep it.
+func sortMapNodes(nodes []d2ast.MapNodeBox) (_ []d2ast.MapNodeBox, blankBefore map[d2ast.Node]bool) {
+	out := make([]d2ast.MapNodeBox, 0, len(nodes))
+	blankBefore = make(map[d2ast.Node]bool)
+	// block holds the units of the current run, each a key with its comments.
+	var block [][]d2ast.MapNodeBox
+	// leading holds the comments of the next key.
+	var leading []d2ast.MapNodeBox
+	// blank is whether a blank line precedes the nodes not yet written to out.
+	blank := false
+	write := func(nbs ...d2ast.MapNodeBox) {
+		if len(nbs) > 0 && blank {
+			blankBefore[nbs[0].Unbox()] = true
+			blank = false
+		}
+		out = append(out, nbs...)
+	}
+	flush := func() {
+		sort.SliceStable(block, func(i, j int) bool {
+			return sortKey(unitKey(block[i])) < sortKey(unitKey(block[j]))
+		})
+		for _, unit := range block {
+			write(unit...)
+		}
+		write(leading...)
+		block = nil
+		leading = nil
+	}
+
+	for i, nb := range nodes {
+		r := nb.Unbox().GetRange()
+		var prev d2ast.Node
+		if i > 0 {
+			prev = nodes[i-1].Unbox()
+			if r.Start.Line-prev.GetRange().End.Line > 1 {
+				flush()
+				blank = true
+			}
+		}
+		switch {
+		case nb.Comment != nil || nb.BlockComment != nil:
+			if _, ok := prev.(*d2ast.Key); ok && len(block) > 0 && len(leading) == 0 && r.Start.Line == prev.GetRange().End.Line {
+				block[len(block)-1] = append(block[len(block)-1], nb)
+			} else {
+				leading = append(leading, nb)
+			}
+		case nb.MapKey != nil && !nb.IsBoardNode():
+			block = append(block, append(leading, nb))
+			leading = nil
+		default:
+			flush()
+			write(nb)
+		}
+	}
+	flush()
+	return out, blankBefore
+}
+
+func unitKey(unit []d2ast.MapNodeBox) *d2ast.Key {
+	for _, nb := range unit {
+		if nb.MapKey != nil {
+			return nb.MapKey
+		}
+	}
+	return nil
+}
+
+func sortKey(mk *d2ast.Key) string {
+	var b strings.Builder
+	if mk.Key != nil && len(mk.Key.Path) > 0 {
+		b.WriteString(strings.ToLower(mk.Key.Path[0].Unbox().ScalarString()))
+	}
+	if len(mk.Edges) == 0 {
+		return "0" + b.String()
+	}
+	for _, e := range mk.Edges {
+		b.WriteByte(0)
+		b.WriteString(strings.ToLower(strings.Join(KeyPath(e.Src), ".")))
+		b.WriteString(e.SrcArrow + "-" + e.DstArrow)
+		b.WriteString(strings.ToLower(strings.Join(KeyPath(e.Dst), ".")))
+	}
+	return "1" + b.String()
+}
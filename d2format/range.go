@@ -0,0 +1,134 @@
+package d2format
+
+import (
+	"strings"
+
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2parser"
+)
+
+// Edit replaces the bytes of a text in [Start, End) with NewText.
+type Edit struct {
+	Start   int
+	End     int
+	NewText string
+}
+
+// Apply returns text with e applied.
+func (e Edit) Apply(text string) string {
+	return text[:e.Start] + e.NewText + text[e.End:]
+}
+
+// FormatRange formats only the keys and comments of text that the byte range [start, end)
+// touches, leaving the rest of text as written. The range is widened to whole nodes of the
+// innermost map that contains it, so that formatting within a key's map formats only its
+// touched children. opts may be nil for the canonical style.
+//
+// ok is false if the range touches no node. Text that does not parse cannot be formatted.
+func FormatRange(path, text string, start, end int, opts *Options) (_ Edit, ok bool, err error) {
+	m, err := d2parser.Parse(path, strings.NewReader(text), nil)
+	if err != nil {
+		return Edit{}, false, err
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+	if end < start {
+		start, end = end, start
+	}
+
+	depth := 0
+	nodes := touchedNodes(m, start, end)
+	for len(nodes) == 1 && nodes[0].MapKey != nil {
+		inner := nodes[0].MapKey.Value.Map
+		// One line maps are formatted as a whole along with their key.
+		if inner == nil || inner.Range.OneLine() || start <= inner.Range.Start.Byte || end >= inner.Range.End.Byte {
+			break
+		}
+		innerNodes := touchedNodes(inner, start, end)
+		if len(innerNodes) == 0 {
+			break
+		}
+		nodes = innerNodes
+		depth++
+	}
+	if len(nodes) == 0 {
+		return Edit{}, false, nil
+	}
+
+	p := printer{
+		opts:       opts,
+		indentUnit: opts.indentUnit(),
+	}
+	p.indentStr = strings.Repeat(p.indentUnit, depth)
+	ordered := nodes
+	var blankBefore map[d2ast.Node]bool
+	if opts.SortKeys {
+		ordered, blankBefore = sortMapNodes(nodes)
+	}
+	var prev d2ast.Node
+	for _, nb := range ordered {
+		n := nb.Unbox()
+		if prev != nil {
+			if (nb.Comment != nil || nb.BlockComment != nil) && n.GetRange().Start.Line == prev.GetRange().End.Line && n.GetRange().OneLine() {
+				p.sb.WriteByte(' ')
+				p.node(n)
+				continue
+			}
+			if blankBefore != nil {
+				if blankBefore[n] {
+					p.sb.WriteByte('\n')
+				}
+			} else if n.GetRange().Start.Line-prev.GetRange().End.Line > 1 {
+				p.sb.WriteByte('\n')
+			}
+			p.newline()
+		}
+		p.node(n)
+		prev = n
+	}
+
+	e := Edit{
+		Start: nodes[0].Unbox().GetRange().Start.Byte,
+		End:   nodes[len(nodes)-1].Unbox().GetRange().End.Byte,
+	}
+	// Reindent the first line too if nothing but whitespace precedes it.
+	lineStart := strings.LastIndexByte(text[:e.Start], '\n') + 1
+	if strings.TrimLeft(text[lineStart:e.Start], " \t") == "" {
+		e.Start = lineStart
+		e.NewText = p.indentStr + p.sb.String()
+	} else {
+		e.NewText = p.sb.String()
+	}
+	return e, true, nil
+}
+
+// touchedNodes returns the consecutive nodes of m that intersect [start, end), or the node
+// containing start if the range is empty.
+func touchedNodes(m *d2ast.Map, start, end int) []d2ast.MapNodeBox {
+	first, last := -1, -1
+	for i, nb := range m.Nodes {
+		r := nb.Unbox().GetRange()
+		touched := r.Start.Byte < end && start < r.End.Byte
+		if start == end {
+			touched = r.Start.Byte <= start && start <= r.End.Byte
+		}
+		if touched {
+			if first == -1 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first == -1 {
+		return nil
+	}
+	// Comments after the last node on the same line go with it.
+	if last+1 < len(m.Nodes) {
+		nb := m.Nodes[last+1]
+		if (nb.Comment != nil || nb.BlockComment != nil) && nb.Unbox().GetRange().Start.Line == m.Nodes[last].Unbox().GetRange().End.Line {
+			last++
+		}
+	}
+	return m.Nodes[first : last+1]
+}
@@ -0,0 +1,51 @@
+package d2graph
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+type layoutBudgetKey struct{}
+
+type layoutBudget struct {
+	d        time.Duration
+	deadline time.Time
+	exceeded atomic.Bool
+}
+
+// WithLayoutBudget returns a context in which the layout of a board has d to complete
+// before layout engines that support it fall back to a cheaper configuration, see
+// WithinLayoutBudget. The budget is shared by all the graphs nested in the board.
+func WithLayoutBudget(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, layoutBudgetKey{}, &layoutBudget{
+		d:        d,
+		deadline: time.Now().Add(d),
+	})
+}
+
+// WithinLayoutBudget runs layout, or cheap if the layout budget of ctx runs out first.
+// layout must return once its context is done and must not have modified g by then, as
+// cheap runs in its stead. Graphs laid out after the budget ran out go straight to cheap.
+//
+// g is warned the first time the budget runs out. Without a budget, layout runs until ctx
+// is done.
+func WithinLayoutBudget(ctx context.Context, g *Graph, layout, cheap func(context.Context) error) error {
+	b, _ := ctx.Value(layoutBudgetKey{}).(*layoutBudget)
+	if b == nil {
+		return layout(ctx)
+	}
+	if !b.exceeded.Load() {
+		bctx, cancel := context.WithDeadline(ctx, b.deadline)
+		err := layout(bctx)
+		exceeded := err != nil && ctx.Err() == nil && bctx.Err() != nil
+		cancel()
+		if !exceeded {
+			return err
+		}
+		if b.exceeded.CompareAndSwap(false, true) {
+			g.Warnf("layout took longer than its budget of %v, falling back to a cheaper configuration", b.d)
+		}
+	}
+	return cheap(ctx)
+}
@@ -0,0 +1,65 @@
+package d2graph_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/d2/d2graph"
+)
+
+func TestWithinLayoutBudget(t *testing.T) {
+	t.Parallel()
+
+	var ran []string
+	slow := func(ctx context.Context) error {
+		ran = append(ran, "slow")
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	fast := func(ctx context.Context) error {
+		ran = append(ran, "fast")
+		return nil
+	}
+	cheap := func(ctx context.Context) error {
+		ran = append(ran, "cheap")
+		return nil
+	}
+
+	// Without a budget, layouts run as they are.
+	g := d2graph.NewGraph()
+	err := d2graph.WithinLayoutBudget(context.Background(), g, fast, cheap)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"fast"}, ran)
+
+	ran = nil
+	ctx := d2graph.WithLayoutBudget(context.Background(), time.Millisecond*10)
+	err = d2graph.WithinLayoutBudget(ctx, g, fast, cheap)
+	assert.Nil(t, err)
+	err = d2graph.WithinLayoutBudget(ctx, g, slow, cheap)
+	assert.Nil(t, err)
+	// Once exceeded, the budget is spent for every graph of the board.
+	nested := d2graph.NewGraph()
+	err = d2graph.WithinLayoutBudget(ctx, nested, slow, cheap)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"fast", "slow", "cheap", "cheap"}, ran)
+	assert.Equal(t, 1, len(g.Warnings))
+	assert.Equal(t, "layout took longer than its budget of 10ms, falling back to a cheaper configuration", g.Warnings[0].Message)
+	assert.Equal(t, 0, len(nested.Warnings))
+
+	// Errors and cancellations of ctx itself are not the budget running out.
+	ran = nil
+	ctx = d2graph.WithLayoutBudget(context.Background(), time.Minute)
+	err = d2graph.WithinLayoutBudget(ctx, g, func(context.Context) error {
+		return errors.New("invalid graph")
+	}, cheap)
+	assert.EqualError(t, err, "invalid graph")
+	cctx, cancel := context.WithTimeout(ctx, time.Millisecond*10)
+	defer cancel()
+	err = d2graph.WithinLayoutBudget(cctx, g, slow, cheap)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, []string{"slow"}, ran)
+}
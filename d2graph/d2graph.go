@@ -43,8 +43,14 @@ type Graph struct {
 	// IsFolderOnly indicates a board or scenario itself makes no modifications from its
 	// base. Folder only boards do not have a render and are used purely for organizing
 	// the board tree.
-	IsFolderOnly bool       `json:"isFolderOnly"`
-	AST          *d2ast.Map `json:"ast"`
+	IsFolderOnly bool `json:"isFolderOnly"`
+	// Description is the description of the board set with the description keyword at its
+	// top level, e.g. the talking points of its slide in PPTX exports.
+	Description string `json:"description,omitempty"`
+	// Transition is how animations transition to the board, set with the transition keyword
+	// at its top level.
+	Transition *d2target.Transition `json:"transition,omitempty"`
+	AST        *d2ast.Map           `json:"ast"`
 	// BaseAST is the AST of the original graph without inherited fields and edges
 	BaseAST *d2ast.Map `json:"-"`
 
@@ -60,6 +66,11 @@ type Graph struct {
 
 	// Object.Level uses the location of a nested graph
 	RootLevel int `json:"rootLevel,omitempty"`
+
+	// Warnings are those layout engines reported while laying out the graph.
+	Warnings []Warning `json:"-"`
+
+	edgeIndices *edgeIndices
 }
 
 func NewGraph() *Graph {
@@ -82,6 +93,9 @@ func (g *Graph) RootBoard() *Graph {
 type LayoutGraph func(context.Context, *Graph) error
 type RouteEdges func(context.Context, *Graph, []*Edge) error
 
+// MutateGraph modifies a graph before it is measured and laid out, e.g. to inject objects.
+type MutateGraph func(context.Context, *Graph) error
+
 // TODO consider having different Scalar types
 // Right now we'll hold any types in Value and just convert, e.g. floats
 type Scalar struct {
@@ -129,6 +143,7 @@ type Attributes struct {
 	Icon    *url.URL `json:"icon,omitempty"`
 	Tooltip *Scalar  `json:"tooltip,omitempty"`
 	Link    *Scalar  `json:"link,omitempty"`
+	Animate *Scalar  `json:"animate,omitempty"`
 
 	WidthAttr  *Scalar `json:"width,omitempty"`
 	HeightAttr *Scalar `json:"height,omitempty"`
@@ -142,6 +157,10 @@ type Attributes struct {
 	Language string         `json:"language,omitempty"`
 	// TODO: default to ShapeRectangle instead of empty string
 	Shape Scalar `json:"shape"`
+	// ShapePath is the shape-path of custom shapes as written, SVG path data or the path of
+	// an SVG file, and ShapePathData the SVG path data it resolves to.
+	ShapePath     *Scalar `json:"shapePath,omitempty"`
+	ShapePathData string  `json:"shapePathData,omitempty"`
 
 	Direction  Scalar   `json:"direction"`
 	Constraint []string `json:"constraint"`
@@ -1301,13 +1320,61 @@ func addSQLTableColumnIndices(e *Edge, srcID, dstID []string, obj, src, dst *Obj
 // TODO: Treat undirectional/bidirectional edge here and in HasEdge flipped. Same with
 // SrcArrow.
 func (e *Edge) initIndex() {
-	for _, e2 := range e.Src.Graph.Edges {
-		if e.Src == e2.Src &&
-			e.SrcArrow == e2.SrcArrow &&
-			e.Dst == e2.Dst &&
-			e.DstArrow == e2.DstArrow {
-			e.Index++
+	g := e.Src.Graph
+	if len(g.Edges) < edgeIndicesThreshold {
+		for _, e2 := range g.Edges {
+			if e.Src == e2.Src &&
+				e.SrcArrow == e2.SrcArrow &&
+				e.Dst == e2.Dst &&
+				e.DstArrow == e2.DstArrow {
+				e.Index++
+			}
 		}
+		return
+	}
+
+	idx := g.edgeIndices
+	if idx == nil || idx.n > len(g.Edges) || (idx.n > 0 && g.Edges[idx.n-1] != idx.last) {
+		// Edges were removed since the edges were counted.
+		idx = &edgeIndices{
+			counts: make(map[edgeEndpoints]int, len(g.Edges)),
+		}
+		g.edgeIndices = idx
+	}
+	for ; idx.n < len(g.Edges); idx.n++ {
+		idx.last = g.Edges[idx.n]
+		idx.counts[idx.last.endpoints()]++
+	}
+	e.Index = idx.counts[e.endpoints()]
+}
+
+// edgeIndicesThreshold is the number of edges from which initIndex counts edges by
+// endpoints instead of scanning them all, which makes compiling graphs with many edges
+// quadratic.
+const edgeIndicesThreshold = 64
+
+// edgeIndices counts the edges of a graph by endpoints. Edges are appended to Graph.Edges
+// directly so it catches up with the edges appended since it was last used.
+type edgeIndices struct {
+	counts map[edgeEndpoints]int
+	n      int
+	// last is the last edge counted, to detect edges removed since.
+	last *Edge
+}
+
+type edgeEndpoints struct {
+	src      *Object
+	srcArrow bool
+	dst      *Object
+	dstArrow bool
+}
+
+func (e *Edge) endpoints() edgeEndpoints {
+	return edgeEndpoints{
+		src:      e.Src,
+		srcArrow: e.SrcArrow,
+		dst:      e.Dst,
+		dstArrow: e.DstArrow,
 	}
 }
 
@@ -1412,8 +1479,8 @@ func appendTextDedup(texts []*d2target.MText, t *d2target.MText) []*d2target.MTe
 
 func (g *Graph) SetDimensions(mtexts []*d2target.MText, ruler *textmeasure.Ruler, fontFamily *d2fonts.FontFamily) error {
 	if ruler != nil && fontFamily != nil {
-		if ok := ruler.HasFontFamilyLoaded(fontFamily); !ok {
-			return fmt.Errorf("ruler does not have entire font family %s loaded, is a style missing?", *fontFamily)
+		if err := ruler.LoadFontFamily(fontFamily); err != nil {
+			return fmt.Errorf("ruler does not have entire font family %s loaded: %w", *fontFamily, err)
 		}
 	}
 
@@ -1681,10 +1748,12 @@ var SimpleReservedKeywords = map[string]struct{}{
 	"label":          {},
 	"desc":           {},
 	"shape":          {},
+	"shape-path":     {},
 	"icon":           {},
 	"constraint":     {},
 	"tooltip":        {},
 	"link":           {},
+	"animate":        {},
 	"near":           {},
 	"width":          {},
 	"height":         {},
@@ -9,11 +9,20 @@ import (
 	"oss.terrastruct.com/util-go/go2"
 )
 
+// SerializedGraphVersion is the version of the schema of SerializedGraph. It is
+// incremented whenever a change would make older readers misread graphs, so that they
+// fail with an error instead.
+const SerializedGraphVersion = 1
+
 type SerializedGraph struct {
+	// Version is the SerializedGraphVersion of the writer. Graphs written before versioning
+	// have none.
+	Version   int                `json:"version,omitempty"`
 	Root      SerializedObject   `json:"root"`
 	Edges     []SerializedEdge   `json:"edges"`
 	Objects   []SerializedObject `json:"objects"`
 	RootLevel int                `json:"rootLevel"`
+	Warnings  []Warning          `json:"warnings,omitempty"`
 }
 
 type SerializedObject map[string]interface{}
@@ -26,6 +35,9 @@ func DeserializeGraph(bytes []byte, g *Graph) error {
 	if err != nil {
 		return err
 	}
+	if sg.Version > SerializedGraphVersion {
+		return fmt.Errorf("graph schema version %d is newer than the supported version %d", sg.Version, SerializedGraphVersion)
+	}
 
 	var root Object
 	Convert(sg.Root, &root)
@@ -83,11 +95,30 @@ func DeserializeGraph(bytes []byte, g *Graph) error {
 	g.Objects = objects
 	g.Edges = edges
 
+	g.Warnings = nil
+	for _, w := range sg.Warnings {
+		if w.AbsID != "" {
+			if o, ok := idToObj[w.AbsID]; ok && o != g.Root {
+				w.Object = o
+			} else {
+				for _, e := range edges {
+					if e.AbsID() == w.AbsID {
+						w.Edge = e
+						break
+					}
+				}
+			}
+		}
+		g.Warnings = append(g.Warnings, w)
+	}
+
 	return nil
 }
 
 func SerializeGraph(g *Graph) ([]byte, error) {
-	sg := SerializedGraph{}
+	sg := SerializedGraph{
+		Version: SerializedGraphVersion,
+	}
 
 	root, err := toSerializedObject(g.Root)
 	if err != nil {
@@ -95,6 +126,15 @@ func SerializeGraph(g *Graph) ([]byte, error) {
 	}
 	sg.Root = root
 	sg.RootLevel = g.RootLevel
+	for _, w := range g.Warnings {
+		switch {
+		case w.Object != nil:
+			w.AbsID = w.Object.AbsID()
+		case w.Edge != nil:
+			w.AbsID = w.Edge.AbsID()
+		}
+		sg.Warnings = append(sg.Warnings, w)
+	}
 
 	var sobjects []SerializedObject
 	for _, o := range g.Objects {
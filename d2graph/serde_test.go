@@ -1,6 +1,7 @@
 package d2graph_test
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 
@@ -69,3 +70,52 @@ func TestCasingRegression(t *testing.T) {
 	_, ok = newG.Root.HasChild([]string{"UserCreatedTypeField"})
 	assert.True(t, ok)
 }
+
+func TestSerializationVersion(t *testing.T) {
+	t.Parallel()
+
+	g, _, err := d2compiler.Compile("", strings.NewReader("a -> b"), nil)
+	assert.Nil(t, err)
+
+	b, err := d2graph.SerializeGraph(g)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), fmt.Sprintf(`"version":%d`, d2graph.SerializedGraphVersion))
+
+	newer := strings.Replace(string(b), fmt.Sprintf(`"version":%d`, d2graph.SerializedGraphVersion), fmt.Sprintf(`"version":%d`, d2graph.SerializedGraphVersion+1), 1)
+	var newG d2graph.Graph
+	err = d2graph.DeserializeGraph([]byte(newer), &newG)
+	assert.EqualError(t, err, fmt.Sprintf("graph schema version %d is newer than the supported version %d", d2graph.SerializedGraphVersion+1, d2graph.SerializedGraphVersion))
+
+	// Graphs written before versioning are still read.
+	legacy := strings.Replace(string(b), fmt.Sprintf(`"version":%d,`, d2graph.SerializedGraphVersion), "", 1)
+	err = d2graph.DeserializeGraph([]byte(legacy), &newG)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(newG.Objects))
+}
+
+func TestSerializeWarnings(t *testing.T) {
+	t.Parallel()
+
+	g, _, err := d2compiler.Compile("", strings.NewReader("a.b -> a.c"), nil)
+	assert.Nil(t, err)
+
+	g.Warnf("graph")
+	g.WarnObjectf(g.Objects[0], "object")
+	g.WarnEdgef(g.Edges[0], "edge")
+
+	b, err := d2graph.SerializeGraph(g)
+	assert.Nil(t, err)
+
+	var newG d2graph.Graph
+	err = d2graph.DeserializeGraph(b, &newG)
+	assert.Nil(t, err)
+
+	assert.Equal(t, 3, len(newG.Warnings))
+	assert.Equal(t, "graph", newG.Warnings[0].Message)
+	assert.Nil(t, newG.Warnings[0].Object)
+	assert.Nil(t, newG.Warnings[0].Edge)
+	assert.Equal(t, "object", newG.Warnings[1].Message)
+	assert.Equal(t, "a", newG.Warnings[1].Object.AbsID())
+	assert.Equal(t, "edge", newG.Warnings[2].Message)
+	assert.Equal(t, "a.(b -> c)[0]", newG.Warnings[2].Edge.AbsID())
+}
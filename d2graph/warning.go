@@ -0,0 +1,39 @@
+package d2graph
+
+import "fmt"
+
+// Warning is a non fatal issue a layout engine reports about a graph, e.g. a container too
+// dense to be laid out well. Layout engines running as plugins report them like bundled
+// ones as warnings are serialized with the graph.
+type Warning struct {
+	Message string `json:"message"`
+	// AbsID is the absolute ID of the object or edge the warning is about, if any. It is
+	// only read and written when serializing, use Object and Edge otherwise.
+	AbsID string `json:"absID,omitempty"`
+
+	Object *Object `json:"-"`
+	Edge   *Edge   `json:"-"`
+}
+
+// Warnf adds a warning about the graph as a whole.
+func (g *Graph) Warnf(format string, args ...interface{}) {
+	g.Warnings = append(g.Warnings, Warning{
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// WarnObjectf adds a warning about obj.
+func (g *Graph) WarnObjectf(obj *Object, format string, args ...interface{}) {
+	g.Warnings = append(g.Warnings, Warning{
+		Message: fmt.Sprintf(format, args...),
+		Object:  obj,
+	})
+}
+
+// WarnEdgef adds a warning about e.
+func (g *Graph) WarnEdgef(e *Edge, format string, args ...interface{}) {
+	g.Warnings = append(g.Warnings, Warning{
+		Message: fmt.Sprintf(format, args...),
+		Edge:    e,
+	})
+}
@@ -0,0 +1,264 @@
+// Package d2highlight classifies the text of a D2 script into tokens for syntax
+// highlighting. The classification is based on the parsed AST rather than regular
+// expressions so that keys, keywords and values are told apart the way the compiler tells
+// them apart.
+package d2highlight
+
+import (
+	"sort"
+	"strings"
+
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2parser"
+)
+
+type Kind string
+
+const (
+	// Key is an element of a key or edge path, e.g. a and b in a.b.
+	Key Kind = "key"
+	// Keyword is a reserved keyword used as a key, e.g. shape or style.
+	Keyword Kind = "keyword"
+	String  Kind = "string"
+	Number  Kind = "number"
+	// Constant is a boolean or null.
+	Constant Kind = "constant"
+	// Connection is the arrow of an edge, e.g. ->.
+	Connection Kind = "connection"
+	// Substitution is a variable substitution, e.g. ${x}.
+	Substitution Kind = "substitution"
+	Import       Kind = "import"
+	Comment      Kind = "comment"
+)
+
+// Kinds lists every kind.
+var Kinds = []Kind{Key, Keyword, String, Number, Constant, Connection, Substitution, Import, Comment}
+
+// Token is a classified span of text. Start and End are byte offsets with End exclusive.
+type Token struct {
+	Kind  Kind `json:"kind"`
+	Start int  `json:"start"`
+	End   int  `json:"end"`
+}
+
+// Tokenize returns the tokens of text sorted by offset. Tokens do not overlap; a token
+// nested in another, such as a substitution within a string, splits the outer token.
+//
+// Text that fails to parse is classified as far as it could be parsed.
+func Tokenize(text string) []Token {
+	ast, _ := d2parser.Parse("", strings.NewReader(text), nil)
+	t := &tokenizer{text: text}
+	t.mapNodes(ast)
+	return flatten(t.tokens)
+}
+
+type tokenizer struct {
+	text   string
+	tokens []Token
+}
+
+func (t *tokenizer) add(kind Kind, r d2ast.Range) {
+	if r.End.Byte <= r.Start.Byte || r.End.Byte > len(t.text) {
+		return
+	}
+	t.tokens = append(t.tokens, Token{
+		Kind:  kind,
+		Start: r.Start.Byte,
+		End:   r.End.Byte,
+	})
+}
+
+func (t *tokenizer) mapNodes(m *d2ast.Map) {
+	if m == nil {
+		return
+	}
+	for _, n := range m.Nodes {
+		switch {
+		case n.Comment != nil:
+			t.add(Comment, n.Comment.Range)
+		case n.BlockComment != nil:
+			t.add(Comment, n.BlockComment.Range)
+		case n.Substitution != nil:
+			t.add(Substitution, n.Substitution.Range)
+		case n.Import != nil:
+			t.add(Import, n.Import.Range)
+		case n.MapKey != nil:
+			t.key(n.MapKey)
+		}
+	}
+}
+
+func (t *tokenizer) key(k *d2ast.Key) {
+	t.keyPath(k.Key)
+	for _, e := range k.Edges {
+		t.keyPath(e.Src)
+		t.arrow(e)
+		t.keyPath(e.Dst)
+	}
+	t.keyPath(k.EdgeKey)
+	if k.Primary.Unbox() != nil {
+		t.value(k.Primary.Unbox())
+	}
+	t.valueBox(k.Value)
+}
+
+func (t *tokenizer) keyPath(kp *d2ast.KeyPath) {
+	if kp == nil {
+		return
+	}
+	for _, sb := range kp.Path {
+		s := sb.Unbox()
+		kind := Key
+		if sb.UnquotedString != nil {
+			if _, ok := d2graph.ReservedKeywords[strings.ToLower(s.ScalarString())]; ok {
+				kind = Keyword
+			}
+		}
+		t.add(kind, s.GetRange())
+		t.substitutions(s)
+	}
+}
+
+// arrow adds the connection between the source and destination of e, which the AST does
+// not hold the range of.
+func (t *tokenizer) arrow(e *d2ast.Edge) {
+	if e.Src == nil || e.Dst == nil {
+		return
+	}
+	start, end := e.Src.Range.End.Byte, e.Dst.Range.Start.Byte
+	if start < 0 || end > len(t.text) || start >= end {
+		return
+	}
+	between := t.text[start:end]
+	trimmed := strings.TrimLeft(between, " \t\n\\")
+	start += len(between) - len(trimmed)
+	end -= len(trimmed) - len(strings.TrimRight(trimmed, " \t\n\\"))
+	if start < end {
+		t.tokens = append(t.tokens, Token{
+			Kind:  Connection,
+			Start: start,
+			End:   end,
+		})
+	}
+}
+
+func (t *tokenizer) valueBox(vb d2ast.ValueBox) {
+	switch {
+	case vb.Map != nil:
+		t.mapNodes(vb.Map)
+	case vb.Array != nil:
+		t.array(vb.Array)
+	case vb.Import != nil:
+		t.add(Import, vb.Import.Range)
+	default:
+		if v := vb.Unbox(); v != nil {
+			t.value(v)
+		}
+	}
+}
+
+func (t *tokenizer) array(a *d2ast.Array) {
+	for _, n := range a.Nodes {
+		switch {
+		case n.Comment != nil:
+			t.add(Comment, n.Comment.Range)
+		case n.BlockComment != nil:
+			t.add(Comment, n.BlockComment.Range)
+		case n.Substitution != nil:
+			t.add(Substitution, n.Substitution.Range)
+		case n.Import != nil:
+			t.add(Import, n.Import.Range)
+		case n.Array != nil:
+			t.array(n.Array)
+		case n.Map != nil:
+			t.mapNodes(n.Map)
+		default:
+			if v, ok := n.Unbox().(d2ast.Value); ok && v != nil {
+				t.value(v)
+			}
+		}
+	}
+}
+
+func (t *tokenizer) value(v d2ast.Value) {
+	switch v := v.(type) {
+	case *d2ast.Null, *d2ast.Boolean:
+		t.add(Constant, v.GetRange())
+	case *d2ast.Number:
+		t.add(Number, v.GetRange())
+	case d2ast.String:
+		r := v.GetRange()
+		// The range of an unquoted string that ends with a substitution stops short of it.
+		if us, ok := v.(*d2ast.UnquotedString); ok && len(us.Value) > 0 {
+			if sub := us.Value[len(us.Value)-1].Substitution; sub != nil && sub.Range.End.Byte > r.End.Byte {
+				r.End = sub.Range.End
+			}
+		}
+		t.add(String, r)
+		t.substitutions(v)
+	}
+}
+
+func (t *tokenizer) substitutions(s d2ast.Scalar) {
+	var boxes []d2ast.InterpolationBox
+	switch s := s.(type) {
+	case *d2ast.UnquotedString:
+		boxes = s.Value
+	case *d2ast.DoubleQuotedString:
+		boxes = s.Value
+	}
+	for _, box := range boxes {
+		if box.Substitution != nil {
+			t.add(Substitution, box.Substitution.Range)
+		}
+	}
+}
+
+// flatten sorts tokens and splits those that contain others so that none overlap.
+func flatten(tokens []Token) []Token {
+	sort.SliceStable(tokens, func(i, j int) bool {
+		if tokens[i].Start != tokens[j].Start {
+			return tokens[i].Start < tokens[j].Start
+		}
+		// Outer tokens first.
+		return tokens[i].End > tokens[j].End
+	})
+
+	var out []Token
+	// stack holds the tokens enclosing the current offset, innermost last.
+	var stack []Token
+	pos := 0
+	emit := func(end int) {
+		if len(stack) == 0 {
+			pos = end
+			return
+		}
+		top := stack[len(stack)-1]
+		if pos < end {
+			out = append(out, Token{
+				Kind:  top.Kind,
+				Start: pos,
+				End:   end,
+			})
+		}
+		pos = end
+	}
+	for _, tok := range tokens {
+		for len(stack) > 0 && stack[len(stack)-1].End <= tok.Start {
+			emit(stack[len(stack)-1].End)
+			stack = stack[:len(stack)-1]
+		}
+		if tok.Start < pos || (len(stack) > 0 && tok.End > stack[len(stack)-1].End) {
+			// Overlaps a previous token without being nested within it.
+			continue
+		}
+		emit(tok.Start)
+		stack = append(stack, tok)
+	}
+	for len(stack) > 0 {
+		emit(stack[len(stack)-1].End)
+		stack = stack[:len(stack)-1]
+	}
+	return out
+}
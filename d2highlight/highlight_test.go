@@ -0,0 +1,137 @@
+package d2highlight_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/d2/d2highlight"
+)
+
+type span struct {
+	kind d2highlight.Kind
+	text string
+}
+
+func TestTokenize(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		text string
+		exp  []span
+	}{
+		{
+			name: "keys",
+			text: `a.b.shape: circle`,
+			exp: []span{
+				{d2highlight.Key, "a"},
+				{d2highlight.Key, "b"},
+				{d2highlight.Keyword, "shape"},
+				{d2highlight.String, "circle"},
+			},
+		},
+		{
+			name: "edges",
+			text: `a -> b <-> c: "hi" {style.stroke-width: 2; style.animated: true}`,
+			exp: []span{
+				{d2highlight.Key, "a"},
+				{d2highlight.Connection, "->"},
+				{d2highlight.Key, "b"},
+				{d2highlight.Connection, "<->"},
+				{d2highlight.Key, "c"},
+				{d2highlight.String, `"hi"`},
+				{d2highlight.Keyword, "style"},
+				{d2highlight.Keyword, "stroke-width"},
+				{d2highlight.Number, "2"},
+				{d2highlight.Keyword, "style"},
+				{d2highlight.Keyword, "animated"},
+				{d2highlight.Constant, "true"},
+			},
+		},
+		{
+			name: "edge_key",
+			text: `(a -- b)[0].label: x`,
+			exp: []span{
+				{d2highlight.Key, "a"},
+				{d2highlight.Connection, "--"},
+				{d2highlight.Key, "b"},
+				{d2highlight.Keyword, "label"},
+				{d2highlight.String, "x"},
+			},
+		},
+		{
+			name: "substitutions",
+			text: "vars: {x: 1}\n# comment\na: \"${x} and ${x}\"\n...@b",
+			exp: []span{
+				{d2highlight.Keyword, "vars"},
+				{d2highlight.Key, "x"},
+				{d2highlight.Number, "1"},
+				{d2highlight.Comment, "# comment"},
+				{d2highlight.Key, "a"},
+				{d2highlight.String, `"`},
+				{d2highlight.Substitution, "${x}"},
+				{d2highlight.String, " and "},
+				{d2highlight.Substitution, "${x}"},
+				{d2highlight.String, `"`},
+				{d2highlight.Import, "...@b"},
+			},
+		},
+		{
+			name: "unquoted_substitution",
+			text: `a: x ${y}`,
+			exp: []span{
+				{d2highlight.Key, "a"},
+				{d2highlight.String, "x "},
+				{d2highlight.Substitution, "${y}"},
+			},
+		},
+		{
+			name: "quoted_keyword",
+			text: `"shape": [a; null]`,
+			exp: []span{
+				{d2highlight.Key, `"shape"`},
+				{d2highlight.String, "a"},
+				{d2highlight.Constant, "null"},
+			},
+		},
+		{
+			name: "parse_error",
+			text: "a: {\n  b -> c",
+			exp: []span{
+				{d2highlight.Key, "a"},
+				{d2highlight.Key, "b"},
+				{d2highlight.Connection, "->"},
+				{d2highlight.Key, "c"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var spans []span
+			for _, tok := range d2highlight.Tokenize(tc.text) {
+				spans = append(spans, span{tok.Kind, tc.text[tok.Start:tok.End]})
+			}
+			assert.Equal(t, tc.exp, spans)
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	err := d2highlight.HTML(&b, "a -> b: <x>")
+	assert.NoError(t, err)
+	assert.Equal(t, `<pre class="d2-highlight"><code><span class="d2-key">a</span> <span class="d2-connection">-&gt;</span> <span class="d2-key">b</span>: <span class="d2-string">&lt;x&gt;</span></code></pre>`+"\n", b.String())
+
+	b.Reset()
+	err = d2highlight.ANSI(&b, "a: |md\n  # x\n|")
+	assert.NoError(t, err)
+	assert.Equal(t, "\x1b[34ma\x1b[0m: \x1b[32m|md\x1b[0m\n\x1b[32m  # x\x1b[0m\n\x1b[32m|\x1b[0m", b.String())
+}
@@ -0,0 +1,87 @@
+package d2highlight
+
+import (
+	"html"
+	"io"
+	"strings"
+
+	"oss.terrastruct.com/util-go/xterm"
+)
+
+// HTML writes text highlighted as a pre element. Every token is wrapped in a span of class
+// d2-<kind>, e.g. d2-keyword, to be styled by the page.
+func HTML(w io.Writer, text string) error {
+	var b strings.Builder
+	b.WriteString(`<pre class="d2-highlight"><code>`)
+	render(text, Tokenize(text), func(kind Kind, s string) {
+		if kind == "" {
+			b.WriteString(html.EscapeString(s))
+			return
+		}
+		b.WriteString(`<span class="d2-`)
+		b.WriteString(string(kind))
+		b.WriteString(`">`)
+		b.WriteString(html.EscapeString(s))
+		b.WriteString(`</span>`)
+	})
+	b.WriteString("</code></pre>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ansiColors are the terminal colors of each kind.
+var ansiColors = map[Kind]string{
+	Key:          xterm.Blue,
+	Keyword:      xterm.Magenta,
+	String:       xterm.Green,
+	Number:       xterm.Yellow,
+	Constant:     xterm.Yellow,
+	Connection:   xterm.Cyan,
+	Substitution: xterm.BrightRed,
+	Import:       xterm.BrightMagenta,
+	Comment:      "\x1b[90m",
+}
+
+const ansiReset = "\x1b[0m"
+
+// ANSI writes text highlighted with terminal color escape sequences.
+func ANSI(w io.Writer, text string) error {
+	var b strings.Builder
+	render(text, Tokenize(text), func(kind Kind, s string) {
+		if kind == "" {
+			b.WriteString(s)
+			return
+		}
+		// Color each line separately so that a multiline token does not color prefixes
+		// such as those of a pager.
+		lines := strings.Split(s, "\n")
+		for i, line := range lines {
+			if i > 0 {
+				b.WriteByte('\n')
+			}
+			if line != "" {
+				b.WriteString(ansiColors[kind])
+				b.WriteString(line)
+				b.WriteString(ansiReset)
+			}
+		}
+	})
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// render calls fn with every span of text in order, with an empty kind for text outside
+// of tokens.
+func render(text string, tokens []Token, fn func(Kind, string)) {
+	pos := 0
+	for _, tok := range tokens {
+		if pos < tok.Start {
+			fn("", text[pos:tok.Start])
+		}
+		fn(tok.Kind, text[tok.Start:tok.End])
+		pos = tok.End
+	}
+	if pos < len(text) {
+		fn("", text[pos:])
+	}
+}
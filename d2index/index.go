@@ -0,0 +1,376 @@
+// Package d2index indexes every D2 script of a workspace to report issues that only appear
+// when looking at the workspace as a whole: imports of files that don't exist, links to
+// boards that don't exist and classes that shared files declare but no file uses.
+//
+// Paths are those of the fs.FS the index is created with, i.e. slash separated and relative
+// to its root.
+package d2index
+
+import (
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"oss.terrastruct.com/util-go/xdefer"
+
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2ir"
+	"oss.terrastruct.com/d2/d2parser"
+)
+
+// Index is the index of the D2 scripts of a workspace.
+type Index struct {
+	Files map[string]*File
+
+	fs   fs.FS
+	opts Options
+}
+
+type Options struct {
+	// UTF16Pos makes the columns of the ranges of issues count UTF-16 code units, as the
+	// Language Server Protocol expects, instead of bytes.
+	UTF16Pos bool
+}
+
+// File is an indexed D2 script.
+type File struct {
+	Path string
+	Text string
+	// AST is nil only if the text could not be parsed at all.
+	AST *d2ast.Map
+	// ParseErr is the error of parsing Text, if any.
+	ParseErr error
+	// Imports are the paths of the files the file imports, resolved like the compiler does.
+	Imports []string
+	// ImportedBy are the paths of the indexed files that import the file.
+	ImportedBy []string
+
+	imports []*d2ast.Import
+}
+
+// Issue is a project-level issue.
+type Issue struct {
+	d2ast.Error
+	// Warning is set for issues that don't prevent the files from compiling.
+	Warning bool
+}
+
+// New indexes every .d2 file of fsys. Hidden directories are skipped. Files are also read
+// from fsys when compiling so it should serve the latest text of files being edited.
+func New(fsys fs.FS, opts *Options) (_ *Index, err error) {
+	defer xdefer.Errorf(&err, "failed to index workspace")
+
+	if opts == nil {
+		opts = &Options{}
+	}
+	ix := &Index{
+		Files: make(map[string]*File),
+		fs:    fsys,
+		opts:  *opts,
+	}
+	err = fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != "." && strings.HasPrefix(d.Name(), ".") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if path.Ext(p) != ".d2" {
+			return nil
+		}
+		b, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		ix.parse(p, string(b))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ix.link()
+	return ix, nil
+}
+
+// Update sets the text of the file at p, adding it to the index if it is not indexed yet.
+func (ix *Index) Update(p, text string) {
+	if f, ok := ix.Files[p]; ok && f.Text == text {
+		return
+	}
+	ix.parse(p, text)
+	ix.link()
+}
+
+// Remove removes the file at p from the index.
+func (ix *Index) Remove(p string) {
+	if _, ok := ix.Files[p]; !ok {
+		return
+	}
+	delete(ix.Files, p)
+	ix.link()
+}
+
+func (ix *Index) parse(p, text string) {
+	f := &File{
+		Path: p,
+		Text: text,
+	}
+	f.AST, f.ParseErr = d2parser.Parse(p, strings.NewReader(text), &d2parser.ParseOptions{
+		UTF16Pos: ix.opts.UTF16Pos,
+	})
+	walk(f.AST, func(n d2ast.Node) {
+		if imp, ok := n.(*d2ast.Import); ok {
+			f.imports = append(f.imports, imp)
+		}
+	})
+	for _, imp := range f.imports {
+		if target, ok := resolve(p, imp); ok {
+			f.Imports = appendUnique(f.Imports, target)
+		}
+	}
+	ix.Files[p] = f
+}
+
+// link recomputes ImportedBy of every file.
+func (ix *Index) link() {
+	for _, f := range ix.Files {
+		f.ImportedBy = nil
+	}
+	for _, p := range ix.paths() {
+		for _, target := range ix.Files[p].Imports {
+			if t, ok := ix.Files[target]; ok {
+				t.ImportedBy = appendUnique(t.ImportedBy, p)
+			}
+		}
+	}
+}
+
+func (ix *Index) paths() []string {
+	paths := make([]string, 0, len(ix.Files))
+	for p := range ix.Files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Entries returns the paths of the files that no other file imports, i.e. those that are
+// compiled on their own.
+func (ix *Index) Entries() []string {
+	var entries []string
+	for _, p := range ix.paths() {
+		if len(ix.Files[p].ImportedBy) == 0 {
+			entries = append(entries, p)
+		}
+	}
+	return entries
+}
+
+// Issues returns the issues of the workspace sorted by path and position:
+//   - imports of files that don't exist, in any file
+//   - errors compiling entry files, which include links to boards that don't exist
+//   - classes declared at the root of imported files that no file uses
+func (ix *Index) Issues() []Issue {
+	var issues []Issue
+	seen := make(map[d2ast.Range]map[string]struct{})
+	add := func(is Issue) {
+		msgs, ok := seen[is.Range]
+		if !ok {
+			msgs = make(map[string]struct{})
+			seen[is.Range] = msgs
+		}
+		// The compiler of each entry reports errors of the files it imports again.
+		if _, ok := msgs[is.Message]; ok {
+			return
+		}
+		msgs[is.Message] = struct{}{}
+		issues = append(issues, is)
+	}
+
+	for _, p := range ix.paths() {
+		f := ix.Files[p]
+		for _, imp := range f.imports {
+			target, ok := resolve(p, imp)
+			if !ok || ix.exists(target) {
+				continue
+			}
+			add(Issue{Error: d2parser.Errorf(imp, "imported file %q does not exist", target).(d2ast.Error)})
+		}
+	}
+
+	for _, p := range ix.Entries() {
+		f := ix.Files[p]
+		_, _, err := d2compiler.Compile(p, strings.NewReader(f.Text), &d2compiler.CompileOptions{
+			UTF16Pos: ix.opts.UTF16Pos,
+			FS:       ix.fs,
+		})
+		pe, ok := err.(*d2parser.ParseError)
+		if !ok {
+			continue
+		}
+		for _, e := range pe.Errors {
+			// Reported above with the path of the missing file.
+			if strings.Contains(e.Message, "failed to import") {
+				continue
+			}
+			add(Issue{Error: e})
+		}
+	}
+
+	used := make(map[string]struct{})
+	for _, f := range ix.Files {
+		classUsages(f.AST, used)
+	}
+	for _, p := range ix.paths() {
+		f := ix.Files[p]
+		if len(f.ImportedBy) == 0 {
+			continue
+		}
+		for _, sb := range classDecls(f.AST) {
+			name := sb.Unbox().ScalarString()
+			if _, ok := used[name]; ok {
+				continue
+			}
+			add(Issue{
+				Error:   d2parser.Errorf(sb.Unbox(), "class %q is shared but never used", name).(d2ast.Error),
+				Warning: true,
+			})
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		ri, rj := issues[i].Range, issues[j].Range
+		if ri.Path != rj.Path {
+			return ri.Path < rj.Path
+		}
+		return ri.Start.Byte < rj.Start.Byte
+	})
+	return issues
+}
+
+func (ix *Index) exists(p string) bool {
+	if _, ok := ix.Files[p]; ok {
+		return true
+	}
+	_, err := fs.Stat(ix.fs, p)
+	return err == nil
+}
+
+// resolve returns the path of the file imp imports from the file at p.
+func resolve(p string, imp *d2ast.Import) (string, bool) {
+	target := imp.PathWithPre()
+	if target == "" || path.IsAbs(target) {
+		return "", false
+	}
+	// Resolved by plugins.
+	if _, ok := d2ir.ImportScheme(target); ok {
+		return "", false
+	}
+	if path.Ext(target) != ".d2" {
+		target += ".d2"
+	}
+	return path.Join(path.Dir(p), target), true
+}
+
+// classDecls returns the names of the classes declared at the root of m.
+func classDecls(m *d2ast.Map) []*d2ast.StringBox {
+	if m == nil {
+		return nil
+	}
+	var decls []*d2ast.StringBox
+	for _, n := range m.Nodes {
+		k := n.MapKey
+		if k == nil || k.Key == nil || len(k.Edges) > 0 || k.Key.Path[0].Unbox().ScalarString() != "classes" {
+			continue
+		}
+		if len(k.Key.Path) > 1 {
+			decls = append(decls, k.Key.Path[1])
+			continue
+		}
+		if k.Value.Map == nil {
+			continue
+		}
+		for _, n := range k.Value.Map.Nodes {
+			if n.MapKey != nil && n.MapKey.Key != nil {
+				decls = append(decls, n.MapKey.Key.Path[0])
+			}
+		}
+	}
+	return decls
+}
+
+// classUsages adds the names of the classes m applies to used.
+func classUsages(m *d2ast.Map, used map[string]struct{}) {
+	walk(m, func(n d2ast.Node) {
+		k, ok := n.(*d2ast.Key)
+		if !ok {
+			return
+		}
+		kp := k.EdgeKey
+		if kp == nil {
+			kp = k.Key
+		}
+		if kp == nil || kp.Last().Unbox().ScalarString() != "class" {
+			return
+		}
+		if k.Value.Array != nil {
+			for _, an := range k.Value.Array.Nodes {
+				if s, ok := an.Unbox().(d2ast.Scalar); ok {
+					used[s.ScalarString()] = struct{}{}
+				}
+			}
+			return
+		}
+		if s := k.Value.ScalarBox().Unbox(); s != nil {
+			used[s.ScalarString()] = struct{}{}
+		}
+	})
+}
+
+// walk calls fn with every key and import of m, including those of nested maps and arrays.
+func walk(m *d2ast.Map, fn func(d2ast.Node)) {
+	if m == nil {
+		return
+	}
+	for _, n := range m.Nodes {
+		switch {
+		case n.Import != nil:
+			fn(n.Import)
+		case n.MapKey != nil:
+			fn(n.MapKey)
+			walkValue(n.MapKey.Value, fn)
+		}
+	}
+}
+
+func walkValue(v d2ast.ValueBox, fn func(d2ast.Node)) {
+	switch {
+	case v.Import != nil:
+		fn(v.Import)
+	case v.Map != nil:
+		walk(v.Map, fn)
+	case v.Array != nil:
+		for _, an := range v.Array.Nodes {
+			switch {
+			case an.Import != nil:
+				fn(an.Import)
+			case an.Map != nil:
+				walk(an.Map, fn)
+			}
+		}
+	}
+}
+
+func appendUnique(s []string, v string) []string {
+	for _, v2 := range s {
+		if v2 == v {
+			return s
+		}
+	}
+	return append(s, v)
+}
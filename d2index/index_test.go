@@ -0,0 +1,96 @@
+package d2index_test
+
+import (
+	"sort"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"oss.terrastruct.com/d2/d2index"
+)
+
+func workspace() fstest.MapFS {
+	return fstest.MapFS{
+		"index.d2": {Data: []byte(`...@shared/classes
+a.class: used
+c.link: layers.nope
+layers: {
+  x: {d: @models/d}
+}
+`)},
+		"shared/classes.d2": {Data: []byte(`classes: {
+  used: {style.fill: red}
+  unused: {style.fill: blue}
+}
+classes.also-unused.shape: circle
+`)},
+		"models/d.d2":        {Data: []byte(`shape: cylinder`)},
+		"other.d2":           {Data: []byte("x -> y: {class: [nothing]}\nb: @shared/missing\n")},
+		".git/ignored.d2":    {Data: []byte(`x: @nowhere`)},
+		"notes/readme.md":    {Data: []byte(`# notes`)},
+		"notes/sub/empty.d2": {Data: []byte(``)},
+	}
+}
+
+type issue struct {
+	msg     string
+	warning bool
+}
+
+func issues(ix *d2index.Index) []issue {
+	var out []issue
+	for _, is := range ix.Issues() {
+		out = append(out, issue{is.Message, is.Warning})
+	}
+	return out
+}
+
+func TestIndex(t *testing.T) {
+	t.Parallel()
+
+	ix, err := d2index.New(workspace(), nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"index.d2", "models/d.d2", "notes/sub/empty.d2", "other.d2", "shared/classes.d2"}, sortedKeys(ix.Files))
+	assert.Equal(t, []string{"shared/classes.d2", "models/d.d2"}, ix.Files["index.d2"].Imports)
+	assert.Equal(t, []string{"index.d2"}, ix.Files["models/d.d2"].ImportedBy)
+	assert.Equal(t, []string{"index.d2", "notes/sub/empty.d2", "other.d2"}, ix.Entries())
+
+	assert.Equal(t, []issue{
+		{`index.d2:3:1: linked board not found`, false},
+		{`other.d2:2:4: imported file "shared/missing.d2" does not exist`, false},
+		{`shared/classes.d2:3:3: class "unused" is shared but never used`, true},
+		{`shared/classes.d2:5:9: class "also-unused" is shared but never used`, true},
+	}, issues(ix))
+}
+
+func TestUpdate(t *testing.T) {
+	t.Parallel()
+
+	ix, err := d2index.New(workspace(), nil)
+	require.NoError(t, err)
+
+	ix.Update("other.d2", `x.class: [unused; also-unused]`)
+	ix.Update("index.d2", `...@shared/classes
+a.class: used
+`)
+	assert.Equal(t, []string(nil), ix.Files["models/d.d2"].ImportedBy)
+	assert.Equal(t, []issue(nil), issues(ix))
+
+	ix.Remove("other.d2")
+	assert.Equal(t, []issue{
+		{`shared/classes.d2:3:3: class "unused" is shared but never used`, true},
+		{`shared/classes.d2:5:9: class "also-unused" is shared but never used`, true},
+	}, issues(ix))
+}
+
+func sortedKeys(m map[string]*d2index.File) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
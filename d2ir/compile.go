@@ -12,6 +12,7 @@ import (
 	"oss.terrastruct.com/d2/d2parser"
 	"oss.terrastruct.com/d2/d2themes"
 	"oss.terrastruct.com/d2/d2themes/d2themescatalog"
+	"oss.terrastruct.com/d2/lib/slab"
 )
 
 type globContext struct {
@@ -41,6 +42,15 @@ type compiler struct {
 	// Used to check whether ampersands are allowed in the current map.
 	mapRefContextStack   []*RefContext
 	lazyGlobBeingApplied bool
+	// Formatted path elements by path element, see globKey.
+	globKeys map[string]string
+
+	// The IR nodes most allocated by large diagrams are allocated from slabs rather than one
+	// by one.
+	fieldSlab    slab.Slab[Field]
+	fieldRefSlab slab.Slab[FieldReference]
+	edgeSlab     slab.Slab[Edge]
+	edgeRefSlab  slab.Slab[EdgeReference]
 }
 
 type CompileOptions struct {
@@ -53,6 +63,30 @@ func (c *compiler) errorf(n d2ast.Node, f string, v ...interface{}) {
 	c.err.Errors = append(c.err.Errors, d2parser.Errorf(n, f, v...).(d2ast.Error))
 }
 
+// fieldReference returns a reference to a field by s in kp in refctx.
+func (c *compiler) fieldReference(s d2ast.String, kp *d2ast.KeyPath, refctx *RefContext) *FieldReference {
+	fr := c.fieldRefSlab.New()
+	*fr = FieldReference{
+		String:         s,
+		KeyPath:        kp,
+		Context_:       refctx,
+		DueToGlob_:     len(c.globRefContextStack) > 0,
+		DueToLazyGlob_: c.lazyGlobBeingApplied,
+	}
+	return fr
+}
+
+// edgeReference returns a reference to an edge in refctx.
+func (c *compiler) edgeReference(refctx *RefContext) *EdgeReference {
+	er := c.edgeRefSlab.New()
+	*er = EdgeReference{
+		Context_:       refctx,
+		DueToGlob_:     len(c.globRefContextStack) > 0,
+		DueToLazyGlob_: c.lazyGlobBeingApplied,
+	}
+	return er
+}
+
 func Compile(ast *d2ast.Map, opts *CompileOptions) (*Map, []string, error) {
 	if opts == nil {
 		opts = &CompileOptions{}
@@ -63,6 +97,7 @@ func Compile(ast *d2ast.Map, opts *CompileOptions) (*Map, []string, error) {
 
 		seenImports: make(map[string]struct{}),
 		utf16Pos:    opts.UTF16Pos,
+		globKeys:    make(map[string]string),
 	}
 	m := &Map{}
 	m.initRoot()
@@ -176,7 +211,7 @@ func (c *compiler) validateConfigs(configs *Field) {
 	for _, f := range configs.Map().Fields {
 		var val string
 		if f.Primary() == nil {
-			if f.Name != "theme-overrides" && f.Name != "dark-theme-overrides" {
+			if f.Name != "theme-overrides" && f.Name != "dark-theme-overrides" && f.Name != "fmt" {
 				c.errorf(f.LastRef().AST(), `"%s" needs a value`, f.Name)
 				continue
 			}
@@ -185,7 +220,7 @@ func (c *compiler) validateConfigs(configs *Field) {
 		}
 
 		switch f.Name {
-		case "sketch", "center":
+		case "sketch", "center", "latex-display", "latex-numbering", "sequence-numbering":
 			_, err := strconv.ParseBool(val)
 			if err != nil {
 				c.errorf(f.LastRef().AST(), `expected a boolean for "%s", got "%s"`, f.Name, val)
@@ -212,7 +247,22 @@ func (c *compiler) validateConfigs(configs *Field) {
 				c.errorf(f.LastRef().AST(), `expected an integer for "%s", got "%s"`, f.Name, val)
 				continue
 			}
-		case "layout-engine":
+		case "layout-engine", "pdf-header", "pdf-footer":
+		case "fmt":
+			if f.Map() == nil {
+				c.errorf(f.LastRef().AST(), `"%s" needs a map`, f.Name)
+				continue
+			}
+			var opts d2format.Options
+			for _, ff := range f.Map().Fields {
+				var val string
+				if ff.Primary() != nil {
+					val = ff.Primary().Value.ScalarString()
+				}
+				if err := opts.Set(ff.Name, val); err != nil {
+					c.errorf(ff.LastRef().AST(), "%s", err)
+				}
+			}
 		default:
 			c.errorf(f.LastRef().AST(), `"%s" is not a valid config`, f.Name)
 		}
@@ -270,6 +320,7 @@ func (c *compiler) resolveSubstitutions(varsStack []*Map, node Node) (removedFie
 						for i, f2 := range m.Fields {
 							if n == f2 {
 								m.Fields = append(m.Fields[:i], m.Fields[i+1:]...)
+								m.invalidateIndex()
 								removedField = true
 								break
 							}
@@ -414,12 +465,7 @@ func (c *compiler) ampersandFilterMap(dst *Map, ast, scopeAST *d2ast.Map) bool {
 				if gctx == nil {
 					return false
 				}
-				var ks string
-				if gctx.refctx.Key.HasTripleGlob() {
-					ks = d2format.Format(d2ast.MakeKeyPath(IDA(dst)))
-				} else {
-					ks = d2format.Format(d2ast.MakeKeyPath(BoardIDA(dst)))
-				}
+				ks := c.globKey(dst, gctx.refctx.Key.HasTripleGlob())
 				delete(gctx.appliedFields, ks)
 				delete(gctx.appliedEdges, ks)
 				return false
@@ -522,6 +568,32 @@ func (c *compiler) compileMap(dst *Map, ast, scopeAST *d2ast.Map) {
 	}
 }
 
+// globKey returns the key of n in the fields and edges a glob was applied to, which is its
+// formatted absolute path for triple globs and its formatted path in its board otherwise.
+// Formatted path elements are cached as keys are computed for every field and edge every
+// time globs are applied.
+func (c *compiler) globKey(n Node, triple bool) string {
+	var ida []string
+	if triple {
+		ida = IDA(n)
+	} else {
+		ida = BoardIDA(n)
+	}
+	var sb strings.Builder
+	for i, s := range ida {
+		if i > 0 {
+			sb.WriteByte('.')
+		}
+		k, ok := c.globKeys[s]
+		if !ok {
+			k = d2format.Format(d2ast.MakeKeyPath([]string{s}))
+			c.globKeys[s] = k
+		}
+		sb.WriteString(k)
+	}
+	return sb.String()
+}
+
 func (c *compiler) globContexts() []*globContext {
 	return c.globContextStack[len(c.globContextStack)-1]
 }
@@ -568,14 +640,20 @@ func (c *compiler) compileKey(refctx *RefContext) {
 		}()
 		c.ensureGlobContext(refctx)
 	}
-	oldFields := refctx.ScopeMap.FieldCountRecursive()
-	oldEdges := refctx.ScopeMap.EdgeCountRecursive()
+	// Counting is linear in the size of the scope so it's skipped without globs to reapply,
+	// otherwise compiling large graphs would be quadratic.
+	globs := len(c.globContexts()) > 0
+	var oldFields, oldEdges int
+	if globs {
+		oldFields = refctx.ScopeMap.FieldCountRecursive()
+		oldEdges = refctx.ScopeMap.EdgeCountRecursive()
+	}
 	if len(refctx.Key.Edges) == 0 {
 		c.compileField(refctx.ScopeMap, refctx.Key.Key, refctx)
 	} else {
 		c.compileEdges(refctx)
 	}
-	if oldFields != refctx.ScopeMap.FieldCountRecursive() || oldEdges != refctx.ScopeMap.EdgeCountRecursive() {
+	if globs && (oldFields != refctx.ScopeMap.FieldCountRecursive() || oldEdges != refctx.ScopeMap.EdgeCountRecursive()) {
 		for _, gctx2 := range c.globContexts() {
 			// println(d2format.Format(gctx2.refctx.Key), d2format.Format(refctx.Key))
 			old := c.lazyGlobBeingApplied
@@ -1013,11 +1091,7 @@ func (c *compiler) _compileEdges(refctx *RefContext) {
 					refctx.ScopeMap.DeleteEdge(e.ID)
 					continue
 				}
-				e.References = append(e.References, &EdgeReference{
-					Context_:       refctx,
-					DueToGlob_:     len(c.globRefContextStack) > 0,
-					DueToLazyGlob_: c.lazyGlobBeingApplied,
-				})
+				e.References = append(e.References, c.edgeReference(refctx))
 				refctx.ScopeMap.appendFieldReferences(0, refctx.Edge.Src, refctx, c)
 				refctx.ScopeMap.appendFieldReferences(0, refctx.Edge.Dst, refctx, c)
 			}
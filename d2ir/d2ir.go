@@ -172,6 +172,9 @@ type Map struct {
 	Edges  []*Edge  `json:"edges"`
 
 	globs []*globContext
+
+	fieldIndex *fieldIndex
+	edgeIndex  *edgeIndex
 }
 
 func (m *Map) initRoot() {
@@ -190,6 +193,7 @@ func (m *Map) Copy(newParent Node) Node {
 	m = &tmp
 
 	m.parent = newParent
+	m.invalidateIndex()
 	pfields := m.Fields
 	m.Fields = make([]*Field, 0, len(pfields))
 	for _, f := range pfields {
@@ -684,16 +688,15 @@ func (m *Map) getField(ida []string) *Field {
 		return nil
 	}
 
-	for _, f := range m.Fields {
-		if !strings.EqualFold(f.Name, s) {
-			continue
-		}
-		if len(rest) == 0 {
-			return f
-		}
-		if f.Map() != nil {
-			return f.Map().getField(rest)
-		}
+	f := m.lookupField(s)
+	if f == nil {
+		return nil
+	}
+	if len(rest) == 0 {
+		return f
+	}
+	if f.Map() != nil {
+		return f.Map().getField(rest)
 	}
 	return nil
 }
@@ -733,12 +736,7 @@ func (m *Map) EnsureField(kp *d2ast.KeyPath, refctx *RefContext, create bool, c
 func (m *Map) ensureField(i int, kp *d2ast.KeyPath, refctx *RefContext, create bool, gctx *globContext, c *compiler, fa *[]*Field) error {
 	filter := func(f *Field, passthrough bool) bool {
 		if gctx != nil {
-			var ks string
-			if refctx.Key.HasTripleGlob() {
-				ks = d2format.Format(d2ast.MakeKeyPath(IDA(f)))
-			} else {
-				ks = d2format.Format(d2ast.MakeKeyPath(BoardIDA(f)))
-			}
+			ks := c.globKey(f, refctx.Key.HasTripleGlob())
 			if !kp.HasGlob() {
 				if !passthrough {
 					gctx.appliedFields[ks] = struct{}{}
@@ -791,7 +789,7 @@ func (m *Map) ensureField(i int, kp *d2ast.KeyPath, refctx *RefContext, create b
 			return nil
 		}
 		for _, f := range m.Fields {
-			if matchPattern(f.Name, us.Pattern) {
+			if MatchPattern(f.Name, us.Pattern) {
 				if i == len(kp.Path)-1 {
 					faAppend(f)
 				} else {
@@ -834,20 +832,10 @@ func (m *Map) ensureField(i int, kp *d2ast.KeyPath, refctx *RefContext, create b
 		return d2parser.Errorf(kp.Path[i].Unbox(), "%s is only allowed at a board root", head)
 	}
 
-	for _, f := range m.Fields {
-		if !strings.EqualFold(f.Name, head) {
-			continue
-		}
-
+	if f := m.lookupField(head); f != nil {
 		// Don't add references for fake common KeyPath from trimCommon in CreateEdge.
 		if refctx != nil {
-			f.References = append(f.References, &FieldReference{
-				String:         kp.Path[i].Unbox(),
-				KeyPath:        kp,
-				Context_:       refctx,
-				DueToGlob_:     len(c.globRefContextStack) > 0,
-				DueToLazyGlob_: c.lazyGlobBeingApplied,
-			})
+			f.References = append(f.References, c.fieldReference(kp.Path[i].Unbox(), kp, refctx))
 		}
 
 		if i+1 == len(kp.Path) {
@@ -877,34 +865,22 @@ func (m *Map) ensureField(i int, kp *d2ast.KeyPath, refctx *RefContext, create b
 			return nil
 		}
 	}
-	f := &Field{
-		parent: m,
-		Name:   head,
-	}
+	f := c.fieldSlab.New()
+	f.parent = m
+	f.Name = head
 	defer func() {
 		if i < kp.FirstGlob() {
 			return
 		}
 		for _, grefctx := range c.globRefContextStack {
-			var ks string
-			if grefctx.Key.HasTripleGlob() {
-				ks = d2format.Format(d2ast.MakeKeyPath(IDA(f)))
-			} else {
-				ks = d2format.Format(d2ast.MakeKeyPath(BoardIDA(f)))
-			}
+			ks := c.globKey(f, grefctx.Key.HasTripleGlob())
 			gctx2 := c.getGlobContext(grefctx)
 			gctx2.appliedFields[ks] = struct{}{}
 		}
 	}()
 	// Don't add references for fake common KeyPath from trimCommon in CreateEdge.
 	if refctx != nil {
-		f.References = append(f.References, &FieldReference{
-			String:         kp.Path[i].Unbox(),
-			KeyPath:        kp,
-			Context_:       refctx,
-			DueToGlob_:     len(c.globRefContextStack) > 0,
-			DueToLazyGlob_: c.lazyGlobBeingApplied,
-		})
+		f.References = append(f.References, c.fieldReference(kp.Path[i].Unbox(), kp, refctx))
 	}
 	if !filter(f, true) {
 		return nil
@@ -930,6 +906,7 @@ func (m *Map) DeleteEdge(eid *EdgeID) *Edge {
 	for i, e := range m.Edges {
 		if e.ID.Match(eid) {
 			m.Edges = append(m.Edges[:i], m.Edges[i+1:]...)
+			m.invalidateIndex()
 			return e
 		}
 	}
@@ -967,6 +944,7 @@ func (m *Map) DeleteField(ida ...string) *Field {
 				}
 			}
 			m.Fields = append(m.Fields[:i], m.Fields[i+1:]...)
+			m.invalidateIndex()
 
 			// If a field was deleted from a keyword-holder keyword and that holder is empty,
 			// then that holder becomes meaningless and should be deleted too
@@ -977,6 +955,7 @@ func (m *Map) DeleteField(ida ...string) *Field {
 					for i, f := range keywordHolderParentMap.Fields {
 						if f.Name == keywordHolder {
 							keywordHolderParentMap.Fields = append(keywordHolderParentMap.Fields[:i], keywordHolderParentMap.Fields[i+1:]...)
+							keywordHolderParentMap.invalidateIndex()
 							break
 						}
 					}
@@ -998,7 +977,7 @@ func (m *Map) GetEdges(eid *EdgeID, refctx *RefContext, c *compiler) []*Edge {
 			gctx = c.ensureGlobContext(refctx)
 		}
 		var ea []*Edge
-		m.getEdges(eid, refctx, gctx, &ea)
+		m.getEdges(eid, refctx, gctx, c, &ea)
 		return ea
 	}
 
@@ -1018,7 +997,7 @@ func (m *Map) GetEdges(eid *EdgeID, refctx *RefContext, c *compiler) []*Edge {
 	}
 
 	var ea []*Edge
-	for _, e := range m.Edges {
+	for _, e := range m.lookupEdges(eid) {
 		if e.ID.Match(eid) {
 			ea = append(ea, e)
 		}
@@ -1026,7 +1005,7 @@ func (m *Map) GetEdges(eid *EdgeID, refctx *RefContext, c *compiler) []*Edge {
 	return ea
 }
 
-func (m *Map) getEdges(eid *EdgeID, refctx *RefContext, gctx *globContext, ea *[]*Edge) error {
+func (m *Map) getEdges(eid *EdgeID, refctx *RefContext, gctx *globContext, c *compiler, ea *[]*Edge) error {
 	eid, m, common, err := eid.resolve(m)
 	if err != nil {
 		return err
@@ -1057,7 +1036,7 @@ func (m *Map) getEdges(eid *EdgeID, refctx *RefContext, gctx *globContext, ea *[
 					parent: f,
 				}
 			}
-			err = f.Map().getEdges(eid, refctx, gctx, ea)
+			err = f.Map().getEdges(eid, refctx, gctx, c, ea)
 			if err != nil {
 				return err
 			}
@@ -1083,12 +1062,7 @@ func (m *Map) getEdges(eid *EdgeID, refctx *RefContext, gctx *globContext, ea *[
 			ea2 := m.GetEdges(eid2, nil, nil)
 			for _, e := range ea2 {
 				if gctx != nil {
-					var ks string
-					if refctx.Key.HasTripleGlob() {
-						ks = d2format.Format(d2ast.MakeKeyPath(IDA(e)))
-					} else {
-						ks = d2format.Format(d2ast.MakeKeyPath(BoardIDA(e)))
-					}
+					ks := c.globKey(e, refctx.Key.HasTripleGlob())
 					if _, ok := gctx.appliedEdges[ks]; ok {
 						continue
 					}
@@ -1247,27 +1221,17 @@ func (m *Map) createEdge2(eid *EdgeID, refctx *RefContext, gctx *globContext, c
 	index := len(ea)
 	eid.Index = &index
 	eid.Glob = false
-	e := &Edge{
-		parent: m,
-		ID:     eid,
-		References: []*EdgeReference{{
-			Context_:       refctx,
-			DueToGlob_:     len(c.globRefContextStack) > 0,
-			DueToLazyGlob_: c.lazyGlobBeingApplied,
-		}},
-	}
+	e := c.edgeSlab.New()
+	e.parent = m
+	e.ID = eid
+	e.References = []*EdgeReference{c.edgeReference(refctx)}
 
 	if gctx != nil {
-		var ks string
 		// We only ever want to create one of the edge per glob so we filter without the edge index.
 		e2 := e.Copy(e.Parent()).(*Edge)
 		e2.ID = e2.ID.Copy()
 		e2.ID.Index = nil
-		if refctx.Key.HasTripleGlob() {
-			ks = d2format.Format(d2ast.MakeKeyPath(IDA(e2)))
-		} else {
-			ks = d2format.Format(d2ast.MakeKeyPath(BoardIDA(e2)))
-		}
+		ks := c.globKey(e2, refctx.Key.HasTripleGlob())
 		if _, ok := gctx.appliedEdges[ks]; ok {
 			return nil, nil
 		}
@@ -1374,13 +1338,7 @@ func (m *Map) appendFieldReferences(i int, kp *d2ast.KeyPath, refctx *RefContext
 		return
 	}
 
-	f.References = append(f.References, &FieldReference{
-		String:         sb.Unbox(),
-		KeyPath:        kp,
-		Context_:       refctx,
-		DueToGlob_:     len(c.globRefContextStack) > 0,
-		DueToLazyGlob_: c.lazyGlobBeingApplied,
-	})
+	f.References = append(f.References, c.fieldReference(sb.Unbox(), kp, refctx))
 	if i+1 == len(kp.Path) {
 		return
 	}
@@ -16,7 +16,12 @@ func (c *compiler) pushImportStack(imp *d2ast.Import) (string, bool) {
 		c.errorf(imp, "imports must specify a path to import")
 		return "", false
 	}
-	if len(c.importStack) > 0 {
+	if _, ok := ImportScheme(impPath); ok {
+		// Resolved by a plugin rather than relative to the importing file.
+		if path.Ext(impPath) != ".d2" {
+			impPath += ".d2"
+		}
+	} else if len(c.importStack) > 0 {
 		if path.IsAbs(impPath) {
 			c.errorf(imp, "import paths must be relative")
 			return "", false
@@ -41,6 +46,25 @@ func (c *compiler) pushImportStack(imp *d2ast.Import) (string, bool) {
 	return impPath, true
 }
 
+// ImportScheme returns the scheme of import paths that are resolved by a plugin, e.g. acme
+// for acme:shared/styles, rather than read from the filesystem. Schemes are at least two
+// characters long so that Windows drive letters are not schemes.
+func ImportScheme(p string) (string, bool) {
+	i := strings.IndexByte(p, ':')
+	if i < 2 {
+		return "", false
+	}
+	for j, r := range p[:i] {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+		case j > 0 && ('0' <= r && r <= '9' || r == '+' || r == '-' || r == '.'):
+		default:
+			return "", false
+		}
+	}
+	return p[:i], true
+}
+
 func (c *compiler) popImportStack() {
 	c.importStack = c.importStack[:len(c.importStack)-1]
 }
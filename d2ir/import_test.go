@@ -27,6 +27,19 @@ label: meow`,
 				assertQuery(t, m, 0, 0, "meow", "x.label")
 			},
 		},
+		{
+			name: "scheme",
+			run: func(t testing.TB) {
+				m, err := compileFS(t, "dir/index.d2", map[string]string{
+					"dir/index.d2":          `x: @"acme:shared/styles"`,
+					"acme:shared/styles.d2": `shape: circle; style: @base`,
+					"acme:shared/base.d2":   `fill: red`,
+				})
+				assert.Success(t, err)
+				assertQuery(t, m, 0, 0, "circle", "x.shape")
+				assertQuery(t, m, 0, 0, "red", "x.style.fill")
+			},
+		},
 		{
 			name: "nested/map",
 			run: func(t testing.TB) {
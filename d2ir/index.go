@@ -0,0 +1,151 @@
+package d2ir
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// indexThreshold is the number of fields or edges from which a map indexes them. Smaller
+// maps are faster to scan than to index.
+const indexThreshold = 32
+
+// fieldIndex indexes the fields of a map by folded name so that looking up fields of maps
+// with many fields, like the root of a large generated graph, doesn't make compilation
+// quadratic.
+//
+// Fields are appended to Map.Fields all over the package so the index catches up with
+// the fields appended since it was last used. Deleting fields must drop the index with
+// invalidateIndex.
+type fieldIndex struct {
+	fields map[string]int
+	n      int
+}
+
+// edgeIndex is fieldIndex for edges, indexed by their folded source and destination
+// paths and arrows.
+type edgeIndex struct {
+	edges map[string][]*Edge
+	n     int
+}
+
+// invalidateIndex drops the indexes of m. It must be called whenever fields or edges are
+// removed from m.
+func (m *Map) invalidateIndex() {
+	m.fieldIndex = nil
+	m.edgeIndex = nil
+}
+
+// lookupField returns the first field of m named name, ignoring case.
+func (m *Map) lookupField(name string) *Field {
+	if len(m.Fields) < indexThreshold {
+		for _, f := range m.Fields {
+			if strings.EqualFold(f.Name, name) {
+				return f
+			}
+		}
+		return nil
+	}
+
+	if m.fieldIndex == nil {
+		m.fieldIndex = &fieldIndex{
+			fields: make(map[string]int, len(m.Fields)),
+		}
+	}
+	idx := m.fieldIndex
+	for ; idx.n < len(m.Fields); idx.n++ {
+		k := foldName(m.Fields[idx.n].Name)
+		if _, ok := idx.fields[k]; !ok {
+			idx.fields[k] = idx.n
+		}
+	}
+	i, ok := idx.fields[foldName(name)]
+	if !ok {
+		return nil
+	}
+	if i >= len(m.Fields) || !strings.EqualFold(m.Fields[i].Name, name) {
+		// Fields were removed without invalidating the index.
+		m.fieldIndex = nil
+		return m.lookupField(name)
+	}
+	return m.Fields[i]
+}
+
+// lookupEdges returns the edges of m that could match eid, in order. They must still be
+// matched against eid.
+func (m *Map) lookupEdges(eid *EdgeID) []*Edge {
+	if len(m.Edges) < indexThreshold {
+		return m.Edges
+	}
+
+	if m.edgeIndex == nil {
+		m.edgeIndex = &edgeIndex{
+			edges: make(map[string][]*Edge, len(m.Edges)),
+		}
+	}
+	idx := m.edgeIndex
+	for ; idx.n < len(m.Edges); idx.n++ {
+		e := m.Edges[idx.n]
+		k := edgeKey(e.ID)
+		idx.edges[k] = append(idx.edges[k], e)
+	}
+	return idx.edges[edgeKey(eid)]
+}
+
+func edgeKey(eid *EdgeID) string {
+	var sb strings.Builder
+	for _, s := range eid.SrcPath {
+		sb.WriteString(foldName(s))
+		sb.WriteByte(0)
+	}
+	if eid.SrcArrow {
+		sb.WriteByte('<')
+	}
+	sb.WriteByte('-')
+	if eid.DstArrow {
+		sb.WriteByte('>')
+	}
+	for _, s := range eid.DstPath {
+		sb.WriteByte(0)
+		sb.WriteString(foldName(s))
+	}
+	return sb.String()
+}
+
+// foldName returns the same string for all names strings.EqualFold considers equal.
+func foldName(s string) string {
+	ascii := true
+	upper := true
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+		if 'a' <= c && c <= 'z' {
+			upper = false
+		}
+	}
+	if ascii {
+		// Upper case letters are the smallest runes of their orbits, see below.
+		if upper {
+			return s
+		}
+		return strings.ToUpper(s)
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(s))
+	for _, r := range s {
+		// The smallest rune of the case folding orbit of r, which strings.EqualFold
+		// considers equal to all others.
+		min := r
+		for r2 := unicode.SimpleFold(r); r2 != r; r2 = unicode.SimpleFold(r2) {
+			if r2 < min {
+				min = r2
+			}
+		}
+		sb.WriteRune(min)
+	}
+	return sb.String()
+}
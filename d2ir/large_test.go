@@ -0,0 +1,103 @@
+package d2ir_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"oss.terrastruct.com/util-go/assert"
+
+	"oss.terrastruct.com/d2/d2ir"
+	"oss.terrastruct.com/d2/d2parser"
+)
+
+// TestCompileLarge checks that the lookups of maps with many fields and edges, which are
+// indexed, behave like those of smaller maps.
+func TestCompileLarge(t *testing.T) {
+	t.Parallel()
+
+	var sb strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&sb, "n%d\n", i)
+	}
+	for i := 0; i < 50; i++ {
+		sb.WriteString("n1 -> n2\n")
+	}
+	sb.WriteString(`N50: fifty
+Ǌ: lj
+ǌ: LJ
+n10: null
+n10: ten
+(N1 -> n2)[49]: last
+(n1 -> n2)[10]: null
+`)
+	ast, err := d2parser.Parse("large.d2", strings.NewReader(sb.String()), nil)
+	assert.Success(t, err)
+	m, _, err := d2ir.Compile(ast, nil)
+	assert.Success(t, err)
+
+	assertQuery(t, m, 101, 49, nil, "")
+	assertQuery(t, m, 0, 0, "fifty", "n50")
+	assertQuery(t, m, 0, 0, "ten", "n10")
+	assertQuery(t, m, 0, 0, "LJ", "ǋ")
+	assert.Equal(t, "n10", m.Fields[len(m.Fields)-1].Name)
+
+	ea := m.GetEdges(&d2ir.EdgeID{SrcPath: []string{"N1"}, DstPath: []string{"N2"}, DstArrow: true}, nil, nil)
+	assert.Equal(t, 49, len(ea))
+	assert.Equal(t, "last", ea[48].Primary().String())
+}
+
+// generatedGraph returns a graph of n objects with an edge between consecutive objects,
+// like those generated from other sources. Objects are spread over containers of size
+// objects, or all at the root if size is 0.
+func generatedGraph(n, size int, globs bool) string {
+	var sb strings.Builder
+	if globs {
+		sb.WriteString("**.style.stroke: red\n")
+		sb.WriteString("(* -> *)[*].style.stroke-width: 2\n")
+	}
+	id := func(i int) string {
+		if size == 0 {
+			return fmt.Sprintf("n%d", i)
+		}
+		return fmt.Sprintf("c%d.n%d", i/size, i)
+	}
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "%s: %d\n", id(i), i)
+		if i > 0 {
+			fmt.Fprintf(&sb, "%s -> %s\n", id(i-1), id(i))
+		}
+	}
+	return sb.String()
+}
+
+func benchmarkCompile(b *testing.B, n, size int, globs bool) {
+	text := generatedGraph(n, size, globs)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ast, err := d2parser.Parse("bench.d2", strings.NewReader(text), nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, _, err = d2ir.Compile(ast, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompile(b *testing.B) {
+	for _, n := range []int{1000, 10000, 50000} {
+		b.Run(fmt.Sprintf("containers/%d", n), func(b *testing.B) {
+			benchmarkCompile(b, n, 100, false)
+		})
+		b.Run(fmt.Sprintf("flat/%d", n), func(b *testing.B) {
+			benchmarkCompile(b, n, 0, false)
+		})
+	}
+	for _, n := range []int{100, 300} {
+		b.Run(fmt.Sprintf("globs/%d", n), func(b *testing.B) {
+			benchmarkCompile(b, n, 100, true)
+		})
+	}
+}
@@ -65,7 +65,9 @@ func (m *Map) _tripleGlob(fa *[]*Field) {
 	}
 }
 
-func matchPattern(s string, pattern []string) bool {
+// MatchPattern reports whether s matches the glob pattern of a key element, as parsed into
+// d2ast.UnquotedString.Pattern.
+func MatchPattern(s string, pattern []string) bool {
 	if len(pattern) == 0 {
 		return true
 	}
@@ -110,51 +110,51 @@ t*ink*r*t*inke*: globbed`)
 		{
 			name: "nested/prefix-suffix/3",
 			run: func(t testing.TB) {
-				m, err := compile(t, `animate.constant.tinkertinker: meow
+				m, err := compile(t, `animator.constant.tinkertinker: meow
 astronaut.constant.thinkerthinker: yes
 a*n*t*.constant.t*ink*r*t*inke*: globbed`)
 				assert.Success(t, err)
 				assertQuery(t, m, 6, 0, nil, "")
-				assertQuery(t, m, 0, 0, "globbed", "animate.constant.tinkertinker")
+				assertQuery(t, m, 0, 0, "globbed", "animator.constant.tinkertinker")
 				assertQuery(t, m, 0, 0, "globbed", "astronaut.constant.thinkerthinker")
 			},
 		},
 		{
 			name: "edge/1",
 			run: func(t testing.TB) {
-				m, err := compile(t, `animate
+				m, err := compile(t, `animator
 animal
 an* -> an*`)
 				assert.Success(t, err)
 				assertQuery(t, m, 2, 2, nil, "")
-				assertQuery(t, m, 0, 0, nil, "(animate -> animal)[0]")
-				assertQuery(t, m, 0, 0, nil, "(animal -> animate)[0]")
+				assertQuery(t, m, 0, 0, nil, "(animator -> animal)[0]")
+				assertQuery(t, m, 0, 0, nil, "(animal -> animator)[0]")
 			},
 		},
 		{
 			name: "edge/2",
 			run: func(t testing.TB) {
-				m, err := compile(t, `shared.animate
+				m, err := compile(t, `shared.animator
 shared.animal
 sh*.(an* -> an*)`)
 				assert.Success(t, err)
 				assertQuery(t, m, 3, 2, nil, "")
 				assertQuery(t, m, 2, 2, nil, "shared")
-				assertQuery(t, m, 0, 0, nil, "shared.(animate -> animal)[0]")
-				assertQuery(t, m, 0, 0, nil, "shared.(animal -> animate)[0]")
+				assertQuery(t, m, 0, 0, nil, "shared.(animator -> animal)[0]")
+				assertQuery(t, m, 0, 0, nil, "shared.(animal -> animator)[0]")
 			},
 		},
 		{
 			name: "edge/3",
 			run: func(t testing.TB) {
-				m, err := compile(t, `shared.animate
+				m, err := compile(t, `shared.animator
 shared.animal
 sh*.an* -> sh*.an*`)
 				assert.Success(t, err)
 				assertQuery(t, m, 3, 2, nil, "")
 				assertQuery(t, m, 2, 2, nil, "shared")
-				assertQuery(t, m, 0, 0, nil, "shared.(animate -> animal)[0]")
-				assertQuery(t, m, 0, 0, nil, "shared.(animal -> animate)[0]")
+				assertQuery(t, m, 0, 0, nil, "shared.(animator -> animal)[0]")
+				assertQuery(t, m, 0, 0, nil, "shared.(animal -> animator)[0]")
 			},
 		},
 		{
@@ -209,15 +209,15 @@ d
 		{
 			name: "double-glob/1",
 			run: func(t testing.TB) {
-				m, err := compile(t, `shared.animate
+				m, err := compile(t, `shared.animator
 shared.animal
 **.style.fill: red`)
 				assert.Success(t, err)
 				assertQuery(t, m, 9, 0, nil, "")
 				assertQuery(t, m, 8, 0, nil, "shared")
 				assertQuery(t, m, 1, 0, nil, "shared.style")
-				assertQuery(t, m, 2, 0, nil, "shared.animate")
-				assertQuery(t, m, 1, 0, nil, "shared.animate.style")
+				assertQuery(t, m, 2, 0, nil, "shared.animator")
+				assertQuery(t, m, 1, 0, nil, "shared.animator.style")
 				assertQuery(t, m, 2, 0, nil, "shared.animal")
 				assertQuery(t, m, 1, 0, nil, "shared.animal.style")
 			},
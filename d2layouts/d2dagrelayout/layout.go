@@ -56,7 +56,8 @@ type DagreNode struct {
 }
 
 type DagreEdge struct {
-	Points []*geo.Point `json:"points"`
+	// Points are decoded packed, to be referenced by the routes of edges rather than copied.
+	Points []geo.Point `json:"points"`
 }
 
 type dagreOpts struct {
@@ -64,6 +65,11 @@ type dagreOpts struct {
 	ranksep int
 	// graph direction: tb (top to bottom)| bt | lr | rl
 	rankdir string
+	// number of crossing minimization sweeps without improvement before ordering stops, 4
+	// if 0
+	orderSweeps int
+	// skip resolving conflicts between edges of dummy and border nodes when positioning
+	fastPosition bool
 
 	ConfigurableOpts
 }
@@ -79,14 +85,6 @@ func Layout(ctx context.Context, g *d2graph.Graph, opts *ConfigurableOpts) (err
 	defer xdefer.Errorf(&err, "failed to dagre layout")
 
 	debugJS := false
-	vm := goja.New()
-	if _, err := vm.RunString(dagreJS); err != nil {
-		return err
-	}
-	if _, err := vm.RunString(setupJS); err != nil {
-		return err
-	}
-
 	rootAttrs := dagreOpts{
 		ConfigurableOpts: ConfigurableOpts{
 			EdgeSep: opts.EdgeSep,
@@ -134,11 +132,6 @@ func Layout(ctx context.Context, g *d2graph.Graph, opts *ConfigurableOpts) (err
 		// Note: non-containers have both of these as padding (rootAttrs.NodeSep + rootAttrs.EdgeSep)
 	}
 
-	configJS := setGraphAttrs(rootAttrs)
-	if _, err := vm.RunString(configJS); err != nil {
-		return err
-	}
-
 	mapper := NewObjectMapper()
 	for _, obj := range g.Objects {
 		mapper.Register(obj)
@@ -179,14 +172,22 @@ func Layout(ctx context.Context, g *d2graph.Graph, opts *ConfigurableOpts) (err
 	}
 
 	if debugJS {
-		log.Debug(ctx, "script", slog.F("all", setupJS+configJS+loadScript))
+		log.Debug(ctx, "script", slog.F("all", setupJS+setGraphAttrs(rootAttrs)+loadScript))
 	}
 
-	if _, err := vm.RunString(loadScript); err != nil {
+	var vm *goja.Runtime
+	err = d2graph.WithinLayoutBudget(ctx, g, func(ctx context.Context) (err error) {
+		vm, err = runDagre(ctx, rootAttrs, loadScript)
 		return err
-	}
-
-	if _, err := vm.RunString(`dagre.layout(g)`); err != nil {
+	}, func(ctx context.Context) (err error) {
+		// Ordering and positioning take most of the time of large graphs.
+		cheapAttrs := rootAttrs
+		cheapAttrs.orderSweeps = 1
+		cheapAttrs.fastPosition = true
+		vm, err = runDagre(ctx, cheapAttrs, loadScript)
+		return err
+	})
+	if err != nil {
 		if debugJS {
 			log.Warn(ctx, "layout error", slog.F("err", err))
 		}
@@ -230,9 +231,9 @@ func Layout(ctx context.Context, g *d2graph.Graph, opts *ConfigurableOpts) (err
 		points := make([]*geo.Point, len(de.Points))
 		for i := range de.Points {
 			if edge.SrcArrow && !edge.DstArrow {
-				points[len(de.Points)-i-1] = de.Points[i].Copy()
+				points[len(de.Points)-i-1] = &de.Points[i]
 			} else {
-				points[i] = de.Points[i].Copy()
+				points[i] = &de.Points[i]
 			}
 		}
 
@@ -342,18 +343,29 @@ func Layout(ctx context.Context, g *d2graph.Graph, opts *ConfigurableOpts) (err
 			vectors = append(vectors, points[i-1].VectorTo(points[i]))
 		}
 
-		path := make([]*geo.Point, 0)
+		pathLen := 2
+		if len(vectors) > 1 {
+			pathLen += 2 + 3*max(len(vectors)-3, 0)
+		}
+		// the points along the curve are packed in one allocation
+		curve := geo.NewPoints(pathLen - 2)
+		path := make([]*geo.Point, 0, pathLen)
+		add := func(p *geo.Point) {
+			c := curve[len(path)-1]
+			*c = *p
+			path = append(path, c)
+		}
 		path = append(path, points[0])
 		if len(vectors) > 1 {
-			path = append(path, points[0].AddVector(vectors[0].Multiply(.8)))
+			add(points[0].AddVector(vectors[0].Multiply(.8)))
 			for i := 1; i < len(vectors)-2; i++ {
 				p := points[i]
 				v := vectors[i]
-				path = append(path, p.AddVector(v.Multiply(.2)))
-				path = append(path, p.AddVector(v.Multiply(.5)))
-				path = append(path, p.AddVector(v.Multiply(.8)))
+				add(p.AddVector(v.Multiply(.2)))
+				add(p.AddVector(v.Multiply(.5)))
+				add(p.AddVector(v.Multiply(.8)))
 			}
-			path = append(path, points[len(points)-2].AddVector(vectors[len(vectors)-1].Multiply(.2)))
+			add(points[len(points)-2].AddVector(vectors[len(vectors)-1].Multiply(.2)))
 			edge.IsCurve = true
 		}
 		path = append(path, points[len(points)-1])
@@ -397,18 +409,53 @@ func getEdgeEndpoints(g *d2graph.Graph, edge *d2graph.Edge) (*d2graph.Object, *d
 	return src, dst
 }
 
+// runDagre lays out the graph of loadScript in a new VM, which it returns to read the
+// result from. It is interrupted once ctx is done.
+func runDagre(ctx context.Context, attrs dagreOpts, loadScript string) (*goja.Runtime, error) {
+	vm := goja.New()
+	stop := context.AfterFunc(ctx, func() {
+		vm.Interrupt(ctx.Err())
+	})
+	defer stop()
+
+	if _, err := vm.RunString(dagreJS); err != nil {
+		return nil, err
+	}
+	if _, err := vm.RunString(setupJS); err != nil {
+		return nil, err
+	}
+	if _, err := vm.RunString(setGraphAttrs(attrs)); err != nil {
+		return nil, err
+	}
+	if _, err := vm.RunString(loadScript); err != nil {
+		return nil, err
+	}
+	if _, err := vm.RunString(`dagre.layout(g)`); err != nil {
+		return nil, err
+	}
+	return vm, nil
+}
+
 func setGraphAttrs(attrs dagreOpts) string {
+	var extra string
+	if attrs.orderSweeps != 0 {
+		extra += fmt.Sprintf("  ordersweeps: %d,\n", attrs.orderSweeps)
+	}
+	if attrs.fastPosition {
+		extra += "  fastposition: true,\n"
+	}
 	return fmt.Sprintf(`g.setGraph({
   ranksep: %d,
   edgesep: %d,
   nodesep: %d,
   rankdir: "%s",
-});
+%s});
 `,
 		attrs.ranksep,
 		attrs.ConfigurableOpts.EdgeSep,
 		attrs.ConfigurableOpts.NodeSep,
 		attrs.rankdir,
+		extra,
 	)
 }
 
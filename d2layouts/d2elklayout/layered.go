@@ -0,0 +1,736 @@
+package d2elklayout
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// layered.go lays out large graphs with the layered algorithm natively in Go. ELK takes
+// minutes on graphs of thousands of shapes in the JS runtime, mostly minimizing crossings,
+// which is done here with independent trials in parallel.
+//
+// Only graphs without containers or ports are laid out natively. The result is written to
+// the ELK graph as ELK would, so Layout reads it the same way.
+
+const (
+	// layeredTrials is the number of orderings crossings are minimized from in parallel. It
+	// is fixed rather than the number of CPUs so that layouts are the same on every machine.
+	layeredTrials = 8
+	// layeredSweeps is the maximum number of sweeps of a trial.
+	layeredSweeps = 24
+	// layeredTrackSpacing is the spacing between the horizontal segments of edges routed
+	// between the same layers.
+	layeredTrackSpacing = 10.
+	// layeredDummySpacing is the spacing between edges passing through a layer.
+	layeredDummySpacing = 10.
+)
+
+// canLayoutNatively returns whether elkGraph is laid out natively with opts.
+func canLayoutNatively(elkGraph *ELKGraph, opts *ConfigurableOpts) bool {
+	if opts.NativeMinNodes <= 0 || len(elkGraph.Children) < opts.NativeMinNodes {
+		return false
+	}
+	if elkGraph.LayoutOptions.Algorithm != "" && elkGraph.LayoutOptions.Algorithm != "layered" {
+		return false
+	}
+	ids := make(map[string]struct{}, len(elkGraph.Children))
+	for _, n := range elkGraph.Children {
+		if len(n.Children) > 0 || len(n.Ports) > 0 {
+			return false
+		}
+		ids[n.ID] = struct{}{}
+	}
+	for _, e := range elkGraph.Edges {
+		if len(e.Sources) != 1 || len(e.Targets) != 1 {
+			return false
+		}
+		if _, ok := ids[e.Sources[0]]; !ok {
+			return false
+		}
+		if _, ok := ids[e.Targets[0]]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// layered is a graph being laid out natively. Its layers are stacked from top to bottom,
+// whatever the direction of the ELK graph, which is only applied once done.
+type layered struct {
+	opts *elkOpts
+
+	elkNodes []*ELKNode
+	edges    []*layeredEdge
+	loops    []*ELKEdge
+
+	// Nodes are indexed from 0, the nodes of the ELK graph first, then the dummy nodes
+	// edges spanning several layers pass through.
+	w, h  []float64
+	layer []int
+	in    [][]int
+	out   [][]int
+	// dummy is whether a node is a dummy node.
+	dummy []bool
+	// loopWidth is the space right of a node its self loops take.
+	loopWidth []float64
+
+	layers [][]int
+	pos    []int
+	x      []float64
+	top    []float64
+}
+
+type layeredEdge struct {
+	elk      *ELKEdge
+	reversed bool
+	// chain is the nodes the edge passes through from the top layer to the bottom one.
+	chain []int
+}
+
+// layoutNatively lays out elkGraph, which canLayoutNatively.
+func layoutNatively(ctx context.Context, elkGraph *ELKGraph) error {
+	l := newLayered(elkGraph)
+	l.breakCycles()
+	l.assignLayers()
+	l.addDummies()
+	err := l.minimizeCrossings(ctx)
+	if err != nil {
+		return err
+	}
+	l.placeNodes()
+	l.route()
+	return nil
+}
+
+func newLayered(elkGraph *ELKGraph) *layered {
+	l := &layered{
+		opts:     elkGraph.LayoutOptions,
+		elkNodes: elkGraph.Children,
+	}
+	horizontal := l.opts.Direction == Right || l.opts.Direction == Left
+	ids := make(map[string]int, len(elkGraph.Children))
+	for i, n := range elkGraph.Children {
+		ids[n.ID] = i
+		w, h := n.Width, n.Height
+		if horizontal {
+			w, h = h, w
+		}
+		l.addNode(w, h, false)
+	}
+	for _, e := range elkGraph.Edges {
+		src, dst := ids[e.Sources[0]], ids[e.Targets[0]]
+		if src == dst {
+			l.loops = append(l.loops, e)
+			l.loopWidth[src] += float64(l.opts.SelfLoopSpacing) / 2
+			continue
+		}
+		l.edges = append(l.edges, &layeredEdge{
+			elk:   e,
+			chain: []int{src, dst},
+		})
+	}
+	return l
+}
+
+func (l *layered) addNode(w, h float64, dummy bool) int {
+	l.w = append(l.w, w)
+	l.h = append(l.h, h)
+	l.layer = append(l.layer, 0)
+	l.in = append(l.in, nil)
+	l.out = append(l.out, nil)
+	l.dummy = append(l.dummy, dummy)
+	l.loopWidth = append(l.loopWidth, 0)
+	return len(l.w) - 1
+}
+
+// breakCycles reverses the edges that close cycles when visited depth first in model
+// order.
+func (l *layered) breakCycles() {
+	n := len(l.elkNodes)
+	adj := make([][]*layeredEdge, n)
+	for _, e := range l.edges {
+		adj[e.chain[0]] = append(adj[e.chain[0]], e)
+	}
+
+	const (
+		unvisited = iota
+		onStack
+		done
+	)
+	state := make([]int, n)
+	type frame struct {
+		node int
+		next int
+	}
+	var stack []frame
+	for root := 0; root < n; root++ {
+		if state[root] != unvisited {
+			continue
+		}
+		state[root] = onStack
+		stack = append(stack, frame{node: root})
+		for len(stack) > 0 {
+			f := &stack[len(stack)-1]
+			if f.next == len(adj[f.node]) {
+				state[f.node] = done
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			e := adj[f.node][f.next]
+			f.next++
+			dst := e.chain[1]
+			switch state[dst] {
+			case onStack:
+				e.reversed = true
+				e.chain[0], e.chain[1] = e.chain[1], e.chain[0]
+			case unvisited:
+				state[dst] = onStack
+				stack = append(stack, frame{node: dst})
+			}
+		}
+	}
+}
+
+// assignLayers puts every node one layer below its lowest predecessor, then moves sources
+// down to right above their highest successor.
+func (l *layered) assignLayers() {
+	n := len(l.elkNodes)
+	preds := make([][]int, n)
+	succs := make([][]int, n)
+	for _, e := range l.edges {
+		src, dst := e.chain[0], e.chain[1]
+		succs[src] = append(succs[src], dst)
+		preds[dst] = append(preds[dst], src)
+	}
+
+	indegree := make([]int, n)
+	var queue []int
+	for v := 0; v < n; v++ {
+		indegree[v] = len(preds[v])
+		if indegree[v] == 0 {
+			queue = append(queue, v)
+		}
+	}
+	for i := 0; i < len(queue); i++ {
+		u := queue[i]
+		for _, v := range succs[u] {
+			l.layer[v] = max(l.layer[v], l.layer[u]+1)
+			indegree[v]--
+			if indegree[v] == 0 {
+				queue = append(queue, v)
+			}
+		}
+	}
+
+	for v := 0; v < n; v++ {
+		if len(preds[v]) > 0 || len(succs[v]) == 0 {
+			continue
+		}
+		lowest := math.MaxInt
+		for _, s := range succs[v] {
+			lowest = min(lowest, l.layer[s])
+		}
+		l.layer[v] = lowest - 1
+	}
+}
+
+// addDummies splits edges spanning several layers with a dummy node in every layer they
+// pass through and orders the nodes of every layer in model order.
+func (l *layered) addDummies() {
+	for _, e := range l.edges {
+		src, dst := e.chain[0], e.chain[1]
+		chain := []int{src}
+		for layer := l.layer[src] + 1; layer < l.layer[dst]; layer++ {
+			d := l.addNode(0, 0, true)
+			l.layer[d] = layer
+			chain = append(chain, d)
+		}
+		e.chain = append(chain, dst)
+		for i := 1; i < len(e.chain); i++ {
+			l.out[e.chain[i-1]] = append(l.out[e.chain[i-1]], e.chain[i])
+			l.in[e.chain[i]] = append(l.in[e.chain[i]], e.chain[i-1])
+		}
+	}
+
+	layers := 0
+	for _, layer := range l.layer {
+		layers = max(layers, layer+1)
+	}
+	l.layers = make([][]int, layers)
+	for v, layer := range l.layer {
+		l.layers[layer] = append(l.layers[layer], v)
+	}
+	l.pos = make([]int, len(l.w))
+	for _, layer := range l.layers {
+		for i, v := range layer {
+			l.pos[v] = i
+		}
+	}
+}
+
+// minimizeCrossings orders the nodes of every layer with the barycenter heuristic from
+// several orderings in parallel, keeping the ordering with the fewest crossings.
+func (l *layered) minimizeCrossings(ctx context.Context) error {
+	type result struct {
+		layers    [][]int
+		crossings int
+	}
+	results := make([]result, layeredTrials)
+	var wg sync.WaitGroup
+	for trial := 0; trial < layeredTrials; trial++ {
+		wg.Add(1)
+		go func(trial int) {
+			defer wg.Done()
+			layers, crossings := l.crossingTrial(ctx, trial)
+			results[trial] = result{layers, crossings}
+		}(trial)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	best := results[0]
+	for _, r := range results[1:] {
+		if r.crossings < best.crossings {
+			best = r
+		}
+	}
+	l.layers = best.layers
+	for _, layer := range l.layers {
+		for i, v := range layer {
+			l.pos[v] = i
+		}
+	}
+	return nil
+}
+
+// crossingTrial sweeps the layers up and down from the model order for trial 0 and from a
+// random ordering seeded by trial otherwise, until crossings stop decreasing.
+func (l *layered) crossingTrial(ctx context.Context, trial int) ([][]int, int) {
+	layers := make([][]int, len(l.layers))
+	for i, layer := range l.layers {
+		layers[i] = append([]int(nil), layer...)
+	}
+	pos := make([]int, len(l.pos))
+	if trial > 0 {
+		r := rand.New(rand.NewSource(int64(trial)))
+		for _, layer := range layers {
+			r.Shuffle(len(layer), func(i, j int) {
+				layer[i], layer[j] = layer[j], layer[i]
+			})
+		}
+	}
+	for _, layer := range layers {
+		for i, v := range layer {
+			pos[v] = i
+		}
+	}
+
+	best := copyLayers(layers)
+	bestCrossings := l.crossings(layers, pos)
+	bary := make([]float64, len(pos))
+	stale := 0
+	for sweep := 0; sweep < layeredSweeps && bestCrossings > 0 && stale < 2; sweep++ {
+		if ctx.Err() != nil {
+			break
+		}
+		if sweep%2 == 0 {
+			for i := 1; i < len(layers); i++ {
+				l.sortByBarycenter(layers[i], l.in, pos, bary)
+			}
+		} else {
+			for i := len(layers) - 2; i >= 0; i-- {
+				l.sortByBarycenter(layers[i], l.out, pos, bary)
+			}
+		}
+		crossings := l.crossings(layers, pos)
+		if crossings < bestCrossings {
+			bestCrossings = crossings
+			best = copyLayers(layers)
+			stale = 0
+		} else {
+			stale++
+		}
+	}
+	return best, bestCrossings
+}
+
+func copyLayers(layers [][]int) [][]int {
+	cp := make([][]int, len(layers))
+	for i, layer := range layers {
+		cp[i] = append([]int(nil), layer...)
+	}
+	return cp
+}
+
+// sortByBarycenter orders layer by the mean position of the neighbors of its nodes in the
+// adjacent layer. Nodes without any keep their position.
+func (l *layered) sortByBarycenter(layer []int, neighbors [][]int, pos []int, bary []float64) {
+	for _, v := range layer {
+		if len(neighbors[v]) == 0 {
+			bary[v] = float64(pos[v])
+			continue
+		}
+		sum := 0
+		for _, u := range neighbors[v] {
+			sum += pos[u]
+		}
+		bary[v] = float64(sum) / float64(len(neighbors[v]))
+	}
+	sort.SliceStable(layer, func(i, j int) bool {
+		return bary[layer[i]] < bary[layer[j]]
+	})
+	for i, v := range layer {
+		pos[v] = i
+	}
+}
+
+// crossings counts the crossings between all adjacent layers with an accumulator tree, see
+// "Simple and Efficient Bilayer Cross Counting" by Barth, Jünger and Mutzel.
+func (l *layered) crossings(layers [][]int, pos []int) int {
+	total := 0
+	var tree, south []int
+	for i := 0; i+1 < len(layers); i++ {
+		firstIndex := 1
+		for firstIndex < len(layers[i+1]) {
+			firstIndex *= 2
+		}
+		treeSize := 2*firstIndex - 1
+		firstIndex--
+		if cap(tree) < treeSize {
+			tree = make([]int, treeSize)
+		}
+		tree = tree[:treeSize]
+		clear(tree)
+		for _, u := range layers[i] {
+			south = south[:0]
+			for _, v := range l.out[u] {
+				south = append(south, pos[v])
+			}
+			sort.Ints(south)
+			for _, p := range south {
+				index := p + firstIndex
+				tree[index]++
+				for index > 0 {
+					if index%2 == 1 {
+						total += tree[index+1]
+					}
+					index = (index - 1) / 2
+					tree[index]++
+				}
+			}
+		}
+	}
+	return total
+}
+
+// spacing returns the minimum distance between the centers of u and v, u left of v in the
+// same layer.
+func (l *layered) spacing(u, v int) float64 {
+	gap := float64(l.opts.EdgeNode)
+	if l.dummy[u] && l.dummy[v] {
+		gap = layeredDummySpacing
+	} else if l.dummy[u] || l.dummy[v] {
+		gap /= 2
+	}
+	return l.w[u]/2 + l.loopWidth[u] + gap + l.w[v]/2
+}
+
+// placeNodes positions the nodes of every layer as close to the mean of their neighbors as
+// their ordering and spacing allow, alternating between the neighbors above and below.
+// Dummy nodes pull harder than nodes so that long edges run straight.
+func (l *layered) placeNodes() {
+	l.x = make([]float64, len(l.w))
+	for _, layer := range l.layers {
+		x := 0.
+		for i, v := range layer {
+			if i > 0 {
+				x += l.spacing(layer[i-1], v)
+			}
+			l.x[v] = x
+		}
+	}
+
+	desired := make([]float64, len(l.w))
+	weights := make([]float64, len(l.w))
+	for iter := 0; iter < 8; iter++ {
+		down := iter%2 == 0
+		for i := range l.layers {
+			layer := l.layers[i]
+			if !down {
+				layer = l.layers[len(l.layers)-1-i]
+			}
+			for _, v := range layer {
+				var neighbors []int
+				switch {
+				case iter >= 6:
+					neighbors = append(append(neighbors, l.in[v]...), l.out[v]...)
+				case down:
+					neighbors = l.in[v]
+				default:
+					neighbors = l.out[v]
+				}
+				if len(neighbors) == 0 {
+					desired[v] = l.x[v]
+					weights[v] = 0.1
+					continue
+				}
+				sum := 0.
+				for _, u := range neighbors {
+					sum += l.x[u]
+				}
+				desired[v] = sum / float64(len(neighbors))
+				weights[v] = 1
+				if l.dummy[v] {
+					weights[v] = 8
+				}
+			}
+			l.placeLayer(layer, desired, weights)
+		}
+	}
+
+	minX := math.Inf(1)
+	for v := range l.x {
+		minX = math.Min(minX, l.x[v]-l.w[v]/2)
+	}
+	for v := range l.x {
+		l.x[v] -= minX
+	}
+}
+
+// placeLayer positions the nodes of layer as close to desired as their spacing allows, in
+// the least squares sense weighted by weights. With c the offsets of the nodes packed
+// together, x-c must be nondecreasing, which is an isotonic regression solved by pooling
+// adjacent violators.
+func (l *layered) placeLayer(layer []int, desired, weights []float64) {
+	type block struct {
+		w, wt float64
+		n     int
+	}
+	offsets := make([]float64, len(layer))
+	blocks := make([]block, 0, len(layer))
+	for i, v := range layer {
+		if i > 0 {
+			offsets[i] = offsets[i-1] + l.spacing(layer[i-1], v)
+		}
+		b := block{w: weights[v], wt: weights[v] * (desired[v] - offsets[i]), n: 1}
+		for len(blocks) > 0 {
+			prev := blocks[len(blocks)-1]
+			if prev.wt/prev.w < b.wt/b.w {
+				break
+			}
+			b = block{w: prev.w + b.w, wt: prev.wt + b.wt, n: prev.n + b.n}
+			blocks = blocks[:len(blocks)-1]
+		}
+		blocks = append(blocks, b)
+	}
+	i := 0
+	for _, b := range blocks {
+		z := b.wt / b.w
+		for j := 0; j < b.n; j++ {
+			l.x[layer[i]] = z + offsets[i]
+			i++
+		}
+	}
+}
+
+// segment is the part of an edge between two adjacent layers.
+type segment struct {
+	src, dst   int
+	srcX, dstX float64
+	track      int
+}
+
+// route routes edges orthogonally, giving horizontal segments between the same layers
+// their own track, then spaces the layers to fit the tracks.
+func (l *layered) route() {
+	gaps := make([][]*segment, len(l.layers))
+	segments := make(map[*layeredEdge][]*segment, len(l.edges))
+	outs := make([][]*segment, len(l.w))
+	ins := make([][]*segment, len(l.w))
+	for _, e := range l.edges {
+		for i := 1; i < len(e.chain); i++ {
+			s := &segment{src: e.chain[i-1], dst: e.chain[i]}
+			segments[e] = append(segments[e], s)
+			gaps[l.layer[s.src]] = append(gaps[l.layer[s.src]], s)
+			outs[s.src] = append(outs[s.src], s)
+			ins[s.dst] = append(ins[s.dst], s)
+		}
+	}
+
+	// Edges leave and enter nodes spread along their sides, in the order of where they go
+	// to and come from so that they don't cross there.
+	for v := range l.w {
+		sort.SliceStable(outs[v], func(i, j int) bool {
+			return l.x[outs[v][i].dst] < l.x[outs[v][j].dst]
+		})
+		for i, s := range outs[v] {
+			s.srcX = l.x[v] - l.w[v]/2 + l.w[v]*float64(i+1)/float64(len(outs[v])+1)
+		}
+		sort.SliceStable(ins[v], func(i, j int) bool {
+			return l.x[ins[v][i].src] < l.x[ins[v][j].src]
+		})
+		for i, s := range ins[v] {
+			s.dstX = l.x[v] - l.w[v]/2 + l.w[v]*float64(i+1)/float64(len(ins[v])+1)
+		}
+	}
+
+	tracks := make([]int, len(l.layers))
+	for i, gap := range gaps {
+		tracks[i] = assignTracks(gap)
+	}
+
+	// Labels are placed in the middle of routes, so space is made for them between the
+	// layers in the middle of their edges.
+	labelSpace := make([]float64, len(l.layers))
+	for _, e := range l.edges {
+		for _, lbl := range e.elk.Labels {
+			gap := l.layer[e.chain[(len(e.chain)-1)/2]]
+			labelSpace[gap] = math.Max(labelSpace[gap], lbl.Height)
+		}
+	}
+
+	layerHeight := make([]float64, len(l.layers))
+	for v := range l.h {
+		layerHeight[l.layer[v]] = math.Max(layerHeight[l.layer[v]], l.h[v])
+	}
+	edgeNode := float64(l.opts.EdgeNodeSpacing)
+	layerTop := make([]float64, len(l.layers))
+	trackTop := make([]float64, len(l.layers))
+	for i := range l.layers {
+		if i > 0 {
+			gap := 2*edgeNode + labelSpace[i-1] + float64(max(tracks[i-1]-1, 0))*layeredTrackSpacing
+			gap = math.Max(gap, float64(l.opts.NodeSpacing))
+			layerTop[i] = layerTop[i-1] + layerHeight[i-1] + gap
+		}
+		trackTop[i] = layerTop[i] + layerHeight[i] + edgeNode + labelSpace[i]/2
+	}
+	l.top = make([]float64, len(l.h))
+	for v := range l.h {
+		l.top[v] = layerTop[l.layer[v]] + (layerHeight[l.layer[v]]-l.h[v])/2
+	}
+	height := layerTop[len(layerTop)-1] + layerHeight[len(layerHeight)-1]
+	width := 0.
+	for v := range l.x {
+		width = math.Max(width, l.x[v]+l.w[v]/2+l.loopWidth[v])
+	}
+
+	t := l.transform(width, height)
+	for v, n := range l.elkNodes {
+		n.X, n.Y = t.box(l.x[v]-l.w[v]/2, l.top[v], l.w[v], l.h[v])
+	}
+
+	for _, e := range l.edges {
+		segs := segments[e]
+		first, last := segs[0], segs[len(segs)-1]
+		points := []ELKPoint{{first.srcX, l.top[first.src] + l.h[first.src]}}
+		for _, s := range segs {
+			if s.srcX == s.dstX {
+				continue
+			}
+			y := trackTop[l.layer[s.src]] + float64(s.track)*layeredTrackSpacing
+			points = append(points, ELKPoint{s.srcX, y}, ELKPoint{s.dstX, y})
+		}
+		points = append(points, ELKPoint{last.dstX, l.top[last.dst]})
+		if e.reversed {
+			for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+				points[i], points[j] = points[j], points[i]
+			}
+		}
+		e.elk.Sections = []ELKEdgeSection{t.section(points)}
+	}
+
+	loops := make(map[string]int)
+	ids := make(map[string]int, len(l.elkNodes))
+	for v, n := range l.elkNodes {
+		ids[n.ID] = v
+	}
+	for _, e := range l.loops {
+		v := ids[e.Sources[0]]
+		loops[e.Sources[0]]++
+		right := l.x[v] + l.w[v]/2
+		out := right + float64(loops[e.Sources[0]])*float64(l.opts.SelfLoopSpacing)/2
+		y1 := l.top[v] + l.h[v]/3
+		y2 := l.top[v] + l.h[v]*2/3
+		e.Sections = []ELKEdgeSection{t.section([]ELKPoint{
+			{right, y1}, {out, y1}, {out, y2}, {right, y2},
+		})}
+	}
+}
+
+// assignTracks gives every segment of gap that isn't vertical the first track free where
+// it runs, and returns the number of tracks.
+func assignTracks(gap []*segment) int {
+	var horizontal []*segment
+	for _, s := range gap {
+		if s.srcX != s.dstX {
+			horizontal = append(horizontal, s)
+		}
+	}
+	sort.SliceStable(horizontal, func(i, j int) bool {
+		return math.Min(horizontal[i].srcX, horizontal[i].dstX) < math.Min(horizontal[j].srcX, horizontal[j].dstX)
+	})
+	var ends []float64
+	for _, s := range horizontal {
+		start, end := math.Min(s.srcX, s.dstX), math.Max(s.srcX, s.dstX)
+		s.track = len(ends)
+		for i, e := range ends {
+			if e+layeredTrackSpacing <= start {
+				s.track = i
+				break
+			}
+		}
+		if s.track == len(ends) {
+			ends = append(ends, end)
+		} else {
+			ends[s.track] = end
+		}
+	}
+	return len(ends)
+}
+
+// layeredTransform maps the coordinates of a layout from top to bottom to the direction of
+// the ELK graph.
+type layeredTransform struct {
+	dir           Direction
+	width, height float64
+}
+
+func (l *layered) transform(width, height float64) layeredTransform {
+	return layeredTransform{dir: l.opts.Direction, width: width, height: height}
+}
+
+func (t layeredTransform) point(x, y float64) (float64, float64) {
+	switch t.dir {
+	case Up:
+		return x, t.height - y
+	case Right:
+		return y, x
+	case Left:
+		return t.height - y, x
+	default:
+		return x, y
+	}
+}
+
+// box returns the top left corner of the box at x, y of size w, h.
+func (t layeredTransform) box(x, y, w, h float64) (float64, float64) {
+	x1, y1 := t.point(x, y)
+	x2, y2 := t.point(x+w, y+h)
+	return math.Min(x1, x2), math.Min(y1, y2)
+}
+
+func (t layeredTransform) section(points []ELKPoint) ELKEdgeSection {
+	for i := range points {
+		points[i].X, points[i].Y = t.point(points[i].X, points[i].Y)
+	}
+	return ELKEdgeSection{
+		Start:      points[0],
+		End:        points[len(points)-1],
+		BendPoints: points[1 : len(points)-1],
+	}
+}
@@ -0,0 +1,133 @@
+package d2elklayout
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestLayoutNatively(t *testing.T) {
+	t.Parallel()
+
+	for _, dir := range []Direction{Down, Up, Right, Left} {
+		dir := dir
+		t.Run(string(dir), func(t *testing.T) {
+			t.Parallel()
+
+			g := randomELKGraph(dir, 300)
+			err := layoutNatively(context.Background(), g)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, a := range g.Children {
+				for _, b := range g.Children[i+1:] {
+					if a.X < b.X+b.Width && b.X < a.X+a.Width && a.Y < b.Y+b.Height && b.Y < a.Y+a.Height {
+						t.Fatalf("%s and %s overlap", a.ID, b.ID)
+					}
+				}
+			}
+
+			nodes := make(map[string]*ELKNode)
+			for _, n := range g.Children {
+				nodes[n.ID] = n
+			}
+			for _, e := range g.Edges {
+				if len(e.Sections) != 1 {
+					t.Fatalf("%s: expected 1 section, got %d", e.ID, len(e.Sections))
+				}
+				s := e.Sections[0]
+				if !onBorder(nodes[e.Sources[0]], s.Start) {
+					t.Fatalf("%s: %v does not start on the border of %s", e.ID, s.Start, e.Sources[0])
+				}
+				if !onBorder(nodes[e.Targets[0]], s.End) {
+					t.Fatalf("%s: %v does not end on the border of %s", e.ID, s.End, e.Targets[0])
+				}
+			}
+
+			g2 := randomELKGraph(dir, 300)
+			err = layoutNatively(context.Background(), g2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(g, g2) {
+				t.Fatal("expected layouts of the same graph to be the same")
+			}
+		})
+	}
+}
+
+func TestCanLayoutNatively(t *testing.T) {
+	t.Parallel()
+
+	g := randomELKGraph(Down, 1000)
+	if !canLayoutNatively(g, &DefaultOpts) {
+		t.Fatal("expected graph to be laid out natively")
+	}
+	if canLayoutNatively(g, &ConfigurableOpts{}) {
+		t.Fatal("expected graph not to be laid out natively with 0 shapes")
+	}
+
+	g = randomELKGraph(Down, 999)
+	if canLayoutNatively(g, &DefaultOpts) {
+		t.Fatal("expected graph of too few shapes not to be laid out natively")
+	}
+
+	g = randomELKGraph(Down, 1000)
+	g.Children[0].Children = []*ELKNode{{ID: "child"}}
+	if canLayoutNatively(g, &DefaultOpts) {
+		t.Fatal("expected graph with containers not to be laid out natively")
+	}
+}
+
+func randomELKGraph(dir Direction, n int) *ELKGraph {
+	r := rand.New(rand.NewSource(1))
+	g := &ELKGraph{
+		LayoutOptions: &elkOpts{
+			EdgeNode:  edge_node_spacing,
+			Direction: dir,
+			ConfigurableOpts: ConfigurableOpts{
+				Algorithm:       "layered",
+				NodeSpacing:     DefaultOpts.NodeSpacing,
+				EdgeNodeSpacing: DefaultOpts.EdgeNodeSpacing,
+				SelfLoopSpacing: DefaultOpts.SelfLoopSpacing,
+			},
+		},
+	}
+	for i := 0; i < n; i++ {
+		g.Children = append(g.Children, &ELKNode{
+			ID:     fmt.Sprintf("n%d", i),
+			Width:  float64(50 + r.Intn(150)),
+			Height: float64(50 + r.Intn(50)),
+		})
+	}
+	for i := 0; i < n*3/2; i++ {
+		src := r.Intn(n)
+		dst := min(n-1, src+1+r.Intn(20))
+		if r.Intn(10) == 0 {
+			dst = max(0, src-r.Intn(20))
+		}
+		e := &ELKEdge{
+			ID:      fmt.Sprintf("e%d", i),
+			Sources: []string{g.Children[src].ID},
+			Targets: []string{g.Children[dst].ID},
+		}
+		if r.Intn(4) == 0 {
+			e.Labels = append(e.Labels, &ELKLabel{Text: "label", Width: 40, Height: 20})
+		}
+		g.Edges = append(g.Edges, e)
+	}
+	return g
+}
+
+func onBorder(n *ELKNode, p ELKPoint) bool {
+	const eps = 1e-6
+	inX := p.X >= n.X-eps && p.X <= n.X+n.Width+eps
+	inY := p.Y >= n.Y-eps && p.Y <= n.Y+n.Height+eps
+	onX := math.Abs(p.X-n.X) < eps || math.Abs(p.X-n.X-n.Width) < eps
+	onY := math.Abs(p.Y-n.Y) < eps || math.Abs(p.Y-n.Y-n.Height) < eps
+	return inX && inY && (onX || onY)
+}
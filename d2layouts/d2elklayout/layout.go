@@ -115,6 +115,9 @@ type ConfigurableOpts struct {
 	Padding         string `json:"elk.padding,omitempty"`
 	EdgeNodeSpacing int    `json:"spacing.edgeNodeBetweenLayers,omitempty"`
 	SelfLoopSpacing int    `json:"elk.spacing.nodeSelfLoop"`
+	// NativeMinNodes is the number of shapes from which graphs without containers are laid
+	// out natively rather than by ELK. 0 never lays out natively.
+	NativeMinNodes int `json:"d2.nativeMinNodes,omitempty"`
 }
 
 var DefaultOpts = ConfigurableOpts{
@@ -123,6 +126,7 @@ var DefaultOpts = ConfigurableOpts{
 	Padding:         "[top=50,left=50,bottom=50,right=50]",
 	EdgeNodeSpacing: 40.0,
 	SelfLoopSpacing: 50.0,
+	NativeMinNodes:  1000,
 }
 
 var port_spacing = 40.
@@ -139,6 +143,8 @@ type elkOpts struct {
 	ForceNodeModelOrder          bool      `json:"elk.layered.crossingMinimization.forceNodeModelOrder,omitempty"`
 	ConsiderModelOrder           string    `json:"elk.layered.considerModelOrder.strategy,omitempty"`
 	CycleBreakingStrategy        string    `json:"elk.layered.cycleBreaking.strategy,omitempty"`
+	NodePlacementStrategy        string    `json:"elk.layered.nodePlacement.strategy,omitempty"`
+	EdgeRouting                  string    `json:"elk.edgeRouting,omitempty"`
 
 	SelfLoopDistribution string `json:"elk.layered.edgeRouting.selfLoopDistribution,omitempty"`
 
@@ -162,20 +168,6 @@ func Layout(ctx context.Context, g *d2graph.Graph, opts *ConfigurableOpts) (err
 	}
 	defer xdefer.Errorf(&err, "failed to ELK layout")
 
-	vm := goja.New()
-
-	console := vm.NewObject()
-	if err := vm.Set("console", console); err != nil {
-		return err
-	}
-
-	if _, err := vm.RunString(elkJS); err != nil {
-		return err
-	}
-	if _, err := vm.RunString(setupJS); err != nil {
-		return err
-	}
-
 	elkGraph := &ELKGraph{
 		ID: "",
 		LayoutOptions: &elkOpts{
@@ -438,64 +430,29 @@ func Layout(ctx context.Context, g *d2graph.Graph, opts *ConfigurableOpts) (err
 		}
 	}
 
-	raw, err := json.Marshal(elkGraph)
-	if err != nil {
-		return err
-	}
-
-	loadScript := fmt.Sprintf(`var graph = %s`, raw)
-
-	if _, err := vm.RunString(loadScript); err != nil {
-		return err
-	}
-
-	val, err := vm.RunString(`elk.layout(graph)
-.then(s => s)
-.catch(err => err.message)
-`)
-
-	if err != nil {
-		return err
-	}
-
-	p := val.Export()
-	if err != nil {
-		return err
-	}
-
-	promise := p.(*goja.Promise)
-
-	for promise.State() == goja.PromiseStatePending {
-		if err := ctx.Err(); err != nil {
+	if canLayoutNatively(elkGraph, opts) {
+		err = layoutNatively(ctx, elkGraph)
+		if err != nil {
+			return err
+		}
+	} else {
+		var jsonBytes []byte
+		err = d2graph.WithinLayoutBudget(ctx, g, func(ctx context.Context) (err error) {
+			jsonBytes, err = runELK(ctx, elkGraph)
+			return err
+		}, func(ctx context.Context) (err error) {
+			cheapen(elkGraph)
+			jsonBytes, err = runELK(ctx, elkGraph)
+			return err
+		})
+		if err != nil {
 			return err
 		}
-		continue
-	}
-
-	if promise.State() == goja.PromiseStateRejected {
-		return errors.New("ELK: something went wrong")
-	}
-
-	result := promise.Result().Export()
-
-	var jsonOut map[string]interface{}
-	switch out := result.(type) {
-	case string:
-		return fmt.Errorf("ELK layout error: %s", out)
-	case map[string]interface{}:
-		jsonOut = out
-	default:
-		return fmt.Errorf("ELK unexpected return: %v", out)
-	}
-
-	jsonBytes, err := json.Marshal(jsonOut)
-	if err != nil {
-		return err
-	}
 
-	err = json.Unmarshal(jsonBytes, &elkGraph)
-	if err != nil {
-		return err
+		err = json.Unmarshal(jsonBytes, &elkGraph)
+		if err != nil {
+			return err
+		}
 	}
 
 	byID := make(map[string]*d2graph.Object)
@@ -525,22 +482,30 @@ func Layout(ctx context.Context, g *d2graph.Graph, opts *ConfigurableOpts) (err
 			parentY = byID[e.Container].TopLeft.Y
 		}
 
-		var points []*geo.Point
+		n := 0
 		for _, s := range e.Sections {
-			points = append(points, &geo.Point{
+			n += len(s.BendPoints) + 2
+		}
+		points := geo.NewPoints(n)
+		i := 0
+		for _, s := range e.Sections {
+			*points[i] = geo.Point{
 				X: parentX + s.Start.X,
 				Y: parentY + s.Start.Y,
-			})
+			}
+			i++
 			for _, bp := range s.BendPoints {
-				points = append(points, &geo.Point{
+				*points[i] = geo.Point{
 					X: parentX + bp.X,
 					Y: parentY + bp.Y,
-				})
+				}
+				i++
 			}
-			points = append(points, &geo.Point{
+			*points[i] = geo.Point{
 				X: parentX + s.End.X,
 				Y: parentY + s.End.Y,
-			})
+			}
+			i++
 		}
 		edge.Route = points
 	}
@@ -742,7 +707,7 @@ func deleteBends(g *d2graph.Graph) {
 				newStart = geo.NewPoint(end.X, start.Y)
 			}
 
-			endpointShape := shape.NewShape(d2target.DSL_SHAPE_TO_SHAPE_TYPE[strings.ToLower(endpoint.Shape.Value)], endpoint.Box)
+			endpointShape := shape.NewShapeWithPath(d2target.DSL_SHAPE_TO_SHAPE_TYPE[strings.ToLower(endpoint.Shape.Value)], endpoint.Box, endpoint.ShapePathData)
 			newStart = shape.TraceToShapeBorder(endpointShape, newStart, end)
 
 			// Check that the new segment doesn't collide with anything new
@@ -1157,3 +1122,94 @@ func positionLabelsIcons(obj *d2graph.Object) {
 		}
 	}
 }
+
+// runELK lays out elkGraph in a new VM and returns the laid out graph as JSON. It is
+// interrupted once ctx is done.
+func runELK(ctx context.Context, elkGraph *ELKGraph) ([]byte, error) {
+	vm := goja.New()
+	stop := context.AfterFunc(ctx, func() {
+		vm.Interrupt(ctx.Err())
+	})
+	defer stop()
+
+	console := vm.NewObject()
+	if err := vm.Set("console", console); err != nil {
+		return nil, err
+	}
+
+	if _, err := vm.RunString(elkJS); err != nil {
+		return nil, err
+	}
+	if _, err := vm.RunString(setupJS); err != nil {
+		return nil, err
+	}
+
+	raw, err := json.Marshal(elkGraph)
+	if err != nil {
+		return nil, err
+	}
+
+	loadScript := fmt.Sprintf(`var graph = %s`, raw)
+
+	if _, err := vm.RunString(loadScript); err != nil {
+		return nil, err
+	}
+
+	val, err := vm.RunString(`elk.layout(graph)
+.then(s => s)
+.catch(err => err.message)
+`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	promise := val.Export().(*goja.Promise)
+
+	for promise.State() == goja.PromiseStatePending {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		continue
+	}
+
+	if promise.State() == goja.PromiseStateRejected {
+		return nil, errors.New("ELK: something went wrong")
+	}
+
+	result := promise.Result().Export()
+
+	var jsonOut map[string]interface{}
+	switch out := result.(type) {
+	case string:
+		return nil, fmt.Errorf("ELK layout error: %s", out)
+	case map[string]interface{}:
+		jsonOut = out
+	default:
+		return nil, fmt.Errorf("ELK unexpected return: %v", out)
+	}
+
+	return json.Marshal(jsonOut)
+}
+
+// cheapen configures elkGraph to be laid out faster, with a single crossing minimization
+// pass, simple node placement and polyline edges.
+func cheapen(elkGraph *ELKGraph) {
+	cheapenOpts := func(opts *elkOpts) {
+		if opts == nil || opts.Thoroughness == 0 {
+			return
+		}
+		opts.Thoroughness = 1
+		opts.NodePlacementStrategy = "SIMPLE"
+		opts.EdgeRouting = "POLYLINE"
+	}
+	cheapenOpts(elkGraph.LayoutOptions)
+	var walk func([]*ELKNode)
+	walk = func(nodes []*ELKNode) {
+		for _, n := range nodes {
+			cheapenOpts(n.LayoutOptions)
+			walk(n.Children)
+		}
+	}
+	walk(elkGraph.Children)
+}
@@ -478,6 +478,7 @@ func InjectNested(container *d2graph.Object, nestedGraph *d2graph.Graph, isRoot
 	}
 	g.Objects = append(g.Objects, nestedGraph.Objects...)
 	g.Edges = append(g.Edges, nestedGraph.Edges...)
+	g.Warnings = append(g.Warnings, nestedGraph.Warnings...)
 
 	if isRoot {
 		if nestedGraph.Root.LabelPosition != nil {
@@ -0,0 +1,39 @@
+package d2sequence
+
+import (
+	"strconv"
+	"strings"
+
+	"oss.terrastruct.com/d2/d2graph"
+)
+
+// NumberMessages prefixes the label of every message of the sequence diagrams of g with its
+// number in its diagram, from 1 in the order the messages are laid out, like PlantUML's
+// autonumber. It runs before g is measured, as the numbers are part of the labels.
+func NumberMessages(g *d2graph.Graph) {
+	if g.Root.IsSequenceDiagram() {
+		numberMessages(g, g.Root)
+		return
+	}
+	for _, obj := range g.Objects {
+		if obj.IsSequenceDiagram() {
+			numberMessages(g, obj)
+		}
+	}
+}
+
+func numberMessages(g *d2graph.Graph, obj *d2graph.Object) {
+	n := 0
+	for _, edge := range g.Edges {
+		// Same messages as layoutSequenceDiagram.
+		if obj != g.Root && !(strings.HasPrefix(edge.Src.AbsID(), obj.AbsID()+".") && strings.HasPrefix(edge.Dst.AbsID(), obj.AbsID()+".")) {
+			continue
+		}
+		n++
+		if edge.Label.Value == "" {
+			edge.Label.Value = strconv.Itoa(n)
+		} else {
+			edge.Label.Value = strconv.Itoa(n) + ". " + edge.Label.Value
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package d2lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2target"
+)
+
+// LayoutCache caches the laid out and exported boards of a diagram by their layout inputs
+// so that recompiling it, e.g. on every change in watch mode, only lays out and exports
+// the boards that changed. A LayoutCache may be shared by compilations of the same
+// diagram, including concurrently.
+//
+// Boards are keyed on their measured objects and connections, without the source ranges
+// of their references, along with the layout engine, router, theme and font family that
+// compile them, so that editing one board does not invalidate the others. Entries that
+// the last compilation did not use are dropped at its end.
+type LayoutCache struct {
+	mu      sync.Mutex
+	entries map[string]*layoutCacheEntry
+	gen     int
+	hits    int
+	misses  int
+}
+
+// LayoutCacheStats are the statistics of a LayoutCache.
+type LayoutCacheStats struct {
+	Hits    int
+	Misses  int
+	Entries int
+}
+
+type layoutCacheEntry struct {
+	// diagram is the board exported by d2target.Marshal, without its children, so that
+	// every hit gets its own copy to modify.
+	diagram  []byte
+	warnings []d2graph.Warning
+	gen      int
+}
+
+func NewLayoutCache() *LayoutCache {
+	return &LayoutCache{
+		entries: make(map[string]*layoutCacheEntry),
+	}
+}
+
+func (c *LayoutCache) Stats() LayoutCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return LayoutCacheStats{
+		Hits:    c.hits,
+		Misses:  c.misses,
+		Entries: len(c.entries),
+	}
+}
+
+// begin starts a compilation.
+func (c *LayoutCache) begin() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+}
+
+// sweep drops the entries the compilation started by the last begin did not use.
+func (c *LayoutCache) sweep() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		if e.gen < c.gen {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// get returns a copy of the board cached by k with its warnings.
+func (c *LayoutCache) get(k string) (*d2target.Diagram, []d2graph.Warning, bool) {
+	c.mu.Lock()
+	e, ok := c.entries[k]
+	if ok {
+		c.hits++
+		e.gen = c.gen
+	} else {
+		c.misses++
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, nil, false
+	}
+	d, err := d2target.Unmarshal(e.diagram)
+	if err != nil {
+		return nil, nil, false
+	}
+	return d, append([]d2graph.Warning(nil), e.warnings...), true
+}
+
+// set caches d, which must not have children yet, and the warnings of g by k.
+func (c *LayoutCache) set(k string, g *d2graph.Graph, d *d2target.Diagram) error {
+	b, err := d2target.Marshal(d)
+	if err != nil {
+		return err
+	}
+	var warnings []d2graph.Warning
+	for _, w := range g.Warnings {
+		// The objects and connections of warnings are found again by their IDs in the graph of
+		// the compilation that hits.
+		switch {
+		case w.Object != nil:
+			w.AbsID = w.Object.AbsID()
+		case w.Edge != nil:
+			w.AbsID = w.Edge.AbsID()
+		}
+		w.Object = nil
+		w.Edge = nil
+		warnings = append(warnings, w)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[k] = &layoutCacheEntry{
+		diagram:  b,
+		warnings: warnings,
+		gen:      c.gen,
+	}
+	return nil
+}
+
+// layoutCacheKey returns the key of g once measured, for the layout inputs of opts.
+func layoutCacheKey(g *d2graph.Graph, compileOpts *CompileOptions, themeID int64) (string, error) {
+	b, err := d2graph.SerializeGraph(g)
+	if err != nil {
+		return "", err
+	}
+	var sg d2graph.SerializedGraph
+	err = json.Unmarshal(b, &sg)
+	if err != nil {
+		return "", err
+	}
+	// References only locate objects and connections in the source, which shifts with edits
+	// to the boards before them.
+	delete(sg.Root, "references")
+	for _, o := range sg.Objects {
+		delete(o, "references")
+	}
+	for _, e := range sg.Edges {
+		delete(e, "references")
+	}
+	sg.Warnings = nil
+
+	h := sha256.New()
+	err = json.NewEncoder(h).Encode(struct {
+		Graph        d2graph.SerializedGraph `json:"graph"`
+		Name         string                  `json:"name"`
+		IsFolderOnly bool                    `json:"isFolderOnly"`
+		Description  string                  `json:"description"`
+		Transition   interface{}             `json:"transition"`
+		Layout       *string                 `json:"layout"`
+		Router       *string                 `json:"router"`
+		ThemeID      int64                   `json:"themeID"`
+		FontFamily   interface{}             `json:"fontFamily"`
+		LayoutBudget int64                   `json:"layoutBudget"`
+	}{
+		Graph:        sg,
+		Name:         g.Name,
+		IsFolderOnly: g.IsFolderOnly,
+		Description:  g.Description,
+		Transition:   g.Transition,
+		Layout:       compileOpts.Layout,
+		Router:       compileOpts.Router,
+		ThemeID:      themeID,
+		FontFamily:   compileOpts.FontFamily,
+		LayoutBudget: int64(compileOpts.LayoutBudget),
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -3,9 +3,11 @@ package d2lib
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io/fs"
 	"os"
 	"strings"
+	"time"
 
 	"oss.terrastruct.com/d2/d2ast"
 	"oss.terrastruct.com/d2/d2compiler"
@@ -13,6 +15,7 @@ import (
 	"oss.terrastruct.com/d2/d2graph"
 	"oss.terrastruct.com/d2/d2layouts"
 	"oss.terrastruct.com/d2/d2layouts/d2dagrelayout"
+	"oss.terrastruct.com/d2/d2layouts/d2sequence"
 	"oss.terrastruct.com/d2/d2parser"
 	"oss.terrastruct.com/d2/d2renderers/d2fonts"
 	"oss.terrastruct.com/d2/d2renderers/d2svg"
@@ -23,14 +26,22 @@ import (
 )
 
 type CompileOptions struct {
-	UTF16Pos       bool
-	FS             fs.FS
-	MeasuredTexts  []*d2target.MText
-	Ruler          *textmeasure.Ruler
-	RouterResolver func(engine string) (d2graph.RouteEdges, error)
-	LayoutResolver func(engine string) (d2graph.LayoutGraph, error)
+	UTF16Pos        bool
+	FS              fs.FS
+	MeasuredTexts   []*d2target.MText
+	Ruler           *textmeasure.Ruler
+	RouterResolver  func(engine string) (d2graph.RouteEdges, error)
+	MutatorResolver func(engine string) (d2graph.MutateGraph, error)
+	LayoutResolver  func(engine string) (d2graph.LayoutGraph, error)
 
 	Layout *string
+	// Router, if set, is the name of the engine that routes the edges of every graph once
+	// Layout placed its objects, resolved with RouterResolver. Its routes replace those of
+	// Layout.
+	Router *string
+	// Mutator, if set, is the name of the plugin that modifies every board before it is
+	// measured and laid out, resolved with MutatorResolver. It is independent of Layout.
+	Mutator *string
 
 	// FontFamily controls the font family used for all texts that are not the following:
 	// - code
@@ -40,6 +51,132 @@ type CompileOptions struct {
 	FontFamily *d2fonts.FontFamily
 
 	InputPath string
+
+	// StableIDs assigns every shape and connection a StableID derived from its board and
+	// key so that external systems can track them across edits.
+	StableIDs bool
+
+	// SequenceNumbering prefixes the label of every message of sequence diagrams with its
+	// number in its diagram. Defaults to the sequence-numbering config.
+	SequenceNumbering *bool
+
+	// OnProgress, if set, is called as compilation passes each stage of each board, e.g. to
+	// drive a progress bar. It is called synchronously so it should return quickly.
+	OnProgress func(Progress)
+
+	// OnWarning, if set, is called with every warning the layout engine reports, e.g. that a
+	// container is too dense to be laid out well.
+	OnWarning func(Warning)
+
+	// LayoutBudget, if non zero, is the time the layout of each board may take before the
+	// bundled layout engines fall back to a cheaper configuration, with a warning, instead
+	// of running until ctx is done.
+	LayoutBudget time.Duration
+
+	// LayoutCache, if set, reuses the layouts of the boards that did not change since the
+	// last compilation with the same cache instead of laying them out again. The graphs of
+	// boards found in it are measured but not laid out.
+	LayoutCache *LayoutCache
+}
+
+type ProgressStage string
+
+const (
+	// ProgressParsed is reported once the input and its imports are parsed and compiled into
+	// a graph, before any board is laid out.
+	ProgressParsed ProgressStage = "parsed"
+	// ProgressMeasured is reported once the texts of a board are measured.
+	ProgressMeasured ProgressStage = "measured"
+	// ProgressLaidOut is reported once a board is laid out.
+	ProgressLaidOut ProgressStage = "laid out"
+	// ProgressExported is reported once a board is exported into its d2target.Diagram.
+	// Boards are exported before their children, so this is the last stage of a board but not
+	// of the compilation as a whole.
+	ProgressExported ProgressStage = "exported"
+)
+
+type Progress struct {
+	Stage ProgressStage
+	// BoardPath is the path of the board the stage was reached for, nil for the root and for
+	// ProgressParsed.
+	BoardPath []string
+}
+
+func (opts *CompileOptions) progress(stage ProgressStage, boardPath []string) {
+	if opts.OnProgress != nil {
+		opts.OnProgress(Progress{
+			Stage:     stage,
+			BoardPath: boardPath,
+		})
+	}
+}
+
+// Warning is a non fatal issue the layout engine reported about a board.
+type Warning struct {
+	Message string
+	// Range is the range of the first reference to the object or connection the warning is
+	// about. It is the zero range if the warning is about the board as a whole or the layout
+	// engine did not say what it is about.
+	Range d2ast.Range
+	// BoardPath is the path of the board, nil for the root.
+	BoardPath []string
+}
+
+func (w Warning) String() string {
+	var prefix string
+	if w.Range != (d2ast.Range{}) {
+		prefix = w.Range.String() + ": "
+	}
+	if len(w.BoardPath) > 0 {
+		prefix += strings.Join(w.BoardPath, ".") + ": "
+	}
+	return prefix + w.Message
+}
+
+// warn reports the warnings of g once it is laid out.
+func (opts *CompileOptions) warn(g *d2graph.Graph, boardPath []string) {
+	if opts.OnWarning == nil {
+		return
+	}
+	for _, w := range g.Warnings {
+		opts.OnWarning(Warning{
+			Message:   w.Message,
+			Range:     warningRange(g, w),
+			BoardPath: boardPath,
+		})
+	}
+}
+
+func warningRange(g *d2graph.Graph, w d2graph.Warning) d2ast.Range {
+	obj, e := w.Object, w.Edge
+	if obj == nil && e == nil && w.AbsID != "" {
+		// Plugins lose track of objects of nested graphs they were not passed.
+		for _, obj2 := range g.Objects {
+			if obj2.AbsID() == w.AbsID {
+				obj = obj2
+			}
+		}
+		for _, e2 := range g.Edges {
+			if e2.AbsID() == w.AbsID {
+				e = e2
+			}
+		}
+	}
+	switch {
+	case obj != nil:
+		for _, ref := range obj.References {
+			if ref.Key != nil {
+				return ref.Key.GetRange()
+			}
+		}
+	case e != nil:
+		for _, ref := range e.References {
+			if ref.Edge != nil {
+				return ref.Edge.GetRange()
+			}
+		}
+	}
+	return d2ast.Range{}
 }
 
 func Parse(ctx context.Context, input string, compileOpts *CompileOptions) (*d2ast.Map, error) {
@@ -68,28 +205,177 @@ func Compile(ctx context.Context, input string, compileOpts *CompileOptions, ren
 	if err != nil {
 		return nil, nil, err
 	}
+	compileOpts.progress(ProgressParsed, nil)
 
 	applyConfigs(config, compileOpts, renderOpts)
 	applyDefaults(compileOpts, renderOpts)
+	if *compileOpts.SequenceNumbering {
+		numberSequenceMessages(g)
+	}
+	removeLatexMacros(g)
 
-	d, err := compile(ctx, g, compileOpts, renderOpts)
+	compileOpts.LayoutCache.begin()
+	d, err := compile(ctx, g, nil, compileOpts, renderOpts)
+	if err == nil {
+		compileOpts.LayoutCache.sweep()
+	}
 	if d != nil {
 		d.Config = config
+		if compileOpts.StableIDs {
+			d.AssignStableIDs(nil)
+		}
 	}
 	return d, g, err
 }
 
-func compile(ctx context.Context, g *d2graph.Graph, compileOpts *CompileOptions, renderOpts *d2svg.RenderOpts) (*d2target.Diagram, error) {
+// CompileGraph measures, lays out and exports a graph that was built programmatically, e.g.
+// with d2graph.NewGraph, Object.EnsureChild and Object.Connect, rather than compiled from
+// source. The returned diagram can be rendered like that of Compile. g is modified in place
+// with the dimensions and positions computed by layout.
+func CompileGraph(ctx context.Context, g *d2graph.Graph, compileOpts *CompileOptions, renderOpts *d2svg.RenderOpts) (*d2target.Diagram, error) {
+	if compileOpts == nil {
+		compileOpts = &CompileOptions{}
+	}
+	if renderOpts == nil {
+		renderOpts = &d2svg.RenderOpts{}
+	}
+	if g == nil || g.Root == nil {
+		return nil, errors.New("graph has no root, construct it with d2graph.NewGraph")
+	}
+	applyDefaults(compileOpts, renderOpts)
+	if *compileOpts.SequenceNumbering {
+		numberSequenceMessages(g)
+	}
+	removeLatexMacros(g)
+
+	compileOpts.LayoutCache.begin()
+	d, err := compile(ctx, g, nil, compileOpts, renderOpts)
+	if err == nil {
+		compileOpts.LayoutCache.sweep()
+	}
+	if d != nil && compileOpts.StableIDs {
+		d.AssignStableIDs(nil)
+	}
+	return d, err
+}
+
+// numberSequenceMessages numbers the messages of the sequence diagrams of g and its boards.
+func numberSequenceMessages(g *d2graph.Graph) {
+	d2sequence.NumberMessages(g)
+	for _, b := range g.Layers {
+		numberSequenceMessages(b)
+	}
+	for _, b := range g.Scenarios {
+		numberSequenceMessages(b)
+	}
+	for _, b := range g.Steps {
+		numberSequenceMessages(b)
+	}
+}
+
+// removeLatexMacros removes the latex blocks of g and its boards that only define macros,
+// which d2compiler leaves empty, so that they are not laid out as empty shapes. Both Compile
+// and CompileGraph remove them, as graphs passed to CompileGraph may be compiled from source
+// too, e.g. by d2oracle.
+func removeLatexMacros(g *d2graph.Graph) {
+	objects := make([]*d2graph.Object, 0, len(g.Objects))
+	for _, obj := range g.Objects {
+		if obj.Language == "latex" && obj.Label.Value == "" {
+			obj.Parent.RemoveChild(obj)
+			continue
+		}
+		objects = append(objects, obj)
+	}
+	g.Objects = objects
+	for _, b := range g.Layers {
+		removeLatexMacros(b)
+	}
+	for _, b := range g.Scenarios {
+		removeLatexMacros(b)
+	}
+	for _, b := range g.Steps {
+		removeLatexMacros(b)
+	}
+}
+
+// compile lays out and exports g and its boards. ctx is checked between every stage so that
+// cancelling it aborts the compilation even when the layout engine does not check it itself.
+func compile(ctx context.Context, g *d2graph.Graph, boardPath []string, compileOpts *CompileOptions, renderOpts *d2svg.RenderOpts) (*d2target.Diagram, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	err := g.ApplyTheme(*renderOpts.ThemeID)
 	if err != nil {
 		return nil, err
 	}
 
+	mutator, err := getMutator(compileOpts)
+	if err != nil {
+		return nil, err
+	}
+	if mutator != nil {
+		err = mutator(ctx, g)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	d, err := layoutBoard(ctx, g, boardPath, compileOpts, *renderOpts.ThemeID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, l := range g.Layers {
+		ld, err := compile(ctx, l, append(append([]string{}, boardPath...), "layers", l.Name), compileOpts, renderOpts)
+		if err != nil {
+			return nil, err
+		}
+		d.Layers = append(d.Layers, ld)
+	}
+	for _, l := range g.Scenarios {
+		ld, err := compile(ctx, l, append(append([]string{}, boardPath...), "scenarios", l.Name), compileOpts, renderOpts)
+		if err != nil {
+			return nil, err
+		}
+		d.Scenarios = append(d.Scenarios, ld)
+	}
+	for _, l := range g.Steps {
+		ld, err := compile(ctx, l, append(append([]string{}, boardPath...), "steps", l.Name), compileOpts, renderOpts)
+		if err != nil {
+			return nil, err
+		}
+		d.Steps = append(d.Steps, ld)
+	}
+	return d, nil
+}
+
+// layoutBoard measures, lays out and exports g without its boards, or returns the board
+// cached by compileOpts.LayoutCache for the same layout inputs.
+func layoutBoard(ctx context.Context, g *d2graph.Graph, boardPath []string, compileOpts *CompileOptions, themeID int64) (*d2target.Diagram, error) {
+	var key string
 	if len(g.Objects) > 0 {
 		err := g.SetDimensions(compileOpts.MeasuredTexts, compileOpts.Ruler, compileOpts.FontFamily)
 		if err != nil {
 			return nil, err
 		}
+		compileOpts.progress(ProgressMeasured, boardPath)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if compileOpts.LayoutCache != nil {
+			key, err = layoutCacheKey(g, compileOpts, themeID)
+			if err != nil {
+				return nil, err
+			}
+			if d, warnings, ok := compileOpts.LayoutCache.get(key); ok {
+				g.Warnings = warnings
+				compileOpts.progress(ProgressLaidOut, boardPath)
+				compileOpts.warn(g, boardPath)
+				compileOpts.progress(ProgressExported, boardPath)
+				return d, nil
+			}
+		}
 
 		coreLayout, err := getLayout(compileOpts)
 		if err != nil {
@@ -99,40 +385,37 @@ func compile(ctx context.Context, g *d2graph.Graph, compileOpts *CompileOptions,
 		if err != nil {
 			return nil, err
 		}
+		if compileOpts.Router != nil {
+			coreLayout = routeAfter(coreLayout, edgeRouter)
+		}
 
+		layoutCtx := ctx
+		if compileOpts.LayoutBudget > 0 {
+			layoutCtx = d2graph.WithLayoutBudget(ctx, compileOpts.LayoutBudget)
+		}
 		graphInfo := d2layouts.NestedGraphInfo(g.Root)
-		err = d2layouts.LayoutNested(ctx, g, graphInfo, coreLayout, edgeRouter)
+		err = d2layouts.LayoutNested(layoutCtx, g, graphInfo, coreLayout, edgeRouter)
 		if err != nil {
 			return nil, err
 		}
+		compileOpts.progress(ProgressLaidOut, boardPath)
+		compileOpts.warn(g, boardPath)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 	}
 
 	d, err := d2exporter.Export(ctx, g, compileOpts.FontFamily)
 	if err != nil {
 		return nil, err
 	}
-
-	for _, l := range g.Layers {
-		ld, err := compile(ctx, l, compileOpts, renderOpts)
+	if key != "" {
+		err = compileOpts.LayoutCache.set(key, g, d)
 		if err != nil {
 			return nil, err
 		}
-		d.Layers = append(d.Layers, ld)
-	}
-	for _, l := range g.Scenarios {
-		ld, err := compile(ctx, l, compileOpts, renderOpts)
-		if err != nil {
-			return nil, err
-		}
-		d.Scenarios = append(d.Scenarios, ld)
-	}
-	for _, l := range g.Steps {
-		ld, err := compile(ctx, l, compileOpts, renderOpts)
-		if err != nil {
-			return nil, err
-		}
-		d.Steps = append(d.Steps, ld)
 	}
+	compileOpts.progress(ProgressExported, boardPath)
 	return d, nil
 }
 
@@ -150,6 +433,19 @@ func getLayout(opts *CompileOptions) (d2graph.LayoutGraph, error) {
 }
 
 func getEdgeRouter(opts *CompileOptions) (d2graph.RouteEdges, error) {
+	if opts.Router != nil {
+		if opts.RouterResolver == nil {
+			return nil, errors.New("a router requires a router resolver")
+		}
+		router, err := opts.RouterResolver(*opts.Router)
+		if err != nil {
+			return nil, err
+		}
+		if router == nil {
+			return nil, fmt.Errorf(`"%s" does not route edges`, *opts.Router)
+		}
+		return router, nil
+	}
 	if opts.Layout != nil && opts.RouterResolver != nil {
 		router, err := opts.RouterResolver(*opts.Layout)
 		if err != nil {
@@ -162,6 +458,37 @@ func getEdgeRouter(opts *CompileOptions) (d2graph.RouteEdges, error) {
 	return d2layouts.DefaultRouter, nil
 }
 
+// routeAfter returns a layout that runs layout and then reroutes every edge with router.
+func routeAfter(layout d2graph.LayoutGraph, router d2graph.RouteEdges) d2graph.LayoutGraph {
+	return func(ctx context.Context, g *d2graph.Graph) error {
+		err := layout(ctx, g)
+		if err != nil {
+			return err
+		}
+		if len(g.Edges) == 0 {
+			return nil
+		}
+		return router(ctx, g, g.Edges)
+	}
+}
+
+func getMutator(opts *CompileOptions) (d2graph.MutateGraph, error) {
+	if opts.Mutator == nil {
+		return nil, nil
+	}
+	if opts.MutatorResolver == nil {
+		return nil, errors.New("a mutator requires a mutator resolver")
+	}
+	mutator, err := opts.MutatorResolver(*opts.Mutator)
+	if err != nil {
+		return nil, err
+	}
+	if mutator == nil {
+		return nil, fmt.Errorf(`"%s" does not mutate graphs`, *opts.Mutator)
+	}
+	return mutator, nil
+}
+
 // applyConfigs applies the configs read from D2 and applies it to passed in opts
 // It will only write to opt fields that are nil, as passed-in opts have precedence
 func applyConfigs(config *d2target.Config, compileOpts *CompileOptions, renderOpts *d2svg.RenderOpts) {
@@ -172,6 +499,9 @@ func applyConfigs(config *d2target.Config, compileOpts *CompileOptions, renderOp
 	if compileOpts.Layout == nil {
 		compileOpts.Layout = config.LayoutEngine
 	}
+	if compileOpts.SequenceNumbering == nil {
+		compileOpts.SequenceNumbering = config.SequenceNumbering
+	}
 
 	if renderOpts.ThemeID == nil {
 		renderOpts.ThemeID = config.ThemeID
@@ -196,6 +526,9 @@ func applyDefaults(compileOpts *CompileOptions, renderOpts *d2svg.RenderOpts) {
 	if compileOpts.Layout == nil {
 		compileOpts.Layout = go2.Pointer("dagre")
 	}
+	if compileOpts.SequenceNumbering == nil {
+		compileOpts.SequenceNumbering = go2.Pointer(false)
+	}
 
 	if renderOpts.ThemeID == nil {
 		renderOpts.ThemeID = &d2themescatalog.NeutralDefault.ID
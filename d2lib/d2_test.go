@@ -0,0 +1,416 @@
+package d2lib_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	tassert "github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/util-go/assert"
+	"oss.terrastruct.com/util-go/go2"
+
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2dagrelayout"
+	"oss.terrastruct.com/d2/d2lib"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/geo"
+	"oss.terrastruct.com/d2/lib/log"
+	"oss.terrastruct.com/d2/lib/textmeasure"
+)
+
+func layoutResolver(engine string) (d2graph.LayoutGraph, error) {
+	return d2dagrelayout.DefaultLayout, nil
+}
+
+func TestProgress(t *testing.T) {
+	t.Parallel()
+
+	ruler, err := textmeasure.NewRuler()
+	assert.Success(t, err)
+
+	var got []string
+	_, _, err = d2lib.Compile(context.Background(), `a -> b
+layers: {
+  x: {
+    c
+  }
+}
+`, &d2lib.CompileOptions{
+		Ruler:          ruler,
+		LayoutResolver: layoutResolver,
+		Layout:         go2.Pointer("dagre"),
+		OnProgress: func(p d2lib.Progress) {
+			got = append(got, strings.TrimSpace(string(p.Stage)+" "+strings.Join(p.BoardPath, ".")))
+		},
+	}, nil)
+	assert.Success(t, err)
+	tassert.Equal(t, []string{
+		"parsed",
+		"measured",
+		"laid out",
+		"exported",
+		"measured layers.x",
+		"laid out layers.x",
+		"exported layers.x",
+	}, got)
+}
+
+func TestProgressCancel(t *testing.T) {
+	t.Parallel()
+
+	ruler, err := textmeasure.NewRuler()
+	assert.Success(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var got []d2lib.ProgressStage
+	_, _, err = d2lib.Compile(ctx, `a -> b
+layers: {
+  x: {
+    c
+  }
+}
+`, &d2lib.CompileOptions{
+		Ruler:          ruler,
+		LayoutResolver: layoutResolver,
+		Layout:         go2.Pointer("dagre"),
+		OnProgress: func(p d2lib.Progress) {
+			got = append(got, p.Stage)
+			if p.Stage == d2lib.ProgressMeasured {
+				cancel()
+			}
+		},
+	}, nil)
+	tassert.True(t, errors.Is(err, context.Canceled))
+	tassert.Equal(t, []d2lib.ProgressStage{d2lib.ProgressParsed, d2lib.ProgressMeasured}, got)
+}
+
+func TestCompileGraph(t *testing.T) {
+	t.Parallel()
+
+	ruler, err := textmeasure.NewRuler()
+	assert.Success(t, err)
+
+	g := d2graph.NewGraph()
+	a := g.Root.EnsureChild([]string{"a"})
+	a.Attributes.Label.Value = "hello"
+	g.Root.EnsureChild([]string{"a", "b"})
+	_, err = g.Root.Connect([]string{"a", "b"}, []string{"c"}, false, true, "to c")
+	assert.Success(t, err)
+
+	d, err := d2lib.CompileGraph(context.Background(), g, &d2lib.CompileOptions{
+		Ruler:          ruler,
+		LayoutResolver: layoutResolver,
+		Layout:         go2.Pointer("dagre"),
+	}, nil)
+	assert.Success(t, err)
+
+	var ids []string
+	for _, s := range d.Shapes {
+		ids = append(ids, s.ID)
+		tassert.Greater(t, s.Width, 0)
+	}
+	tassert.Equal(t, []string{"a", "a.b", "c"}, ids)
+	tassert.Equal(t, "hello", d.Shapes[0].Label)
+	tassert.Equal(t, 1, len(d.Connections))
+	tassert.Equal(t, "a.b", d.Connections[0].Src)
+	tassert.Equal(t, "to c", d.Connections[0].Label)
+}
+
+func TestLayoutCache(t *testing.T) {
+	t.Parallel()
+
+	ruler, err := textmeasure.NewRuler()
+	assert.Success(t, err)
+
+	var laidOut []string
+	cache := d2lib.NewLayoutCache()
+	compile := func(input string) *d2target.Diagram {
+		laidOut = nil
+		d, _, err := d2lib.Compile(log.WithTB(context.Background(), t, nil), input, &d2lib.CompileOptions{
+			Ruler: ruler,
+			LayoutResolver: func(engine string) (d2graph.LayoutGraph, error) {
+				return func(ctx context.Context, g *d2graph.Graph) error {
+					laidOut = append(laidOut, g.Objects[0].ID)
+					return d2dagrelayout.DefaultLayout(ctx, g)
+				}, nil
+			},
+			Layout:      go2.Pointer("dagre"),
+			LayoutCache: cache,
+		}, nil)
+		assert.Success(t, err)
+		d.Config = nil
+		return d
+	}
+
+	d1 := compile(`a -> b
+layers: {
+  x: {
+    c -> d
+  }
+}
+`)
+	tassert.Equal(t, []string{"a", "c"}, laidOut)
+
+	// Only the edited layer is laid out again, even though the root moved down a line.
+	d2 := compile(`
+a -> b
+layers: {
+  x: {
+    c -> e
+  }
+}
+`)
+	tassert.Equal(t, []string{"c"}, laidOut)
+	stats := cache.Stats()
+	tassert.Equal(t, 1, stats.Hits)
+	tassert.Equal(t, 3, stats.Misses)
+	// The layout of the old layer is dropped.
+	tassert.Equal(t, 2, stats.Entries)
+	tassert.Equal(t, "e", d2.Layers[0].Shapes[1].ID)
+
+	d1.Layers = nil
+	d2.Layers = nil
+	b1, err := d1.Bytes()
+	assert.Success(t, err)
+	b2, err := d2.Bytes()
+	assert.Success(t, err)
+	tassert.Equal(t, string(b1), string(b2))
+}
+
+func TestMutatorResolver(t *testing.T) {
+	t.Parallel()
+
+	ruler, err := textmeasure.NewRuler()
+	assert.Success(t, err)
+
+	opts := &d2lib.CompileOptions{
+		Ruler:          ruler,
+		LayoutResolver: layoutResolver,
+		Layout:         go2.Pointer("dagre"),
+		Mutator:        go2.Pointer("legend"),
+		MutatorResolver: func(engine string) (d2graph.MutateGraph, error) {
+			if engine != "legend" {
+				return nil, nil
+			}
+			return func(ctx context.Context, g *d2graph.Graph) error {
+				legend := g.Root.EnsureChild([]string{"legend"})
+				legend.Attributes.Label.Value = "Legend"
+				return nil
+			}, nil
+		},
+	}
+	d, _, err := d2lib.Compile(log.WithTB(context.Background(), t, nil), `a
+layers: {
+  x: {
+    b
+  }
+}
+`, opts, nil)
+	assert.Success(t, err)
+
+	ids := func(d *d2target.Diagram) (ids []string) {
+		for _, s := range d.Shapes {
+			ids = append(ids, s.ID)
+		}
+		return ids
+	}
+	tassert.Equal(t, []string{"a", "legend"}, ids(d))
+	tassert.Equal(t, []string{"b", "legend"}, ids(d.Layers[0]))
+	tassert.Greater(t, d.Shapes[1].Width, 0)
+
+	opts.Mutator = nil
+	d, _, err = d2lib.Compile(context.Background(), `a`, opts, nil)
+	assert.Success(t, err)
+	tassert.Equal(t, []string{"a"}, ids(d))
+
+	opts.Mutator = go2.Pointer("dagre")
+	_, _, err = d2lib.Compile(context.Background(), `a`, opts, nil)
+	tassert.EqualError(t, err, `"dagre" does not mutate graphs`)
+}
+
+func TestRouter(t *testing.T) {
+	t.Parallel()
+
+	ruler, err := textmeasure.NewRuler()
+	assert.Success(t, err)
+
+	var routed []string
+	opts := &d2lib.CompileOptions{
+		Ruler:          ruler,
+		LayoutResolver: layoutResolver,
+		Layout:         go2.Pointer("dagre"),
+		Router:         go2.Pointer("straight"),
+		RouterResolver: func(engine string) (d2graph.RouteEdges, error) {
+			if engine != "straight" {
+				return nil, nil
+			}
+			return func(ctx context.Context, g *d2graph.Graph, edges []*d2graph.Edge) error {
+				for _, e := range edges {
+					routed = append(routed, e.AbsID())
+					e.Route = []*geo.Point{e.Src.Center(), e.Dst.Center()}
+				}
+				return nil
+			}, nil
+		},
+	}
+	d, _, err := d2lib.Compile(log.WithTB(context.Background(), t, nil), `a -> b
+c: {
+  d -> e
+}
+a -> c.d
+`, opts, nil)
+	assert.Success(t, err)
+
+	tassert.ElementsMatch(t, []string{"(a -> b)[0]", "c.(d -> e)[0]", "(a -> c.d)[0]"}, routed)
+	for _, c := range d.Connections {
+		tassert.Len(t, c.Route, 2)
+	}
+
+	opts.Router = go2.Pointer("dagre")
+	_, _, err = d2lib.Compile(context.Background(), `a -> b`, opts, nil)
+	tassert.EqualError(t, err, `"dagre" does not route edges`)
+}
+
+func TestWarnings(t *testing.T) {
+	t.Parallel()
+
+	ruler, err := textmeasure.NewRuler()
+	assert.Success(t, err)
+
+	var warnings []string
+	opts := &d2lib.CompileOptions{
+		Ruler:     ruler,
+		InputPath: "index.d2",
+		Layout:    go2.Pointer("dense"),
+		LayoutResolver: func(engine string) (d2graph.LayoutGraph, error) {
+			return func(ctx context.Context, g *d2graph.Graph) error {
+				for _, obj := range g.Objects {
+					if len(obj.ChildrenArray) > 1 {
+						g.WarnObjectf(obj, "container %s too dense", obj.AbsID())
+					}
+				}
+				for _, e := range g.Edges {
+					g.WarnEdgef(e, "connection %s too long", e.AbsID())
+				}
+				if len(g.Objects) == 1 {
+					g.Warnf("only one shape")
+				}
+				return d2dagrelayout.DefaultLayout(ctx, g)
+			}, nil
+		},
+		OnWarning: func(w d2lib.Warning) {
+			warnings = append(warnings, w.String())
+		},
+	}
+	_, _, err = d2lib.Compile(context.Background(), `c: {
+  a
+  b
+}
+x -> c
+layers: {
+  l: {
+    y
+  }
+}
+`, opts, nil)
+	assert.Success(t, err)
+
+	tassert.Equal(t, []string{
+		"index.d2:1:1: container c too dense",
+		"index.d2:5:1: connection (x -> c)[0] too long",
+		"layers.l: only one shape",
+	}, warnings)
+}
+
+func TestSequenceNumbering(t *testing.T) {
+	t.Parallel()
+
+	ruler, err := textmeasure.NewRuler()
+	assert.Success(t, err)
+
+	input := `vars: {
+  d2-config: {
+    sequence-numbering: true
+  }
+}
+x -> y: not a message
+s: {
+  shape: sequence_diagram
+  a -> b: hello
+  b -> a
+  g: {
+    a -> b: in a group
+  }
+}
+layers: {
+  l: {
+    shape: sequence_diagram
+    c -> d: again
+  }
+}
+`
+	labels := func(d *d2target.Diagram) []string {
+		var labels []string
+		for _, c := range d.Connections {
+			if !strings.Contains(c.Dst, "-lifeline-end-") {
+				labels = append(labels, c.Label)
+			}
+		}
+		return labels
+	}
+
+	opts := &d2lib.CompileOptions{
+		Ruler:          ruler,
+		LayoutResolver: layoutResolver,
+		Layout:         go2.Pointer("dagre"),
+	}
+	d, _, err := d2lib.Compile(log.WithTB(context.Background(), t, nil), input, opts, nil)
+	assert.Success(t, err)
+	tassert.Equal(t, []string{"not a message", "1. hello", "2", "3. in a group"}, labels(d))
+	tassert.Equal(t, []string{"1. again"}, labels(d.Layers[0]))
+
+	// Options override the config.
+	opts = &d2lib.CompileOptions{
+		Ruler:             ruler,
+		LayoutResolver:    layoutResolver,
+		Layout:            go2.Pointer("dagre"),
+		SequenceNumbering: go2.Pointer(false),
+	}
+	d, _, err = d2lib.Compile(log.WithTB(context.Background(), t, nil), input, opts, nil)
+	assert.Success(t, err)
+	tassert.Equal(t, []string{"not a message", "hello", "", "in a group"}, labels(d))
+}
+
+func TestLatexMacros(t *testing.T) {
+	t.Parallel()
+
+	ruler, err := textmeasure.NewRuler()
+	assert.Success(t, err)
+
+	input := `defs: |latex \\newcommand{\\RR}{\\mathbb{R}}|
+a: |latex x \\in \\RR|
+`
+	opts := &d2lib.CompileOptions{
+		Ruler:          ruler,
+		LayoutResolver: layoutResolver,
+		Layout:         go2.Pointer("dagre"),
+	}
+	d, _, err := d2lib.Compile(log.WithTB(context.Background(), t, nil), input, opts, nil)
+	assert.Success(t, err)
+	// Blocks that only define macros are not drawn.
+	tassert.Equal(t, 1, len(d.Shapes))
+	tassert.Equal(t, "a", d.Shapes[0].ID)
+
+	// Nor are they by CompileGraph.
+	g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+	assert.Success(t, err)
+	d, err = d2lib.CompileGraph(log.WithTB(context.Background(), t, nil), g, opts, nil)
+	assert.Success(t, err)
+	tassert.Equal(t, 1, len(d.Shapes))
+	tassert.Equal(t, "a", d.Shapes[0].ID)
+}
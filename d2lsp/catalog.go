@@ -0,0 +1,279 @@
+package d2lsp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"oss.terrastruct.com/util-go/go2"
+
+	"oss.terrastruct.com/d2/d2ir"
+	"oss.terrastruct.com/d2/d2renderers/d2fonts"
+	"oss.terrastruct.com/d2/d2renderers/d2svg"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/d2themes"
+	"oss.terrastruct.com/d2/d2themes/d2themescatalog"
+)
+
+var shapeDocs = map[string]string{
+	d2target.ShapeRectangle:       "The default shape.",
+	d2target.ShapeSquare:          "A rectangle with equal width and height.",
+	d2target.ShapePage:            "A page with a folded corner.",
+	d2target.ShapeParallelogram:   "Commonly used for inputs and outputs in flowcharts.",
+	d2target.ShapeDocument:        "A page with a wavy bottom edge.",
+	d2target.ShapeCylinder:        "Commonly used for databases.",
+	d2target.ShapeQueue:           "A horizontal cylinder, commonly used for message queues.",
+	d2target.ShapePackage:         "A box with a tab.",
+	d2target.ShapeStep:            "A chevron, commonly used for steps of a process.",
+	d2target.ShapeCallout:         "A speech bubble.",
+	d2target.ShapeStoredData:      "Commonly used for stored data in flowcharts.",
+	d2target.ShapePerson:          "A person, commonly used for actors.",
+	d2target.ShapeDiamond:         "Commonly used for decisions in flowcharts.",
+	d2target.ShapeOval:            "An ellipse.",
+	d2target.ShapeCircle:          "An ellipse with equal width and height.",
+	d2target.ShapeHexagon:         "A hexagon.",
+	d2target.ShapeCloud:           "Commonly used for cloud services.",
+	d2target.ShapeCustom:          "A shape drawn with the SVG path of shape-path.",
+	d2target.ShapeText:            "Standalone text without a border, e.g. Markdown.",
+	d2target.ShapeCode:            "A block of code. Set the language with a block string, e.g. |go ...|.",
+	d2target.ShapeClass:           "A UML class. Its keys are fields and methods.",
+	d2target.ShapeSQLTable:        "A SQL table. Its keys are columns.",
+	d2target.ShapeImage:           "A standalone image. Requires icon.",
+	d2target.ShapeSequenceDiagram: "Lays out its children as a sequence diagram.",
+	d2target.ShapeHierarchy:       "Lays out its children as a hierarchy.",
+}
+
+// shapePreviews holds the data URIs of SVG previews of shapes, rendered once on first use.
+var shapePreviews struct {
+	once sync.Once
+	uris map[string]string
+}
+
+// shapePreview returns the data URI of an SVG preview of shape, or "" if it has none.
+func shapePreview(shape string) string {
+	shapePreviews.once.Do(func() {
+		shapePreviews.uris = make(map[string]string)
+		for _, s := range d2target.Shapes {
+			switch s {
+			case d2target.ShapeText, d2target.ShapeCode, d2target.ShapeImage, d2target.ShapeCustom:
+				// Nothing to see without content.
+				continue
+			}
+			svg, err := renderShape(s)
+			if err != nil {
+				continue
+			}
+			shapePreviews.uris[s] = svgDataURI(svg)
+		}
+	})
+	return shapePreviews.uris[shape]
+}
+
+// renderShape renders an empty shape of the default theme without laying it out.
+func renderShape(shape string) ([]byte, error) {
+	s := d2target.BaseShape()
+	s.ID = shape
+	s.Type = shape
+	s.Width = 80
+	s.Height = 50
+	switch shape {
+	case d2target.ShapeSquare, d2target.ShapeCircle, d2target.ShapePerson:
+		s.Width = 50
+	}
+	s.Fill = "B6"
+	s.Stroke = "B1"
+	if shape == d2target.ShapeClass || shape == d2target.ShapeSQLTable {
+		s.Fill = "N1"
+	}
+	d := &d2target.Diagram{
+		FontFamily: go2.Pointer(d2fonts.SourceSansPro),
+		Shapes:     []d2target.Shape{*s},
+		Root:       *d2target.BaseShape(),
+	}
+	return d2svg.Render(d, &d2svg.RenderOpts{
+		Pad:     go2.Pointer(int64(4)),
+		ThemeID: &d2themescatalog.NeutralDefault.ID,
+	})
+}
+
+// themeSwatch returns the data URI of an SVG of the colors of t.
+func themeSwatch(t d2themes.Theme) string {
+	colors := []string{
+		t.Colors.B1, t.Colors.B2, t.Colors.B3, t.Colors.B4, t.Colors.B5, t.Colors.B6,
+		t.Colors.AA2, t.Colors.AA4, t.Colors.AA5, t.Colors.AB4, t.Colors.AB5,
+		t.Colors.Neutrals.N1, t.Colors.Neutrals.N7,
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20">`, len(colors)*20)
+	for i, c := range colors {
+		fmt.Fprintf(&b, `<rect x="%d" width="20" height="20" fill="%s"/>`, i*20, c)
+	}
+	b.WriteString(`</svg>`)
+	return svgDataURI([]byte(b.String()))
+}
+
+func svgDataURI(svg []byte) string {
+	return "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString(svg)
+}
+
+func markdown(s string) *MarkupContent {
+	return &MarkupContent{
+		Kind:  "markdown",
+		Value: s,
+	}
+}
+
+func shapeCompletions() []CompletionItem {
+	items := make([]CompletionItem, 0, len(d2target.Shapes))
+	for _, s := range d2target.Shapes {
+		doc := shapeDocs[s]
+		if uri := shapePreview(s); uri != "" {
+			doc += fmt.Sprintf("\n\n![%s](%s)", s, uri)
+		}
+		items = append(items, CompletionItem{
+			Label:         s,
+			Kind:          CompletionKindEnum,
+			Documentation: markdown(doc),
+		})
+	}
+	return items
+}
+
+func themeCompletions() []CompletionItem {
+	var items []CompletionItem
+	for _, themes := range [][]d2themes.Theme{d2themescatalog.LightCatalog, d2themescatalog.DarkCatalog} {
+		for _, t := range themes {
+			kind := "Light"
+			if t.IsDark() {
+				kind = "Dark"
+			}
+			items = append(items, CompletionItem{
+				Label:         strconv.FormatInt(t.ID, 10),
+				Kind:          CompletionKindValue,
+				Detail:        t.Name,
+				Documentation: markdown(fmt.Sprintf("%s theme **%s**\n\n![%s](%s)", kind, t.Name, t.Name, themeSwatch(t))),
+			})
+		}
+	}
+	return items
+}
+
+// iconExts are the extensions of the local files offered as icons.
+var iconExts = map[string]struct{}{
+	".svg":  {},
+	".png":  {},
+	".jpg":  {},
+	".jpeg": {},
+	".gif":  {},
+	".webp": {},
+}
+
+// iconCompletions returns the hosted icon catalog, the icons already used by the open
+// documents and the images next to doc.
+func (s *server) iconCompletions(doc *document) []CompletionItem {
+	items := []CompletionItem{{
+		Label:         iconsURL,
+		Kind:          CompletionKindValue,
+		Detail:        "icon catalog",
+		Documentation: markdown("Browse the hosted icons at " + iconsURL),
+	}}
+	seen := map[string]struct{}{iconsURL: {}}
+	add := func(icon, detail string, preview bool) {
+		if _, ok := seen[icon]; ok {
+			return
+		}
+		seen[icon] = struct{}{}
+		item := CompletionItem{
+			Label:  icon,
+			Kind:   CompletionKindValue,
+			Detail: detail,
+		}
+		if preview {
+			item.Documentation = markdown(fmt.Sprintf("![icon](%s)", icon))
+		}
+		items = append(items, item)
+	}
+
+	var used []string
+	for _, d := range s.docs {
+		if d.g == nil {
+			continue
+		}
+		for _, obj := range d.g.Objects {
+			if obj.Icon != nil && obj.Icon.Scheme != "" {
+				used = append(used, obj.Icon.String())
+			}
+		}
+	}
+	sort.Strings(used)
+	for _, icon := range used {
+		add(icon, "used icon", true)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(doc.path))
+	if err == nil {
+		for _, e := range entries {
+			if _, ok := iconExts[strings.ToLower(filepath.Ext(e.Name()))]; ok && !e.IsDir() {
+				add(e.Name(), "local image", false)
+			}
+		}
+	}
+	return items
+}
+
+// classCompletions returns the names of the classes of the board that path, a key path from
+// the root, is within.
+func classCompletions(doc *document, path []string) []CompletionItem {
+	if doc.ir == nil {
+		return nil
+	}
+	boards := boardChain(doc.ir, path)
+	if boards == nil {
+		return nil
+	}
+	classes := boards[len(boards)-1].GetField("classes")
+	if classes == nil || classes.Map() == nil {
+		return nil
+	}
+	var items []CompletionItem
+	for _, f := range classes.Map().Fields {
+		items = append(items, CompletionItem{
+			Label:         f.Name,
+			Kind:          CompletionKindValue,
+			Detail:        "class",
+			Documentation: classDocs(f),
+		})
+	}
+	return items
+}
+
+// classDocs returns the keys a class sets.
+func classDocs(f *d2ir.Field) *MarkupContent {
+	if f.Map() == nil {
+		return nil
+	}
+	lines := classLines(f.Map(), nil)
+	if len(lines) == 0 {
+		return nil
+	}
+	return markdown("```d2\n" + strings.Join(lines, "\n") + "\n```")
+}
+
+func classLines(m *d2ir.Map, prefix []string) []string {
+	var lines []string
+	for _, f := range m.Fields {
+		path := append(append([]string{}, prefix...), f.Name)
+		if p := f.Primary(); p != nil {
+			lines = append(lines, fmt.Sprintf("%s: %s", strings.Join(path, "."), p.Value.ScalarString()))
+		}
+		if f.Map() != nil {
+			lines = append(lines, classLines(f.Map(), path)...)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,58 @@
+package d2lsp
+
+import (
+	"oss.terrastruct.com/d2/d2ast"
+)
+
+// codeActions returns a quick fix for every fix of the errors that intersect r. Fixes that
+// edit other files, such as imports, are left out.
+func codeActions(doc *document, r Range) []CodeAction {
+	actions := []CodeAction{}
+	for _, e := range doc.errs {
+		if !inDoc(doc, e.Range) || !intersects(toRange(e.Range), r) {
+			continue
+		}
+		diag := toDiagnostic(doc.path, e)
+		preferred := true
+	fixes:
+		for _, fix := range e.Fixes {
+			edits := make([]TextEdit, 0, len(fix.Edits))
+			for _, edit := range fix.Edits {
+				if !inDoc(doc, edit.Range) {
+					continue fixes
+				}
+				edits = append(edits, TextEdit{
+					Range:   toRange(edit.Range),
+					NewText: edit.NewText,
+				})
+			}
+			actions = append(actions, CodeAction{
+				Title:       fix.Title,
+				Kind:        "quickfix",
+				Diagnostics: []Diagnostic{diag},
+				IsPreferred: preferred,
+				Edit: WorkspaceEdit{
+					Changes: map[string][]TextEdit{
+						doc.uri: edits,
+					},
+				},
+			})
+			preferred = false
+		}
+	}
+	return actions
+}
+
+func inDoc(doc *document, r d2ast.Range) bool {
+	return r.Path == "" || r.Path == doc.path
+}
+
+// intersects reports whether a and b overlap, inclusive of their ends so that an empty
+// range at the cursor intersects the ranges it touches.
+func intersects(a, b Range) bool {
+	return !before(a.End, b.Start) && !before(b.End, a.Start)
+}
+
+func before(a, b Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Character < b.Character)
+}
@@ -0,0 +1,263 @@
+package d2lsp
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/color"
+)
+
+// edgeMarker stands in for an edge within a key path, e.g. the path of
+// (a -> b)[0].style.stroke is [edgeMarker, style, stroke].
+const edgeMarker = ""
+
+// completions returns the completions at pos. Completion is driven by the text of the
+// current statement up to pos, along with the keys of the maps enclosing pos, so that it
+// works while the document does not parse.
+func (s *server) completions(doc *document, pos Position) []CompletionItem {
+	prefix := linePrefix(doc.text, pos)
+	enclosing := enclosingPath(doc.text, doc.ast, pos)
+	if typed, ok := substitutionPrefix(prefix); ok {
+		return varCompletions(doc, enclosing, typed)
+	}
+	if i := strings.LastIndexAny(prefix, "{;"); i >= 0 {
+		prefix = prefix[i+1:]
+	}
+	prefix = strings.TrimLeft(prefix, " \t")
+
+	if i := strings.Index(prefix, ":"); i >= 0 {
+		path := append(enclosing, splitKey(prefix[:i])...)
+		return s.valueCompletions(doc, path)
+	}
+	path := splitKey(prefix)
+	// The last element is the key being typed.
+	return keyCompletions(append(enclosing, path[:len(path)-1]...))
+}
+
+func keyCompletions(parent []string) []CompletionItem {
+	var keywords []string
+	if len(parent) == 0 {
+		keywords = objectKeywords(true)
+	} else {
+		switch parent[len(parent)-1] {
+		case "style":
+			keywords = sortedKeys(d2graph.StyleKeywords)
+		case "source-arrowhead", "target-arrowhead":
+			keywords = []string{"label", "shape", "style"}
+		case "vars":
+			keywords = []string{"d2-config"}
+		case "d2-config":
+			keywords = configKeywords
+		case "label", "icon":
+			keywords = []string{"near"}
+		case "classes", "layers", "scenarios", "steps", "theme-overrides", "dark-theme-overrides":
+			// Everything within is named by the user.
+			return nil
+		case edgeMarker:
+			keywords = []string{"label", "style", "source-arrowhead", "target-arrowhead", "class", "tooltip", "link", "icon", "animate"}
+		default:
+			isBoard := false
+			if len(parent) >= 2 {
+				_, isBoard = d2graph.BoardKeywords[parent[len(parent)-2]]
+			}
+			keywords = objectKeywords(isBoard)
+		}
+	}
+
+	items := make([]CompletionItem, 0, len(keywords))
+	for _, k := range keywords {
+		item := CompletionItem{
+			Label: k,
+			Kind:  CompletionKindKeyword,
+		}
+		if docs, ok := keywordDocs[k]; ok {
+			item.Documentation = markdown(docs)
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// objectKeywords returns the keywords valid within an object, or a board if isBoard.
+func objectKeywords(isBoard bool) []string {
+	var keywords []string
+	for k := range d2graph.SimpleReservedKeywords {
+		if k == "vars" && !isBoard {
+			continue
+		}
+		keywords = append(keywords, k)
+	}
+	keywords = append(keywords, "style")
+	if isBoard {
+		keywords = append(keywords, "classes", "description", "transition")
+		keywords = append(keywords, sortedKeys(d2graph.BoardKeywords)...)
+	}
+	sort.Strings(keywords)
+	return keywords
+}
+
+func (s *server) valueCompletions(doc *document, path []string) []CompletionItem {
+	if len(path) == 0 {
+		return nil
+	}
+	key := path[len(path)-1]
+	var parent string
+	if len(path) >= 2 {
+		parent = path[len(path)-2]
+	}
+
+	values := func(kind CompletionItemKind, vs ...string) []CompletionItem {
+		items := make([]CompletionItem, 0, len(vs))
+		for _, v := range vs {
+			items = append(items, CompletionItem{
+				Label: v,
+				Kind:  kind,
+			})
+		}
+		return items
+	}
+
+	switch {
+	case key == "shape":
+		if parent == "source-arrowhead" || parent == "target-arrowhead" {
+			return values(CompletionKindEnum, sortedKeys(d2target.Arrowheads)...)
+		}
+		return shapeCompletions()
+	case key == "direction":
+		return values(CompletionKindEnum, directions...)
+	case key == "near":
+		if parent == "label" || parent == "icon" {
+			return values(CompletionKindConstant, d2graph.LabelPositionsArray...)
+		}
+		items := values(CompletionKindConstant, d2graph.NearConstantsArray...)
+		if doc.g != nil {
+			for _, obj := range doc.g.Objects {
+				items = append(items, CompletionItem{
+					Label:  obj.AbsID(),
+					Kind:   CompletionKindValue,
+					Detail: "object",
+				})
+			}
+		}
+		return items
+	case key == "fill-pattern":
+		return values(CompletionKindEnum, d2graph.FillPatterns...)
+	case key == "animate":
+		return values(CompletionKindEnum, d2target.AnimationEffects...)
+	case key == "text-transform":
+		return values(CompletionKindEnum, textTransforms...)
+	case key == "font":
+		return values(CompletionKindEnum, "mono")
+	case key == "layout-engine":
+		return values(CompletionKindEnum, layoutEngines...)
+	case key == "icon":
+		return s.iconCompletions(doc)
+	case key == "theme-id" || key == "dark-theme-id":
+		return themeCompletions()
+	case key == "class":
+		return classCompletions(doc, path[:len(path)-1])
+	}
+	if _, ok := booleanKeywords[key]; ok {
+		return values(CompletionKindValue, "true", "false")
+	}
+	if _, ok := colorKeywords[key]; ok {
+		items := values(CompletionKindColor, themeColorCodes...)
+		for i := range items {
+			items[i].Detail = "theme color"
+		}
+		return append(items, values(CompletionKindColor, color.NamedColors...)...)
+	}
+	return nil
+}
+
+// linePrefix returns the text of the line of pos up to pos.
+func linePrefix(text string, pos Position) string {
+	lines := strings.Split(text, "\n")
+	if pos.Line < 0 || pos.Line >= len(lines) {
+		return ""
+	}
+	line := lines[pos.Line]
+	// Characters are counted in UTF-16 code units.
+	n := 0
+	for i, r := range line {
+		if n >= pos.Character {
+			return line[:i]
+		}
+		n += len(utf16.Encode([]rune{r}))
+	}
+	return line
+}
+
+// splitKey splits a possibly incomplete key, e.g. a.style. or (a -> b)[0].sty, into its path.
+func splitKey(s string) []string {
+	s = strings.TrimSpace(s)
+	var path []string
+	if i := strings.LastIndex(s, ")"); i >= 0 {
+		s = s[i+1:]
+		if strings.HasPrefix(s, "[") {
+			if j := strings.Index(s, "]"); j >= 0 {
+				s = s[j+1:]
+			}
+		}
+		s = strings.TrimPrefix(s, ".")
+		path = append(path, edgeMarker)
+		if s == "" {
+			return append(path, "")
+		}
+	} else if strings.Contains(s, "->") || strings.Contains(s, "<-") || strings.Contains(s, "--") {
+		// The label of an edge.
+		return []string{edgeMarker, ""}
+	}
+	for _, p := range strings.Split(s, ".") {
+		path = append(path, strings.ToLower(strings.Trim(strings.TrimSpace(p), `"'`)))
+	}
+	return path
+}
+
+// enclosingPath returns the key path of the maps within m, parsed from text, that pos is
+// inside of.
+func enclosingPath(text string, m *d2ast.Map, pos Position) []string {
+	if m == nil {
+		return nil
+	}
+	for _, n := range m.Nodes {
+		mk := n.MapKey
+		if mk == nil || mk.Value.Map == nil {
+			continue
+		}
+		r := mk.Value.Map.Range
+		if !contains(r, pos) || (pos.Line == r.Start.Line && pos.Character == r.Start.Column) {
+			continue
+		}
+		// Right after the closing brace is outside, but a map left unterminated while typing
+		// ends where the cursor is.
+		closed := r.End.Byte > 0 && r.End.Byte <= len(text) && text[r.End.Byte-1] == '}'
+		if closed && pos.Line == r.End.Line && pos.Character == r.End.Column {
+			continue
+		}
+		var path []string
+		if len(mk.Edges) > 0 {
+			path = append(path, edgeMarker)
+			if mk.EdgeKey != nil {
+				path = append(path, d2graph.Key(mk.EdgeKey)...)
+			}
+		} else if mk.Key != nil {
+			path = d2graph.Key(mk.Key)
+		}
+		return append(path, enclosingPath(text, mk.Value.Map, pos)...)
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,278 @@
+package d2lsp
+
+import (
+	"net/url"
+	"path/filepath"
+	"sort"
+
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2ir"
+)
+
+// symbol is what an identifier refers to: the fields of an object, or of a class, across
+// every board that holds a copy of it.
+type symbol struct {
+	fields []*d2ir.Field
+	// class is set if the symbol is a class, whose usages are the values of class keywords
+	// rather than keys.
+	class string
+}
+
+// definition returns where the object or class at pos is first declared, which may be
+// within an imported file.
+func (s *server) definition(doc *document, pos Position) []Location {
+	sym := symbolAt(doc, pos)
+	if sym == nil {
+		return nil
+	}
+	for _, r := range sym.declarations() {
+		return []Location{s.location(r)}
+	}
+	return nil
+}
+
+// references returns every usage of the object or class at pos, across imports and
+// globs.
+func (s *server) references(doc *document, pos Position, includeDeclaration bool) []Location {
+	sym := symbolAt(doc, pos)
+	if sym == nil {
+		return nil
+	}
+	var decl d2ast.Range
+	if !includeDeclaration {
+		for _, r := range sym.declarations() {
+			decl = r
+			break
+		}
+	}
+
+	seen := make(map[d2ast.Range]struct{})
+	var ranges []d2ast.Range
+	add := func(r d2ast.Range) {
+		if _, ok := seen[r]; ok || (!includeDeclaration && r == decl) {
+			return
+		}
+		seen[r] = struct{}{}
+		ranges = append(ranges, r)
+	}
+	for _, f := range sym.fields {
+		for _, ref := range f.References {
+			add(ref.String.GetRange())
+		}
+		for _, r := range globReferences(f) {
+			add(r)
+		}
+	}
+	if sym.class != "" {
+		walkFields(doc.ir, func(f *d2ir.Field) {
+			for _, u := range classUsages(f) {
+				if u.ScalarString() == sym.class && sym.definedBy(classField(f, sym.class)) {
+					add(u.GetRange())
+				}
+			}
+		})
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].Path != ranges[j].Path {
+			return ranges[i].Path < ranges[j].Path
+		}
+		return ranges[i].Before(ranges[j])
+	})
+	locs := make([]Location, 0, len(ranges))
+	for _, r := range ranges {
+		locs = append(locs, s.location(r))
+	}
+	return locs
+}
+
+// declarations returns the ranges of the symbol's references in the order they were
+// compiled, excluding those due to globs.
+func (sym *symbol) declarations() []d2ast.Range {
+	var ranges []d2ast.Range
+	for _, f := range sym.fields {
+		for _, ref := range f.References {
+			if !ref.DueToGlob_ && !ref.DueToLazyGlob_ {
+				ranges = append(ranges, ref.String.GetRange())
+			}
+		}
+	}
+	return ranges
+}
+
+// definedBy reports whether f is one of the fields of sym, as copied into a board.
+func (sym *symbol) definedBy(f *d2ir.Field) bool {
+	if f == nil {
+		return false
+	}
+	for _, sf := range sym.fields {
+		if sf == f || sameReferences(sf, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// symbolAt returns the symbol of the key or class value at pos.
+func symbolAt(doc *document, pos Position) *symbol {
+	if doc.ir == nil {
+		return nil
+	}
+
+	if sb := keyAt(doc.ast, pos); sb != nil {
+		r := sb.Unbox().GetRange()
+		sym := &symbol{}
+		walkFields(doc.ir, func(f *d2ir.Field) {
+			for _, ref := range f.References {
+				if ref.String.GetRange() == r {
+					sym.fields = append(sym.fields, f)
+					if isClass(f) {
+						sym.class = f.Name
+					}
+					return
+				}
+			}
+		})
+		if len(sym.fields) == 0 {
+			return nil
+		}
+		return sym
+	}
+
+	// The value of a class keyword.
+	var sym *symbol
+	walkFields(doc.ir, func(f *d2ir.Field) {
+		if sym != nil {
+			return
+		}
+		for _, u := range classUsages(f) {
+			r := u.GetRange()
+			if (r.Path == "" || r.Path == doc.path) && contains(r, pos) {
+				if cf := classField(f, u.ScalarString()); cf != nil {
+					sym = &symbol{
+						fields: []*d2ir.Field{cf},
+						class:  cf.Name,
+					}
+				}
+				return
+			}
+		}
+	})
+	if sym == nil {
+		return nil
+	}
+	// Boards that inherit the class hold copies of it.
+	walkFields(doc.ir, func(f *d2ir.Field) {
+		if f != sym.fields[0] && isClass(f) && sameReferences(f, sym.fields[0]) {
+			sym.fields = append(sym.fields, f)
+		}
+	})
+	return sym
+}
+
+// walkFields calls fn with every field within m, including those of edges and boards.
+func walkFields(m *d2ir.Map, fn func(*d2ir.Field)) {
+	if m == nil {
+		return
+	}
+	for _, f := range m.Fields {
+		fn(f)
+		walkFields(f.Map(), fn)
+	}
+	for _, e := range m.Edges {
+		walkFields(e.Map(), fn)
+	}
+}
+
+// globReferences returns the ranges of the glob patterns that matched f. Patterns are not
+// references of the fields they match, but the fields they create within them are, e.g.
+// the reference to style in *.style.fill shows that * matched its parent.
+func globReferences(f *d2ir.Field) []d2ast.Range {
+	if f.Map() == nil {
+		return nil
+	}
+	var ranges []d2ast.Range
+	for _, child := range f.Map().Fields {
+		for _, ref := range child.References {
+			i := ref.KeyPathIndex()
+			if i <= 0 {
+				continue
+			}
+			us, ok := ref.KeyPath.Path[i-1].Unbox().(*d2ast.UnquotedString)
+			if ok && us.Pattern != nil {
+				ranges = append(ranges, us.GetRange())
+			}
+		}
+	}
+	return ranges
+}
+
+// isClass reports whether f is a class definition.
+func isClass(f *d2ir.Field) bool {
+	classes := d2ir.ParentField(f)
+	if classes == nil || classes.Name != "classes" {
+		return false
+	}
+	board := d2ir.ParentField(classes)
+	return board != nil && (board.Root() || d2ir.NodeBoardKind(board) != "")
+}
+
+// classField returns the definition of the class name applied by the class keyword f.
+func classField(f *d2ir.Field, name string) *d2ir.Field {
+	board := d2ir.ParentBoard(f)
+	if board == nil || board.Map() == nil {
+		return nil
+	}
+	return board.Map().GetField("classes", name)
+}
+
+// classUsages returns the class names that f applies if it is a class keyword, including
+// those overridden later.
+func classUsages(f *d2ir.Field) []d2ast.Scalar {
+	if f.Name != "class" {
+		return nil
+	}
+	var usages []d2ast.Scalar
+	for _, ref := range f.References {
+		if !ref.Primary() {
+			continue
+		}
+		v := ref.Context_.Key.Value
+		if v.Array != nil {
+			for _, n := range v.Array.Nodes {
+				if s, ok := n.Unbox().(d2ast.Scalar); ok {
+					usages = append(usages, s)
+				}
+			}
+		} else if s, ok := v.Unbox().(d2ast.Scalar); ok && s != nil {
+			usages = append(usages, s)
+		}
+	}
+	return usages
+}
+
+// sameReferences reports whether a and b are copies of the same field, such as an object
+// inherited by a scenario.
+func sameReferences(a, b *d2ir.Field) bool {
+	if len(a.References) == 0 || len(b.References) == 0 {
+		return false
+	}
+	return a.References[0].String.GetRange() == b.References[0].String.GetRange()
+}
+
+// location converts r into a location, using the URI of the open document at r's path if
+// there is one.
+func (s *server) location(r d2ast.Range) Location {
+	for _, doc := range s.docs {
+		if doc.path == r.Path {
+			return Location{URI: doc.uri, Range: toRange(r)}
+		}
+	}
+	p := filepath.ToSlash(r.Path)
+	if filepath.VolumeName(r.Path) != "" {
+		// c:/x on Windows.
+		p = "/" + p
+	}
+	u := url.URL{Scheme: "file", Path: p}
+	return Location{URI: u.String(), Range: toRange(r)}
+}
@@ -0,0 +1,94 @@
+package d2lsp
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"oss.terrastruct.com/d2/d2format"
+	"oss.terrastruct.com/d2/d2parser"
+)
+
+// formatting returns the edit that formats the whole document as d2 fmt would. Documents
+// that do not parse are left as is.
+func formatting(doc *document) []TextEdit {
+	ast, err := d2parser.Parse(doc.path, strings.NewReader(doc.text), nil)
+	if err != nil {
+		return []TextEdit{}
+	}
+	opts, err := d2format.ConfigOptions(ast)
+	if err != nil {
+		return []TextEdit{}
+	}
+	formatted := d2format.FormatWithOptions(ast, opts)
+	if formatted == doc.text {
+		return []TextEdit{}
+	}
+	return []TextEdit{{
+		Range: Range{
+			Start: Position{},
+			End:   positionAt(doc.text, len(doc.text)),
+		},
+		NewText: formatted,
+	}}
+}
+
+// rangeFormatting returns the edit that formats the keys within r.
+func rangeFormatting(doc *document, r Range) []TextEdit {
+	ast, err := d2parser.Parse(doc.path, strings.NewReader(doc.text), nil)
+	if err != nil {
+		return []TextEdit{}
+	}
+	opts, err := d2format.ConfigOptions(ast)
+	if err != nil {
+		return []TextEdit{}
+	}
+	e, ok, err := d2format.FormatRange(doc.path, doc.text, offsetAt(doc.text, r.Start), offsetAt(doc.text, r.End), opts)
+	if err != nil || !ok || doc.text[e.Start:e.End] == e.NewText {
+		return []TextEdit{}
+	}
+	return []TextEdit{{
+		Range: Range{
+			Start: positionAt(doc.text, e.Start),
+			End:   positionAt(doc.text, e.End),
+		},
+		NewText: e.NewText,
+	}}
+}
+
+// offsetAt returns the byte offset of pos within text.
+func offsetAt(text string, pos Position) int {
+	line := 0
+	offset := 0
+	for line < pos.Line {
+		i := strings.IndexByte(text[offset:], '\n')
+		if i == -1 {
+			return len(text)
+		}
+		offset += i + 1
+		line++
+	}
+	char := 0
+	for offset < len(text) && char < pos.Character {
+		r, n := utf8.DecodeRuneInString(text[offset:])
+		if r == '\n' {
+			break
+		}
+		char += int(utf16Len(r))
+		offset += n
+	}
+	return offset
+}
+
+// positionAt returns the position of the byte offset within text.
+func positionAt(text string, offset int) Position {
+	var pos Position
+	for _, r := range text[:offset] {
+		if r == '\n' {
+			pos.Line++
+			pos.Character = 0
+		} else {
+			pos.Character += int(utf16Len(r))
+		}
+	}
+	return pos
+}
@@ -0,0 +1,106 @@
+package d2lsp
+
+import (
+	"fmt"
+	"strings"
+
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2graph"
+)
+
+// hover returns the documentation of the keyword at pos, or a summary of the object at pos.
+func hover(doc *document, pos Position) *Hover {
+	sb := keyAt(doc.ast, pos)
+	if sb == nil {
+		return nil
+	}
+	r := toRange(sb.Unbox().GetRange())
+	s := sb.Unbox().ScalarString()
+	if docs, ok := keywordDocs[s]; ok && sb.UnquotedString != nil {
+		return &Hover{
+			Contents: MarkupContent{
+				Kind:  "markdown",
+				Value: fmt.Sprintf("**%s**\n\n%s", s, docs),
+			},
+			Range: &r,
+		}
+	}
+
+	if doc.g == nil {
+		return nil
+	}
+	obj := objectAt(doc.g, sb)
+	if obj == nil {
+		return nil
+	}
+	var lines []string
+	lines = append(lines, fmt.Sprintf("```d2\n%s\n```", obj.AbsID()))
+	if obj.Label.Value != obj.IDVal {
+		lines = append(lines, fmt.Sprintf("label: %s", obj.Label.Value))
+	}
+	if obj.Shape.Value != "" {
+		lines = append(lines, fmt.Sprintf("shape: %s", obj.Shape.Value))
+	}
+	if n := len(obj.ChildrenArray); n > 0 {
+		lines = append(lines, fmt.Sprintf("children: %d", n))
+	}
+	return &Hover{
+		Contents: MarkupContent{
+			Kind:  "markdown",
+			Value: strings.Join(lines, "\n\n"),
+		},
+		Range: &r,
+	}
+}
+
+// keyAt returns the element of a key or edge path within m at pos.
+func keyAt(m *d2ast.Map, pos Position) *d2ast.StringBox {
+	if m == nil {
+		return nil
+	}
+	for _, n := range m.Nodes {
+		mk := n.MapKey
+		if mk == nil || !contains(mk.Range, pos) {
+			continue
+		}
+		var paths []*d2ast.KeyPath
+		paths = append(paths, mk.Key, mk.EdgeKey)
+		for _, e := range mk.Edges {
+			paths = append(paths, e.Src, e.Dst)
+		}
+		for _, kp := range paths {
+			if kp == nil {
+				continue
+			}
+			for _, sb := range kp.Path {
+				if contains(sb.Unbox().GetRange(), pos) {
+					return sb
+				}
+			}
+		}
+		if mk.Value.Map != nil {
+			return keyAt(mk.Value.Map, pos)
+		}
+	}
+	return nil
+}
+
+// objectAt returns the object of g or its boards that sb references.
+func objectAt(g *d2graph.Graph, sb *d2ast.StringBox) *d2graph.Object {
+	r := sb.Unbox().GetRange()
+	for _, obj := range g.Objects {
+		for _, ref := range obj.References {
+			if ref.Key.Path[ref.KeyPathIndex].Unbox().GetRange() == r {
+				return obj
+			}
+		}
+	}
+	for _, boards := range [][]*d2graph.Graph{g.Layers, g.Scenarios, g.Steps} {
+		for _, b := range boards {
+			if obj := objectAt(b, sb); obj != nil {
+				return obj
+			}
+		}
+	}
+	return nil
+}
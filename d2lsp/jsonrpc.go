@@ -0,0 +1,92 @@
+package d2lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// conn reads and writes JSON-RPC messages framed with Content-Length headers as the base
+// protocol of LSP requires.
+type conn struct {
+	r *bufio.Reader
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{
+		r: bufio.NewReader(r),
+		w: w,
+	}
+}
+
+// read returns the next message. It returns io.EOF once the input is closed between
+// messages.
+func (c *conn) read() (*request, error) {
+	tp := textproto.NewReader(c.r)
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(header.Get("Content-Length")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length: %w", err)
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(c.r, b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read content: %w", err)
+	}
+
+	var req request
+	err = json.Unmarshal(b, &req)
+	if err != nil {
+		return nil, &responseError{
+			Code:    codeParseError,
+			Message: err.Error(),
+		}
+	}
+	return &req, nil
+}
+
+func (c *conn) write(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err = fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(b), b)
+	return err
+}
+
+func (c *conn) reply(id *json.RawMessage, result any, rerr *responseError) error {
+	resp := map[string]any{
+		"jsonrpc": "2.0",
+		"id":      id,
+	}
+	if rerr != nil {
+		resp["error"] = rerr
+	} else {
+		resp["result"] = result
+	}
+	return c.write(resp)
+}
+
+func (c *conn) notify(method string, params any) error {
+	return c.write(notification{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  params,
+	})
+}
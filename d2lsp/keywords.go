@@ -0,0 +1,130 @@
+package d2lsp
+
+// keywordDocs documents every keyword for completion and hover.
+var keywordDocs = map[string]string{
+	"label":          "The text displayed for an object or connection. Defaults to the key.",
+	"desc":           "A description of the object, not rendered.",
+	"shape":          "The shape of an object, or of an arrowhead within source-arrowhead and target-arrowhead.",
+	"shape-path":     "The outline of a custom shape: SVG path data, or the path of an SVG file whose paths are used.",
+	"icon":           "A URL or path to an image displayed with the object.",
+	"constraint":     "SQL table column constraints such as primary_key, foreign_key and unique.",
+	"tooltip":        "Text displayed when hovering over the object in SVG output.",
+	"link":           "A URL or board the object links to when clicked.",
+	"animate":        "Fades the object or connection in or out after its board is shown, e.g. fade-in 2 for the second animation of the board, in SVG output.",
+	"near":           "Positions the object at a constant like top-center, or next to another object.",
+	"width":          "The width of the object in pixels.",
+	"height":         "The height of the object in pixels.",
+	"direction":      "The direction the layout flows in: up, down, left or right.",
+	"top":            "Pins the object's top edge, relative to its container, in pixels.",
+	"left":           "Pins the object's left edge, relative to its container, in pixels.",
+	"grid-rows":      "Turns the container into a grid with this many rows.",
+	"grid-columns":   "Turns the container into a grid with this many columns.",
+	"grid-gap":       "The gap between cells of a grid, in pixels.",
+	"vertical-gap":   "The gap between rows of a grid, in pixels.",
+	"horizontal-gap": "The gap between columns of a grid, in pixels.",
+	"class":          "Applies one or more classes defined under classes.",
+	"classes":        "Defines reusable sets of attributes, applied with class.",
+	"description":    "A description of the board, the speaker notes of its slide in PPTX exports.",
+	"transition":     "How animated SVGs transition to the board: its interval, the duration of its fade and its easing.",
+	"vars":           "Defines variables, substituted with ${name}. vars.d2-config configures the diagram.",
+	"d2-config":      "Diagram configuration such as theme-id, layout-engine and pad.",
+
+	"style":            "Holds the style attributes of an object or connection.",
+	"source-arrowhead": "Configures the arrowhead at the source end of a connection.",
+	"target-arrowhead": "Configures the arrowhead at the target end of a connection.",
+
+	"layers":    "Boards that start from a blank diagram.",
+	"scenarios": "Boards that inherit everything from their parent board.",
+	"steps":     "Boards that each inherit from the previous step.",
+
+	"opacity":        "Opacity from 0 to 1.",
+	"stroke":         "Stroke color.",
+	"fill":           "Fill color.",
+	"fill-pattern":   "Pattern drawn over the fill: none, dots, lines, grain or paper.",
+	"stroke-width":   "Stroke width from 1 to 15.",
+	"stroke-dash":    "Dash length from 0, for a solid line, to 10.",
+	"border-radius":  "Corner radius from 0 to 20.",
+	"font":           "The font of the label. Only mono is supported.",
+	"font-size":      "Font size from 8 to 100.",
+	"font-color":     "Font color.",
+	"bold":           "Whether the label is bold.",
+	"italic":         "Whether the label is italic.",
+	"underline":      "Whether the label is underlined.",
+	"text-transform": "Transforms the label: none, uppercase, lowercase or capitalize.",
+	"shadow":         "Whether the shape casts a shadow.",
+	"multiple":       "Whether the shape is drawn as a stack of multiple.",
+	"double-border":  "Whether the shape has a double border. Only for rectangles and ovals.",
+	"3d":             "Whether the shape is drawn in 3D. Only for rectangles and squares.",
+	"animated":       "Whether the connection is animated.",
+	"filled":         "Whether the arrowhead is filled.",
+
+	"theme-id":             "The ID of the theme. See `d2 themes`.",
+	"dark-theme-id":        "The ID of the theme used in dark mode. See `d2 themes`.",
+	"pad":                  "Padding around the diagram in pixels.",
+	"center":               "Whether to center the diagram within its viewbox.",
+	"sketch":               "Whether to render the diagram as if sketched by hand.",
+	"layout-engine":        "The layout engine: dagre, elk or tala.",
+	"theme-overrides":      "Overrides colors of the theme, e.g. B1: \"#2E7D32\".",
+	"dark-theme-overrides": "Overrides colors of the dark theme.",
+	"latex-display":        "Whether LaTeX is typeset in display mode.",
+	"latex-numbering":      "Whether LaTeX equations are numbered.",
+	"sequence-numbering":   "Whether the messages of sequence diagrams are numbered.",
+	"pdf-header":           "Stamped at the top of every page of PDF exports, e.g. \"{title}|{board}|{date}\".",
+	"pdf-footer":           "Stamped at the bottom of every page of PDF exports, e.g. \"Page {page} of {pages}\".",
+	"fmt":                  "Configures d2 fmt: indent-width, tabs, quotes, braces and sort-keys.",
+}
+
+var configKeywords = []string{
+	"theme-id",
+	"dark-theme-id",
+	"pad",
+	"center",
+	"sketch",
+	"layout-engine",
+	"theme-overrides",
+	"dark-theme-overrides",
+	"latex-display",
+	"latex-numbering",
+	"sequence-numbering",
+	"pdf-header",
+	"pdf-footer",
+	"fmt",
+}
+
+var booleanKeywords = map[string]struct{}{
+	"bold":               {},
+	"italic":             {},
+	"underline":          {},
+	"shadow":             {},
+	"multiple":           {},
+	"double-border":      {},
+	"3d":                 {},
+	"animated":           {},
+	"filled":             {},
+	"center":             {},
+	"sketch":             {},
+	"latex-display":      {},
+	"latex-numbering":    {},
+	"sequence-numbering": {},
+}
+
+var colorKeywords = map[string]struct{}{
+	"fill":       {},
+	"stroke":     {},
+	"font-color": {},
+}
+
+var themeColorCodes = []string{
+	"N1", "N2", "N3", "N4", "N5", "N6", "N7",
+	"B1", "B2", "B3", "B4", "B5", "B6",
+	"AA2", "AA4", "AA5",
+	"AB4", "AB5",
+}
+
+var textTransforms = []string{"none", "uppercase", "lowercase", "capitalize"}
+
+var directions = []string{"up", "down", "left", "right"}
+
+var layoutEngines = []string{"dagre", "elk", "tala"}
+
+const iconsURL = "https://icons.terrastruct.com/"
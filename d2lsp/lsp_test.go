@@ -0,0 +1,706 @@
+package d2lsp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type session struct {
+	in     bytes.Buffer
+	nextID int
+}
+
+func (s *session) request(method string, params any) int {
+	s.nextID++
+	s.send(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      s.nextID,
+		"method":  method,
+		"params":  params,
+	})
+	return s.nextID
+}
+
+func (s *session) notify(method string, params any) {
+	s.send(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  params,
+	})
+}
+
+func (s *session) send(v any) {
+	b, _ := json.Marshal(v)
+	fmt.Fprintf(&s.in, "Content-Length: %d\r\n\r\n%s", len(b), b)
+}
+
+type testMessage struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *responseError  `json:"error"`
+}
+
+// run serves the session and returns every message written by the server.
+func (s *session) run(t *testing.T) []testMessage {
+	var out bytes.Buffer
+	err := Serve(context.Background(), &s.in, &out)
+	require.NoError(t, err)
+
+	var msgs []testMessage
+	r := bufio.NewReader(&out)
+	for {
+		header, err := textproto.NewReader(r).ReadMIMEHeader()
+		if err == io.EOF {
+			return msgs
+		}
+		require.NoError(t, err)
+		n, err := strconv.Atoi(header.Get("Content-Length"))
+		require.NoError(t, err)
+		b := make([]byte, n)
+		_, err = io.ReadFull(r, b)
+		require.NoError(t, err)
+		var m testMessage
+		require.NoError(t, json.Unmarshal(b, &m))
+		msgs = append(msgs, m)
+	}
+}
+
+func response(t *testing.T, msgs []testMessage, id int, v any) {
+	for _, m := range msgs {
+		if m.ID != nil && *m.ID == id && m.Method == "" {
+			require.Nil(t, m.Error)
+			require.NoError(t, json.Unmarshal(m.Result, v))
+			return
+		}
+	}
+	t.Fatalf("no response to %d", id)
+}
+
+func diagnostics(t *testing.T, msgs []testMessage) []PublishDiagnosticsParams {
+	var out []PublishDiagnosticsParams
+	for _, m := range msgs {
+		if m.Method == "textDocument/publishDiagnostics" {
+			var p PublishDiagnosticsParams
+			require.NoError(t, json.Unmarshal(m.Params, &p))
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+const testURI = "file:///tmp/d2lsp/index.d2"
+
+func TestServe(t *testing.T) {
+	t.Parallel()
+
+	var s session
+	initID := s.request("initialize", map[string]any{})
+	s.notify("initialized", map[string]any{})
+	s.notify("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{
+			URI:     testURI,
+			Version: 1,
+			Text:    "a: {shape: hexagonz}\n",
+		},
+	})
+	s.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument: VersionedTextDocumentIdentifier{
+			URI:     testURI,
+			Version: 2,
+		},
+		ContentChanges: []TextDocumentContentChangeEvent{{
+			Text: "a: {shape: hexagon}\na -> b\nlayers: {\n  x: {\n    c\n  }\n}\n",
+		}},
+	})
+	hoverID := s.request("textDocument/hover", TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: testURI},
+		Position:     Position{Line: 0, Character: 6},
+	})
+	objHoverID := s.request("textDocument/hover", TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: testURI},
+		Position:     Position{Line: 1, Character: 0},
+	})
+	symbolsID := s.request("textDocument/documentSymbol", DocumentSymbolParams{
+		TextDocument: TextDocumentIdentifier{URI: testURI},
+	})
+	unknownID := s.request("textDocument/unknown", map[string]any{})
+	shutdownID := s.request("shutdown", nil)
+	s.notify("exit", nil)
+
+	msgs := s.run(t)
+
+	var init InitializeResult
+	response(t, msgs, initID, &init)
+	assert.True(t, init.Capabilities.HoverProvider)
+	assert.Equal(t, SyncFull, init.Capabilities.TextDocumentSync.Change)
+
+	diags := diagnostics(t, msgs)
+	require.Len(t, diags, 2)
+	require.Len(t, diags[0].Diagnostics, 1)
+	assert.Equal(t, `unknown shape "hexagonz"`, diags[0].Diagnostics[0].Message)
+	assert.Equal(t, Range{Start: Position{0, 11}, End: Position{0, 19}}, diags[0].Diagnostics[0].Range)
+	assert.Equal(t, 2, *diags[1].Version)
+	assert.Empty(t, diags[1].Diagnostics)
+
+	var h Hover
+	response(t, msgs, hoverID, &h)
+	assert.Contains(t, h.Contents.Value, "**shape**")
+
+	response(t, msgs, objHoverID, &h)
+	assert.Equal(t, "```d2\na\n```\n\nshape: hexagon", h.Contents.Value)
+
+	var symbols []DocumentSymbol
+	response(t, msgs, symbolsID, &symbols)
+	require.Len(t, symbols, 3)
+	assert.Equal(t, "a", symbols[0].Name)
+	assert.Equal(t, "a -> b", symbols[1].Name)
+	assert.Equal(t, "x", symbols[2].Name)
+	assert.Equal(t, SymbolKindNamespace, symbols[2].Kind)
+	require.Len(t, symbols[2].Children, 1)
+	assert.Equal(t, "c", symbols[2].Children[0].Name)
+
+	for _, m := range msgs {
+		if m.ID != nil && *m.ID == unknownID {
+			require.NotNil(t, m.Error)
+			assert.Equal(t, codeMethodNotFound, m.Error.Code)
+		}
+	}
+	var null any
+	response(t, msgs, shutdownID, &null)
+}
+
+func TestCompletion(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		// prev, if set, is analyzed before text as if text were an edit of it.
+		prev string
+		text string
+		pos  Position
+
+		exp    []string
+		notExp []string
+	}{
+		{
+			name: "root",
+			text: "",
+			pos:  Position{0, 0},
+			exp:  []string{"shape", "style", "layers", "vars", "classes", "description", "transition"},
+		},
+		{
+			name:   "nested",
+			text:   "a: {\n  \n}\n",
+			pos:    Position{1, 2},
+			exp:    []string{"shape", "style", "label"},
+			notExp: []string{"layers", "vars"},
+		},
+		{
+			name:   "style",
+			text:   "a.style.",
+			pos:    Position{0, 8},
+			exp:    []string{"fill", "stroke", "3d"},
+			notExp: []string{"shape"},
+		},
+		{
+			name: "style-map",
+			text: "a: {\n  style: {\n    f\n  }\n}\n",
+			pos:  Position{2, 5},
+			exp:  []string{"fill", "font-color"},
+		},
+		{
+			name:   "unterminated",
+			text:   "a: {\n  style.",
+			pos:    Position{1, 8},
+			exp:    []string{"fill"},
+			notExp: []string{"shape"},
+		},
+		{
+			name:   "unterminated-nested",
+			text:   "a: {\n  b: {\n    ",
+			pos:    Position{2, 4},
+			exp:    []string{"shape"},
+			notExp: []string{"layers"},
+		},
+		{
+			name: "shape",
+			text: "a: {shape: ",
+			pos:  Position{0, 11},
+			exp:  []string{"rectangle", "sql_table", "person"},
+		},
+		{
+			name:   "arrowhead-shape",
+			text:   "a -> b: {\n  source-arrowhead.shape: \n}\n",
+			pos:    Position{1, 26},
+			exp:    []string{"diamond", "cf-many"},
+			notExp: []string{"rectangle"},
+		},
+		{
+			name: "edge-keys",
+			text: "(a -> b)[0].",
+			pos:  Position{0, 12},
+			exp:  []string{"source-arrowhead", "style"},
+		},
+		{
+			name: "fill",
+			text: "a.style.fill: ",
+			pos:  Position{0, 14},
+			exp:  []string{"N1", "AB5", "red"},
+		},
+		{
+			name: "boolean",
+			text: "a.style.shadow: ",
+			pos:  Position{0, 16},
+			exp:  []string{"true", "false"},
+		},
+		{
+			name: "theme",
+			text: "vars: {\n  d2-config: {\n    theme-id: \n  }\n}\n",
+			pos:  Position{2, 14},
+			exp:  []string{"0", "200"},
+		},
+		{
+			name: "config-keys",
+			text: "vars: {\n  d2-config: {\n    \n  }\n}\n",
+			pos:  Position{2, 4},
+			exp:  []string{"theme-id", "layout-engine"},
+		},
+		{
+			name: "near",
+			text: "x\na.near: ",
+			prev: "x\na",
+			pos:  Position{1, 8},
+			exp:  []string{"top-center", "x"},
+		},
+		{
+			name: "icon",
+			text: "a.icon: ",
+			pos:  Position{0, 8},
+			exp:  []string{iconsURL},
+		},
+		{
+			name: "class",
+			prev: "classes: {k: {shape: circle}}\nlayers: {\n  l: {\n    classes: {m}\n    a\n  }\n}\n",
+			text: "classes: {k: {shape: circle}}\nlayers: {\n  l: {\n    classes: {m}\n    a.class: \n  }\n}\n",
+			pos:  Position{4, 13},
+			exp:  []string{"k", "m"},
+		},
+		{
+			name:   "root-class",
+			prev:   "classes: {k}\nlayers: {\n  l: {\n    classes: {m}\n  }\n}\na\n",
+			text:   "classes: {k}\nlayers: {\n  l: {\n    classes: {m}\n  }\n}\na.class: \n",
+			pos:    Position{6, 9},
+			exp:    []string{"k"},
+			notExp: []string{"m"},
+		},
+		{
+			name: "label",
+			text: "a -> b: ",
+			pos:  Position{0, 8},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := &server{docs: make(map[string]*document)}
+			doc := &document{
+				uri:  testURI,
+				path: uriToPath(testURI),
+				text: tc.prev,
+			}
+			s.docs[doc.uri] = doc
+			if tc.prev != "" {
+				s.analyze(doc)
+			}
+			doc.text = tc.text
+			s.analyze(doc)
+
+			var labels []string
+			for _, item := range s.completions(doc, tc.pos) {
+				labels = append(labels, item.Label)
+			}
+			for _, exp := range tc.exp {
+				assert.Contains(t, labels, exp)
+			}
+			for _, notExp := range tc.notExp {
+				assert.NotContains(t, labels, notExp)
+			}
+			if len(tc.exp) == 0 {
+				assert.Empty(t, labels)
+			}
+		})
+	}
+}
+
+func TestVarCompletion(t *testing.T) {
+	t.Parallel()
+
+	const importURI = "file:///tmp/d2lsp/vars.d2"
+	s := &server{docs: make(map[string]*document)}
+	for uri, text := range map[string]string{
+		testURI: `vars: {
+  ...@vars
+  color: red
+  sizes: {small: 1; large: 3}
+  d2-config: {theme-id: 1}
+}
+layers: {
+  l: {
+    vars: {color: blue; border: ${color}}
+    a.label: x
+  }
+}
+b.label: x
+`,
+		importURI: `shared: [a; b]`,
+	} {
+		s.docs[uri] = &document{
+			uri:  uri,
+			path: uriToPath(uri),
+			text: text,
+		}
+	}
+	for _, doc := range s.docs {
+		s.analyze(doc)
+		require.Empty(t, doc.diagnostics)
+	}
+	doc := s.docs[testURI]
+
+	vars := func(text string, pos Position) map[string]string {
+		doc.text = text
+		m := make(map[string]string)
+		for _, item := range s.completions(doc, pos) {
+			assert.Equal(t, CompletionKindVariable, item.Kind)
+			m[item.Label] = item.Detail
+		}
+		return m
+	}
+	lines := strings.Split(doc.text, "\n")
+	edit := func(line int, text string) (string, Position) {
+		l := append([]string{}, lines...)
+		l[line] = text
+		return strings.Join(l, "\n"), Position{line, len(text)}
+	}
+
+	assert.Equal(t, map[string]string{
+		"shared": "[a; b]",
+		"color":  "red",
+		"sizes":  "map",
+	}, vars(edit(12, "b.label: ${")))
+	assert.Equal(t, map[string]string{
+		"small": "1",
+		"large": "3",
+	}, vars(edit(12, "b.label: ${sizes.")))
+	assert.Equal(t, map[string]string{
+		"color":  "blue",
+		"border": "red",
+		"shared": "[a; b]",
+		"sizes":  "map",
+	}, vars(edit(9, "    a.label: ${")))
+	text, pos := edit(12, "b.label: ${color} ")
+	doc.text = text
+	for _, item := range s.completions(doc, pos) {
+		assert.NotEqual(t, CompletionKindVariable, item.Kind)
+	}
+
+	doc.text = "vars: {color: red}\na.style.fill: ${colr}\n"
+	s.analyze(doc)
+	require.Len(t, doc.diagnostics, 1)
+	assert.Equal(t, `could not resolve variable "colr"`, doc.diagnostics[0].Message)
+	assert.Equal(t, "red", vars("vars: {color: red}\na.style.fill: ${col", Position{1, 19})["color"])
+}
+
+func TestCompletionDocs(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "logo.svg"), []byte("<svg/>"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0600))
+	s := &server{docs: make(map[string]*document)}
+	doc := &document{
+		uri:  "file://" + filepath.ToSlash(filepath.Join(dir, "index.d2")),
+		path: filepath.Join(dir, "index.d2"),
+		text: "classes: {k: {shape: circle; style.fill: red}}\nx.icon: https://icons.terrastruct.com/dev/go.svg\n",
+	}
+	s.docs[doc.uri] = doc
+	s.analyze(doc)
+	require.Empty(t, doc.diagnostics)
+
+	find := func(items []CompletionItem, label string) CompletionItem {
+		for _, item := range items {
+			if item.Label == label {
+				return item
+			}
+		}
+		t.Fatalf("no completion %q", label)
+		return CompletionItem{}
+	}
+
+	cylinder := find(s.valueCompletions(doc, []string{"a", "shape"}), "cylinder")
+	require.NotNil(t, cylinder.Documentation)
+	assert.Contains(t, cylinder.Documentation.Value, "databases")
+	assert.Contains(t, cylinder.Documentation.Value, "](data:image/svg+xml;base64,")
+
+	theme := find(s.valueCompletions(doc, []string{"vars", "d2-config", "theme-id"}), "200")
+	assert.Contains(t, theme.Documentation.Value, "Dark theme")
+	assert.Contains(t, theme.Documentation.Value, "](data:image/svg+xml;base64,")
+
+	icons := s.valueCompletions(doc, []string{"a", "icon"})
+	find(icons, iconsURL)
+	find(icons, "https://icons.terrastruct.com/dev/go.svg")
+	assert.Equal(t, "local image", find(icons, "logo.svg").Detail)
+	for _, item := range icons {
+		assert.NotEqual(t, "notes.txt", item.Label)
+	}
+
+	k := find(s.valueCompletions(doc, []string{"a", "class"}), "k")
+	assert.Equal(t, "```d2\nshape: circle\nstyle.fill: red\n```", k.Documentation.Value)
+}
+
+func TestDefinition(t *testing.T) {
+	t.Parallel()
+
+	const importURI = "file:///tmp/d2lsp/x.d2"
+	s := &server{docs: make(map[string]*document)}
+	for uri, text := range map[string]string{
+		testURI: `...@x
+a -> b
+c.class: k
+*.style.opacity: 0.5
+layers: {
+  l: {
+    d.class: [k]
+  }
+}
+`,
+		importURI: `a: {shape: circle}
+classes: {
+  k: {style.fill: red}
+}
+`,
+	} {
+		s.docs[uri] = &document{
+			uri:  uri,
+			path: uriToPath(uri),
+			text: text,
+		}
+	}
+	for _, doc := range s.docs {
+		s.analyze(doc)
+		require.Empty(t, doc.diagnostics)
+	}
+	doc := s.docs[testURI]
+
+	loc := func(uri string, line, start, end int) Location {
+		return Location{
+			URI: uri,
+			Range: Range{
+				Start: Position{line, start},
+				End:   Position{line, end},
+			},
+		}
+	}
+
+	assert.Equal(t, []Location{loc(importURI, 0, 0, 1)}, s.definition(doc, Position{1, 0}))
+	assert.Equal(t, []Location{loc(testURI, 1, 5, 6)}, s.definition(doc, Position{1, 5}))
+	assert.Equal(t, []Location{loc(importURI, 2, 2, 3)}, s.definition(doc, Position{2, 9}))
+	assert.Equal(t, []Location{loc(importURI, 2, 2, 3)}, s.definition(doc, Position{6, 14}))
+	assert.Empty(t, s.definition(doc, Position{0, 2}))
+
+	assert.Equal(t, []Location{
+		loc(testURI, 1, 0, 1),
+		loc(testURI, 3, 0, 1),
+		loc(importURI, 0, 0, 1),
+	}, s.references(doc, Position{1, 0}, true))
+	assert.Equal(t, []Location{
+		loc(testURI, 1, 0, 1),
+		loc(testURI, 3, 0, 1),
+	}, s.references(doc, Position{1, 0}, false))
+	assert.Equal(t, []Location{
+		loc(testURI, 2, 9, 10),
+		loc(testURI, 6, 14, 15),
+		loc(importURI, 2, 2, 3),
+	}, s.references(doc, Position{2, 9}, true))
+}
+
+func TestSemanticTokens(t *testing.T) {
+	t.Parallel()
+
+	data := semanticTokens("é -> b: |md\n  x\n|\n")
+	assert.Equal(t, []uint32{
+		0, 0, 1, 0, 0, // é
+		0, 2, 2, 5, 0, // ->
+		0, 3, 1, 0, 0, // b
+		0, 3, 3, 2, 0, // |md
+		1, 0, 3, 2, 0, //   x
+		1, 0, 1, 2, 0, // |
+	}, data)
+}
+
+func TestFormatting(t *testing.T) {
+	t.Parallel()
+
+	doc := &document{
+		uri:  testURI,
+		path: uriToPath(testURI),
+		text: "é   ->   b\nx: {\n     y   ->   z\n}\n",
+	}
+	assert.Equal(t, []TextEdit{{
+		Range:   Range{Start: Position{0, 0}, End: Position{4, 0}},
+		NewText: "é -> b\nx: {\n  y -> z\n}\n",
+	}}, formatting(doc))
+	assert.Equal(t, []TextEdit{{
+		Range:   Range{Start: Position{2, 0}, End: Position{2, 15}},
+		NewText: "  y -> z",
+	}}, rangeFormatting(doc, Range{Start: Position{2, 6}, End: Position{2, 6}}))
+	assert.Equal(t, []TextEdit{{
+		Range:   Range{Start: Position{0, 0}, End: Position{0, 10}},
+		NewText: "é -> b",
+	}}, rangeFormatting(doc, Range{Start: Position{0, 1}, End: Position{0, 2}}))
+
+	doc.text = "a: {"
+	assert.Empty(t, formatting(doc))
+}
+
+func TestCodeActions(t *testing.T) {
+	t.Parallel()
+
+	s := &server{docs: make(map[string]*document)}
+	doc := &document{
+		uri:  testURI,
+		path: uriToPath(testURI),
+		text: "é.shape: cirle\nx.style.fil: red\n",
+	}
+	s.docs[testURI] = doc
+	s.analyze(doc)
+	require.Len(t, doc.diagnostics, 2)
+
+	actions := codeActions(doc, Range{Start: Position{0, 10}, End: Position{0, 10}})
+	require.Len(t, actions, 1)
+	assert.Equal(t, `Change to "circle"`, actions[0].Title)
+	assert.True(t, actions[0].IsPreferred)
+	assert.Equal(t, []Diagnostic{doc.diagnostics[0]}, actions[0].Diagnostics)
+	assert.Equal(t, map[string][]TextEdit{
+		testURI: {{
+			Range:   Range{Start: Position{0, 9}, End: Position{0, 14}},
+			NewText: "circle",
+		}},
+	}, actions[0].Edit.Changes)
+
+	actions = codeActions(doc, Range{Start: Position{0, 0}, End: Position{2, 0}})
+	require.Len(t, actions, 2)
+	assert.Equal(t, `Change to "fill"`, actions[1].Title)
+
+	assert.Empty(t, codeActions(doc, Range{Start: Position{2, 0}, End: Position{2, 0}}))
+}
+
+func TestPreview(t *testing.T) {
+	t.Parallel()
+
+	s := &server{docs: make(map[string]*document)}
+	doc := &document{
+		uri:  testURI,
+		path: uriToPath(testURI),
+		text: "a: {shape: hexagon; inner}\na -> b: hi\nc -> a\nd -> b\ne\n",
+	}
+	s.docs[testURI] = doc
+	s.analyze(doc)
+	require.Empty(t, doc.diagnostics)
+
+	g := neighborhood(doc.g.Objects[0])
+	require.Equal(t, "a", doc.g.Objects[0].AbsID())
+	var labels []string
+	for _, obj := range g.Objects {
+		labels = append(labels, obj.Label.Value)
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, labels)
+	assert.Equal(t, "hexagon", g.Objects[0].Shape.Value)
+	require.Len(t, g.Edges, 2)
+	assert.Equal(t, "hi", g.Edges[0].Label.Value)
+
+	p, err := s.preview(context.Background(), doc, Position{1, 0})
+	require.NoError(t, err)
+	require.NotNil(t, p)
+	assert.Equal(t, "a", p.ID)
+	assert.Contains(t, p.SVG, "<svg")
+	// The original graph is untouched.
+	assert.Nil(t, doc.g.Objects[0].Box)
+
+	p, err = s.preview(context.Background(), doc, Position{5, 0})
+	require.NoError(t, err)
+	assert.Nil(t, p)
+}
+
+func TestWorkspace(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "shared.d2"), []byte("classes: {\n  used: {style.fill: red}\n  unused: {style.fill: blue}\n}\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.d2"), []byte("x: @missing\n"), 0600))
+	uri := func(name string) string {
+		return (&url.URL{Scheme: "file", Path: filepath.ToSlash(filepath.Join(dir, name))}).String()
+	}
+
+	var s session
+	s.request("initialize", InitializeParams{RootURI: uri("")})
+	s.notify("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{
+			URI:     uri("index.d2"),
+			Version: 1,
+			Text:    "...@shared\na.class: used\nb.link: layers.nope\n",
+		},
+	})
+	s.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument: VersionedTextDocumentIdentifier{
+			URI:     uri("index.d2"),
+			Version: 2,
+		},
+		ContentChanges: []TextDocumentContentChangeEvent{{
+			Text: "...@shared\na.class: [used; unused]\n",
+		}},
+	})
+	s.request("shutdown", nil)
+	s.notify("exit", nil)
+
+	type diag struct {
+		uri      string
+		severity DiagnosticSeverity
+		msg      string
+	}
+	var got []diag
+	for _, p := range diagnostics(t, s.run(t)) {
+		if len(p.Diagnostics) == 0 {
+			got = append(got, diag{uri: p.URI})
+		}
+		for _, d := range p.Diagnostics {
+			got = append(got, diag{p.URI, d.Severity, d.Message})
+		}
+	}
+	assert.Equal(t, []diag{
+		{uri("index.d2"), SeverityError, "linked board not found"},
+		{uri("broken.d2"), SeverityError, `imported file "missing.d2" does not exist`},
+		{uri("shared.d2"), SeverityWarning, `class "unused" is shared but never used`},
+		{uri: uri("index.d2")},
+		{uri("broken.d2"), SeverityError, `imported file "missing.d2" does not exist`},
+		{uri: uri("shared.d2")},
+	}, got)
+}
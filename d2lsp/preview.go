@@ -0,0 +1,106 @@
+package d2lsp
+
+import (
+	"context"
+	"fmt"
+
+	"oss.terrastruct.com/util-go/go2"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2dagrelayout"
+	"oss.terrastruct.com/d2/d2lib"
+	"oss.terrastruct.com/d2/d2renderers/d2svg"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/textmeasure"
+)
+
+// preview renders the object at pos and its immediate neighbors to a small SVG. It uses the
+// graph of the last text that compiled rather than recompiling the document, so it returns
+// nil if there is none or nothing is at pos.
+func (s *server) preview(ctx context.Context, doc *document, pos Position) (*Preview, error) {
+	if doc.g == nil {
+		return nil, nil
+	}
+	sb := keyAt(doc.ast, pos)
+	if sb == nil {
+		return nil, nil
+	}
+	obj := objectAt(doc.g, sb)
+	if obj == nil {
+		return nil, nil
+	}
+
+	if s.ruler == nil {
+		ruler, err := textmeasure.NewRuler()
+		if err != nil {
+			return nil, err
+		}
+		s.ruler = ruler
+	}
+	renderOpts := &d2svg.RenderOpts{
+		Pad: go2.Pointer(int64(8)),
+	}
+	diagram, err := d2lib.CompileGraph(ctx, neighborhood(obj), &d2lib.CompileOptions{
+		Ruler: s.ruler,
+		LayoutResolver: func(engine string) (d2graph.LayoutGraph, error) {
+			return d2dagrelayout.DefaultLayout, nil
+		},
+	}, renderOpts)
+	if err != nil {
+		return nil, err
+	}
+	svg, err := d2svg.Render(diagram, renderOpts)
+	if err != nil {
+		return nil, err
+	}
+	return &Preview{
+		ID:  obj.AbsID(),
+		SVG: string(svg),
+	}, nil
+}
+
+// neighborhood returns a graph of obj and the objects it is connected to, with the
+// connections between them. The objects are copied without their children and at the root so
+// that the preview stays small, and the graph of obj is left untouched.
+func neighborhood(obj *d2graph.Object) *d2graph.Graph {
+	g := d2graph.NewGraph()
+	ids := make(map[*d2graph.Object]string)
+	add := func(o *d2graph.Object) string {
+		if id, ok := ids[o]; ok {
+			return id
+		}
+		id := fmt.Sprintf("n%d", len(ids))
+		ids[o] = id
+		n := g.Root.EnsureChild([]string{id})
+		n.Attributes = o.Attributes
+		n.Class = o.Class
+		n.SQLTable = o.SQLTable
+		// Attributes that only make sense with the children or surroundings of o.
+		n.NearKey = nil
+		n.Top = nil
+		n.Left = nil
+		n.GridRows = nil
+		n.GridColumns = nil
+		switch n.Shape.Value {
+		case d2target.ShapeSequenceDiagram, d2target.ShapeHierarchy:
+			n.Shape.Value = d2target.ShapeRectangle
+		}
+		return id
+	}
+
+	add(obj)
+	for _, e := range obj.Graph.Edges {
+		if e.Src != obj && e.Dst != obj {
+			continue
+		}
+		src, dst := add(e.Src), add(e.Dst)
+		e2, err := g.Root.Connect([]string{src}, []string{dst}, e.SrcArrow, e.DstArrow, e.Label.Value)
+		if err != nil {
+			continue
+		}
+		e2.Attributes = e.Attributes
+		e2.SrcArrowhead = e.SrcArrowhead
+		e2.DstArrowhead = e.DstArrowhead
+	}
+	return g
+}
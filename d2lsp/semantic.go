@@ -0,0 +1,86 @@
+package d2lsp
+
+import (
+	"unicode/utf8"
+
+	"oss.terrastruct.com/d2/d2highlight"
+)
+
+// semanticTokenTypes is the legend of semantic token types, indexed by the encoded tokens.
+var semanticTokenTypes = []string{
+	"property",
+	"keyword",
+	"string",
+	"number",
+	"enumMember",
+	"operator",
+	"variable",
+	"macro",
+	"comment",
+}
+
+var semanticTokenKinds = map[d2highlight.Kind]uint32{
+	d2highlight.Key:          0,
+	d2highlight.Keyword:      1,
+	d2highlight.String:       2,
+	d2highlight.Number:       3,
+	d2highlight.Constant:     4,
+	d2highlight.Connection:   5,
+	d2highlight.Substitution: 6,
+	d2highlight.Import:       7,
+	d2highlight.Comment:      8,
+}
+
+// semanticTokens encodes the tokens of text relative to each other as LSP requires.
+// Tokens spanning multiple lines are split into one per line.
+func semanticTokens(text string) []uint32 {
+	var data []uint32
+	// The position of the last encoded token.
+	var prevLine, prevChar uint32
+	// The position of offset.
+	var line, char uint32
+	offset := 0
+	next := func() rune {
+		r, n := utf8.DecodeRuneInString(text[offset:])
+		offset += n
+		if r == '\n' {
+			line++
+			char = 0
+		} else {
+			char += utf16Len(r)
+		}
+		return r
+	}
+
+	for _, tok := range d2highlight.Tokenize(text) {
+		kind := semanticTokenKinds[tok.Kind]
+		for offset < tok.Start {
+			next()
+		}
+		for offset < tok.End {
+			startLine, startChar := line, char
+			for offset < tok.End && text[offset] != '\n' {
+				next()
+			}
+			if char > startChar {
+				deltaChar := startChar
+				if startLine == prevLine {
+					deltaChar -= prevChar
+				}
+				data = append(data, startLine-prevLine, deltaChar, char-startChar, kind, 0)
+				prevLine, prevChar = startLine, startChar
+			}
+			if offset < tok.End {
+				next()
+			}
+		}
+	}
+	return data
+}
+
+func utf16Len(r rune) uint32 {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,529 @@
+// Package d2lsp implements a Language Server Protocol server for D2 over a pair of streams,
+// usually stdin and stdout. It is served by `d2 lsp`.
+//
+// Besides the standard requests, it answers d2/preview, which takes a position like
+// textDocument/hover and returns a Preview: an SVG of the object at the position and its
+// immediate neighbors, for editors to show alongside hovers.
+package d2lsp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2index"
+	"oss.terrastruct.com/d2/d2ir"
+	"oss.terrastruct.com/d2/d2parser"
+	"oss.terrastruct.com/d2/lib/textmeasure"
+	"oss.terrastruct.com/d2/lib/version"
+)
+
+// document is an open text document along with the result of analyzing its latest text.
+type document struct {
+	uri     string
+	path    string
+	version int
+	text    string
+
+	// ast is nil only if the text could not be parsed at all. On parse errors it holds
+	// whatever could be parsed.
+	ast *d2ast.Map
+	// g is the graph of the last text that compiled, so that completion keeps working while
+	// the document is being edited. It is nil if the text never compiled.
+	g *d2graph.Graph
+	// ir is the IR of the last text that compiled, which references the declarations and
+	// usages of every field, including those of imports.
+	ir          *d2ir.Map
+	diagnostics []Diagnostic
+	// errs are the errors the diagnostics were converted from, with their fixes.
+	errs []d2ast.Error
+}
+
+type server struct {
+	conn *conn
+	docs map[string]*document
+	// ruler measures the texts of previews. It is loaded on the first preview.
+	ruler *textmeasure.Ruler
+
+	// root is the directory of the workspace. The index of its scripts is nil if the client
+	// did not send one.
+	root  string
+	index *d2index.Index
+	// published are the URIs of the files that are not open and have workspace diagnostics.
+	published map[string]struct{}
+
+	shutdown bool
+}
+
+// Serve runs the server until the client sends exit, r is closed or ctx is cancelled.
+func Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	s := &server{
+		conn:      newConn(r, w),
+		docs:      make(map[string]*document),
+		published: make(map[string]struct{}),
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		req, err := s.conn.read()
+		if err != nil {
+			var rerr *responseError
+			if errors.As(err, &rerr) {
+				if err := s.conn.reply(nil, nil, rerr); err != nil {
+					return err
+				}
+				continue
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exit received before shutdown")
+			}
+			return nil
+		}
+
+		result, err := s.handle(ctx, req)
+		if req.ID == nil {
+			// Notifications cannot be replied to.
+			if err != nil {
+				s.logf("%s: %v", req.Method, err)
+			}
+			continue
+		}
+		var rerr *responseError
+		if err != nil && !errors.As(err, &rerr) {
+			rerr = &responseError{
+				Code:    codeInternalError,
+				Message: err.Error(),
+			}
+		}
+		if err := s.conn.reply(req.ID, result, rerr); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *server) handle(ctx context.Context, req *request) (any, error) {
+	if s.shutdown && req.Method != "exit" {
+		return nil, &responseError{
+			Code:    codeInvalidRequest,
+			Message: "server is shutting down",
+		}
+	}
+
+	switch req.Method {
+	case "initialize":
+		var params InitializeParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		s.initWorkspace(params)
+		return InitializeResult{
+			Capabilities: ServerCapabilities{
+				TextDocumentSync: TextDocumentSyncOptions{
+					OpenClose: true,
+					Change:    SyncFull,
+					Save:      true,
+				},
+				CompletionProvider: &CompletionOptions{
+					TriggerCharacters: []string{".", ":", " ", "{"},
+				},
+				HoverProvider:          true,
+				DocumentSymbolProvider: true,
+				DefinitionProvider:     true,
+				ReferencesProvider:     true,
+				SemanticTokensProvider: &SemanticTokensOptions{
+					Legend: SemanticTokensLegend{
+						TokenTypes:     semanticTokenTypes,
+						TokenModifiers: []string{},
+					},
+					Full: true,
+				},
+				DocumentFormattingProvider:      true,
+				DocumentRangeFormattingProvider: true,
+				CodeActionProvider:              true,
+			},
+			ServerInfo: ServerInfo{
+				Name:    "d2",
+				Version: version.Version,
+			},
+		}, nil
+	case "initialized", "$/cancelRequest", "$/setTrace":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		s.docs[params.TextDocument.URI] = &document{
+			uri:     params.TextDocument.URI,
+			path:    uriToPath(params.TextDocument.URI),
+			version: params.TextDocument.Version,
+			text:    params.TextDocument.Text,
+		}
+		return nil, s.analyzeAll()
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		doc, err := s.doc(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		// Only full document sync is advertised so the last change has the whole text.
+		doc.text = params.ContentChanges[len(params.ContentChanges)-1].Text
+		doc.version = params.TextDocument.Version
+		return nil, s.analyzeAll()
+	case "textDocument/didSave":
+		var params DidSaveTextDocumentParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		if params.Text != nil {
+			doc, err := s.doc(params.TextDocument.URI)
+			if err != nil {
+				return nil, err
+			}
+			doc.text = *params.Text
+		}
+		return nil, s.analyzeAll()
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		doc, err := s.doc(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		delete(s.docs, params.TextDocument.URI)
+		s.reindex(doc.path)
+		// Clear the diagnostics of the closed document and refresh those of the documents
+		// that may import it, which now read it from disk instead.
+		err = s.conn.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []Diagnostic{},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.analyzeAll()
+
+	case "textDocument/completion":
+		var params TextDocumentPositionParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		doc, err := s.doc(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return CompletionList{
+			Items: s.completions(doc, params.Position),
+		}, nil
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		doc, err := s.doc(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		if h := hover(doc, params.Position); h != nil {
+			return h, nil
+		}
+		return nil, nil
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		doc, err := s.doc(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return documentSymbols(doc), nil
+	case "textDocument/definition":
+		var params TextDocumentPositionParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		doc, err := s.doc(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return s.definition(doc, params.Position), nil
+	case "textDocument/references":
+		var params ReferenceParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		doc, err := s.doc(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return s.references(doc, params.Position, params.Context.IncludeDeclaration), nil
+	case "textDocument/semanticTokens/full":
+		var params SemanticTokensParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		doc, err := s.doc(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return SemanticTokens{
+			Data: semanticTokens(doc.text),
+		}, nil
+	case "textDocument/formatting":
+		var params DocumentFormattingParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		doc, err := s.doc(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return formatting(doc), nil
+	case "textDocument/rangeFormatting":
+		var params DocumentRangeFormattingParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		doc, err := s.doc(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return rangeFormatting(doc, params.Range), nil
+	case "d2/preview":
+		var params TextDocumentPositionParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		doc, err := s.doc(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return s.preview(ctx, doc, params.Position)
+	case "textDocument/codeAction":
+		var params CodeActionParams
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		doc, err := s.doc(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return codeActions(doc, params.Range), nil
+	}
+
+	if req.ID == nil {
+		// Unknown notifications must be ignored.
+		return nil, nil
+	}
+	return nil, &responseError{
+		Code:    codeMethodNotFound,
+		Message: fmt.Sprintf("method %q not supported", req.Method),
+	}
+}
+
+func unmarshalParams(req *request, v any) error {
+	err := json.Unmarshal(req.Params, v)
+	if err != nil {
+		return &responseError{
+			Code:    codeInvalidParams,
+			Message: err.Error(),
+		}
+	}
+	return nil
+}
+
+func (s *server) doc(uri string) (*document, error) {
+	doc, ok := s.docs[uri]
+	if !ok {
+		return nil, &responseError{
+			Code:    codeInvalidParams,
+			Message: fmt.Sprintf("document %q is not open", uri),
+		}
+	}
+	return doc, nil
+}
+
+func (s *server) logf(format string, v ...any) {
+	s.conn.notify("window/logMessage", map[string]any{
+		"type":    1,
+		"message": fmt.Sprintf(format, v...),
+	})
+}
+
+// analyzeAll analyzes every open document and publishes their diagnostics. Every document is
+// analyzed rather than just the one that changed as any of them may import it.
+func (s *server) analyzeAll() error {
+	uris := make([]string, 0, len(s.docs))
+	for uri := range s.docs {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	for _, uri := range uris {
+		s.analyze(s.docs[uri])
+	}
+	issues := s.workspaceIssues()
+	for _, uri := range uris {
+		doc := s.docs[uri]
+		doc.diagnostics = mergeDiagnostics(doc.diagnostics, issues[doc.path])
+		delete(issues, doc.path)
+		err := s.conn.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+			URI:         doc.uri,
+			Version:     &doc.version,
+			Diagnostics: doc.diagnostics,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return s.publishWorkspace(issues)
+}
+
+func (s *server) analyze(doc *document) {
+	doc.diagnostics = []Diagnostic{}
+	doc.errs = nil
+
+	ast, err := d2parser.Parse(doc.path, strings.NewReader(doc.text), &d2parser.ParseOptions{
+		UTF16Pos: true,
+	})
+	doc.ast = ast
+	if err == nil {
+		var ir *d2ir.Map
+		ir, _, err = d2ir.Compile(ast, &d2ir.CompileOptions{
+			UTF16Pos: true,
+			FS:       docFS{docs: s.docs},
+		})
+		if err == nil {
+			doc.ir = ir
+		}
+	}
+	if err == nil {
+		var g *d2graph.Graph
+		g, _, err = d2compiler.Compile(doc.path, strings.NewReader(doc.text), &d2compiler.CompileOptions{
+			UTF16Pos: true,
+			FS:       docFS{docs: s.docs},
+		})
+		if err == nil {
+			doc.g = g
+		}
+	}
+	if err != nil {
+		doc.diagnostics = toDiagnostics(doc.path, err)
+		var pe *d2parser.ParseError
+		if errors.As(err, &pe) {
+			doc.errs = pe.Errors
+		}
+	}
+}
+
+// toDiagnostics converts the error of parsing or compiling the file at path into diagnostics.
+// Errors within imported files are reported at the start of the document.
+func toDiagnostics(path string, err error) []Diagnostic {
+	var pe *d2parser.ParseError
+	if !errors.As(err, &pe) {
+		return []Diagnostic{{
+			Severity: SeverityError,
+			Source:   "d2",
+			Message:  err.Error(),
+		}}
+	}
+	diags := make([]Diagnostic, 0, len(pe.Errors))
+	for _, e := range pe.Errors {
+		diags = append(diags, toDiagnostic(path, e))
+	}
+	return diags
+}
+
+func toDiagnostic(path string, e d2ast.Error) Diagnostic {
+	d := Diagnostic{
+		Severity: SeverityError,
+		Source:   "d2",
+		Message:  strings.TrimPrefix(e.Message, e.Range.String()+": "),
+	}
+	if e.Range.Path == "" || e.Range.Path == path {
+		d.Range = toRange(e.Range)
+	} else {
+		d.Message = e.Message
+	}
+	return d
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	p := u.Path
+	// file:///c:/x on Windows.
+	if len(p) >= 3 && p[0] == '/' && p[2] == ':' {
+		p = p[1:]
+	}
+	return filepath.FromSlash(p)
+}
+
+// docFS serves the text of open documents so that imports see unsaved edits, and falls back
+// to the OS for everything else.
+type docFS struct {
+	docs map[string]*document
+}
+
+func (fsys docFS) Open(name string) (fs.File, error) {
+	for _, doc := range fsys.docs {
+		if doc.path == name {
+			return &memFile{
+				Reader: bytes.NewReader([]byte(doc.text)),
+				name:   path.Base(filepath.ToSlash(name)),
+				size:   int64(len(doc.text)),
+			}, nil
+		}
+	}
+	return os.Open(name)
+}
+
+type memFile struct {
+	*bytes.Reader
+	name string
+	size int64
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f, nil }
+func (f *memFile) Close() error               { return nil }
+func (f *memFile) Name() string               { return f.name }
+func (f *memFile) Size() int64                { return f.size }
+func (f *memFile) Mode() fs.FileMode          { return 0o444 }
+func (f *memFile) ModTime() time.Time         { return time.Time{} }
+func (f *memFile) IsDir() bool                { return false }
+func (f *memFile) Sys() any                   { return nil }
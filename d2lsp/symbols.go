@@ -0,0 +1,40 @@
+package d2lsp
+
+import (
+	"oss.terrastruct.com/d2/d2outline"
+)
+
+var symbolKinds = map[d2outline.Kind]SymbolKind{
+	d2outline.Object:     SymbolKindObject,
+	d2outline.Connection: SymbolKindEvent,
+	d2outline.Board:      SymbolKindNamespace,
+	d2outline.Class:      SymbolKindClass,
+	d2outline.Var:        SymbolKindVariable,
+}
+
+// documentSymbols returns the outline of doc: its objects, connections, boards, classes and
+// vars.
+func documentSymbols(doc *document) []DocumentSymbol {
+	if doc.ast == nil {
+		return []DocumentSymbol{}
+	}
+	return toSymbols(d2outline.Outline(doc.ast))
+}
+
+func toSymbols(items []d2outline.Item) []DocumentSymbol {
+	symbols := make([]DocumentSymbol, 0, len(items))
+	for _, it := range items {
+		s := DocumentSymbol{
+			Name:           it.Name,
+			Detail:         it.Detail,
+			Kind:           symbolKinds[it.Kind],
+			Range:          toRange(it.Range),
+			SelectionRange: toRange(it.SelectionRange),
+		}
+		if len(it.Children) > 0 {
+			s.Children = toSymbols(it.Children)
+		}
+		symbols = append(symbols, s)
+	}
+	return symbols
+}
@@ -0,0 +1,97 @@
+package d2lsp
+
+import (
+	"strings"
+
+	"oss.terrastruct.com/d2/d2ir"
+)
+
+// substitutionPrefix returns the text typed after the ${ of an unterminated substitution at
+// the end of prefix.
+func substitutionPrefix(prefix string) (string, bool) {
+	i := strings.LastIndex(prefix, "${")
+	if i < 0 {
+		return "", false
+	}
+	typed := strings.TrimPrefix(prefix[i+2:], "...")
+	if strings.Contains(typed, "}") {
+		return "", false
+	}
+	return typed, true
+}
+
+// boardChain returns the boards that path goes through, starting with m, the root board. It
+// returns nil if one of them does not exist.
+func boardChain(m *d2ir.Map, path []string) []*d2ir.Map {
+	boards := []*d2ir.Map{m}
+	for i := 0; i+1 < len(path); i++ {
+		switch path[i] {
+		case "layers", "scenarios", "steps":
+			f := m.GetField(path[i], path[i+1])
+			if f == nil || f.Map() == nil {
+				return nil
+			}
+			m = f.Map()
+			boards = append(boards, m)
+			i++
+		}
+	}
+	return boards
+}
+
+// varCompletions returns the vars in scope at path for a substitution starting with typed.
+// The vars of a board are in scope within its boards unless they declare a var of the same
+// name. Typing a var that is a map followed by a dot completes the vars within it.
+func varCompletions(doc *document, path []string, typed string) []CompletionItem {
+	if doc.ir == nil {
+		return nil
+	}
+	boards := boardChain(doc.ir, path)
+	parents := strings.Split(typed, ".")
+	parents = parents[:len(parents)-1]
+
+	var items []CompletionItem
+	seen := make(map[string]struct{})
+	for i := len(boards) - 1; i >= 0; i-- {
+		f := boards[i].GetField(append([]string{"vars"}, parents...)...)
+		if f == nil || f.Map() == nil {
+			continue
+		}
+		for _, v := range f.Map().Fields {
+			if len(parents) == 0 && v.Name == "d2-config" {
+				continue
+			}
+			if _, ok := seen[v.Name]; ok {
+				continue
+			}
+			seen[v.Name] = struct{}{}
+			items = append(items, CompletionItem{
+				Label:         v.Name,
+				Kind:          CompletionKindVariable,
+				Detail:        varValue(v),
+				Documentation: classDocs(v),
+			})
+		}
+	}
+	return items
+}
+
+// varValue returns the resolved value of v as it is substituted.
+func varValue(v *d2ir.Field) string {
+	if arr, ok := v.Composite.(*d2ir.Array); ok {
+		values := make([]string, 0, len(arr.Values))
+		for _, val := range arr.Values {
+			if s, ok := val.(*d2ir.Scalar); ok {
+				values = append(values, s.Value.ScalarString())
+			}
+		}
+		return "[" + strings.Join(values, "; ") + "]"
+	}
+	if v.Primary() != nil {
+		return v.Primary().Value.ScalarString()
+	}
+	if v.Map() != nil {
+		return "map"
+	}
+	return ""
+}
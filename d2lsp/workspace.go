@@ -0,0 +1,161 @@
+package d2lsp
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2index"
+)
+
+// initWorkspace indexes the scripts of the workspace of params, if any.
+func (s *server) initWorkspace(params InitializeParams) {
+	switch {
+	case params.RootURI != "":
+		s.root = uriToPath(params.RootURI)
+	case params.RootPath != "":
+		s.root = params.RootPath
+	case len(params.WorkspaceFolders) > 0:
+		s.root = uriToPath(params.WorkspaceFolders[0].URI)
+	default:
+		return
+	}
+	ix, err := d2index.New(workspaceFS{root: s.root, docs: s.docs}, &d2index.Options{
+		UTF16Pos: true,
+	})
+	if err != nil {
+		s.logf("%v", err)
+		return
+	}
+	s.index = ix
+}
+
+// workspaceFS serves the files of the workspace by their slash separated path relative to
+// root, using the text of open documents over that on disk.
+type workspaceFS struct {
+	root string
+	docs map[string]*document
+}
+
+func (fsys workspaceFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return docFS{docs: fsys.docs}.Open(filepath.Join(fsys.root, filepath.FromSlash(name)))
+}
+
+// rel returns the path of the file at p within the index.
+func (s *server) rel(p string) (string, bool) {
+	if s.index == nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(s.root, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+	return rel, path.Ext(rel) == ".d2"
+}
+
+// reindex reads the file at p from disk again after its document is closed.
+func (s *server) reindex(p string) {
+	rel, ok := s.rel(p)
+	if !ok {
+		return
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		s.index.Remove(rel)
+		return
+	}
+	s.index.Update(rel, string(b))
+}
+
+// workspaceIssues returns the diagnostics of the issues of the workspace by file path.
+func (s *server) workspaceIssues() map[string][]Diagnostic {
+	diags := make(map[string][]Diagnostic)
+	if s.index == nil {
+		return diags
+	}
+	for _, doc := range s.docs {
+		if rel, ok := s.rel(doc.path); ok {
+			s.index.Update(rel, doc.text)
+		}
+	}
+	for _, is := range s.index.Issues() {
+		d := Diagnostic{
+			Range:    toRange(is.Range),
+			Severity: SeverityError,
+			Source:   "d2",
+			Message:  strings.TrimPrefix(is.Message, is.Range.String()+": "),
+		}
+		if is.Warning {
+			d.Severity = SeverityWarning
+		}
+		p := filepath.Join(s.root, filepath.FromSlash(is.Range.Path))
+		diags[p] = append(diags[p], d)
+	}
+	return diags
+}
+
+// mergeDiagnostics appends to diags those of extra it does not have yet.
+func mergeDiagnostics(diags, extra []Diagnostic) []Diagnostic {
+outer:
+	for _, d := range extra {
+		for _, d2 := range diags {
+			if d2.Range == d.Range && d2.Message == d.Message {
+				continue outer
+			}
+		}
+		diags = append(diags, d)
+	}
+	return diags
+}
+
+// publishWorkspace publishes the diagnostics of files that are not open, clearing those of
+// files that no longer have any.
+func (s *server) publishWorkspace(diags map[string][]Diagnostic) error {
+	paths := make([]string, 0, len(diags))
+	for p := range diags {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	published := make(map[string]struct{}, len(paths))
+	for _, p := range paths {
+		uri := s.location(d2ast.Range{Path: p}).URI
+		published[uri] = struct{}{}
+		err := s.conn.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+			URI:         uri,
+			Diagnostics: diags[p],
+		})
+		if err != nil {
+			return err
+		}
+	}
+	stale := make([]string, 0, len(s.published))
+	for uri := range s.published {
+		if _, ok := published[uri]; !ok {
+			stale = append(stale, uri)
+		}
+	}
+	sort.Strings(stale)
+	for _, uri := range stale {
+		if _, ok := s.docs[uri]; ok {
+			continue
+		}
+		err := s.conn.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+			URI:         uri,
+			Diagnostics: []Diagnostic{},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	s.published = published
+	return nil
+}
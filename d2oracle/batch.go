@@ -0,0 +1,269 @@
+package d2oracle
+
+import (
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+	"reflect"
+	"strings"
+
+	"oss.terrastruct.com/util-go/xdefer"
+
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2format"
+	"oss.terrastruct.com/d2/d2graph"
+)
+
+type OpType string
+
+const (
+	OpCreate OpType = "create"
+	OpSet    OpType = "set"
+	OpMove   OpType = "move"
+	OpDelete OpType = "delete"
+	OpRename OpType = "rename"
+)
+
+// Op is a single edit within a batch passed to Apply.
+type Op struct {
+	Type OpType `json:"type"`
+	// BoardPath is the board the edit applies to. Empty means the root board.
+	BoardPath []string `json:"boardPath,omitempty"`
+	Key       string   `json:"key"`
+
+	// NewKey is the destination key for OpMove and the new name for OpRename.
+	NewKey string `json:"newKey,omitempty"`
+	// IncludeDescendants is only used by OpMove.
+	IncludeDescendants bool `json:"includeDescendants,omitempty"`
+	// Hint is only used by OpMove. See MoveWithHint.
+	Hint *PositionHint `json:"hint,omitempty"`
+
+	// Tag and Value are only used by OpSet.
+	Tag   *string `json:"tag,omitempty"`
+	Value *string `json:"value,omitempty"`
+}
+
+// OpResult is the outcome of a single Op within a batch.
+type OpResult struct {
+	// NewKey is set for OpCreate and OpRename to the key that was actually used, which
+	// may differ from the requested one if it had to be made unique.
+	NewKey string `json:"newKey,omitempty"`
+}
+
+// Apply applies ops in order with all-or-nothing semantics. If any op fails, the
+// returned error identifies it and g is left untouched. Otherwise the graph compiled
+// from the final AST is returned along with one result per op.
+//
+// The ops edit a copy of the AST of g that is compiled in place between them, without
+// formatting it. The result is formatted and compiled from its text once, after the last op.
+//
+// Editors that sync structured edits should prefer Apply over calling each function
+// individually so that a partially applied batch never reaches the user.
+func Apply(g *d2graph.Graph, ops []Op) (_ *d2graph.Graph, _ []OpResult, err error) {
+	defer xdefer.Errorf(&err, "failed to apply batch")
+
+	fsys := g.FS
+	// Every op mutates the AST it is given in place, so work on a copy to leave g
+	// untouched should any op fail.
+	g2, err := compileCopy(g.AST, &batchFS{FS: fsys})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	results := make([]OpResult, len(ops))
+	for i, op := range ops {
+		var newKey string
+		g2, newKey, err = applyOp(g2, op)
+		if err != nil {
+			return nil, nil, fmt.Errorf("op %d (%s): %w", i, op.Type, err)
+		}
+		results[i].NewKey = newKey
+	}
+
+	g2.FS = fsys
+	g2, err = recompile(g2)
+	if err != nil {
+		return nil, nil, err
+	}
+	return g2, results, nil
+}
+
+// batchFS is the file system of the graphs edited by Apply. recompile compiles their AST
+// without formatting it. It wraps FS, or the OS if nil.
+type batchFS struct {
+	FS fs.FS
+}
+
+func (b *batchFS) Open(name string) (fs.File, error) {
+	if b.FS == nil {
+		return os.Open(name)
+	}
+	return b.FS.Open(name)
+}
+
+// compileCopy compiles a copy of ast with fsys.
+func compileCopy(ast *d2ast.Map, fsys fs.FS) (*d2graph.Graph, error) {
+	ast2 := copyAST(ast)
+	g2, _, err := d2compiler.CompileAST(ast2, &d2compiler.CompileOptions{
+		FS: fsys,
+	})
+	if err != nil {
+		// The positions of the error are only right in the formatted text.
+		s := d2format.Format(ast2)
+		_, _, err2 := d2compiler.Compile(ast.Range.Path, strings.NewReader(s), &d2compiler.CompileOptions{
+			FS: fsys,
+		})
+		if err2 != nil {
+			err = err2
+		}
+		return nil, fmt.Errorf("failed to recompile:\n%s\n%w", s, err)
+	}
+	return g2, nil
+}
+
+// copyAST returns a deep copy of ast.
+//
+// Nodes added by edits have no path in their range, or the range of the node they're based
+// on. The copy gives them the path of ast so that they're not taken for imported ones, and
+// start bytes that keep Range.Before ordering all nodes as they'd be in the formatted text.
+// Copying a copy changes nothing. Lines and columns are kept as d2format uses them.
+func copyAST(ast *d2ast.Map) *d2ast.Map {
+	c := &astCopier{
+		path:   ast.Range.Path,
+		copies: make(map[any]reflect.Value),
+	}
+	return c.copy(reflect.ValueOf(ast)).Interface().(*d2ast.Map)
+}
+
+type astCopier struct {
+	path string
+	// copies maps the pointers copied to their copy, as nodes may be shared, such as the key
+	// path between two edges of a chain.
+	copies map[any]reflect.Value
+	// byte is the byte that the next node starts at or after.
+	byte int
+}
+
+var (
+	rangeType  = reflect.TypeOf(d2ast.Range{})
+	bigRatType = reflect.TypeOf(big.Rat{})
+
+	blockStringType = reflect.TypeOf(d2ast.BlockString{})
+	importType      = reflect.TypeOf(d2ast.Import{})
+)
+
+func (c *astCopier) copy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		if v2, ok := c.copies[v.Interface()]; ok {
+			return v2
+		}
+		v2 := reflect.New(v.Type().Elem())
+		c.copies[v.Interface()] = v2
+		if v.Type().Elem() == bigRatType {
+			v2.Interface().(*big.Rat).Set(v.Interface().(*big.Rat))
+			return v2
+		}
+		v2.Elem().Set(c.copy(v.Elem()))
+		return v2
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		v2 := reflect.New(v.Type()).Elem()
+		v2.Set(c.copy(v.Elem()))
+		return v2
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		v2 := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			v2.Index(i).Set(c.copy(v.Index(i)))
+		}
+		return v2
+	case reflect.Struct:
+		v2 := reflect.New(v.Type()).Elem()
+		v2.Set(v)
+		rf, hasRange := v.Type().FieldByName("Range")
+		hasRange = hasRange && rf.Type == rangeType
+		var r *d2ast.Range
+		var added bool
+		// d2format and d2ir take block strings and imports without a range for added ones.
+		if hasRange && (v.Type() == blockStringType || v.Type() == importType) && v2.FieldByIndex(rf.Index).IsZero() {
+			hasRange = false
+		}
+		if hasRange {
+			r = v2.FieldByIndex(rf.Index).Addr().Interface().(*d2ast.Range)
+			added = c.start(r)
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if hasRange && i == rf.Index[0] {
+				continue
+			}
+			v2.Field(i).Set(c.copy(v.Field(i)))
+		}
+		if hasRange {
+			c.end(r, added)
+		}
+		if mk, ok := v2.Addr().Interface().(*d2ast.Key); ok && mk.Value.Map != nil && len(mk.Value.Map.Nodes) == 0 {
+			// d2format drops empty maps.
+			mk.Value = d2ast.ValueBox{}
+		}
+		return v2
+	default:
+		return v
+	}
+}
+
+// start sets the start byte of r, reporting whether r is the range of an added node. Nodes
+// starting at the same byte, such as keys and their first key path, keep doing so.
+func (c *astCopier) start(r *d2ast.Range) (added bool) {
+	added = r.Path != c.path
+	r.Path = c.path
+	if !added && r.Start.Byte > c.byte {
+		c.byte = r.Start.Byte
+	}
+	r.Start.Byte = c.byte
+	return added
+}
+
+// end sets the end byte of r if it's the range of an added node. The nodes after it start
+// after it.
+func (c *astCopier) end(r *d2ast.Range, added bool) {
+	if c.byte <= r.Start.Byte {
+		c.byte = r.Start.Byte + 1
+	}
+	if added {
+		r.End.Byte = c.byte
+	}
+}
+
+func applyOp(g *d2graph.Graph, op Op) (_ *d2graph.Graph, newKey string, err error) {
+	switch op.Type {
+	case OpCreate:
+		return Create(g, op.BoardPath, op.Key)
+	case OpSet:
+		g, err = Set(g, op.BoardPath, op.Key, op.Tag, op.Value)
+		return g, "", err
+	case OpMove:
+		if op.Hint != nil {
+			g, err = MoveWithHint(g, op.BoardPath, op.Key, op.NewKey, op.IncludeDescendants, *op.Hint)
+		} else {
+			g, err = Move(g, op.BoardPath, op.Key, op.NewKey, op.IncludeDescendants)
+		}
+		return g, "", err
+	case OpDelete:
+		g, err = Delete(g, op.BoardPath, op.Key)
+		return g, "", err
+	case OpRename:
+		return Rename(g, op.BoardPath, op.Key, op.NewKey)
+	default:
+		return nil, "", fmt.Errorf("unknown op type %q", op.Type)
+	}
+}
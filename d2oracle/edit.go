@@ -157,6 +157,9 @@ func ReconnectEdge(g *d2graph.Graph, boardPath []string, edgeKey string, srcKey,
 	if !ok {
 		return nil, errors.New("edge not found")
 	}
+	if p := importedEdgePath(g, edge); p != "" {
+		return nil, ImportedError{Path: p}
+	}
 
 	if srcKey != nil {
 		if edge.Src.AbsID() == *srcKey {
@@ -247,7 +250,11 @@ func ReconnectEdge(g *d2graph.Graph, boardPath []string, edgeKey string, srcKey,
 					mk2.Edges = ref.MapKey.Edges[ref.MapKeyEdgeIndex+1:]
 					ref.Scope.InsertAfter(ref.MapKey, mk2)
 				}
-				ref.MapKey.Edges = ref.MapKey.Edges[:ref.MapKeyEdgeIndex]
+				if ref.MapKeyEdgeIndex == 0 {
+					deleteFromMap(ref.Scope, ref.MapKey)
+				} else {
+					ref.MapKey.Edges = ref.MapKey.Edges[:ref.MapKeyEdgeIndex]
+				}
 			}
 		}
 
@@ -304,6 +311,9 @@ func pathFromScopeObj(g *d2graph.Graph, key *d2ast.Key, fromScope *d2graph.Objec
 }
 
 func recompile(g *d2graph.Graph) (*d2graph.Graph, error) {
+	if _, ok := g.FS.(*batchFS); ok {
+		return compileCopy(g.AST, g.FS)
+	}
 	s := d2format.Format(g.AST)
 	g2, _, err := d2compiler.Compile(g.AST.Range.Path, strings.NewReader(s), &d2compiler.CompileOptions{
 		FS: g.FS,
@@ -631,6 +641,16 @@ func _set(g *d2graph.Graph, baseAST *d2ast.Map, key string, tag, value *string)
 					attrs.Tooltip.MapKey.SetScalar(mk.Value.ScalarBox())
 					return nil
 				}
+			case "animate":
+				if inlined(attrs.Animate) {
+					attrs.Animate.MapKey.SetScalar(mk.Value.ScalarBox())
+					return nil
+				}
+			case "shape-path":
+				if inlined(attrs.ShapePath) {
+					attrs.ShapePath.MapKey.SetScalar(mk.Value.ScalarBox())
+					return nil
+				}
 			case "width":
 				if inlined(attrs.WidthAttr) {
 					attrs.WidthAttr.MapKey.SetScalar(mk.Value.ScalarBox())
@@ -1320,6 +1340,8 @@ func deleteReserved(g *d2graph.Graph, boardPath []string, baseAST *d2ast.Map, mk
 
 			if id == "near" ||
 				id == "tooltip" ||
+				id == "animate" ||
+				id == "shape-path" ||
 				id == "icon" ||
 				id == "width" ||
 				id == "height" ||
@@ -1630,6 +1652,9 @@ func ensureNode(g *d2graph.Graph, excludedEdges []*d2ast.Edge, scopeObj *d2graph
 	}
 }
 
+// Rename renames the object, edge or board at key. References to a renamed object in
+// scenarios and steps that inherit it are renamed too, as are the globs that only match it and
+// links to a renamed board.
 func Rename(g *d2graph.Graph, boardPath []string, key, newName string) (_ *d2graph.Graph, newKey string, err error) {
 	defer xdefer.Errorf(&err, "failed to rename %#v to %#v", key, newName)
 
@@ -1647,6 +1672,17 @@ func Rename(g *d2graph.Graph, boardPath []string, key, newName string) (_ *d2gra
 		}
 	}
 
+	if len(mk.Edges) == 0 && mk.Key != nil && len(mk.Key.Path) == 2 {
+		if _, ok := d2graph.BoardKeywords[mk.Key.Path[0].Unbox().ScalarString()]; ok {
+			return renameBoard(g, boardPath, boardG, mk.Key.Path[0].Unbox().ScalarString(), mk.Key.Path[1].Unbox().ScalarString(), newName)
+		}
+	}
+
+	var oldIDA []string
+	if len(mk.Edges) == 0 && mk.Key != nil {
+		oldIDA = mk.Key.IDA()
+	}
+
 	if len(mk.Edges) > 0 && mk.EdgeKey == nil {
 		// TODO: Not a fan of this dual interpretation depending on mk.Edges.
 		// Maybe we remove Rename and just have Move.
@@ -1678,7 +1714,355 @@ func Rename(g *d2graph.Graph, boardPath []string, key, newName string) (_ *d2gra
 	}
 
 	g, err = move(g, boardPath, key, d2format.Format(mk), false)
-	return g, newName, err
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Scenarios and steps inherit the objects of their parent board, so references to the
+	// renamed object within them must be renamed too.
+	if oldIDA != nil {
+		boardG = GetBoardGraph(g, boardPath)
+		var renamed bool
+		if boardG != nil {
+			baseAST := boardG.BaseAST
+			if baseAST == nil {
+				baseAST = boardG.AST
+			}
+			renamed = renameGlobs(boardG, baseAST, nil, oldIDA, newName)
+			if renameInheritedRefs(boardG, oldIDA, newName) {
+				renamed = true
+			}
+		}
+		if renamed {
+			g, err = recompile(g)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		recordRename(g, boardPath, oldIDA, newName)
+	}
+	return g, newName, nil
+}
+
+func renameInheritedRefs(g *d2graph.Graph, oldIDA []string, newName string) (renamed bool) {
+	for _, b := range append(append([]*d2graph.Graph{}, g.Scenarios...), g.Steps...) {
+		if b.BaseAST != nil && renameKeyPrefix(b.BaseAST, nil, oldIDA, newName) {
+			renamed = true
+		}
+		if b.BaseAST != nil && renameGlobs(b, b.BaseAST, nil, oldIDA, newName) {
+			renamed = true
+		}
+		if renameInheritedRefs(b, oldIDA, newName) {
+			renamed = true
+		}
+	}
+	return renamed
+}
+
+// renameKeyPrefix renames the last element of oldIDA to newName in every key of m that refers
+// to oldIDA or one of its descendants. prefix is the path of m within its board.
+func renameKeyPrefix(m *d2ast.Map, prefix, oldIDA []string, newName string) (renamed bool) {
+	renamePath := func(prefix []string, kp *d2ast.KeyPath) {
+		if kp == nil {
+			return
+		}
+		i := len(oldIDA) - 1 - len(prefix)
+		if i < 0 || len(kp.Path) <= i {
+			return
+		}
+		ida := append(append([]string{}, prefix...), kp.IDA()...)
+		for j := range oldIDA {
+			if !strings.EqualFold(ida[j], oldIDA[j]) {
+				return
+			}
+		}
+		kp.Path[i] = d2ast.MakeValueBox(d2ast.RawString(newName, true)).StringBox()
+		renamed = true
+	}
+
+	for _, n := range m.Nodes {
+		if n.MapKey == nil {
+			continue
+		}
+		mk := n.MapKey
+		if mk.Key != nil && len(mk.Key.Path) > 0 {
+			head := mk.Key.Path[0].Unbox().ScalarString()
+			if _, ok := d2graph.BoardKeywords[head]; ok {
+				// Nested boards are handled by renameInheritedRefs.
+				continue
+			}
+		}
+		if len(mk.Edges) > 0 {
+			edgePrefix := prefix
+			if mk.Key != nil {
+				renamePath(prefix, mk.Key)
+				edgePrefix = append(append([]string{}, prefix...), mk.Key.IDA()...)
+			}
+			for _, e := range mk.Edges {
+				renamePath(edgePrefix, e.Src)
+				renamePath(edgePrefix, e.Dst)
+			}
+			continue
+		}
+		if mk.Key == nil {
+			continue
+		}
+		renamePath(prefix, mk.Key)
+		if mk.Value.Map != nil {
+			if renameKeyPrefix(mk.Value.Map, append(append([]string{}, prefix...), mk.Key.IDA()...), oldIDA, newName) {
+				renamed = true
+			}
+		}
+	}
+	return renamed
+}
+
+// renameGlobs replaces with newName every glob in the keys of m that matches the last element
+// of oldIDA unambiguously, so that the key keeps referring to the renamed object. A glob is
+// unambiguous if it does not already match newName and matches no other child of the parent
+// of oldIDA in g, e.g. x* when renaming x to y. The elements before it must be literal. prefix
+// is the path of m within its board.
+func renameGlobs(g *d2graph.Graph, m *d2ast.Map, prefix, oldIDA []string, newName string) (renamed bool) {
+	oldName := oldIDA[len(oldIDA)-1]
+	parent := g.Root
+	if len(oldIDA) > 1 {
+		var ok bool
+		parent, ok = g.Root.HasChild(oldIDA[:len(oldIDA)-1])
+		if !ok {
+			return false
+		}
+	}
+	unambiguous := func(pattern []string) bool {
+		if d2ast.IsDoubleGlob(pattern) || d2ast.IsTripleGlob(pattern) {
+			return false
+		}
+		if !d2ir.MatchPattern(oldName, pattern) || d2ir.MatchPattern(newName, pattern) {
+			return false
+		}
+		for _, child := range parent.ChildrenArray {
+			if strings.EqualFold(child.ID, oldName) || strings.EqualFold(child.ID, newName) {
+				continue
+			}
+			if d2ir.MatchPattern(child.ID, pattern) {
+				return false
+			}
+		}
+		return true
+	}
+	isGlob := func(sb *d2ast.StringBox) bool {
+		return sb.UnquotedString != nil && len(sb.UnquotedString.Pattern) > 0
+	}
+	renamePath := func(prefix []string, kp *d2ast.KeyPath) {
+		if kp == nil {
+			return
+		}
+		i := len(oldIDA) - 1 - len(prefix)
+		if i < 0 || len(kp.Path) <= i {
+			return
+		}
+		for j := range prefix {
+			if !strings.EqualFold(prefix[j], oldIDA[j]) {
+				return
+			}
+		}
+		for j := 0; j < i; j++ {
+			if isGlob(kp.Path[j]) || !strings.EqualFold(kp.Path[j].Unbox().ScalarString(), oldIDA[len(prefix)+j]) {
+				return
+			}
+		}
+		if !isGlob(kp.Path[i]) || !unambiguous(kp.Path[i].UnquotedString.Pattern) {
+			return
+		}
+		kp.Path[i] = d2ast.MakeValueBox(d2ast.RawString(newName, true)).StringBox()
+		renamed = true
+	}
+
+	for _, n := range m.Nodes {
+		if n.MapKey == nil {
+			continue
+		}
+		mk := n.MapKey
+		if mk.Key != nil && len(mk.Key.Path) > 0 {
+			head := mk.Key.Path[0].Unbox().ScalarString()
+			if _, ok := d2graph.BoardKeywords[head]; ok {
+				// Nested boards are handled by renameInheritedRefs.
+				continue
+			}
+		}
+		if len(mk.Edges) > 0 {
+			edgePrefix := prefix
+			if mk.Key != nil {
+				renamePath(prefix, mk.Key)
+				edgePrefix = append(append([]string{}, prefix...), mk.Key.IDA()...)
+			}
+			for _, e := range mk.Edges {
+				renamePath(edgePrefix, e.Src)
+				renamePath(edgePrefix, e.Dst)
+			}
+			continue
+		}
+		if mk.Key == nil {
+			continue
+		}
+		renamePath(prefix, mk.Key)
+		if mk.Value.Map != nil {
+			if renameGlobs(g, mk.Value.Map, append(append([]string{}, prefix...), mk.Key.IDA()...), oldIDA, newName) {
+				renamed = true
+			}
+		}
+	}
+	return renamed
+}
+
+// renameBoard renames the board kind.oldName declared in the board at boardPath and updates
+// every link pointing into it.
+func renameBoard(g *d2graph.Graph, boardPath []string, boardG *d2graph.Graph, kind, oldName, newName string) (_ *d2graph.Graph, _ string, err error) {
+	var boards []*d2graph.Graph
+	switch kind {
+	case "layers":
+		boards = boardG.Layers
+	case "scenarios":
+		boards = boardG.Scenarios
+	case "steps":
+		boards = boardG.Steps
+	}
+	var found bool
+	for _, b := range boards {
+		if b.Name == oldName {
+			found = true
+		} else if b.Name == newName {
+			return nil, "", fmt.Errorf("board %#v already exists", newName)
+		}
+	}
+	if !found {
+		return nil, "", fmt.Errorf("board %#v does not exist", oldName)
+	}
+	if oldName == newName {
+		return g, newName, nil
+	}
+
+	baseAST := boardG.BaseAST
+	if baseAST == nil {
+		baseAST = boardG.AST
+	}
+	newBox := d2ast.MakeValueBox(d2ast.RawString(newName, true)).StringBox()
+	for _, n := range baseAST.Nodes {
+		if n.MapKey == nil || n.MapKey.Key == nil || len(n.MapKey.Edges) > 0 || n.MapKey.Key.Path[0].Unbox().ScalarString() != kind {
+			continue
+		}
+		if len(n.MapKey.Key.Path) > 1 {
+			if n.MapKey.Key.Path[1].Unbox().ScalarString() == oldName {
+				n.MapKey.Key.Path[1] = newBox
+			}
+			continue
+		}
+		if n.MapKey.Value.Map == nil {
+			continue
+		}
+		for _, n2 := range n.MapKey.Value.Map.Nodes {
+			if n2.MapKey != nil && n2.MapKey.Key != nil && n2.MapKey.Key.Path[0].Unbox().ScalarString() == oldName {
+				n2.MapKey.Key.Path[0] = newBox
+			}
+		}
+	}
+
+	oldIDA := append(boardIDA(g, boardPath), kind, oldName)
+	updateLinks(g, oldIDA, newName)
+
+	g, err = recompile(g)
+	if err != nil {
+		return nil, "", err
+	}
+	return g, newName, nil
+}
+
+// boardIDA returns the absolute path of the board at boardPath as it appears in links, e.g.
+// root.layers.x.scenarios.y
+func boardIDA(g *d2graph.Graph, boardPath []string) []string {
+	ida := []string{"root"}
+	for _, name := range boardPath {
+		kind, b := childBoard(g, name)
+		if b == nil {
+			break
+		}
+		ida = append(ida, kind, name)
+		g = b
+	}
+	return ida
+}
+
+func childBoard(g *d2graph.Graph, name string) (kind string, _ *d2graph.Graph) {
+	for _, b := range g.Layers {
+		if b.Name == name {
+			return "layers", b
+		}
+	}
+	for _, b := range g.Scenarios {
+		if b.Name == name {
+			return "scenarios", b
+		}
+	}
+	for _, b := range g.Steps {
+		if b.Name == name {
+			return "steps", b
+		}
+	}
+	return "", nil
+}
+
+// updateLinks renames the last element of oldIDA to newName in every link of every board of g
+// pointing to oldIDA or one of its descendants.
+func updateLinks(g *d2graph.Graph, oldIDA []string, newName string) {
+	update := func(link *d2graph.Scalar) {
+		if link == nil || link.MapKey == nil || link.MapKey.Value.ScalarBox().Unbox() == nil {
+			return
+		}
+		abs, err := d2parser.ParseKey(link.Value)
+		if err != nil {
+			return
+		}
+		absIDA := abs.IDA()
+		if len(absIDA) < len(oldIDA) {
+			return
+		}
+		for i := range oldIDA {
+			if absIDA[i] != oldIDA[i] {
+				return
+			}
+		}
+		rel, err := d2parser.ParseKey(link.MapKey.Value.ScalarBox().Unbox().ScalarString())
+		if err != nil {
+			return
+		}
+		// The value as written is either absolute or a suffix of the absolute link after any
+		// leading underscores.
+		underscores := 0
+		for underscores < len(rel.Path) && rel.Path[underscores].Unbox().ScalarString() == "_" {
+			underscores++
+		}
+		i := len(oldIDA) - 1 - (len(absIDA) - (len(rel.Path) - underscores)) + underscores
+		if i < underscores || i >= len(rel.Path) {
+			return
+		}
+		rel.Path[i] = d2ast.MakeValueBox(d2ast.RawString(newName, true)).StringBox()
+		link.MapKey.Value = d2ast.MakeValueBox(d2ast.FlatUnquotedString(d2format.Format(rel)))
+	}
+
+	for _, obj := range g.Objects {
+		update(obj.Link)
+	}
+	for _, e := range g.Edges {
+		update(e.Link)
+	}
+	for _, b := range g.Layers {
+		updateLinks(b, oldIDA, newName)
+	}
+	for _, b := range g.Scenarios {
+		updateLinks(b, oldIDA, newName)
+	}
+	for _, b := range g.Steps {
+		updateLinks(b, oldIDA, newName)
+	}
 }
 
 func trimReservedSuffix(path []*d2ast.StringBox) []*d2ast.StringBox {
@@ -1696,6 +2080,96 @@ func Move(g *d2graph.Graph, boardPath []string, key, newKey string, includeDesce
 	return move(g, boardPath, key, newKey, includeDescendants)
 }
 
+// PositionHint records where a moved object was dropped so that it stays there across
+// recompiles instead of wherever the layout engine would place it. Exactly one of Near or
+// Top and Left should be set.
+type PositionHint struct {
+	// Near is another object's key or a near constant like top-center.
+	Near string `json:"near,omitempty"`
+	// Top and Left pin the object, relative to its container. Only layout engines with
+	// the top_left feature support this.
+	Top  *int `json:"top,omitempty"`
+	Left *int `json:"left,omitempty"`
+}
+
+// MoveWithHint is Move followed by recording hint on the moved object. Any position set
+// previously that would conflict with hint, near when pinning and top and left otherwise, is
+// removed.
+func MoveWithHint(g *d2graph.Graph, boardPath []string, key, newKey string, includeDescendants bool, hint PositionHint) (_ *d2graph.Graph, err error) {
+	defer xdefer.Errorf(&err, "failed to move: %#v to %#v", key, newKey)
+
+	mk, err := d2parser.ParseMapKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(mk.Edges) > 0 {
+		return nil, errors.New("position hints only apply to objects")
+	}
+	pin := hint.Top != nil || hint.Left != nil
+	if pin && hint.Near != "" {
+		return nil, errors.New("position hint cannot both pin and set near")
+	}
+	if !pin && hint.Near == "" {
+		return nil, errors.New("empty position hint")
+	}
+
+	boardG := GetBoardGraph(g, boardPath)
+	if boardG == nil {
+		return nil, fmt.Errorf("board %v not found", boardPath)
+	}
+	if key != newKey {
+		// move makes newKey unique the same way so this is the key the object ends up at.
+		newKey, _, err = generateUniqueKey(boardG, newKey, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		g, err = move(g, boardPath, key, newKey, includeDescendants)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	obj := GetObj(g, boardPath, newKey)
+	if obj == nil {
+		return nil, fmt.Errorf("moved object %#v not found", newKey)
+	}
+	if pin {
+		if obj.NearKey != nil {
+			g, err = Delete(g, boardPath, newKey+".near")
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, attr := range []struct {
+			name string
+			v    *int
+		}{{"top", hint.Top}, {"left", hint.Left}} {
+			if attr.v == nil {
+				continue
+			}
+			g, err = Set(g, boardPath, newKey+"."+attr.name, nil, go2.Pointer(strconv.Itoa(*attr.v)))
+			if err != nil {
+				return nil, err
+			}
+		}
+		return g, nil
+	}
+
+	if obj.Top != nil {
+		g, err = Delete(g, boardPath, newKey+".top")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if obj.Left != nil {
+		g, err = Delete(g, boardPath, newKey+".left")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return Set(g, boardPath, newKey+".near", nil, &hint.Near)
+}
+
 func move(g *d2graph.Graph, boardPath []string, key, newKey string, includeDescendants bool) (*d2graph.Graph, error) {
 	if key == newKey {
 		return g, nil
@@ -1752,6 +2226,9 @@ func move(g *d2graph.Graph, boardPath []string, key, newKey string, includeDesce
 		if ok {
 			return nil, fmt.Errorf("to edge already exists")
 		}
+		if p := importedEdgePath(g, e); p != "" {
+			return nil, ImportedError{Path: p}
+		}
 
 		for i := len(e.References) - 1; i >= 0; i-- {
 			ref := e.References[i]
@@ -1771,6 +2248,12 @@ func move(g *d2graph.Graph, boardPath []string, key, newKey string, includeDesce
 
 	isCrossScope := strings.Join(ak[:len(ak)-1], ".") != strings.Join(ak2[:len(ak2)-1], ".")
 
+	if obj, ok := boardG.Root.HasChild(ak); ok {
+		if p := importedObjPath(g, obj); p != "" {
+			return nil, ImportedError{Path: p}
+		}
+	}
+
 	if isCrossScope && !includeDescendants {
 		boardG, err = renameConflictsToParent(boardG, mk.Key)
 		if err != nil {
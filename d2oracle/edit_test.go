@@ -1,6 +1,7 @@
 package d2oracle_test
 
 import (
+	"errors"
 	"fmt"
 	"path/filepath"
 	"strconv"
@@ -3506,6 +3507,252 @@ scenarios: {
 
 			expErr: `failed to rename "x" to "b": operation would modify AST outside of given scope`,
 		},
+		{
+			name: "scenarios-inherited",
+
+			text: `a.x -> b
+
+scenarios: {
+  s: {
+    a.x.style.fill: green
+    b: {a}
+    a: {x -> y}
+  }
+  t: {
+    steps: {
+      1: {
+        a -> c
+      }
+    }
+  }
+}
+`,
+			key:     "a",
+			newName: "z",
+
+			exp: `z.x -> b
+
+scenarios: {
+  s: {
+    z.x.style.fill: green
+    b: {a}
+    z: {x -> y}
+  }
+  t: {
+    steps: {
+      1: {
+        z -> c
+      }
+    }
+  }
+}
+`,
+		},
+		{
+			name: "scenarios-inherited-nested",
+
+			text: `a.x
+a.*.style.fill: red
+
+scenarios: {
+  s: {
+    a: {
+      x.style.fill: green
+      y
+    }
+    *.x.style.stroke: blue
+  }
+}
+`,
+			key:     "a.x",
+			newName: "z",
+
+			exp: `a.z
+a.*.style.fill: red
+
+scenarios: {
+  s: {
+    a: {
+      z.style.fill: green
+      y
+    }
+    *.x.style.stroke: blue
+  }
+}
+`,
+		},
+		{
+			name: "globs",
+
+			text: `a.x
+b
+a*.style.fill: red
+a.*.style.stroke: blue
+*.style.opacity: 0.5
+
+scenarios: {
+  s: {
+    a*.style.fill: green
+    a.x*.style.stroke: red
+  }
+}
+`,
+			key:     "a",
+			newName: "z",
+
+			exp: `z.x
+b
+z.style.fill: red
+z.*.style.stroke: blue
+*.style.opacity: 0.5
+
+scenarios: {
+  s: {
+    z.style.fill: green
+    z.x*.style.stroke: red
+  }
+}
+`,
+		},
+		{
+			name: "globs-ambiguous",
+
+			text: `a.x
+ab
+a*.style.fill: red
+a.x*.style.stroke: blue
+`,
+			key:     "a",
+			newName: "z",
+
+			exp: `z.x
+ab
+a*.style.fill: red
+z.x*.style.stroke: blue
+`,
+		},
+		{
+			name: "globs-nested",
+
+			text: `a: {
+  x
+  y
+}
+a.x*.style.fill: red
+a: {
+  *.style.stroke: blue
+  x*.style.opacity: 0.5
+}
+`,
+			key:     "a.x",
+			newName: "z",
+
+			exp: `a: {
+  z
+  y
+}
+a.z.style.fill: red
+a: {
+  *.style.stroke: blue
+  z.style.opacity: 0.5
+}
+`,
+		},
+		{
+			name: "board",
+
+			text: `k.link: layers.l.layers.n
+layers: {
+  l: {
+    layers: {
+      n: {
+        q.link: _
+        w: {link: _._.layers.l.layers.n}
+        r.link: _._.layers.l
+        s.link: https://example.com/layers/l
+      }
+    }
+  }
+}
+`,
+			key:     "layers.l",
+			newName: "m",
+
+			exp: `k.link: layers.m.layers.n
+
+layers: {
+  m: {
+    layers: {
+      n: {
+        q.link: _
+        w: {link: _._.layers.m.layers.n}
+        r.link: _._.layers.m
+        s.link: https://example.com/layers/l
+      }
+    }
+  }
+}
+`,
+		},
+		{
+			name: "board-nested",
+
+			text: `x.link: layers.l.scenarios.n
+layers: {
+  l: {
+    scenarios: {
+      n: {
+        r.link: _
+      }
+    }
+  }
+}
+`,
+			boardPath: []string{"l"},
+			key:       "scenarios.n",
+			newName:   "o",
+
+			exp: `x.link: layers.l.scenarios.o
+
+layers: {
+  l: {
+    scenarios: {
+      o: {
+        r.link: _
+      }
+    }
+  }
+}
+`,
+		},
+		{
+			name: "board-conflict",
+
+			text: `layers: {
+  l: {a}
+  m: {b}
+}
+`,
+			key:     "layers.l",
+			newName: "m",
+
+			expErr: `failed to rename "layers.l" to "m": board "m" already exists`,
+		},
+		{
+			name: "import",
+
+			text: `...@meow
+q
+`,
+			fsTexts: map[string]string{
+				"meow.d2": `x -> y
+`,
+			},
+			key:     "x",
+			newName: "z",
+
+			expErr: `failed to rename "x" to "z": key is declared in imported file "meow.d2"`,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -7917,6 +8164,614 @@ layers: {
 	}
 }
 
+func TestApply(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+
+		text string
+		ops  []d2oracle.Op
+
+		expErr     string
+		exp        string
+		assertions func(t *testing.T, g *d2graph.Graph)
+	}{
+		{
+			name: "base",
+			text: `a -> b
+`,
+			ops: []d2oracle.Op{
+				{Type: d2oracle.OpCreate, Key: "c"},
+				{Type: d2oracle.OpSet, Key: "c.shape", Value: go2.Pointer("circle")},
+				{Type: d2oracle.OpRename, Key: "a", NewKey: "x"},
+				{Type: d2oracle.OpMove, Key: "c", NewKey: "b.c"},
+				{Type: d2oracle.OpDelete, Key: "(x -> b)[0]"},
+			},
+			exp: `x
+b: {
+  c: {shape: circle}
+}
+`,
+		},
+		{
+			name: "boards",
+			text: `a
+layers: {
+  x: {
+    b
+  }
+}
+`,
+			ops: []d2oracle.Op{
+				{Type: d2oracle.OpCreate, BoardPath: []string{"x"}, Key: "c"},
+				{Type: d2oracle.OpSet, Key: "a.style.fill", Value: go2.Pointer("red")},
+			},
+			exp: `a: {style.fill: red}
+
+layers: {
+  x: {
+    b
+    c
+  }
+}
+`,
+		},
+		{
+			name: "added",
+			text: `a
+`,
+			ops: []d2oracle.Op{
+				{Type: d2oracle.OpCreate, Key: "b"},
+				{Type: d2oracle.OpCreate, Key: "b.c"},
+				{Type: d2oracle.OpRename, Key: "b", NewKey: "x"},
+				{Type: d2oracle.OpMove, Key: "a", NewKey: "x.a"},
+				{Type: d2oracle.OpCreate, Key: "x.a -> x.c"},
+				{Type: d2oracle.OpSet, Key: "(x.a -> x.c)[0].style.stroke", Value: go2.Pointer("red")},
+			},
+			exp: `x: {
+  c
+  a
+  a -> c: {style.stroke: red}
+}
+`,
+		},
+		{
+			name: "chain",
+			text: `p.x -> p.y -> p.z
+x
+`,
+			ops: []d2oracle.Op{
+				{Type: d2oracle.OpDelete, Key: "p"},
+				{Type: d2oracle.OpSet, Key: "y.shape", Value: go2.Pointer("circle")},
+			},
+			exp: `x 2 -> y -> z
+x
+y.shape: circle
+`,
+		},
+		{
+			name: "fail",
+			text: `a
+`,
+			ops: []d2oracle.Op{
+				{Type: d2oracle.OpCreate, Key: "b"},
+				{Type: d2oracle.OpSet, Key: "b.shape", Value: go2.Pointer("hexagonz")},
+			},
+			expErr: `failed to apply batch: op 1 (set): failed to set "b.shape" to "\"hexagonz\"": failed to recompile:
+a
+b: {shape: hexagonz}
+
+d2/testdata/d2oracle/TestApply/fail.d2:2:12: unknown shape "hexagonz"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			et := editTest{
+				text: tc.text,
+				testFunc: func(g *d2graph.Graph) (*d2graph.Graph, error) {
+					before := d2format.Format(g.AST)
+					g2, _, err := d2oracle.Apply(g, tc.ops)
+					if after := d2format.Format(g.AST); before != after {
+						t.Fatalf("expected input graph to be untouched:\n%s", after)
+					}
+					return g2, err
+				},
+
+				exp:        tc.exp,
+				expErr:     tc.expErr,
+				assertions: tc.assertions,
+			}
+			et.run(t)
+		})
+	}
+}
+
+func TestMoveWithHint(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name      string
+		boardPath []string
+
+		text   string
+		key    string
+		newKey string
+		hint   d2oracle.PositionHint
+
+		expErr string
+		exp    string
+	}{
+		{
+			name: "pin",
+
+			text: `a
+b
+`,
+			key:    "a",
+			newKey: "b.a",
+			hint: d2oracle.PositionHint{
+				Top:  go2.Pointer(10),
+				Left: go2.Pointer(20),
+			},
+
+			exp: `b: {
+  a: {
+    top: 10
+    left: 20
+  }
+}
+`,
+		},
+		{
+			name: "near",
+
+			text: `a
+b
+`,
+			key:    "a",
+			newKey: "a",
+			hint: d2oracle.PositionHint{
+				Near: "top-center",
+			},
+
+			exp: `a: {near: top-center}
+b
+`,
+		},
+		{
+			name: "replace-pin",
+
+			text: `a: {
+  top: 10
+  left: 20
+}
+b
+`,
+			key:    "a",
+			newKey: "c",
+			hint: d2oracle.PositionHint{
+				Near: "b",
+			},
+
+			exp: `c: {near: b}
+b
+`,
+		},
+		{
+			name: "replace-near",
+
+			text: `a: {near: bottom-right}
+`,
+			key:    "a",
+			newKey: "a",
+			hint: d2oracle.PositionHint{
+				Top: go2.Pointer(5),
+			},
+
+			exp: `a: {top: 5}
+`,
+		},
+		{
+			name: "unique",
+
+			text: `a
+b: {
+  a
+}
+`,
+			key:    "a",
+			newKey: "b.a",
+			hint: d2oracle.PositionHint{
+				Top: go2.Pointer(0),
+			},
+
+			exp: `b: {
+  a
+  a 2: {top: 0}
+}
+`,
+		},
+		{
+			name: "edge",
+
+			text: `a -> b
+`,
+			key:    "(a -> b)[0]",
+			newKey: "(a -> b)[0]",
+			hint: d2oracle.PositionHint{
+				Top: go2.Pointer(0),
+			},
+
+			expErr: `failed to move: "(a -> b)[0]" to "(a -> b)[0]": position hints only apply to objects`,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			et := editTest{
+				text: tc.text,
+				testFunc: func(g *d2graph.Graph) (*d2graph.Graph, error) {
+					return d2oracle.MoveWithHint(g, tc.boardPath, tc.key, tc.newKey, true, tc.hint)
+				},
+
+				exp:    tc.exp,
+				expErr: tc.expErr,
+			}
+			et.run(t)
+		})
+	}
+}
+
+func TestSetStyle(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name      string
+		boardPath []string
+
+		text  string
+		key   string
+		field d2oracle.StyleField
+		value string
+
+		expErr string
+		exp    string
+	}{
+		{
+			name: "base",
+
+			text: `a
+`,
+			key:   "a",
+			field: d2oracle.StyleFill,
+			value: "red",
+
+			exp: `a: {style.fill: red}
+`,
+		},
+		{
+			name: "edge",
+
+			text: `a -> b
+`,
+			key:   "(a -> b)[0]",
+			field: d2oracle.StyleStrokeDash,
+			value: "3",
+
+			exp: `a -> b: {style.stroke-dash: 3}
+`,
+		},
+		{
+			name: "invalid",
+
+			text: `a
+`,
+			key:   "a",
+			field: d2oracle.StyleOpacity,
+			value: "2",
+
+			expErr: `failed to set style opacity of "a": expected "opacity" to be a number between 0.0 and 1.0`,
+		},
+		{
+			name: "unknown",
+
+			text: `a
+`,
+			key:   "a",
+			field: "color",
+			value: "red",
+
+			expErr: `failed to set style color of "a": unknown style field "color"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			et := editTest{
+				text: tc.text,
+				testFunc: func(g *d2graph.Graph) (*d2graph.Graph, error) {
+					return d2oracle.SetStyle(g, tc.boardPath, tc.key, tc.field, tc.value)
+				},
+
+				exp:    tc.exp,
+				expErr: tc.expErr,
+			}
+			et.run(t)
+		})
+	}
+}
+
+func TestApplyClass(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name      string
+		boardPath []string
+
+		text    string
+		fsTexts map[string]string
+		key     string
+		class   string
+
+		expErr string
+		exp    string
+	}{
+		{
+			name: "base",
+
+			text: `classes: {
+  x: {style.fill: red}
+}
+a
+`,
+			key:   "a",
+			class: "x",
+
+			exp: `classes: {
+  x: {style.fill: red}
+}
+a: {class: x}
+`,
+		},
+		{
+			name: "append",
+
+			text: `classes: {
+  x: {style.fill: red}
+  y: {shape: circle}
+}
+a.class: x
+a -> b: {class: y}
+`,
+			key:   "a",
+			class: "y",
+
+			exp: `classes: {
+  x: {style.fill: red}
+  y: {shape: circle}
+}
+a.class: [
+  x
+  y
+]
+a -> b: {class: y}
+`,
+		},
+		{
+			name: "already",
+
+			text: `classes: {
+  x: {style.fill: red}
+}
+a.class: x
+`,
+			key:   "a",
+			class: "x",
+
+			exp: `classes: {
+  x: {style.fill: red}
+}
+a.class: x
+`,
+		},
+		{
+			name: "edge",
+
+			text: `classes: {
+  x: {style.stroke: red}
+}
+a -> b
+`,
+			key:   "(a -> b)[0]",
+			class: "x",
+
+			exp: `classes: {
+  x: {style.stroke: red}
+}
+a -> b: {class: x}
+`,
+		},
+		{
+			name: "import",
+
+			text: `...@meow
+a
+`,
+			fsTexts: map[string]string{
+				"meow.d2": `classes: {
+  x: {style.fill: red}
+}
+`,
+			},
+			key:   "a",
+			class: "x",
+
+			exp: `...@meow
+a: {class: x}
+`,
+		},
+		{
+			name: "layer",
+
+			text: `classes: {
+  x: {style.fill: red}
+}
+
+layers: {
+  l: {
+    a
+  }
+}
+`,
+			boardPath: []string{"l"},
+			key:       "a",
+			class:     "x",
+
+			exp: `classes: {
+  x: {style.fill: red}
+}
+
+layers: {
+  l: {
+    a: {class: x}
+  }
+}
+`,
+		},
+		{
+			name: "undefined",
+
+			text: `a
+`,
+			key:   "a",
+			class: "x",
+
+			expErr: `failed to apply class "x" to "a": class "x" is not defined`,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			et := editTest{
+				text:    tc.text,
+				fsTexts: tc.fsTexts,
+				testFunc: func(g *d2graph.Graph) (*d2graph.Graph, error) {
+					return d2oracle.ApplyClass(g, tc.boardPath, tc.key, tc.class)
+				},
+
+				exp:    tc.exp,
+				expErr: tc.expErr,
+			}
+			et.run(t)
+		})
+	}
+}
+
+func TestEditImport(t *testing.T) {
+	t.Parallel()
+
+	tfs, err := mapfs.New(map[string]string{
+		"index.d2": "...@meow\nq -> x\n",
+		"meow.d2":  "# cats\nx -> y\n",
+	})
+	assert.Success(t, err)
+	t.Cleanup(func() {
+		assert.Success(t, tfs.Close())
+	})
+
+	g, _, err := d2compiler.Compile("index.d2", strings.NewReader("...@meow\nq -> x\n"), &d2compiler.CompileOptions{
+		FS: tfs,
+	})
+	assert.Success(t, err)
+
+	_, _, err = d2oracle.Rename(g, nil, "x", "z")
+	var ierr d2oracle.ImportedError
+	if !errors.As(err, &ierr) {
+		t.Fatalf("expected ImportedError: %v", err)
+	}
+	assert.String(t, "meow.d2", ierr.Path)
+
+	p, err := d2oracle.ImportedFrom(g, nil, "q")
+	assert.Success(t, err)
+	assert.String(t, "", p)
+	p, err = d2oracle.ImportedFrom(g, nil, "(x -> y)[0]")
+	assert.Success(t, err)
+	assert.String(t, "meow.d2", p)
+
+	g, newText, err := d2oracle.EditImport(g, ierr.Path, func(g *d2graph.Graph) (*d2graph.Graph, error) {
+		g, _, err := d2oracle.Rename(g, nil, "x", "z")
+		return g, err
+	})
+	assert.Success(t, err)
+	assert.String(t, "# cats\nz -> y\n", newText)
+	// References of the importing file follow the rename.
+	assert.String(t, "...@meow\nq -> z\n", d2format.Format(g.AST))
+	if d2oracle.GetObj(g, nil, "z") == nil {
+		t.Fatal("expected z to be imported")
+	}
+	if d2oracle.GetObj(g, nil, "x") != nil {
+		t.Fatal("expected x to be gone")
+	}
+}
+
+func TestEditImportNested(t *testing.T) {
+	t.Parallel()
+
+	index := `layers: {
+  l: {
+    a: @meow.box
+    a.x -> b
+    b -> a.y
+  }
+}
+`
+	tfs, err := mapfs.New(map[string]string{
+		"index.d2": index,
+		"meow.d2":  "box: {\n  x -> y\n}\n",
+	})
+	assert.Success(t, err)
+	t.Cleanup(func() {
+		assert.Success(t, tfs.Close())
+	})
+
+	g, _, err := d2compiler.Compile("index.d2", strings.NewReader(index), &d2compiler.CompileOptions{
+		FS: tfs,
+	})
+	assert.Success(t, err)
+
+	g, newText, err := d2oracle.EditImport(g, "meow.d2", func(g *d2graph.Graph) (*d2graph.Graph, error) {
+		g, _, err := d2oracle.Rename(g, nil, "box.x", "z")
+		return g, err
+	})
+	assert.Success(t, err)
+	assert.String(t, "box: {\n  z -> y\n}\n", newText)
+	assert.String(t, `layers: {
+  l: {
+    a: @meow.box
+    a.z -> b
+    b -> a.y
+  }
+}
+`, d2format.Format(g.AST))
+	if d2oracle.GetObj(g, []string{"l"}, "a.z") == nil {
+		t.Fatal("expected a.z to be imported")
+	}
+}
+
 type editTest struct {
 	text     string
 	fsTexts  map[string]string
@@ -1,10 +1,12 @@
 package d2oracle_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
+	"oss.terrastruct.com/d2/d2compiler"
 	"oss.terrastruct.com/d2/d2oracle"
 )
 
@@ -24,3 +26,54 @@ func TestGetID(t *testing.T) {
 
 	assert.Equal(t, `"y (z)"`, d2oracle.GetID(`y (z)`))
 }
+
+func TestQuery(t *testing.T) {
+	t.Parallel()
+
+	text := `users: {shape: sql_table}
+db: {
+  orders: {shape: sql_table}
+  cache: {style.fill: red}
+  logs: hello world
+}
+a -> db.orders: {style.stroke: red}
+a -> users
+users <- b
+a.class: hi
+`
+	g, _, err := d2compiler.Compile("", strings.NewReader(text), nil)
+	assert.Nil(t, err)
+
+	ids := func(selector string) []string {
+		ms, err := d2oracle.Query(g, selector)
+		assert.Nil(t, err)
+		var ids []string
+		for _, m := range ms {
+			if m.Object != nil {
+				ids = append(ids, m.Object.AbsID())
+			} else {
+				ids = append(ids, m.Edge.AbsID())
+			}
+			assert.NotEmpty(t, m.Ranges)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []string{"users", "db.orders"}, ids("**.shape=sql_table"))
+	assert.Equal(t, []string{"db.orders", "db.cache", "db.logs"}, ids("db.*"))
+	assert.Equal(t, []string{"db.cache"}, ids("db.c*e.style.fill"))
+	assert.Equal(t, []string{"db.logs"}, ids(`** = "hello world"`))
+	assert.Equal(t, []string{"a"}, ids("*.class=hi"))
+	assert.Equal(t, []string{"(a -> db.orders)[0]", "(a -> users)[0]"}, ids("(a -> **)[*]"))
+	assert.Equal(t, []string{"(a -> db.orders)[0]"}, ids("(* -> **)[0].style.stroke=red"))
+	assert.Equal(t, []string{"(users <- b)[0]"}, ids("(* <- *)[*]"))
+	assert.Nil(t, ids("nope"))
+
+	ms, err := d2oracle.Query(g, "db.orders")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(ms[0].Ranges))
+	assert.Equal(t, "3:3", ms[0].Ranges[0].Start.String())
+
+	_, err = d2oracle.Query(g, "(a -> b -> c)[0]")
+	assert.EqualError(t, err, `failed to query "(a -> b -> c)[0]": edge chains are not supported`)
+}
@@ -0,0 +1,315 @@
+package d2oracle
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"oss.terrastruct.com/util-go/xdefer"
+
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2format"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2ir"
+	"oss.terrastruct.com/d2/d2parser"
+)
+
+// ImportedError is returned by edits of objects and edges declared in a file imported by the
+// one being edited that can't be written as an override, such as renaming or moving an object
+// declared in an import.
+// Path is the imported file, which can be edited with EditImport.
+type ImportedError struct {
+	Path string
+}
+
+func (e ImportedError) Error() string {
+	return fmt.Sprintf("key is declared in imported file %#v", e.Path)
+}
+
+// importedObjPath returns the first file other than the one of g that obj is referenced in.
+func importedObjPath(g *d2graph.Graph, obj *d2graph.Object) string {
+	for _, ref := range obj.References {
+		if ref.Key.Range.Path != g.AST.Range.Path {
+			return ref.Key.Range.Path
+		}
+	}
+	return ""
+}
+
+// importedEdgePath returns the first file other than the one of g that e is referenced in.
+func importedEdgePath(g *d2graph.Graph, e *d2graph.Edge) string {
+	for _, ref := range e.References {
+		if ref.Edge.Range.Path != g.AST.Range.Path {
+			return ref.Edge.Range.Path
+		}
+	}
+	return ""
+}
+
+// ImportedFrom returns the imported file that the object or edge at key is declared in, or ""
+// if it is only declared in the file of g.
+func ImportedFrom(g *d2graph.Graph, boardPath []string, key string) (string, error) {
+	if GetBoardGraph(g, boardPath) == nil {
+		return "", fmt.Errorf("board %v not found", boardPath)
+	}
+	mk, err := d2parser.ParseMapKey(key)
+	if err != nil {
+		return "", err
+	}
+	if len(mk.Edges) > 0 {
+		if e := GetEdge(g, boardPath, key); e != nil {
+			return importedEdgePath(g, e), nil
+		}
+	} else if obj := GetObj(g, boardPath, key); obj != nil {
+		return importedObjPath(g, obj), nil
+	}
+	return "", fmt.Errorf("%v not found", key)
+}
+
+// EditImport applies edit to the graph of the file at importPath, as imported by g, and
+// returns the new source of that file along with g recompiled against it. Keys within edit are
+// relative to the imported file. Objects renamed by edit are renamed in the file of g too, so
+// that its references keep pointing to them. The caller is responsible for writing the new
+// source back.
+func EditImport(g *d2graph.Graph, importPath string, edit func(*d2graph.Graph) (*d2graph.Graph, error)) (_ *d2graph.Graph, newText string, err error) {
+	defer xdefer.Errorf(&err, "failed to edit import %#v", importPath)
+
+	var f fs.File
+	if g.FS == nil {
+		f, err = os.Open(importPath)
+	} else {
+		f, err = g.FS.Open(importPath)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	rfs := &renamesFS{FS: g.FS}
+	ig, _, err := d2compiler.Compile(importPath, f, &d2compiler.CompileOptions{
+		FS: rfs,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	ig, err = edit(ig)
+	if err != nil {
+		return nil, "", err
+	}
+	newText = d2format.Format(ig.AST)
+
+	if len(rfs.renames) > 0 {
+		// Edit a copy so that g is left as is on failure.
+		g, err = recompile(g)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, r := range rfs.renames {
+			renameImportedRefs(g, importPath, r)
+		}
+	}
+
+	s := d2format.Format(g.AST)
+	g2, _, err := d2compiler.Compile(g.AST.Range.Path, strings.NewReader(s), &d2compiler.CompileOptions{
+		FS: overlayFS{
+			FS:   g.FS,
+			path: importPath,
+			text: newText,
+		},
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to recompile:\n%s\n%w", s, err)
+	}
+	// Keep the caller's FS so that subsequent edits do not see a stale overlay once the new
+	// source has been written back.
+	g2.FS = g.FS
+	return g2, newText, nil
+}
+
+// renamesFS is the file system of the graphs edited by EditImport, which records the objects
+// renamed in them by Rename. It wraps FS, or the OS if nil.
+type renamesFS struct {
+	FS      fs.FS
+	renames []importRename
+}
+
+type importRename struct {
+	boardPath []string
+	oldIDA    []string
+	newName   string
+}
+
+func (r *renamesFS) Open(name string) (fs.File, error) {
+	if r.FS == nil {
+		return os.Open(name)
+	}
+	return r.FS.Open(name)
+}
+
+// recordRename records the rename of the object at oldIDA of the board at boardPath of g to
+// newName, if g is being edited by EditImport.
+func recordRename(g *d2graph.Graph, boardPath, oldIDA []string, newName string) {
+	fsys := g.FS
+	if b, ok := fsys.(*batchFS); ok {
+		fsys = b.FS
+	}
+	if r, ok := fsys.(*renamesFS); ok {
+		r.renames = append(r.renames, importRename{
+			boardPath: boardPath,
+			oldIDA:    oldIDA,
+			newName:   newName,
+		})
+	}
+}
+
+// renameImportedRefs renames the references of the file of g to the object renamed by r in
+// the file at importPath, wherever it is imported.
+func renameImportedRefs(g *d2graph.Graph, importPath string, r importRename) {
+	dir := path.Dir(g.AST.Range.Path)
+	var walk func(boardPath []string, b *d2graph.Graph)
+	walk = func(boardPath []string, b *d2graph.Graph) {
+		m := b.BaseAST
+		if m == nil {
+			m = b.AST
+		}
+		for _, site := range importSites(m, nil, dir, importPath) {
+			targetPath := boardPath
+			if len(r.boardPath) > 0 {
+				// The boards of a file are only those of the boards it is spread into.
+				if len(site.prefix) > 0 || len(site.ida) > 0 {
+					continue
+				}
+				targetPath = append(append([]string{}, boardPath...), r.boardPath...)
+			}
+			oldIDA, ok := site.importedIDA(r.oldIDA)
+			if !ok {
+				continue
+			}
+			renameBoardRefs(g, targetPath, oldIDA, r.newName)
+		}
+		for _, child := range append(append(append([]*d2graph.Graph{}, b.Layers...), b.Scenarios...), b.Steps...) {
+			walk(append(append([]string{}, boardPath...), child.Name), child)
+		}
+	}
+	walk(nil, g)
+}
+
+// renameBoardRefs renames the references to the object at oldIDA in the board at boardPath
+// of g, and in the scenarios and steps that inherit it, to newName.
+func renameBoardRefs(g *d2graph.Graph, boardPath, oldIDA []string, newName string) {
+	boardG := GetBoardGraph(g, boardPath)
+	if boardG == nil {
+		return
+	}
+	m := boardG.BaseAST
+	if m == nil {
+		m = boardG.AST
+	}
+	renameKeyPrefix(m, nil, oldIDA, newName)
+	renameGlobs(boardG, m, nil, oldIDA, newName)
+	renameInheritedRefs(boardG, oldIDA, newName)
+	if len(boardPath) > 0 {
+		ReplaceBoardNode(g.AST, m, boardPath)
+	}
+}
+
+// importSite is where a file is imported: at prefix within its board, importing the key ida
+// of the file, or all of it if empty.
+type importSite struct {
+	prefix []string
+	ida    []string
+}
+
+// importedIDA returns the path in the board of the site of the object at ida in the
+// imported file, if it is imported.
+func (s importSite) importedIDA(ida []string) ([]string, bool) {
+	// Imports of a key only import what is under it, which takes the place of the key.
+	if len(ida) <= len(s.ida) {
+		return nil, false
+	}
+	for i := range s.ida {
+		if !strings.EqualFold(s.ida[i], ida[i]) {
+			return nil, false
+		}
+	}
+	return append(append([]string{}, s.prefix...), ida[len(s.ida):]...), true
+}
+
+// importSites returns the sites of the imports of the file at importPath by m and its maps.
+// prefix is the path of m within its board and dir the directory of its file.
+func importSites(m *d2ast.Map, prefix []string, dir, importPath string) (sites []importSite) {
+	matches := func(imp *d2ast.Import) bool {
+		p := imp.PathWithPre()
+		if p == "" || path.IsAbs(p) {
+			return false
+		}
+		if _, ok := d2ir.ImportScheme(p); ok {
+			return false
+		}
+		if path.Ext(p) != ".d2" {
+			p += ".d2"
+		}
+		return path.Join(dir, p) == path.Clean(importPath)
+	}
+	for _, n := range m.Nodes {
+		if n.Import != nil {
+			if n.Import.Spread && matches(n.Import) {
+				sites = append(sites, importSite{prefix: prefix, ida: n.Import.IDA()})
+			}
+			continue
+		}
+		if n.MapKey == nil || n.MapKey.Key == nil || len(n.MapKey.Edges) > 0 {
+			continue
+		}
+		if _, ok := d2graph.BoardKeywords[n.MapKey.Key.Path[0].Unbox().ScalarString()]; ok {
+			// Boards are renamed from their own graphs.
+			continue
+		}
+		keyPrefix := append(append([]string{}, prefix...), n.MapKey.Key.IDA()...)
+		if imp := n.MapKey.Value.Import; imp != nil && matches(imp) {
+			sites = append(sites, importSite{prefix: keyPrefix, ida: imp.IDA()})
+		}
+		if n.MapKey.Value.Map != nil {
+			sites = append(sites, importSites(n.MapKey.Value.Map, keyPrefix, dir, importPath)...)
+		}
+	}
+	return sites
+}
+
+// overlayFS serves text at path and falls back to FS, or the OS if nil, for everything else.
+type overlayFS struct {
+	FS   fs.FS
+	path string
+	text string
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if name == o.path {
+		return &memFile{
+			Reader: bytes.NewReader([]byte(o.text)),
+			name:   path.Base(name),
+		}, nil
+	}
+	if o.FS == nil {
+		return os.Open(name)
+	}
+	return o.FS.Open(name)
+}
+
+type memFile struct {
+	*bytes.Reader
+	name string
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f, nil }
+func (f *memFile) Close() error               { return nil }
+func (f *memFile) Name() string               { return f.name }
+func (f *memFile) Mode() fs.FileMode          { return 0o444 }
+func (f *memFile) ModTime() time.Time         { return time.Time{} }
+func (f *memFile) IsDir() bool                { return false }
+func (f *memFile) Sys() any                   { return nil }
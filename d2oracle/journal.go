@@ -0,0 +1,145 @@
+package d2oracle
+
+import (
+	"fmt"
+	"strings"
+
+	"oss.terrastruct.com/util-go/xdefer"
+
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2format"
+	"oss.terrastruct.com/d2/d2graph"
+)
+
+// JournalEntry is a single undoable edit. Before and After are snapshots of the source of the
+// graph on either side of the edit, which undo and redo restore. Before is the text the edit was made to as given to the Journal, so
+// that undoing restores it exactly, comments and formatting included, whereas After is
+// formatted like every oracle edit.
+type JournalEntry struct {
+	// Ops is nil for edits made to the text outside of Journal.Apply, such as typing in an
+	// editor or calling Set directly, which the Journal records when it finds the text
+	// changed.
+	Ops    []Op   `json:"ops,omitempty"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// Journal records the source before and after the batches of oracle edits applied with
+// Journal.Apply for undo and redo. The zero value is ready to use.
+//
+// Every method takes the current source text. When it differs from the text that the
+// Journal left, the difference is recorded as an edit of its own so that it's undone like
+// the others rather than overwritten. Edits made without Journal.Apply, e.g. by calling Set
+// directly, are only recorded that way, without their ops, and merged with any other
+// change of the text made before the next call.
+type Journal struct {
+	// Limit is the maximum number of entries kept. The oldest entries are dropped once it
+	// is exceeded. Zero means unlimited.
+	Limit int
+
+	entries []JournalEntry
+	// cursor is the index of the entry that the next Redo applies. Every entry before it
+	// has been applied.
+	cursor int
+}
+
+// Apply applies ops to g, the graph compiled from text, like the package level Apply and
+// records the edit on success.
+func (j *Journal) Apply(text string, g *d2graph.Graph, ops []Op) (*d2graph.Graph, []OpResult, error) {
+	g2, results, err := Apply(g, ops)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Recording discards every entry that could have been redone.
+	j.sync(text)
+	j.push(JournalEntry{
+		Ops:    ops,
+		Before: text,
+		After:  d2format.Format(g2.AST),
+	})
+	return g2, results, nil
+}
+
+// sync records the edits made outside of the Journal that turned the text it left into text.
+func (j *Journal) sync(text string) {
+	var last string
+	switch {
+	case j.cursor > 0:
+		last = j.entries[j.cursor-1].After
+	case len(j.entries) > 0:
+		last = j.entries[0].Before
+	default:
+		return
+	}
+	j.push(JournalEntry{
+		Before: last,
+		After:  text,
+	})
+}
+
+func (j *Journal) push(e JournalEntry) {
+	if e.Before == e.After {
+		return
+	}
+	j.entries = append(j.entries[:j.cursor], e)
+	if j.Limit > 0 && len(j.entries) > j.Limit {
+		j.entries = j.entries[len(j.entries)-j.Limit:]
+	}
+	j.cursor = len(j.entries)
+}
+
+func (j *Journal) CanUndo() bool {
+	return j.cursor > 0
+}
+
+func (j *Journal) CanRedo() bool {
+	return j.cursor < len(j.entries)
+}
+
+// Entries returns the recorded entries, oldest first, along with the number of them that
+// are currently applied.
+func (j *Journal) Entries() ([]JournalEntry, int) {
+	return j.entries, j.cursor
+}
+
+// Undo reverts the last applied edit of text. g is the current graph and is only used for
+// its FS and source path.
+func (j *Journal) Undo(text string, g *d2graph.Graph) (_ *d2graph.Graph, err error) {
+	defer xdefer.Errorf(&err, "failed to undo")
+
+	j.sync(text)
+	if !j.CanUndo() {
+		return nil, fmt.Errorf("nothing to undo")
+	}
+	g2, err := compileJournalText(g, j.entries[j.cursor-1].Before)
+	if err != nil {
+		return nil, err
+	}
+	j.cursor--
+	return g2, nil
+}
+
+// Redo reapplies the last undone edit of text. g is the current graph and is only used for
+// its FS and source path. Edits of text made outside of the Journal since the undo discard
+// the edits that could have been redone.
+func (j *Journal) Redo(text string, g *d2graph.Graph) (_ *d2graph.Graph, err error) {
+	defer xdefer.Errorf(&err, "failed to redo")
+
+	j.sync(text)
+	if !j.CanRedo() {
+		return nil, fmt.Errorf("nothing to redo")
+	}
+	g2, err := compileJournalText(g, j.entries[j.cursor].After)
+	if err != nil {
+		return nil, err
+	}
+	j.cursor++
+	return g2, nil
+}
+
+func compileJournalText(g *d2graph.Graph, s string) (*d2graph.Graph, error) {
+	g2, _, err := d2compiler.Compile(g.AST.Range.Path, strings.NewReader(s), &d2compiler.CompileOptions{
+		FS: g.FS,
+	})
+	return g2, err
+}
@@ -0,0 +1,105 @@
+package d2oracle_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/util-go/go2"
+
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2format"
+	"oss.terrastruct.com/d2/d2oracle"
+)
+
+func TestJournal(t *testing.T) {
+	t.Parallel()
+
+	// Undo restores the text as it was rather than formatted.
+	text := `# comment
+a->b
+`
+	g, _, err := d2compiler.Compile("", strings.NewReader(text), nil)
+	assert.Nil(t, err)
+
+	j := &d2oracle.Journal{}
+	assert.False(t, j.CanUndo())
+	_, err = j.Undo(text, g)
+	assert.EqualError(t, err, "failed to undo: nothing to undo")
+
+	g, _, err = j.Apply(text, g, []d2oracle.Op{{Type: d2oracle.OpCreate, Key: "c"}})
+	assert.Nil(t, err)
+
+	// Edits made without the journal are recorded as changes of the text.
+	g, err = d2oracle.Set(g, nil, "c.shape", nil, go2.Pointer("circle"))
+	assert.Nil(t, err)
+	assert.Equal(t, `# comment
+a -> b
+c: {shape: circle}
+`, d2format.Format(g.AST))
+
+	g, err = j.Undo(d2format.Format(g.AST), g)
+	assert.Nil(t, err)
+	assert.Equal(t, `# comment
+a -> b
+c
+`, d2format.Format(g.AST))
+
+	g, err = j.Undo(d2format.Format(g.AST), g)
+	assert.Nil(t, err)
+	assert.Equal(t, `# comment
+a -> b
+`, d2format.Format(g.AST))
+	assert.False(t, j.CanUndo())
+	assert.True(t, j.CanRedo())
+	entries, _ := j.Entries()
+	assert.Equal(t, text, entries[0].Before)
+
+	g, err = j.Redo(text, g)
+	assert.Nil(t, err)
+	assert.Equal(t, `# comment
+a -> b
+c
+`, d2format.Format(g.AST))
+
+	// A new edit discards the redo history.
+	g, _, err = j.Apply(d2format.Format(g.AST), g, []d2oracle.Op{{Type: d2oracle.OpDelete, Key: "a"}})
+	assert.Nil(t, err)
+	assert.False(t, j.CanRedo())
+	entries, applied := j.Entries()
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, 2, applied)
+
+	// Failed edits are not recorded.
+	_, _, err = j.Apply(d2format.Format(g.AST), g, []d2oracle.Op{{Type: d2oracle.OpSet, Key: "b.shape", Value: go2.Pointer("hexagonz")}})
+	assert.NotNil(t, err)
+	_, applied = j.Entries()
+	assert.Equal(t, 2, applied)
+
+	// Edits of the text made outside of the journal are undone like the others.
+	typed := d2format.Format(g.AST) + "typed\n"
+	g, _, err = d2compiler.Compile("", strings.NewReader(typed), nil)
+	assert.Nil(t, err)
+	g, _, err = j.Apply(typed, g, []d2oracle.Op{{Type: d2oracle.OpCreate, Key: "d"}})
+	assert.Nil(t, err)
+	entries, applied = j.Entries()
+	assert.Equal(t, 4, len(entries))
+	assert.Equal(t, 4, applied)
+	assert.Nil(t, entries[2].Ops)
+
+	g, err = j.Undo(d2format.Format(g.AST), g)
+	assert.Nil(t, err)
+	assert.Equal(t, typed, d2format.Format(g.AST))
+	g, err = j.Undo(typed, g)
+	assert.Nil(t, err)
+	assert.Equal(t, entries[1].After, d2format.Format(g.AST))
+
+	j = &d2oracle.Journal{Limit: 1}
+	g, _, err = j.Apply(d2format.Format(g.AST), g, []d2oracle.Op{{Type: d2oracle.OpCreate, Key: "x"}})
+	assert.Nil(t, err)
+	_, _, err = j.Apply(d2format.Format(g.AST), g, []d2oracle.Op{{Type: d2oracle.OpCreate, Key: "y"}})
+	assert.Nil(t, err)
+	entries, _ = j.Entries()
+	assert.Equal(t, 1, len(entries))
+}
@@ -0,0 +1,308 @@
+package d2oracle
+
+import (
+	"fmt"
+	"strings"
+
+	"oss.terrastruct.com/util-go/xdefer"
+
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2ir"
+	"oss.terrastruct.com/d2/d2parser"
+)
+
+// QueryMatch is a single result of Query. Exactly one of Object and Edge is set.
+type QueryMatch struct {
+	Object *d2graph.Object
+	Edge   *d2graph.Edge
+
+	// Ranges are the source ranges of every reference to the match.
+	Ranges []d2ast.Range
+}
+
+// Query returns every object or edge of g matching selector, in graph order.
+//
+// A selector is a key, optionally with globs, optionally followed by an attribute and an
+// expected value. Examples:
+//
+//	**.shape=sql_table
+//	a.*
+//	(* -> *)[*].style.stroke=red
+//	x.label="hello world"
+//
+// Without an attribute, the value is compared against the label.
+func Query(g *d2graph.Graph, selector string) (_ []QueryMatch, err error) {
+	defer xdefer.Errorf(&err, "failed to query %#v", selector)
+
+	key, value, hasValue := splitSelector(selector)
+	mk, err := d2parser.ParseMapKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if hasValue {
+		mk2, err := d2parser.ParseMapKey("_: " + value)
+		if err != nil {
+			return nil, err
+		}
+		if mk2.Value.ScalarBox().Unbox() == nil {
+			return nil, fmt.Errorf("value must be a scalar")
+		}
+		value = mk2.Value.ScalarBox().Unbox().ScalarString()
+	}
+
+	var matches []QueryMatch
+	if len(mk.Edges) > 0 {
+		if len(mk.Edges) > 1 {
+			return nil, fmt.Errorf("edge chains are not supported")
+		}
+		var prefix, attr []*d2ast.StringBox
+		if mk.Key != nil {
+			prefix = mk.Key.Path
+		}
+		if mk.EdgeKey != nil {
+			attr = mk.EdgeKey.Path
+		}
+		for _, e := range g.Edges {
+			if !matchEdge(e, prefix, mk.Edges[0], mk.EdgeIndex) {
+				continue
+			}
+			if !matchAttribute(&e.Attributes, attr, value, hasValue) {
+				continue
+			}
+			m := QueryMatch{Edge: e}
+			for _, ref := range e.References {
+				m.Ranges = append(m.Ranges, ref.Edge.Range)
+			}
+			matches = append(matches, m)
+		}
+		return matches, nil
+	}
+
+	if mk.Key == nil {
+		return nil, fmt.Errorf("missing key")
+	}
+	pattern, attr := splitReserved(mk.Key.Path)
+	if len(pattern) == 0 {
+		return nil, fmt.Errorf("missing key")
+	}
+	for _, obj := range g.Objects {
+		if !matchIDA(obj.AbsIDArray(), pattern) {
+			continue
+		}
+		if !matchAttribute(&obj.Attributes, attr, value, hasValue) {
+			continue
+		}
+		m := QueryMatch{Object: obj}
+		for _, ref := range obj.References {
+			m.Ranges = append(m.Ranges, ref.Key.Path[ref.KeyPathIndex].Unbox().GetRange())
+		}
+		matches = append(matches, m)
+	}
+	return matches, nil
+}
+
+// splitSelector splits selector on the first = outside of quotes.
+func splitSelector(selector string) (key, value string, hasValue bool) {
+	var quote rune
+	for i, r := range selector {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '=':
+			return strings.TrimSpace(selector[:i]), strings.TrimSpace(selector[i+1:]), true
+		}
+	}
+	return strings.TrimSpace(selector), "", false
+}
+
+// splitReserved splits path into the object portion and the trailing attribute portion.
+func splitReserved(path []*d2ast.StringBox) (pattern, attr []*d2ast.StringBox) {
+	for i, sb := range path {
+		if _, ok := d2graph.ReservedKeywords[sb.Unbox().ScalarString()]; ok {
+			return path[:i], path[i:]
+		}
+	}
+	return path, nil
+}
+
+func matchEdge(e *d2graph.Edge, prefix []*d2ast.StringBox, ae *d2ast.Edge, index *d2ast.EdgeIndex) bool {
+	if e.SrcArrow != (ae.SrcArrow == "<") || e.DstArrow != (ae.DstArrow == ">") {
+		return false
+	}
+	if index != nil && index.Int != nil && *index.Int != e.Index {
+		return false
+	}
+	src := append(append([]*d2ast.StringBox{}, prefix...), ae.Src.Path...)
+	dst := append(append([]*d2ast.StringBox{}, prefix...), ae.Dst.Path...)
+	return matchIDA(e.Src.AbsIDArray(), src) && matchIDA(e.Dst.AbsIDArray(), dst)
+}
+
+// matchIDA reports whether ida is matched by pattern where each element of pattern matches
+// one element of ida, except for ** and *** which match one or more.
+func matchIDA(ida []string, pattern []*d2ast.StringBox) bool {
+	if len(pattern) == 0 {
+		return len(ida) == 0
+	}
+	if len(ida) == 0 {
+		return false
+	}
+	if us := pattern[0].UnquotedString; us != nil && (d2ast.IsDoubleGlob(us.Pattern) || d2ast.IsTripleGlob(us.Pattern)) {
+		for i := 1; i <= len(ida); i++ {
+			if matchIDA(ida[i:], pattern[1:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if us := pattern[0].UnquotedString; us != nil && len(us.Pattern) > 0 {
+		if !d2ir.MatchPattern(ida[0], us.Pattern) {
+			return false
+		}
+	} else if !strings.EqualFold(ida[0], pattern[0].Unbox().ScalarString()) {
+		return false
+	}
+	return matchIDA(ida[1:], pattern[1:])
+}
+
+func matchAttribute(attrs *d2graph.Attributes, attr []*d2ast.StringBox, value string, hasValue bool) bool {
+	if !hasValue && len(attr) == 0 {
+		return true
+	}
+	if len(attr) == 1 && attr[0].Unbox().ScalarString() == "class" {
+		if !hasValue {
+			return len(attrs.Classes) > 0
+		}
+		for _, c := range attrs.Classes {
+			if c == value {
+				return true
+			}
+		}
+		return false
+	}
+	v, ok := getAttribute(attrs, attr)
+	return ok && (!hasValue || v == value)
+}
+
+// getAttribute returns the value of the attribute at path, or the label if path is empty.
+func getAttribute(attrs *d2graph.Attributes, path []*d2ast.StringBox) (string, bool) {
+	if len(path) == 0 {
+		return attrs.Label.Value, true
+	}
+	ida := make([]string, len(path))
+	for i, sb := range path {
+		ida[i] = sb.Unbox().ScalarString()
+	}
+
+	scalar := func(s *d2graph.Scalar) (string, bool) {
+		if s == nil {
+			return "", false
+		}
+		return s.Value, true
+	}
+
+	if ida[0] == "style" {
+		if len(ida) != 2 {
+			return "", false
+		}
+		return scalar(styleAttribute(&attrs.Style, ida[1]))
+	}
+	if len(ida) != 1 {
+		return "", false
+	}
+	switch ida[0] {
+	case "label":
+		return attrs.Label.Value, true
+	case "shape":
+		return attrs.Shape.Value, attrs.Shape.Value != ""
+	case "icon":
+		if attrs.Icon == nil {
+			return "", false
+		}
+		return attrs.Icon.String(), true
+	case "tooltip":
+		return scalar(attrs.Tooltip)
+	case "animate":
+		return scalar(attrs.Animate)
+	case "shape-path":
+		return scalar(attrs.ShapePath)
+	case "link":
+		return scalar(attrs.Link)
+	case "width":
+		return scalar(attrs.WidthAttr)
+	case "height":
+		return scalar(attrs.HeightAttr)
+	case "top":
+		return scalar(attrs.Top)
+	case "left":
+		return scalar(attrs.Left)
+	case "near":
+		if attrs.NearKey == nil {
+			return "", false
+		}
+		return strings.Join(attrs.NearKey.IDA(), "."), true
+	case "direction":
+		return attrs.Direction.Value, attrs.Direction.Value != ""
+	case "grid-rows":
+		return scalar(attrs.GridRows)
+	case "grid-columns":
+		return scalar(attrs.GridColumns)
+	case "grid-gap":
+		return scalar(attrs.GridGap)
+	case "vertical-gap":
+		return scalar(attrs.VerticalGap)
+	case "horizontal-gap":
+		return scalar(attrs.HorizontalGap)
+	}
+	return "", false
+}
+
+func styleAttribute(s *d2graph.Style, key string) *d2graph.Scalar {
+	switch key {
+	case "opacity":
+		return s.Opacity
+	case "stroke":
+		return s.Stroke
+	case "fill":
+		return s.Fill
+	case "fill-pattern":
+		return s.FillPattern
+	case "stroke-width":
+		return s.StrokeWidth
+	case "stroke-dash":
+		return s.StrokeDash
+	case "border-radius":
+		return s.BorderRadius
+	case "shadow":
+		return s.Shadow
+	case "3d":
+		return s.ThreeDee
+	case "multiple":
+		return s.Multiple
+	case "font":
+		return s.Font
+	case "font-size":
+		return s.FontSize
+	case "font-color":
+		return s.FontColor
+	case "animated":
+		return s.Animated
+	case "bold":
+		return s.Bold
+	case "italic":
+		return s.Italic
+	case "underline":
+		return s.Underline
+	case "filled":
+		return s.Filled
+	case "double-border":
+		return s.DoubleBorder
+	case "text-transform":
+		return s.TextTransform
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package d2oracle
+
+import (
+	"fmt"
+
+	"oss.terrastruct.com/util-go/xdefer"
+
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2ir"
+	"oss.terrastruct.com/d2/d2parser"
+)
+
+// StyleField is a key within style, e.g. the fill of style.fill.
+type StyleField string
+
+const (
+	StyleOpacity       StyleField = "opacity"
+	StyleStroke        StyleField = "stroke"
+	StyleFill          StyleField = "fill"
+	StyleFillPattern   StyleField = "fill-pattern"
+	StyleStrokeWidth   StyleField = "stroke-width"
+	StyleStrokeDash    StyleField = "stroke-dash"
+	StyleBorderRadius  StyleField = "border-radius"
+	StyleShadow        StyleField = "shadow"
+	Style3D            StyleField = "3d"
+	StyleMultiple      StyleField = "multiple"
+	StyleFont          StyleField = "font"
+	StyleFontSize      StyleField = "font-size"
+	StyleFontColor     StyleField = "font-color"
+	StyleAnimated      StyleField = "animated"
+	StyleBold          StyleField = "bold"
+	StyleItalic        StyleField = "italic"
+	StyleUnderline     StyleField = "underline"
+	StyleFilled        StyleField = "filled"
+	StyleDoubleBorder  StyleField = "double-border"
+	StyleTextTransform StyleField = "text-transform"
+)
+
+// ValidateStyle checks value against the rules the compiler applies to field.
+func ValidateStyle(field StyleField, value string) error {
+	if _, ok := d2graph.StyleKeywords[string(field)]; !ok {
+		return fmt.Errorf("unknown style field %#v", field)
+	}
+	s := d2graph.Style{
+		Opacity:       &d2graph.Scalar{},
+		Stroke:        &d2graph.Scalar{},
+		Fill:          &d2graph.Scalar{},
+		FillPattern:   &d2graph.Scalar{},
+		StrokeWidth:   &d2graph.Scalar{},
+		StrokeDash:    &d2graph.Scalar{},
+		BorderRadius:  &d2graph.Scalar{},
+		Shadow:        &d2graph.Scalar{},
+		ThreeDee:      &d2graph.Scalar{},
+		Multiple:      &d2graph.Scalar{},
+		Font:          &d2graph.Scalar{},
+		FontSize:      &d2graph.Scalar{},
+		FontColor:     &d2graph.Scalar{},
+		Animated:      &d2graph.Scalar{},
+		Bold:          &d2graph.Scalar{},
+		Italic:        &d2graph.Scalar{},
+		Underline:     &d2graph.Scalar{},
+		Filled:        &d2graph.Scalar{},
+		DoubleBorder:  &d2graph.Scalar{},
+		TextTransform: &d2graph.Scalar{},
+	}
+	return s.Apply(string(field), value)
+}
+
+// SetStyle sets style.field of the object or edge at key to value. The value is validated
+// before the AST is touched so that an invalid value never reaches the source.
+func SetStyle(g *d2graph.Graph, boardPath []string, key string, field StyleField, value string) (_ *d2graph.Graph, err error) {
+	defer xdefer.Errorf(&err, "failed to set style %s of %#v", field, key)
+
+	if err := ValidateStyle(field, value); err != nil {
+		return nil, err
+	}
+	return Set(g, boardPath, key+".style."+string(field), nil, &value)
+}
+
+// ApplyClass adds class to the classes of the object or edge at key. The class must be
+// defined in the board, whether directly, by a parent board or by an import.
+func ApplyClass(g *d2graph.Graph, boardPath []string, key, class string) (_ *d2graph.Graph, err error) {
+	defer xdefer.Errorf(&err, "failed to apply class %#v to %#v", class, key)
+
+	boardG := GetBoardGraph(g, boardPath)
+	if boardG == nil {
+		return nil, fmt.Errorf("board %v not found", boardPath)
+	}
+
+	mk, err := d2parser.ParseMapKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var classes []string
+	// The map keys referencing the object or edge.
+	var refs []*d2ast.Key
+	if len(mk.Edges) > 0 {
+		e := GetEdge(g, boardPath, key)
+		if e == nil {
+			return nil, fmt.Errorf("edge not found")
+		}
+		classes = e.Attributes.Classes
+		for _, ref := range e.References {
+			refs = append(refs, ref.MapKey)
+		}
+	} else {
+		obj := GetObj(g, boardPath, key)
+		if obj == nil {
+			return nil, fmt.Errorf("key does not exist")
+		}
+		classes = obj.Attributes.Classes
+		for _, ref := range obj.References {
+			if ref.InEdge() {
+				continue
+			}
+			if ref.KeyPathIndex == len(ref.Key.Path)-1 || ref.KeyPathIndex == len(ref.Key.Path)-2 {
+				refs = append(refs, ref.MapKey)
+			}
+		}
+	}
+	for _, c := range classes {
+		if c == class {
+			return g, nil
+		}
+	}
+
+	ir, _, err := d2ir.Compile(g.AST, &d2ir.CompileOptions{
+		FS: g.FS,
+	})
+	if err != nil {
+		return nil, err
+	}
+	m := ir
+	if len(boardPath) > 0 {
+		f := ir.GetField(boardIDA(g, boardPath)[1:]...)
+		if f == nil || f.Map() == nil {
+			return nil, fmt.Errorf("board %v not found", boardPath)
+		}
+		m = f.Map()
+	}
+	if m.GetClassMap(class) == nil {
+		return nil, fmt.Errorf("class %#v is not defined", class)
+	}
+
+	classes = append(append([]string{}, classes...), class)
+	if len(classes) == 1 {
+		return Set(g, boardPath, key+".class", nil, &class)
+	}
+
+	arr := &d2ast.Array{
+		Range: d2ast.MakeRange(",1:0:0-1:0:0"),
+	}
+	for _, c := range classes {
+		arr.Nodes = append(arr.Nodes, d2ast.MakeArrayNodeBox(d2ast.RawString(c, false)))
+	}
+	if classKey := writeableClassKey(g, refs); classKey != nil {
+		classKey.Primary = d2ast.ScalarBox{}
+		classKey.Value = d2ast.MakeValueBox(arr)
+		return recompile(g)
+	}
+
+	// The existing classes were set somewhere that cannot be written to, like a glob or an
+	// import, so override them.
+	mk, err = d2parser.ParseMapKey(key + ".class")
+	if err != nil {
+		return nil, err
+	}
+	mk.Value = d2ast.MakeValueBox(arr)
+	baseAST := g.AST
+	if len(boardPath) > 0 {
+		baseAST = boardG.BaseAST
+	}
+	appendMapKey(baseAST, mk)
+	return recompile(g)
+}
+
+// writeableClassKey returns the key in the file of g that sets the class of whatever refs
+// belong to.
+func writeableClassKey(g *d2graph.Graph, refs []*d2ast.Key) *d2ast.Key {
+	isClass := func(kp *d2ast.KeyPath) bool {
+		return kp != nil && len(kp.Path) == 1 && kp.Path[0].Unbox().ScalarString() == "class"
+	}
+	for i := len(refs) - 1; i >= 0; i-- {
+		mk := refs[i]
+		if mk.Range.Path != g.AST.Range.Path || mk.HasGlob() {
+			continue
+		}
+		if len(mk.Edges) > 0 {
+			if isClass(mk.EdgeKey) {
+				return mk
+			}
+		} else if mk.Key != nil && len(mk.Key.Path) > 1 && mk.Key.Path[len(mk.Key.Path)-1].Unbox().ScalarString() == "class" {
+			return mk
+		}
+		if mk.Value.Map != nil {
+			for _, n := range mk.Value.Map.Nodes {
+				if n.MapKey != nil && len(n.MapKey.Edges) == 0 && isClass(n.MapKey.Key) {
+					return n.MapKey
+				}
+			}
+		}
+	}
+	return nil
+}
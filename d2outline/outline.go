@@ -0,0 +1,204 @@
+// Package d2outline returns the hierarchical outline of a D2 script, its containers,
+// connections, boards, classes and vars, for editors to show outline views and breadcrumbs.
+//
+// The outline is based on the AST of the script alone, so that it reflects how the script is
+// written and is available while it does not compile.
+package d2outline
+
+import (
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2format"
+	"oss.terrastruct.com/d2/d2graph"
+)
+
+type Kind string
+
+const (
+	Object     Kind = "object"
+	Connection Kind = "connection"
+	// Board is a layer, scenario or step.
+	Board Kind = "board"
+	Class Kind = "class"
+	Var   Kind = "var"
+)
+
+// Item is an entry of the outline.
+type Item struct {
+	Name string `json:"name"`
+	Kind Kind   `json:"kind"`
+	// Detail is the keyword that declares a board, class or var, e.g. layers.
+	Detail string `json:"detail,omitempty"`
+	// Range is the range of the whole key that declares the item.
+	Range d2ast.Range `json:"range"`
+	// SelectionRange is the range of the name of the item within Range.
+	SelectionRange d2ast.Range `json:"selectionRange"`
+	Children       []Item      `json:"children,omitempty"`
+}
+
+// Outline returns the outline of m, the AST of a script. An object declared several times
+// has an item per declaration.
+func Outline(m *d2ast.Map) []Item {
+	if m == nil {
+		return []Item{}
+	}
+	return mapItems(m)
+}
+
+// Breadcrumbs returns the chain of items that contain the byte offset, outermost first.
+func Breadcrumbs(items []Item, offset int) []Item {
+	var crumbs []Item
+	for {
+		found := false
+		for _, it := range items {
+			if it.Range.Start.Byte <= offset && offset <= it.Range.End.Byte {
+				crumbs = append(crumbs, it)
+				items = it.Children
+				found = true
+				break
+			}
+		}
+		if !found {
+			return crumbs
+		}
+	}
+}
+
+func mapItems(m *d2ast.Map) []Item {
+	items := []Item{}
+	for _, n := range m.Nodes {
+		mk := n.MapKey
+		if mk == nil {
+			continue
+		}
+
+		if len(mk.Edges) > 0 {
+			prefix := ""
+			if mk.Key != nil {
+				prefix = d2format.Format(mk.Key) + "."
+			}
+			for _, e := range mk.Edges {
+				items = append(items, Item{
+					Name:           prefix + d2format.Format(e),
+					Kind:           Connection,
+					Range:          mk.Range,
+					SelectionRange: e.Range,
+				})
+			}
+			continue
+		}
+		if mk.Key == nil {
+			continue
+		}
+
+		path := mk.Key.Path
+		if path[0].UnquotedString != nil {
+			head := path[0].Unbox().ScalarString()
+			if _, ok := d2graph.BoardKeywords[head]; ok {
+				items = append(items, declaredItems(mk, Board, mapItems)...)
+				continue
+			}
+			switch head {
+			case "classes":
+				items = append(items, declaredItems(mk, Class, nil)...)
+				continue
+			case "vars":
+				items = append(items, declaredItems(mk, Var, varItems)...)
+				continue
+			}
+		}
+		// a.style.fill: red is about a.
+		path = trimReserved(path)
+		if len(path) == 0 {
+			continue
+		}
+		it := Item{
+			Name:           d2format.Format(&d2ast.KeyPath{Path: path}),
+			Kind:           Object,
+			Range:          mk.Range,
+			SelectionRange: mk.Key.Range,
+		}
+		if mk.Value.Map != nil && len(path) == len(mk.Key.Path) {
+			it.Children = mapItems(mk.Value.Map)
+		}
+		items = append(items, it)
+	}
+	return items
+}
+
+// declaredItems returns an item of kind per name declared by mk under a keyword, e.g.
+// layers: {x; y} or layers.x: {}. children, if not nil, returns the children of the map of a
+// declared item.
+func declaredItems(mk *d2ast.Key, kind Kind, children func(*d2ast.Map) []Item) []Item {
+	path := mk.Key.Path
+	keyword := path[0].Unbox().ScalarString()
+	if len(path) > 2 {
+		// Something within a declaration, e.g. layers.x.a: b.
+		return nil
+	}
+	if len(path) == 2 {
+		return []Item{declaredItem(keyword, kind, mk, path[1], children)}
+	}
+	if mk.Value.Map == nil {
+		return nil
+	}
+	var items []Item
+	for _, n := range mk.Value.Map.Nodes {
+		if n.MapKey == nil || n.MapKey.Key == nil || len(n.MapKey.Key.Path) != 1 || len(n.MapKey.Edges) > 0 {
+			continue
+		}
+		if kind == Var && n.MapKey.Key.Path[0].Unbox().ScalarString() == "d2-config" {
+			continue
+		}
+		items = append(items, declaredItem(keyword, kind, n.MapKey, n.MapKey.Key.Path[0], children))
+	}
+	return items
+}
+
+func declaredItem(keyword string, kind Kind, mk *d2ast.Key, name *d2ast.StringBox, children func(*d2ast.Map) []Item) Item {
+	it := Item{
+		Name:           name.Unbox().ScalarString(),
+		Kind:           kind,
+		Detail:         keyword,
+		Range:          mk.Range,
+		SelectionRange: name.Unbox().GetRange(),
+	}
+	if children != nil && mk.Value.Map != nil {
+		it.Children = children(mk.Value.Map)
+	}
+	return it
+}
+
+// varItems returns the vars nested in the map of a var.
+func varItems(m *d2ast.Map) []Item {
+	var items []Item
+	for _, n := range m.Nodes {
+		mk := n.MapKey
+		if mk == nil || mk.Key == nil || len(mk.Edges) > 0 {
+			continue
+		}
+		it := Item{
+			Name:           d2format.Format(mk.Key),
+			Kind:           Var,
+			Range:          mk.Range,
+			SelectionRange: mk.Key.Range,
+		}
+		if mk.Value.Map != nil {
+			it.Children = varItems(mk.Value.Map)
+		}
+		items = append(items, it)
+	}
+	return items
+}
+
+// trimReserved returns the portion of path before its first reserved keyword.
+func trimReserved(path []*d2ast.StringBox) []*d2ast.StringBox {
+	for i, sb := range path {
+		if sb.UnquotedString == nil {
+			continue
+		}
+		if _, ok := d2graph.ReservedKeywords[sb.Unbox().ScalarString()]; ok {
+			return path[:i]
+		}
+	}
+	return path
+}
@@ -0,0 +1,106 @@
+package d2outline_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"oss.terrastruct.com/d2/d2outline"
+	"oss.terrastruct.com/d2/d2parser"
+)
+
+// entry is an item without its ranges.
+type entry struct {
+	name     string
+	kind     d2outline.Kind
+	detail   string
+	children []entry
+}
+
+func entries(items []d2outline.Item) []entry {
+	var out []entry
+	for _, it := range items {
+		out = append(out, entry{it.Name, it.Kind, it.Detail, entries(it.Children)})
+	}
+	return out
+}
+
+const script = `vars: {
+  d2-config: {theme-id: 1}
+  color: red
+  sizes: {small: 1}
+}
+vars.extra: 2
+classes: {
+  k: {style.fill: ${color}}
+}
+a: {
+  b.style.fill: red
+  b -> c
+}
+x.y.class: k
+direction: right
+layers: {
+  l: {
+    d
+  }
+}
+steps.s: {
+  e
+}
+`
+
+func TestOutline(t *testing.T) {
+	t.Parallel()
+
+	m, err := d2parser.Parse("", strings.NewReader(script), nil)
+	require.NoError(t, err)
+	items := d2outline.Outline(m)
+	assert.Equal(t, []entry{
+		{"color", d2outline.Var, "vars", nil},
+		{"sizes", d2outline.Var, "vars", []entry{
+			{"small", d2outline.Var, "", nil},
+		}},
+		{"extra", d2outline.Var, "vars", nil},
+		{"k", d2outline.Class, "classes", nil},
+		{"a", d2outline.Object, "", []entry{
+			{"b", d2outline.Object, "", nil},
+			{"b -> c", d2outline.Connection, "", nil},
+		}},
+		{"x.y", d2outline.Object, "", nil},
+		{"l", d2outline.Board, "layers", []entry{
+			{"d", d2outline.Object, "", nil},
+		}},
+		{"s", d2outline.Board, "steps", []entry{
+			{"e", d2outline.Object, "", nil},
+		}},
+	}, entries(items))
+
+	// The name of a declaration is selected, not its keyword.
+	assert.Equal(t, "extra", script[items[2].SelectionRange.Start.Byte:items[2].SelectionRange.End.Byte])
+	assert.Equal(t, "vars.extra: 2", script[items[2].Range.Start.Byte:items[2].Range.End.Byte])
+
+	assert.Empty(t, d2outline.Outline(nil))
+}
+
+func TestBreadcrumbs(t *testing.T) {
+	t.Parallel()
+
+	m, err := d2parser.Parse("", strings.NewReader(script), nil)
+	require.NoError(t, err)
+	items := d2outline.Outline(m)
+
+	names := func(offset int) []string {
+		var out []string
+		for _, it := range d2outline.Breadcrumbs(items, offset) {
+			out = append(out, it.Name)
+		}
+		return out
+	}
+	assert.Equal(t, []string{"l", "d"}, names(strings.Index(script, "    d")+4))
+	assert.Equal(t, []string{"a", "b -> c"}, names(strings.Index(script, "b -> c")+3))
+	assert.Equal(t, []string{"sizes", "small"}, names(strings.Index(script, "small")))
+	assert.Empty(t, names(strings.Index(script, "direction")))
+}
@@ -8,6 +8,7 @@ import (
 	"math/big"
 	"strconv"
 	"strings"
+	"sync"
 	"unicode"
 	"unicode/utf8"
 
@@ -15,6 +16,7 @@ import (
 	"golang.org/x/text/transform"
 
 	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/lib/slab"
 	"oss.terrastruct.com/util-go/go2"
 )
 
@@ -27,6 +29,14 @@ type ParseOptions struct {
 	ParseError *ParseError
 }
 
+// readers pools the readers of Parse so that services parsing many diagrams don't allocate
+// a buffer for every one.
+var readers = sync.Pool{
+	New: func() any {
+		return bufio.NewReader(nil)
+	},
+}
+
 // Parse parses a .d2 Map in r.
 //
 // The returned Map always represents a valid .d2 file. All encountered errors will be in
@@ -52,7 +62,12 @@ func Parse(path string, r io.Reader, opts *ParseOptions) (*d2ast.Map, error) {
 		utf16Pos: opts.UTF16Pos,
 		err:      opts.ParseError,
 	}
-	br := bufio.NewReader(r)
+	br := readers.Get().(*bufio.Reader)
+	br.Reset(r)
+	defer func() {
+		br.Reset(nil)
+		readers.Put(br)
+	}()
 	p.reader = br
 
 	bom, err := br.Peek(2)
@@ -157,13 +172,29 @@ type parser struct {
 	inEdgeGroup bool
 
 	depth int
+
+	// The nodes most allocated by large diagrams are allocated from slabs rather than one by
+	// one.
+	stringSlab    slab.Slab[[2]string]
+	keySlab       slab.Slab[d2ast.Key]
+	keyPathSlab   slab.Slab[d2ast.KeyPath]
+	edgeSlab      slab.Slab[d2ast.Edge]
+	stringBoxSlab slab.Slab[d2ast.StringBox]
+	unquotedSlab  slab.Slab[d2ast.UnquotedString]
+}
+
+// stringBox returns an InterpolationBox of the string s with the raw string raw.
+func (p *parser) stringBox(s, raw string) d2ast.InterpolationBox {
+	v := p.stringSlab.New()
+	v[0], v[1] = s, raw
+	return d2ast.InterpolationBox{String: &v[0], StringRaw: &v[1]}
 }
 
 // TODO: rename to Error and make existing Error a private type errorWithRange
 type ParseError struct {
 	// Errors from globs need to be deduplicated
-	ErrorsLookup map[d2ast.Error]struct{} `json:"-"`
-	Errors       []d2ast.Error            `json:"errs"`
+	ErrorsLookup map[string]struct{} `json:"-"`
+	Errors       []d2ast.Error       `json:"errs"`
 }
 
 func Errorf(n d2ast.Node, f string, v ...interface{}) error {
@@ -648,11 +679,10 @@ func trimSpaceAfterLastNewline(s string) string {
 }
 
 func (p *parser) parseMapKey() (mk *d2ast.Key) {
-	mk = &d2ast.Key{
-		Range: d2ast.Range{
-			Path:  p.path,
-			Start: p.pos,
-		},
+	mk = p.keySlab.New()
+	mk.Range = d2ast.Range{
+		Path:  p.path,
+		Start: p.pos,
 	}
 	defer mk.Range.End.From(&p.pos)
 
@@ -883,12 +913,11 @@ func (p *parser) parseEdgeIndex() *d2ast.EdgeIndex {
 
 func (p *parser) parseEdges(mk *d2ast.Key, src *d2ast.KeyPath) {
 	for {
-		e := &d2ast.Edge{
-			Range: d2ast.Range{
-				Path: p.path,
-			},
-			Src: src,
+		e := p.edgeSlab.New()
+		e.Range = d2ast.Range{
+			Path: p.path,
 		}
+		e.Src = src
 		if src != nil {
 			e.Range.Start = src.Range.Start
 		} else {
@@ -972,11 +1001,10 @@ func (p *parser) parseEdge(e *d2ast.Edge) (ok bool) {
 }
 
 func (p *parser) parseKey() (k *d2ast.KeyPath) {
-	k = &d2ast.KeyPath{
-		Range: d2ast.Range{
-			Path:  p.path,
-			Start: p.pos,
-		},
+	k = p.keyPathSlab.New()
+	k.Range = d2ast.Range{
+		Path:  p.path,
+		Start: p.pos,
 	}
 
 	defer func() {
@@ -1014,7 +1042,9 @@ func (p *parser) parseKey() (k *d2ast.KeyPath) {
 		if len(k.Path) == 0 {
 			k.Range.Start = s.GetRange().Start
 		}
-		k.Path = append(k.Path, &sb)
+		sbp := p.stringBoxSlab.New()
+		*sbp = sb
+		k.Path = append(k.Path, sbp)
 
 		r, newlines, eof = p.peekNotSpace()
 		if eof {
@@ -1058,11 +1088,10 @@ func (p *parser) parseString(inKey bool) d2ast.StringBox {
 }
 
 func (p *parser) parseUnquotedString(inKey bool) (s *d2ast.UnquotedString) {
-	s = &d2ast.UnquotedString{
-		Range: d2ast.Range{
-			Path:  p.path,
-			Start: p.pos,
-		},
+	s = p.unquotedSlab.New()
+	s.Range = d2ast.Range{
+		Path:  p.path,
+		Start: p.pos,
 	}
 	// TODO: fix unquoted end whitespace handling to peekNotSpace
 	lastNonSpace := p.pos
@@ -1089,7 +1118,7 @@ func (p *parser) parseUnquotedString(inKey bool) (s *d2ast.UnquotedString) {
 			// TODO: give specific descriptions for each kind of special character that could have caused this.
 			return
 		}
-		s.Value = append(s.Value, d2ast.InterpolationBox{String: &sv, StringRaw: &rawv})
+		s.Value = append(s.Value, p.stringBox(sv, rawv))
 	}()
 
 	_s, eof := p.peekn(4)
@@ -1185,7 +1214,7 @@ func (p *parser) parseUnquotedString(inKey bool) (s *d2ast.UnquotedString) {
 				if sb.Len() > 0 {
 					sv := sb.String()
 					rawv := rawb.String()
-					s.Value = append(s.Value, d2ast.InterpolationBox{String: &sv, StringRaw: &rawv})
+					s.Value = append(s.Value, p.stringBox(sv, rawv))
 					sb.Reset()
 					rawb.Reset()
 				}
@@ -1266,7 +1295,7 @@ func (p *parser) parseDoubleQuotedString(inKey bool) *d2ast.DoubleQuotedString {
 		if sb.Len() > 0 {
 			sv := sb.String()
 			rawv := rawb.String()
-			s.Value = append(s.Value, d2ast.InterpolationBox{String: &sv, StringRaw: &rawv})
+			s.Value = append(s.Value, p.stringBox(sv, rawv))
 		}
 	}()
 
@@ -4,17 +4,18 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"oss.terrastruct.com/util-go/xdefer"
 	"oss.terrastruct.com/util-go/xmain"
 
 	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2target"
 	timelib "oss.terrastruct.com/d2/lib/time"
 )
 
@@ -30,33 +31,78 @@ import (
 // Layout
 //  1. The binary is invoked with layout as the first argument and the json marshalled
 //     d2graph.Graph on stdin.
-//  2. The stdout of the binary is unmarshalled into a d2graph.Graph
+//  2. The stdout of the binary is unmarshalled into a d2graph.Graph. Warnings the plugin
+//     added to the graph are reported to the user with the position of the object or edge
+//     they are about.
+//
+// MutateGraph
+//  1. The binary is invoked with mutategraph as the first argument and the json marshalled
+//     d2graph.Graph on stdin. This is only done for plugins with the
+//     mutates_graph feature that are selected with --mutator.
+//  2. The stdout of the binary is unmarshalled into a d2graph.Graph, which is then measured
+//     and laid out in place of the original.
 //
 // PostProcess
 //  1. The binary is invoked with postprocess as the first argument and the
 //     bytes of the SVG render on stdin.
 //  2. The stdout of the binary is bytes of SVG with any post-processing.
 //
+// ResolveImport
+//  1. The binary is invoked with resolveimport as the first argument and the import path,
+//     e.g. acme:shared/styles.d2, as the second. This is only done for plugins with the
+//     resolves_imports feature.
+//  2. The stdout of the binary is the D2 text of the imported file.
+//
+// Icon
+//  1. The binary is invoked with icon as the first argument and the icon URL, e.g.
+//     corp://payments/logo, as the second. This is only done for plugins with the
+//     provides_icons feature.
+//  2. The stdout of the binary is the image. Its type is detected from the extension of
+//     the URL path, if any, or else its contents.
+//
+// Render
+//  1. The binary is invoked with render as the first argument and the json marshalled
+//     d2target.Diagram on stdin. This is only done for plugins with the renders feature.
+//  2. The stdout of the binary is the bytes of the output file.
+//
 // If any errors occur the binary will exit with a non zero status code and write
 // the error to stderr.
+//
+// The version of the protocol is negotiated with info, see protocol.go. The other
+// subcommands fail if d2 does not speak the version the plugin answered with.
+//
+// WASM plugins implement the same protocol as WASI commands, see wasm.go.
 type execPlugin struct {
 	path string
 	opts map[string]string
 	info *PluginInfo
+	// wasm is set if path is a WASM module rather than a native binary.
+	wasm *wasmModule
+
+	// persistent is the process started with serve for plugins with the persistent
+	// feature. noPersistent is set if it could not be started.
+	persistentMu sync.Mutex
+	persistent   *persistentProcess
+	noPersistent bool
+
+	// sandbox, sandboxAllowNetwork and checksum are set from ListOptions.
+	sandbox             bool
+	sandboxAllowNetwork bool
+	checksum            string
 }
 
 func (p *execPlugin) Flags(ctx context.Context) (_ []PluginSpecificFlag, err error) {
+	defer xdefer.Errorf(&err, "failed to run %v", []string{p.path, "flags"})
+	// Compiling WASM plugins can take longer than running them.
+	err = p.load(ctx)
+	if err != nil {
+		return nil, err
+	}
 	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
-	cmd := exec.CommandContext(ctx, p.path, "flags")
-	defer xdefer.Errorf(&err, "failed to run %v", cmd.Args)
 
-	stdout, err := cmd.Output()
+	stdout, err := p.run(ctx, nil, "flags")
 	if err != nil {
-		ee := &exec.ExitError{}
-		if errors.As(err, &ee) && len(ee.Stderr) > 0 {
-			return nil, fmt.Errorf("%v\nstderr:\n%s", ee, ee.Stderr)
-		}
 		return nil, err
 	}
 
@@ -119,17 +165,17 @@ func (p *execPlugin) Info(ctx context.Context) (_ *PluginInfo, err error) {
 		return p.info, nil
 	}
 
+	defer xdefer.Errorf(&err, "failed to run %v", []string{p.path, "info"})
+	// Compiling WASM plugins can take longer than running them.
+	err = p.load(ctx)
+	if err != nil {
+		return nil, err
+	}
 	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
-	cmd := exec.CommandContext(ctx, p.path, "info")
-	defer xdefer.Errorf(&err, "failed to run %v", cmd.Args)
 
-	stdout, err := cmd.Output()
+	stdout, err := p.run(ctx, nil, "info")
 	if err != nil {
-		ee := &exec.ExitError{}
-		if errors.As(err, &ee) && len(ee.Stderr) > 0 {
-			return nil, fmt.Errorf("%v\nstderr:\n%s", ee, ee.Stderr)
-		}
 		return nil, err
 	}
 
@@ -141,6 +187,9 @@ func (p *execPlugin) Info(ctx context.Context) (_ *PluginInfo, err error) {
 	}
 
 	info.Type = "binary"
+	if p.wasm != nil {
+		info.Type = "wasm"
+	}
 	info.Path = p.path
 
 	p.info = &info
@@ -156,22 +205,29 @@ func (p *execPlugin) Layout(ctx context.Context, g *d2graph.Graph) error {
 		return err
 	}
 
-	args := []string{"layout"}
-	for k, v := range p.opts {
-		args = append(args, fmt.Sprintf("--%s", k), v)
+	stdout, err := p.run(ctx, graphBytes, p.args("layout")...)
+	if err != nil {
+		return err
+	}
+	err = d2graph.DeserializeGraph(stdout, g)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal json: %w", err)
 	}
-	cmd := exec.CommandContext(ctx, p.path, args...)
 
-	buffer := bytes.Buffer{}
-	buffer.Write(graphBytes)
-	cmd.Stdin = &buffer
+	return nil
+}
 
-	stdout, err := cmd.Output()
+func (p *execPlugin) MutateGraph(ctx context.Context, g *d2graph.Graph) error {
+	ctx, cancel := timelib.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	graphBytes, err := d2graph.SerializeGraph(g)
+	if err != nil {
+		return err
+	}
+
+	stdout, err := p.run(ctx, graphBytes, p.args("mutategraph")...)
 	if err != nil {
-		ee := &exec.ExitError{}
-		if errors.As(err, &ee) && len(ee.Stderr) > 0 {
-			return fmt.Errorf("%v\nstderr:\n%s", ee, ee.Stderr)
-		}
 		return err
 	}
 	err = d2graph.DeserializeGraph(stdout, g)
@@ -186,20 +242,7 @@ func (p *execPlugin) PostProcess(ctx context.Context, in []byte) ([]byte, error)
 	ctx, cancel := context.WithTimeout(ctx, time.Minute)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, p.path, "postprocess")
-
-	cmd.Stdin = bytes.NewBuffer(in)
-
-	stdout, err := cmd.Output()
-	if err != nil {
-		ee := &exec.ExitError{}
-		if errors.As(err, &ee) && len(ee.Stderr) > 0 {
-			return nil, fmt.Errorf("%v\nstderr:\n%s", ee, ee.Stderr)
-		}
-		return nil, err
-	}
-
-	return stdout, nil
+	return p.run(ctx, in, "postprocess")
 }
 
 func (p *execPlugin) RouteEdges(ctx context.Context, g *d2graph.Graph, edges []*d2graph.Edge) error {
@@ -232,28 +275,128 @@ func (p *execPlugin) RouteEdges(ctx context.Context, g *d2graph.Graph, edges []*
 		return err
 	}
 
-	args := []string{"routeedges"}
+	stdout, err := p.run(ctx, b, p.args("routeedges")...)
+	if err != nil {
+		return err
+	}
+	err = d2graph.DeserializeGraph(stdout, g)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal json: %w", err)
+	}
+
+	return nil
+}
+
+func (p *execPlugin) Render(ctx context.Context, diagram *d2target.Diagram) ([]byte, error) {
+	ctx, cancel := timelib.WithTimeout(ctx, time.Minute*2)
+	defer cancel()
+
+	b, err := json.Marshal(diagram)
+	if err != nil {
+		return nil, err
+	}
+	return p.run(ctx, b, p.args("render")...)
+}
+
+func (p *execPlugin) ResolveImport(ctx context.Context, path string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	return p.run(ctx, nil, "resolveimport", path)
+}
+
+func (p *execPlugin) Icon(ctx context.Context, href string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	return p.run(ctx, nil, "icon", href)
+}
+
+// args returns the arguments to run subcmd with, followed by the plugin specific flags.
+func (p *execPlugin) args(subcmd string) []string {
+	args := []string{subcmd}
 	for k, v := range p.opts {
 		args = append(args, fmt.Sprintf("--%s", k), v)
 	}
-	cmd := exec.CommandContext(ctx, p.path, args...)
+	return args
+}
 
-	buffer := bytes.Buffer{}
-	buffer.Write(b)
-	cmd.Stdin = &buffer
+// load compiles the module of WASM plugins.
+func (p *execPlugin) load(ctx context.Context) error {
+	if p.wasm == nil {
+		return nil
+	}
+	return p.wasm.load(ctx)
+}
 
-	stdout, err := cmd.Output()
-	if err != nil {
-		ee := &exec.ExitError{}
-		if errors.As(err, &ee) && len(ee.Stderr) > 0 {
-			return fmt.Errorf("%v\nstderr:\n%s", ee, ee.Stderr)
+// run runs the plugin with args, writing stdin to its standard input, and returns its
+// standard output.
+func (p *execPlugin) run(ctx context.Context, stdin []byte, args ...string) ([]byte, error) {
+	if p.info != nil && args[0] != "info" && args[0] != "flags" {
+		err := checkProtocol(p.info)
+		if err != nil {
+			return nil, err
 		}
-		return err
 	}
-	err = d2graph.DeserializeGraph(stdout, g)
+	if p.wasm != nil {
+		return p.wasm.run(ctx, stdin, args...)
+	}
+	if pp := p.persistentProcess(ctx, args[0]); pp != nil {
+		return pp.run(ctx, stdin, args...)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd, cleanup, err := p.start(ctx, true, func(cmd *exec.Cmd) error {
+		cmd.Stdin = bytes.NewReader(stdin)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		return nil
+	}, args...)
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal json: %w", err)
+		return nil, err
 	}
+	defer cleanup()
 
-	return nil
+	err = cmd.Wait()
+	if err != nil {
+		if stderr.Len() > 0 {
+			return nil, fmt.Errorf("%v\nstderr:\n%s", err, stderr.Bytes())
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// persistentProcess returns the process to send subcmd to if the plugin has the persistent
+// feature, starting it if needed. It returns nil if subcmd should run in its own process.
+func (p *execPlugin) persistentProcess(ctx context.Context, subcmd string) *persistentProcess {
+	// Info is what tells whether the plugin has the feature.
+	if p.info == nil || subcmd == "info" || subcmd == "flags" {
+		return nil
+	}
+	persistent := false
+	for _, f := range p.info.Features {
+		if f == PERSISTENT {
+			persistent = true
+		}
+	}
+	if !persistent {
+		return nil
+	}
+
+	p.persistentMu.Lock()
+	defer p.persistentMu.Unlock()
+	if p.noPersistent {
+		return nil
+	}
+	if p.persistent == nil || p.persistent.exited() {
+		pp, err := startPersistent(ctx, p)
+		if err != nil {
+			// Fall back to a process per request.
+			p.noPersistent = true
+			return nil
+		}
+		p.persistent = pp
+	}
+	return p.persistent
 }
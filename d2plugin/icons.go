@@ -0,0 +1,32 @@
+package d2plugin
+
+import (
+	"context"
+	"strings"
+
+	"oss.terrastruct.com/d2/lib/imgbundler"
+)
+
+// IconProviders returns the icon providers of the plugins with the provides_icons feature
+// by URL scheme, to bundle icons with imgbundler.WithIconProviders. The first plugin to
+// provide a scheme provides it.
+func IconProviders(ctx context.Context, ps []Plugin) (map[string]imgbundler.IconProvider, error) {
+	providers := make(map[string]imgbundler.IconProvider)
+	for _, p := range ps {
+		info, err := p.Info(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ip, ok := p.(IconProvidingPlugin)
+		if !ok || !HasFeature(info, PROVIDES_ICONS) {
+			continue
+		}
+		for _, scheme := range info.IconSchemes {
+			scheme = strings.ToLower(scheme)
+			if _, ok := providers[scheme]; !ok {
+				providers[scheme] = ip.Icon
+			}
+		}
+	}
+	return providers, nil
+}
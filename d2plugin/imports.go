@@ -0,0 +1,165 @@
+package d2plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"oss.terrastruct.com/d2/d2ir"
+)
+
+// ImportFS returns the filesystem to compile with so that imports with the scheme of a
+// plugin with the resolves_imports feature are resolved by the plugin, e.g. to read shared
+// files from an artifact store. Other files are read from base, or the OS filesystem if it
+// is nil. base is returned as is if no plugin resolves imports.
+func ImportFS(ctx context.Context, ps []Plugin, base fs.FS) (fs.FS, error) {
+	resolvers := make(map[string]ImportResolvingPlugin)
+	for _, p := range ps {
+		info, err := p.Info(ctx)
+		if err != nil {
+			return nil, err
+		}
+		rp, ok := p.(ImportResolvingPlugin)
+		if !ok || !HasFeature(info, RESOLVES_IMPORTS) {
+			continue
+		}
+		for _, scheme := range info.Schemes {
+			scheme = strings.ToLower(scheme)
+			if _, ok := resolvers[scheme]; !ok {
+				resolvers[scheme] = rp
+			}
+		}
+	}
+	if len(resolvers) == 0 {
+		return base, nil
+	}
+	ifs := &importFS{
+		ctx:       ctx,
+		base:      base,
+		resolvers: resolvers,
+	}
+	if c, ok := ctx.Value(importCacheKey{}).(importCache); ok {
+		ifs.cache = c
+	}
+	return ifs, nil
+}
+
+type importCacheKey struct{}
+
+type importCache struct {
+	dir     string
+	offline bool
+}
+
+// WithImportCache returns a context under which ImportFS caches the imports resolved by
+// plugins in dir. Imports are always resolved by their plugin unless offline, in which case
+// they are read from dir instead, failing if they were never resolved.
+func WithImportCache(ctx context.Context, dir string, offline bool) context.Context {
+	return context.WithValue(ctx, importCacheKey{}, importCache{
+		dir:     dir,
+		offline: offline,
+	})
+}
+
+type importFS struct {
+	ctx       context.Context
+	base      fs.FS
+	resolvers map[string]ImportResolvingPlugin
+	cache     importCache
+}
+
+func (ifs *importFS) Open(name string) (fs.File, error) {
+	scheme, ok := d2ir.ImportScheme(name)
+	if !ok {
+		if ifs.base == nil {
+			return os.Open(name)
+		}
+		return ifs.base.Open(name)
+	}
+	b, err := ifs.resolve(scheme, name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &importFile{
+		Reader: bytes.NewReader(b),
+		name:   name,
+		size:   int64(len(b)),
+	}, nil
+}
+
+func (ifs *importFS) resolve(scheme, name string) ([]byte, error) {
+	rp, ok := ifs.resolvers[strings.ToLower(scheme)]
+	if !ok {
+		return nil, fmt.Errorf("no plugin resolves imports with the scheme %s", scheme)
+	}
+	if ifs.cache.offline {
+		b, err := os.ReadFile(ifs.cachePath(name))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, errors.New("not cached, so cannot be resolved offline")
+		}
+		return b, err
+	}
+
+	b, err := rp.ResolveImport(ifs.ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if ifs.cache.dir != "" {
+		// Failing to cache only matters offline, which reports the import as not cached.
+		_ = writeCachedImport(ifs.cachePath(name), b)
+	}
+	return b, nil
+}
+
+func (ifs *importFS) cachePath(name string) string {
+	h := sha256.Sum256([]byte(name))
+	return filepath.Join(ifs.cache.dir, hex.EncodeToString(h[:])+".d2")
+}
+
+func writeCachedImport(p string, b []byte) error {
+	err := os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// importFile is a file resolved by a plugin.
+type importFile struct {
+	*bytes.Reader
+	name string
+	size int64
+}
+
+func (f *importFile) Stat() (fs.FileInfo, error) { return f, nil }
+func (f *importFile) Close() error               { return nil }
+
+func (f *importFile) Name() string       { return path.Base(f.name) }
+func (f *importFile) Size() int64        { return f.size }
+func (f *importFile) Mode() fs.FileMode  { return 0444 }
+func (f *importFile) ModTime() time.Time { return time.Time{} }
+func (f *importFile) IsDir() bool        { return false }
+func (f *importFile) Sys() interface{}   { return nil }
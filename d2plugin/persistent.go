@@ -0,0 +1,242 @@
+package d2plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"oss.terrastruct.com/util-go/xdefer"
+	"oss.terrastruct.com/util-go/xmain"
+)
+
+// The persistent plugin protocol lets binary plugins with the persistent feature stay
+// alive across compilations, e.g. in watch mode, instead of being spawned for every
+// layout.
+//
+//  1. The binary is invoked with serve as the first argument.
+//  2. It writes a persistentHandshake to stdout with the version of the protocol it speaks.
+//  3. For each invocation of the regular protocol, a persistentRequest is written to stdin
+//     with its arguments and stdin, and the binary answers with a persistentResponse
+//     containing what it would have written to stdout, or the error it would have exited
+//     with.
+//  4. The binary exits once its stdin is closed, which happens when the d2 process exits.
+//
+// Requests and responses are JSON values written one after the other.
+const persistentProtocol = 1
+
+type persistentHandshake struct {
+	Protocol int `json:"protocol"`
+}
+
+type persistentRequest struct {
+	Args  []string `json:"args"`
+	Stdin []byte   `json:"stdin"`
+}
+
+type persistentResponse struct {
+	Stdout []byte `json:"stdout"`
+	Error  string `json:"error,omitempty"`
+}
+
+// persistentProcess is a plugin binary started with serve.
+type persistentProcess struct {
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	enc    *json.Encoder
+	dec    *json.Decoder
+	stderr lockedBuffer
+	// dead is set once the process cannot be used anymore.
+	dead bool
+	// cleanup removes the working directory of sandboxed processes and the verified copy of
+	// the binary once killed.
+	cleanup func()
+}
+
+func startPersistent(ctx context.Context, p *execPlugin) (_ *persistentProcess, err error) {
+	defer xdefer.Errorf(&err, "failed to start %s serve", p.path)
+
+	pp := &persistentProcess{}
+	var stdout io.Reader
+	// Not bound to ctx as the process outlives the request that starts it.
+	pp.cmd, pp.cleanup, err = p.start(ctx, false, func(cmd *exec.Cmd) (err error) {
+		cmd.Stderr = &pp.stderr
+		pp.stdin, err = cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+		stdout, err = cmd.StdoutPipe()
+		return err
+	}, "serve")
+	if err != nil {
+		return nil, err
+	}
+	pp.enc = json.NewEncoder(pp.stdin)
+	pp.dec = json.NewDecoder(stdout)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	var hs persistentHandshake
+	err = pp.wait(ctx, func() error {
+		return pp.dec.Decode(&hs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if hs.Protocol != persistentProtocol {
+		pp.kill()
+		return nil, fmt.Errorf("unsupported protocol version %d, expected %d", hs.Protocol, persistentProtocol)
+	}
+	return pp, nil
+}
+
+// run sends a request and waits for its response. The process is killed if ctx is done
+// first as it would otherwise answer the next request with the response to this one.
+func (pp *persistentProcess) run(ctx context.Context, stdin []byte, args ...string) ([]byte, error) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if pp.dead {
+		return nil, errors.New("plugin process exited")
+	}
+
+	pp.stderr.Reset()
+	var resp persistentResponse
+	err := pp.wait(ctx, func() error {
+		err := pp.enc.Encode(persistentRequest{
+			Args:  args,
+			Stdin: stdin,
+		})
+		if err != nil {
+			return err
+		}
+		return pp.dec.Decode(&resp)
+	})
+	if err != nil {
+		if stderr := pp.stderr.Bytes(); len(stderr) > 0 {
+			return nil, fmt.Errorf("%v\nstderr:\n%s", err, stderr)
+		}
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return resp.Stdout, nil
+}
+
+// wait runs fn, killing the process if ctx is done or fn fails.
+func (pp *persistentProcess) wait(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			pp.kill()
+		}
+		return err
+	case <-ctx.Done():
+		pp.kill()
+		return ctx.Err()
+	}
+}
+
+func (pp *persistentProcess) exited() bool {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	return pp.dead
+}
+
+func (pp *persistentProcess) kill() {
+	pp.dead = true
+	pp.stdin.Close()
+	pp.cmd.Process.Kill()
+	go func() {
+		pp.cmd.Wait()
+		pp.cleanup()
+	}()
+}
+
+// servePersistent implements the serve subcommand by running run for each request until
+// stdin is closed.
+func servePersistent(ctx context.Context, ms *xmain.State, run xmain.RunFunc) error {
+	enc := json.NewEncoder(ms.Stdout)
+	err := enc.Encode(persistentHandshake{Protocol: persistentProtocol})
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(ms.Stdin)
+	for {
+		var req persistentRequest
+		err := dec.Decode(&req)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var resp persistentResponse
+		if len(req.Args) > 0 && req.Args[0] == "serve" {
+			resp.Error = "cannot serve within serve"
+		} else {
+			var stdout bytes.Buffer
+			ms2 := &xmain.State{
+				Name:   ms.Name,
+				Stdin:  bytes.NewReader(req.Stdin),
+				Stdout: nopWriteCloser{&stdout},
+				Stderr: ms.Stderr,
+				Log:    ms.Log,
+				Env:    ms.Env,
+				Opts:   xmain.NewOpts(ms.Env, req.Args),
+				PWD:    ms.PWD,
+			}
+			err = run(ctx, ms2)
+			if err != nil {
+				resp.Error = err.Error()
+			} else {
+				resp.Stdout = stdout.Bytes()
+			}
+		}
+		err = enc.Encode(resp)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// lockedBuffer is written to by the goroutine exec starts to copy stderr.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Reset()
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
@@ -3,17 +3,28 @@
 //
 // Binary plugins are stored in $PATH with the prefix d2plugin-*. i.e the binary for
 // dagre might be d2plugin-dagre. See ListPlugins() below.
+//
+// WASM plugins are WASI modules stored in $PATH with the prefix d2plugin-* and the suffix
+// .wasm, e.g. d2plugin-dagre.wasm. They are run in process, see wasm.go.
+//
+// The d2 CLI also searches the paths passed with --plugin-path, those listed in its
+// configuration file and the plugins it installed before $PATH.
 package d2plugin
 
 import (
 	"context"
 	"encoding/json"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
 
 	"oss.terrastruct.com/util-go/xexec"
 	"oss.terrastruct.com/util-go/xmain"
 
 	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2target"
 )
 
 // plugins contains the bundled d2 plugins.
@@ -85,6 +96,30 @@ type RoutingPlugin interface {
 	RouteEdges(context.Context, *d2graph.Graph, []*d2graph.Edge) error
 }
 
+type MutatingPlugin interface {
+	// MutateGraph runs before the input graph is measured and laid out. It may add, remove or
+	// restyle objects and edges, e.g. to inject a legend.
+	MutateGraph(context.Context, *d2graph.Graph) error
+}
+
+type RenderingPlugin interface {
+	// Render exports the laid out diagram, including its boards, to an output format of
+	// the plugin, e.g. a plotter language or a game engine scene.
+	Render(context.Context, *d2target.Diagram) ([]byte, error)
+}
+
+type ImportResolvingPlugin interface {
+	// ResolveImport returns the D2 text of the file at the import path p, which starts with
+	// a scheme of the plugin, e.g. acme:shared/styles.d2.
+	ResolveImport(ctx context.Context, p string) ([]byte, error)
+}
+
+type IconProvidingPlugin interface {
+	// Icon returns the image of the icon URL href, which has a scheme of the plugin, e.g.
+	// corp://payments/logo.
+	Icon(ctx context.Context, href string) ([]byte, error)
+}
+
 type routeEdgesInput struct {
 	G      []byte `json:"g"`
 	GEdges []byte `json:"gEdges"`
@@ -99,34 +134,81 @@ type PluginInfo struct {
 	LongHelp  string `json:"longHelp"`
 
 	// Set to bundled when returning from the plugin.
-	// execPlugin will set to binary when used.
-	// bundled | binary
+	// execPlugin will set to binary or wasm when used.
+	// bundled | binary | wasm
 	Type string `json:"type"`
-	// If Type == binary then this contains the absolute path to the binary.
+	// If Type == binary or wasm then this contains the absolute path to the binary or module.
 	Path string `json:"path"`
 
 	Features []PluginFeature `json:"features"`
+
+	// Formats are the extensions of the output formats of plugins with the renders
+	// feature, without the leading dot, e.g. hpgl.
+	Formats []string `json:"formats,omitempty"`
+
+	// Schemes are the import path schemes of plugins with the resolves_imports feature,
+	// e.g. acme for imports of acme:shared/styles.
+	Schemes []string `json:"schemes,omitempty"`
+
+	// IconSchemes are the icon URL schemes of plugins with the provides_icons feature,
+	// e.g. corp for icons like corp://payments/logo.
+	IconSchemes []string `json:"iconSchemes,omitempty"`
+
+	// Protocol is the version of the protocol the plugin speaks with d2, see protocol.go.
+	Protocol int `json:"protocol,omitempty"`
 }
 
 const binaryPrefix = "d2plugin-"
 
-func ListPlugins(ctx context.Context) ([]Plugin, error) {
+// ListPlugins returns the bundled plugins followed by those found in paths and then in $PATH.
+// Each path is either a plugin binary or WASM module, or a directory searched like those of
+// $PATH. When several plugins have the same name, the first one is used.
+func ListPlugins(ctx context.Context, paths ...string) ([]Plugin, error) {
+	return ListPluginsWithOptions(ctx, ListOptions{Paths: paths})
+}
+
+// ListPluginsWithOptions is ListPlugins with the binary and WASM plugins it finds
+// restricted by opts.
+func ListPluginsWithOptions(ctx context.Context, opts ListOptions) ([]Plugin, error) {
 	// 1. Run Info on all bundled plugins in the global plugins array.
 	//    - set Type for each bundled plugin to "bundled".
-	// 2. Iterate through directories in $PATH and look for executables within these
-	//    directories with the prefix d2plugin-*
+	// 2. Iterate through paths and directories in $PATH and look for executables within
+	//    these directories with the prefix d2plugin-*
 	// 3. Run each plugin binary with the argument info. e.g. d2plugin-dagre info
+	// 4. Do the same for WASM plugins, files with the prefix d2plugin-* and the suffix .wasm
+	//    within these directories.
 
 	var ps []Plugin
 	ps = append(ps, plugins...)
 
+	var candidates []*execPlugin
+	for _, path := range searchPaths(opts.Paths) {
+		candidates = append(candidates, open(path))
+	}
 	matches, err := xexec.SearchPath(binaryPrefix)
 	if err != nil {
 		return nil, err
 	}
-BINARY_PLUGINS_LOOP:
 	for _, path := range matches {
-		p := &execPlugin{path: path}
+		// Found on Windows where every file with the prefix is considered executable.
+		if strings.HasSuffix(path, wasmSuffix) {
+			continue
+		}
+		candidates = append(candidates, &execPlugin{path: path})
+	}
+	for _, path := range searchWASM() {
+		candidates = append(candidates, newWASMPlugin(path))
+	}
+	for _, p := range candidates {
+		p.sandbox = opts.Sandbox
+		p.sandboxAllowNetwork = opts.SandboxAllowNetwork
+		p.checksum = opts.Checksums[fileName(p.path)]
+		if p.wasm != nil {
+			p.wasm.checksum = p.checksum
+		}
+	}
+BINARY_PLUGINS_LOOP:
+	for _, p := range candidates {
 		info, err := p.Info(ctx)
 		if err != nil {
 			return nil, err
@@ -158,6 +240,50 @@ func ListPluginInfos(ctx context.Context, ps []Plugin) ([]*PluginInfo, error) {
 	return infoSlice, nil
 }
 
+// Open returns the plugin of the binary or WASM module at path.
+func Open(path string) Plugin {
+	return open(path)
+}
+
+func open(path string) *execPlugin {
+	if strings.HasSuffix(path, wasmSuffix) {
+		return newWASMPlugin(path)
+	}
+	return &execPlugin{path: path}
+}
+
+// searchPaths returns the plugins of paths: files as is and the plugins within directories.
+func searchPaths(paths []string) []string {
+	var matches []string
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		if !fi.IsDir() {
+			matches = append(matches, p)
+			continue
+		}
+		files, err := os.ReadDir(p)
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			if f.IsDir() || !strings.HasPrefix(f.Name(), binaryPrefix) {
+				continue
+			}
+			if !strings.HasSuffix(f.Name(), wasmSuffix) && runtime.GOOS != "windows" {
+				fi, err := f.Info()
+				if err != nil || fi.Mode()&0111 == 0 {
+					continue
+				}
+			}
+			matches = append(matches, filepath.Join(p, f.Name()))
+		}
+	}
+	return matches
+}
+
 // FindPlugin finds the plugin with the given name.
 //  1. It first searches the bundled plugins in the global plugins slice.
 //  2. If not found, it then searches each directory in $PATH for a binary with the name
@@ -177,6 +303,32 @@ func FindPlugin(ctx context.Context, ps []Plugin, name string) (Plugin, error) {
 	return nil, exec.ErrNotFound
 }
 
+// FindRenderer finds the plugin that renders the output format with the extension ext,
+// e.g. .hpgl. It returns nil if there is none.
+func FindRenderer(ctx context.Context, ps []Plugin, ext string) (RenderingPlugin, error) {
+	format := strings.TrimPrefix(ext, ".")
+	if format == "" {
+		return nil, nil
+	}
+	for _, p := range ps {
+		info, err := p.Info(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !HasFeature(info, RENDERS) {
+			continue
+		}
+		for _, f := range info.Formats {
+			if strings.EqualFold(f, format) {
+				if rp, ok := p.(RenderingPlugin); ok {
+					return rp, nil
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
 func ListPluginFlags(ctx context.Context, ps []Plugin) ([]PluginSpecificFlag, error) {
 	var out []PluginSpecificFlag
 	for _, p := range ps {
@@ -59,6 +59,13 @@ func (p elkPlugin) Flags(context.Context) ([]PluginSpecificFlag, error) {
 			Usage:   "spacing to be preserved between a node and its self loops",
 			Tag:     "elk.spacing.nodeSelfLoop",
 		},
+		{
+			Name:    "elk-nativeMinNodes",
+			Type:    "int64",
+			Default: int64(d2elklayout.DefaultOpts.NativeMinNodes),
+			Usage:   "number of shapes from which graphs without containers are laid out natively, much faster than ELK on large graphs. 0 to disable",
+			Tag:     "d2.nativeMinNodes",
+		},
 	}, nil
 }
 
@@ -24,6 +24,32 @@ const DESCENDANT_EDGES PluginFeature = "descendant_edges"
 // When this is true, the plugin also implements RoutingPlugin interface to route edges
 const ROUTES_EDGES PluginFeature = "routes_edges"
 
+// When this is true, the plugin has no layout of its own. It implements RoutingPlugin to route
+// the edges of graphs whose objects were placed by another layout engine, and is selected
+// with --router rather than --layout.
+const ROUTES_EDGES_ONLY PluginFeature = "routes_edges_only"
+
+// When this is true, the plugin also implements MutatingPlugin interface to modify the graph
+// before layout when selected with --mutator
+const MUTATES_GRAPH PluginFeature = "mutates_graph"
+
+// When this is true, the plugin also implements RenderingPlugin to export diagrams to the
+// formats of PluginInfo.Formats
+const RENDERS PluginFeature = "renders"
+
+// When this is true, the plugin also implements ImportResolvingPlugin to resolve imports
+// with the schemes of PluginInfo.Schemes, e.g. @"acme:shared/styles". See ImportFS.
+const RESOLVES_IMPORTS PluginFeature = "resolves_imports"
+
+// When this is true, the plugin also implements IconProvidingPlugin to provide the images
+// of icon URLs with the schemes of PluginInfo.IconSchemes, e.g. corp://payments/logo. See
+// IconProviders.
+const PROVIDES_ICONS PluginFeature = "provides_icons"
+
+// When this is true, the plugin binary can be started once with the serve subcommand and
+// answer every request of the d2 process over its stdin and stdout. See persistent.go.
+const PERSISTENT PluginFeature = "persistent"
+
 func FeatureSupportCheck(info *PluginInfo, g *d2graph.Graph) error {
 	// Older version of plugin. Skip checking.
 	if info.Features == nil {
@@ -76,3 +102,13 @@ func FeatureSupportCheck(info *PluginInfo, g *d2graph.Graph) error {
 	}
 	return nil
 }
+
+// HasFeature reports whether the plugin of info has the feature f.
+func HasFeature(info *PluginInfo, f PluginFeature) bool {
+	for _, f2 := range info.Features {
+		if f2 == f {
+			return true
+		}
+	}
+	return false
+}
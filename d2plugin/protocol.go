@@ -0,0 +1,72 @@
+package d2plugin
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"oss.terrastruct.com/d2/lib/version"
+)
+
+// ProtocolVersion is the latest version of the protocol between d2 and binary and WASM
+// plugins, see exec.go. It is incremented with every change older plugins or older d2
+// binaries would not understand, along with d2graph.SerializedGraphVersion when the
+// change is to the serialized graph.
+const ProtocolVersion = 1
+
+// MinProtocolVersion is the oldest version of the protocol this version of d2 and plugins
+// built with it still speak.
+const MinProtocolVersion = 1
+
+// protocolEnv is set to ProtocolVersion when d2 runs plugins so that they can answer info
+// with the version they will speak with it.
+//
+// Versions are negotiated as follows.
+//  1. d2 runs info with protocolEnv set to the latest version it speaks.
+//  2. The plugin sets PluginInfo.Protocol to the latest version both speak, or fails if
+//     d2 is too old.
+//  3. d2 checks that it speaks PluginInfo.Protocol, and fails if the plugin is too old or
+//     too new.
+//
+// Plugins built before versioning set no protocol version, which is version 1.
+const protocolEnv = "D2_PLUGIN_PROTOCOL"
+
+// negotiateProtocol returns the version of the protocol a plugin speaks with the d2
+// binary that set protocolEnv to env.
+func negotiateProtocol(env string) (int, error) {
+	if env == "" {
+		// d2 predates versioning.
+		return MinProtocolVersion, nil
+	}
+	v, err := strconv.Atoi(env)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q", protocolEnv, env)
+	}
+	if v < MinProtocolVersion {
+		return 0, fmt.Errorf("d2 speaks plugin protocol version %d but this plugin requires at least version %d, upgrade d2", v, MinProtocolVersion)
+	}
+	if v > ProtocolVersion {
+		v = ProtocolVersion
+	}
+	return v, nil
+}
+
+// checkProtocol returns an error if d2 does not speak the protocol version of info.
+func checkProtocol(info *PluginInfo) error {
+	v := info.Protocol
+	if v == 0 {
+		v = 1
+	}
+	if v < MinProtocolVersion {
+		return fmt.Errorf("plugin %s speaks protocol version %d but d2 %s requires at least version %d, upgrade the plugin", info.Name, v, version.Version, MinProtocolVersion)
+	}
+	if v > ProtocolVersion {
+		return fmt.Errorf("plugin %s speaks protocol version %d but d2 %s supports up to version %d, upgrade d2", info.Name, v, version.Version, ProtocolVersion)
+	}
+	return nil
+}
+
+// protocolEnviron returns the environment to run plugin processes with.
+func protocolEnviron() []string {
+	return append(os.Environ(), fmt.Sprintf("%s=%d", protocolEnv, ProtocolVersion))
+}
@@ -0,0 +1,192 @@
+package d2plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+// ListOptions configure how ListPluginsWithOptions finds plugins and how the binary and
+// WASM plugins it finds run.
+type ListOptions struct {
+	// Paths are searched before $PATH, see ListPlugins.
+	Paths []string
+
+	// Sandbox runs binary plugins with an environment that only has $PATH and the version
+	// of the protocol, in an empty temporary working directory and without network access.
+	// Only Linux where user namespaces are allowed can isolate plugins from the network,
+	// elsewhere plugins fail to start unless SandboxAllowNetwork is set. WASM plugins are
+	// always sandboxed.
+	Sandbox bool
+	// SandboxAllowNetwork runs sandboxed plugins with network access, with a warning,
+	// where they cannot be isolated from the network instead of failing.
+	SandboxAllowNetwork bool
+
+	// Checksums pins the SHA-256 checksums of the files of plugins by name, e.g. dagre for
+	// d2plugin-dagre or d2plugin-dagre.wasm. Plugins whose file has another checksum fail
+	// instead of running. Binary plugins with a pinned checksum run from a verified copy
+	// in a temporary directory. Checksums are hex encoded and may be prefixed with sha256:.
+	Checksums map[string]string
+}
+
+// fileName returns the name of the plugin at path from its file name, e.g. dagre for
+// d2plugin-dagre.exe.
+func fileName(path string) string {
+	name := strings.TrimPrefix(filepath.Base(path), binaryPrefix)
+	name = strings.TrimSuffix(name, wasmSuffix)
+	if runtime.GOOS == "windows" {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return name
+}
+
+// verifyChecksum checks b, the content of the file of the plugin at path, against the
+// pinned checksum.
+func verifyChecksum(path string, b []byte, checksum string) error {
+	sum := sha256.Sum256(b)
+	got := hex.EncodeToString(sum[:])
+	want := strings.ToLower(strings.TrimPrefix(checksum, "sha256:"))
+	if got != want {
+		return fmt.Errorf("checksum mismatch for %s: pinned sha256:%s but the file has sha256:%s", path, want, got)
+	}
+	return nil
+}
+
+// Checksum returns the hex encoded SHA-256 checksum of the file at path, as pinned in
+// ListOptions.Checksums.
+func Checksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifiedCopy copies the plugin binary into a new temporary directory that only the user
+// can write to, if its checksum is pinned, and returns the path of the copy. The copy is
+// what's verified and run so that the file cannot be swapped in between. cleanup removes
+// the copy.
+func (p *execPlugin) verifiedCopy() (path string, cleanup func(), _ error) {
+	if p.checksum == "" {
+		return p.path, func() {}, nil
+	}
+	b, err := os.ReadFile(p.path)
+	if err != nil {
+		return "", nil, err
+	}
+	err = verifyChecksum(p.path, b, p.checksum)
+	if err != nil {
+		return "", nil, err
+	}
+	dir, err := os.MkdirTemp("", "d2plugin-verified-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() {
+		os.RemoveAll(dir)
+	}
+	path = filepath.Join(dir, filepath.Base(p.path))
+	err = os.WriteFile(path, b, 0o700)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return path, cleanup, nil
+}
+
+// start starts the plugin binary with args. setup is called to connect the standard
+// streams of the command before it is started. The process is killed once ctx is done if
+// bound is set, otherwise ctx is only used to log. cleanup must be called once the
+// process exits.
+func (p *execPlugin) start(ctx context.Context, bound bool, setup func(*exec.Cmd) error, args ...string) (_ *exec.Cmd, cleanup func(), err error) {
+	path, removeCopy, err := p.verifiedCopy()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			removeCopy()
+		}
+	}()
+
+	isolate := p.sandbox
+	for {
+		var cmd *exec.Cmd
+		if bound {
+			cmd = exec.CommandContext(ctx, path, args...)
+		} else {
+			cmd = exec.Command(path, args...)
+		}
+		cmd.Env = protocolEnviron()
+		removeDir := func() {}
+		if p.sandbox {
+			dir, err := os.MkdirTemp("", "d2plugin-sandbox-")
+			if err != nil {
+				return nil, nil, err
+			}
+			removeDir = func() {
+				os.RemoveAll(dir)
+			}
+			cmd.Dir = dir
+			cmd.Env = sandboxEnviron(dir)
+		}
+		err = nil
+		if isolate {
+			err = isolateNetwork(cmd)
+		}
+		if err == nil {
+			err = setup(cmd)
+		}
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			removeDir()
+			if isolate && networkIsolationFailed(cmd, err) {
+				if !p.sandboxAllowNetwork {
+					return nil, nil, fmt.Errorf("failed to isolate sandboxed plugin from the network, allow it network access to run it anyway: %w", err)
+				}
+				log.Warn(ctx, fmt.Sprintf("running sandboxed plugin %s with network access as it could not be isolated from the network: %v", p.path, err))
+				isolate = false
+				continue
+			}
+			return nil, nil, err
+		}
+		cleanup = func() {
+			removeDir()
+			removeCopy()
+		}
+		return cmd, cleanup, nil
+	}
+}
+
+// sandboxEnviron returns the environment of sandboxed plugins with the working directory
+// dir.
+func sandboxEnviron(dir string) []string {
+	env := []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + dir,
+		"TMPDIR=" + dir,
+		fmt.Sprintf("%s=%d", protocolEnv, ProtocolVersion),
+	}
+	if runtime.GOOS == "windows" {
+		// Required to start processes at all.
+		env = append(env, "SYSTEMROOT="+os.Getenv("SYSTEMROOT"))
+	}
+	return env
+}
@@ -0,0 +1,34 @@
+//go:build linux
+
+package d2plugin
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// isolateNetwork starts cmd in new user and network namespaces so that it has no network
+// interface but loopback.
+func isolateNetwork(cmd *exec.Cmd) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET,
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1},
+		},
+	}
+	return nil
+}
+
+// networkIsolationFailed reports whether cmd failed to start because of isolateNetwork,
+// e.g. because unprivileged user namespaces are disabled.
+func networkIsolationFailed(cmd *exec.Cmd, err error) bool {
+	if cmd.SysProcAttr == nil || cmd.SysProcAttr.Cloneflags == 0 {
+		return false
+	}
+	return errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOSPC)
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package d2plugin
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+)
+
+var errNoNetworkIsolation = errors.New("plugins cannot be isolated from the network on " + runtime.GOOS)
+
+// isolateNetwork always fails as only Linux can run processes without network access.
+func isolateNetwork(cmd *exec.Cmd) error {
+	return errNoNetworkIsolation
+}
+
+func networkIsolationFailed(cmd *exec.Cmd, err error) bool {
+	return errors.Is(err, errNoNetworkIsolation)
+}
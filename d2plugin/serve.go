@@ -10,6 +10,7 @@ import (
 	"github.com/spf13/pflag"
 
 	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2target"
 	"oss.terrastruct.com/util-go/xmain"
 )
 
@@ -20,7 +21,8 @@ import (
 //
 // Also see execPlugin in exec.go for the d2 binary plugin protocol.
 func Serve(p Plugin) xmain.RunFunc {
-	return func(ctx context.Context, ms *xmain.State) (err error) {
+	var run xmain.RunFunc
+	run = func(ctx context.Context, ms *xmain.State) (err error) {
 		if !ms.Opts.Flags.Parsed() {
 			fs, err := p.Flags(ctx)
 			if err != nil {
@@ -64,14 +66,46 @@ func Serve(p Plugin) xmain.RunFunc {
 				return fmt.Errorf("plugin has routing feature but does not implement RoutingPlugin")
 			}
 			return routeEdges(ctx, routingPlugin, ms)
+		case "mutategraph":
+			mutatingPlugin, ok := p.(MutatingPlugin)
+			if !ok {
+				return fmt.Errorf("plugin has graph mutation feature but does not implement MutatingPlugin")
+			}
+			return mutateGraph(ctx, mutatingPlugin, ms)
+		case "render":
+			renderingPlugin, ok := p.(RenderingPlugin)
+			if !ok {
+				return fmt.Errorf("plugin has renders feature but does not implement RenderingPlugin")
+			}
+			return render(ctx, renderingPlugin, ms)
+		case "resolveimport":
+			resolvingPlugin, ok := p.(ImportResolvingPlugin)
+			if !ok {
+				return fmt.Errorf("plugin has import resolution feature but does not implement ImportResolvingPlugin")
+			}
+			return resolveImport(ctx, resolvingPlugin, ms)
+		case "icon":
+			iconPlugin, ok := p.(IconProvidingPlugin)
+			if !ok {
+				return fmt.Errorf("plugin has icon provider feature but does not implement IconProvidingPlugin")
+			}
+			return icon(ctx, iconPlugin, ms)
+		case "serve":
+			return servePersistent(ctx, ms, run)
 		default:
 			return xmain.UsageErrorf("unrecognized command: %s", subcmd)
 		}
 	}
+	return run
 }
 
 func info(ctx context.Context, p Plugin, ms *xmain.State) error {
-	info, err := p.Info(ctx)
+	pinfo, err := p.Info(ctx)
+	if err != nil {
+		return err
+	}
+	info := *pinfo
+	info.Protocol, err = negotiateProtocol(ms.Env.Getenv(protocolEnv))
 	if err != nil {
 		return err
 	}
@@ -126,6 +160,30 @@ func layout(ctx context.Context, p Plugin, ms *xmain.State) error {
 	return nil
 }
 
+func mutateGraph(ctx context.Context, p MutatingPlugin, ms *xmain.State) error {
+	in, err := io.ReadAll(ms.Stdin)
+	if err != nil {
+		return err
+	}
+	var g d2graph.Graph
+	if err := d2graph.DeserializeGraph(in, &g); err != nil {
+		return fmt.Errorf("failed to unmarshal input to graph: %s", in)
+	}
+	err = p.MutateGraph(ctx, &g)
+	if err != nil {
+		return err
+	}
+	b, err := d2graph.SerializeGraph(&g)
+	if err != nil {
+		return err
+	}
+	_, err = ms.Stdout.Write(b)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func postProcess(ctx context.Context, p Plugin, ms *xmain.State) error {
 	in, err := io.ReadAll(ms.Stdin)
 	if err != nil {
@@ -181,3 +239,45 @@ func routeEdges(ctx context.Context, p RoutingPlugin, ms *xmain.State) error {
 	}
 	return nil
 }
+
+func render(ctx context.Context, p RenderingPlugin, ms *xmain.State) error {
+	in, err := io.ReadAll(ms.Stdin)
+	if err != nil {
+		return err
+	}
+	var diagram d2target.Diagram
+	err = json.Unmarshal(in, &diagram)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal input to diagram: %w", err)
+	}
+	out, err := p.Render(ctx, &diagram)
+	if err != nil {
+		return err
+	}
+	_, err = ms.Stdout.Write(out)
+	return err
+}
+
+func resolveImport(ctx context.Context, p ImportResolvingPlugin, ms *xmain.State) error {
+	if len(ms.Opts.Flags.Args()) != 2 {
+		return xmain.UsageErrorf("resolveimport must be passed the import path")
+	}
+	b, err := p.ResolveImport(ctx, ms.Opts.Flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	_, err = ms.Stdout.Write(b)
+	return err
+}
+
+func icon(ctx context.Context, p IconProvidingPlugin, ms *xmain.State) error {
+	if len(ms.Opts.Flags.Args()) != 2 {
+		return xmain.UsageErrorf("icon must be passed the icon URL")
+	}
+	b, err := p.Icon(ctx, ms.Opts.Flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	_, err = ms.Stdout.Write(b)
+	return err
+}
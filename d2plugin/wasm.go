@@ -0,0 +1,147 @@
+package d2plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+	"oss.terrastruct.com/util-go/xdefer"
+)
+
+// wasmSuffix is the suffix of WASM plugins. e.g. the module for a plugin named foo is
+// d2plugin-foo.wasm.
+const wasmSuffix = ".wasm"
+
+// wasmModule runs a plugin compiled to a WASI command module, e.g. with
+// GOOS=wasip1 GOARCH=wasm, in process with wazero. It speaks the same protocol as binary
+// plugins over its arguments, stdin and stdout, so that the same plugin source built with
+// Serve works as either.
+//
+// Unlike binary plugins, WASM plugins are a single file that runs on every platform and
+// they are sandboxed: they cannot access the filesystem, the network or the environment,
+// which only has the variable with the version of the protocol d2 speaks.
+type wasmModule struct {
+	path string
+	// checksum is pinned in ListOptions.Checksums, if at all.
+	checksum string
+
+	once     sync.Once
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	err      error
+}
+
+func newWASMPlugin(path string) *execPlugin {
+	return &execPlugin{
+		path: path,
+		wasm: &wasmModule{path: path},
+	}
+}
+
+// load compiles the module, after checking it against its checksum if pinned. Compiled
+// modules are cached in the user cache directory as compiling is much slower than
+// instantiating.
+func (m *wasmModule) load(ctx context.Context) error {
+	m.once.Do(func() {
+		defer xdefer.Errorf(&m.err, "failed to load %s", m.path)
+
+		var b []byte
+		b, m.err = os.ReadFile(m.path)
+		if m.err != nil {
+			return
+		}
+		if m.checksum != "" {
+			m.err = verifyChecksum(m.path, b, m.checksum)
+			if m.err != nil {
+				return
+			}
+		}
+
+		config := wazero.NewRuntimeConfig().WithCloseOnContextDone(true)
+		if dir, err := os.UserCacheDir(); err == nil {
+			cache, err := wazero.NewCompilationCacheWithDir(filepath.Join(dir, "d2", "wasm"))
+			if err == nil {
+				config = config.WithCompilationCache(cache)
+			}
+		}
+		// The runtime lives as long as the process so it must not be bound to ctx.
+		m.runtime = wazero.NewRuntimeWithConfig(context.Background(), config)
+		wasi_snapshot_preview1.MustInstantiate(context.Background(), m.runtime)
+
+		m.compiled, m.err = m.runtime.CompileModule(ctx, b)
+	})
+	return m.err
+}
+
+// run instantiates a new instance of the module, which runs it to completion as WASI
+// commands run their main function on instantiation.
+func (m *wasmModule) run(ctx context.Context, stdin []byte, args ...string) ([]byte, error) {
+	err := m.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	config := wazero.NewModuleConfig().
+		// Anonymous so that concurrent runs don't conflict.
+		WithName("").
+		WithArgs(append([]string{filepath.Base(m.path)}, args...)...).
+		WithStdin(bytes.NewReader(stdin)).
+		WithStdout(&stdout).
+		WithStderr(&stderr).
+		WithEnv(protocolEnv, strconv.Itoa(ProtocolVersion)).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader)
+	mod, err := m.runtime.InstantiateModule(ctx, m.compiled, config)
+	if mod != nil {
+		mod.Close(ctx)
+	}
+	if err != nil {
+		var ee *sys.ExitError
+		if errors.As(err, &ee) && ee.ExitCode() == 0 {
+			return stdout.Bytes(), nil
+		}
+		if stderr.Len() > 0 {
+			return nil, fmt.Errorf("%v\nstderr:\n%s", err, stderr.Bytes())
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// searchWASM returns the paths of the WASM plugins in the directories of $PATH.
+func searchWASM() []string {
+	var matches []string
+	seen := make(map[string]struct{})
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir == "" {
+			dir = "."
+		}
+		if _, ok := seen[dir]; ok {
+			continue
+		}
+		seen[dir] = struct{}{}
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			if !f.IsDir() && strings.HasPrefix(f.Name(), binaryPrefix) && strings.HasSuffix(f.Name(), wasmSuffix) {
+				matches = append(matches, filepath.Join(dir, f.Name()))
+			}
+		}
+	}
+	return matches
+}
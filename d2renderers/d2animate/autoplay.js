@@ -0,0 +1,39 @@
+// d2AnimationAutoplay holds the animated diagram diagramHash on its first frame until it
+// is first scrolled into view.
+function d2AnimationAutoplay(diagramHash) {
+  var script = document.currentScript;
+  if (!script || !document.getAnimations || !window.IntersectionObserver) {
+    return;
+  }
+  var svg = script.ownerSVGElement;
+  var prefixes = ["d2Transition-" + diagramHash + "-", "d2Morph-" + diagramHash + "-"];
+  var animations = function () {
+    return document.getAnimations().filter(function (a) {
+      return (
+        a.animationName &&
+        prefixes.some(function (prefix) {
+          return a.animationName.indexOf(prefix) === 0;
+        })
+      );
+    });
+  };
+
+  animations().forEach(function (a) {
+    a.pause();
+    a.currentTime = 0;
+  });
+  var observer = new IntersectionObserver(function (entries) {
+    if (
+      !entries.some(function (e) {
+        return e.isIntersecting;
+      })
+    ) {
+      return;
+    }
+    observer.disconnect();
+    animations().forEach(function (a) {
+      a.play();
+    });
+  });
+  observer.observe(svg);
+}
@@ -0,0 +1,147 @@
+// d2AnimationControls wires up the playback controls of the animated diagram diagramHash,
+// whose boards start at starts milliseconds into an animation of totalMS.
+function d2AnimationControls(diagramHash, starts, totalMS, trackWidth, controlsHeight) {
+  var controls = document.getElementById("d2-controls-" + diagramHash);
+  if (!controls || !document.getAnimations) {
+    return;
+  }
+  var svg = controls.ownerSVGElement;
+  var control = function (name) {
+    return controls.querySelector('[data-d2-control="' + name + '"]');
+  };
+  var play = control("play");
+  var track = control("track");
+  var progress = control("progress");
+  var handle = control("handle");
+  var label = control("label");
+
+  var viewBox = svg.viewBox.baseVal;
+  svg.setAttribute(
+    "viewBox",
+    [viewBox.x, viewBox.y, viewBox.width, viewBox.height + controlsHeight].join(" ")
+  );
+  controls.style.display = "";
+
+  // The boards fade with the d2Transition animations and their shapes morph with d2Morph.
+  var prefixes = ["d2Transition-" + diagramHash + "-", "d2Morph-" + diagramHash + "-"];
+  var animations = function () {
+    return document.getAnimations().filter(function (a) {
+      return (
+        a.animationName &&
+        prefixes.some(function (prefix) {
+          return a.animationName.indexOf(prefix) === 0;
+        })
+      );
+    });
+  };
+  // Animations can be delayed and play every other loop backwards, so times are read and
+  // set through their computed timing rather than their current time.
+  var time = function () {
+    var a = animations()[0];
+    var progress = a ? a.effect.getComputedTiming().progress : 0;
+    return (progress || 0) * totalMS;
+  };
+  var seek = function (t) {
+    animations().forEach(function (a) {
+      var timing = a.effect.getComputedTiming();
+      var loop = timing.currentIteration;
+      if (loop === null) {
+        loop = a.currentTime < timing.delay ? 0 : timing.iterations - 1;
+      }
+      var backwards =
+        timing.direction === "reverse" ||
+        (timing.direction === "alternate" && loop % 2 === 1);
+      a.currentTime = timing.delay + loop * totalMS + (backwards ? totalMS - t : t);
+    });
+  };
+  var board = function (t) {
+    var i = 0;
+    while (i + 1 < starts.length && starts[i + 1] <= t) {
+      i++;
+    }
+    return i;
+  };
+
+  // The animation is also paused while it waits to autoplay, and stops after its loops.
+  var isPaused = function () {
+    var a = animations()[0];
+    return !a || a.playState !== "running";
+  };
+  var setPaused = function (p) {
+    animations().forEach(function (a) {
+      if (p) {
+        a.pause();
+      } else {
+        a.play();
+      }
+    });
+  };
+  var step = function (delta) {
+    var i = (board(time()) + delta + starts.length) % starts.length;
+    setPaused(true);
+    seek(starts[i]);
+  };
+
+  control("back").addEventListener("click", function () {
+    step(-1);
+  });
+  control("forward").addEventListener("click", function () {
+    step(1);
+  });
+  play.addEventListener("click", function () {
+    setPaused(!isPaused());
+  });
+
+  // Scrubbing pauses the animation on the board the pointer is released on.
+  var scrubbing = false;
+  var scrub = function (e) {
+    var p = svg.createSVGPoint();
+    p.x = e.clientX;
+    p.y = e.clientY;
+    p = p.matrixTransform(track.getScreenCTM().inverse());
+    seek((Math.min(Math.max(p.x / trackWidth, 0), 1) * totalMS) % totalMS);
+  };
+  track.addEventListener("pointerdown", function (e) {
+    scrubbing = true;
+    track.setPointerCapture(e.pointerId);
+    setPaused(true);
+    scrub(e);
+  });
+  track.addEventListener("pointermove", function (e) {
+    if (scrubbing) {
+      scrub(e);
+    }
+  });
+  track.addEventListener("pointerup", function () {
+    scrubbing = false;
+  });
+
+  // Keys only control the animation when it is the document, not when it is inlined in a page.
+  if (document.documentElement === svg) {
+    document.addEventListener("keydown", function (e) {
+      if (e.key === " ") {
+        setPaused(!isPaused());
+      } else if (e.key === "ArrowLeft") {
+        step(-1);
+      } else if (e.key === "ArrowRight") {
+        step(1);
+      } else {
+        return;
+      }
+      e.preventDefault();
+    });
+  }
+
+  var update = function () {
+    var x = (time() / totalMS) * trackWidth;
+    progress.setAttribute("width", x);
+    handle.setAttribute("cx", x);
+    label.textContent = board(time()) + 1 + " / " + starts.length;
+    // The first icon of the play button is play, the second pause.
+    var paused = isPaused();
+    play.children[2].style.display = paused ? "" : "none";
+    play.children[3].style.display = paused ? "none" : "";
+    requestAnimationFrame(update);
+  };
+  update();
+}
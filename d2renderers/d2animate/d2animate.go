@@ -2,10 +2,14 @@ package d2animate
 
 import (
 	"bytes"
+	_ "embed"
+	"encoding/json"
 	"fmt"
 	"math"
 	"strings"
 
+	"oss.terrastruct.com/util-go/go2"
+
 	"oss.terrastruct.com/d2/d2renderers/d2sketch"
 	"oss.terrastruct.com/d2/d2renderers/d2svg"
 	"oss.terrastruct.com/d2/d2target"
@@ -14,36 +18,140 @@ import (
 
 var transitionDurationMS = 1
 
-func makeKeyframe(delayMS, durationMS, totalMS, identifier int, diagramHash string) string {
-	percentageBefore := (math.Max(0, float64(delayMS-transitionDurationMS)) / float64(totalMS)) * 100.
-	percentageStart := (float64(delayMS) / float64(totalMS)) * 100.
-	percentageEnd := (float64(delayMS+durationMS-transitionDurationMS) / float64(totalMS)) * 100.
+// controlsHeight is the height of the bar of playback controls below the diagram.
+const controlsHeight = 48
+
+//go:embed controls.js
+var controlsJS string
+
+//go:embed autoplay.js
+var autoplayJS string
+
+// Options configure the SVGs packaged by WrapWithOptions.
+type Options struct {
+	// Transitions are the transitions to each board, see WrapWithOptions.
+	Transitions []d2target.Transition
+	// Controls adds play/pause, step buttons and a scrubber below the diagram. They are
+	// shown by a script, so they stay hidden where scripts don't run, like in <img> tags.
+	Controls bool
+	// Morph moves and scales the shapes in consecutive boards from their box in one board to
+	// their box in the next while the boards fade, instead of only fading between them.
+	Morph bool
+	// Loops is how many times the animation plays before stopping on its last frame, 0 to
+	// loop forever.
+	Loops int
+	// Alternate plays every other loop backwards, so the animation goes back and forth
+	// instead of cutting back to the first board.
+	Alternate bool
+	// DelayMS is how long the first board is shown before the animation starts.
+	DelayMS int
+	// AutoplayVisible holds the animation until the SVG is first scrolled into view. It is
+	// started by a script, so it plays right away where scripts don't run.
+	AutoplayVisible bool
+}
+
+// animation returns the CSS animation that plays the keyframes name over totalMS as set by
+// opts.
+func (opts Options) animation(name string, totalMS int) string {
+	s := fmt.Sprintf("%s %dms", name, totalMS)
+	if opts.DelayMS > 0 {
+		s += fmt.Sprintf(" %dms", opts.DelayMS)
+	}
+	if opts.Loops > 0 {
+		s += fmt.Sprintf(" %d", opts.Loops)
+	} else {
+		s += " infinite"
+	}
+	if opts.Alternate {
+		s += " alternate"
+	}
+	// The first frame is held over the delay, and the last once the loops are done.
+	if opts.DelayMS > 0 || opts.Loops > 0 {
+		s += " both"
+	}
+	return s
+}
+
+// makeKeyframe returns the keyframes of the board identifier, which fades in from fadeInMS
+// to startMS with easeIn, is shown until endMS and fades out until afterMS with easeOut.
+func makeKeyframe(fadeInMS, startMS, endMS, afterMS, totalMS, identifier int, diagramHash, easeIn, easeOut string) string {
+	percentageBefore := (float64(fadeInMS) / float64(totalMS)) * 100.
+	percentageStart := (float64(startMS) / float64(totalMS)) * 100.
+	percentageEnd := (float64(endMS) / float64(totalMS)) * 100.
 	if int(math.Ceil(percentageEnd)) == 100 {
 		return fmt.Sprintf(`@keyframes d2Transition-%s-%d {
 		0%%, %f%% {
-				opacity: 0;
+				opacity: 0;%s
 		}
 		%f%%, %f%% {
 				opacity: 1;
 		}
-}`, diagramHash, identifier, percentageBefore, percentageStart, math.Ceil(percentageEnd))
+}`, diagramHash, identifier, percentageBefore, timingFunction(easeIn), percentageStart, math.Ceil(percentageEnd))
 	}
 
-	percentageAfter := (float64(delayMS+durationMS) / float64(totalMS)) * 100.
+	percentageAfter := (float64(afterMS) / float64(totalMS)) * 100.
 	return fmt.Sprintf(`@keyframes d2Transition-%s-%d {
 		0%%, %f%% {
-				opacity: 0;
+				opacity: 0;%s
 		}
 		%f%%, %f%% {
-				opacity: 1;
+				opacity: 1;%s
 		}
 		%f%%, 100%% {
 				opacity: 0;
 		}
-}`, diagramHash, identifier, percentageBefore, percentageStart, percentageEnd, percentageAfter)
+}`, diagramHash, identifier, percentageBefore, timingFunction(easeIn), percentageStart, percentageEnd, timingFunction(easeOut), percentageAfter)
+}
+
+// timingFunction returns the declaration of the easing of the keyframes from a keyframe to
+// the next, none for the default of browsers.
+func timingFunction(easing string) string {
+	if easing == "" {
+		return ""
+	}
+	return fmt.Sprintf(`
+				animation-timing-function: %s;`, easing)
 }
 
+// Wrap packages svgs, the boards of rootDiagram, into one SVG that transitions through
+// them, showing each for intervalMS.
 func Wrap(rootDiagram *d2target.Diagram, svgs [][]byte, renderOpts d2svg.RenderOpts, intervalMS int) ([]byte, error) {
+	transitions := make([]d2target.Transition, len(svgs))
+	for i := range transitions {
+		transitions[i].Interval = intervalMS
+	}
+	return WrapWithOptions(rootDiagram, svgs, renderOpts, Options{Transitions: transitions})
+}
+
+// WrapWithOptions is Wrap with the transition to each of svgs in opts. Boards fade in over
+// the end of the previous board, so their durations are capped by its interval. The
+// transition of the first board only sets its interval, as the animation cuts back to it
+// when it loops.
+func WrapWithOptions(rootDiagram *d2target.Diagram, svgs [][]byte, renderOpts d2svg.RenderOpts, opts Options) ([]byte, error) {
+	transitions := opts.Transitions
+	if len(transitions) != len(svgs) {
+		return nil, fmt.Errorf("%d transitions given for %d boards", len(transitions), len(svgs))
+	}
+	// The board i is shown from starts[i] to starts[i+1], after fading in over fades[i].
+	starts := make([]int, len(svgs)+1)
+	fades := make([]int, len(svgs)+1)
+	for i, tr := range transitions {
+		if tr.Interval <= 0 {
+			return nil, fmt.Errorf("the interval of board %d must be greater than 0", i)
+		}
+		starts[i+1] = starts[i] + tr.Interval
+		fades[i] = transitionDurationMS
+		duration := tr.Duration
+		if duration == 0 && opts.Morph {
+			duration = morphDurationMS
+		}
+		if i > 0 && duration > 0 {
+			fades[i] = go2.Min(duration, transitions[i-1].Interval)
+		}
+	}
+	fades[len(svgs)] = transitionDurationMS
+	totalMS := starts[len(svgs)]
+
 	buf := &bytes.Buffer{}
 
 	// TODO account for stroke width of root border
@@ -99,18 +207,103 @@ func Wrap(rootDiagram *d2target.Diagram, svgs [][]byte, renderOpts d2svg.RenderO
 
 	fmt.Fprint(buf, `<style type="text/css"><![CDATA[`)
 	for i := range svgs {
-		fmt.Fprint(buf, makeKeyframe(i*intervalMS, intervalMS, len(svgs)*intervalMS, i, diagramHash))
+		var easeIn, easeOut string
+		if i > 0 {
+			easeIn = transitions[i].Easing
+		}
+		if i+1 < len(svgs) {
+			easeOut = transitions[i+1].Easing
+		}
+		fmt.Fprint(buf, makeKeyframe(go2.Max(0, starts[i]-fades[i]), starts[i], starts[i+1]-fades[i+1], starts[i+1], totalMS, i, diagramHash, easeIn, easeOut))
+	}
+	var boards []*d2target.Diagram
+	if opts.Morph {
+		boards = flattenBoards(rootDiagram)
+		if len(boards) != len(svgs) {
+			return nil, fmt.Errorf("%d boards to morph for %d boards", len(boards), len(svgs))
+		}
+		writeMorphs(buf, diagramHash, boards, starts, fades, totalMS, transitions, opts)
 	}
 	fmt.Fprint(buf, `]]></style>`)
 
+	// Boards are faded rather than hidden, so that the animations within them, like the
+	// dashes of animated connections, run on one clock instead of restarting on every board.
 	for i, svg := range svgs {
 		str := string(svg)
-		str = strings.Replace(str, "<g", fmt.Sprintf(`<g style="animation: d2Transition-%s-%d %dms infinite"`, diagramHash, i, len(svgs)*intervalMS), 1)
+		// Morphs target the shapes of each board by the id of its group.
+		idAttr := ""
+		if opts.Morph {
+			idAttr = fmt.Sprintf(` id="%s"`, boardID(diagramHash, i))
+		}
+		str = strings.Replace(str, "<g", fmt.Sprintf(`<g%s style="animation: %s"`, idAttr, opts.animation(fmt.Sprintf("d2Transition-%s-%d", diagramHash, i), totalMS)), 1)
 		buf.Write([]byte(str))
 	}
 
 	fmt.Fprint(buf, "</svg>")
+	if opts.AutoplayVisible {
+		fmt.Fprintf(buf, `<script type="text/javascript"><![CDATA[%s
+d2AnimationAutoplay(%q);]]></script>`, autoplayJS, diagramHash)
+	}
+	if opts.Controls {
+		err = writeControls(buf, diagramHash, width, height, starts, totalMS)
+		if err != nil {
+			return nil, err
+		}
+	}
 	fmt.Fprint(buf, "</svg>")
 
 	return buf.Bytes(), nil
 }
+
+// writeControls writes the playback controls of an animation of totalMS, whose boards
+// start at starts, below the diagram of width and height. The controls are hidden until
+// their script grows the viewBox of the SVG to fit them.
+func writeControls(buf *bytes.Buffer, diagramHash string, width, height int, starts []int, totalMS int) error {
+	const trackX = 128
+	const labelWidth = 72
+	trackWidth := go2.Max(width-trackX-labelWidth, 48)
+
+	fmt.Fprintf(buf, `<g id="d2-controls-%s" class="%s" transform="translate(0 %d)" style="display:none">`, diagramHash, diagramHash, height)
+	fmt.Fprintf(buf, `<rect width="%d" height="%d" class="fill-N7 stroke-N6" />`, width, controlsHeight)
+	buttons := []struct {
+		name  string
+		title string
+		paths []string
+	}{
+		{"back", "Previous board", []string{"M9 9h2.5v14H9z M23 9v14l-10.5-7z"}},
+		{"play", "Play/pause", []string{"M11 9v14l12-7z", "M10 9h4v14h-4z M18 9h4v14h-4z"}},
+		{"forward", "Next board", []string{"M20.5 9H23v14h-2.5z M9 9v14l10.5-7z"}},
+	}
+	for i, b := range buttons {
+		fmt.Fprintf(buf, `<g data-d2-control="%s" transform="translate(%d 8)" style="cursor:pointer"><title>%s</title><rect width="32" height="32" rx="4" fill="transparent" />`, b.name, 8+i*40, b.title)
+		for j, d := range b.paths {
+			// The play button is drawn as pause while the animation plays.
+			style := ""
+			if j == 0 && len(b.paths) > 1 {
+				style = ` style="display:none"`
+			}
+			fmt.Fprintf(buf, `<path d="%s" class="fill-N1"%s />`, d, style)
+		}
+		fmt.Fprint(buf, `</g>`)
+	}
+
+	fmt.Fprintf(buf, `<g data-d2-control="track" transform="translate(%d 24)" style="cursor:pointer">`, trackX)
+	fmt.Fprintf(buf, `<rect y="-12" width="%d" height="24" fill="transparent" />`, trackWidth)
+	fmt.Fprintf(buf, `<rect y="-2" width="%d" height="4" rx="2" class="fill-N5" />`, trackWidth)
+	for _, start := range starts[1 : len(starts)-1] {
+		fmt.Fprintf(buf, `<rect x="%f" y="-5" width="2" height="10" class="fill-N4" />`, float64(start)/float64(totalMS)*float64(trackWidth)-1)
+	}
+	fmt.Fprint(buf, `<rect data-d2-control="progress" y="-2" width="0" height="4" rx="2" class="fill-B1" />`)
+	fmt.Fprint(buf, `<circle data-d2-control="handle" r="6" class="fill-B1" />`)
+	fmt.Fprint(buf, `</g>`)
+	fmt.Fprintf(buf, `<text data-d2-control="label" x="%d" y="29" text-anchor="end" font-family="sans-serif" font-size="14" class="fill-N1">1 / %d</text>`, width-12, len(starts)-1)
+	fmt.Fprint(buf, `</g>`)
+
+	startsJSON, err := json.Marshal(starts[:len(starts)-1])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(buf, `<script type="text/javascript"><![CDATA[%s
+d2AnimationControls(%q, %s, %d, %d, %d);]]></script>`, controlsJS, diagramHash, startsJSON, totalMS, trackWidth, controlsHeight)
+	return nil
+}
@@ -0,0 +1,146 @@
+package d2animate
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"oss.terrastruct.com/d2/d2target"
+)
+
+// morphDurationMS is the duration of the transitions to boards that morph shapes, unless
+// they set one.
+var morphDurationMS = 500
+
+// boardID returns the id of the group of the board i of the animation of diagramHash.
+func boardID(diagramHash string, i int) string {
+	return fmt.Sprintf("%s-board-%d", diagramHash, i)
+}
+
+// flattenBoards returns the boards of diagram that are not folders in the order they are
+// rendered in, diagram first, then its layers, scenarios and steps.
+func flattenBoards(diagram *d2target.Diagram) []*d2target.Diagram {
+	var boards []*d2target.Diagram
+	if !diagram.IsFolderOnly {
+		boards = append(boards, diagram)
+	}
+	for _, dl := range diagram.Layers {
+		boards = append(boards, flattenBoards(dl)...)
+	}
+	for _, dl := range diagram.Scenarios {
+		boards = append(boards, flattenBoards(dl)...)
+	}
+	for _, dl := range diagram.Steps {
+		boards = append(boards, flattenBoards(dl)...)
+	}
+	return boards
+}
+
+// morph is the transform of a shape over the fade between two boards.
+type morph struct {
+	// fromMS and toMS are when the fade starts and ends.
+	fromMS, toMS int
+	// from and to are the transforms of the shape when the fade starts and ends.
+	from, to string
+	easing   string
+}
+
+// writeMorphs writes the keyframes that morph the shapes in consecutive boards from their
+// box in one board to their box in the next while the boards fade. The shape of both boards
+// is moved, so that it morphs as they crossfade. The animation cuts back to the first board,
+// so nothing morphs into it.
+func writeMorphs(buf *bytes.Buffer, diagramHash string, boards []*d2target.Diagram, starts, fades []int, totalMS int, transitions []d2target.Transition, opts Options) {
+	morphs := make([]map[string][]morph, len(boards))
+	for i := range boards {
+		morphs[i] = make(map[string][]morph)
+	}
+	for i := 1; i < len(boards); i++ {
+		prev := make(map[string]d2target.Shape, len(boards[i-1].Shapes))
+		for _, s := range boards[i-1].Shapes {
+			prev[s.ID] = s
+		}
+		fromMS := starts[i] - fades[i]
+		for _, s := range boards[i].Shapes {
+			p, ok := prev[s.ID]
+			if !ok || (p.Pos == s.Pos && p.Width == s.Width && p.Height == s.Height) {
+				continue
+			}
+			if p.Width == 0 || p.Height == 0 || s.Width == 0 || s.Height == 0 {
+				continue
+			}
+			easing := transitions[i].Easing
+			// The shape of the previous board moves from its box to the box of the next.
+			morphs[i-1][s.ID] = append(morphs[i-1][s.ID], morph{
+				fromMS: fromMS,
+				toMS:   starts[i],
+				from:   "none",
+				to:     boxTransform(p, s),
+				easing: easing,
+			})
+			// And the shape of the next board from the box of the previous to its own.
+			morphs[i][s.ID] = append(morphs[i][s.ID], morph{
+				fromMS: fromMS,
+				toMS:   starts[i],
+				from:   boxTransform(s, p),
+				to:     "none",
+				easing: easing,
+			})
+		}
+	}
+
+	n := 0
+	for i, board := range boards {
+		for _, s := range board.Shapes {
+			ms, ok := morphs[i][s.ID]
+			if !ok {
+				continue
+			}
+			name := fmt.Sprintf("d2Morph-%s-%d", diagramHash, n)
+			n++
+			fmt.Fprintf(buf, "\n@keyframes %s {", name)
+			// A shape morphs out of a board after it morphed into it.
+			last := "none"
+			lastMS := 0
+			for _, m := range ms {
+				fmt.Fprintf(buf, `
+		%f%%, %f%% {
+				transform: %s;%s
+		}`, percentage(lastMS, totalMS), percentage(m.fromMS, totalMS), m.from, timingFunction(m.easing))
+				last = m.to
+				lastMS = m.toMS
+			}
+			fmt.Fprintf(buf, `
+		%f%%, 100%% {
+				transform: %s;
+		}
+}
+#%s [id="%s"] {
+		animation: %s;
+		transform-box: view-box;
+		transform-origin: 0 0;
+}`, percentage(lastMS, totalMS), last, boardID(diagramHash, i), cssString(s.ID), opts.animation(name, totalMS))
+		}
+	}
+}
+
+func percentage(ms, totalMS int) float64 {
+	return float64(ms) / float64(totalMS) * 100.
+}
+
+// boxTransform returns the CSS transform that moves and scales the box of s onto the box
+// of onto.
+func boxTransform(s, onto d2target.Shape) string {
+	sx := float64(onto.Width) / float64(s.Width)
+	sy := float64(onto.Height) / float64(s.Height)
+	tx := float64(onto.Pos.X) - float64(s.Pos.X)*sx
+	ty := float64(onto.Pos.Y) - float64(s.Pos.Y)*sy
+	if sx == 1 && sy == 1 {
+		return fmt.Sprintf("translate(%fpx, %fpx)", tx, ty)
+	}
+	return fmt.Sprintf("translate(%fpx, %fpx) scale(%f, %f)", tx, ty, sx, sy)
+}
+
+// cssString escapes s to be quoted in CSS.
+func cssString(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\a `).Replace(s)
+}
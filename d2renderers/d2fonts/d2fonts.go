@@ -5,7 +5,7 @@
 package d2fonts
 
 import (
-	"embed"
+	_ "embed"
 	"encoding/base64"
 	"fmt"
 	"strings"
@@ -133,8 +133,38 @@ var fuzzyBubblesRegularBase64 string
 //go:embed encoded/FuzzyBubbles-Bold.txt
 var fuzzyBubblesBoldBase64 string
 
-//go:embed ttf/*
-var fontFacesFS embed.FS
+// The faces are embedded as []byte rather than read from an embed.FS so that they are not
+// copied into memory on startup, whether or not they end up used.
+
+//go:embed ttf/SourceSansPro-Regular.ttf
+var sourceSansProRegularTTF []byte
+
+//go:embed ttf/SourceSansPro-Bold.ttf
+var sourceSansProBoldTTF []byte
+
+//go:embed ttf/SourceSansPro-Semibold.ttf
+var sourceSansProSemiboldTTF []byte
+
+//go:embed ttf/SourceSansPro-Italic.ttf
+var sourceSansProItalicTTF []byte
+
+//go:embed ttf/SourceCodePro-Regular.ttf
+var sourceCodeProRegularTTF []byte
+
+//go:embed ttf/SourceCodePro-Bold.ttf
+var sourceCodeProBoldTTF []byte
+
+//go:embed ttf/SourceCodePro-Semibold.ttf
+var sourceCodeProSemiboldTTF []byte
+
+//go:embed ttf/SourceCodePro-Italic.ttf
+var sourceCodeProItalicTTF []byte
+
+//go:embed ttf/FuzzyBubbles-Regular.ttf
+var fuzzyBubblesRegularTTF []byte
+
+//go:embed ttf/FuzzyBubbles-Bold.ttf
+var fuzzyBubblesBoldTTF []byte
 
 var FontEncodings syncmap.SyncMap[Font, string]
 var FontFaces syncmap.SyncMap[Font, []byte]
@@ -230,103 +260,56 @@ func init() {
 
 	FontFaces = syncmap.New[Font, []byte]()
 
-	b, err := fontFacesFS.ReadFile("ttf/SourceSansPro-Regular.ttf")
-	if err != nil {
-		panic(err)
-	}
 	FontFaces.Set(Font{
 		Family: SourceSansPro,
 		Style:  FONT_STYLE_REGULAR,
-	}, b)
-
-	b, err = fontFacesFS.ReadFile("ttf/SourceCodePro-Regular.ttf")
-	if err != nil {
-		panic(err)
-	}
+	}, sourceSansProRegularTTF)
 	FontFaces.Set(Font{
-		Family: SourceCodePro,
-		Style:  FONT_STYLE_REGULAR,
-	}, b)
-
-	b, err = fontFacesFS.ReadFile("ttf/SourceCodePro-Bold.ttf")
-	if err != nil {
-		panic(err)
-	}
-	FontFaces.Set(Font{
-		Family: SourceCodePro,
+		Family: SourceSansPro,
 		Style:  FONT_STYLE_BOLD,
-	}, b)
-
-	b, err = fontFacesFS.ReadFile("ttf/SourceCodePro-Semibold.ttf")
-	if err != nil {
-		panic(err)
-	}
+	}, sourceSansProBoldTTF)
 	FontFaces.Set(Font{
-		Family: SourceCodePro,
+		Family: SourceSansPro,
 		Style:  FONT_STYLE_SEMIBOLD,
-	}, b)
-
-	b, err = fontFacesFS.ReadFile("ttf/SourceCodePro-Italic.ttf")
-	if err != nil {
-		panic(err)
-	}
+	}, sourceSansProSemiboldTTF)
 	FontFaces.Set(Font{
-		Family: SourceCodePro,
+		Family: SourceSansPro,
 		Style:  FONT_STYLE_ITALIC,
-	}, b)
-
-	b, err = fontFacesFS.ReadFile("ttf/SourceSansPro-Bold.ttf")
-	if err != nil {
-		panic(err)
-	}
+	}, sourceSansProItalicTTF)
 	FontFaces.Set(Font{
-		Family: SourceSansPro,
+		Family: SourceCodePro,
+		Style:  FONT_STYLE_REGULAR,
+	}, sourceCodeProRegularTTF)
+	FontFaces.Set(Font{
+		Family: SourceCodePro,
 		Style:  FONT_STYLE_BOLD,
-	}, b)
-
-	b, err = fontFacesFS.ReadFile("ttf/SourceSansPro-Semibold.ttf")
-	if err != nil {
-		panic(err)
-	}
+	}, sourceCodeProBoldTTF)
 	FontFaces.Set(Font{
-		Family: SourceSansPro,
+		Family: SourceCodePro,
 		Style:  FONT_STYLE_SEMIBOLD,
-	}, b)
-
-	b, err = fontFacesFS.ReadFile("ttf/SourceSansPro-Italic.ttf")
-	if err != nil {
-		panic(err)
-	}
+	}, sourceCodeProSemiboldTTF)
 	FontFaces.Set(Font{
-		Family: SourceSansPro,
+		Family: SourceCodePro,
 		Style:  FONT_STYLE_ITALIC,
-	}, b)
-
-	b, err = fontFacesFS.ReadFile("ttf/FuzzyBubbles-Regular.ttf")
-	if err != nil {
-		panic(err)
-	}
+	}, sourceCodeProItalicTTF)
 	FontFaces.Set(Font{
 		Family: HandDrawn,
 		Style:  FONT_STYLE_REGULAR,
-	}, b)
+	}, fuzzyBubblesRegularTTF)
 	FontFaces.Set(Font{
 		Family: HandDrawn,
 		Style:  FONT_STYLE_ITALIC,
-	}, b)
-
-	b, err = fontFacesFS.ReadFile("ttf/FuzzyBubbles-Bold.ttf")
-	if err != nil {
-		panic(err)
-	}
+		// This font has no italic, so just reuse regular
+	}, fuzzyBubblesRegularTTF)
 	FontFaces.Set(Font{
 		Family: HandDrawn,
 		Style:  FONT_STYLE_BOLD,
-	}, b)
+	}, fuzzyBubblesBoldTTF)
 	FontFaces.Set(Font{
 		Family: HandDrawn,
 		Style:  FONT_STYLE_SEMIBOLD,
-	}, b)
+		// This font has no semibold, so just reuse bold
+	}, fuzzyBubblesBoldTTF)
 }
 
 var D2_FONT_TO_FAMILY = map[string]FontFamily{
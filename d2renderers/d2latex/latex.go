@@ -6,6 +6,7 @@ import (
 	"math"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/dop251/goja"
 
@@ -29,6 +30,10 @@ var svgRe = regexp.MustCompile(`<svg[^>]+width="([0-9\.]+)ex" height="([0-9\.]+)
 
 func Render(s string) (_ string, err error) {
 	defer xdefer.Errorf(&err, "latex failed to parse")
+	// Blocks that only define macros are left empty once the definitions are removed.
+	if strings.TrimSpace(s) == "" {
+		return "", nil
+	}
 	vm := goja.New()
 
 	if _, err := vm.RunString(polyfillsJS); err != nil {
@@ -56,6 +61,9 @@ func Render(s string) (_ string, err error) {
 
 func Measure(s string) (width, height int, err error) {
 	defer xdefer.Errorf(&err, "latex failed to parse")
+	if strings.TrimSpace(s) == "" {
+		return 0, 0, nil
+	}
 	svg, err := Render(s)
 	if err != nil {
 		return 0, 0, err
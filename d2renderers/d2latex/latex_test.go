@@ -31,3 +31,41 @@ func TestRenderError(t *testing.T) {
 		t.Fatal("expected to error on invalid latex syntax")
 	}
 }
+
+func TestMacros(t *testing.T) {
+	macros := make(Macros)
+	rest, err := ParseMacros(`\\newcommand{\\RR}{\\mathbb{R}} \\def\\half{\\frac{1}{2}} \\newcommand{\\norm}[1]{\\lVert #1 \\rVert} x`, macros)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rest != "x" {
+		t.Fatalf("unexpected rest: %q", rest)
+	}
+	if len(macros) != 3 || macros["norm"].NArgs != 1 {
+		t.Fatalf("unexpected macros: %#v", macros)
+	}
+
+	out, err := ExpandMacros(`\\norm{\\RR} + \\half \\definecolor`, macros)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `{\\lVert {\\mathbb{R}} \\rVert} + {\\frac{1}{2}} \\definecolor`
+	if out != exp {
+		t.Fatalf("expected %q, got %q", exp, out)
+	}
+
+	macros["loop"] = Macro{Name: "loop", Body: `\\loop`}
+	if _, err := ExpandMacros(`\\loop`, macros); err == nil {
+		t.Fatal("expected recursive macro to error")
+	}
+}
+
+func TestMeasureEmpty(t *testing.T) {
+	w, h, err := Measure("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != 0 || h != 0 {
+		t.Fatalf("expected empty latex to have no dimensions, got %dx%d", w, h)
+	}
+}
@@ -0,0 +1,213 @@
+package d2latex
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Latex labels in D2 escape their backslashes, e.g. \\frac{1}{2}, since they are
+// interpolated into a JS template string before reaching MathJax. Everything in this
+// file operates on that escaped form.
+const bs = `\\`
+
+// Macro is a user defined command, declared with \\newcommand or \\def in any latex
+// block of a file and usable from every other latex block of the same file.
+type Macro struct {
+	Name  string
+	NArgs int
+	Body  string
+}
+
+type Macros map[string]Macro
+
+var macroDefRe = regexp.MustCompile(`^(?:` + regexp.QuoteMeta(bs) + `(?:re)?newcommand\*?|` + regexp.QuoteMeta(bs) + `def)`)
+
+// ParseMacros extracts every macro definition from s into macros and returns s with the
+// definitions removed.
+func ParseMacros(s string, macros Macros) (rest string, err error) {
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		loc := macroDefRe.FindStringIndex(s[i:])
+		if loc == nil || (i+loc[1] < len(s) && isLetter(s[i+loc[1]])) {
+			sb.WriteByte(s[i])
+			i++
+			continue
+		}
+		isDef := strings.HasSuffix(s[i:i+loc[1]], "def")
+		m, n, err := parseMacro(s[i+loc[1]:], isDef)
+		if err != nil {
+			return "", err
+		}
+		macros[m.Name] = m
+		i += loc[1] + n
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+func parseMacro(s string, isDef bool) (m Macro, n int, err error) {
+	s2 := strings.TrimLeft(s, " \t\n")
+	n = len(s) - len(s2)
+	s = s2
+
+	var name string
+	if !isDef && strings.HasPrefix(s, "{") {
+		name, err = matchBraces(s)
+		if err != nil {
+			return Macro{}, 0, err
+		}
+		n += len(name) + 2
+		s = s[len(name)+2:]
+		name = strings.TrimSpace(name)
+	} else {
+		name = readCommand(s)
+		n += len(name)
+		s = s[len(name):]
+	}
+	if !strings.HasPrefix(name, bs) || len(name) == len(bs) {
+		return Macro{}, 0, fmt.Errorf("invalid macro name %q", name)
+	}
+	m.Name = strings.TrimPrefix(name, bs)
+
+	if strings.HasPrefix(s, "[") {
+		end := strings.IndexByte(s, ']')
+		if end == -1 {
+			return Macro{}, 0, fmt.Errorf("unterminated argument count for macro %q", m.Name)
+		}
+		m.NArgs, err = strconv.Atoi(strings.TrimSpace(s[1:end]))
+		if err != nil || m.NArgs < 0 || m.NArgs > 9 {
+			return Macro{}, 0, fmt.Errorf("invalid argument count for macro %q", m.Name)
+		}
+		n += end + 1
+		s = s[end+1:]
+	}
+
+	s2 = strings.TrimLeft(s, " \t\n")
+	n += len(s) - len(s2)
+	s = s2
+	if !strings.HasPrefix(s, "{") {
+		return Macro{}, 0, fmt.Errorf("expected body for macro %q", m.Name)
+	}
+	m.Body, err = matchBraces(s)
+	if err != nil {
+		return Macro{}, 0, err
+	}
+	n += len(m.Body) + 2
+	return m, n, nil
+}
+
+// readCommand reads a \\name command off the start of s.
+func readCommand(s string) string {
+	if !strings.HasPrefix(s, bs) {
+		return ""
+	}
+	i := len(bs)
+	for i < len(s) && isLetter(s[i]) {
+		i++
+	}
+	return s[:i]
+}
+
+func isLetter(b byte) bool {
+	return ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
+
+// matchBraces returns the contents of the brace group that s starts with.
+func matchBraces(s string) (string, error) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return s[1:i], nil
+			}
+		}
+	}
+	return "", fmt.Errorf("unbalanced braces in %q", s)
+}
+
+// ExpandMacros replaces every use of a macro in s with its body.
+func ExpandMacros(s string, macros Macros) (string, error) {
+	if len(macros) == 0 {
+		return s, nil
+	}
+	// Bound the number of passes so that recursive macros cannot loop forever.
+	for pass := 0; pass < 32; pass++ {
+		out, expanded, err := expandOnce(s, macros)
+		if err != nil {
+			return "", err
+		}
+		if !expanded {
+			return out, nil
+		}
+		s = out
+	}
+	return "", fmt.Errorf("macro expansion too deep, is a macro recursive?")
+}
+
+func expandOnce(s string, macros Macros) (_ string, expanded bool, _ error) {
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		cmd := readCommand(s[i:])
+		m, ok := macros[strings.TrimPrefix(cmd, bs)]
+		if cmd == "" || !ok {
+			if cmd != "" {
+				sb.WriteString(cmd)
+				i += len(cmd)
+			} else {
+				sb.WriteByte(s[i])
+				i++
+			}
+			continue
+		}
+		i += len(cmd)
+		body := m.Body
+		for arg := 1; arg <= m.NArgs; arg++ {
+			for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n') {
+				i++
+			}
+			if i >= len(s) {
+				return "", false, fmt.Errorf("macro %q expects %d arguments", m.Name, m.NArgs)
+			}
+			var v string
+			if s[i] == '{' {
+				inner, err := matchBraces(s[i:])
+				if err != nil {
+					return "", false, err
+				}
+				v = inner
+				i += len(inner) + 2
+			} else if c := readCommand(s[i:]); c != "" {
+				v = c
+				i += len(c)
+			} else {
+				v = s[i : i+1]
+				i++
+			}
+			body = strings.ReplaceAll(body, "#"+strconv.Itoa(arg), v)
+		}
+		sb.WriteString("{" + body + "}")
+		expanded = true
+	}
+	return sb.String(), expanded, nil
+}
+
+// Display renders s in display style, e.g. with limits above and below operators.
+func Display(s string) string {
+	return bs + "displaystyle{" + s + "}"
+}
+
+var noNumberRe = regexp.MustCompile(regexp.QuoteMeta(bs) + `(?:notag|nonumber)\b`)
+
+// Number appends the equation number n to s. It reports false and leaves s untouched
+// (aside from removing the marker) if s opts out with \\notag or \\nonumber.
+func Number(s string, n int) (string, bool) {
+	if noNumberRe.MatchString(s) {
+		return strings.TrimSpace(noNumberRe.ReplaceAllString(s, "")), false
+	}
+	return fmt.Sprintf("%s %sqquad(%d)", s, bs, n), true
+}
@@ -0,0 +1,218 @@
+// d2scroll packages the boards of a diagram into an HTML page that walks through them as it
+// is scrolled: the diagram stays in view while a section per board scrolls past it, and
+// the board of the section in the middle of the page is shown.
+package d2scroll
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html"
+	"strings"
+
+	"oss.terrastruct.com/d2/d2renderers/d2singlefile"
+	"oss.terrastruct.com/d2/d2renderers/d2sketch"
+	"oss.terrastruct.com/d2/d2renderers/d2svg"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/version"
+)
+
+//go:embed scroll.js
+var scrollJS string
+
+// pageCSS lays the sections out next to the diagram, or above it on narrow screens.
+const pageCSS = `body {
+	margin: 0;
+	font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif;
+	line-height: 1.5;
+}
+.d2-scroll {
+	display: flex;
+	flex-direction: row-reverse;
+}
+.d2-scroll-diagram {
+	position: sticky;
+	top: 0;
+	flex: 2;
+	height: 100vh;
+}
+.d2-scroll-diagram > svg {
+	width: 100%;
+	height: 100%;
+}
+.d2-scroll-sections {
+	flex: 1;
+	max-width: 32em;
+	padding: 0 2em;
+}
+.d2-scroll-section {
+	min-height: 100vh;
+	display: flex;
+	flex-direction: column;
+	justify-content: center;
+}
+.d2-scroll-section p {
+	white-space: pre-line;
+}
+.d2-scroll-board {
+	opacity: 0;
+	pointer-events: none;
+	transition: opacity 400ms ease-in-out;
+}
+.d2-scroll-board.d2-scroll-active {
+	opacity: 1;
+	pointer-events: auto;
+}
+@media (max-width: 48em) {
+	.d2-scroll {
+		flex-direction: column;
+	}
+	.d2-scroll-diagram {
+		height: 60vh;
+		z-index: 1;
+		background: white;
+	}
+	.d2-scroll-sections {
+		max-width: none;
+	}
+}`
+
+// flattenBoards returns the boards of diagram that are not folders in the order they are
+// rendered in, diagram first, then its layers, scenarios and steps.
+func flattenBoards(diagram *d2target.Diagram) []*d2target.Diagram {
+	var boards []*d2target.Diagram
+	if !diagram.IsFolderOnly {
+		boards = append(boards, diagram)
+	}
+	for _, dl := range diagram.Layers {
+		boards = append(boards, flattenBoards(dl)...)
+	}
+	for _, dl := range diagram.Scenarios {
+		boards = append(boards, flattenBoards(dl)...)
+	}
+	for _, dl := range diagram.Steps {
+		boards = append(boards, flattenBoards(dl)...)
+	}
+	return boards
+}
+
+// Wrap packages svgs, the boards of rootDiagram rendered with renderOpts.MasterID, into an
+// HTML page with a section per board, titled by its label or name and described by its
+// description. The section of the i-th board has the id d2singlefile.BoardID(hash, i), so
+// links relinked to those anchors scroll to the board they link to. Without scripts, the
+// first board is shown throughout.
+func Wrap(rootDiagram *d2target.Diagram, svgs [][]byte, renderOpts d2svg.RenderOpts) ([]byte, error) {
+	boards := flattenBoards(rootDiagram)
+	if len(svgs) == 0 || len(boards) != len(svgs) {
+		return nil, fmt.Errorf("%d boards to wrap for %d diagrams", len(svgs), len(boards))
+	}
+	buf := &bytes.Buffer{}
+
+	tl, br := rootDiagram.NestedBoundingBox()
+	left := tl.X - int(*renderOpts.Pad)
+	top := tl.Y - int(*renderOpts.Pad)
+	width := br.X - tl.X + int(*renderOpts.Pad)*2
+	height := br.Y - tl.Y + int(*renderOpts.Pad)*2
+
+	// Links to boards are rewritten after the master ID is taken, changing the hash.
+	diagramHash := renderOpts.MasterID
+	if diagramHash == "" {
+		var err error
+		diagramHash, err = rootDiagram.HashID()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	title := rootDiagram.Root.Label
+	if title == "" {
+		title = "D2 diagram"
+	}
+	fmt.Fprintf(buf, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="generator" content="D2 %s">
+<title>%s</title>
+<style>
+%s
+</style>
+</head>
+<body>
+<div class="d2-scroll">
+<div class="d2-scroll-diagram">`, version.Version, html.EscapeString(title), pageCSS)
+
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" d2Version="%s" preserveAspectRatio="xMidYMid meet" viewBox="0 0 %d %d">`,
+		version.Version,
+		width, height,
+	)
+	fmt.Fprintf(buf, `<svg id="d2-svg" width="%d" height="%d" viewBox="%d %d %d %d">`,
+		width, height, left, top, width, height)
+
+	svgsStr := ""
+	for _, svg := range svgs {
+		svgsStr += string(svg) + " "
+	}
+
+	d2svg.EmbedFonts(buf, diagramHash, svgsStr, rootDiagram.FontFamily, rootDiagram.GetNestedCorpus())
+
+	themeStylesheet, err := d2svg.ThemeCSS(diagramHash, renderOpts.ThemeID, renderOpts.DarkThemeID, renderOpts.ThemeOverrides, renderOpts.DarkThemeOverrides)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(buf, `<style type="text/css"><![CDATA[%s%s]]></style>`, d2svg.BaseStylesheet, themeStylesheet)
+
+	if rootDiagram.HasShape(func(s d2target.Shape) bool {
+		return s.Label != "" && s.Type == d2target.ShapeText
+	}) {
+		css := d2svg.MarkdownCSS
+		css = strings.ReplaceAll(css, "font-italic", fmt.Sprintf("%s-font-italic", diagramHash))
+		css = strings.ReplaceAll(css, "font-bold", fmt.Sprintf("%s-font-bold", diagramHash))
+		css = strings.ReplaceAll(css, "font-mono", fmt.Sprintf("%s-font-mono", diagramHash))
+		css = strings.ReplaceAll(css, "font-regular", fmt.Sprintf("%s-font-regular", diagramHash))
+		fmt.Fprintf(buf, `<style type="text/css">%s</style>`, css)
+	}
+
+	if renderOpts.Sketch != nil && *renderOpts.Sketch {
+		d2sketch.DefineFillPatterns(buf)
+	}
+
+	for i, svg := range svgs {
+		class := "d2-scroll-board"
+		if i == 0 {
+			class += " d2-scroll-active"
+		}
+		fmt.Fprintf(buf, `<g class="%s" data-d2-board="%d">`, class, i)
+		buf.Write(svg)
+		fmt.Fprint(buf, `</g>`)
+	}
+	fmt.Fprint(buf, "</svg></svg>\n</div>\n")
+
+	fmt.Fprint(buf, `<div class="d2-scroll-sections">`+"\n")
+	for i, board := range boards {
+		fmt.Fprintf(buf, `<section id="%s" class="d2-scroll-section" data-d2-board="%d">`, d2singlefile.BoardID(diagramHash, i), i)
+		title := board.Root.Label
+		if title == "" {
+			title = board.Name
+		}
+		if title != "" {
+			fmt.Fprintf(buf, "<h2>%s</h2>", html.EscapeString(title))
+		}
+		if board.Description != "" {
+			fmt.Fprintf(buf, "<p>%s</p>", html.EscapeString(board.Description))
+		}
+		fmt.Fprint(buf, "</section>\n")
+	}
+	fmt.Fprint(buf, "</div>\n</div>\n")
+
+	fmt.Fprintf(buf, `<script>
+%s
+d2ScrollBoards();
+</script>
+</body>
+</html>
+`, scrollJS)
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,26 @@
+// d2ScrollBoards shows the board of the section crossing the middle of the page.
+function d2ScrollBoards() {
+  if (!window.IntersectionObserver) {
+    return;
+  }
+  var boards = document.querySelectorAll(".d2-scroll-board");
+  var show = function (i) {
+    for (var j = 0; j < boards.length; j++) {
+      boards[j].classList.toggle("d2-scroll-active", boards[j].getAttribute("data-d2-board") === i);
+    }
+  };
+  // The margins shrink the viewport to its middle line.
+  var observer = new IntersectionObserver(
+    function (entries) {
+      entries.forEach(function (e) {
+        if (e.isIntersecting) {
+          show(e.target.getAttribute("data-d2-board"));
+        }
+      });
+    },
+    { rootMargin: "-50% 0px -50% 0px" }
+  );
+  document.querySelectorAll(".d2-scroll-section").forEach(function (section) {
+    observer.observe(section);
+  });
+}
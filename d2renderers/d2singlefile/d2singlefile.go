@@ -0,0 +1,112 @@
+package d2singlefile
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"oss.terrastruct.com/d2/d2renderers/d2sketch"
+	"oss.terrastruct.com/d2/d2renderers/d2svg"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/version"
+)
+
+// BoardID returns the id of the i-th board of the document of the diagram diagramHash, to
+// link to it with "#" + BoardID.
+func BoardID(diagramHash string, i int) string {
+	return fmt.Sprintf("%s-board-%d", diagramHash, i)
+}
+
+// Wrap packages svgs, the boards of rootDiagram rendered with renderOpts.MasterID, into one
+// SVG that shows the board targeted by the fragment of its URL, or else the first of svgs.
+// Boards are switched with CSS only, so navigating works where scripts don't run.
+func Wrap(rootDiagram *d2target.Diagram, svgs [][]byte, renderOpts d2svg.RenderOpts) ([]byte, error) {
+	if len(svgs) == 0 {
+		return nil, fmt.Errorf("no boards to wrap")
+	}
+	buf := &bytes.Buffer{}
+
+	tl, br := rootDiagram.NestedBoundingBox()
+	left := tl.X - int(*renderOpts.Pad)
+	top := tl.Y - int(*renderOpts.Pad)
+	width := br.X - tl.X + int(*renderOpts.Pad)*2
+	height := br.Y - tl.Y + int(*renderOpts.Pad)*2
+
+	fmt.Fprintf(buf, `<?xml version="1.0" encoding="utf-8"?><svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" d2Version="%s" preserveAspectRatio="xMinYMin meet" viewBox="0 0 %d %d">`,
+		version.Version,
+		width, height,
+	)
+	fmt.Fprintf(buf, `<svg id="d2-svg" width="%d" height="%d" viewBox="%d %d %d %d">`,
+		width, height, left, top, width, height)
+
+	svgsStr := ""
+	for _, svg := range svgs {
+		svgsStr += string(svg) + " "
+	}
+
+	// Links to boards are rewritten after the master ID is taken, changing the hash.
+	diagramHash := renderOpts.MasterID
+	if diagramHash == "" {
+		var err error
+		diagramHash, err = rootDiagram.HashID()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	d2svg.EmbedFonts(buf, diagramHash, svgsStr, rootDiagram.FontFamily, rootDiagram.GetNestedCorpus())
+
+	themeStylesheet, err := d2svg.ThemeCSS(diagramHash, renderOpts.ThemeID, renderOpts.DarkThemeID, renderOpts.ThemeOverrides, renderOpts.DarkThemeOverrides)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(buf, `<style type="text/css"><![CDATA[%s%s]]></style>`, d2svg.BaseStylesheet, themeStylesheet)
+
+	if rootDiagram.HasShape(func(s d2target.Shape) bool {
+		return s.Label != "" && s.Type == d2target.ShapeText
+	}) {
+		css := d2svg.MarkdownCSS
+		css = strings.ReplaceAll(css, "font-italic", fmt.Sprintf("%s-font-italic", diagramHash))
+		css = strings.ReplaceAll(css, "font-bold", fmt.Sprintf("%s-font-bold", diagramHash))
+		css = strings.ReplaceAll(css, "font-mono", fmt.Sprintf("%s-font-mono", diagramHash))
+		css = strings.ReplaceAll(css, "font-regular", fmt.Sprintf("%s-font-regular", diagramHash))
+		fmt.Fprintf(buf, `<style type="text/css">%s</style>`, css)
+	}
+
+	if renderOpts.Sketch != nil && *renderOpts.Sketch {
+		d2sketch.DefineFillPatterns(buf)
+	}
+
+	// The first board is written last, so that it can be hidden when a board before it is
+	// targeted. Boards are hidden rather than not displayed, so that the dashes of animated
+	// connections flow on one clock instead of restarting whenever their board is shown. The
+	// animations of the shapes and connections of hidden boards are removed, so that they
+	// play again when their board is shown.
+	first := BoardID(diagramHash, 0)
+	fmt.Fprintf(buf, `<style type="text/css"><![CDATA[.d2-board {
+	visibility: hidden;
+}
+.d2-board:target, #%[1]s {
+	visibility: visible;
+}
+.d2-board:target ~ #%[1]s {
+	visibility: hidden;
+}
+.d2-board:not(:target):not(#%[1]s) [style*="animation:d2-"], .d2-board:target ~ #%[1]s [style*="animation:d2-"] {
+	animation-name: none !important;
+}]]></style>`, first)
+
+	for i := 1; i < len(svgs); i++ {
+		fmt.Fprintf(buf, `<g id="%s" class="d2-board">`, BoardID(diagramHash, i))
+		buf.Write(svgs[i])
+		fmt.Fprint(buf, `</g>`)
+	}
+	fmt.Fprintf(buf, `<g id="%s" class="d2-board">`, first)
+	buf.Write(svgs[0])
+	fmt.Fprint(buf, `</g>`)
+
+	fmt.Fprint(buf, "</svg>")
+	fmt.Fprint(buf, "</svg>")
+
+	return buf.Bytes(), nil
+}
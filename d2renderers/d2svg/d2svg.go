@@ -3,6 +3,7 @@
 package d2svg
 
 import (
+	"bufio"
 	"bytes"
 	_ "embed"
 	"errors"
@@ -79,14 +80,59 @@ type RenderOpts struct {
 	Font               string
 	// the svg will be scaled by this factor, if unset the svg will fit to screen
 	Scale *float64
+	// Crop is the box of the diagram to render, e.g. the box of a container to zoom in on, the
+	// bounding box of the diagram if unset.
+	Crop *geo.Box
 
 	// MasterID is passed when the diagram should use something other than its own hash for unique targeting
 	// Currently, that's when multi-boards are collapsed
 	MasterID string
+
+	// NoElementAnimations renders shapes and connections with the animate keyword as if they
+	// had none, for exports that are not animated, like PNG.
+	NoElementAnimations bool
+}
+
+// elementAnimationIntervalMS is the time between the animations of elements of consecutive
+// orders, and elementAnimationDurationMS how long each takes.
+const (
+	elementAnimationIntervalMS = 1000
+	elementAnimationDurationMS = 500
+)
+
+// elementStyle returns the style attribute of the group of a shape or connection of opacity
+// and animation, empty if it has neither.
+func elementStyle(opacity float64, animation *d2target.Animation) string {
+	var declarations []string
+	if opacity != 1.0 {
+		declarations = append(declarations, fmt.Sprintf("opacity:%f", opacity))
+	}
+	if animation != nil {
+		// Fading in shows the element before its animation as it is at the start, 0 opacity,
+		// and fading out keeps it as it is at the end. Otherwise it is at its own opacity.
+		fill := "backwards"
+		if animation.Effect == "fade-out" {
+			fill = "forwards"
+		}
+		declarations = append(declarations, fmt.Sprintf("animation:d2-%s %dms ease-in-out %dms %s",
+			animation.Effect,
+			elementAnimationDurationMS,
+			(animation.Order-1)*elementAnimationIntervalMS,
+			fill,
+		))
+	}
+	if len(declarations) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" style='%s'", strings.Join(declarations, ";"))
 }
 
-func dimensions(diagram *d2target.Diagram, pad int) (left, top, width, height int) {
+func dimensions(diagram *d2target.Diagram, pad int, crop *geo.Box) (left, top, width, height int) {
 	tl, br := diagram.BoundingBox()
+	if crop != nil {
+		tl = d2target.NewPoint(int(math.Floor(crop.TopLeft.X)), int(math.Floor(crop.TopLeft.Y)))
+		br = d2target.NewPoint(int(math.Ceil(crop.TopLeft.X+crop.Width)), int(math.Ceil(crop.TopLeft.Y+crop.Height)))
+	}
 	left = tl.X - pad
 	top = tl.Y - pad
 	width = br.X - tl.X + pad*2
@@ -394,6 +440,13 @@ func getArrowheadAdjustments(connection d2target.Connection, idToShape map[strin
 	return sourceAdjustment, targetAdjustment
 }
 
+// ConnectionPathData returns the d attribute of the path of connection, shortened at its ends
+// to make room for its arrowheads.
+func ConnectionPathData(connection d2target.Connection, idToShape map[string]d2target.Shape) string {
+	srcAdj, dstAdj := getArrowheadAdjustments(connection, idToShape)
+	return pathData(connection, srcAdj, dstAdj)
+}
+
 // returns the path's d attribute for the given connection
 func pathData(connection d2target.Connection, srcAdj, dstAdj *geo.Point) string {
 	var path []string
@@ -495,10 +548,7 @@ func makeLabelMask(labelTL *geo.Point, width, height int, opacity float64) strin
 }
 
 func drawConnection(writer io.Writer, labelMaskID string, connection d2target.Connection, markers map[string]struct{}, idToShape map[string]d2target.Shape, sketchRunner *d2sketch.Runner) (labelMask string, _ error) {
-	opacityStyle := ""
-	if connection.Opacity != 1.0 {
-		opacityStyle = fmt.Sprintf(" style='opacity:%f'", connection.Opacity)
-	}
+	opacityStyle := elementStyle(connection.Opacity, connection.Animate)
 
 	classStr := ""
 	if len(connection.Classes) > 0 {
@@ -944,11 +994,8 @@ func drawShape(writer, appendixWriter io.Writer, diagramHash string, targetShape
 		fmt.Fprintf(writer, `<a href="%s" xlink:href="%[1]s">`, svg.EscapeText(targetShape.Link))
 		closingTag += "</a>"
 	}
-	// Opacity is a unique style, it applies to everything for a shape
-	opacityStyle := ""
-	if targetShape.Opacity != 1.0 {
-		opacityStyle = fmt.Sprintf(" style='opacity:%f'", targetShape.Opacity)
-	}
+	// Opacity is a unique style, it applies to everything for a shape, as do animations
+	opacityStyle := elementStyle(targetShape.Opacity, targetShape.Animate)
 
 	// this clipPath must be defined outside `g` element
 	if targetShape.BorderRadius != 0 && (targetShape.Type == d2target.ShapeClass || targetShape.Type == d2target.ShapeSQLTable) {
@@ -966,7 +1013,7 @@ func drawShape(writer, appendixWriter io.Writer, diagramHash string, targetShape
 	style := targetShape.CSSStyle()
 	shapeType := d2target.DSL_SHAPE_TO_SHAPE_TYPE[targetShape.Type]
 
-	s := shape.NewShape(shapeType, geo.NewBox(tl, width, height))
+	s := shape.NewShapeWithPath(shapeType, geo.NewBox(tl, width, height), targetShape.ShapePath)
 	if shapeType == shape.CLOUD_TYPE && targetShape.ContentAspectRatio != nil {
 		s.SetInnerBoxAspectRatio(*targetShape.ContentAspectRatio)
 	}
@@ -1200,7 +1247,7 @@ func drawShape(writer, appendixWriter io.Writer, diagramHash string, targetShape
 	case d2target.ShapeText, d2target.ShapeCode:
 	default:
 		if targetShape.Multiple {
-			multiplePathData := shape.NewShape(shapeType, geo.NewBox(multipleTL, width, height)).GetSVGPathData()
+			multiplePathData := shape.NewShapeWithPath(shapeType, geo.NewBox(multipleTL, width, height), targetShape.ShapePath).GetSVGPathData()
 			el := d2themes.NewThemableElement("path")
 			el.Fill = fill
 			el.Stroke = stroke
@@ -1530,18 +1577,22 @@ func RenderText(text string, x, height float64) string {
 	return strings.Join(rendered, "")
 }
 
-func EmbedFonts(buf *bytes.Buffer, diagramHash, source string, fontFamily *d2fonts.FontFamily, corpus string) {
-	fmt.Fprint(buf, `<style type="text/css"><![CDATA[`)
+// embeddedStyle is a stylesheet EmbedFonts embeds in documents that contain any of its
+// triggers.
+type embeddedStyle struct {
+	triggers []string
+	css      func(diagramHash string, fontFamily *d2fonts.FontFamily, corpus string) string
+}
 
-	appendOnTrigger(
-		buf,
-		source,
-		[]string{
+var embeddedStyles = []embeddedStyle{
+	{
+		triggers: []string{
 			`class="text"`,
 			`class="text `,
 			`class="md"`,
 		},
-		fmt.Sprintf(`
+		css: func(diagramHash string, fontFamily *d2fonts.FontFamily, corpus string) string {
+			return fmt.Sprintf(`
 .%s .text {
 	font-family: "%s-font-regular";
 }
@@ -1549,75 +1600,96 @@ func EmbedFonts(buf *bytes.Buffer, diagramHash, source string, fontFamily *d2fon
 	font-family: %s-font-regular;
 	src: url("%s");
 }`,
-			diagramHash,
-			diagramHash,
-			diagramHash,
-			fontFamily.Font(0, d2fonts.FONT_STYLE_REGULAR).GetEncodedSubset(corpus),
-		),
-	)
-
-	appendOnTrigger(
-		buf,
-		source,
-		[]string{`class="md"`},
-		fmt.Sprintf(`
+				diagramHash,
+				diagramHash,
+				diagramHash,
+				fontFamily.Font(0, d2fonts.FONT_STYLE_REGULAR).GetEncodedSubset(corpus),
+			)
+		},
+	},
+	{
+		triggers: []string{`class="md"`},
+		css: func(diagramHash string, fontFamily *d2fonts.FontFamily, corpus string) string {
+			return fmt.Sprintf(`
 @font-face {
 	font-family: %s-font-semibold;
 	src: url("%s");
 }`,
-			diagramHash,
-			fontFamily.Font(0, d2fonts.FONT_STYLE_SEMIBOLD).GetEncodedSubset(corpus),
-		),
-	)
-
-	appendOnTrigger(
-		buf,
-		source,
-		[]string{
+				diagramHash,
+				fontFamily.Font(0, d2fonts.FONT_STYLE_SEMIBOLD).GetEncodedSubset(corpus),
+			)
+		},
+	},
+	{
+		triggers: []string{
 			`text-underline`,
 		},
-		`
+		css: func(diagramHash string, fontFamily *d2fonts.FontFamily, corpus string) string {
+			return `
 .text-underline {
 	text-decoration: underline;
-}`,
-	)
-
-	appendOnTrigger(
-		buf,
-		source,
-		[]string{
+}`
+		},
+	},
+	{
+		triggers: []string{
 			`animated-connection`,
 		},
-		`
+		css: func(diagramHash string, fontFamily *d2fonts.FontFamily, corpus string) string {
+			return `
 @keyframes dashdraw {
 	from {
 		stroke-dashoffset: 0;
 	}
 }
-`,
-	)
-
-	appendOnTrigger(
-		buf,
-		source,
-		[]string{
+`
+		},
+	},
+	{
+		triggers: []string{
+			`animation:d2-fade-in`,
+		},
+		css: func(diagramHash string, fontFamily *d2fonts.FontFamily, corpus string) string {
+			return `
+@keyframes d2-fade-in {
+	from {
+		opacity: 0;
+	}
+}`
+		},
+	},
+	{
+		triggers: []string{
+			`animation:d2-fade-out`,
+		},
+		css: func(diagramHash string, fontFamily *d2fonts.FontFamily, corpus string) string {
+			return `
+@keyframes d2-fade-out {
+	to {
+		opacity: 0;
+	}
+}`
+		},
+	},
+	{
+		triggers: []string{
 			`appendix-icon`,
 		},
-		`
+		css: func(diagramHash string, fontFamily *d2fonts.FontFamily, corpus string) string {
+			return `
 .appendix-icon {
 	filter: drop-shadow(0px 0px 32px rgba(31, 36, 58, 0.1));
-}`,
-	)
-
-	appendOnTrigger(
-		buf,
-		source,
-		[]string{
+}`
+		},
+	},
+	{
+		triggers: []string{
 			`class="text-bold`,
 			`<b>`,
 			`<strong>`,
 		},
-		fmt.Sprintf(`
+		css: func(diagramHash string, fontFamily *d2fonts.FontFamily, corpus string) string {
+			return fmt.Sprintf(`
 .%s .text-bold {
 	font-family: "%s-font-bold";
 }
@@ -1625,22 +1697,21 @@ func EmbedFonts(buf *bytes.Buffer, diagramHash, source string, fontFamily *d2fon
 	font-family: %s-font-bold;
 	src: url("%s");
 }`,
-			diagramHash,
-			diagramHash,
-			diagramHash,
-			fontFamily.Font(0, d2fonts.FONT_STYLE_BOLD).GetEncodedSubset(corpus),
-		),
-	)
-
-	appendOnTrigger(
-		buf,
-		source,
-		[]string{
+				diagramHash,
+				diagramHash,
+				diagramHash,
+				fontFamily.Font(0, d2fonts.FONT_STYLE_BOLD).GetEncodedSubset(corpus),
+			)
+		},
+	},
+	{
+		triggers: []string{
 			`class="text-italic`,
 			`<em>`,
 			`<dfn>`,
 		},
-		fmt.Sprintf(`
+		css: func(diagramHash string, fontFamily *d2fonts.FontFamily, corpus string) string {
+			return fmt.Sprintf(`
 .%s .text-italic {
 	font-family: "%s-font-italic";
 }
@@ -1648,24 +1719,23 @@ func EmbedFonts(buf *bytes.Buffer, diagramHash, source string, fontFamily *d2fon
 	font-family: %s-font-italic;
 	src: url("%s");
 }`,
-			diagramHash,
-			diagramHash,
-			diagramHash,
-			fontFamily.Font(0, d2fonts.FONT_STYLE_ITALIC).GetEncodedSubset(corpus),
-		),
-	)
-
-	appendOnTrigger(
-		buf,
-		source,
-		[]string{
+				diagramHash,
+				diagramHash,
+				diagramHash,
+				fontFamily.Font(0, d2fonts.FONT_STYLE_ITALIC).GetEncodedSubset(corpus),
+			)
+		},
+	},
+	{
+		triggers: []string{
 			`class="text-mono`,
 			`<pre>`,
 			`<code>`,
 			`<kbd>`,
 			`<samp>`,
 		},
-		fmt.Sprintf(`
+		css: func(diagramHash string, fontFamily *d2fonts.FontFamily, corpus string) string {
+			return fmt.Sprintf(`
 .%s .text-mono {
 	font-family: "%s-font-mono";
 }
@@ -1673,20 +1743,19 @@ func EmbedFonts(buf *bytes.Buffer, diagramHash, source string, fontFamily *d2fon
 	font-family: %s-font-mono;
 	src: url("%s");
 }`,
-			diagramHash,
-			diagramHash,
-			diagramHash,
-			d2fonts.SourceCodePro.Font(0, d2fonts.FONT_STYLE_REGULAR).GetEncodedSubset(corpus),
-		),
-	)
-
-	appendOnTrigger(
-		buf,
-		source,
-		[]string{
+				diagramHash,
+				diagramHash,
+				diagramHash,
+				d2fonts.SourceCodePro.Font(0, d2fonts.FONT_STYLE_REGULAR).GetEncodedSubset(corpus),
+			)
+		},
+	},
+	{
+		triggers: []string{
 			`class="text-mono-bold`,
 		},
-		fmt.Sprintf(`
+		css: func(diagramHash string, fontFamily *d2fonts.FontFamily, corpus string) string {
+			return fmt.Sprintf(`
 .%s .text-mono-bold {
 	font-family: "%s-font-mono-bold";
 }
@@ -1694,20 +1763,19 @@ func EmbedFonts(buf *bytes.Buffer, diagramHash, source string, fontFamily *d2fon
 	font-family: %s-font-mono-bold;
 	src: url("%s");
 }`,
-			diagramHash,
-			diagramHash,
-			diagramHash,
-			d2fonts.SourceCodePro.Font(0, d2fonts.FONT_STYLE_BOLD).GetEncodedSubset(corpus),
-		),
-	)
-
-	appendOnTrigger(
-		buf,
-		source,
-		[]string{
+				diagramHash,
+				diagramHash,
+				diagramHash,
+				d2fonts.SourceCodePro.Font(0, d2fonts.FONT_STYLE_BOLD).GetEncodedSubset(corpus),
+			)
+		},
+	},
+	{
+		triggers: []string{
 			`class="text-mono-italic`,
 		},
-		fmt.Sprintf(`
+		css: func(diagramHash string, fontFamily *d2fonts.FontFamily, corpus string) string {
+			return fmt.Sprintf(`
 .%s .text-mono-italic {
 	font-family: "%s-font-mono-italic";
 }
@@ -1715,80 +1783,110 @@ func EmbedFonts(buf *bytes.Buffer, diagramHash, source string, fontFamily *d2fon
 	font-family: %s-font-mono-italic;
 	src: url("%s");
 }`,
-			diagramHash,
-			diagramHash,
-			diagramHash,
-			d2fonts.SourceCodePro.Font(0, d2fonts.FONT_STYLE_ITALIC).GetEncodedSubset(corpus),
-		),
-	)
-
-	appendOnTrigger(
-		buf,
-		source,
-		[]string{
+				diagramHash,
+				diagramHash,
+				diagramHash,
+				d2fonts.SourceCodePro.Font(0, d2fonts.FONT_STYLE_ITALIC).GetEncodedSubset(corpus),
+			)
+		},
+	},
+	{
+		triggers: []string{
 			`sketch-overlay-bright`,
 		},
-		`
+		css: func(diagramHash string, fontFamily *d2fonts.FontFamily, corpus string) string {
+			return `
 .sketch-overlay-bright {
 	fill: url(#streaks-bright);
 	mix-blend-mode: darken;
-}`,
-	)
-
-	appendOnTrigger(
-		buf,
-		source,
-		[]string{
+}`
+		},
+	},
+	{
+		triggers: []string{
 			`sketch-overlay-normal`,
 		},
-		`
+		css: func(diagramHash string, fontFamily *d2fonts.FontFamily, corpus string) string {
+			return `
 .sketch-overlay-normal {
 	fill: url(#streaks-normal);
 	mix-blend-mode: color-burn;
-}`,
-	)
-
-	appendOnTrigger(
-		buf,
-		source,
-		[]string{
+}`
+		},
+	},
+	{
+		triggers: []string{
 			`sketch-overlay-dark`,
 		},
-		`
+		css: func(diagramHash string, fontFamily *d2fonts.FontFamily, corpus string) string {
+			return `
 .sketch-overlay-dark {
 	fill: url(#streaks-dark);
 	mix-blend-mode: overlay;
-}`,
-	)
-
-	appendOnTrigger(
-		buf,
-		source,
-		[]string{
+}`
+		},
+	},
+	{
+		triggers: []string{
 			`sketch-overlay-darker`,
 		},
-		`
+		css: func(diagramHash string, fontFamily *d2fonts.FontFamily, corpus string) string {
+			return `
 .sketch-overlay-darker {
 	fill: url(#streaks-darker);
 	mix-blend-mode: lighten;
-}`,
-	)
+}`
+		},
+	},
+}
 
-	fmt.Fprint(buf, `]]></style>`)
+func EmbedFonts(buf *bytes.Buffer, diagramHash, source string, fontFamily *d2fonts.FontFamily, corpus string) {
+	embedFonts(buf, diagramHash, func(trigger string) bool {
+		return strings.Contains(source, trigger)
+	}, fontFamily, corpus)
 }
 
-func appendOnTrigger(buf *bytes.Buffer, source string, triggers []string, newContent string) {
-	for _, trigger := range triggers {
-		if strings.Contains(source, trigger) {
-			fmt.Fprint(buf, newContent)
-			break
+// embedFonts is EmbedFonts for documents that contain the triggers contains reports.
+func embedFonts(buf *bytes.Buffer, diagramHash string, contains func(trigger string) bool, fontFamily *d2fonts.FontFamily, corpus string) {
+	fmt.Fprint(buf, `<style type="text/css"><![CDATA[`)
+	for _, style := range embeddedStyles {
+		for _, trigger := range style.triggers {
+			if contains(trigger) {
+				fmt.Fprint(buf, style.css(diagramHash, fontFamily, corpus))
+				break
+			}
 		}
 	}
+	fmt.Fprint(buf, `]]></style>`)
 }
 
 var DEFAULT_DARK_THEME *int64 = nil // no theme selected
 
 func Render(diagram *d2target.Diagram, opts *RenderOpts) ([]byte, error) {
+	out := &bytes.Buffer{}
+	err := render(out, diagram, opts, false)
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// RenderTo is Render writing the document to w incrementally instead of building it in
+// memory, for diagrams too large for that. The styles and fonts that come first depend on
+// what the shapes and connections draw, so they are drawn twice: once to find what the
+// document needs, and once to w.
+func RenderTo(w io.Writer, diagram *d2target.Diagram, opts *RenderOpts) error {
+	bw := bufio.NewWriter(w)
+	err := render(bw, diagram, opts, true)
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// render writes the document of diagram to out. When stream is set, the body is drawn
+// straight to out rather than buffered, see RenderTo.
+func render(out io.Writer, diagram *d2target.Diagram, opts *RenderOpts, stream bool) error {
 	var sketchRunner *d2sketch.Runner
 	pad := DEFAULT_PADDING
 	themeID := d2themescatalog.NeutralDefault.ID
@@ -1802,7 +1900,7 @@ func Render(diagram *d2target.Diagram, opts *RenderOpts) ([]byte, error) {
 			var err error
 			sketchRunner, err = d2sketch.InitSketchVM()
 			if err != nil {
-				return nil, err
+				return err
 			}
 		}
 		if opts.ThemeID != nil {
@@ -1812,20 +1910,10 @@ func Render(diagram *d2target.Diagram, opts *RenderOpts) ([]byte, error) {
 		scale = opts.Scale
 	}
 
-	buf := &bytes.Buffer{}
-
-	// only define shadow filter if a shape uses it
-	for _, s := range diagram.Shapes {
-		if s.Shadow {
-			defineShadowFilter(buf)
-			break
-		}
-	}
-
 	// Apply hash on IDs for targeting, to be specific for this diagram
 	diagramHash, err := diagram.HashID()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	// Some targeting is still per-board, like masks for connections
 	isolatedDiagramHash := diagramHash
@@ -1835,65 +1923,101 @@ func Render(diagram *d2target.Diagram, opts *RenderOpts) ([]byte, error) {
 
 	// SVG has no notion of z-index. The z-index is effectively the order it's drawn.
 	// So draw from the least nested to most nested
-	idToShape := make(map[string]d2target.Shape)
-	allObjects := make([]DiagramObject, 0, len(diagram.Shapes)+len(diagram.Connections))
-	for _, s := range diagram.Shapes {
-		idToShape[s.ID] = s
-		allObjects = append(allObjects, s)
-	}
-	for _, c := range diagram.Connections {
-		allObjects = append(allObjects, c)
-	}
-
-	sortObjects(allObjects)
+	allObjects, idToShape := SortedObjects(diagram)
 
-	appendixItemBuf := &bytes.Buffer{}
-
-	var labelMasks []string
-	markers := map[string]struct{}{}
-	for _, obj := range allObjects {
-		if c, is := obj.(d2target.Connection); is {
-			labelMask, err := drawConnection(buf, isolatedDiagramHash, c, markers, idToShape, sketchRunner)
-			if err != nil {
-				return nil, err
-			}
-			if labelMask != "" {
-				labelMasks = append(labelMasks, labelMask)
-			}
-		} else if s, is := obj.(d2target.Shape); is {
-			labelMask, err := drawShape(buf, appendixItemBuf, diagramHash, s, sketchRunner)
-			if err != nil {
-				return nil, err
-			} else if labelMask != "" {
-				labelMasks = append(labelMasks, labelMask)
-			}
-		} else {
-			return nil, fmt.Errorf("unknown object of type %T", obj)
-		}
+	var crop *geo.Box
+	if opts != nil {
+		crop = opts.Crop
 	}
-	// add all appendix items afterwards so they are always on top
-	fmt.Fprint(buf, appendixItemBuf)
-
+	left, top, w, h := dimensions(diagram, pad, crop)
 	// Note: we always want this since we reference it on connections even if there end up being no masked labels
-	left, top, w, h := dimensions(diagram, pad)
-	fmt.Fprint(buf, strings.Join([]string{
+	maskOpening := strings.Join([]string{
 		fmt.Sprintf(`<mask id="%s" maskUnits="userSpaceOnUse" x="%d" y="%d" width="%d" height="%d">`,
 			isolatedDiagramHash, left, top, w, h,
 		),
 		fmt.Sprintf(`<rect x="%d" y="%d" width="%d" height="%d" fill="white"></rect>`,
 			left, top, w, h,
 		),
-		strings.Join(labelMasks, "\n"),
-		`</mask>`,
-	}, "\n"))
+	}, "\n")
+
+	drawBody := func(buf io.Writer) error {
+		// only define shadow filter if a shape uses it
+		for _, s := range diagram.Shapes {
+			if s.Shadow {
+				defineShadowFilter(buf)
+				break
+			}
+		}
+
+		appendixItemBuf := &bytes.Buffer{}
+
+		var labelMasks []string
+		markers := map[string]struct{}{}
+		noElementAnimations := opts != nil && opts.NoElementAnimations
+		for _, obj := range allObjects {
+			if c, is := obj.(d2target.Connection); is {
+				if noElementAnimations {
+					c.Animate = nil
+				}
+				labelMask, err := drawConnection(buf, isolatedDiagramHash, c, markers, idToShape, sketchRunner)
+				if err != nil {
+					return err
+				}
+				if labelMask != "" {
+					labelMasks = append(labelMasks, labelMask)
+				}
+			} else if s, is := obj.(d2target.Shape); is {
+				if noElementAnimations {
+					s.Animate = nil
+				}
+				labelMask, err := drawShape(buf, appendixItemBuf, diagramHash, s, sketchRunner)
+				if err != nil {
+					return err
+				} else if labelMask != "" {
+					labelMasks = append(labelMasks, labelMask)
+				}
+			} else {
+				return fmt.Errorf("unknown object of type %T", obj)
+			}
+		}
+		// add all appendix items afterwards so they are always on top
+		fmt.Fprint(buf, appendixItemBuf)
+
+		fmt.Fprint(buf, strings.Join([]string{
+			maskOpening,
+			strings.Join(labelMasks, "\n"),
+			`</mask>`,
+		}, "\n"))
+		return nil
+	}
+
+	var contains func(trigger string) bool
+	var body *bytes.Buffer
+	if stream {
+		scanner := newTriggerScanner(svgTriggers())
+		err := drawBody(scanner)
+		if err != nil {
+			return err
+		}
+		contains = scanner.contains
+	} else {
+		body = &bytes.Buffer{}
+		err := drawBody(body)
+		if err != nil {
+			return err
+		}
+		contains = func(trigger string) bool {
+			return bytes.Contains(body.Bytes(), []byte(trigger))
+		}
+	}
 
 	// generate style elements that will be appended to the SVG tag
 	upperBuf := &bytes.Buffer{}
 	if opts.MasterID == "" {
-		EmbedFonts(upperBuf, diagramHash, buf.String(), diagram.FontFamily, diagram.GetCorpus()) // EmbedFonts *must* run before `d2sketch.DefineFillPatterns`, but after all elements are appended to `buf`
+		embedFonts(upperBuf, diagramHash, contains, diagram.FontFamily, diagram.GetCorpus()) // embedFonts *must* run before `d2sketch.DefineFillPatterns`, but after all elements are drawn
 		themeStylesheet, err := ThemeCSS(diagramHash, &themeID, darkThemeID, opts.ThemeOverrides, opts.DarkThemeOverrides)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		fmt.Fprintf(upperBuf, `<style type="text/css"><![CDATA[%s%s]]></style>`, BaseStylesheet, themeStylesheet)
 
@@ -1972,10 +2096,9 @@ func Render(diagram *d2target.Diagram, opts *RenderOpts) ([]byte, error) {
 		h += int(math.Ceil(float64(diagram.Root.StrokeWidth)/2.) * 2.)
 	}
 
-	bufStr := buf.String()
 	patternDefs := ""
 	for _, pattern := range d2graph.FillPatterns {
-		if strings.Contains(bufStr, fmt.Sprintf("%s-overlay", pattern)) || diagram.Root.FillPattern == pattern {
+		if contains(fmt.Sprintf("%s-overlay", pattern)) || diagram.Root.FillPattern == pattern {
 			if patternDefs == "" {
 				fmt.Fprint(upperBuf, `<style type="text/css"><![CDATA[`)
 			}
@@ -2035,7 +2158,7 @@ func Render(diagram *d2target.Diagram, opts *RenderOpts) ([]byte, error) {
 	}
 
 	// TODO minify
-	docRendered := fmt.Sprintf(`%s%s<%s %s class="%s" width="%d" height="%d" viewBox="%d %d %d %d">%s%s%s%s</%s>%s`,
+	_, err = fmt.Fprintf(out, `%s%s<%s %s class="%s" width="%d" height="%d" viewBox="%d %d %d %d">%s%s%s`,
 		xmlTag,
 		fitToScreenWrapperOpening,
 		tag,
@@ -2045,11 +2168,73 @@ func Render(diagram *d2target.Diagram, opts *RenderOpts) ([]byte, error) {
 		doubleBorderElStr,
 		backgroundEl.Render(),
 		upperBuf.String(),
-		buf.String(),
-		tag,
-		fitToScreenWrapperClosing,
 	)
-	return []byte(docRendered), nil
+	if err != nil {
+		return err
+	}
+	if stream {
+		err = drawBody(out)
+	} else {
+		_, err = out.Write(body.Bytes())
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, `</%s>%s`, tag, fitToScreenWrapperClosing)
+	return err
+}
+
+// svgTriggers returns every string whose presence in the body of a document changes the
+// styles render embeds.
+func svgTriggers() []string {
+	var triggers []string
+	for _, style := range embeddedStyles {
+		triggers = append(triggers, style.triggers...)
+	}
+	for _, pattern := range d2graph.FillPatterns {
+		triggers = append(triggers, fmt.Sprintf("%s-overlay", pattern))
+	}
+	return triggers
+}
+
+// triggerScanner is a writer that records which of its triggers are written to it, to find
+// the styles a document needs without keeping its body in memory.
+type triggerScanner struct {
+	triggers [][]byte
+	found    map[string]struct{}
+	// tail is the end of what was written that triggers may continue across writes.
+	tail   []byte
+	maxLen int
+}
+
+func newTriggerScanner(triggers []string) *triggerScanner {
+	s := &triggerScanner{
+		found: make(map[string]struct{}),
+	}
+	for _, t := range triggers {
+		s.triggers = append(s.triggers, []byte(t))
+		s.maxLen = max(s.maxLen, len(t))
+	}
+	return s
+}
+
+func (s *triggerScanner) Write(p []byte) (int, error) {
+	buf := append(s.tail, p...)
+	for _, t := range s.triggers {
+		if _, ok := s.found[string(t)]; !ok && bytes.Contains(buf, t) {
+			s.found[string(t)] = struct{}{}
+		}
+	}
+	if keep := s.maxLen - 1; len(buf) > keep {
+		buf = buf[len(buf)-keep:]
+	}
+	s.tail = append(s.tail[:0], buf...)
+	return len(p), nil
+}
+
+func (s *triggerScanner) contains(trigger string) bool {
+	_, ok := s.found[trigger]
+	return ok
 }
 
 // TODO include only colors that are being used to reduce size
@@ -2281,13 +2466,29 @@ type DiagramObject interface {
 	GetZIndex() int
 }
 
-// sortObjects sorts all diagrams objects (shapes and connections) in the desired drawing order
+// SortObjects sorts all diagrams objects (shapes and connections) in the desired drawing order
+// SortedObjects returns the shapes and connections of diagram in the order they are drawn,
+// see SortObjects, along with its shapes by ID as ConnectionPathData takes them.
+func SortedObjects(diagram *d2target.Diagram) ([]DiagramObject, map[string]d2target.Shape) {
+	idToShape := make(map[string]d2target.Shape, len(diagram.Shapes))
+	allObjects := make([]DiagramObject, 0, len(diagram.Shapes)+len(diagram.Connections))
+	for _, s := range diagram.Shapes {
+		idToShape[s.ID] = s
+		allObjects = append(allObjects, s)
+	}
+	for _, c := range diagram.Connections {
+		allObjects = append(allObjects, c)
+	}
+	SortObjects(allObjects)
+	return allObjects, idToShape
+}
+
 // the sorting criteria is:
 // 1. zIndex, lower comes first
 // 2. two shapes with the same zIndex are sorted by their level (container nesting), containers come first
 // 3. two shapes with the same zIndex and same level, are sorted in the order they were exported
 // 4. shape and edge, shapes come first
-func sortObjects(allObjects []DiagramObject) {
+func SortObjects(allObjects []DiagramObject) {
 	sort.SliceStable(allObjects, func(i, j int) bool {
 		// first sort by zIndex
 		iZIndex := allObjects[i].GetZIndex()
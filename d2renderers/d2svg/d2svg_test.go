@@ -69,7 +69,7 @@ func TestSortObjects(t *testing.T) {
 		allObjects[7],
 	}
 
-	sortObjects(allObjects)
+	SortObjects(allObjects)
 
 	if len(allObjects) != len(expectedOrder) {
 		t.Fatal("number of objects changed while sorting")
@@ -81,3 +81,17 @@ func TestSortObjects(t *testing.T) {
 		}
 	}
 }
+
+func TestTriggerScanner(t *testing.T) {
+	s := newTriggerScanner([]string{`class="text"`, `<b>`, `sketch-overlay-dark`})
+	// Triggers split across writes are found too.
+	for _, w := range []string{`<text cla`, `ss="te`, `xt">hi</text><`, `b>`} {
+		s.Write([]byte(w))
+	}
+	if !s.contains(`class="text"`) || !s.contains(`<b>`) {
+		t.Fatalf("expected triggers split across writes to be found")
+	}
+	if s.contains(`sketch-overlay-dark`) {
+		t.Fatalf("unexpected trigger")
+	}
+}
@@ -0,0 +1,70 @@
+package d2svg_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"oss.terrastruct.com/util-go/assert"
+	"oss.terrastruct.com/util-go/go2"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2dagrelayout"
+	"oss.terrastruct.com/d2/d2lib"
+	"oss.terrastruct.com/d2/d2renderers/d2svg"
+	"oss.terrastruct.com/d2/lib/log"
+	"oss.terrastruct.com/d2/lib/textmeasure"
+)
+
+func TestRenderTo(t *testing.T) {
+	t.Parallel()
+
+	script := `vars: {
+  d2-config: {
+    theme-overrides: {
+      B1: "#2E7D32"
+    }
+  }
+}
+style.fill-pattern: dots
+md: |md
+  # Title
+  **bold**, _italic_ and ` + "`code`" + `
+|
+x: {
+  tooltip: Appendix icons come last
+  style.shadow: true
+  style.fill-pattern: lines
+}
+y: {
+  link: https://d2lang.com
+  style.underline: true
+}
+x -> y: {
+  style.animated: true
+}
+x -> md: label
+`
+	for _, sketch := range []bool{false, true} {
+		ctx := log.WithTB(context.Background(), t, nil)
+		ruler, err := textmeasure.NewRuler()
+		assert.Success(t, err)
+		renderOpts := &d2svg.RenderOpts{
+			Sketch: go2.Pointer(sketch),
+		}
+		diagram, _, err := d2lib.Compile(ctx, script, &d2lib.CompileOptions{
+			Ruler: ruler,
+			LayoutResolver: func(engine string) (d2graph.LayoutGraph, error) {
+				return d2dagrelayout.DefaultLayout, nil
+			},
+		}, renderOpts)
+		assert.Success(t, err)
+
+		exp, err := d2svg.Render(diagram, renderOpts)
+		assert.Success(t, err)
+		var got bytes.Buffer
+		err = d2svg.RenderTo(&got, diagram, renderOpts)
+		assert.Success(t, err)
+		assert.Equal(t, string(exp), got.String())
+	}
+}
@@ -0,0 +1,43 @@
+package d2target
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"oss.terrastruct.com/util-go/go2"
+)
+
+// Animation is how a shape or connection appears or disappears after its board is shown,
+// set with the animate keyword, e.g. animate: fade-in 2.
+type Animation struct {
+	// Effect is one of AnimationEffects.
+	Effect string `json:"effect"`
+	// Order is the place of the animation in the sequence of the animations of the board,
+	// starting at 1. Animations of the same order play together.
+	Order int `json:"order"`
+}
+
+// AnimationEffects are the effects of the animate keyword.
+var AnimationEffects = []string{"fade-in", "fade-out"}
+
+// ParseAnimation parses the value of the animate keyword, an effect optionally followed by
+// its order, 1 if omitted.
+func ParseAnimation(s string) (Animation, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return Animation{}, fmt.Errorf(`animate must be an effect followed by its order, e.g. "fade-in 2", got %q`, s)
+	}
+	a := Animation{Effect: fields[0], Order: 1}
+	if !go2.Contains(AnimationEffects, a.Effect) {
+		return Animation{}, fmt.Errorf("animate effect must be one of %s, got %q", strings.Join(AnimationEffects, ", "), a.Effect)
+	}
+	if len(fields) == 2 {
+		order, err := strconv.Atoi(fields[1])
+		if err != nil || order <= 0 {
+			return Animation{}, fmt.Errorf("animate order must be a positive integer, got %q", fields[1])
+		}
+		a.Order = order
+	}
+	return a, nil
+}
@@ -1,6 +1,7 @@
 package d2target
 
 import (
+	"crypto/sha1"
 	"encoding/json"
 	"fmt"
 	"hash/fnv"
@@ -47,6 +48,11 @@ type Config struct {
 	LayoutEngine       *string         `json:"layoutEngine"`
 	ThemeOverrides     *ThemeOverrides `json:"themeOverrides,omitempty"`
 	DarkThemeOverrides *ThemeOverrides `json:"darkThemeOverrides,omitempty"`
+	LatexDisplay       *bool           `json:"latexDisplay,omitempty"`
+	LatexNumbering     *bool           `json:"latexNumbering,omitempty"`
+	SequenceNumbering  *bool           `json:"sequenceNumbering,omitempty"`
+	PDFHeader          *string         `json:"pdfHeader,omitempty"`
+	PDFFooter          *string         `json:"pdfFooter,omitempty"`
 }
 
 type ThemeOverrides struct {
@@ -76,6 +82,7 @@ type Diagram struct {
 	// See docs on the same field in d2graph to understand what it means.
 	IsFolderOnly bool                `json:"isFolderOnly"`
 	Description  string              `json:"description,omitempty"`
+	Transition   *Transition         `json:"transition,omitempty"`
 	FontFamily   *d2fonts.FontFamily `json:"fontFamily,omitempty"`
 
 	Shapes      []Shape      `json:"shapes"`
@@ -148,6 +155,44 @@ func (d *Diagram) GetBoard(boardPath []string) *Diagram {
 	return nil
 }
 
+// AssignStableIDs sets the StableID of every shape and connection of d and its boards.
+// boardPath is the path of d, nil for the root.
+func (d *Diagram) AssignStableIDs(boardPath []string) {
+	for i := range d.Shapes {
+		d.Shapes[i].StableID = StableID(boardPath, d.Shapes[i].ID)
+	}
+	for i := range d.Connections {
+		d.Connections[i].StableID = StableID(boardPath, d.Connections[i].ID)
+	}
+	for _, b := range d.Layers {
+		b.AssignStableIDs(append(append([]string{}, boardPath...), "layers", b.Name))
+	}
+	for _, b := range d.Scenarios {
+		b.AssignStableIDs(append(append([]string{}, boardPath...), "scenarios", b.Name))
+	}
+	for _, b := range d.Steps {
+		b.AssignStableIDs(append(append([]string{}, boardPath...), "steps", b.Name))
+	}
+}
+
+// StableID derives a UUID formatted identifier from the board path and absolute ID of a
+// shape or connection. It depends on nothing else, so the same entity keeps the same ID
+// across edits and layouts for as long as it keeps its key. IDs are case insensitive in D2,
+// and so are stable IDs.
+func StableID(boardPath []string, id string) string {
+	h := sha1.New()
+	for _, p := range boardPath {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	h.Write([]byte(strings.ToLower(id)))
+	b := h.Sum(nil)[:16]
+	// Version 5 and the RFC 4122 variant, like a name based UUID.
+	b[6] = (b[6] & 0x0f) | 0x50
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
 func (diagram Diagram) Bytes() ([]byte, error) {
 	b1, err := json.Marshal(diagram.Shapes)
 	if err != nil {
@@ -435,8 +480,12 @@ func NewDiagram() *Diagram {
 }
 
 type Shape struct {
-	ID   string `json:"id"`
-	Type string `json:"type"`
+	ID string `json:"id"`
+	// StableID is only set when requested at compile time. See AssignStableIDs.
+	StableID string `json:"stableID,omitempty"`
+	Type     string `json:"type"`
+	// ShapePath is the SVG path data of custom shapes.
+	ShapePath string `json:"shapePath,omitempty"`
 
 	Classes []string `json:"classes,omitempty"`
 
@@ -465,6 +514,8 @@ type Shape struct {
 	Icon         *url.URL `json:"icon"`
 	IconPosition string   `json:"iconPosition"`
 
+	Animate *Animation `json:"animate,omitempty"`
+
 	// Whether the shape should allow shapes behind it to bleed through
 	// Currently just used for sequence diagram groups
 	Blend bool `json:"blend"`
@@ -562,6 +613,8 @@ func BaseShape() *Shape {
 
 type Connection struct {
 	ID string `json:"id"`
+	// StableID is only set when requested at compile time. See AssignStableIDs.
+	StableID string `json:"stableID,omitempty"`
 
 	Classes []string `json:"classes,omitempty"`
 
@@ -591,6 +644,8 @@ type Connection struct {
 	Tooltip  string   `json:"tooltip"`
 	Icon     *url.URL `json:"icon"`
 
+	Animate *Animation `json:"animate,omitempty"`
+
 	ZIndex int `json:"zIndex"`
 }
 
@@ -845,6 +900,150 @@ func (arrowhead Arrowhead) Dimensions(strokeWidth float64) (width, height float6
 	return baseWidth + clippedStrokeWidth*widthMultiplier, baseHeight + clippedStrokeWidth*heightMultiplier
 }
 
+// ArrowheadMarker is the geometry of the marker d2svg draws for an arrowhead, in the
+// coordinates of its viewBox, for renderers that draw arrowheads as shapes of their own.
+type ArrowheadMarker struct {
+	// Width and Height are the size of the viewBox, which the marker is clipped to.
+	Width  float64
+	Height float64
+	// Ref is the point of the marker that is placed on the end of the connection, the
+	// marker pointing right along the connection.
+	Ref geo.Point
+
+	Elements []MarkerElement
+}
+
+// MarkerElement is a polygon, polyline or circle of an ArrowheadMarker. Fill and Stroke are
+// colors, or empty for none. Strokes are as wide as the stroke of the connection.
+type MarkerElement struct {
+	// Points are the points of polygons and polylines, or the center of circles.
+	Points []geo.Point
+	// Closed is set for polygons.
+	Closed bool
+	// Radius is only set for circles.
+	Radius float64
+
+	Fill   string
+	Stroke string
+}
+
+// Marker returns the geometry of the marker of arrowhead at the source or target of a
+// connection with strokeWidth and stroke.
+func (arrowhead Arrowhead) Marker(isTarget bool, strokeWidth float64, stroke string) ArrowheadMarker {
+	sw := strokeWidth
+	width, height := arrowhead.Dimensions(sw)
+	m := ArrowheadMarker{
+		Width:  width,
+		Height: height,
+		Ref:    geo.Point{X: 1.5 * sw, Y: height / 2},
+	}
+	if isTarget {
+		m.Ref.X = width - 1.5*sw
+	}
+	if arrowhead == DiamondArrowhead {
+		if isTarget {
+			m.Ref.X = width - 0.6*sw
+		} else {
+			m.Ref.X = width/8 + 0.6*sw
+		}
+		m.Width *= 1.1
+	}
+
+	points := func(xys ...float64) []geo.Point {
+		pts := make([]geo.Point, 0, len(xys)/2)
+		for i := 0; i < len(xys); i += 2 {
+			pts = append(pts, geo.Point{X: xys[i], Y: xys[i+1]})
+		}
+		return pts
+	}
+	polygon := func(fill, stroke string, xys ...float64) {
+		m.Elements = append(m.Elements, MarkerElement{Points: points(xys...), Closed: true, Fill: fill, Stroke: stroke})
+	}
+	polyline := func(xys ...float64) {
+		m.Elements = append(m.Elements, MarkerElement{Points: points(xys...), Stroke: stroke})
+	}
+	circle := func(cx, cy, r float64, fill, stroke string) {
+		m.Elements = append(m.Elements, MarkerElement{Points: points(cx, cy), Radius: r, Fill: fill, Stroke: stroke})
+	}
+
+	switch arrowhead {
+	case ArrowArrowhead:
+		if isTarget {
+			polygon(stroke, "", 0, 0, width, height/2, 0, height, width/4, height/2)
+		} else {
+			polygon(stroke, "", 0, height/2, width, 0, width*3/4, height/2, width, height)
+		}
+	case UnfilledTriangleArrowhead:
+		inset := sw / 2
+		if isTarget {
+			polygon(BG_COLOR, stroke, inset, inset, width-inset, height/2, inset, height-inset)
+		} else {
+			polygon(BG_COLOR, stroke, width-inset, inset, inset, height/2, width-inset, height-inset)
+		}
+	case TriangleArrowhead:
+		if isTarget {
+			polygon(stroke, "", 0, 0, width, height/2, 0, height)
+		} else {
+			polygon(stroke, "", width, 0, 0, height/2, width, height)
+		}
+	case LineArrowhead:
+		if isTarget {
+			polyline(sw/2, sw/2, width-sw/2, height/2, sw/2, height-sw/2)
+		} else {
+			polyline(width-sw/2, sw/2, sw/2, height/2, width-sw/2, height-sw/2)
+		}
+	case FilledDiamondArrowhead:
+		polygon(stroke, "", 0, height/2, width/2, 0, width, height/2, width/2, height)
+	case DiamondArrowhead:
+		if isTarget {
+			polygon(BG_COLOR, stroke, 0, height/2, width/2, height/8, width, height/2, width/2, height*0.9)
+		} else {
+			polygon(BG_COLOR, stroke, width/8, height/2, width*0.6, height/8, width*1.1, height/2, width*0.6, height*7/8)
+		}
+	case FilledCircleArrowhead, CircleArrowhead:
+		radius := width / 2
+		cx := radius - sw/2
+		if isTarget {
+			cx = radius + sw/2
+		}
+		if arrowhead == FilledCircleArrowhead {
+			circle(cx, radius, radius-sw/2, stroke, "")
+		} else {
+			circle(cx, radius, radius-sw, BG_COLOR, stroke)
+		}
+	case CfOne, CfMany, CfOneRequired, CfManyRequired:
+		offset := 3.0 + sw*1.8
+		// Crow's feet of sources are the ones of targets turned around.
+		flip := func(x, y float64) (float64, float64) {
+			if isTarget {
+				return x, y
+			}
+			return width - x, height - y
+		}
+		line := func(x1, y1, x2, y2 float64) {
+			x1, y1 = flip(x1, y1)
+			x2, y2 = flip(x2, y2)
+			polyline(x1, y1, x2, y2)
+		}
+
+		if arrowhead == CfOneRequired || arrowhead == CfManyRequired {
+			line(offset, 0, offset, height)
+		} else {
+			x, y := flip(offset/2+2, height/2)
+			circle(x, y, offset/2, BG_COLOR, stroke)
+		}
+
+		line(width-3, height/2, width+offset, height/2)
+		if arrowhead == CfMany || arrowhead == CfManyRequired {
+			line(offset+3, height/2, width+offset, 0)
+			line(offset+3, height/2, width+offset, height)
+		} else {
+			line(offset*2, 0, offset*2, height)
+		}
+	}
+	return m
+}
+
 type Point struct {
 	X int `json:"x"`
 	Y int `json:"y"`
@@ -872,6 +1071,7 @@ const (
 	ShapeCircle          = "circle"
 	ShapeHexagon         = "hexagon"
 	ShapeCloud           = "cloud"
+	ShapeCustom          = "custom"
 	ShapeText            = "text"
 	ShapeCode            = "code"
 	ShapeClass           = "class"
@@ -899,6 +1099,7 @@ var Shapes = []string{
 	ShapeCircle,
 	ShapeHexagon,
 	ShapeCloud,
+	ShapeCustom,
 	ShapeText,
 	ShapeCode,
 	ShapeClass,
@@ -967,6 +1168,7 @@ var DSL_SHAPE_TO_SHAPE_TYPE = map[string]string{
 	ShapeCircle:          shape.CIRCLE_TYPE,
 	ShapeHexagon:         shape.HEXAGON_TYPE,
 	ShapeCloud:           shape.CLOUD_TYPE,
+	ShapeCustom:          shape.CUSTOM_TYPE,
 	ShapeText:            shape.TEXT_TYPE,
 	ShapeCode:            shape.CODE_TYPE,
 	ShapeClass:           shape.CLASS_TYPE,
@@ -0,0 +1,194 @@
+package d2target
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// SchemaVersion is the version of the JSON encoding of Diagram written by Marshal.
+//
+// Compatibility guarantees:
+//   - Within a version, fields are only ever added, never removed, renamed or retyped. Readers
+//     must ignore fields they do not know.
+//   - Any other change increments SchemaVersion. Unmarshal keeps reading every prior version.
+//   - Unmarshal rejects documents from newer versions rather than misreading them.
+const SchemaVersion = 1
+
+// Document is the versioned envelope Marshal writes around a Diagram.
+type Document struct {
+	Version int      `json:"version"`
+	Diagram *Diagram `json:"diagram"`
+}
+
+// Marshal encodes d into a versioned JSON document. See JSONSchema for its shape.
+func Marshal(d *Diagram) ([]byte, error) {
+	return json.Marshal(Document{
+		Version: SchemaVersion,
+		Diagram: d,
+	})
+}
+
+// Unmarshal decodes a document written by Marshal of this or any prior version. A bare
+// Diagram, as written by encoding/json before versioning, is accepted as version 0.
+func Unmarshal(b []byte) (*Diagram, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(b, &probe); err != nil {
+		return nil, fmt.Errorf("failed to decode diagram: %w", err)
+	}
+	if _, ok := probe["version"]; !ok {
+		var d Diagram
+		if err := json.Unmarshal(b, &d); err != nil {
+			return nil, fmt.Errorf("failed to decode diagram: %w", err)
+		}
+		return &d, nil
+	}
+
+	var doc Document
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode diagram: %w", err)
+	}
+	if doc.Version > SchemaVersion {
+		return nil, fmt.Errorf("diagram is of schema version %d but only versions up to %d are supported, upgrade d2", doc.Version, SchemaVersion)
+	}
+	if doc.Diagram == nil {
+		return nil, fmt.Errorf("failed to decode diagram: missing diagram")
+	}
+	return doc.Diagram, nil
+}
+
+// JSONSchema returns the JSON Schema, draft 2020-12, of the documents written by Marshal. It
+// is generated from the Go types so it can never fall out of date with them.
+func JSONSchema() ([]byte, error) {
+	sg := &schemaGen{
+		defs: make(map[string]any),
+	}
+	s := sg.object(reflect.TypeOf(Document{}))
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["$id"] = fmt.Sprintf("https://d2lang.com/schemas/diagram.v%d.json", SchemaVersion)
+	s["title"] = "D2 diagram"
+	s["$defs"] = sg.defs
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type schemaGen struct {
+	defs map[string]any
+}
+
+func (sg *schemaGen) schema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return map[string]any{
+			"anyOf": []any{
+				sg.schema(t.Elem()),
+				map[string]any{"type": "null"},
+			},
+		}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{
+			"type":  []any{"array", "null"},
+			"items": sg.schema(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]any{
+			"type":                 []any{"object", "null"},
+			"additionalProperties": sg.schema(t.Elem()),
+		}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Struct:
+		name := defName(t)
+		if _, ok := sg.defs[name]; !ok {
+			// Reserve the name first for recursive types like Diagram.
+			sg.defs[name] = nil
+			sg.defs[name] = sg.object(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + name}
+	}
+	return map[string]any{}
+}
+
+func (sg *schemaGen) object(t reflect.Type) map[string]any {
+	props := make(map[string]any)
+	var required []string
+	sg.fields(t, props, &required)
+	o := map[string]any{
+		"type":       "object",
+		"properties": props,
+	}
+	if len(required) > 0 {
+		o["required"] = required
+	}
+	return o
+}
+
+// fields collects the properties of t the way encoding/json does, flattening embedded
+// structs. Fields declared directly on t shadow those of embedded structs.
+func (sg *schemaGen) fields(t reflect.Type, props map[string]any, required *[]string) {
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded = append(embedded, ft)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if _, ok := props[name]; ok {
+			continue
+		}
+		props[name] = sg.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+	for _, et := range embedded {
+		sg.fields(et, props, required)
+	}
+}
+
+func defName(t reflect.Type) string {
+	if t.PkgPath() == reflect.TypeOf(Diagram{}).PkgPath() {
+		return t.Name()
+	}
+	pkg := t.PkgPath()
+	if i := strings.LastIndex(pkg, "/"); i >= 0 {
+		pkg = pkg[i+1:]
+	}
+	return pkg + "." + t.Name()
+}
@@ -0,0 +1,56 @@
+package d2target_test
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	tassert "github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/util-go/assert"
+	"oss.terrastruct.com/util-go/diff"
+
+	"oss.terrastruct.com/d2/d2target"
+)
+
+func TestJSONSchema(t *testing.T) {
+	t.Parallel()
+
+	b, err := d2target.JSONSchema()
+	assert.Success(t, err)
+	err = diff.Testdata(filepath.Join("..", "testdata", "d2target", t.Name()), ".json", b)
+	assert.Success(t, err)
+}
+
+func TestMarshal(t *testing.T) {
+	t.Parallel()
+
+	d := d2target.NewDiagram()
+	d.Name = "x"
+	s := d2target.BaseShape()
+	s.ID = "a"
+	s.Label = "hello"
+	d.Shapes = append(d.Shapes, *s)
+	d.Layers = append(d.Layers, &d2target.Diagram{Name: "l"})
+
+	b, err := d2target.Marshal(d)
+	assert.Success(t, err)
+
+	var doc map[string]json.RawMessage
+	assert.Success(t, json.Unmarshal(b, &doc))
+	tassert.Equal(t, "1", string(doc["version"]))
+
+	d2, err := d2target.Unmarshal(b)
+	assert.Success(t, err)
+	tassert.Equal(t, d, d2)
+
+	// Diagrams encoded before versioning are read as version 0.
+	b, err = json.Marshal(d)
+	assert.Success(t, err)
+	d2, err = d2target.Unmarshal(b)
+	assert.Success(t, err)
+	tassert.Equal(t, d, d2)
+
+	_, err = d2target.Unmarshal([]byte(`{"version": 1000, "diagram": {}}`))
+	tassert.EqualError(t, err, "diagram is of schema version 1000 but only versions up to 1 are supported, upgrade d2")
+}
@@ -0,0 +1,73 @@
+package d2target
+
+import (
+	"strconv"
+	"strings"
+
+	"oss.terrastruct.com/util-go/go2"
+)
+
+// Transition is how animations transition to a board, set with the transition keyword at
+// the top level of the board.
+type Transition struct {
+	// Interval is how long the board is shown in milliseconds, 0 for the interval of the
+	// animation.
+	Interval int `json:"interval,omitempty"`
+	// Duration is how long the board takes to fade in over the previous one in
+	// milliseconds.
+	Duration int `json:"duration,omitempty"`
+	// Easing is the CSS easing function of the fade, e.g. ease-in-out or
+	// cubic-bezier(0.4, 0, 0.2, 1). Empty is the default of browsers, ease.
+	Easing string `json:"easing,omitempty"`
+}
+
+// Easings are the keywords of the easing functions of transitions. cubic-bezier functions
+// are also supported.
+var Easings = []string{"linear", "ease", "ease-in", "ease-out", "ease-in-out", "step-start", "step-end"}
+
+// ValidEasing reports whether easing is one of Easings or a cubic-bezier function with x
+// coordinates between 0 and 1, as CSS requires.
+func ValidEasing(easing string) bool {
+	if go2.Contains(Easings, easing) {
+		return true
+	}
+	args, ok := strings.CutPrefix(easing, "cubic-bezier(")
+	if !ok {
+		return false
+	}
+	args, ok = strings.CutSuffix(args, ")")
+	if !ok {
+		return false
+	}
+	points := strings.Split(args, ",")
+	if len(points) != 4 {
+		return false
+	}
+	for i, p := range points {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return false
+		}
+		if i%2 == 0 && (f < 0 || f > 1) {
+			return false
+		}
+	}
+	return true
+}
+
+// Override returns t with the fields set in o replaced.
+func (t Transition) Override(o *Transition) Transition {
+	if o == nil {
+		return t
+	}
+	if o.Interval != 0 {
+		t.Interval = o.Interval
+	}
+	if o.Duration != 0 {
+		t.Duration = o.Duration
+	}
+	if o.Easing != "" {
+		t.Easing = o.Easing
+	}
+	return t
+}
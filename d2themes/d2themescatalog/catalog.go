@@ -1,10 +1,14 @@
 package d2themescatalog
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
+	"oss.terrastruct.com/util-go/go2"
+
 	"oss.terrastruct.com/d2/d2themes"
+	"oss.terrastruct.com/d2/lib/color"
 )
 
 var LightCatalog = []d2themes.Theme{
@@ -63,3 +67,53 @@ func CLIString() string {
 
 	return s.String()
 }
+
+// Register adds theme to the catalog, replacing the theme of the same ID if any, so that
+// diagrams can use palettes that are not built in, e.g. those of a brand. Themes with IDs
+// from 200 to 299 are dark. Register is not safe to call while themes are looked up, so it
+// should be called before compiling diagrams.
+func Register(theme d2themes.Theme) error {
+	if err := validate(theme); err != nil {
+		return fmt.Errorf("invalid theme %q: %w", theme.Name, err)
+	}
+	catalog := &LightCatalog
+	if theme.IsDark() {
+		catalog = &DarkCatalog
+	}
+	for _, c := range []*[]d2themes.Theme{&LightCatalog, &DarkCatalog} {
+		for i, t := range *c {
+			if t.ID == theme.ID {
+				*c = append((*c)[:i:i], (*c)[i+1:]...)
+				break
+			}
+		}
+	}
+	*catalog = append(*catalog, theme)
+	return nil
+}
+
+func validate(theme d2themes.Theme) error {
+	if theme.Name == "" {
+		return errors.New("missing name")
+	}
+	n := theme.Colors.Neutrals
+	colors := []struct {
+		name  string
+		value string
+	}{
+		{"N1", n.N1}, {"N2", n.N2}, {"N3", n.N3}, {"N4", n.N4}, {"N5", n.N5}, {"N6", n.N6}, {"N7", n.N7},
+		{"B1", theme.Colors.B1}, {"B2", theme.Colors.B2}, {"B3", theme.Colors.B3},
+		{"B4", theme.Colors.B4}, {"B5", theme.Colors.B5}, {"B6", theme.Colors.B6},
+		{"AA2", theme.Colors.AA2}, {"AA4", theme.Colors.AA4}, {"AA5", theme.Colors.AA5},
+		{"AB4", theme.Colors.AB4}, {"AB5", theme.Colors.AB5},
+	}
+	for _, c := range colors {
+		if c.value == "" {
+			return fmt.Errorf("missing color %s", c.name)
+		}
+		if !color.ColorHexRegex.MatchString(c.value) && !go2.Contains(color.NamedColors, strings.ToLower(c.value)) {
+			return fmt.Errorf("color %s must be a named color or a hex code such as #f0ff3a, got %q", c.name, c.value)
+		}
+	}
+	return nil
+}
@@ -3,13 +3,21 @@ package e2etests_cli
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"image"
+	_ "image/png"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -21,7 +29,10 @@ import (
 	"oss.terrastruct.com/util-go/xos"
 
 	"oss.terrastruct.com/d2/d2cli"
+	"oss.terrastruct.com/d2/d2plugin"
+	"oss.terrastruct.com/d2/d2target"
 	"oss.terrastruct.com/d2/lib/pptx"
+	"oss.terrastruct.com/d2/lib/version"
 	"oss.terrastruct.com/d2/lib/xgif"
 )
 
@@ -57,6 +68,180 @@ func TestCLI_E2E(t *testing.T) {
 				testdataIgnoreDiff(t, ".png", png)
 			},
 		},
+		{
+			name: "png_size",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "hello-world.d2", `x -> y`)
+				err := runTestMainPersist(t, ctx, dir, env, "--width=400", "hello-world.d2", "width.png")
+				assert.Success(t, err)
+				cfg, _, err := image.DecodeConfig(bytes.NewReader(readFile(t, dir, "width.png")))
+				assert.Success(t, err)
+				assert.Equal(t, 400, cfg.Width)
+
+				err = runTestMainPersist(t, ctx, dir, env, "--width=400", "--height=400", "hello-world.d2", "square.png")
+				assert.Success(t, err)
+				cfg, _, err = image.DecodeConfig(bytes.NewReader(readFile(t, dir, "square.png")))
+				assert.Success(t, err)
+				assert.Equal(t, 400, cfg.Width)
+				assert.Equal(t, 400, cfg.Height)
+
+				err = runTestMain(t, ctx, dir, env, "--png-scale=4", "hello-world.d2", "scale.png")
+				assert.Success(t, err)
+				// 4 times 96 DPI in pixels per meter
+				assert.Equal(t, true, bytes.Contains(readFile(t, dir, "scale.png"), []byte{'p', 'H', 'Y', 's', 0, 0, 0x3b, 0x0e, 0, 0, 0x3b, 0x0e, 1}))
+
+				// GIF frames are sized alike.
+				writeFile(t, dir, "animated.d2", "x -> y\nlayers: {l: {z}}")
+				err = runTestMain(t, ctx, dir, env, "--width=400", "--raster-engine=native", "--animate-interval=100", "animated.d2", "animated.gif")
+				assert.Success(t, err)
+				cfg, _, err = image.DecodeConfig(bytes.NewReader(readFile(t, dir, "animated.gif")))
+				assert.Success(t, err)
+				assert.Equal(t, 400, cfg.Width)
+			},
+		},
+		{
+			name: "png_background",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "hello-world.d2", `x -> y`)
+				err := runTestMainPersist(t, ctx, dir, env, "--background=transparent", "hello-world.d2", "transparent.png")
+				assert.Success(t, err)
+				img, _, err := image.Decode(bytes.NewReader(readFile(t, dir, "transparent.png")))
+				assert.Success(t, err)
+				_, _, _, a := img.At(0, 0).RGBA()
+				assert.Equal(t, uint32(0), a)
+
+				err = runTestMain(t, ctx, dir, env, "--background=#ffffff", "--theme=200", "hello-world.d2", "white.png")
+				assert.Success(t, err)
+				img, _, err = image.Decode(bytes.NewReader(readFile(t, dir, "white.png")))
+				assert.Success(t, err)
+				r, g, b, _ := img.At(0, 0).RGBA()
+				assert.Equal(t, [3]uint32{0xffff, 0xffff, 0xffff}, [3]uint32{r, g, b})
+			},
+		},
+		{
+			name: "png_native",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "hello-world.d2", `x -> y: hi`)
+				err := runTestMainPersist(t, ctx, dir, env, "hello-world.d2", "hello-world.svg")
+				assert.Success(t, err)
+				// Diagrams without markdown, icons and the like are drawn without a browser, at
+				// twice the size of their SVG.
+				err = runTestMain(t, ctx, dir, env, "--scale=1", "hello-world.d2", "hello-world.png")
+				assert.Success(t, err)
+				m := regexp.MustCompile(`width="(\d+)" height="(\d+)"`).FindSubmatch(readFile(t, dir, "hello-world.svg"))
+				cfg, _, err := image.DecodeConfig(bytes.NewReader(readFile(t, dir, "hello-world.png")))
+				assert.Success(t, err)
+				assert.Equal(t, string(m[1]), strconv.Itoa(cfg.Width/2))
+				assert.Equal(t, string(m[2]), strconv.Itoa(cfg.Height/2))
+			},
+		},
+		{
+			name: "raster_engine_native",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				// Markdown and tables would need a browser, which the native engine approximates.
+				writeFile(t, dir, "hello-world.d2", "md: |md\n  # hi\n|\nt: {shape: sql_table; id: int}\nmd -> t\nlayers: {l: {x -> y}}")
+				err := runTestMainPersist(t, ctx, dir, env, "--raster-engine=native", "hello-world.d2", "hello-world.png")
+				assert.Success(t, err)
+				_, _, err = image.Decode(bytes.NewReader(readFile(t, dir, "hello-world/index.png")))
+				assert.Success(t, err)
+				err = runTestMainPersist(t, ctx, dir, env, "--raster-engine=native", "--animate-interval=100", "hello-world.d2", "hello-world.gif")
+				assert.Success(t, err)
+				err = runTestMain(t, ctx, dir, env, "--raster-engine=chrome", "hello-world.d2", "hello-world.png")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --raster-engine must be auto, native or browser.
+You provided: chrome`)
+			},
+		},
+		{
+			name: "png_tiles",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "hello-world.d2", `x -> y`)
+				err := runTestMain(t, ctx, dir, env, "--png-tile-size=64", "hello-world.d2", "hello-world.png")
+				assert.Success(t, err)
+				var m struct {
+					Width   int `json:"width"`
+					Height  int `json:"height"`
+					Columns int `json:"columns"`
+					Rows    int `json:"rows"`
+				}
+				err = json.Unmarshal(readFile(t, dir, "hello-world/manifest.json"), &m)
+				assert.Success(t, err)
+				assert.Equal(t, (m.Width+63)/64, m.Columns)
+				assert.Equal(t, (m.Height+63)/64, m.Rows)
+				// tiles on the edges are as large as the others
+				cfg, _, err := image.DecodeConfig(bytes.NewReader(readFile(t, dir, fmt.Sprintf("hello-world/tiles/%d/%d.png", m.Columns-1, m.Rows-1))))
+				assert.Success(t, err)
+				assert.Equal(t, 64, cfg.Width)
+				assert.Equal(t, 64, cfg.Height)
+				assert.Equal(t, true, strings.Contains(string(readFile(t, dir, "hello-world/index.html")), "L.CRS.Simple"))
+			},
+		},
+		{
+			name: "png_size_usage",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "hello-world.d2", `x -> y`)
+				err := runTestMainPersist(t, ctx, dir, env, "--png-scale=0", "hello-world.d2", "hello-world.png")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --png-scale must be greater than 0.
+You provided: 0`)
+				err = runTestMainPersist(t, ctx, dir, env, "--width=-1", "hello-world.d2", "hello-world.png")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --width must be 0 or greater.
+You provided: -1`)
+				err = runTestMainPersist(t, ctx, dir, env, "--png-tile-size=-1", "hello-world.d2", "hello-world.png")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --png-tile-size must be 0 or greater.
+You provided: -1`)
+				err = runTestMain(t, ctx, dir, env, "--background=rainbow", "hello-world.d2", "hello-world.png")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --background must be theme, transparent, a named color or a hex code such as #f0ff3a.
+You provided: rainbow`)
+			},
+		},
+		{
+			name: "png_metadata",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "hello-world.d2", `x -> y`)
+				err := runTestMainPersist(t, ctx, dir, env, "--png-title=Hello", "--png-author=Ops", "--png-metadata-fields=team=infra,env=prod", "hello-world.d2", "meta.png")
+				assert.Success(t, err)
+				png := readFile(t, dir, "meta.png")
+				for _, s := range []string{"Hello", "Ops", "team\x00\x00\x00\x00\x00infra", "sha256:"} {
+					assert.Equal(t, true, bytes.Contains(png, []byte(s)))
+				}
+				err = runTestMainPersist(t, ctx, dir, env, "--png-metadata=false", "hello-world.d2", "bare.png")
+				assert.Success(t, err)
+				png = readFile(t, dir, "bare.png")
+				assert.Equal(t, false, bytes.Contains(png, []byte("eXIf")))
+				assert.Equal(t, false, bytes.Contains(png, []byte("iTXt")))
+				err = runTestMain(t, ctx, dir, env, "--png-metadata-fields=Title=x", "hello-world.d2", "hello-world.png")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --png-metadata-fields must be comma separated key=value pairs, with keys of at most 79 printable ASCII characters other than Title, Author and Source.
+You provided: Title=x`)
+			},
+		},
+		{
+			name: "crop",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "hello-world.d2", `a: {b -> c}; d -> a`)
+				err := runTestMainPersist(t, ctx, dir, env, "--pad=0", "--crop=10,20,100,50", "hello-world.d2", "coords.svg")
+				assert.Success(t, err)
+				assert.Equal(t, true, strings.Contains(string(readFile(t, dir, "coords.svg")), `width="100" height="50" viewBox="10 20 100 50"`))
+				err = runTestMainPersist(t, ctx, dir, env, "--crop=a.b", "hello-world.d2", "shape.png")
+				assert.Success(t, err)
+				cfg, _, err := image.DecodeConfig(bytes.NewReader(readFile(t, dir, "shape.png")))
+				assert.Success(t, err)
+				// a.b is padded by 100 on all sides
+				assert.Equal(t, true, cfg.Width > 400 && cfg.Width < 600)
+				err = runTestMainPersist(t, ctx, dir, env, "--crop=a.x", "hello-world.d2", "missing.svg")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: failed to compile hello-world.d2: --crop: the root board has no shape a.x`)
+				err = runTestMain(t, ctx, dir, env, "--crop=0,0,0,10", "hello-world.d2", "hello-world.svg")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --crop must be the ID of a shape or x,y,width,height with a width and height greater than 0.
+You provided: 0,0,0,10`)
+			},
+		},
+		{
+			name: "crop_usage",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "hello-world.d2", `x -> y`)
+				err := runTestMain(t, ctx, dir, env, "--crop=x", "hello-world.d2", "hello-world.pdf")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --crop can only be used when exporting to SVG or PNG.
+You provided: .pdf`)
+			},
+		},
 		{
 			name: "center",
 			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
@@ -177,6 +362,244 @@ steps: {
 				assert.Testdata(t, ".svg", svg)
 			},
 		},
+		{
+			name: "animation-transition",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "animation.d2", `a
+steps: {
+  1: {
+    b
+    transition: {
+      duration: 500
+      easing: ease-in
+    }
+  }
+  2: {
+    c
+    transition.interval: 2000
+  }
+}
+`)
+				err := runTestMainPersist(t, ctx, dir, env, "--animate-interval=1000", "--animate-transition-duration=100", "--animate-easing=linear", "animation.d2")
+				assert.Success(t, err)
+				svg := string(readFile(t, dir, "animation.svg"))
+				// The boards are shown for 1000ms, 1000ms and 2000ms. The first step fades in over
+				// 500ms, and the second inherits its transition.
+				assert.Equal(t, 3, strings.Count(svg, "4000ms infinite"))
+				assert.Equal(t, true, strings.Contains(svg, `0%, 12.500000% {
+				opacity: 0;
+				animation-timing-function: ease-in;
+		}
+		25.000000%, 37.500000% {`))
+				assert.Equal(t, true, strings.Contains(svg, `0%, 37.500000% {
+				opacity: 0;
+				animation-timing-function: ease-in;
+		}
+		50.000000%, 100.000000% {`))
+
+				err = runTestMainPersist(t, ctx, dir, env, "--animate-easing=bounce", "--animate-interval=1000", "animation.d2")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --animate-easing must be one of linear, ease, ease-in, ease-out, ease-in-out, step-start, step-end, or cubic-bezier(x1, y1, x2, y2).
+You provided: bounce`)
+				err = runTestMain(t, ctx, dir, env, "--animate-transition-duration=100", "animation.d2")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --animate-transition-duration and --animate-easing can only be used with --animate-interval`)
+			},
+		},
+		{
+			name: "animation-controls",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "animation.d2", `a
+steps: {
+  1: {
+    b
+  }
+  2: {
+    c
+  }
+}
+`)
+				err := runTestMainPersist(t, ctx, dir, env, "--animate-interval=1000", "animation.d2")
+				assert.Success(t, err)
+				svg := string(readFile(t, dir, "animation.svg"))
+				assert.Equal(t, false, strings.Contains(svg, "<script"))
+
+				err = runTestMainPersist(t, ctx, dir, env, "--animate-interval=1000", "--animate-controls", "animation.d2")
+				assert.Success(t, err)
+				svg = string(readFile(t, dir, "animation.svg"))
+				assert.Equal(t, 1, strings.Count(svg, `id="d2-controls-`))
+				for _, control := range []string{"back", "play", "forward", "track", "progress", "handle", "label"} {
+					assert.Equal(t, true, strings.Contains(svg, fmt.Sprintf(`data-d2-control="%s"`, control)))
+				}
+				assert.Equal(t, true, strings.Contains(svg, ">1 / 3</text>"))
+				assert.Equal(t, true, strings.Contains(svg, ", [0,1000,2000], 3000, "))
+
+				err = runTestMain(t, ctx, dir, env, "--animate-controls", "animation.d2")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --animate-controls can only be used with --animate-interval`)
+			},
+		},
+		{
+			name: "animation-morph",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "animation.d2", `a -> b
+steps: {
+  1: {
+    c -> a
+  }
+}
+`)
+				err := runTestMainPersist(t, ctx, dir, env, "--animate-interval=1000", "animation.d2")
+				assert.Success(t, err)
+				svg := string(readFile(t, dir, "animation.svg"))
+				assert.Equal(t, false, strings.Contains(svg, "d2Morph"))
+
+				err = runTestMainPersist(t, ctx, dir, env, "--animate-interval=1000", "--animate-morph", "animation.d2")
+				assert.Success(t, err)
+				svg = string(readFile(t, dir, "animation.svg"))
+				// a and b move down to make room for c, in both boards.
+				assert.Equal(t, true, strings.Contains(svg, `-board-0" style="animation: d2Transition-`))
+				assert.Equal(t, true, strings.Contains(svg, `-board-1" style="animation: d2Transition-`))
+				assert.Equal(t, 4, strings.Count(svg, "@keyframes d2Morph-"))
+				assert.Equal(t, true, strings.Contains(svg, `-board-0 [id="a"] {`))
+				assert.Equal(t, true, strings.Contains(svg, `-board-1 [id="b"] {`))
+				assert.Equal(t, false, strings.Contains(svg, `[id="c"]`))
+
+				err = runTestMain(t, ctx, dir, env, "--animate-morph", "animation.d2")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --animate-morph can only be used with --animate-interval`)
+			},
+		},
+		{
+			name: "animation-flow",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "animation.d2", `a -> b: {style.animated: true}
+steps: {
+  1: {
+    c
+  }
+}
+`)
+				err := runTestMainPersist(t, ctx, dir, env, "--animate-interval=1000", "animation.d2")
+				assert.Success(t, err)
+				svg := string(readFile(t, dir, "animation.svg"))
+				// The flow of a -> b runs on the same clock in both boards, so it does not
+				// restart when the boards change.
+				flows := regexp.MustCompile(`animation: dashdraw [^;]*;`).FindAllString(svg, -1)
+				assert.Equal(t, 2, len(flows))
+				assert.Equal(t, flows[0], flows[1])
+				assert.Equal(t, 1, strings.Count(svg, "@keyframes dashdraw"))
+			},
+		},
+		{
+			name: "animation-playback",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "animation.d2", `a
+steps: {
+  1: {
+    b
+  }
+}
+`)
+				err := runTestMainPersist(t, ctx, dir, env, "--animate-interval=1000", "--animate-loops=1", "--animate-direction=alternate", "--animate-delay=300", "animation.d2")
+				assert.Success(t, err)
+				svg := string(readFile(t, dir, "animation.svg"))
+				assert.Equal(t, 2, strings.Count(svg, `2000ms 300ms 1 alternate both"`))
+				assert.Equal(t, false, strings.Contains(svg, "d2AnimationAutoplay"))
+
+				err = runTestMainPersist(t, ctx, dir, env, "--animate-interval=1000", "--animate-autoplay=visible", "animation.d2")
+				assert.Success(t, err)
+				svg = string(readFile(t, dir, "animation.svg"))
+				assert.Equal(t, 2, strings.Count(svg, `2000ms infinite"`))
+				assert.Equal(t, true, strings.Contains(svg, "d2AnimationAutoplay("))
+
+				err = runTestMainPersist(t, ctx, dir, env, "--animate-interval=1000", "--animate-direction=backwards", "animation.d2")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --animate-direction must be normal or alternate.
+You provided: backwards`)
+
+				err = runTestMain(t, ctx, dir, env, "--animate-loops=1", "animation.d2")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --animate-loops, --animate-direction, --animate-delay and --animate-autoplay can only be used with --animate-interval`)
+			},
+		},
+		{
+			name: "scroll-html",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "walkthrough.d2", `description: The client calls the API
+client -> api
+api.link: layers.db
+layers: {
+  db: {
+    description: The API stores orders in Postgres
+    api -> db
+  }
+}
+`)
+				err := runTestMain(t, ctx, dir, env, "walkthrough.d2", "walkthrough.html")
+				assert.Success(t, err)
+				page := string(readFile(t, dir, "walkthrough.html"))
+				assert.Equal(t, true, strings.HasPrefix(page, "<!DOCTYPE html>"))
+				assert.Equal(t, 2, strings.Count(page, `<g class="d2-scroll-board`))
+				assert.Equal(t, true, strings.Contains(page, `<p>The client calls the API</p>`))
+				assert.Equal(t, true, strings.Contains(page, `<h2>db</h2><p>The API stores orders in Postgres</p>`))
+				// The link to the layer scrolls to its section.
+				assert.Equal(t, true, strings.Contains(page, `-board-1" class="d2-scroll-section" data-d2-board="1">`))
+				assert.Equal(t, true, regexp.MustCompile(`href="#d2-[0-9]+-board-1"`).MatchString(page))
+				_, err = os.Stat(filepath.Join(dir, "walkthrough"))
+				assert.Equal(t, true, os.IsNotExist(err))
+			},
+		},
+		{
+			name: "json",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "hello.d2", `x -> y
+x.link: layers.db
+layers: {
+  db: {
+    z
+  }
+}
+`)
+				err := runTestMain(t, ctx, dir, env, "hello.d2", "hello.json")
+				assert.Success(t, err)
+				diagram, err := d2target.Unmarshal(readFile(t, dir, "hello.json"))
+				assert.Success(t, err)
+				assert.Equal(t, 2, len(diagram.Shapes))
+				assert.Equal(t, 1, len(diagram.Connections))
+				assert.Equal(t, true, diagram.Shapes[0].Width > 0)
+				// Links stay board paths, as the boards are within the document.
+				assert.Equal(t, "root.layers.db", diagram.Shapes[0].Link)
+				assert.Equal(t, 1, len(diagram.Layers))
+				assert.Equal(t, "z", diagram.Layers[0].Shapes[0].ID)
+				_, err = os.Stat(filepath.Join(dir, "hello"))
+				assert.Equal(t, true, os.IsNotExist(err))
+			},
+		},
+		{
+			name: "animation-frames",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "animation.d2", `a
+steps: {
+  1: {
+    b
+  }
+}
+`)
+				err := runTestMainPersist(t, ctx, dir, env, "--animate-interval=1000", "--animate-frames=frames/frame-%02d.svg", "animation.d2")
+				assert.Success(t, err)
+				// Frames are standalone SVGs of each board, not fragments of the animation.
+				frame1 := string(readFile(t, dir, "frames/frame-01.svg"))
+				frame2 := string(readFile(t, dir, "frames/frame-02.svg"))
+				assert.Equal(t, true, strings.HasPrefix(frame1, "<?xml"))
+				assert.Equal(t, false, strings.Contains(frame1, ">b</text>"))
+				assert.Equal(t, true, strings.Contains(frame2, ">b</text>"))
+				assert.Equal(t, false, strings.Contains(frame2, "d2Transition"))
+				_, err = os.Stat(filepath.Join(dir, "frames/frame-03.svg"))
+				assert.Equal(t, true, os.IsNotExist(err))
+
+				err = runTestMainPersist(t, ctx, dir, env, "--animate-interval=1000", "--animate-frames=frames/frame.svg", "animation.d2")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --animate-frames must contain 1 verb formatting the frame number, e.g. %d or %02d.
+You provided: frames/frame.svg`)
+
+				err = runTestMain(t, ctx, dir, env, "--animate-frames=frames/frame-%d.svg", "animation.d2")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --animate-frames can only be used with --animate-interval`)
+			},
+		},
 		{
 			name: "linked-path",
 			// TODO tempdir is random, resulting in different test results each time with the links
@@ -510,6 +933,55 @@ scenarios: {
 				assert.TestdataDir(t, filepath.Join(dir, "life"))
 			},
 		},
+		{
+			name: "multiboard/single_file",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "life.d2", `x -> y
+x.link: layers.core
+layers: {
+  core: {
+    belief
+    belief.link: _
+  }
+}
+scenarios: {
+  why: {
+    y -> x
+  }
+}
+`)
+				err := runTestMainPersist(t, ctx, dir, env, "life.d2", "-")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: failed to compile life.d2: multiboard output cannot be written to stdout, unless composed into 1 SVG with --single-file`)
+
+				stdout := &bytes.Buffer{}
+				tms := testMain(dir, env, "--single-file", "life.d2", "-")
+				tms.Stdout = stdout
+				tms.Start(t, ctx)
+				defer tms.Cleanup(t)
+				err = tms.Wait(ctx)
+				assert.Success(t, err)
+
+				svg := stdout.String()
+				// The root board is written last to be shown unless another board is targeted.
+				assert.Equal(t, 3, strings.Count(svg, `class="d2-board"`))
+				ids := regexp.MustCompile(`<g id="([^"]+)" class="d2-board">`).FindAllStringSubmatch(svg, -1)
+				assert.Equal(t, 3, len(ids))
+				root, core := ids[2][1], ids[0][1]
+				assert.Equal(t, true, strings.HasSuffix(root, "-board-0"))
+				assert.Equal(t, true, strings.Contains(svg, fmt.Sprintf(`.d2-board:target ~ #%s {`, root)))
+				// Boards are hidden rather than not displayed, so animated connections keep flowing.
+				assert.Equal(t, true, strings.Contains(svg, ".d2-board {\n\tvisibility: hidden;\n}"))
+				assert.Equal(t, true, strings.Contains(svg, `.d2-board:not(:target):not(#`+root+`) [style*="animation:d2-"]`))
+				assert.Equal(t, true, strings.Contains(svg, fmt.Sprintf(`<a href="#%s"`, core)))
+				assert.Equal(t, true, strings.Contains(svg, fmt.Sprintf(`<a href="#%s"`, root)))
+				_, err = os.Stat(filepath.Join(dir, "life"))
+				assert.Equal(t, true, os.IsNotExist(err))
+
+				err = runTestMain(t, ctx, dir, env, "--single-file", "life.d2", "life.png")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --single-file can only be used when exporting to SVG.
+You provided: .png`)
+			},
+		},
 		{
 			name: "multiboard/life_index_d2",
 			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
@@ -565,6 +1037,14 @@ layers: {
 				testdataIgnoreDiff(t, ".pdf", pdf)
 			},
 		},
+		{
+			name: "pptx-editable",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "x.d2", `x -> y`)
+				err := runTestMain(t, ctx, dir, env, "--pptx-editable", "--pptx-raster", "x.d2", "x.pptx")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --pptx-editable cannot be combined with --pptx-raster`)
+			},
+		},
 		{
 			name: "export_ppt",
 			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
@@ -880,6 +1360,83 @@ layers: {
 				assert.Equal(t, "x -> y\n", string(gotBar))
 			},
 		},
+		{
+			name: "fix",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "fix.d2", `x.shape: cirle
+x.style.fil: red
+y.near: z
+direction: rigth
+`)
+				err := runTestMainPersist(t, ctx, dir, env, "fix", "fix.d2")
+				assert.Success(t, err)
+				got := readFile(t, dir, "fix.d2")
+				assert.Equal(t, `x.shape: circle
+x.style.fill: red
+y.near: z
+direction: right
+z
+`, string(got))
+			},
+		},
+		{
+			name: "fix-unfixable",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "fix.d2", `x.shape: cirle
+y.shape: star
+`)
+				err := runTestMainPersist(t, ctx, dir, env, "fix", "fix.d2")
+				assert.Error(t, err)
+				assert.True(t, strings.Contains(err.Error(), `fix.d2:2:10: unknown shape "star"`))
+				got := readFile(t, dir, "fix.d2")
+				assert.Equal(t, `x.shape: circle
+y.shape: star
+`, string(got))
+			},
+		},
+		{
+			name: "validate",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "ok.d2", `...@shapes`)
+				writeFile(t, dir, "bad.d2", `x.shape: star
+y.style.opacity: 4
+`)
+				writeFile(t, dir, "shapes.d2", `z.shape: hexagon`)
+
+				validate := func(args ...string) (string, error) {
+					stdout := &bytes.Buffer{}
+					tms := testMain(dir, env, append([]string{"validate"}, args...)...)
+					tms.Stdout = stdout
+					tms.Start(t, ctx)
+					defer tms.Cleanup(t)
+					err := tms.Wait(ctx)
+					return stdout.String(), err
+				}
+
+				out, err := validate("ok.d2")
+				assert.Success(t, err)
+				assert.Equal(t, "", out)
+
+				out, err = validate("ok.d2", "bad.d2")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: failed to validate: 1 of 2 files are invalid`)
+				assert.Equal(t, `bad.d2:1:10: unknown shape "star"
+bad.d2:2:18: expected "opacity" to be a number between 0.0 and 1.0
+`, out)
+
+				out, err = validate("--format=json", "bad.d2", "missing.d2")
+				assert.Error(t, err)
+				var diags []map[string]any
+				assert.Success(t, json.Unmarshal([]byte(out), &diags))
+				assert.Equal(t, 3, len(diags))
+				assert.Equal(t, "bad.d2", diags[0]["path"])
+				assert.Equal(t, 1., diags[0]["line"])
+				assert.Equal(t, 10., diags[0]["column"])
+				assert.Equal(t, 14., diags[0]["endColumn"])
+				assert.Equal(t, `unknown shape "star"`, diags[0]["message"])
+				assert.Equal(t, "missing.d2", diags[2]["path"])
+				assert.Equal(t, nil, diags[2]["line"])
+			},
+		},
 		{
 			name:   "watch-regular",
 			serial: true,
@@ -1113,6 +1670,874 @@ c
 				assert.Success(t, err)
 			},
 		},
+		{
+			name:   "watch-icon-file",
+			serial: true,
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "a.d2", `
+x.icon: icon.svg
+`)
+				writeFile(t, dir, "icon.svg", `<svg xmlns="http://www.w3.org/2000/svg"></svg>`)
+				stderr := &stderrWrapper{}
+				tms := testMain(dir, env, "--watch", "--browser=0", "a.d2")
+				tms.Stderr = stderr
+
+				tms.Start(t, ctx)
+				defer func() {
+					err := tms.Signal(ctx, os.Interrupt)
+					assert.Success(t, err)
+				}()
+
+				doneRE := regexp.MustCompile(`successfully compiled a.d2`)
+				_, err := waitLogs(ctx, stderr, doneRE)
+				assert.Success(t, err)
+				stderr.Reset()
+
+				// Test that writing a local icon will cause recompilation
+				writeFile(t, dir, "icon.svg", `<svg xmlns="http://www.w3.org/2000/svg"><rect/></svg>`)
+				iconRE := regexp.MustCompile(`detected change in icon.svg`)
+				_, err = waitLogs(ctx, stderr, iconRE)
+				assert.Success(t, err)
+				_, err = waitLogs(ctx, stderr, doneRE)
+				assert.Success(t, err)
+			},
+		},
+		{
+			name:   "watch-multiple-outputs",
+			serial: true,
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "a.d2", `x -> y`)
+				stderr := &stderrWrapper{}
+				tms := testMain(dir, env, "--watch", "--browser=0", "a.d2", "a.svg", "out/a.html")
+				tms.Stderr = stderr
+
+				tms.Start(t, ctx)
+				defer func() {
+					err := tms.Signal(ctx, os.Interrupt)
+					assert.Success(t, err)
+				}()
+
+				doneRE := regexp.MustCompile(`successfully compiled a.d2 to out/a.html`)
+				_, err := waitLogs(ctx, stderr, doneRE)
+				assert.Success(t, err)
+				stderr.Reset()
+
+				writeFile(t, dir, "a.d2", `x -> renamed`)
+				_, err = waitLogs(ctx, stderr, doneRE)
+				assert.Success(t, err)
+				assert.Equal(t, true, strings.Contains(string(readFile(t, dir, "a.svg")), "renamed"))
+				assert.Equal(t, true, strings.Contains(string(readFile(t, dir, "out/a.html")), "renamed"))
+			},
+		},
+		{
+			name: "plugin",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				if runtime.GOOS == "windows" {
+					t.Skip("plugin is a shell script")
+				}
+				writeFile(t, dir, "fake/d2plugin-fake", `#!/bin/sh
+case "$1" in
+  info) echo '{"name": "fake", "shortHelp": "fake layout"}' ;;
+  flags) echo '[]' ;;
+esac
+`)
+				err := os.Chmod(filepath.Join(dir, "fake/d2plugin-fake"), 0755)
+				assert.Success(t, err)
+				env.Setenv("D2_CONFIG_DIR", filepath.Join(dir, "config"))
+
+				list := func(args ...string) string {
+					stdout := &bytes.Buffer{}
+					tms := testMain(dir, env, append(args, "plugin", "list")...)
+					tms.Stdout = stdout
+					tms.Start(t, ctx)
+					defer tms.Cleanup(t)
+					err := tms.Wait(ctx)
+					assert.Success(t, err)
+					return stdout.String()
+				}
+				fakeLine := regexp.MustCompile(`(?m)^fake \(binary\) (.*)$`)
+
+				assert.Equal(t, false, fakeLine.MatchString(list()))
+				m := fakeLine.FindStringSubmatch(list("--plugin-path", "fake"))
+				assert.Equal(t, 2, len(m))
+				assert.Equal(t, filepath.Join(dir, "fake/d2plugin-fake"), m[1])
+
+				writeFile(t, dir, "config/config.d2", `plugins: [../fake]`)
+				assert.Equal(t, true, fakeLine.MatchString(list()))
+				assert.Remove(t, filepath.Join(dir, "config/config.d2"))
+
+				err = runTestMainPersist(t, ctx, dir, env, "plugin", "install", "fake/d2plugin-fake")
+				assert.Success(t, err)
+				m = fakeLine.FindStringSubmatch(list())
+				assert.Equal(t, 2, len(m))
+				assert.Equal(t, filepath.Join(dir, "config/plugins/d2plugin-fake"), m[1])
+
+				err = runTestMainPersist(t, ctx, dir, env, "plugin", "remove", "fake")
+				assert.Success(t, err)
+				assert.Equal(t, false, fakeLine.MatchString(list()))
+				err = runTestMainPersist(t, ctx, dir, env, "plugin", "remove", "fake")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: plugin "fake" is not installed in `+filepath.Join(dir, "config/plugins"))
+			},
+		},
+		{
+			name: "playwright_use",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				env.Setenv("D2_CONFIG_DIR", filepath.Join(dir, "config"))
+				writeFile(t, dir, "config/config.d2", `plugins: [~/d2/plugins]`)
+				writeFile(t, dir, "chrome", ``)
+
+				err := runTestMainPersist(t, ctx, dir, env, "playwright", "use", "chrome")
+				assert.Success(t, err)
+				assert.Equal(t, "plugins: [~/d2/plugins]\nbrowser: "+filepath.Join(dir, "chrome")+"\n", string(readFile(t, dir, "config/config.d2")))
+
+				stdout := &bytes.Buffer{}
+				tms := testMain(dir, env, "playwright", "status")
+				tms.Stdout = stdout
+				tms.Start(t, ctx)
+				defer tms.Cleanup(t)
+				err = tms.Wait(ctx)
+				assert.Success(t, err)
+				assert.Equal(t, true, strings.Contains(stdout.String(), "browser: "+filepath.Join(dir, "chrome")+" (set with d2 playwright use)"))
+
+				err = runTestMainPersist(t, ctx, dir, env, "playwright", "use", "default")
+				assert.Success(t, err)
+				assert.Equal(t, "plugins: [~/d2/plugins]\n", string(readFile(t, dir, "config/config.d2")))
+				err = runTestMainPersist(t, ctx, dir, env, "playwright", "use", "config")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: playwright use must be passed the path of a Chromium, Chrome or Edge executable, not a directory.
+You provided: `+filepath.Join(dir, "config"))
+				err = runTestMainPersist(t, ctx, dir, env, "playwright", "uninstall")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: unknown playwright subcommand "uninstall", expected one of install, status, clean or use`)
+			},
+		},
+		{
+			name: "plugin-protocol",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				if runtime.GOOS == "windows" {
+					t.Skip("plugin is a shell script")
+				}
+				// Answers with the version d2 speaks but returns graphs of a newer schema.
+				writeFile(t, dir, "plugins/d2plugin-current", `#!/bin/sh
+case "$1" in
+  info) echo "{\"name\": \"current\", \"protocol\": $D2_PLUGIN_PROTOCOL}" ;;
+  flags) echo '[]' ;;
+  layout) sed 's/"version":1/"version":2/' ;;
+esac
+`)
+				writeFile(t, dir, "plugins/d2plugin-future", `#!/bin/sh
+case "$1" in
+  info) echo '{"name": "future", "protocol": 99}' ;;
+  flags) echo '[]' ;;
+  layout) cat ;;
+esac
+`)
+				for _, name := range []string{"current", "future"} {
+					err := os.Chmod(filepath.Join(dir, "plugins", "d2plugin-"+name), 0755)
+					assert.Success(t, err)
+				}
+				writeFile(t, dir, "in.d2", `x`)
+
+				err := runTestMainPersist(t, ctx, dir, env, "--plugin-path", "plugins", "--layout", "current", "in.d2")
+				assert.Error(t, err)
+				assert.Equal(t, true, strings.Contains(err.Error(), "graph schema version 2 is newer than the supported version 1"))
+
+				err = runTestMainPersist(t, ctx, dir, env, "--plugin-path", "plugins", "--layout", "future", "in.d2")
+				assert.Error(t, err)
+				assert.Equal(t, true, strings.Contains(err.Error(), "plugin future speaks protocol version 99 but d2 "+version.Version+" supports up to version 1, upgrade d2"))
+			},
+		},
+		{
+			name: "plugin-renderer",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				if runtime.GOOS == "windows" {
+					t.Skip("plugin is a shell script")
+				}
+				// Renders the IDs of the shapes of the diagram it is passed.
+				writeFile(t, dir, "plugins/d2plugin-ids", `#!/bin/sh
+case "$1" in
+  info) echo '{"name": "ids", "features": ["renders"], "formats": ["ids"]}' ;;
+  flags) echo '[]' ;;
+  render) grep -o '"id":"[^"]*"' ;;
+esac
+`)
+				err := os.Chmod(filepath.Join(dir, "plugins/d2plugin-ids"), 0755)
+				assert.Success(t, err)
+				writeFile(t, dir, "in.d2", `x -> y`)
+
+				err = runTestMainPersist(t, ctx, dir, env, "--plugin-path", "plugins", "in.d2", "out.ids")
+				assert.Success(t, err)
+				assert.Equal(t, true, strings.Contains(string(readFile(t, dir, "out.ids")), `"id":"x"`))
+				assert.Equal(t, true, strings.Contains(string(readFile(t, dir, "out.ids")), `"id":"y"`))
+			},
+		},
+		{
+			name: "plugin-import-resolver",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				if runtime.GOOS == "windows" {
+					t.Skip("plugin is a shell script")
+				}
+				writeFile(t, dir, "plugins/d2plugin-acme", `#!/bin/sh
+case "$1" in
+  info) echo '{"name": "acme", "features": ["resolves_imports"], "schemes": ["acme"]}' ;;
+  flags) echo '[]' ;;
+  resolveimport)
+    case "$2" in
+      acme:shared/styles.d2) echo 'classes: {db: {label: from-acme}}' ;;
+      *) echo "$2 not found" >&2; exit 1 ;;
+    esac ;;
+esac
+`)
+				err := os.Chmod(filepath.Join(dir, "plugins/d2plugin-acme"), 0755)
+				assert.Success(t, err)
+				writeFile(t, dir, "in.d2", `...@"acme:shared/styles"
+x.class: db
+`)
+				err = runTestMainPersist(t, ctx, dir, env, "--plugin-path", "plugins", "in.d2")
+				assert.Success(t, err)
+				assert.Equal(t, true, strings.Contains(string(readFile(t, dir, "in.svg")), "from-acme"))
+
+				writeFile(t, dir, "missing.d2", `...@"acme:shared/missing"`)
+				err = runTestMainPersist(t, ctx, dir, env, "--plugin-path", "plugins", "missing.d2")
+				assert.Error(t, err)
+				assert.Equal(t, true, strings.Contains(err.Error(), "acme:shared/missing.d2 not found"))
+
+				writeFile(t, dir, "unknown.d2", `...@"other:styles"`)
+				err = runTestMainPersist(t, ctx, dir, env, "--plugin-path", "plugins", "unknown.d2")
+				assert.Error(t, err)
+				assert.Equal(t, true, strings.Contains(err.Error(), "no plugin resolves imports with the scheme other"))
+			},
+		},
+		{
+			name: "plugin-sandbox",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				if runtime.GOOS == "windows" {
+					t.Skip("plugin is a shell script")
+				}
+				// Renders its environment and working directory.
+				writeFile(t, dir, "plugins/d2plugin-env", `#!/bin/sh
+case "$1" in
+  info) echo '{"name": "env", "features": ["renders"], "formats": ["env"]}' ;;
+  flags) echo '[]' ;;
+  render) env; echo "pwd=$(pwd)"; echo "exe=$0" ;;
+esac
+`)
+				err := os.Chmod(filepath.Join(dir, "plugins/d2plugin-env"), 0755)
+				assert.Success(t, err)
+				writeFile(t, dir, "in.d2", `x`)
+				env.Setenv("D2_CONFIG_DIR", filepath.Join(dir, "config"))
+
+				err = runTestMainPersist(t, ctx, dir, env, "--plugin-path", "plugins", "--plugin-sandbox", "in.d2", "out.env")
+				assert.Success(t, err)
+				out := string(readFile(t, dir, "out.env"))
+				pwd := regexp.MustCompile(`(?m)^pwd=(.*)$`).FindStringSubmatch(out)
+				assert.Equal(t, 2, len(pwd))
+				assert.Equal(t, true, strings.Contains(pwd[1], "d2plugin-sandbox-"))
+				assert.Equal(t, true, strings.Contains(out, "HOME="+pwd[1]+"\n"))
+				assert.Equal(t, true, strings.Contains(out, "D2_PLUGIN_PROTOCOL=1\n"))
+				_, err = os.Stat(pwd[1])
+				assert.Equal(t, true, os.IsNotExist(err))
+
+				checksum, err := d2plugin.Checksum(filepath.Join(dir, "plugins/d2plugin-env"))
+				assert.Success(t, err)
+				writeFile(t, dir, "config/config.d2", `plugin-checksums: {
+  env: sha256:`+checksum+`
+}`)
+				err = runTestMainPersist(t, ctx, dir, env, "--plugin-path", "plugins", "in.d2", "out.env")
+				assert.Success(t, err)
+				// Pinned plugins run from a verified copy, removed once they exit.
+				exe := regexp.MustCompile(`(?m)^exe=(.*)$`).FindStringSubmatch(string(readFile(t, dir, "out.env")))
+				assert.Equal(t, 2, len(exe))
+				assert.Equal(t, true, strings.Contains(exe[1], "d2plugin-verified-"))
+				_, err = os.Stat(exe[1])
+				assert.Equal(t, true, os.IsNotExist(err))
+
+				writeFile(t, dir, "config/config.d2", `plugin-checksums: {
+  env: sha256:0000
+}`)
+				err = runTestMainPersist(t, ctx, dir, env, "--plugin-path", "plugins", "in.d2", "out.env")
+				assert.Error(t, err)
+				assert.Equal(t, true, strings.Contains(err.Error(), "checksum mismatch for "+filepath.Join(dir, "plugins/d2plugin-env")+": pinned sha256:0000 but the file has sha256:"+checksum))
+			},
+		},
+		{
+			name: "plugin-icon-provider",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				if runtime.GOOS == "windows" {
+					t.Skip("plugin is a shell script")
+				}
+				writeFile(t, dir, "plugins/d2plugin-corp", `#!/bin/sh
+case "$1" in
+  info) echo '{"name": "corp", "features": ["provides_icons"], "iconSchemes": ["corp"]}' ;;
+  flags) echo '[]' ;;
+  icon)
+    case "$2" in
+      corp://payments/logo) echo '<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"></svg>' ;;
+      *) echo "$2 not found" >&2; exit 1 ;;
+    esac ;;
+esac
+`)
+				err := os.Chmod(filepath.Join(dir, "plugins/d2plugin-corp"), 0755)
+				assert.Success(t, err)
+				writeFile(t, dir, "in.d2", `payments.icon: corp://payments/logo`)
+
+				err = runTestMainPersist(t, ctx, dir, env, "--plugin-path", "plugins", "in.d2")
+				assert.Success(t, err)
+				svg := string(readFile(t, dir, "in.svg"))
+				assert.Equal(t, false, strings.Contains(svg, "corp://payments/logo"))
+				assert.Equal(t, true, strings.Contains(svg, `href="data:image/svg+xml`))
+
+				writeFile(t, dir, "missing.d2", `payments.icon: corp://payments/missing`)
+				err = runTestMainPersist(t, ctx, dir, env, "--plugin-path", "plugins", "missing.d2")
+				assert.Error(t, err)
+				assert.Equal(t, true, strings.Contains(err.Error(), "corp://payments/missing not found"))
+			},
+		},
+		{
+			name:   "daemon",
+			serial: true,
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				if runtime.GOOS == "windows" {
+					t.Skip("daemon is not supported on windows")
+				}
+				writeFile(t, dir, "hello-world.d2", `x -> y`)
+
+				// Unix socket paths are limited to around 100 bytes.
+				sockDir, err := os.MkdirTemp("", "d2")
+				assert.Success(t, err)
+				defer os.RemoveAll(sockDir)
+				env.Setenv("D2_DAEMON_SOCKET", filepath.Join(sockDir, "d.sock"))
+
+				stderr := &stderrWrapper{}
+				tms := testMain(dir, env, "daemon")
+				tms.Stderr = stderr
+				tms.Start(t, ctx)
+
+				_, err = waitLogs(ctx, stderr, regexp.MustCompile(`listening on`))
+				assert.Success(t, err)
+
+				err = runTestMainPersist(t, ctx, dir, env, "--daemon", "hello-world.d2", "daemon.svg")
+				assert.Success(t, err)
+				err = runTestMainPersist(t, ctx, dir, env, "hello-world.d2", "local.svg")
+				assert.Success(t, err)
+				assert.Equal(t, string(readFile(t, dir, "local.svg")), string(readFile(t, dir, "daemon.svg")))
+
+				// Errors are relayed with their exit code.
+				writeFile(t, dir, "bad.d2", `x -> `)
+				err = runTestMain(t, ctx, dir, env, "--daemon", "bad.d2")
+				assert.Error(t, err)
+
+				err = runTestMain(t, ctx, dir, env, "daemon", "stop")
+				assert.Success(t, err)
+				err = tms.Wait(ctx)
+				assert.Success(t, err)
+			},
+		},
+		{
+			name: "layout-budget",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "budget.d2", `a -> b -> c
+a -> c`)
+				stderr := &stderrWrapper{}
+				tms := testMain(dir, env, "--layout-budget=1ns", "budget.d2")
+				tms.Stderr = stderr
+				tms.Start(t, ctx)
+				defer tms.Cleanup(t)
+				err := tms.Wait(ctx)
+				assert.Success(t, err)
+				assert.Equal(t, true, strings.Contains(stderr.Read(), "layout took longer than its budget of 1ns, falling back to a cheaper configuration"))
+				svg := readFile(t, dir, "budget.svg")
+				assert.Testdata(t, ".svg", svg)
+
+				err = runTestMain(t, ctx, dir, env, "--layout-budget=soon", "budget.d2")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: invalid --layout-budget: time: invalid duration "soon"`)
+			},
+		},
+		{
+			name: "timings",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "timings.d2", `a -> b
+layers: {
+  x: {
+    c -> d
+  }
+}`)
+				stderr := &stderrWrapper{}
+				tms := testMain(dir, env, "--timings", "timings.d2")
+				tms.Stderr = stderr
+				tms.Start(t, ctx)
+				defer tms.Cleanup(t)
+				err := tms.Wait(ctx)
+				assert.Success(t, err)
+				assert.True(t, regexp.MustCompile(`(?m)^board +measure +layout +export +render +raster$`).MatchString(stderr.Read()))
+				assert.True(t, regexp.MustCompile(`(?m)^root +[0-9.]+ms +[0-9.]+ms +[0-9.]+ms +[0-9.]+ms +-$`).MatchString(stderr.Read()))
+				assert.True(t, regexp.MustCompile(`(?m)^root\.layers\.x +[0-9.]+ms +[0-9.]+ms +[0-9.]+ms +[0-9.]+ms +-$`).MatchString(stderr.Read()))
+				assert.True(t, regexp.MustCompile(`(?m)^parse: [0-9.]+ms, total: [0-9.]+ms$`).MatchString(stderr.Read()))
+			},
+		},
+		{
+			name: "profile",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "profile.d2", `a -> b`)
+				err := runTestMain(t, ctx, dir, env, "--profile=cpu=cpu.pprof,mem", "profile.d2")
+				assert.Success(t, err)
+				assert.True(t, len(readFile(t, dir, "cpu.pprof")) > 0)
+				assert.True(t, len(readFile(t, dir, "d2.mem.pprof")) > 0)
+
+				err = runTestMain(t, ctx, dir, env, "--profile=gpu", "profile.d2")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: unknown --profile "gpu": expected cpu, mem or trace`)
+			},
+		},
+		{
+			name: "img-fetch-options",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				var mu sync.Mutex
+				requests := 0
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					mu.Lock()
+					requests++
+					n := requests
+					mu.Unlock()
+					if n == 1 {
+						w.WriteHeader(http.StatusServiceUnavailable)
+						return
+					}
+					w.Header().Set("Content-Type", "image/svg+xml")
+					w.Write([]byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`))
+				}))
+				defer srv.Close()
+
+				writeFile(t, dir, "icons.d2", fmt.Sprintf(`a.icon: %[1]s/logo.svg
+layers: {
+  x: {
+    b.icon: %[1]s/logo.svg
+  }
+}`, srv.URL))
+				err := runTestMain(t, ctx, dir, env, "--img-cache=false", "--img-retries=1", "--img-retry-backoff=1", "icons.d2")
+				assert.Success(t, err)
+				// The image of both boards is fetched once, after a retry.
+				mu.Lock()
+				assert.Equal(t, 2, requests)
+				mu.Unlock()
+				for _, board := range []string{"icons/index.svg", "icons/x.svg"} {
+					svg := readFile(t, dir, board)
+					assert.Equal(t, false, strings.Contains(string(svg), srv.URL))
+				}
+
+				err = runTestMain(t, ctx, dir, env, "--img-concurrency=0", "icons.d2")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --img-concurrency must be positive`)
+			},
+		},
+		{
+			name: "theme-file",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "in.d2", `x -> y`)
+				writeFile(t, dir, "acme.json", `{
+  "id": 1001,
+  "name": "Acme",
+  "colors": {
+    "neutrals": {"n1": "#0A0F25", "n2": "#676C7E", "n3": "#9499AB", "n4": "#CFD2DD", "n5": "#DEE1EB", "n6": "#EEF1F8", "n7": "#FFFFFF"},
+    "b1": "#AC1DE5", "b2": "#AC1DE5", "b3": "#E5D1F2", "b4": "#E5D1F2", "b5": "#F2E8F9", "b6": "#FAF5FD",
+    "aa2": "#6B1DE5", "aa4": "#F2E8F9", "aa5": "#FAF5FD",
+    "ab4": "#F2E8F9", "ab5": "#FAF5FD"
+  }
+}`)
+				err := runTestMainPersist(t, ctx, dir, env, "--theme-file=acme.json", "in.d2", "json.svg")
+				assert.Success(t, err)
+				assert.Equal(t, true, strings.Contains(string(readFile(t, dir, "json.svg")), "#AC1DE5"))
+
+				writeFile(t, dir, "acme.d2", `id: 1002
+name: Acme D2
+colors: {
+  neutrals: {n1: "#0A0F25"; n2: "#676C7E"; n3: "#9499AB"; n4: "#CFD2DD"; n5: "#DEE1EB"; n6: "#EEF1F8"; n7: "#FFFFFF"}
+  b1: "#1DE5AC"; b2: "#1DE5AC"; b3: "#D1F2E5"; b4: "#D1F2E5"; b5: "#E8F9F2"; b6: "#F5FDFA"
+  aa2: "#1D6BE5"; aa4: "#E8F9F2"; aa5: "#F5FDFA"
+  ab4: "#E8F9F2"; ab5: "#F5FDFA"
+}
+`)
+				err = runTestMainPersist(t, ctx, dir, env, "--theme-file=acme.d2", "in.d2", "d2.svg")
+				assert.Success(t, err)
+				assert.Equal(t, true, strings.Contains(string(readFile(t, dir, "d2.svg")), "#1DE5AC"))
+
+				writeFile(t, dir, "partial.json", `{"id": 1003, "name": "Partial", "colors": {"b1": "#AC1DE5"}}`)
+				err = runTestMain(t, ctx, dir, env, "--theme-file=partial.json", "in.d2", "partial.svg")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: failed to load theme file partial.json: invalid theme "Partial": missing color N1`)
+			},
+		},
+		{
+			name: "img-headers",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.Header.Get("Authorization") != "Bearer secret" {
+						w.WriteHeader(http.StatusUnauthorized)
+						return
+					}
+					w.Header().Set("Content-Type", "image/svg+xml")
+					w.Write([]byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`))
+				}))
+				defer srv.Close()
+
+				writeFile(t, dir, "icons.d2", fmt.Sprintf(`a.icon: %s/private/logo.svg`, srv.URL))
+				env.Setenv("D2_CONFIG_DIR", filepath.Join(dir, "config"))
+				err := runTestMain(t, ctx, dir, env, "--img-cache=false", "icons.d2")
+				assert.Error(t, err)
+
+				writeFile(t, dir, "config/config.d2", fmt.Sprintf(`image-headers: {
+  "%s/private/": {
+    Authorization: 'Bearer ${ICONS_TOKEN}'
+  }
+}`, srv.URL))
+				env.Setenv("ICONS_TOKEN", "secret")
+				err = runTestMain(t, ctx, dir, env, "--img-cache=false", "icons.d2")
+				assert.Success(t, err)
+				svg := readFile(t, dir, "icons.svg")
+				assert.Equal(t, false, strings.Contains(string(svg), srv.URL))
+			},
+		},
+		{
+			name: "img-relative-paths",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "project/index.d2", `a: @sub/a
+b.icon: b.svg
+`)
+				writeFile(t, dir, "project/sub/a.d2", `icon: a.svg`)
+				writeFile(t, dir, "project/sub/a.svg", `<svg xmlns="http://www.w3.org/2000/svg"><title>a</title></svg>`)
+				writeFile(t, dir, "project/b.svg", `<svg xmlns="http://www.w3.org/2000/svg"><title>b</title></svg>`)
+				writeFile(t, dir, "assets/b.svg", `<svg xmlns="http://www.w3.org/2000/svg"><title>assets</title></svg>`)
+				writeFile(t, dir, "assets/sub/a.svg", `<svg xmlns="http://www.w3.org/2000/svg"><title>assets</title></svg>`)
+
+				err := runTestMainPersist(t, ctx, dir, env, "project/index.d2", "out.svg")
+				assert.Success(t, err)
+				svg := string(readFile(t, dir, "out.svg"))
+				assert.Equal(t, true, strings.Contains(svg, base64.StdEncoding.EncodeToString([]byte(`<svg xmlns="http://www.w3.org/2000/svg"><title>a</title></svg>`))))
+				assert.Equal(t, true, strings.Contains(svg, base64.StdEncoding.EncodeToString([]byte(`<svg xmlns="http://www.w3.org/2000/svg"><title>b</title></svg>`))))
+
+				err = runTestMain(t, ctx, dir, env, "--img-root=assets", "project/index.d2", "out.svg")
+				assert.Success(t, err)
+				svg = string(readFile(t, dir, "out.svg"))
+				// Both icons resolve to the same image, embedded once.
+				assert.Equal(t, 1, strings.Count(svg, base64.StdEncoding.EncodeToString([]byte(`<svg xmlns="http://www.w3.org/2000/svg"><title>assets</title></svg>`))))
+				assert.Equal(t, 2, strings.Count(svg, `<use href="#d2-image-`))
+			},
+		},
+		{
+			name: "img-size-limits",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "logo.svg", `<svg xmlns="http://www.w3.org/2000/svg">`+strings.Repeat(" ", 2048)+`</svg>`)
+				writeFile(t, dir, "icons.d2", `a.icon: logo.svg`)
+				err := runTestMainPersist(t, ctx, dir, env, "--img-warn-size=1", "icons.d2")
+				assert.Success(t, err)
+
+				err = runTestMain(t, ctx, dir, env, "--img-max-size=1", "icons.d2")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: failed to compile icons.d2: failed to bundle local images: [logo.svg]; stderr: err: failed to bundle logo.svg: image is 2.0 KB, above the maximum of 1.0 KB
+`)
+			},
+		},
+		{
+			name: "img-data-urls",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "data.d2", `a.icon: "data:image/svg+xml,%3Csvg xmlns='http://www.w3.org/2000/svg'%3E%3Ctitle%3Ea%3C/title%3E%3C/svg%3E"
+layers: {
+  x: {
+    b.icon: "data:image/svg+xml,%3Csvg xmlns='http://www.w3.org/2000/svg'%3E%3Ctitle%3Ea%3C/title%3E%3C/svg%3E"
+    c: {
+      shape: image
+      icon: "data:image/svg+xml,%3Csvg xmlns='http://www.w3.org/2000/svg'%3E%3Ctitle%3Ea%3C/title%3E%3C/svg%3E"
+    }
+  }
+}
+`)
+				err := runTestMainPersist(t, ctx, dir, env, "--animate-interval=1000", "data.d2", "data.svg")
+				assert.Success(t, err)
+				svg := string(readFile(t, dir, "data.svg"))
+				assert.Equal(t, 1, strings.Count(svg, base64.StdEncoding.EncodeToString([]byte(`<svg xmlns="http://www.w3.org/2000/svg"><title>a</title></svg>`))))
+				assert.Equal(t, 3, strings.Count(svg, `<use href="#d2-image-`))
+				assert.Equal(t, false, strings.Contains(svg, "data:image/svg+xml,"))
+			},
+		},
+		{
+			name: "img-report",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "logo.svg", `<svg xmlns="http://www.w3.org/2000/svg"></svg>`)
+				writeFile(t, dir, "icons.d2", `a.icon: logo.svg
+b.icon: missing.svg
+c.icon: https://icons.terrastruct.com/essentials/004-picture.svg
+`)
+				err := runTestMainPersist(t, ctx, dir, env, "--bundle=false", "--img-report=report.json", "icons.d2", "icons.svg")
+				assert.Error(t, err)
+				assert.Equal(t, true, strings.Contains(err.Error(), "failed to bundle local images: [missing.svg]"))
+
+				// The missing image is drawn as a placeholder instead.
+				svg := string(readFile(t, dir, "icons.svg"))
+				assert.Equal(t, false, strings.Contains(svg, `href="missing.svg"`))
+				assert.Equal(t, 2, strings.Count(svg, `data:image/svg+xml;base64,`))
+
+				var report struct {
+					Images []struct {
+						Href   string `json:"href"`
+						Status string `json:"status"`
+						Error  string `json:"error"`
+					} `json:"images"`
+				}
+				err = json.Unmarshal(readFile(t, dir, "report.json"), &report)
+				assert.Success(t, err)
+				assert.Equal(t, 3, len(report.Images))
+				assert.Equal(t, "https://icons.terrastruct.com/essentials/004-picture.svg", report.Images[0].Href)
+				assert.Equal(t, "skipped", report.Images[0].Status)
+				assert.Equal(t, "logo.svg", report.Images[1].Href)
+				assert.Equal(t, "bundled", report.Images[1].Status)
+				assert.Equal(t, "missing.svg", report.Images[2].Href)
+				assert.Equal(t, "failed", report.Images[2].Status)
+				assert.Equal(t, true, report.Images[2].Error != "")
+			},
+		},
+		{
+			name: "offline",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				if runtime.GOOS == "windows" {
+					t.Skip("plugin is a shell script")
+				}
+				writeFile(t, dir, "plugins/d2plugin-acme", fmt.Sprintf(`#!/bin/sh
+case "$1" in
+  info) echo '{"name": "acme", "features": ["resolves_imports"], "schemes": ["acme"]}' ;;
+  flags) echo '[]' ;;
+  resolveimport)
+    if [ -e '%s' ]; then echo "acme is unreachable" >&2; exit 1; fi
+    echo 'classes: {db: {label: from-acme}}' ;;
+esac
+`, filepath.Join(dir, "unreachable")))
+				err := os.Chmod(filepath.Join(dir, "plugins/d2plugin-acme"), 0755)
+				assert.Success(t, err)
+				writeFile(t, dir, "in.d2", `...@"acme:shared/styles"
+x.class: db
+`)
+				err = runTestMainPersist(t, ctx, dir, env, "--plugin-path", "plugins", "in.d2")
+				assert.Success(t, err)
+
+				// Imports resolved before are read from the cache offline.
+				writeFile(t, dir, "unreachable", "")
+				err = runTestMainPersist(t, ctx, dir, env, "--plugin-path", "plugins", "in.d2")
+				assert.Error(t, err)
+				assert.Equal(t, true, strings.Contains(err.Error(), "acme is unreachable"))
+				assert.Remove(t, filepath.Join(dir, "in.svg"))
+				err = runTestMainPersist(t, ctx, dir, env, "--plugin-path", "plugins", "--offline", "in.d2")
+				assert.Success(t, err)
+				assert.Equal(t, true, strings.Contains(string(readFile(t, dir, "in.svg")), "from-acme"))
+
+				writeFile(t, dir, "other.d2", `...@"acme:shared/other"`)
+				err = runTestMainPersist(t, ctx, dir, env, "--plugin-path", "plugins", "--offline", "other.d2")
+				assert.Error(t, err)
+				assert.Equal(t, true, strings.Contains(err.Error(), "acme:shared/other.d2: not cached, so cannot be resolved offline"))
+			},
+		},
+		{
+			name: "pdf-page-size",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "in.d2", `direction: right
+a -> b -> c -> d -> e -> f -> g -> h -> i -> j -> k -> l -> m -> n -> o -> p
+`)
+				err := runTestMainPersist(t, ctx, dir, env, "--pdf-page-size=A4", "in.d2", "fit.pdf")
+				assert.Success(t, err)
+				pdf := readFile(t, dir, "fit.pdf")
+				assert.Equal(t, true, bytes.Contains(pdf, []byte("/Count 1")))
+				// Wide boards are laid on landscape pages.
+				assert.Equal(t, true, bytes.Contains(pdf, []byte("/MediaBox [0 0 841.89 595.28]")))
+
+				err = runTestMainPersist(t, ctx, dir, env, "--pdf-page-size=letter", "--pdf-orientation=portrait", "--pdf-tile", "in.d2", "tile.pdf")
+				assert.Success(t, err)
+				pdf = readFile(t, dir, "tile.pdf")
+				assert.Equal(t, false, bytes.Contains(pdf, []byte("/Count 1\n")))
+				assert.Equal(t, true, bytes.Contains(pdf, []byte("/MediaBox [0 0 612.00 792.00]")))
+
+				err = runTestMain(t, ctx, dir, env, "--pdf-page-size=A5", "in.d2", "out.pdf")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --pdf-page-size must be A4, Letter or auto.
+You provided: a5`)
+			},
+		},
+		{
+			name: "pdf-toc",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "in.d2", `a -> b
+layers: {
+  x: {c -> d}
+  y: {
+    scenarios: {
+      s1: {e}
+    }
+  }
+}
+`)
+				err := runTestMain(t, ctx, dir, env, "--pdf-toc", "in.d2", "out.pdf")
+				assert.Success(t, err)
+				pdf := readFile(t, dir, "out.pdf")
+				// The table of contents and the 3 boards with pages of their own.
+				assert.Equal(t, true, bytes.Contains(pdf, []byte("/Count 4")))
+				assert.Equal(t, true, bytes.Contains(pdf, []byte("/Outlines")))
+				// The folder only board y is in the outline.
+				assert.Equal(t, true, bytes.Contains(pdf, []byte("/Title (\xfe\xff\x00y)")))
+				assert.Equal(t, false, bytes.Contains(pdf, []byte("{d2page")))
+			},
+		},
+		{
+			name: "pdf-metadata",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "in.d2", `a -> b`)
+				err := runTestMainPersist(t, ctx, dir, env, "--pdf-title=Architecture & Co", "--pdf-xmp", "in.d2", "meta.pdf")
+				assert.Success(t, err)
+				pdf := readFile(t, dir, "meta.pdf")
+				assert.Equal(t, true, bytes.Contains(pdf, []byte("<dc:title><rdf:Alt><rdf:li xml:lang=\"x-default\">Architecture &amp; Co</rdf:li></rdf:Alt></dc:title>")))
+				assert.Equal(t, false, bytes.Contains(pdf, []byte("/Encrypt")))
+
+				env.Setenv("D2_PDF_USER_PASSWORD", "secret")
+				err = runTestMainPersist(t, ctx, dir, env, "--pdf-permissions=print,copy", "in.d2", "encrypted.pdf")
+				assert.Success(t, err)
+				pdf = readFile(t, dir, "encrypted.pdf")
+				assert.Equal(t, true, bytes.Contains(pdf, []byte("/Encrypt")))
+
+				err = runTestMainPersist(t, ctx, dir, env, "--pdf-permissions=print,fax", "in.d2", "out.pdf")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --pdf-permissions must be a comma separated list of annotate, copy, modify, print, or none.
+You provided: print,fax`)
+
+				err = runTestMain(t, ctx, dir, env, "--pdf-standard=pdfa-2b", "in.d2", "archive.pdf")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: PDF/A exports cannot be encrypted with --pdf-user-password or --pdf-owner-password`)
+			},
+		},
+		{
+			name: "pdf-merge",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "payments.d2", `a -> b
+layers: {
+  x: {c -> d}
+}
+`)
+				writeFile(t, dir, "billing.d2", `label: Billing
+e -> f
+`)
+				err := runTestMainPersist(t, ctx, dir, env, "-o", "handbook.pdf", "--pdf-toc", "payments.d2", "billing.d2")
+				assert.Success(t, err)
+				pdf := readFile(t, dir, "handbook.pdf")
+				// The table of contents and the 3 boards of the inputs.
+				assert.Equal(t, true, bytes.Contains(pdf, []byte("/Count 4")))
+				assert.Equal(t, true, bytes.Contains(pdf, []byte("/Title (\xfe\xff\x00p\x00a\x00y\x00m\x00e\x00n\x00t\x00s)")))
+				assert.Equal(t, true, bytes.Contains(pdf, []byte("/Title (\xfe\xff\x00B\x00i\x00l\x00l\x00i\x00n\x00g)")))
+				assert.Equal(t, false, bytes.Contains(pdf, []byte("{d2page")))
+
+				err = runTestMain(t, ctx, dir, env, "-o", "handbook.svg", "payments.d2", "billing.d2")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: several inputs can only be combined into a PDF.
+You provided: .svg`)
+			},
+		},
+		{
+			name: "pptx-template",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "corp.d2", `logo`)
+				err := runTestMainPersist(t, ctx, dir, env, "corp.d2", "corp.pptx")
+				assert.Success(t, err)
+				writeFile(t, dir, "in.d2", `a -> b`)
+				err = runTestMainPersist(t, ctx, dir, env, "--pptx-template", filepath.Join(dir, "corp.pptx"), "in.d2", "out.pptx")
+				assert.Success(t, err)
+				file := readFile(t, dir, "out.pptx")
+				err = pptx.Validate(file, 1)
+				assert.Success(t, err)
+
+				err = runTestMain(t, ctx, dir, env, "--pptx-template", "corp.key", "in.d2", "out.pptx")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: failed to load PPTX template: expected .pptx or .potx file but corp.key has extension .key`)
+			},
+		},
+		{
+			name: "pptx-notes",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "in.d2", `description: Start with the happy path
+a -> b: {tooltip: Retried 3 times}
+layers: {
+  x: {c}
+}
+`)
+				err := runTestMain(t, ctx, dir, env, "in.d2", "out.pptx")
+				assert.Success(t, err)
+				file := readFile(t, dir, "out.pptx")
+				err = pptx.Validate(file, 2)
+				assert.Success(t, err)
+				assert.Equal(t, true, bytes.Contains(file, []byte("ppt/notesSlides/notesSlide1.xml")))
+				assert.Equal(t, false, bytes.Contains(file, []byte("ppt/notesSlides/notesSlide2.xml")))
+			},
+		},
+		{
+			name: "pptx-slide-size",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "in.d2", `a -> b`)
+				err := runTestMainPersist(t, ctx, dir, env, "--pptx-slide-size", "13.33x7.5", "--pptx-scaling", "fill", "in.d2", "out.pptx")
+				assert.Success(t, err)
+				file := readFile(t, dir, "out.pptx")
+				err = pptx.Validate(file, 1)
+				assert.Success(t, err)
+
+				err = runTestMainPersist(t, ctx, dir, env, "--pptx-slide-size", "A4", "in.d2", "out.pptx")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --pptx-slide-size must be 16:9, 4:3, a size in inches between 1 and 56 such as 13.33x7.5, or auto.
+You provided: A4`)
+				err = runTestMain(t, ctx, dir, env, "--pptx-scaling", "stretch", "in.d2", "out.pptx")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --pptx-scaling must be fit, fill or actual.
+You provided: stretch`)
+			},
+		},
+		{
+			name: "pptx-steps",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "in.d2", `a
+steps: {
+  1: {b}
+  2: {a -> b}
+  3: {c}
+}
+`)
+				err := runTestMainPersist(t, ctx, dir, env, "in.d2", "out.pptx")
+				assert.Success(t, err)
+				err = pptx.Validate(readFile(t, dir, "out.pptx"), 4)
+				assert.Success(t, err)
+
+				err = runTestMainPersist(t, ctx, dir, env, "--pptx-steps", "animate", "in.d2", "out.pptx")
+				assert.Success(t, err)
+				file := readFile(t, dir, "out.pptx")
+				err = pptx.Validate(file, 2)
+				assert.Success(t, err)
+
+				err = runTestMain(t, ctx, dir, env, "--pptx-steps", "fade", "in.d2", "out.pptx")
+				assert.ErrorString(t, err, `failed to wait xmain test: e2etests-cli/d2: bad usage: --pptx-steps must be slides or animate.
+You provided: fade`)
+			},
+		},
+		{
+			name: "pptx-title-slides",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "in.d2", `x.link: layers.b
+layers: {
+  a: {y}
+  b: {z}
+}
+`)
+				writeFile(t, dir, "one.d2", `x`)
+				err := runTestMainPersist(t, ctx, dir, env, "--pptx-title-slides", "in.d2", "out.pptx")
+				assert.Success(t, err)
+				file := readFile(t, dir, "out.pptx")
+				// title, root, section, a, section, b
+				err = pptx.Validate(file, 6)
+				assert.Success(t, err)
+
+				err = runTestMain(t, ctx, dir, env, "--pptx-title-slides", "one.d2", "one.pptx")
+				assert.Success(t, err)
+				err = pptx.Validate(readFile(t, dir, "one.pptx"), 1)
+				assert.Success(t, err)
+			},
+		},
+		{
+			name: "pptx-tooltip-callouts",
+			run: func(t *testing.T, ctx context.Context, dir string, env *xos.Env) {
+				writeFile(t, dir, "in.d2", `a: Checkout {tooltip: Where orders begin}
+a -> b`)
+				err := runTestMain(t, ctx, dir, env, "--pptx-tooltip-callouts", "in.d2", "out.pptx")
+				assert.Success(t, err)
+				err = pptx.Validate(readFile(t, dir, "out.pptx"), 1)
+				assert.Success(t, err)
+			},
+		},
 	}
 
 	ctx := context.Background()
@@ -1137,6 +2562,8 @@ c
 			defer cleanup()
 
 			env := xos.NewEnv(nil)
+			// Keeps remote images and imports cached by tests out of the user cache.
+			env.Setenv("D2_CACHE_DIR", t.TempDir())
 
 			tc.run(t, ctx, dir, env)
 		})
@@ -1,9 +1,10 @@
 module oss.terrastruct.com/d2
 
-go 1.22
+go 1.22.0
 
 require (
 	cdr.dev/slog v1.4.2-0.20221206192828-e4803b10ae17
+	git.sr.ht/~sbinet/gg v0.5.0
 	github.com/PuerkitoBio/goquery v1.8.1
 	github.com/alecthomas/chroma/v2 v2.5.0
 	github.com/dop251/goja v0.0.0-20231027120936-b396bb4c349d
@@ -19,6 +20,7 @@ require (
 	github.com/rivo/uniseg v0.4.4
 	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.8.4
+	github.com/tetratelabs/wazero v1.9.0
 	github.com/yuin/goldmark v1.6.0
 	go.uber.org/multierr v1.11.0
 	golang.org/x/image v0.14.0
@@ -34,6 +36,7 @@ require (
 require (
 	github.com/alecthomas/chroma v0.10.0 // indirect
 	github.com/andybalholm/cascadia v1.3.2 // indirect
+	github.com/campoy/embedmd v1.0.0 // indirect
 	github.com/danwakefield/fnmatch v0.0.0-20160403171240-cbb64ac3d964 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/dlclark/regexp2 v1.10.0 // indirect
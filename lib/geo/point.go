@@ -54,6 +54,29 @@ func (p *Point) Copy() *Point {
 
 type Points []*Point
 
+// NewPoints returns n points packed in one allocation rather than one allocation per point,
+// for routes and other paths of many points.
+func NewPoints(n int) Points {
+	buf := make([]Point, n)
+	ps := make(Points, n)
+	for i := range buf {
+		ps[i] = &buf[i]
+	}
+	return ps
+}
+
+// Copy returns a deep copy of ps, packed like NewPoints.
+func (ps Points) Copy() Points {
+	if ps == nil {
+		return nil
+	}
+	cp := NewPoints(len(ps))
+	for i, p := range ps {
+		*cp[i] = *p
+	}
+	return cp
+}
+
 func (ps Points) Equals(other Points) bool {
 	if ps == nil {
 		return other == nil
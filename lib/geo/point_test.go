@@ -55,3 +55,19 @@ func TestVectorTo(t *testing.T) {
 		t.Fatalf("Expected Vector to be (3.5, 2.3), got %v", c)
 	}
 }
+
+func TestPointsCopy(t *testing.T) {
+	ps := NewPoints(3)
+	for i, p := range ps {
+		p.X = float64(i)
+	}
+	cp := ps.Copy()
+	if !cp.Equals(ps) {
+		t.Fatalf("Expected copy %v to equal %v", cp.ToString(), ps.ToString())
+	}
+
+	cp[0].X = 10
+	if ps[0].X != 0 {
+		t.Fatalf("Expected copy to not share points with the original, got %v", ps.ToString())
+	}
+}
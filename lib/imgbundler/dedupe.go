@@ -0,0 +1,61 @@
+package imgbundler
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+)
+
+var dataImageRegex = regexp.MustCompile(`<image href="(data:[^"]+)"([^>]*?)\s*/>`)
+
+var svgTagRegex = regexp.MustCompile(`<svg[^>]*>`)
+
+// Dedupe returns svg with the data URL images embedded more than once in it defined once,
+// in the defs of its first svg element, and referenced everywhere else. Diagrams with
+// several boards that repeat the same icon shrink by the size of every repetition.
+func Dedupe(svg []byte) []byte {
+	counts := make(map[string]int)
+	for _, m := range dataImageRegex.FindAllSubmatch(svg, -1) {
+		counts[string(m[1])]++
+	}
+
+	ids := make(map[string]string)
+	used := make(map[string]bool)
+	var defs bytes.Buffer
+	out := dataImageRegex.ReplaceAllFunc(svg, func(img []byte) []byte {
+		m := dataImageRegex.FindSubmatch(img)
+		href := string(m[1])
+		if counts[href] < 2 {
+			return img
+		}
+		id, ok := ids[href]
+		if !ok {
+			h := fnv.New32a()
+			h.Write(m[1])
+			id = fmt.Sprintf("d2-image-%x", h.Sum32())
+			for i := 1; used[id]; i++ {
+				id = fmt.Sprintf("d2-image-%x-%d", h.Sum32(), i)
+			}
+			used[id] = true
+			ids[href] = id
+			fmt.Fprintf(&defs, `<symbol id="%s"><image href="%s" width="100%%" height="100%%" /></symbol>`, id, href)
+		}
+		return []byte(fmt.Sprintf(`<use href="#%s"%s />`, id, m[2]))
+	})
+	if defs.Len() == 0 {
+		return svg
+	}
+
+	loc := svgTagRegex.FindIndex(out)
+	if loc == nil {
+		return svg
+	}
+	deduped := make([]byte, 0, len(out)+defs.Len()+len("<defs></defs>"))
+	deduped = append(deduped, out[:loc[1]]...)
+	deduped = append(deduped, "<defs>"...)
+	deduped = append(deduped, defs.Bytes()...)
+	deduped = append(deduped, "</defs>"...)
+	deduped = append(deduped, out[loc[1]:]...)
+	return deduped
+}
@@ -0,0 +1,105 @@
+package imgbundler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"oss.terrastruct.com/d2/lib/simplelog"
+)
+
+// remoteImage is a fetched remote image, as cached on disk.
+type remoteImage struct {
+	body []byte
+
+	URL          string `json:"url"`
+	ContentType  string `json:"contentType"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// cachedImagePath returns the path in dir of the image cached for href, without extension.
+// The body is stored next to its metadata in a .json.
+func cachedImagePath(dir, href string) string {
+	h := sha256.Sum256([]byte(href))
+	return filepath.Join(dir, hex.EncodeToString(h[:]))
+}
+
+// readCachedImage returns the image cached in dir for href, nil if there is none. An
+// unreadable cache is only a cache miss.
+func readCachedImage(l simplelog.Logger, dir, href string) *remoteImage {
+	if dir == "" {
+		return nil
+	}
+	p := cachedImagePath(dir, href)
+	meta, err := os.ReadFile(p + ".json")
+	if err != nil {
+		if !os.IsNotExist(err) {
+			l.Debug(fmt.Sprintf("failed to read cached %s: %v", href, err))
+		}
+		return nil
+	}
+	var img remoteImage
+	err = json.Unmarshal(meta, &img)
+	if err != nil || img.URL != href {
+		l.Debug(fmt.Sprintf("ignoring invalid cache entry of %s", href))
+		return nil
+	}
+	img.body, err = os.ReadFile(p)
+	if err != nil {
+		l.Debug(fmt.Sprintf("failed to read cached %s: %v", href, err))
+		return nil
+	}
+	return &img
+}
+
+// writeCachedImage caches img in dir. Failing to is only logged, as the image was fetched.
+func writeCachedImage(l simplelog.Logger, dir string, img *remoteImage) {
+	if dir == "" {
+		return
+	}
+	err := writeCachedImageFiles(dir, img)
+	if err != nil {
+		l.Debug(fmt.Sprintf("failed to cache %s: %v", img.URL, err))
+	}
+}
+
+func writeCachedImageFiles(dir string, img *remoteImage) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	meta, err := json.Marshal(img)
+	if err != nil {
+		return err
+	}
+	p := cachedImagePath(dir, img.URL)
+	// The body is written first so that the metadata never refers to a partial body, and
+	// both are renamed into place so that concurrent processes only read complete files.
+	err = writeFileAtomic(p, img.body)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p+".json", meta)
+}
+
+func writeFileAtomic(p string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
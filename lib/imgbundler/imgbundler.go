@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"html"
 	"io/ioutil"
@@ -30,6 +31,218 @@ const maxImageSize int64 = 1 << 25 // 33_554_432
 
 var imageRegex = regexp.MustCompile(`<image href="([^"]+)"`)
 
+// IconProvider returns the bytes of the image at href, a URL with a scheme it is
+// registered for, e.g. corp://payments/logo.
+type IconProvider func(ctx context.Context, href string) ([]byte, error)
+
+type iconProvidersKey struct{}
+
+// WithIconProviders returns a context under which images with the URL schemes of providers
+// are bundled locally with the provider of their scheme, e.g. from a private icon
+// registry.
+func WithIconProviders(ctx context.Context, providers map[string]IconProvider) context.Context {
+	if len(providers) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, iconProvidersKey{}, providers)
+}
+
+// iconProvider returns the provider of href under ctx, if any.
+func iconProvider(ctx context.Context, href string) IconProvider {
+	providers, _ := ctx.Value(iconProvidersKey{}).(map[string]IconProvider)
+	if providers == nil {
+		return nil
+	}
+	u, err := url.Parse(href)
+	if err != nil {
+		return nil
+	}
+	return providers[strings.ToLower(u.Scheme)]
+}
+
+// FetchOptions configures how remote images are fetched.
+type FetchOptions struct {
+	// Concurrency is the maximum number of images fetched at once, across all the SVGs
+	// bundled under the context of the options. Defaults to 16.
+	Concurrency int
+	// Timeout is the maximum time a request for an image may take. Defaults to a minute.
+	Timeout time.Duration
+	// Retries is the number of times a request is retried after a network error or a 429
+	// or 5xx response.
+	Retries int
+	// Backoff is how long to wait before the first retry, doubled on every following one.
+	// Defaults to 500ms.
+	Backoff time.Duration
+	// CacheDir is the directory remote images are cached in across processes. Cached images
+	// are revalidated with their ETag or modification time when fetched, and used as they are
+	// if that fails. Remote images are not cached on disk if empty.
+	CacheDir string
+	// Offline bundles remote images from CacheDir only, failing on those not cached, rather
+	// than fetching them.
+	Offline bool
+	// Headers are the headers sent with the requests for the images whose URLs match their
+	// patterns, e.g. the Authorization of an artifact registry. Every rule that matches a
+	// URL applies, later ones overriding the headers of earlier ones.
+	Headers []HeaderRule
+
+	sema chan struct{}
+}
+
+// HeaderRule sets headers on the requests for the remote images whose URLs match Pattern.
+type HeaderRule struct {
+	// Pattern matches the URLs that start with it, with * matching any characters, e.g.
+	// https://*.corp.example.com/icons/.
+	Pattern string
+	Header  http.Header
+}
+
+// Match returns whether the rule applies to href.
+func (r HeaderRule) Match(href string) bool {
+	return matchPrefix(r.Pattern, href)
+}
+
+// matchPrefix returns whether s starts with pattern, with * matching any characters.
+func matchPrefix(pattern, s string) bool {
+	prefix, rest, wildcard := strings.Cut(pattern, "*")
+	if !strings.HasPrefix(s, prefix) {
+		return false
+	}
+	if !wildcard {
+		return true
+	}
+	s = s[len(prefix):]
+	for i := 0; i <= len(s); i++ {
+		if matchPrefix(rest, s[i:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// header returns the headers of the rules of opts that match href.
+func (opts *FetchOptions) header(href string) http.Header {
+	h := make(http.Header)
+	for _, r := range opts.Headers {
+		if !r.Match(href) {
+			continue
+		}
+		for k, v := range r.Header {
+			h[http.CanonicalHeaderKey(k)] = v
+		}
+	}
+	return h
+}
+
+type fetchOptionsKey struct{}
+
+// WithFetchOptions returns a context under which remote images are fetched with opts.
+func WithFetchOptions(ctx context.Context, opts FetchOptions) context.Context {
+	return context.WithValue(ctx, fetchOptionsKey{}, newFetchOptions(opts))
+}
+
+func newFetchOptions(opts FetchOptions) *FetchOptions {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 16
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = time.Minute
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = time.Millisecond * 500
+	}
+	opts.sema = make(chan struct{}, opts.Concurrency)
+	return &opts
+}
+
+// fetchOptions returns the fetch options of ctx. Without any, every bundle fetches up to
+// 16 images at once.
+func fetchOptions(ctx context.Context) *FetchOptions {
+	if opts, ok := ctx.Value(fetchOptionsKey{}).(*FetchOptions); ok {
+		return opts
+	}
+	return newFetchOptions(FetchOptions{})
+}
+
+type readFilesKey struct{}
+
+// WithReadFiles returns a context under which read is passed the path of every local image
+// read successfully, e.g. for watch mode to re-render when one changes. read may be called
+// concurrently.
+func WithReadFiles(ctx context.Context, read func(path string)) context.Context {
+	return context.WithValue(ctx, readFilesKey{}, read)
+}
+
+type imageRootKey struct{}
+
+// WithImageRoot returns a context under which the relative paths of local images are
+// resolved against the directory root rather than that of the input, so that diagrams
+// render the same whatever directory they're in.
+func WithImageRoot(ctx context.Context, root string) context.Context {
+	if root == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, imageRootKey{}, root)
+}
+
+// bundleCache holds the images bundled under a context, by href, so that SVGs bundled
+// concurrently under it, e.g. the boards of a diagram, wait on the same fetch of an image
+// rather than fetching it again.
+type bundleCache struct {
+	mu      sync.Mutex
+	bundles map[bundleKey]*bundled
+}
+
+type bundleKey struct {
+	href string
+	// Relative local images differ between inputs.
+	inputPath string
+}
+
+type bundled struct {
+	done chan struct{}
+	out  []byte
+	err  error
+}
+
+type bundleCacheKey struct{}
+
+// WithBundleCache returns a context under which every image is only fetched or read once,
+// however many SVGs are bundled under it. Unlike the cacheImages argument of the bundle
+// functions, the images are only cached for as long as the context is used, e.g. the
+// export of one diagram and its boards.
+func WithBundleCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bundleCacheKey{}, &bundleCache{
+		bundles: make(map[bundleKey]*bundled),
+	})
+}
+
+// do returns the bundle of href, calling bundle if it isn't already in c or being
+// bundled. c may be nil.
+func (c *bundleCache) do(ctx context.Context, key bundleKey, bundle func() ([]byte, error)) ([]byte, error) {
+	if c == nil {
+		return bundle()
+	}
+	c.mu.Lock()
+	b, ok := c.bundles[key]
+	if !ok {
+		b = &bundled{done: make(chan struct{})}
+		c.bundles[key] = b
+	}
+	c.mu.Unlock()
+	if ok {
+		select {
+		case <-b.done:
+			return b.out, b.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	b.out, b.err = bundle()
+	close(b.done)
+	return b.out, b.err
+}
+
 func BundleLocal(ctx context.Context, l simplelog.Logger, inputPath string, in []byte, cacheImages bool) ([]byte, error) {
 	return bundle(ctx, l, inputPath, in, false, cacheImages)
 }
@@ -50,7 +263,7 @@ func bundle(ctx context.Context, l simplelog.Logger, inputPath string, svg []byt
 		defer xdefer.Errorf(&err, "failed to bundle local images")
 	}
 	imgs := imageRegex.FindAllSubmatch(svg, -1)
-	imgs = filterImageElements(imgs, isRemote)
+	imgs = filterImageElements(ctx, imgs, isRemote)
 
 	if len(imgs) == 0 {
 		return svg, nil
@@ -63,8 +276,9 @@ func bundle(ctx context.Context, l simplelog.Logger, inputPath string, svg []byt
 }
 
 // filterImageElements finds all unique image elements in imgs that are
-// eligible for bundling in the current context.
-func filterImageElements(imgs [][][]byte, isRemote bool) [][][]byte {
+// eligible for bundling in the current context. Images of icon providers and data URLs are
+// local.
+func filterImageElements(ctx context.Context, imgs [][][]byte, isRemote bool) [][][]byte {
 	unq := make(map[string]struct{})
 	imgs2 := imgs[:0]
 	for _, img := range imgs {
@@ -74,16 +288,14 @@ func filterImageElements(imgs [][][]byte, isRemote bool) [][][]byte {
 		}
 		unq[href] = struct{}{}
 
-		// Skip already bundled images.
-		if strings.HasPrefix(href, "data:") {
-			continue
-		}
-
 		u, err := url.Parse(html.UnescapeString(href))
-		isRemoteImg := err == nil && strings.HasPrefix(u.Scheme, "http")
+		isRemoteImg := err == nil && strings.HasPrefix(u.Scheme, "http") && iconProvider(ctx, html.UnescapeString(href)) == nil
 
 		if isRemoteImg == isRemote {
 			imgs2 = append(imgs2, img)
+		} else if !strings.HasPrefix(href, "data:") {
+			// Data URLs left to the remote pass were bundled or failed by the local pass.
+			record(ctx, img[1], ImageSkipped, nil)
 		}
 	}
 	return imgs2
@@ -99,8 +311,8 @@ func runWorkers(ctx context.Context, l simplelog.Logger, inputPath string, svg [
 		close(replc)
 	}()
 
-	// Limits the number of workers to 16.
-	sema := make(chan struct{}, 16)
+	// Limits the number of workers to the concurrency of the fetch options.
+	sema := fetchOptions(ctx).sema
 
 	var errhrefsMu sync.Mutex
 	var errhrefs []string
@@ -118,11 +330,14 @@ func runWorkers(ctx context.Context, l simplelog.Logger, inputPath string, svg [
 
 				bundledImage, err := worker(ctx, l, inputPath, img[1], isRemote, cacheImages)
 				if err != nil {
-					l.Error(fmt.Sprintf("failed to bundle %s: %v", img[1], err))
+					l.Error(fmt.Sprintf("failed to bundle %s: %v", displayHref(img[1]), err))
 					errhrefsMu.Lock()
-					errhrefs = append(errhrefs, string(img[1]))
+					errhrefs = append(errhrefs, displayHref(img[1]))
 					errhrefsMu.Unlock()
-					return
+					record(ctx, img[1], ImageFailed, err)
+					bundledImage = placeholder(img[1])
+				} else {
+					record(ctx, img[1], ImageBundled, nil)
 				}
 				select {
 				case <-ctx.Done():
@@ -156,6 +371,52 @@ func runWorkers(ctx context.Context, l simplelog.Logger, inputPath string, svg [
 }
 
 func worker(ctx context.Context, l simplelog.Logger, inputPath string, href []byte, isRemote, cacheImages bool) ([]byte, error) {
+	c, _ := ctx.Value(bundleCacheKey{}).(*bundleCache)
+	key := bundleKey{href: string(href)}
+	if !isRemote {
+		key.inputPath = inputPath
+	}
+	return c.do(ctx, key, func() ([]byte, error) {
+		return bundleImage(ctx, l, inputPath, href, isRemote, cacheImages)
+	})
+}
+
+// Image returns the data and mime type of the image at href as it's bundled, e.g. for
+// renderers that draw images themselves. Relative paths are relative to inputPath.
+func Image(ctx context.Context, l simplelog.Logger, inputPath, href string, cacheImages bool) (_ []byte, mimeType string, err error) {
+	defer xdefer.Errorf(&err, "failed to bundle %s", href)
+
+	u, err := url.Parse(href)
+	isRemote := err == nil && strings.HasPrefix(u.Scheme, "http") && iconProvider(ctx, href) == nil
+	if isRemote {
+		inputPath = ""
+	}
+	img, err := worker(ctx, l, inputPath, []byte(html.EscapeString(href)), isRemote, cacheImages)
+	if err != nil {
+		record(ctx, []byte(html.EscapeString(href)), ImageFailed, err)
+		return nil, "", err
+	}
+	record(ctx, []byte(html.EscapeString(href)), ImageBundled, nil)
+	dataURL := strings.TrimSuffix(strings.TrimPrefix(string(img), `<image href="`), `"`)
+
+	mimeType, b64, ok := strings.Cut(strings.TrimPrefix(dataURL, "data:"), ";base64,")
+	if !ok {
+		return nil, "", errors.New("only base64 data URLs are supported")
+	}
+	buf, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, "", err
+	}
+	if mt, _, err := mime.ParseMediaType(mimeType); err == nil {
+		mimeType = mt
+	}
+	return buf, mimeType, nil
+}
+
+func bundleImage(ctx context.Context, l simplelog.Logger, inputPath string, href []byte, isRemote, cacheImages bool) ([]byte, error) {
+	provider := iconProvider(ctx, html.UnescapeString(string(href)))
+	// Local images are cheap to read again and may be edited while watched.
+	cacheImages = cacheImages && (isRemote || provider != nil)
 	if cacheImages {
 		if hit, ok := imgCache.Load(string(href)); ok {
 			return hit.([]byte), nil
@@ -164,25 +425,46 @@ func worker(ctx context.Context, l simplelog.Logger, inputPath string, href []by
 	var buf []byte
 	var mimeType string
 	var err error
-	if isRemote {
+	if strings.HasPrefix(string(href), "data:") {
+		// Data URLs are bundled again to check them like other images and encode them in base64.
+		buf, mimeType, err = decodeDataURL(html.UnescapeString(string(href)))
+	} else if provider != nil {
+		l.Debug(fmt.Sprintf("fetching %s from its icon provider", string(href)))
+		buf, err = provider(ctx, html.UnescapeString(string(href)))
+	} else if isRemote {
 		l.Debug(fmt.Sprintf("fetching %s remotely", string(href)))
-		buf, mimeType, err = httpGet(ctx, html.UnescapeString(string(href)))
+		buf, mimeType, err = httpGet(ctx, l, html.UnescapeString(string(href)))
 	} else {
 		l.Debug(fmt.Sprintf("reading %s from disk", string(href)))
 		path := html.UnescapeString(string(href))
-		if inputPath != "-" && !filepath.IsAbs(path) {
+		if root, ok := ctx.Value(imageRootKey{}).(string); ok && !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		} else if inputPath != "-" && !filepath.IsAbs(path) {
 			path = filepath.Join(filepath.Dir(inputPath), path)
 		}
 		buf, err = os.ReadFile(path)
+		if read, ok := ctx.Value(readFilesKey{}).(func(string)); ok && err == nil {
+			read(path)
+		}
 	}
 	if err != nil {
 		return nil, err
 	}
 
 	if mimeType == "" {
-		mimeType = sniffMimeType(href, buf, isRemote)
+		mimeType = sniffMimeType(href, buf, isRemote || provider != nil)
 	}
 	mimeType = strings.Replace(mimeType, "text/xml", "image/svg+xml", 1)
+	if strings.HasPrefix(mimeType, "image/svg+xml") && sanitizing(ctx) {
+		buf, err = SanitizeSVG(buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sanitize: %w", err)
+		}
+	}
+	buf, err = sizeLimits(ctx).limit(l, href, buf, mimeType)
+	if err != nil {
+		return nil, err
+	}
 	b64 := base64.StdEncoding.EncodeToString(buf)
 
 	out := []byte(fmt.Sprintf(`<image href="data:%s;base64,%s"`, mimeType, b64))
@@ -192,31 +474,126 @@ func worker(ctx context.Context, l simplelog.Logger, inputPath string, href []by
 	return out, nil
 }
 
+// decodeDataURL returns the data and media type of dataURL, encoded in base64 or percent
+// encoded. The media type is empty if the URL leaves it out.
+func decodeDataURL(dataURL string) ([]byte, string, error) {
+	meta, data, ok := strings.Cut(strings.TrimPrefix(dataURL, "data:"), ",")
+	if !ok {
+		return nil, "", errors.New("data URL is missing a comma")
+	}
+	mimeType, isBase64 := strings.CutSuffix(meta, ";base64")
+	if isBase64 {
+		buf, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			buf, err = base64.RawStdEncoding.DecodeString(data)
+		}
+		return buf, mimeType, err
+	}
+	unescaped, err := url.PathUnescape(data)
+	return []byte(unescaped), mimeType, err
+}
+
+// displayHref returns href shortened for logs, as data URLs may be megabytes long.
+func displayHref(href []byte) string {
+	if bytes.HasPrefix(href, []byte("data:")) && len(href) > 64 {
+		return string(href[:64]) + "..."
+	}
+	return string(href)
+}
+
 var httpClient = &http.Client{}
 
-func httpGet(ctx context.Context, href string) ([]byte, string, error) {
-	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+// httpGet gets href as configured by the fetch options of ctx.
+func httpGet(ctx context.Context, l simplelog.Logger, href string) ([]byte, string, error) {
+	opts := fetchOptions(ctx)
+	cached := readCachedImage(l, opts.CacheDir, href)
+	if opts.Offline {
+		if cached == nil {
+			return nil, "", errors.New("not cached, so cannot be fetched offline")
+		}
+		return cached.body, cached.ContentType, nil
+	}
+
+	img, err := httpGetRetrying(ctx, l, opts, href, cached)
+	if err != nil {
+		if cached != nil && ctx.Err() == nil {
+			l.Info(fmt.Sprintf("using cached %s as it could not be fetched: %v", href, err))
+			return cached.body, cached.ContentType, nil
+		}
+		return nil, "", err
+	}
+	if img != cached {
+		writeCachedImage(l, opts.CacheDir, img)
+	}
+	return img.body, img.ContentType, nil
+}
+
+// httpGetRetrying gets href, retrying transient failures.
+func httpGetRetrying(ctx context.Context, l simplelog.Logger, opts *FetchOptions, href string, cached *remoteImage) (*remoteImage, error) {
+	backoff := opts.Backoff
+	for i := 0; ; i++ {
+		img, transient, err := httpGetOnce(ctx, opts, href, cached)
+		if err == nil || !transient || i >= opts.Retries || ctx.Err() != nil {
+			return img, err
+		}
+		l.Debug(fmt.Sprintf("retrying %s in %v: %v", href, backoff, err))
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, err
+		case <-t.C:
+		}
+		backoff *= 2
+	}
+}
+
+// httpGetOnce gets href, or returns cached if the server responds it is still current.
+// transient is whether the error may not happen again on retry, e.g. a timeout or a 503
+// response.
+func httpGetOnce(ctx context.Context, opts *FetchOptions, href string, cached *remoteImage) (_ *remoteImage, transient bool, _ error) {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
 	defer cancel()
 
 	req, err := http.NewRequestWithContext(ctx, "GET", href, nil)
 	if err != nil {
-		return nil, "", err
+		return nil, false, err
+	}
+	req.Header = opts.header(href)
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
 	}
 
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return nil, "", err
+		return nil, true, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		return cached, false, nil
+	}
 	if resp.StatusCode != 200 {
-		return nil, "", fmt.Errorf("expected status 200 but got %d %s", resp.StatusCode, resp.Status)
+		transient := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return nil, transient, fmt.Errorf("expected status 200 but got %d %s", resp.StatusCode, resp.Status)
 	}
 	r := http.MaxBytesReader(nil, resp.Body, maxImageSize)
 	buf, err := ioutil.ReadAll(r)
 	if err != nil {
-		return nil, "", err
+		var maxBytesErr *http.MaxBytesError
+		return nil, !errors.As(err, &maxBytesErr), err
 	}
-	return buf, resp.Header.Get("Content-Type"), nil
+	return &remoteImage{
+		body:         buf,
+		URL:          href,
+		ContentType:  resp.Header.Get("Content-Type"),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}, false, nil
 }
 
 // sniffMimeType sniffs the mime type of href based on its file extension and contents.
@@ -1,16 +1,24 @@
 package imgbundler
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	_ "embed"
+	"encoding/base64"
 	"fmt"
+	"hash/fnv"
+	"image"
+	"image/png"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"cdr.dev/slog/sloggers/slogtest"
 	tassert "github.com/stretchr/testify/assert"
@@ -88,7 +96,7 @@ width="328" height="587" viewBox="-100 -131 328 587"><style type="text/css">
 		respRecorder := httptest.NewRecorder()
 		switch req.URL.String() {
 		case svgURL:
-			respRecorder.WriteString(`<?xml version=\"1.0\" encoding=\"iso-8859-1\"?>\r\n<!-- Generator: Adobe Illustrator 19.0.0, SVG Export Plug-In . SVG Version: 6.00 Build 0)  -->\r\n<svg version=\"1.1\" id=\"Capa_1\" xmlns=\"http://www.w3.org/2000/svg\" xmlns:xlink=\"http://www.w3.org/1999/xlink\" x=\"0px\" y=\"0px\"\r\n\t viewBox=\"0 0 58 58\" style=\"enable-background:new 0 0 58 58;\" xml:space=\"preserve\">\r\n<rect x=\"1\" y=\"7\" style=\"fill:#C3E1ED;stroke:#E7ECED;stroke-width:2;stroke-miterlimit:10;\" width=\"56\" height=\"44\"/>\r\n<circle style=\"fill:#ED8A19;\" cx=\"16\" cy=\"17.569\" r=\"6.569\"/>\r\n<polygon style=\"fill:#1A9172;\" points=\"56,36.111 55,35 43,24 32.5,35.5 37.983,40.983 42,45 56,45 \"/>\r\n<polygon style=\"fill:#1A9172;\" points=\"2,49 26,49 21.983,44.983 11.017,34.017 2,41.956 \"/>\r\n<rect x=\"2\" y=\"45\" style=\"fill:#6B5B4B;\" width=\"54\" height=\"5\"/>\r\n<polygon style=\"fill:#25AE88;\" points=\"37.983,40.983 27.017,30.017 10,45 42,45 \"/>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n</svg>`)
+			respRecorder.WriteString("<?xml version=\"1.0\" encoding=\"iso-8859-1\"?>\r\n<!-- Generator: Adobe Illustrator 19.0.0, SVG Export Plug-In . SVG Version: 6.00 Build 0)  -->\r\n<svg version=\"1.1\" id=\"Capa_1\" xmlns=\"http://www.w3.org/2000/svg\" xmlns:xlink=\"http://www.w3.org/1999/xlink\" x=\"0px\" y=\"0px\"\r\n\t viewBox=\"0 0 58 58\" style=\"enable-background:new 0 0 58 58;\" xml:space=\"preserve\">\r\n<rect x=\"1\" y=\"7\" style=\"fill:#C3E1ED;stroke:#E7ECED;stroke-width:2;stroke-miterlimit:10;\" width=\"56\" height=\"44\"/>\r\n<circle style=\"fill:#ED8A19;\" cx=\"16\" cy=\"17.569\" r=\"6.569\"/>\r\n<polygon style=\"fill:#1A9172;\" points=\"56,36.111 55,35 43,24 32.5,35.5 37.983,40.983 42,45 56,45 \"/>\r\n<polygon style=\"fill:#1A9172;\" points=\"2,49 26,49 21.983,44.983 11.017,34.017 2,41.956 \"/>\r\n<rect x=\"2\" y=\"45\" style=\"fill:#6B5B4B;\" width=\"54\" height=\"5\"/>\r\n<polygon style=\"fill:#25AE88;\" points=\"37.983,40.983 27.017,30.017 10,45 42,45 \"/>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n</svg>")
 		case pngURL:
 			respRecorder.Write(testPNGFile)
 		default:
@@ -254,7 +262,123 @@ width="328" height="587" viewBox="-100 -131 328 587"><style type="text/css">
 	}
 }
 
+func TestIconProviders(t *testing.T) {
+	imgCache = sync.Map{}
+	ctx := log.WithTB(context.Background(), t, nil)
+	l := simplelog.FromLibLog(ctx)
+
+	var fetched []string
+	ctx = WithIconProviders(ctx, map[string]IconProvider{
+		"corp": func(ctx context.Context, href string) ([]byte, error) {
+			fetched = append(fetched, href)
+			if href == "corp://payments/missing" {
+				return nil, fmt.Errorf("%s not found", href)
+			}
+			return testPNGFile, nil
+		},
+	})
+
+	svg := `<image href="corp://payments/logo" /><image href="https://icons.terrastruct.com/essentials/004-picture.svg" />`
+	out, err := BundleLocal(ctx, l, "index.d2", []byte(svg), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tassert.Equal(t, []string{"corp://payments/logo"}, fetched)
+	tassert.Contains(t, string(out), `<image href="data:image/png;base64,`)
+	tassert.Contains(t, string(out), `<image href="https://icons.terrastruct.com/essentials/004-picture.svg" />`)
+
+	// Remote bundling leaves provided icons alone.
+	out, err = BundleRemote(ctx, l, []byte(`<image href="corp://payments/logo" />`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tassert.Equal(t, `<image href="corp://payments/logo" />`, string(out))
+
+	var errs []string
+	l = simplelog.Make(nil, nil, go2.Pointer(func(s string) {
+		errs = append(errs, s)
+	}))
+	_, err = BundleLocal(ctx, l, "index.d2", []byte(`<image href="corp://payments/missing" />`), false)
+	tassert.EqualError(t, err, `failed to bundle local images: [corp://payments/missing]`)
+	tassert.Equal(t, []string{"failed to bundle corp://payments/missing: corp://payments/missing not found"}, errs)
+}
+
+func TestImageRoot(t *testing.T) {
+	imgCache = sync.Map{}
+	ctx := log.WithTB(context.Background(), t, &slogtest.Options{IgnoreErrors: true})
+	l := simplelog.FromLibLog(ctx)
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, "assets"), 0700)
+	tassert.Nil(t, err)
+	err = os.WriteFile(filepath.Join(dir, "assets", "icon.svg"), []byte(`<svg></svg>`), 0600)
+	tassert.Nil(t, err)
+
+	svg := []byte(`<image href="icon.svg" />`)
+	inputPath := filepath.Join(dir, "diagrams", "index.d2")
+	_, err = BundleLocal(ctx, l, inputPath, svg, false)
+	tassert.NotNil(t, err)
+
+	out, err := BundleLocal(WithImageRoot(ctx, filepath.Join(dir, "assets")), l, inputPath, svg, false)
+	tassert.Nil(t, err)
+	tassert.Contains(t, string(out), base64.StdEncoding.EncodeToString([]byte(`<svg></svg>`)))
+}
+
+func TestDataURL(t *testing.T) {
+	imgCache = sync.Map{}
+	ctx := log.WithTB(context.Background(), t, &slogtest.Options{IgnoreErrors: true})
+	l := simplelog.FromLibLog(ctx)
+
+	// Percent encoded SVGs are sanitized and encoded in base64.
+	svg := []byte(`<image href="data:image/svg+xml,%3Csvg%3E%3Cscript%3Ealert(1)%3C/script%3E%3C/svg%3E" />`)
+	out, err := BundleLocal(ctx, l, "index.d2", svg, false)
+	tassert.Nil(t, err)
+	tassert.Equal(t, `<image href="data:image/svg+xml;base64,`+base64.StdEncoding.EncodeToString([]byte(`<svg></svg>`))+`" />`, string(out))
+
+	b64 := base64.StdEncoding.EncodeToString(testPNGFile)
+	out, err = BundleLocal(ctx, l, "index.d2", []byte(`<image href="data:image/png;base64,`+b64+`" />`), false)
+	tassert.Nil(t, err)
+	tassert.Equal(t, `<image href="data:image/png;base64,`+b64+`" />`, string(out))
+
+	buf, mimeType, err := Image(ctx, l, "index.d2", "data:image/svg+xml,%3Csvg%3E%3C/svg%3E", false)
+	tassert.Nil(t, err)
+	tassert.Equal(t, "image/svg+xml", mimeType)
+	tassert.Equal(t, `<svg></svg>`, string(buf))
+
+	_, err = BundleLocal(ctx, l, "index.d2", []byte(`<image href="data:image/png;base64" />`), false)
+	tassert.NotNil(t, err)
+}
+
 // TestDuplicateURL ensures that we don't fetch the same image twice
+func TestReadFiles(t *testing.T) {
+	imgCache = sync.Map{}
+	// we don't want log.Error to cause this test to fail
+	ctx := log.WithTB(context.Background(), t, &slogtest.Options{IgnoreErrors: true})
+	l := simplelog.FromLibLog(ctx)
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "icon.svg"), []byte(`<svg>v1</svg>`), 0600)
+	tassert.Nil(t, err)
+
+	var mu sync.Mutex
+	var read []string
+	ctx = WithReadFiles(ctx, func(path string) {
+		mu.Lock()
+		defer mu.Unlock()
+		read = append(read, path)
+	})
+	svg := []byte(`<image href="icon.svg" /><image href="missing.svg" />`)
+	inputPath := filepath.Join(dir, "index.d2")
+	_, err = BundleLocal(ctx, l, inputPath, svg, true)
+	tassert.NotNil(t, err)
+	tassert.Equal(t, []string{filepath.Join(dir, "icon.svg")}, read)
+
+	// Local images are read again even with cacheImages, as they may have been edited.
+	err = os.WriteFile(filepath.Join(dir, "icon.svg"), []byte(`<svg>v2</svg>`), 0600)
+	tassert.Nil(t, err)
+	out, err := BundleLocal(ctx, l, inputPath, []byte(`<image href="icon.svg" />`), true)
+	tassert.Nil(t, err)
+	tassert.Contains(t, string(out), base64.StdEncoding.EncodeToString([]byte(`<svg>v2</svg>`)))
+}
+
 func TestDuplicateURL(t *testing.T) {
 	imgCache = sync.Map{}
 	ctx := log.WithTB(context.Background(), t, nil)
@@ -293,7 +417,7 @@ width="328" height="587" viewBox="-100 -131 328 587"><style type="text/css">
 	httpClient.Transport = roundTripFunc(func(req *http.Request) *http.Response {
 		count++
 		respRecorder := httptest.NewRecorder()
-		respRecorder.WriteString(`<?xml version=\"1.0\" encoding=\"iso-8859-1\"?>\r\n<!-- Generator: Adobe Illustrator 19.0.0, SVG Export Plug-In . SVG Version: 6.00 Build 0)  -->\r\n<svg version=\"1.1\" id=\"Capa_1\" xmlns=\"http://www.w3.org/2000/svg\" xmlns:xlink=\"http://www.w3.org/1999/xlink\" x=\"0px\" y=\"0px\"\r\n\t viewBox=\"0 0 58 58\" style=\"enable-background:new 0 0 58 58;\" xml:space=\"preserve\">\r\n<rect x=\"1\" y=\"7\" style=\"fill:#C3E1ED;stroke:#E7ECED;stroke-width:2;stroke-miterlimit:10;\" width=\"56\" height=\"44\"/>\r\n<circle style=\"fill:#ED8A19;\" cx=\"16\" cy=\"17.569\" r=\"6.569\"/>\r\n<polygon style=\"fill:#1A9172;\" points=\"56,36.111 55,35 43,24 32.5,35.5 37.983,40.983 42,45 56,45 \"/>\r\n<polygon style=\"fill:#1A9172;\" points=\"2,49 26,49 21.983,44.983 11.017,34.017 2,41.956 \"/>\r\n<rect x=\"2\" y=\"45\" style=\"fill:#6B5B4B;\" width=\"54\" height=\"5\"/>\r\n<polygon style=\"fill:#25AE88;\" points=\"37.983,40.983 27.017,30.017 10,45 42,45 \"/>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n</svg>`)
+		respRecorder.WriteString("<?xml version=\"1.0\" encoding=\"iso-8859-1\"?>\r\n<!-- Generator: Adobe Illustrator 19.0.0, SVG Export Plug-In . SVG Version: 6.00 Build 0)  -->\r\n<svg version=\"1.1\" id=\"Capa_1\" xmlns=\"http://www.w3.org/2000/svg\" xmlns:xlink=\"http://www.w3.org/1999/xlink\" x=\"0px\" y=\"0px\"\r\n\t viewBox=\"0 0 58 58\" style=\"enable-background:new 0 0 58 58;\" xml:space=\"preserve\">\r\n<rect x=\"1\" y=\"7\" style=\"fill:#C3E1ED;stroke:#E7ECED;stroke-width:2;stroke-miterlimit:10;\" width=\"56\" height=\"44\"/>\r\n<circle style=\"fill:#ED8A19;\" cx=\"16\" cy=\"17.569\" r=\"6.569\"/>\r\n<polygon style=\"fill:#1A9172;\" points=\"56,36.111 55,35 43,24 32.5,35.5 37.983,40.983 42,45 56,45 \"/>\r\n<polygon style=\"fill:#1A9172;\" points=\"2,49 26,49 21.983,44.983 11.017,34.017 2,41.956 \"/>\r\n<rect x=\"2\" y=\"45\" style=\"fill:#6B5B4B;\" width=\"54\" height=\"5\"/>\r\n<polygon style=\"fill:#25AE88;\" points=\"37.983,40.983 27.017,30.017 10,45 42,45 \"/>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n</svg>")
 		respRecorder.WriteHeader(200)
 		return respRecorder.Result()
 	})
@@ -348,7 +472,7 @@ width="328" height="587" viewBox="-100 -131 328 587"><style type="text/css">
 	httpClient.Transport = roundTripFunc(func(req *http.Request) *http.Response {
 		count++
 		respRecorder := httptest.NewRecorder()
-		respRecorder.WriteString(`<?xml version=\"1.0\" encoding=\"iso-8859-1\"?>\r\n<!-- Generator: Adobe Illustrator 19.0.0, SVG Export Plug-In . SVG Version: 6.00 Build 0)  -->\r\n<svg version=\"1.1\" id=\"Capa_1\" xmlns=\"http://www.w3.org/2000/svg\" xmlns:xlink=\"http://www.w3.org/1999/xlink\" x=\"0px\" y=\"0px\"\r\n\t viewBox=\"0 0 58 58\" style=\"enable-background:new 0 0 58 58;\" xml:space=\"preserve\">\r\n<rect x=\"1\" y=\"7\" style=\"fill:#C3E1ED;stroke:#E7ECED;stroke-width:2;stroke-miterlimit:10;\" width=\"56\" height=\"44\"/>\r\n<circle style=\"fill:#ED8A19;\" cx=\"16\" cy=\"17.569\" r=\"6.569\"/>\r\n<polygon style=\"fill:#1A9172;\" points=\"56,36.111 55,35 43,24 32.5,35.5 37.983,40.983 42,45 56,45 \"/>\r\n<polygon style=\"fill:#1A9172;\" points=\"2,49 26,49 21.983,44.983 11.017,34.017 2,41.956 \"/>\r\n<rect x=\"2\" y=\"45\" style=\"fill:#6B5B4B;\" width=\"54\" height=\"5\"/>\r\n<polygon style=\"fill:#25AE88;\" points=\"37.983,40.983 27.017,30.017 10,45 42,45 \"/>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n</svg>`)
+		respRecorder.WriteString("<?xml version=\"1.0\" encoding=\"iso-8859-1\"?>\r\n<!-- Generator: Adobe Illustrator 19.0.0, SVG Export Plug-In . SVG Version: 6.00 Build 0)  -->\r\n<svg version=\"1.1\" id=\"Capa_1\" xmlns=\"http://www.w3.org/2000/svg\" xmlns:xlink=\"http://www.w3.org/1999/xlink\" x=\"0px\" y=\"0px\"\r\n\t viewBox=\"0 0 58 58\" style=\"enable-background:new 0 0 58 58;\" xml:space=\"preserve\">\r\n<rect x=\"1\" y=\"7\" style=\"fill:#C3E1ED;stroke:#E7ECED;stroke-width:2;stroke-miterlimit:10;\" width=\"56\" height=\"44\"/>\r\n<circle style=\"fill:#ED8A19;\" cx=\"16\" cy=\"17.569\" r=\"6.569\"/>\r\n<polygon style=\"fill:#1A9172;\" points=\"56,36.111 55,35 43,24 32.5,35.5 37.983,40.983 42,45 56,45 \"/>\r\n<polygon style=\"fill:#1A9172;\" points=\"2,49 26,49 21.983,44.983 11.017,34.017 2,41.956 \"/>\r\n<rect x=\"2\" y=\"45\" style=\"fill:#6B5B4B;\" width=\"54\" height=\"5\"/>\r\n<polygon style=\"fill:#25AE88;\" points=\"37.983,40.983 27.017,30.017 10,45 42,45 \"/>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n<g>\r\n</g>\r\n</svg>")
 		respRecorder.WriteHeader(200)
 		return respRecorder.Result()
 	})
@@ -377,3 +501,336 @@ width="328" height="587" viewBox="-100 -131 328 587"><style type="text/css">
 	}
 	tassert.Equal(t, 2, count)
 }
+
+func TestFetchOptions(t *testing.T) {
+	imgCache = sync.Map{}
+	// we don't want log.Error to cause this test to fail
+	ctx := log.WithTB(context.Background(), t, &slogtest.Options{IgnoreErrors: true})
+	l := simplelog.FromLibLog(ctx)
+	ctx = WithFetchOptions(ctx, FetchOptions{
+		Concurrency: 2,
+		Retries:     2,
+		Backoff:     time.Millisecond,
+	})
+
+	var mu sync.Mutex
+	var inflight, maxInflight int
+	statuses := make(map[string][]int)
+	httpClient.Transport = roundTripFunc(func(req *http.Request) *http.Response {
+		mu.Lock()
+		inflight++
+		maxInflight = max(maxInflight, inflight)
+		status := 200
+		if s := statuses[req.URL.Path]; len(s) > 0 {
+			status = s[0]
+			statuses[req.URL.Path] = s[1:]
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond * 10)
+		mu.Lock()
+		inflight--
+		mu.Unlock()
+		respRecorder := httptest.NewRecorder()
+		respRecorder.WriteHeader(status)
+		respRecorder.WriteString(`<svg></svg>`)
+		return respRecorder.Result()
+	})
+
+	var svg string
+	for i := 0; i < 6; i++ {
+		svg += fmt.Sprintf(`<image href="https://icons.terrastruct.com/%d.svg" />`, i)
+	}
+	statuses["/0.svg"] = []int{503, 429}
+	out, err := BundleRemote(ctx, l, []byte(svg), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tassert.Equal(t, 6, strings.Count(string(out), ";base64,"))
+	tassert.Equal(t, 2, maxInflight)
+
+	// Retries run out.
+	statuses["/0.svg"] = []int{503, 503, 503}
+	_, err = BundleRemote(ctx, l, []byte(svg), false)
+	tassert.ErrorContains(t, err, "https://icons.terrastruct.com/0.svg")
+
+	// Only transient failures are retried.
+	statuses["/0.svg"] = []int{404, 200}
+	_, err = BundleRemote(ctx, l, []byte(svg), false)
+	tassert.ErrorContains(t, err, "https://icons.terrastruct.com/0.svg")
+	tassert.Equal(t, []int{200}, statuses["/0.svg"])
+}
+
+func TestFetchHeaders(t *testing.T) {
+	imgCache = sync.Map{}
+	ctx := log.WithTB(context.Background(), t, nil)
+	l := simplelog.FromLibLog(ctx)
+	ctx = WithFetchOptions(ctx, FetchOptions{
+		Headers: []HeaderRule{
+			{Pattern: "https://*.corp.example.com/", Header: http.Header{"Authorization": {"Bearer a"}, "X-Team": {"icons"}}},
+			{Pattern: "https://artifacts.corp.example.com/", Header: http.Header{"authorization": {"Bearer b"}}},
+		},
+	})
+
+	var mu sync.Mutex
+	got := make(map[string]http.Header)
+	httpClient.Transport = roundTripFunc(func(req *http.Request) *http.Response {
+		mu.Lock()
+		got[req.URL.String()] = req.Header
+		mu.Unlock()
+		respRecorder := httptest.NewRecorder()
+		respRecorder.WriteString(`<svg></svg>`)
+		return respRecorder.Result()
+	})
+
+	_, err := BundleRemote(ctx, l, []byte(`<image href="https://icons.corp.example.com/a.svg" /><image href="https://artifacts.corp.example.com/b.svg" /><image href="https://icons.terrastruct.com/c.svg" />`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tassert.Equal(t, "Bearer a", got["https://icons.corp.example.com/a.svg"].Get("Authorization"))
+	tassert.Equal(t, "Bearer b", got["https://artifacts.corp.example.com/b.svg"].Get("Authorization"))
+	tassert.Equal(t, "icons", got["https://artifacts.corp.example.com/b.svg"].Get("X-Team"))
+	tassert.Equal(t, "", got["https://icons.terrastruct.com/c.svg"].Get("Authorization"))
+}
+
+func TestSanitizeSVG(t *testing.T) {
+	out, err := SanitizeSVG([]byte(`<?xml version="1.0"?>
+<!DOCTYPE svg [<!ENTITY x "y">]>
+<?xml-stylesheet href="https://evil.example.com/a.css"?>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" onload="alert(1)">
+<style>@import url(https://evil.example.com/b.css); .a { fill: url(#g); background: url('https://evil.example.com/c.png') }</style>
+<script>alert(2)</script>
+<foreignObject><div xmlns="http://www.w3.org/1999/xhtml"><iframe src="https://evil.example.com"></iframe></div></foreignObject>
+<linearGradient id="g"/>
+<rect class="a" fill="url(#g)" filter="url(https://evil.example.com/d.svg#f)" onclick="alert(3)"/>
+<use xlink:href="#g"/><use href="https://evil.example.com/e.svg#x"/>
+<image href="data:image/png;base64,AAAA"/><image href="javascript:alert(4)"/>
+<text>a &lt; b</text>
+</svg>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tassert.Equal(t, `<?xml version="1.0"?>
+
+
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink">
+<style> .a { fill: url(#g); background: none }</style>
+
+
+<linearGradient id="g"></linearGradient>
+<rect class="a" fill="url(#g)" filter="none"></rect>
+<use xlink:href="#g"></use><use></use>
+<image href="data:image/png;base64,AAAA"></image><image></image>
+<text>a &lt; b</text>
+</svg>`, string(out))
+}
+
+func TestSizeLimits(t *testing.T) {
+	imgCache = sync.Map{}
+	ctx := log.WithTB(context.Background(), t, &slogtest.Options{IgnoreErrors: true})
+	dir := t.TempDir()
+
+	var b bytes.Buffer
+	err := png.Encode(&b, image.NewRGBA(image.Rect(0, 0, 400, 200)))
+	tassert.Nil(t, err)
+	err = os.WriteFile(filepath.Join(dir, "wide.png"), b.Bytes(), 0600)
+	tassert.Nil(t, err)
+	err = os.WriteFile(filepath.Join(dir, "icon.svg"), []byte(`<svg>`+strings.Repeat(" ", 2048)+`</svg>`), 0600)
+	tassert.Nil(t, err)
+
+	var infos, errs []string
+	l := simplelog.Make(nil, go2.Pointer(func(s string) {
+		infos = append(infos, s)
+	}), go2.Pointer(func(s string) {
+		errs = append(errs, s)
+	}))
+	inputPath := filepath.Join(dir, "index.d2")
+
+	ctx = WithSizeLimits(ctx, SizeLimits{MaxDimension: 100, WarnSize: 1024})
+	buf, mimeType, err := Image(ctx, l, inputPath, "wide.png", false)
+	tassert.Nil(t, err)
+	tassert.Equal(t, "image/png", mimeType)
+	cfg, err := png.DecodeConfig(bytes.NewReader(buf))
+	tassert.Nil(t, err)
+	tassert.Equal(t, 100, cfg.Width)
+	tassert.Equal(t, 50, cfg.Height)
+
+	// SVGs are not downscaled, only checked.
+	_, err = BundleLocal(ctx, l, inputPath, []byte(`<image href="icon.svg" />`), false)
+	tassert.Nil(t, err)
+	tassert.Equal(t, []string{"icon.svg is 2.0 KB, above the warning size of 1.0 KB"}, infos)
+
+	ctx = WithSizeLimits(ctx, SizeLimits{MaxSize: 1024})
+	_, err = BundleLocal(ctx, l, inputPath, []byte(`<image href="icon.svg" />`), false)
+	tassert.EqualError(t, err, "failed to bundle local images: [icon.svg]")
+	tassert.Equal(t, []string{"failed to bundle icon.svg: image is 2.0 KB, above the maximum of 1.0 KB"}, errs)
+}
+
+func TestBundleCache(t *testing.T) {
+	imgCache = sync.Map{}
+	ctx := log.WithTB(context.Background(), t, nil)
+	l := simplelog.FromLibLog(ctx)
+
+	var count atomic.Int64
+	httpClient.Transport = roundTripFunc(func(req *http.Request) *http.Response {
+		count.Add(1)
+		time.Sleep(time.Millisecond * 10)
+		respRecorder := httptest.NewRecorder()
+		respRecorder.WriteString(`<svg></svg>`)
+		respRecorder.WriteHeader(200)
+		return respRecorder.Result()
+	})
+
+	// Boards bundled concurrently under the same cache fetch their common images once.
+	svg := []byte(`<image href="https://icons.terrastruct.com/logo.svg" />`)
+	bctx := WithBundleCache(ctx)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, err := BundleRemote(bctx, l, svg, false)
+			tassert.Nil(t, err)
+			tassert.Contains(t, string(out), ";base64,")
+		}()
+	}
+	wg.Wait()
+	tassert.Equal(t, int64(1), count.Load())
+
+	// Without the cache, they are fetched again.
+	_, err := BundleRemote(ctx, l, svg, false)
+	tassert.Nil(t, err)
+	tassert.Equal(t, int64(2), count.Load())
+}
+
+func TestDiskCache(t *testing.T) {
+	imgCache = sync.Map{}
+	// we don't want log.Error to cause this test to fail
+	ctx := log.WithTB(context.Background(), t, &slogtest.Options{IgnoreErrors: true})
+	l := simplelog.FromLibLog(ctx)
+	dir := t.TempDir()
+
+	var requests []string
+	status := 200
+	httpClient.Transport = roundTripFunc(func(req *http.Request) *http.Response {
+		requests = append(requests, req.Header.Get("If-None-Match"))
+		respRecorder := httptest.NewRecorder()
+		if status == 200 && req.Header.Get("If-None-Match") == `"v1"` {
+			respRecorder.WriteHeader(http.StatusNotModified)
+			return respRecorder.Result()
+		}
+		respRecorder.Header().Set("ETag", `"v1"`)
+		respRecorder.Header().Set("Content-Type", "image/svg+xml")
+		respRecorder.WriteHeader(status)
+		respRecorder.WriteString(`<svg></svg>`)
+		return respRecorder.Result()
+	})
+
+	svg := []byte(`<image href="https://icons.terrastruct.com/logo.svg" />`)
+	bundled := `<image href="data:image/svg+xml;base64,PHN2Zz48L3N2Zz4=" />`
+	bundle := func(opts FetchOptions) (string, error) {
+		opts.CacheDir = dir
+		out, err := BundleRemote(WithFetchOptions(ctx, opts), l, svg, false)
+		return string(out), err
+	}
+
+	out, err := bundle(FetchOptions{})
+	tassert.Nil(t, err)
+	tassert.Equal(t, bundled, out)
+
+	// Cached images are revalidated.
+	out, err = bundle(FetchOptions{})
+	tassert.Nil(t, err)
+	tassert.Equal(t, bundled, out)
+	tassert.Equal(t, []string{"", `"v1"`}, requests)
+
+	// and used as they are if that fails.
+	status = 500
+	out, err = bundle(FetchOptions{})
+	tassert.Nil(t, err)
+	tassert.Equal(t, bundled, out)
+
+	requests = nil
+	out, err = bundle(FetchOptions{Offline: true})
+	tassert.Nil(t, err)
+	tassert.Equal(t, bundled, out)
+	tassert.Equal(t, 0, len(requests))
+
+	_, err = BundleRemote(WithFetchOptions(ctx, FetchOptions{CacheDir: dir, Offline: true}), l, []byte(`<image href="https://icons.terrastruct.com/other.svg" />`), false)
+	tassert.ErrorContains(t, err, "https://icons.terrastruct.com/other.svg")
+	tassert.Equal(t, 0, len(requests))
+}
+
+func TestDedupe(t *testing.T) {
+	icon := `data:image/svg+xml;base64,PHN2Zz48L3N2Zz4=`
+	other := `data:image/png;base64,AAAA`
+	svg := `<?xml version="1.0" encoding="utf-8"?><svg id="d2-svg"><svg id="a">` +
+		`<image href="` + icon + `" x="0" y="0" width="10" height="10" />` +
+		`<image href="` + other + `" x="0" y="0" width="10" height="10" />` +
+		`</svg><svg id="b"><image href="` + icon + `" x="5" y="5" width="20" height="20" class="shape" /></svg></svg>`
+
+	h := fnv.New32a()
+	h.Write([]byte(icon))
+	id := fmt.Sprintf("d2-image-%x", h.Sum32())
+	exp := `<?xml version="1.0" encoding="utf-8"?><svg id="d2-svg">` +
+		`<defs><symbol id="` + id + `"><image href="` + icon + `" width="100%" height="100%" /></symbol></defs>` +
+		`<svg id="a">` +
+		`<use href="#` + id + `" x="0" y="0" width="10" height="10" />` +
+		`<image href="` + other + `" x="0" y="0" width="10" height="10" />` +
+		`</svg><svg id="b"><use href="#` + id + `" x="5" y="5" width="20" height="20" class="shape" /></svg></svg>`
+	tassert.Equal(t, exp, string(Dedupe([]byte(svg))))
+
+	// Images embedded once are left as is.
+	once := `<svg><image href="` + icon + `" /><image href="` + other + `" /></svg>`
+	tassert.Equal(t, once, string(Dedupe([]byte(once))))
+}
+
+func TestReport(t *testing.T) {
+	imgCache = sync.Map{}
+	ctx := log.WithTB(context.Background(), t, &slogtest.Options{IgnoreErrors: true})
+	l := simplelog.FromLibLog(ctx)
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "icon.svg"), []byte(`<svg></svg>`), 0600)
+	tassert.Nil(t, err)
+	httpClient.Transport = roundTripFunc(func(req *http.Request) *http.Response {
+		respRecorder := httptest.NewRecorder()
+		respRecorder.WriteHeader(404)
+		return respRecorder.Result()
+	})
+
+	var report Report
+	ctx = WithReport(ctx, &report)
+	svg := []byte(`<image href="icon.svg" width="10" /><image href="missing.svg" width="10" /><image href="https://example.com/x.svg" width="10" />`)
+	out, err := BundleLocal(ctx, l, filepath.Join(dir, "index.d2"), svg, false)
+	tassert.ErrorContains(t, err, "missing.svg")
+	tassert.Equal(t, 2, strings.Count(string(out), `data:image/svg+xml;base64,`))
+	tassert.Contains(t, string(out), `<image href="https://example.com/x.svg" width="10" />`)
+
+	// Failed images are replaced with a placeholder showing their URL.
+	i := bytes.Index(out, placeholder([]byte("missing.svg")))
+	tassert.NotEqual(t, -1, i)
+	tassert.Contains(t, string(out[i:]), `" width="10" />`)
+	b64 := strings.TrimPrefix(string(placeholder([]byte("missing.svg"))), `<image href="data:image/svg+xml;base64,`)
+	buf, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(b64, `"`))
+	tassert.Nil(t, err)
+	tassert.Contains(t, string(buf), "missing.svg")
+
+	images := report.Images()
+	tassert.Equal(t, 3, len(images))
+	tassert.Equal(t, ReportImage{Href: "https://example.com/x.svg", Status: ImageSkipped}, images[0])
+	tassert.Equal(t, ReportImage{Href: "icon.svg", Status: ImageBundled}, images[1])
+	tassert.Equal(t, "missing.svg", images[2].Href)
+	tassert.Equal(t, ImageFailed, images[2].Status)
+	tassert.Contains(t, images[2].Error, "missing.svg")
+
+	// The remote pass fails the image skipped by the local pass.
+	_, err = BundleRemote(ctx, l, out, false)
+	tassert.ErrorContains(t, err, "https://example.com/x.svg")
+	images = report.Images()
+	tassert.Equal(t, 3, len(images))
+	tassert.Equal(t, ImageFailed, images[0].Status)
+	tassert.Equal(t, ImageBundled, images[1].Status)
+
+	report.Reset()
+	tassert.Equal(t, 0, len(report.Images()))
+}
@@ -0,0 +1,119 @@
+package imgbundler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"strings"
+
+	"golang.org/x/image/draw"
+
+	"oss.terrastruct.com/d2/lib/simplelog"
+)
+
+// SizeLimits limits the images bundled into diagrams, so that a single high resolution
+// screenshot does not make a diagram tens of megabytes.
+type SizeLimits struct {
+	// WarnSize is the size in bytes of bundled images above which they are logged. Not
+	// checked if 0.
+	WarnSize int64
+	// MaxSize is the size in bytes of bundled images above which bundling them fails. Not
+	// checked if 0.
+	MaxSize int64
+	// MaxDimension is the width and height in pixels that PNG and JPEG images larger than it
+	// are downscaled to fit within, keeping their aspect ratio. Sizes are checked after
+	// downscaling. Images are not downscaled if 0.
+	MaxDimension int
+}
+
+type sizeLimitsKey struct{}
+
+// WithSizeLimits returns a context under which images are bundled within limits.
+func WithSizeLimits(ctx context.Context, limits SizeLimits) context.Context {
+	return context.WithValue(ctx, sizeLimitsKey{}, limits)
+}
+
+func sizeLimits(ctx context.Context) SizeLimits {
+	limits, _ := ctx.Value(sizeLimitsKey{}).(SizeLimits)
+	return limits
+}
+
+// limit downscales buf, of mimeType, and checks its size as configured by limits.
+func (limits SizeLimits) limit(l simplelog.Logger, href []byte, buf []byte, mimeType string) ([]byte, error) {
+	if limits.MaxDimension > 0 {
+		downscaled, ok, err := Downscale(buf, mimeType, limits.MaxDimension)
+		if err != nil {
+			return nil, fmt.Errorf("failed to downscale: %w", err)
+		}
+		if ok {
+			l.Debug(fmt.Sprintf("downscaled %s from %d to %d bytes", displayHref(href), len(buf), len(downscaled)))
+			buf = downscaled
+		}
+	}
+	size := int64(len(buf))
+	if limits.MaxSize > 0 && size > limits.MaxSize {
+		return nil, fmt.Errorf("image is %s, above the maximum of %s", formatSize(size), formatSize(limits.MaxSize))
+	}
+	if limits.WarnSize > 0 && size > limits.WarnSize {
+		l.Info(fmt.Sprintf("%s is %s, above the warning size of %s", displayHref(href), formatSize(size), formatSize(limits.WarnSize)))
+	}
+	return buf, nil
+}
+
+// Downscale returns buf, a PNG or JPEG image, downscaled and encoded again to fit within
+// maxDimension pixels, keeping its aspect ratio. ok is false if buf is another kind of
+// image or already fits.
+func Downscale(buf []byte, mimeType string, maxDimension int) (_ []byte, ok bool, _ error) {
+	mimeType = strings.ToLower(mimeType)
+	isPNG := strings.HasPrefix(mimeType, "image/png")
+	isJPEG := strings.HasPrefix(mimeType, "image/jpeg")
+	if !isPNG && !isJPEG {
+		return buf, false, nil
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(buf))
+	if err != nil {
+		return nil, false, err
+	}
+	if cfg.Width <= maxDimension && cfg.Height <= maxDimension {
+		return buf, false, nil
+	}
+	src, _, err := image.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, false, err
+	}
+
+	w, h := maxDimension, maxDimension
+	if cfg.Width > cfg.Height {
+		h = max(1, cfg.Height*maxDimension/cfg.Width)
+	} else {
+		w = max(1, cfg.Width*maxDimension/cfg.Height)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+
+	var out bytes.Buffer
+	if isPNG {
+		enc := png.Encoder{CompressionLevel: png.BestCompression}
+		err = enc.Encode(&out, dst)
+	} else {
+		err = jpeg.Encode(&out, dst, &jpeg.Options{Quality: 85})
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return out.Bytes(), true, nil
+}
+
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
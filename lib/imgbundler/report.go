@@ -0,0 +1,106 @@
+package imgbundler
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"sort"
+	"sync"
+)
+
+// ImageStatus is what became of an image when bundled.
+type ImageStatus string
+
+const (
+	ImageBundled ImageStatus = "bundled"
+	// ImageSkipped images are left as they are, i.e. remote images when only local ones are
+	// bundled.
+	ImageSkipped ImageStatus = "skipped"
+	// ImageFailed images are replaced with a placeholder showing their URL.
+	ImageFailed ImageStatus = "failed"
+)
+
+// ReportImage is what became of an image.
+type ReportImage struct {
+	Href   string      `json:"href"`
+	Status ImageStatus `json:"status"`
+	// Error is why a failed image failed.
+	Error string `json:"error,omitempty"`
+}
+
+// Report records what became of the images bundled under a context, see WithReport. The
+// zero value is an empty report.
+type Report struct {
+	mu     sync.Mutex
+	images map[string]ReportImage
+}
+
+type reportKey struct{}
+
+// WithReport returns a context under which what becomes of every image bundled is recorded
+// in r.
+func WithReport(ctx context.Context, r *Report) context.Context {
+	return context.WithValue(ctx, reportKey{}, r)
+}
+
+// Images returns the images recorded in r, sorted by href.
+func (r *Report) Images() []ReportImage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	images := make([]ReportImage, 0, len(r.images))
+	for _, img := range r.images {
+		images = append(images, img)
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Href < images[j].Href
+	})
+	return images
+}
+
+// Reset empties r, e.g. for watch mode to only report the images of the last compilation.
+func (r *Report) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.images = nil
+}
+
+// record records the status of the image at href, an href attribute of an SVG, under ctx.
+// Images bundled or failed are not recorded as skipped by another pass.
+func record(ctx context.Context, href []byte, status ImageStatus, err error) {
+	r, _ := ctx.Value(reportKey{}).(*Report)
+	if r == nil {
+		return
+	}
+	img := ReportImage{
+		Href:   displayHref([]byte(html.UnescapeString(string(href)))),
+		Status: status,
+	}
+	if err != nil {
+		img.Error = err.Error()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.images == nil {
+		r.images = make(map[string]ReportImage)
+	}
+	if prev, ok := r.images[img.Href]; ok && status == ImageSkipped && prev.Status != ImageSkipped {
+		return
+	}
+	r.images[img.Href] = img
+}
+
+// placeholder returns the image element that replaces img, an image element of an SVG whose
+// image at href failed to be bundled: a dashed box showing href.
+func placeholder(href []byte) []byte {
+	text := displayHref([]byte(html.UnescapeString(string(href))))
+	if len(text) > 40 {
+		text = text[:19] + "..." + text[len(text)-18:]
+	}
+	svg := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 200 120">`+
+		`<rect x="1" y="1" width="198" height="118" fill="#FFF5F5" stroke="#E03131" stroke-width="2" stroke-dasharray="6 4"/>`+
+		`<text x="100" y="54" text-anchor="middle" font-family="sans-serif" font-size="14" fill="#C92A2A">image failed to load</text>`+
+		`<text x="100" y="78" text-anchor="middle" font-family="sans-serif" font-size="9" fill="#C92A2A">%s</text>`+
+		`</svg>`, html.EscapeString(text))
+	return []byte(`<image href="data:image/svg+xml;base64,` + base64.StdEncoding.EncodeToString([]byte(svg)) + `"`)
+}
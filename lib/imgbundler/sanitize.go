@@ -0,0 +1,160 @@
+package imgbundler
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+)
+
+type noSanitizeKey struct{}
+
+// WithoutSanitizing returns a context under which SVG images are bundled as they are, see
+// SanitizeSVG.
+func WithoutSanitizing(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noSanitizeKey{}, true)
+}
+
+func sanitizing(ctx context.Context) bool {
+	noSanitize, _ := ctx.Value(noSanitizeKey{}).(bool)
+	return !noSanitize
+}
+
+// unsafeElements are removed from SVG images along with their contents.
+var unsafeElements = map[string]struct{}{
+	"script":        {},
+	"foreignobject": {},
+	"iframe":        {},
+	"object":        {},
+	"embed":         {},
+	"handler":       {},
+	"listener":      {},
+}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+	cssImportRegex = regexp.MustCompile(`(?i)@import[^;]*;?`)
+	cssURLRegex    = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)]*?))\s*\)`)
+)
+
+// SanitizeSVG returns svg without what could run or load anything when it's inlined into
+// a diagram, so that bundled diagrams are safe to host: scripts, foreign objects and
+// embedded documents, event handler attributes, and references to anything but the
+// fragments of the image itself and data URLs of images, in attributes and styles alike.
+// Comments and doctypes, which may declare entities, are removed too.
+func SanitizeSVG(svg []byte) ([]byte, error) {
+	d := xml.NewDecoder(bytes.NewReader(svg))
+	d.Strict = false
+	// The image is written back in the encoding it declares.
+	d.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) {
+		return r, nil
+	}
+	var buf bytes.Buffer
+	// skip is the depth of the unsafe element being removed, 0 if none.
+	skip := 0
+	for {
+		tok, err := d.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if skip > 0 {
+				skip++
+				continue
+			}
+			if _, ok := unsafeElements[strings.ToLower(tok.Name.Local)]; ok {
+				skip = 1
+				continue
+			}
+			buf.WriteByte('<')
+			buf.WriteString(xmlName(tok.Name))
+			for _, attr := range tok.Attr {
+				v, ok := sanitizeAttr(attr)
+				if !ok {
+					continue
+				}
+				buf.WriteByte(' ')
+				buf.WriteString(xmlName(attr.Name))
+				buf.WriteString(`="`)
+				buf.WriteString(attrEscaper.Replace(v))
+				buf.WriteByte('"')
+			}
+			buf.WriteByte('>')
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+				continue
+			}
+			buf.WriteString("</")
+			buf.WriteString(xmlName(tok.Name))
+			buf.WriteByte('>')
+		case xml.CharData:
+			if skip > 0 {
+				continue
+			}
+			// Only styles hold references in text.
+			buf.WriteString(textEscaper.Replace(sanitizeCSS(string(tok))))
+		case xml.ProcInst:
+			if skip == 0 && tok.Target == "xml" {
+				buf.WriteString("<?xml ")
+				buf.Write(tok.Inst)
+				buf.WriteString("?>")
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func xmlName(n xml.Name) string {
+	if n.Space != "" {
+		return n.Space + ":" + n.Local
+	}
+	return n.Local
+}
+
+// sanitizeAttr returns the value of attr to keep, if any.
+func sanitizeAttr(attr xml.Attr) (string, bool) {
+	name := strings.ToLower(attr.Name.Local)
+	if strings.HasPrefix(name, "on") {
+		return "", false
+	}
+	switch name {
+	case "href", "src":
+		return attr.Value, safeRef(attr.Value)
+	case "style":
+		return sanitizeCSS(attr.Value), true
+	}
+	// Presentation attributes such as fill and filter reference with url().
+	if strings.Contains(strings.ToLower(attr.Value), "url(") {
+		return sanitizeCSS(attr.Value), true
+	}
+	return attr.Value, true
+}
+
+// sanitizeCSS removes imports and references to anything but fragments and image data URLs
+// from css.
+func sanitizeCSS(css string) string {
+	css = cssImportRegex.ReplaceAllString(css, "")
+	return cssURLRegex.ReplaceAllStringFunc(css, func(u string) string {
+		m := cssURLRegex.FindStringSubmatch(u)
+		if safeRef(m[1] + m[2] + m[3]) {
+			return u
+		}
+		return "none"
+	})
+}
+
+// safeRef returns whether ref only references the image itself or embedded image data.
+func safeRef(ref string) bool {
+	ref = strings.ToLower(strings.TrimSpace(ref))
+	return strings.HasPrefix(ref, "#") || strings.HasPrefix(ref, "data:image/") && !strings.HasPrefix(ref, "data:image/svg+xml")
+}
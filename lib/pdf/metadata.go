@@ -0,0 +1,137 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Metadata is the document information of a PDF.
+type Metadata struct {
+	Title    string
+	Author   string
+	Subject  string
+	Keywords string
+	// XMP embeds the metadata as an XMP packet too, which some document management systems
+	// only read.
+	XMP bool
+	// Standard is the standard the PDF conforms to, one of Standards, none if empty. It
+	// implies XMP.
+	Standard string
+}
+
+// Protection is the encryption of a PDF.
+type Protection struct {
+	// UserPassword is the password needed to open the PDF, none if empty.
+	UserPassword string
+	// OwnerPassword is the password giving full access to the PDF regardless of Permissions.
+	// When empty, nobody has full access.
+	OwnerPassword string
+	// Permissions are what the PDF can be opened for without the owner password, a subset of
+	// the keys of PermissionFlags.
+	Permissions []string
+}
+
+// PermissionFlags are the permissions of encrypted PDFs.
+var PermissionFlags = map[string]byte{
+	"print":    gofpdf.CnProtectPrint,
+	"modify":   gofpdf.CnProtectModify,
+	"copy":     gofpdf.CnProtectCopy,
+	"annotate": gofpdf.CnProtectAnnotForms,
+}
+
+// Permissions returns the names of PermissionFlags, sorted.
+func Permissions() []string {
+	names := make([]string, 0, len(PermissionFlags))
+	for name := range PermissionFlags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetMetadata sets the document information of the PDF to m.
+func (g *GoFPDF) SetMetadata(m Metadata) {
+	if m.Standard != "" {
+		m.XMP = true
+	}
+	g.metadata = m
+	g.pdf.SetTitle(m.Title, true)
+	g.pdf.SetAuthor(m.Author, true)
+	g.pdf.SetSubject(m.Subject, true)
+	g.pdf.SetKeywords(m.Keywords, true)
+	if !m.XMP {
+		return
+	}
+	// The dates of the document information and the XMP packet must agree.
+	now := time.Now()
+	g.pdf.SetCreationDate(now)
+	g.pdf.SetModificationDate(now)
+	g.pdf.SetXmpMetadata(xmpPacket(m, now))
+}
+
+// SetProtection encrypts the PDF per p. Note that PDFs are encrypted with 40 bit RC4, which
+// readers honor but is not a protection against a determined attacker.
+func (g *GoFPDF) SetProtection(p Protection) error {
+	if g.metadata.Standard == PDFA2B {
+		return errors.New("PDF/A documents cannot be encrypted")
+	}
+	var flags byte
+	for _, name := range p.Permissions {
+		flag, ok := PermissionFlags[name]
+		if !ok {
+			return fmt.Errorf("unknown permission %q, expected one of %s", name, strings.Join(Permissions(), ", "))
+		}
+		flags |= flag
+	}
+	g.pdf.SetProtection(flags, p.UserPassword, p.OwnerPassword)
+	if g.pdf.Err() {
+		return g.pdf.Error()
+	}
+	return nil
+}
+
+// xmpPacket returns the XMP packet of m for a PDF created at t.
+func xmpPacket(m Metadata, t time.Time) []byte {
+	var b bytes.Buffer
+	b.WriteString(`<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:pdf="http://ns.adobe.com/pdf/1.3/" xmlns:xmp="http://ns.adobe.com/xap/1.0/">
+`)
+	if m.Title != "" {
+		fmt.Fprintf(&b, "<dc:title><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:title>\n", xmlEscape(m.Title))
+	}
+	if m.Author != "" {
+		fmt.Fprintf(&b, "<dc:creator><rdf:Seq><rdf:li>%s</rdf:li></rdf:Seq></dc:creator>\n", xmlEscape(m.Author))
+	}
+	if m.Subject != "" {
+		fmt.Fprintf(&b, "<dc:description><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:description>\n", xmlEscape(m.Subject))
+	}
+	if m.Keywords != "" {
+		fmt.Fprintf(&b, "<pdf:Keywords>%s</pdf:Keywords>\n", xmlEscape(m.Keywords))
+	}
+	// The document information dates have no time zone, so neither do these.
+	date := t.Format("2006-01-02T15:04:05")
+	fmt.Fprintf(&b, "<xmp:CreateDate>%s</xmp:CreateDate>\n<xmp:ModifyDate>%s</xmp:ModifyDate>\n", date, date)
+	if m.Standard == PDFA2B {
+		b.WriteString("<pdfaid:part xmlns:pdfaid=\"http://www.aiim.org/pdfa/ns/id/\">2</pdfaid:part>\n<pdfaid:conformance xmlns:pdfaid=\"http://www.aiim.org/pdfa/ns/id/\">B</pdfaid:conformance>\n")
+	}
+	b.WriteString(`</rdf:Description>
+</rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`)
+	return b.Bytes()
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
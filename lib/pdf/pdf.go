@@ -2,7 +2,10 @@ package pdf
 
 import (
 	"bytes"
+	"fmt"
+	"io"
 	"math"
+	"os"
 	"strings"
 
 	"github.com/jung-kurt/gofpdf"
@@ -13,12 +16,59 @@ import (
 	"oss.terrastruct.com/d2/d2themes"
 	"oss.terrastruct.com/d2/d2themes/d2themescatalog"
 	"oss.terrastruct.com/d2/lib/color"
+	"oss.terrastruct.com/d2/lib/geo"
 )
 
 const TITLE_SEP = "  /  "
 
 type GoFPDF struct {
-	pdf *gofpdf.Fpdf
+	pdf  *gofpdf.Fpdf
+	opts PageOptions
+	// fonts are the families and styles of the fonts added, see setFont.
+	fonts map[string]struct{}
+	// boardPages are the first pages of the boards added, and links the boards the internal
+	// links go to, resolved by Export since boards may span several pages.
+	boardPages map[string]int
+	links      map[int]string
+	pageMap    map[string]int
+	// bookmarked are the boards in the outline, see bookmark.
+	bookmarked map[string]struct{}
+	// metadata is the document information, see SetMetadata.
+	metadata Metadata
+	// pages are the pages added, to stamp with the header and footer once all are.
+	pages []*page
+	// section is the prefix of the IDs of the boards of the current section, see
+	// BeginSection, and sectionName its name.
+	section     string
+	sectionName string
+	sections    int
+	// pageAliases are the placeholders of the page numbers of the boards in the table of
+	// contents, replaced by Export.
+	pageAliases map[string]string
+}
+
+// PageOptions are the options of the pages of a PDF.
+type PageOptions struct {
+	// Size is the size of pages, one of PageSizes. By default each page is sized to its
+	// board.
+	Size string
+	// Orientation is the orientation of pages of a fixed size: portrait, landscape, or by
+	// default the orientation of each board.
+	Orientation string
+	// Margin is the margin around the boards on pages of a fixed size, in points.
+	Margin float64
+	// Tile splits boards too large for pages of a fixed size across pages at full size
+	// rather than shrinking them to fit.
+	Tile bool
+	// Header and Footer are stamped at the top and bottom of every page, see stamp.
+	Header string
+	Footer string
+}
+
+// PageSizes are the fixed sizes of pages, in points.
+var PageSizes = map[string]gofpdf.SizeType{
+	"a4":     {Wd: 595.28, Ht: 841.89},
+	"letter": {Wd: 612, Ht: 792},
 }
 
 type BoardTitle struct {
@@ -26,7 +76,35 @@ type BoardTitle struct {
 	BoardID string
 }
 
-func Init() *GoFPDF {
+// Section is a section of a PDF combining several diagrams, see BeginSection.
+type Section struct {
+	// Name is the name of the section, empty if the PDF has no sections.
+	Name string
+	// Boards are the paths of the boards of the section from its root.
+	Boards [][]BoardTitle
+}
+
+// BeginSection begins a section named name, to which the boards added next belong. The
+// boards of a section are distinct from those of the others, even with the same IDs, and
+// its root board is named name in the outline and table of contents.
+func (g *GoFPDF) BeginSection(name string) {
+	g.sections++
+	g.section = sectionPrefix(g.sections)
+	g.sectionName = name
+}
+
+// sectionPrefix returns the prefix of the IDs of the boards of the nth section.
+func sectionPrefix(n int) string {
+	return fmt.Sprintf("%d:", n)
+}
+
+// key returns the key of the board boardID of the current section in boardPages and links.
+func (g *GoFPDF) key(boardID string) string {
+	return g.section + boardID
+}
+
+// Init returns a new PDF with pages per opts, which may be nil for the default ones.
+func Init(opts *PageOptions) *GoFPDF {
 	newGofPDF := gofpdf.NewCustom(&gofpdf.InitType{
 		UnitStr: "pt",
 	})
@@ -38,7 +116,17 @@ func Init() *GoFPDF {
 	newGofPDF.SetMargins(0, 0, 0)
 
 	fpdf := GoFPDF{
-		pdf: newGofPDF,
+		pdf:         newGofPDF,
+		fonts:       make(map[string]struct{}),
+		boardPages:  make(map[string]int),
+		links:       make(map[int]string),
+		bookmarked:  make(map[string]struct{}),
+		pageAliases: make(map[string]string),
+	}
+	if opts != nil {
+		fpdf.opts = *opts
+		fpdf.opts.Size = strings.ToLower(opts.Size)
+		fpdf.opts.Orientation = strings.ToLower(opts.Orientation)
 	}
 
 	return &fpdf
@@ -66,7 +154,9 @@ func (g *GoFPDF) GetFillRGB(themeID int64, fill string) (color.RGB, error) {
 	return color.Hex2RGB(fill)
 }
 
-func (g *GoFPDF) AddPDFPage(png []byte, titlePath []BoardTitle, themeID int64, fill string, shapes []d2target.Shape, pad int64, viewboxX, viewboxY float64, pageMap map[string]int, includeNav bool) error {
+// AddPDFPage adds the pages of a board drawn from its PNG screenshot, pngScale being the
+// number of pixels of the screenshot per pixel of the board.
+func (g *GoFPDF) AddPDFPage(png []byte, pngScale float64, titlePath []BoardTitle, themeID int64, fill string, shapes []d2target.Shape, pad int64, viewboxX, viewboxY float64, pageMap map[string]int, includeNav bool) error {
 	var opt gofpdf.ImageOptions
 	opt.ImageType = "png"
 	boardPath := make([]string, len(titlePath))
@@ -77,40 +167,169 @@ func (g *GoFPDF) AddPDFPage(png []byte, titlePath []BoardTitle, themeID int64, f
 	if g.pdf.Err() {
 		return g.pdf.Error()
 	}
-	imageWidth := imageInfo.Width() / 2
-	imageHeight := imageInfo.Height() / 2
+	imageWidth := imageInfo.Width() / pngScale
+	imageHeight := imageInfo.Height() / pngScale
+
+	return g.addBoard(&board{
+		titlePath: titlePath,
+		themeID:   themeID,
+		fill:      fill,
+		width:     imageWidth,
+		height:    imageHeight,
+		shapes:    shapes,
+		x:         viewboxX,
+		y:         viewboxY,
+		scale:     1,
+		draw: func(x, y, scale float64) {
+			g.pdf.ImageOptions(strings.Join(boardPath, "/"), x, y, imageWidth*scale, imageHeight*scale, false, opt, 0, "")
+		},
+	}, pageMap, includeNav)
+}
+
+// board is a board to add the pages of, see addBoard.
+type board struct {
+	titlePath []BoardTitle
+	themeID   int64
+	fill      string
+
+	// width and height are the size of the board on the page if it fits.
+	width, height float64
+	// shapes are made clickable, x and y being the top left of the board and scale the scale
+	// of the board in their coordinates.
+	shapes []d2target.Shape
+	x, y   float64
+	scale  float64
+
+	// draw draws the board shrunk by scale with its top left at x, y.
+	draw func(x, y, scale float64)
+}
+
+// addBoard adds the pages of b: a page sized to it, or pages of the size of the options
+// that it is shrunk to fit on or tiled across.
+func (g *GoFPDF) addBoard(b *board, pageMap map[string]int, includeNav bool) error {
+	boardID := g.key(b.titlePath[len(b.titlePath)-1].BoardID)
+	if _, ok := g.boardPages[boardID]; !ok {
+		g.boardPages[boardID] = g.pdf.PageNo() + 1
+	}
+	g.pageMap = pageMap
+
+	headerWidth, headerHeight := g.headerSize(b.titlePath, includeNav)
+	top, bottom := g.stampHeights()
+
+	size, ok := PageSizes[g.opts.Size]
+	if !ok {
+		minPageDimension := 576.0
+		pageWidth := math.Max(math.Max(minPageDimension, b.width), headerWidth)
+		pageHeight := math.Max(minPageDimension, b.height)
+
+		p, err := g.addPage(b.titlePath, "", b.themeID, b.fill, pageWidth, top+headerHeight+pageHeight+bottom, pageMap, includeNav)
+		if err != nil {
+			return err
+		}
+		x := (pageWidth - b.width) / 2
+		y := top + headerHeight + (pageHeight-b.height)/2
+		b.draw(x, y, 1)
+		g.drawLinks(b.shapes, x-b.x*b.scale, y-b.y*b.scale, b.scale, nil, pageMap)
+		g.drawSeparator(p)
+		return nil
+	}
+
+	landscape := g.opts.Orientation == "landscape" || g.opts.Orientation != "portrait" && b.width > b.height
+	if landscape {
+		size.Wd, size.Ht = size.Ht, size.Wd
+	}
+	margin := g.opts.Margin
+	area := gofpdf.SizeType{
+		Wd: math.Max(1, size.Wd-2*margin),
+		Ht: math.Max(1, size.Ht-top-headerHeight-bottom-2*margin),
+	}
 
-	// calculate page dimensions
-	var pageWidth float64
-	var pageHeight float64
+	if !g.opts.Tile || b.width <= area.Wd && b.height <= area.Ht {
+		scale := math.Min(1, math.Min(area.Wd/b.width, area.Ht/b.height))
+		p, err := g.addPage(b.titlePath, "", b.themeID, b.fill, size.Wd, size.Ht, pageMap, includeNav)
+		if err != nil {
+			return err
+		}
+		x := margin + (area.Wd-b.width*scale)/2
+		y := top + headerHeight + margin + (area.Ht-b.height*scale)/2
+		b.draw(x, y, scale)
+		g.drawLinks(b.shapes, x-b.x*b.scale*scale, y-b.y*b.scale*scale, b.scale*scale, nil, pageMap)
+		g.drawSeparator(p)
+		return nil
+	}
+
+	cols := int(math.Ceil(b.width / area.Wd))
+	rows := int(math.Ceil(b.height / area.Ht))
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			tile := fmt.Sprintf(" (%d/%d)", row*cols+col+1, rows*cols)
+			p, err := g.addPage(b.titlePath, tile, b.themeID, b.fill, size.Wd, size.Ht, pageMap, includeNav)
+			if err != nil {
+				return err
+			}
+			clip := geo.NewBox(geo.NewPoint(margin, top+headerHeight+margin), area.Wd, area.Ht)
+			x := clip.TopLeft.X - float64(col)*area.Wd
+			y := clip.TopLeft.Y - float64(row)*area.Ht
+			g.pdf.ClipRect(clip.TopLeft.X, clip.TopLeft.Y, clip.Width, clip.Height, false)
+			b.draw(x, y, 1)
+			g.pdf.ClipEnd()
+			g.drawLinks(b.shapes, x-b.x*b.scale, y-b.y*b.scale, b.scale, clip, pageMap)
+			g.drawSeparator(p)
+		}
+	}
+	return nil
+}
 
+// page is a page being added, see addPage.
+type page struct {
+	width, height float64
+	// top is the height of the band of the header stamp above the header.
+	top          float64
+	headerMargin float64
+	headerHeight float64
+	fill         color.RGB
+
+	titlePath  []BoardTitle
+	tile       string
+	includeNav bool
+}
+
+// headerSize returns the size of the header of the pages of the board at titlePath.
+func (g *GoFPDF) headerSize(titlePath []BoardTitle, includeNav bool) (width, height float64) {
+	if !includeNav {
+		return 0, 0
+	}
+	boardPath := make([]string, len(titlePath))
+	for i, t := range titlePath {
+		boardPath[i] = t.Name
+	}
 	g.pdf.SetFont("source", "B", 14)
-	pathString := strings.Join(boardPath, "  /  ")
+	return g.pdf.GetStringWidth(strings.Join(boardPath, TITLE_SEP)) + 2*28, 72
+}
+
+// addPage adds a page of width by height with the header of the board at titlePath, tile
+// following its name.
+func (g *GoFPDF) addPage(titlePath []BoardTitle, tile string, themeID int64, fill string, pageWidth, pageHeight float64, pageMap map[string]int, includeNav bool) (*page, error) {
 	headerMargin := 28.0
-	headerWidth := g.pdf.GetStringWidth(pathString) + 2*headerMargin
-	headerHeight := 72.0
+	_, headerHeight := g.headerSize(titlePath, includeNav)
 	if !includeNav {
 		headerMargin = 0.
-		headerWidth = 0.
-		headerHeight = 0.
 	}
 
-	minPageDimension := 576.0
-	pageWidth = math.Max(math.Max(minPageDimension, imageWidth), headerWidth)
-	pageHeight = math.Max(minPageDimension, imageHeight)
-
 	fillRGB, err := g.GetFillRGB(themeID, fill)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Add page
-	g.pdf.AddPageFormat("", gofpdf.SizeType{Wd: pageWidth, Ht: pageHeight + headerHeight})
+	g.pdf.AddPageFormat("", gofpdf.SizeType{Wd: pageWidth, Ht: pageHeight})
+	g.bookmark(titlePath)
+	top, _ := g.stampHeights()
 
 	if includeNav {
 		// Draw header
 		g.pdf.SetFillColor(int(fillRGB.Red), int(fillRGB.Green), int(fillRGB.Blue))
-		g.pdf.Rect(0, 0, pageWidth, pageHeight+headerHeight, "F")
+		g.pdf.Rect(0, 0, pageWidth, pageHeight, "F")
 		if fillRGB.IsLight() {
 			g.pdf.SetTextColor(10, 15, 37) // steel-900
 		} else {
@@ -122,17 +341,16 @@ func (g *GoFPDF) AddPDFPage(png []byte, titlePath []BoardTitle, themeID int64, f
 		prefixWidth := headerMargin
 		if len(titlePath) > 1 {
 			for _, t := range titlePath[:len(titlePath)-1] {
-				g.pdf.SetXY(prefixWidth, 0)
+				g.pdf.SetXY(prefixWidth, top)
 				w := g.pdf.GetStringWidth(t.Name)
 				var linkID int
-				if pageNum, ok := pageMap[t.BoardID]; ok {
-					linkID = g.pdf.AddLink()
-					g.pdf.SetLink(linkID, 0, pageNum+1)
+				if _, ok := pageMap[t.BoardID]; ok {
+					linkID = g.link(t.BoardID)
 				}
 				g.pdf.CellFormat(w, headerHeight, t.Name, "", 0, "", false, linkID, "")
 				prefixWidth += w
 
-				g.pdf.SetXY(prefixWidth, 0)
+				g.pdf.SetXY(prefixWidth, top)
 				w = g.pdf.GetStringWidth(TITLE_SEP)
 				g.pdf.CellFormat(prefixWidth, headerHeight, TITLE_SEP, "", 0, "", false, 0, "")
 				prefixWidth += w
@@ -140,55 +358,259 @@ func (g *GoFPDF) AddPDFPage(png []byte, titlePath []BoardTitle, themeID int64, f
 		}
 
 		// Draw board name
-		boardName := boardPath[len(boardPath)-1]
+		boardName := titlePath[len(titlePath)-1].Name + tile
 		g.pdf.SetFont("source", "B", 14)
-		g.pdf.SetXY(prefixWidth, 0)
+		g.pdf.SetXY(prefixWidth, top)
 		g.pdf.CellFormat(pageWidth-prefixWidth-headerMargin, headerHeight, boardName, "", 0, "", false, 0, "")
 	}
 
-	// Draw image
-	imageX := (pageWidth - imageWidth) / 2
-	imageY := headerHeight + (pageHeight-imageHeight)/2
-	g.pdf.ImageOptions(strings.Join(boardPath, "/"), imageX, imageY, imageWidth, imageHeight, false, opt, 0, "")
+	p := &page{
+		width:        pageWidth,
+		height:       pageHeight,
+		top:          top,
+		headerMargin: headerMargin,
+		headerHeight: headerHeight,
+		fill:         fillRGB,
+		titlePath:    titlePath,
+		tile:         tile,
+		includeNav:   includeNav,
+	}
+	g.pages = append(g.pages, p)
+	return p, nil
+}
+
+// bookmark adds the boards of titlePath not yet in the outline to it, at the current page.
+// Boards without pages of their own, like those only holding other boards, are so in the
+// outline at the first page of the first of them.
+func (g *GoFPDF) bookmark(titlePath []BoardTitle) {
+	// The outline is encoded as UTF-16 only while a UTF-8 font is set.
+	g.pdf.SetFont("source", "", 14)
+	for i, t := range titlePath {
+		key := g.key(t.BoardID)
+		if _, ok := g.bookmarked[key]; ok {
+			continue
+		}
+		g.bookmarked[key] = struct{}{}
+		name := boardName(t)
+		if i == 0 && g.section != "" {
+			// The root board of a section is bookmarked as the section.
+			name = g.sectionName
+		}
+		g.pdf.Bookmark(name, i, 0)
+	}
+}
+
+// boardName returns the name of the board t, falling back to its ID's last part.
+func boardName(t BoardTitle) string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return t.BoardID[strings.LastIndex(t.BoardID, ".")+1:]
+}
 
-	// Draw links
+// AddTOCPages adds pages listing the boards of sections with links to them and their page
+// numbers. They must be added before the boards, and the sections begun in the same order.
+func (g *GoFPDF) AddTOCPages(sections []Section, themeID int64, fill string) error {
+	type entry struct {
+		name  string
+		key   string
+		depth int
+	}
+	var entries []entry
+	for i, section := range sections {
+		var prefix string
+		if section.Name != "" {
+			prefix = sectionPrefix(i + 1)
+		}
+		for _, path := range section.Boards {
+			t := path[len(path)-1]
+			name := boardName(t)
+			if len(path) == 1 && section.Name != "" {
+				name = section.Name
+			}
+			entries = append(entries, entry{name: name, key: prefix + t.BoardID, depth: len(path) - 1})
+		}
+	}
+
+	size, ok := PageSizes[g.opts.Size]
+	if !ok {
+		size = PageSizes["letter"]
+	}
+	if g.opts.Orientation == "landscape" {
+		size.Wd, size.Ht = size.Ht, size.Wd
+	}
+	titlePath := []BoardTitle{{Name: "Contents", BoardID: "contents"}}
+
+	const lineHeight = 20.
+	const indent = 16.
+	const pageNumWidth = 40.
+	_, bottom := g.stampHeights()
+	var p *page
+	var y float64
+	for i, e := range entries {
+		if p == nil || y+lineHeight > size.Ht-bottom-p.headerMargin {
+			tile := ""
+			if p != nil {
+				tile = " (continued)"
+			}
+			var err error
+			p, err = g.addPage(titlePath, tile, themeID, fill, size.Wd, size.Ht, nil, true)
+			if err != nil {
+				return err
+			}
+			g.drawSeparator(p)
+			y = p.top + p.headerHeight + p.headerMargin/2
+		}
+
+		x := p.headerMargin + indent*float64(e.depth)
+		if e.depth == 0 {
+			g.pdf.SetFont("source", "B", 12)
+		} else {
+			g.pdf.SetFont("source", "", 12)
+		}
+		linkID := g.linkKey(e.key)
+		g.pdf.SetXY(x, y)
+		g.pdf.CellFormat(math.Max(0, size.Wd-p.headerMargin-pageNumWidth-x), lineHeight, e.name, "", 0, "", false, linkID, "")
+
+		// Page numbers are only known once the boards are added.
+		alias := fmt.Sprintf("{d2page%d}", i)
+		g.pageAliases[alias] = e.key
+		g.pdf.SetXY(size.Wd-p.headerMargin-pageNumWidth, y)
+		g.pdf.CellFormat(pageNumWidth, lineHeight, alias, "", 0, "", false, linkID, "")
+		y += lineHeight
+	}
+	return nil
+}
+
+// link returns a new internal link to the board boardID of the current section.
+func (g *GoFPDF) link(boardID string) int {
+	return g.linkKey(g.key(boardID))
+}
+
+// linkKey returns a new internal link to the board of key, see key.
+func (g *GoFPDF) linkKey(key string) int {
+	linkID := g.pdf.AddLink()
+	g.links[linkID] = key
+	return linkID
+}
+
+// drawLinks makes the shapes with links clickable, the shapes being scaled by scale and then
+// offset by dx, dy on the page. When clip is not nil, only the parts of shapes within it are.
+func (g *GoFPDF) drawLinks(shapes []d2target.Shape, dx, dy, scale float64, clip *geo.Box, pageMap map[string]int) {
 	for _, shape := range shapes {
 		if shape.Link == "" {
 			continue
 		}
 
-		linkX := imageX + float64(shape.Pos.X) - viewboxX - float64(shape.StrokeWidth)
-		linkY := imageY + float64(shape.Pos.Y) - viewboxY - float64(shape.StrokeWidth)
-		linkWidth := float64(shape.Width) + float64(shape.StrokeWidth*2)
-		linkHeight := float64(shape.Height) + float64(shape.StrokeWidth*2)
+		linkX := dx + (float64(shape.Pos.X)-float64(shape.StrokeWidth))*scale
+		linkY := dy + (float64(shape.Pos.Y)-float64(shape.StrokeWidth))*scale
+		linkWidth := (float64(shape.Width) + float64(shape.StrokeWidth*2)) * scale
+		linkHeight := (float64(shape.Height) + float64(shape.StrokeWidth*2)) * scale
+		if clip != nil {
+			x1 := math.Max(linkX, clip.TopLeft.X)
+			y1 := math.Max(linkY, clip.TopLeft.Y)
+			x2 := math.Min(linkX+linkWidth, clip.TopLeft.X+clip.Width)
+			y2 := math.Min(linkY+linkHeight, clip.TopLeft.Y+clip.Height)
+			if x2 <= x1 || y2 <= y1 {
+				continue
+			}
+			linkX, linkY, linkWidth, linkHeight = x1, y1, x2-x1, y2-y1
+		}
 
 		key, err := d2parser.ParseKey(shape.Link)
 		if err != nil || key.Path[0].Unbox().ScalarString() != "root" {
 			// External link
-			g.pdf.LinkString(linkX, linkY, linkWidth, linkHeight, shape.Link)
+			g.pdf.LinkString(linkX, linkY, linkWidth, linkHeight, uri(shape.Link))
 		} else {
 			// Internal link
-			if pageNum, ok := pageMap[shape.Link]; ok {
-				linkID := g.pdf.AddLink()
-				g.pdf.SetLink(linkID, 0, pageNum+1)
-				g.pdf.Link(linkX, linkY, linkWidth, linkHeight, linkID)
+			if _, ok := pageMap[shape.Link]; ok {
+				g.pdf.Link(linkX, linkY, linkWidth, linkHeight, g.link(shape.Link))
 			}
 		}
 	}
+}
+
+// uri returns link as a URI of a link annotation, which must be ASCII, percent-encoding
+// the bytes of other characters, spaces and control characters.
+func uri(link string) string {
+	var b strings.Builder
+	for i := 0; i < len(link); i++ {
+		c := link[i]
+		if c <= ' ' || c >= 0x7f {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
 
-	// Draw header/img separator
-	g.pdf.SetXY(headerMargin, headerHeight)
+// drawSeparator draws the separator between the header of p and its board.
+func (g *GoFPDF) drawSeparator(p *page) {
+	g.pdf.SetXY(p.headerMargin, p.top+p.headerHeight)
 	g.pdf.SetLineWidth(1)
-	if fillRGB.IsLight() {
+	if p.fill.IsLight() {
 		g.pdf.SetDrawColor(10, 15, 37) // steel-900
 	} else {
 		g.pdf.SetDrawColor(255, 255, 255)
 	}
-	g.pdf.CellFormat(pageWidth-(headerMargin*2), 1, "", "T", 0, "", false, 0, "")
+	g.pdf.CellFormat(p.width-(p.headerMargin*2), 1, "", "T", 0, "", false, 0, "")
+}
 
-	return nil
+// firstPage returns the first page of the board of key, which for boards without pages
+// of their own is the first page of the boards within them.
+func (g *GoFPDF) firstPage(key string) int {
+	if pageNum, ok := g.boardPages[key]; ok {
+		return pageNum
+	}
+	pageNum := 0
+	for id, n := range g.boardPages {
+		if strings.HasPrefix(id, key+".") && (pageNum == 0 || n < pageNum) {
+			pageNum = n
+		}
+	}
+	if pageNum == 0 {
+		pageNum = g.pageMap[key] + 1
+	}
+	return pageNum
+}
+
+// resolveLinks points the internal links at the first pages of their boards and fills in
+// the page numbers of the table of contents.
+func (g *GoFPDF) resolveLinks() {
+	for linkID, key := range g.links {
+		g.pdf.SetLink(linkID, 0, g.firstPage(key))
+	}
+	for alias, key := range g.pageAliases {
+		g.pdf.RegisterAlias(alias, fmt.Sprint(g.firstPage(key)))
+	}
 }
 
 func (g *GoFPDF) Export(outputPath string) error {
-	return g.pdf.OutputFileAndClose(outputPath)
+	var buf bytes.Buffer
+	err := g.ExportTo(&buf)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outputPath, buf.Bytes(), 0666)
+}
+
+// ExportTo writes the document to w, like Export.
+func (g *GoFPDF) ExportTo(w io.Writer) error {
+	g.resolveLinks()
+	g.stamp()
+	if !g.metadata.XMP {
+		return g.pdf.Output(w)
+	}
+	var buf bytes.Buffer
+	err := g.pdf.Output(&buf)
+	if err != nil {
+		return err
+	}
+	out, err := g.finish(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
 }
@@ -0,0 +1,118 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"oss.terrastruct.com/util-go/assert"
+)
+
+func TestDrawLinks(t *testing.T) {
+	t.Parallel()
+
+	diagram := compile(t, `a: {link: https://d2lang.com/tour/links?x=(y)}
+b: {link: https://example.com/ガイド page}
+c: {link: layers.x}
+layers: {
+  x: {d}
+}
+`)
+	g := Init(nil)
+	pageMap := map[string]int{"root": 0, "root.layers.x": 1}
+	err := g.AddVectorPage(diagram, nil, []BoardTitle{{Name: "root", BoardID: "root"}}, 0, "", 100, 1, pageMap, true)
+	assert.Success(t, err)
+	err = g.AddVectorPage(diagram.Layers[0], nil, []BoardTitle{{Name: "root", BoardID: "root"}, {Name: "x", BoardID: "root.layers.x"}}, 0, "", 100, 1, pageMap, true)
+	assert.Success(t, err)
+	g.resolveLinks()
+	g.pdf.SetCompression(false)
+	var buf bytes.Buffer
+	err = g.pdf.Output(&buf)
+	assert.Success(t, err)
+
+	for _, annot := range []string{
+		`/A <</S /URI /URI (https://d2lang.com/tour/links?x=\(y\))>>`,
+		`/A <</S /URI /URI (https://example.com/%E3%82%AC%E3%82%A4%E3%83%89%20page)>>`,
+		// The internal link to layer x, on the second page.
+		`/Dest [5 0 R`,
+	} {
+		if !bytes.Contains(buf.Bytes(), []byte(annot)) {
+			t.Errorf("expected annotation %s", annot)
+		}
+	}
+}
+
+func TestPDFA(t *testing.T) {
+	t.Parallel()
+
+	diagram := compile(t, `a: {link: https://d2lang.com}`)
+	g := Init(nil)
+	g.SetMetadata(Metadata{Title: "Title", Standard: PDFA2B})
+	err := g.SetProtection(Protection{UserPassword: "secret"})
+	assert.Error(t, err)
+	err = g.AddVectorPage(diagram, nil, []BoardTitle{{Name: "root", BoardID: "root"}}, 0, "", 100, 1, nil, true)
+	assert.Success(t, err)
+	var buf bytes.Buffer
+	err = g.pdf.Output(&buf)
+	assert.Success(t, err)
+	out, err := g.finish(buf.Bytes())
+	assert.Success(t, err)
+
+	for _, s := range []string{
+		"%PDF-1.4\n%\xe2\xe3\xcf\xd3\n",
+		"/Metadata ",
+		"/OutputIntents [",
+		"<pdfaid:part xmlns:pdfaid=\"http://www.aiim.org/pdfa/ns/id/\">2</pdfaid:part>",
+		"/Type /Annot /Subtype /Link /F 4 ",
+		"/ID [<",
+	} {
+		if !bytes.Contains(out, []byte(s)) {
+			t.Errorf("expected %q", s)
+		}
+	}
+
+	// The rewritten PDF must be readable, its objects being at the offsets of its
+	// cross-reference table.
+	doc, err := parseObjects(out)
+	assert.Success(t, err)
+	assert.Equal(t, len(doc.objects), bytes.Count(out, []byte(" 0 obj\n")))
+
+	profile := srgbProfile()
+	assert.Equal(t, len(profile), int(binary.BigEndian.Uint32(profile)))
+	assert.Equal(t, "acsp", string(profile[36:40]))
+}
+
+func TestStamp(t *testing.T) {
+	t.Parallel()
+
+	diagram := compile(t, `a -> b`)
+	g := Init(&PageOptions{Header: "{title}|{board}", Footer: "Page {page} of {pages}"})
+	g.SetMetadata(Metadata{Title: "Handbook"})
+	for _, titlePath := range [][]BoardTitle{
+		{{Name: "root", BoardID: "root"}},
+		{{Name: "root", BoardID: "root"}, {Name: "x", BoardID: "root.layers.x"}},
+	} {
+		err := g.AddVectorPage(diagram, nil, titlePath, 0, "", 100, 1, nil, true)
+		assert.Success(t, err)
+	}
+	g.stamp()
+	g.pdf.SetCompression(false)
+	var buf bytes.Buffer
+	err := g.pdf.Output(&buf)
+	assert.Success(t, err)
+
+	for _, s := range []string{"Handbook", "root" + TITLE_SEP + "x", "Page 1 of 2", "Page 2 of 2"} {
+		if !bytes.Contains(buf.Bytes(), utf16(s)) {
+			t.Errorf("expected %q to be stamped", s)
+		}
+	}
+}
+
+// utf16 returns s as gofpdf writes the text of UTF-8 fonts.
+func utf16(s string) []byte {
+	var b []byte
+	for _, r := range s {
+		b = append(b, byte(r>>8), byte(r))
+	}
+	return b
+}
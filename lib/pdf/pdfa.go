@@ -0,0 +1,250 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// PDFA2B is the standard of PDF/A-2b archival PDFs.
+const PDFA2B = "pdfa-2b"
+
+// Standards are the standards PDFs can conform to.
+var Standards = []string{PDFA2B}
+
+// finish makes the changes to the output of gofpdf it has no API for: referencing the XMP
+// packet from the catalog and, for PDF/A, the binary header comment, the sRGB output intent,
+// printable link annotations and the file identifier.
+//
+// The objects are rewritten in order with a new cross-reference table rather than appended
+// as an incremental update, since the header comment must come first.
+func (g *GoFPDF) finish(in []byte) ([]byte, error) {
+	doc, err := parseObjects(in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rewrite PDF: %w", err)
+	}
+	pdfa := g.metadata.Standard == PDFA2B
+
+	var catalog []byte
+	if xmp := doc.find("/Type /Metadata /Subtype /XML"); xmp != 0 {
+		catalog = append(catalog, fmt.Sprintf("/Metadata %d 0 R\n", xmp)...)
+	}
+	if pdfa {
+		profile := srgbProfile()
+		icc := doc.add(fmt.Sprintf("<< /N 3 /Length %d >>\nstream\n%s\nendstream", len(profile), profile))
+		intent := doc.add(fmt.Sprintf("<< /Type /OutputIntent /S /GTS_PDFA1 /OutputConditionIdentifier (sRGB IEC61966-2.1) /Info (sRGB IEC61966-2.1) /DestOutputProfile %d 0 R >>", icc))
+		catalog = append(catalog, fmt.Sprintf("/OutputIntents [%d 0 R]\n", intent)...)
+
+		for i, obj := range doc.objects {
+			// Annotations must be printable, and are in the dictionaries of pages.
+			if !bytes.Contains(obj, []byte("\nstream\n")) {
+				doc.objects[i] = bytes.ReplaceAll(obj, []byte("/Type /Annot /Subtype /Link "), []byte("/Type /Annot /Subtype /Link /F 4 "))
+			}
+		}
+	}
+	root := doc.objects[doc.root-1]
+	end := bytes.LastIndex(root, []byte(">>"))
+	doc.objects[doc.root-1] = append(append(append([]byte(nil), root[:end]...), catalog...), root[end:]...)
+
+	var out bytes.Buffer
+	out.Write(doc.header)
+	if pdfa {
+		out.WriteString("%\xe2\xe3\xcf\xd3\n")
+	}
+	offsets := make([]int, len(doc.objects))
+	for i, obj := range doc.objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n", i+1)
+		out.Write(obj)
+		out.WriteString("\nendobj\n")
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(doc.objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<<\n/Size %d\n", len(doc.objects)+1)
+	out.Write(doc.trailer)
+	if !bytes.Contains(doc.trailer, []byte("/ID ")) {
+		id := fmt.Sprintf("%x", md5.Sum(in))
+		fmt.Fprintf(&out, "/ID [<%s> <%s>]\n", id, id)
+	}
+	fmt.Fprintf(&out, ">>\nstartxref\n%d\n%%%%EOF\n", xref)
+	return out.Bytes(), nil
+}
+
+// objects are the objects of a PDF written by gofpdf.
+type objects struct {
+	header []byte
+	// objects are the objects without their obj and endobj keywords, by number from 1.
+	objects [][]byte
+	root    int
+	// trailer is the trailer dictionary without its delimiters and size.
+	trailer []byte
+}
+
+var (
+	xrefRe    = regexp.MustCompile(`(?s)^\nxref\n0 (\d+)\n(.*)trailer\n<<\n/Size \d+\n(.*)>>\nstartxref\n`)
+	objRe     = regexp.MustCompile(`^(\d+) 0 obj\n`)
+	endobjRe  = regexp.MustCompile(`\s*endobj\s*$`)
+	rootRe    = regexp.MustCompile(`/Root (\d+) 0 R`)
+	errNotPDF = errors.New("unexpected structure")
+)
+
+// parseObjects splits pdf, a PDF written by gofpdf, into its objects. gofpdf writes a single
+// cross-reference table listing them all, so they are found at its offsets.
+func parseObjects(pdf []byte) (*objects, error) {
+	// The cross-reference table follows the objects, so it is the last one even if streams
+	// happen to contain its keyword.
+	xref := bytes.LastIndex(pdf, []byte("\nxref\n"))
+	if xref == -1 {
+		return nil, errNotPDF
+	}
+	m := xrefRe.FindSubmatchIndex(pdf[xref:])
+	if m == nil {
+		return nil, errNotPDF
+	}
+	for i := range m {
+		m[i] += xref
+	}
+	n, _ := strconv.Atoi(string(pdf[m[2]:m[3]]))
+	entries := bytes.Split(bytes.TrimSuffix(pdf[m[4]:m[5]], []byte("\n")), []byte("\n"))
+	if n < 2 || len(entries) != n {
+		return nil, errNotPDF
+	}
+	offsets := make([]int, n-1)
+	for i, e := range entries[1:] {
+		fields := bytes.Fields(e)
+		if len(fields) != 3 {
+			return nil, errNotPDF
+		}
+		offset, err := strconv.Atoi(string(fields[0]))
+		if err != nil || offset >= m[0] {
+			return nil, errNotPDF
+		}
+		offsets[i] = offset
+	}
+	ends := append([]int{m[0]}, offsets...)
+	sort.Ints(ends)
+
+	doc := &objects{
+		header:  pdf[:bytes.IndexByte(pdf, '\n')+1],
+		objects: make([][]byte, n-1),
+		trailer: pdf[m[6]:m[7]],
+	}
+	for i, offset := range offsets {
+		end := ends[sort.SearchInts(ends, offset+1)]
+		obj := pdf[offset:end]
+		om := objRe.FindSubmatch(obj)
+		if om == nil || string(om[1]) != strconv.Itoa(i+1) {
+			return nil, errNotPDF
+		}
+		obj = obj[len(om[0]):]
+		obj = obj[:endobjRe.FindIndex(obj)[0]]
+		doc.objects[i] = obj
+	}
+	rm := rootRe.FindSubmatch(doc.trailer)
+	if rm == nil {
+		return nil, errNotPDF
+	}
+	doc.root, _ = strconv.Atoi(string(rm[1]))
+	if doc.root < 1 || doc.root > len(doc.objects) {
+		return nil, errNotPDF
+	}
+	return doc, nil
+}
+
+// find returns the number of the first object starting with the dictionary entries prefix,
+// 0 if none does.
+func (doc *objects) find(prefix string) int {
+	for i, obj := range doc.objects {
+		if bytes.HasPrefix(bytes.TrimLeft(obj, "<\n "), []byte(prefix)) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// add adds the object obj, returning its number.
+func (doc *objects) add(obj string) int {
+	doc.objects = append(doc.objects, []byte(obj))
+	return len(doc.objects)
+}
+
+// srgbProfile returns an ICC version 2 display profile of the sRGB color space, which the
+// colors of PDFs are in.
+func srgbProfile() []byte {
+	be := binary.BigEndian
+	xyz := func(x, y, z float64) []byte {
+		b := []byte("XYZ \x00\x00\x00\x00")
+		for _, v := range []float64{x, y, z} {
+			b = be.AppendUint32(b, uint32(int32(math.Round(v*65536))))
+		}
+		return b
+	}
+	desc := []byte("desc\x00\x00\x00\x00")
+	name := "sRGB IEC61966-2.1"
+	desc = be.AppendUint32(desc, uint32(len(name)+1))
+	desc = append(desc, name+"\x00"...)
+	// No Unicode nor ScriptCode descriptions.
+	desc = append(desc, make([]byte, 4+4+2+1+67)...)
+	cprt := []byte("text\x00\x00\x00\x00No copyright, use freely\x00")
+	trc := []byte("curv\x00\x00\x00\x00")
+	trc = be.AppendUint32(trc, 1024)
+	for i := 0; i < 1024; i++ {
+		v := float64(i) / 1023
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		trc = be.AppendUint16(trc, uint16(math.Round(v*65535)))
+	}
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", desc},
+		{"cprt", cprt},
+		// D50, which the primaries are chromatically adapted to.
+		{"wtpt", xyz(0.9642, 1, 0.8249)},
+		{"rXYZ", xyz(0.4360747, 0.2225045, 0.0139322)},
+		{"gXYZ", xyz(0.3850649, 0.7168786, 0.0971045)},
+		{"bXYZ", xyz(0.1430804, 0.0606169, 0.7141733)},
+		{"rTRC", trc},
+		{"gTRC", trc},
+		{"bTRC", trc},
+	}
+
+	header := make([]byte, 128)
+	be.PutUint32(header[8:], 0x02100000)
+	copy(header[12:], "mntrRGB XYZ ")
+	// 2024-01-01 00:00:00
+	be.PutUint16(header[24:], 2024)
+	be.PutUint16(header[26:], 1)
+	be.PutUint16(header[28:], 1)
+	copy(header[36:], "acsp")
+	copy(header[68:], xyz(0.9642, 1, 0.8249)[8:])
+
+	table := be.AppendUint32(nil, uint32(len(tags)))
+	offset := len(header) + 4 + 12*len(tags)
+	var data []byte
+	for _, t := range tags {
+		table = append(table, t.sig...)
+		table = be.AppendUint32(table, uint32(offset+len(data)))
+		table = be.AppendUint32(table, uint32(len(t.data)))
+		data = append(data, t.data...)
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+	}
+	profile := append(append(header, table...), data...)
+	be.PutUint32(profile, uint32(len(profile)))
+	return profile
+}
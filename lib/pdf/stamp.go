@@ -0,0 +1,101 @@
+package pdf
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// stampHeight is the height of the bands of the header and footer stamps.
+	stampHeight   = 24.
+	stampMargin   = 28.
+	stampFontSize = 9.
+)
+
+// stampHeights returns the heights of the bands of the header and footer stamps at the top
+// and bottom of pages, 0 for those there are none of.
+func (g *GoFPDF) stampHeights() (top, bottom float64) {
+	if g.opts.Header != "" {
+		top = stampHeight
+	}
+	if g.opts.Footer != "" {
+		bottom = stampHeight
+	}
+	return top, bottom
+}
+
+// stamp stamps the header and footer on every page, once all are added so that the number
+// of pages is known.
+//
+// Each is up to three parts separated by |, aligned left, center and right, or centered if
+// one. Fields in them are replaced: {board} by the path of the board on the page, {title}
+// by the title of the document or else of the root board, {date} by the date, {page} by the
+// number of the page and {pages} by the number of pages.
+func (g *GoFPDF) stamp() {
+	top, bottom := g.stampHeights()
+	if (top == 0 && bottom == 0) || len(g.pages) == 0 {
+		return
+	}
+	last := g.pages[len(g.pages)-1]
+	title := g.metadata.Title
+	if title == "" {
+		title = boardName(last.titlePath[0])
+	}
+	date := time.Now().Format("2006-01-02")
+
+	for i, p := range g.pages {
+		boardPath := make([]string, len(p.titlePath))
+		for j, t := range p.titlePath {
+			boardPath[j] = boardName(t)
+		}
+		fields := strings.NewReplacer(
+			"{board}", strings.Join(boardPath, TITLE_SEP)+p.tile,
+			"{title}", title,
+			"{date}", date,
+			"{page}", strconv.Itoa(i+1),
+			"{pages}", strconv.Itoa(len(g.pages)),
+		)
+
+		g.pdf.SetPage(i + 1)
+		// gofpdf only sets the font and colors of the page when they change, which they may
+		// have on the pages added since.
+		g.pdf.SetFont("source", "", stampFontSize+1)
+		g.pdf.SetFont("source", "", stampFontSize)
+		if !p.includeNav || p.fill.IsLight() {
+			g.pdf.SetTextColor(10, 15, 37) // steel-900
+			g.pdf.SetFillColor(10, 15, 37)
+		} else {
+			g.pdf.SetTextColor(255, 255, 255)
+			g.pdf.SetFillColor(255, 255, 255)
+		}
+		// gofpdf places what is drawn on the page by the height of the last page.
+		dy := last.height - p.height
+		if top > 0 {
+			g.stampText(fields, g.opts.Header, p.width, dy, top)
+		}
+		if bottom > 0 {
+			g.stampText(fields, g.opts.Footer, p.width, dy+p.height-bottom, bottom)
+		}
+	}
+}
+
+// stampText draws the parts of the header or footer s in the band of height at y.
+func (g *GoFPDF) stampText(fields *strings.Replacer, s string, pageWidth, y, height float64) {
+	parts := strings.SplitN(s, "|", 3)
+	// Baseline of text vertically centered in the band.
+	y += height/2 + stampFontSize*0.35
+	for i, part := range parts {
+		text := strings.TrimSpace(fields.Replace(part))
+		w := g.pdf.GetStringWidth(text)
+		// Centered when alone or in the middle, else left then right.
+		x := (pageWidth - w) / 2
+		if len(parts) > 1 && i == 0 {
+			x = stampMargin
+		} else if len(parts) > 1 && i == len(parts)-1 {
+			x = pageWidth - stampMargin - w
+		}
+		// Text rather than CellFormat as y may be negative, see stamp.
+		g.pdf.Text(x, y, text)
+	}
+}
@@ -0,0 +1,754 @@
+package pdf
+
+import (
+	"bytes"
+	"math"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/jung-kurt/gofpdf"
+	"github.com/mazznoer/csscolorparser"
+
+	"oss.terrastruct.com/d2/d2renderers/d2fonts"
+	"oss.terrastruct.com/d2/d2renderers/d2svg"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/d2themes"
+	"oss.terrastruct.com/d2/d2themes/d2themescatalog"
+	"oss.terrastruct.com/d2/lib/color"
+	"oss.terrastruct.com/d2/lib/geo"
+	"oss.terrastruct.com/d2/lib/label"
+	"oss.terrastruct.com/d2/lib/shape"
+	"oss.terrastruct.com/d2/lib/svg"
+	"oss.terrastruct.com/d2/lib/textmeasure"
+)
+
+// Image is an image of a diagram, fetched by its href.
+type Image struct {
+	Data     []byte
+	MimeType string
+}
+
+// imageTypes are the gofpdf image types of the mime types of images that can be drawn.
+var imageTypes = map[string]string{
+	"image/png":  "PNG",
+	"image/jpeg": "JPG",
+	"image/gif":  "GIF",
+}
+
+// ImageHrefs returns the hrefs of the images and icons of diagram, each once.
+func ImageHrefs(diagram *d2target.Diagram) []string {
+	var hrefs []string
+	seen := make(map[string]struct{})
+	for _, s := range diagram.Shapes {
+		if s.Icon == nil {
+			continue
+		}
+		href := s.Icon.String()
+		if _, ok := seen[href]; ok {
+			continue
+		}
+		seen[href] = struct{}{}
+		hrefs = append(hrefs, href)
+	}
+	return hrefs
+}
+
+// CanDrawVector returns whether AddVectorPage can draw diagram with images. Markdown, LaTeX,
+// 3D hexagons and images other than PNGs, JPEGs and GIFs can only be drawn rasterized.
+func CanDrawVector(diagram *d2target.Diagram, images map[string]Image) bool {
+	for _, s := range diagram.Shapes {
+		if s.Type == d2target.ShapeText && s.Language != "" {
+			return false
+		}
+		if s.Type == d2target.ShapeHexagon && s.ThreeDee {
+			return false
+		}
+		if s.Icon != nil {
+			img, ok := images[s.Icon.String()]
+			if !ok {
+				return false
+			}
+			if _, ok := imageTypes[img.MimeType]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// AddVectorPage adds the pages of diagram drawn from its shapes and connections rather than
+// from a screenshot, so that its text stays selectable and it stays sharp at any zoom.
+// Shadows, fill patterns and animations are not drawn.
+func (g *GoFPDF) AddVectorPage(diagram *d2target.Diagram, images map[string]Image, titlePath []BoardTitle, themeID int64, fill string, pad int64, scale float64, pageMap map[string]int, includeNav bool) error {
+	tl, br := diagram.BoundingBox()
+	left := float64(tl.X) - float64(pad)
+	top := float64(tl.Y) - float64(pad)
+	width := float64(br.X-tl.X) + float64(pad)*2
+	height := float64(br.Y-tl.Y) + float64(pad)*2
+
+	theme := d2themescatalog.Find(themeID)
+	if diagram.Config != nil {
+		theme.ApplyOverrides(diagram.Config.ThemeOverrides)
+	}
+	fontFamily := d2fonts.SourceSansPro
+	if diagram.FontFamily != nil {
+		fontFamily = *diagram.FontFamily
+	}
+	v := &vectorPage{
+		g:          g,
+		theme:      &theme,
+		fontFamily: fontFamily,
+		images:     images,
+		bounds:     geo.NewBox(geo.NewPoint(left, top), width, height),
+	}
+	var allObjects []d2svg.DiagramObject
+	allObjects, v.idToShape = d2svg.SortedObjects(diagram)
+
+	err := g.addBoard(&board{
+		titlePath: titlePath,
+		themeID:   themeID,
+		fill:      fill,
+		width:     width * scale,
+		height:    height * scale,
+		shapes:    diagram.Shapes,
+		x:         left,
+		y:         top,
+		scale:     scale,
+		draw: func(x, y, fit float64) {
+			s := scale * fit
+			g.pdf.TransformBegin()
+			if s != 1 {
+				g.pdf.TransformScale(100*s, 100*s, 0, 0)
+			}
+			g.pdf.TransformTranslate(x/s-left, y/s-top)
+			for _, obj := range allObjects {
+				if c, ok := obj.(d2target.Connection); ok {
+					v.drawConnection(c)
+				} else {
+					v.drawShape(obj.(d2target.Shape))
+				}
+				g.pdf.SetAlpha(1, "Normal")
+				g.pdf.SetDashPattern(nil, 0)
+			}
+			g.pdf.TransformEnd()
+		},
+	}, pageMap, includeNav)
+	if err != nil {
+		return err
+	}
+	if g.pdf.Err() {
+		return g.pdf.Error()
+	}
+	return nil
+}
+
+// vectorPage draws the shapes and connections of a diagram on the current page, in the
+// coordinates of the diagram.
+type vectorPage struct {
+	g          *GoFPDF
+	theme      *d2themes.Theme
+	fontFamily d2fonts.FontFamily
+	images     map[string]Image
+	idToShape  map[string]d2target.Shape
+	// bounds is the box of the diagram with its padding.
+	bounds *geo.Box
+}
+
+// rgba resolves a color of the diagram, ok being false for no color.
+func (v *vectorPage) rgba(c string) (r, g, b int, a float64, ok bool) {
+	switch strings.ToLower(c) {
+	case "", color.None, "transparent":
+		return 0, 0, 0, 0, false
+	}
+	c = d2themes.ResolveThemeColor(*v.theme, c)
+	parsed, err := csscolorparser.Parse(c)
+	if err != nil {
+		return 0, 0, 0, 0, false
+	}
+	r8, g8, b8, _ := parsed.RGBA255()
+	return int(r8), int(g8), int(b8), parsed.A, true
+}
+
+// style sets the colors, width and dashes of what is drawn next, returning the gofpdf style
+// to draw it with, "" if there is nothing to draw.
+func (v *vectorPage) style(fill, stroke string, strokeWidth int, strokeDash float64) string {
+	var style string
+	if r, g, b, a, ok := v.rgba(fill); ok && a > 0 {
+		v.g.pdf.SetFillColor(r, g, b)
+		style += "F"
+	}
+	if r, g, b, a, ok := v.rgba(stroke); ok && a > 0 && strokeWidth > 0 {
+		v.g.pdf.SetDrawColor(r, g, b)
+		v.g.pdf.SetLineWidth(float64(strokeWidth))
+		if strokeDash != 0 {
+			dashSize, gapSize := svg.GetStrokeDashAttributes(float64(strokeWidth), strokeDash)
+			v.g.pdf.SetDashPattern([]float64{dashSize, gapSize}, 0)
+		} else {
+			v.g.pdf.SetDashPattern(nil, 0)
+		}
+		style += "D"
+	}
+	return style
+}
+
+func (v *vectorPage) setAlpha(opacity float64) {
+	v.g.pdf.SetAlpha(math.Max(0, math.Min(1, opacity)), "Normal")
+}
+
+// setFont sets the font of text drawn next, registering it on first use.
+func (v *vectorPage) setFont(mono, bold, italic, underline bool, size float64) {
+	family := v.fontFamily
+	if mono {
+		family = d2fonts.SourceCodePro
+	}
+	fontStyle := d2fonts.FONT_STYLE_REGULAR
+	style := ""
+	if bold {
+		fontStyle = d2fonts.FONT_STYLE_BOLD
+		style = "B"
+	} else if italic {
+		fontStyle = d2fonts.FONT_STYLE_ITALIC
+		style = "I"
+	}
+	key := string(family) + style
+	if _, ok := v.g.fonts[key]; !ok {
+		ttf, ok := d2fonts.FontFaces.Lookup(family.Font(0, fontStyle))
+		if !ok {
+			ttf = d2fonts.FontFaces.Get(family.Font(0, d2fonts.FONT_STYLE_REGULAR))
+		}
+		v.g.pdf.AddUTF8FontFromBytes(string(family), style, ttf)
+		v.g.fonts[key] = struct{}{}
+	}
+	if underline {
+		style += "U"
+	}
+	v.g.pdf.SetFont(string(family), style, size)
+}
+
+func (v *vectorPage) setTextColor(c string) {
+	r, g, b, _, ok := v.rgba(c)
+	if !ok {
+		r, g, b, _, _ = v.rgba(d2target.FG_COLOR)
+	}
+	v.g.pdf.SetTextColor(r, g, b)
+}
+
+// text draws the lines of text centered in the box of a label at tl, the way d2svg does.
+func (v *vectorPage) text(text string, tl *geo.Point, width, height float64, fontSize int) {
+	lines := strings.Split(text, "\n")
+	cx := tl.X + width/2
+	y := tl.Y + float64(fontSize)
+	for _, line := range lines {
+		v.g.pdf.Text(cx-v.g.pdf.GetStringWidth(line)/2, y, line)
+		y += height / float64(len(lines))
+	}
+}
+
+func (v *vectorPage) rect(x, y, width, height, radius float64, style string) {
+	if style == "" {
+		return
+	}
+	if radius > 0 {
+		v.g.pdf.RoundedRect(x, y, width, height, math.Min(radius, math.Min(width, height)/2), "1234", style)
+	} else {
+		v.g.pdf.Rect(x, y, width, height, style)
+	}
+}
+
+// image draws the image at href fitted in the box at x, y.
+func (v *vectorPage) image(href string, x, y, width, height float64) {
+	img := v.images[href]
+	opt := gofpdf.ImageOptions{ImageType: imageTypes[img.MimeType]}
+	info := v.g.pdf.RegisterImageOptionsReader(href, opt, bytes.NewReader(img.Data))
+	if info == nil || info.Width() == 0 || info.Height() == 0 {
+		return
+	}
+	s := math.Min(width/info.Width(), height/info.Height())
+	w, h := info.Width()*s, info.Height()*s
+	v.g.pdf.ImageOptions(href, x+(width-w)/2, y+(height-h)/2, w, h, false, opt, 0, "")
+}
+
+func (v *vectorPage) drawShape(s d2target.Shape) {
+	v.setAlpha(s.Opacity)
+	tl := geo.NewPoint(float64(s.Pos.X), float64(s.Pos.Y))
+	width := float64(s.Width)
+	height := float64(s.Height)
+	fill, stroke := d2themes.ShapeTheme(s)
+	shapeType := d2target.DSL_SHAPE_TO_SHAPE_TYPE[s.Type]
+
+	sh := shape.NewShapeWithPath(shapeType, geo.NewBox(tl, width, height), s.ShapePath)
+	if shapeType == shape.CLOUD_TYPE && s.ContentAspectRatio != nil {
+		sh.SetInnerBoxAspectRatio(*s.ContentAspectRatio)
+	}
+
+	var multipleTL *geo.Point
+	if s.Multiple {
+		multipleTL = tl.AddVector(geo.NewVector(d2target.MULTIPLE_OFFSET, -d2target.MULTIPLE_OFFSET))
+	}
+
+	switch s.Type {
+	case d2target.ShapeClass:
+		v.drawClass(s)
+		return
+	case d2target.ShapeSQLTable:
+		v.drawTable(s)
+		return
+	case d2target.ShapeOval:
+		ovals := []*geo.Point{tl}
+		if s.Multiple {
+			ovals = []*geo.Point{multipleTL, tl}
+		}
+		for _, otl := range ovals {
+			style := v.style(fill, stroke, s.StrokeWidth, s.StrokeDash)
+			if style == "" {
+				continue
+			}
+			v.g.pdf.Ellipse(otl.X+width/2, otl.Y+height/2, width/2, height/2, 0, style)
+			if s.DoubleBorder {
+				v.g.pdf.Ellipse(otl.X+width/2, otl.Y+height/2, width/2-d2target.INNER_BORDER_OFFSET, height/2-d2target.INNER_BORDER_OFFSET, 0, style)
+			}
+		}
+	case d2target.ShapeImage:
+		v.image(s.Icon.String(), tl.X, tl.Y, width, height)
+		v.rect(tl.X, tl.Y, width, height, 0, v.style("", stroke, s.StrokeWidth, s.StrokeDash))
+	case d2target.ShapeRectangle, d2target.ShapeSequenceDiagram, d2target.ShapeHierarchy, "":
+		radius := float64(s.BorderRadius)
+		if s.ThreeDee {
+			v.draw3DRect(s)
+			break
+		}
+		rects := []*geo.Point{tl}
+		if s.Multiple {
+			rects = []*geo.Point{multipleTL, tl}
+		}
+		for _, rtl := range rects {
+			v.rect(rtl.X, rtl.Y, width, height, radius, v.style(fill, stroke, s.StrokeWidth, s.StrokeDash))
+			if s.DoubleBorder {
+				innerFill := fill
+				if rtl == tl {
+					innerFill = ""
+				}
+				v.rect(rtl.X+d2target.INNER_BORDER_OFFSET, rtl.Y+d2target.INNER_BORDER_OFFSET,
+					width-2*d2target.INNER_BORDER_OFFSET, height-2*d2target.INNER_BORDER_OFFSET,
+					radius, v.style(innerFill, stroke, s.StrokeWidth, s.StrokeDash))
+			}
+		}
+	case d2target.ShapeText, d2target.ShapeCode:
+	default:
+		var paths [][]string
+		if s.Multiple {
+			paths = append(paths, shape.NewShapeWithPath(shapeType, geo.NewBox(multipleTL, width, height), s.ShapePath).GetSVGPathData())
+		}
+		paths = append(paths, sh.GetSVGPathData())
+		for _, pathData := range paths {
+			for _, d := range pathData {
+				v.path(svg.ParsePath(d), v.style(fill, stroke, s.StrokeWidth, s.StrokeDash))
+			}
+		}
+	}
+
+	if s.Icon != nil && s.Type != d2target.ShapeImage && s.Opacity != 0 {
+		iconPosition := label.FromString(s.IconPosition)
+		var box *geo.Box
+		if iconPosition.IsOutside() {
+			box = sh.GetBox()
+		} else {
+			box = sh.GetInnerBox()
+		}
+		iconSize := float64(d2target.GetIconSize(box, s.IconPosition))
+		iconTL := iconPosition.GetPointOnBox(box, label.PADDING, iconSize, iconSize)
+		v.image(s.Icon.String(), iconTL.X, iconTL.Y, iconSize, iconSize)
+	}
+
+	if s.Label == "" || s.Opacity == 0 {
+		return
+	}
+	labelPosition := label.FromString(s.LabelPosition)
+	var box *geo.Box
+	if labelPosition.IsOutside() {
+		box = sh.GetBox().Copy()
+		if s.ThreeDee {
+			box.TopLeft.Y -= d2target.THREE_DEE_OFFSET
+			box.Height += d2target.THREE_DEE_OFFSET
+			box.Width += d2target.THREE_DEE_OFFSET
+		} else if s.Multiple {
+			box.TopLeft.Y -= d2target.MULTIPLE_OFFSET
+			box.Height += d2target.MULTIPLE_OFFSET
+			box.Width += d2target.MULTIPLE_OFFSET
+		}
+	} else {
+		box = sh.GetInnerBox()
+	}
+
+	if s.Type == d2target.ShapeCode {
+		v.drawCode(s, box.TopLeft)
+		return
+	}
+
+	labelTL := labelPosition.GetPointOnBox(box, label.PADDING, float64(s.LabelWidth), float64(s.LabelHeight))
+	if s.LabelFill != "" {
+		v.rect(labelTL.X, labelTL.Y, float64(s.LabelWidth), float64(s.LabelHeight), 0, v.style(s.LabelFill, "", 0, 0))
+	}
+	v.setFont(s.FontFamily == "mono", s.Bold, s.Italic, s.Underline, float64(s.FontSize))
+	v.setTextColor(s.GetFontColor())
+	v.text(s.Label, labelTL, float64(s.LabelWidth), float64(s.LabelHeight), s.FontSize)
+}
+
+// draw3DRect draws s as a rectangle with its darker top and right sides.
+func (v *vectorPage) draw3DRect(s d2target.Shape) {
+	fill, stroke := d2themes.ShapeTheme(s)
+	x, y := float64(s.Pos.X), float64(s.Pos.Y)
+	w, h := float64(s.Width), float64(s.Height)
+	const o = d2target.THREE_DEE_OFFSET
+
+	v.rect(x, y, w, h, 0, v.style(fill, "", 0, 0))
+	darkerFill, err := color.Darken(s.Fill)
+	if err != nil {
+		darkerFill = s.Fill
+	}
+	if style := v.style(darkerFill, "", 0, 0); style != "" {
+		v.g.pdf.Polygon([]gofpdf.PointType{
+			{X: x, Y: y},
+			{X: x + o, Y: y - o},
+			{X: x + w + o, Y: y - o},
+			{X: x + w + o, Y: y + h - o},
+			{X: x + w, Y: y + h},
+			{X: x + w, Y: y},
+		}, style)
+	}
+
+	if style := v.style("", stroke, s.StrokeWidth, s.StrokeDash); style != "" {
+		v.path([]svg.PathCommand{
+			{Op: 'M', Points: [3]geo.Point{{X: x, Y: y}}},
+			{Op: 'L', Points: [3]geo.Point{{X: x + o, Y: y - o}}},
+			{Op: 'L', Points: [3]geo.Point{{X: x + w + o, Y: y - o}}},
+			{Op: 'L', Points: [3]geo.Point{{X: x + w + o, Y: y + h - o}}},
+			{Op: 'L', Points: [3]geo.Point{{X: x + w, Y: y + h}}},
+			{Op: 'L', Points: [3]geo.Point{{X: x, Y: y + h}}},
+			{Op: 'Z'},
+			{Op: 'M', Points: [3]geo.Point{{X: x, Y: y}}},
+			{Op: 'L', Points: [3]geo.Point{{X: x + w, Y: y}}},
+			{Op: 'L', Points: [3]geo.Point{{X: x + w, Y: y + h}}},
+			{Op: 'M', Points: [3]geo.Point{{X: x + w, Y: y}}},
+			{Op: 'L', Points: [3]geo.Point{{X: x + w + o, Y: y - o}}},
+		}, "D")
+	}
+}
+
+// drawCode draws the highlighted lines of the code of s at tl.
+func (v *vectorPage) drawCode(s d2target.Shape, tl *geo.Point) {
+	lexer := lexers.Get(s.Language)
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	style := styles.Get("github")
+	iterator, err := lexer.Tokenise(nil, s.Label)
+	if err != nil {
+		return
+	}
+
+	bg := style.Get(chroma.Background).Background.String()
+	v.rect(tl.X, tl.Y, float64(s.Width), float64(s.Height), 0, v.style(bg, s.Stroke, s.StrokeWidth, 0))
+
+	fontSize := float64(s.FontSize)
+	padding := fontSize / 2
+	for i, tokens := range chroma.SplitTokensIntoLines(iterator.Tokens()) {
+		x := tl.X + padding
+		y := tl.Y + padding + fontSize*(1+float64(i)*textmeasure.CODE_LINE_HEIGHT)
+		for _, token := range tokens {
+			text := strings.ReplaceAll(strings.TrimRight(token.String(), "\n"), "\t", "    ")
+			if text == "" {
+				continue
+			}
+			entry := style.Get(token.Type)
+			v.setFont(true, entry.Bold == chroma.Yes, entry.Italic == chroma.Yes, false, fontSize)
+			if entry.Colour.IsSet() {
+				v.g.pdf.SetTextColor(int(entry.Colour.Red()), int(entry.Colour.Green()), int(entry.Colour.Blue()))
+			} else {
+				v.g.pdf.SetTextColor(0, 0, 0)
+			}
+			v.g.pdf.Text(x, y, text)
+			x += v.g.pdf.GetStringWidth(text)
+		}
+	}
+}
+
+// drawTable draws s the way d2svg's drawTable does.
+func (v *vectorPage) drawTable(s d2target.Shape) {
+	fill, stroke := d2themes.ShapeTheme(s)
+	box := geo.NewBox(geo.NewPoint(float64(s.Pos.X), float64(s.Pos.Y)), float64(s.Width), float64(s.Height))
+	radius := float64(s.BorderRadius)
+	v.rect(box.TopLeft.X, box.TopLeft.Y, box.Width, box.Height, radius, v.style(fill, stroke, s.StrokeWidth, s.StrokeDash))
+
+	rowHeight := box.Height / float64(1+len(s.SQLTable.Columns))
+	headerBox := geo.NewBox(box.TopLeft, box.Width, rowHeight)
+	v.header(s, headerBox, radius)
+	if s.Label != "" {
+		textHeight := float64(s.LabelHeight)
+		tl := label.InsideMiddleLeft.GetPointOnBox(headerBox, float64(d2target.HeaderPadding), float64(s.Width), textHeight)
+		v.setFont(false, false, false, false, float64(4+s.FontSize))
+		v.setTextColor(s.GetFontColor())
+		v.g.pdf.Text(tl.X, tl.Y+textHeight*3/4, s.Label)
+	}
+
+	var longestNameWidth int
+	for _, f := range s.Columns {
+		longestNameWidth = max(longestNameWidth, f.Name.LabelWidth)
+	}
+
+	fontSize := float64(s.FontSize)
+	v.setFont(false, false, false, false, fontSize)
+	rowBox := geo.NewBox(box.TopLeft.Copy(), box.Width, rowHeight)
+	rowBox.TopLeft.Y += headerBox.Height
+	for i, f := range s.Columns {
+		nameTL := label.InsideMiddleLeft.GetPointOnBox(rowBox, d2target.NamePadding, 0, fontSize)
+		y := nameTL.Y + fontSize*3/4
+		v.setTextColor(s.PrimaryAccentColor)
+		v.g.pdf.Text(nameTL.X, y, f.Name.Label)
+		v.setTextColor(s.NeutralAccentColor)
+		v.g.pdf.Text(nameTL.X+float64(longestNameWidth)+d2target.TypePadding, y, f.Type.Label)
+		constraint := f.ConstraintAbbr()
+		v.setTextColor(s.SecondaryAccentColor)
+		v.g.pdf.Text(rowBox.TopLeft.X+rowBox.Width-d2target.NamePadding-v.g.pdf.GetStringWidth(constraint), y, constraint)
+		rowBox.TopLeft.Y += rowHeight
+
+		x1, x2 := rowBox.TopLeft.X, rowBox.TopLeft.X+rowBox.Width
+		if i == len(s.Columns)-1 && s.BorderRadius != 0 {
+			x1 += radius
+			x2 -= radius
+		}
+		if v.style("", s.Fill, 2, 0) != "" {
+			v.g.pdf.Line(x1, rowBox.TopLeft.Y, x2, rowBox.TopLeft.Y)
+		}
+	}
+	v.drawBoxIcon(s, box)
+}
+
+// drawClass draws s the way d2svg's drawClass does.
+func (v *vectorPage) drawClass(s d2target.Shape) {
+	fill, stroke := d2themes.ShapeTheme(s)
+	box := geo.NewBox(geo.NewPoint(float64(s.Pos.X), float64(s.Pos.Y)), float64(s.Width), float64(s.Height))
+	radius := float64(s.BorderRadius)
+	v.rect(box.TopLeft.X, box.TopLeft.Y, box.Width, box.Height, radius, v.style(fill, stroke, s.StrokeWidth, s.StrokeDash))
+
+	rowHeight := box.Height / float64(2+len(s.Class.Fields)+len(s.Class.Methods))
+	headerBox := geo.NewBox(box.TopLeft, box.Width, 2*rowHeight)
+	v.header(s, headerBox, radius)
+	if s.Label != "" {
+		textWidth, textHeight := float64(s.LabelWidth), float64(s.LabelHeight)
+		tl := label.InsideMiddleCenter.GetPointOnBox(headerBox, 0, textWidth, textHeight)
+		v.setFont(true, false, false, false, float64(4+s.FontSize))
+		v.setTextColor(s.GetFontColor())
+		v.g.pdf.Text(tl.X+textWidth/2-v.g.pdf.GetStringWidth(s.Label)/2, tl.Y+textHeight*3/4, s.Label)
+	}
+
+	fontSize := float64(s.FontSize)
+	v.setFont(true, false, false, false, fontSize)
+	rowBox := geo.NewBox(box.TopLeft.Copy(), box.Width, rowHeight)
+	rowBox.TopLeft.Y += headerBox.Height
+	row := func(prefix, name, typ string) {
+		prefixTL := label.InsideMiddleLeft.GetPointOnBox(rowBox, d2target.PrefixPadding, rowBox.Width, fontSize)
+		typeTR := label.InsideMiddleRight.GetPointOnBox(rowBox, d2target.TypePadding, 0, fontSize)
+		v.setTextColor(s.PrimaryAccentColor)
+		v.g.pdf.Text(prefixTL.X, prefixTL.Y+fontSize*3/4, prefix)
+		v.setTextColor(s.Fill)
+		v.g.pdf.Text(prefixTL.X+d2target.PrefixWidth, prefixTL.Y+fontSize*3/4, name)
+		v.setTextColor(s.SecondaryAccentColor)
+		v.g.pdf.Text(typeTR.X-v.g.pdf.GetStringWidth(typ), typeTR.Y+fontSize*3/4, typ)
+		rowBox.TopLeft.Y += rowHeight
+	}
+	for _, f := range s.Fields {
+		row(f.VisibilityToken(), f.Name, f.Type)
+	}
+
+	x1, x2 := rowBox.TopLeft.X, rowBox.TopLeft.X+rowBox.Width
+	if s.BorderRadius != 0 && len(s.Methods) == 0 {
+		x1 += radius
+		x2 -= radius
+	}
+	if v.style("", s.Fill, 1, 0) != "" {
+		v.g.pdf.Line(x1, rowBox.TopLeft.Y, x2, rowBox.TopLeft.Y)
+	}
+
+	for _, m := range s.Methods {
+		row(m.VisibilityToken(), m.Name, m.Return)
+	}
+	v.drawBoxIcon(s, box)
+}
+
+// header draws the header of a class or table, rounding its top corners with the shape.
+func (v *vectorPage) header(s d2target.Shape, box *geo.Box, radius float64) {
+	style := v.style(s.Fill, "", 0, 0)
+	if style == "" {
+		return
+	}
+	if radius > 0 {
+		v.g.pdf.RoundedRect(box.TopLeft.X, box.TopLeft.Y, box.Width, box.Height, math.Min(radius, math.Min(box.Width, box.Height)/2), "12", style)
+	} else {
+		v.g.pdf.Rect(box.TopLeft.X, box.TopLeft.Y, box.Width, box.Height, style)
+	}
+}
+
+func (v *vectorPage) drawBoxIcon(s d2target.Shape, box *geo.Box) {
+	if s.Icon == nil {
+		return
+	}
+	iconSize := float64(d2target.GetIconSize(box, s.IconPosition))
+	tl := label.FromString(s.IconPosition).GetPointOnBox(box, label.PADDING, iconSize, iconSize)
+	v.image(s.Icon.String(), tl.X, tl.Y, iconSize, iconSize)
+}
+
+func (v *vectorPage) drawConnection(c d2target.Connection) {
+	v.setAlpha(c.Opacity)
+
+	cmds := svg.ParsePath(d2svg.ConnectionPathData(c, v.idToShape))
+	strokeDash := c.StrokeDash
+	if strokeDash == 0 && c.Animated {
+		strokeDash = 5
+	}
+
+	var labelTL *geo.Point
+	if c.Label != "" {
+		labelTL = c.GetLabelTopLeft()
+		labelTL.X = math.Round(labelTL.X)
+		labelTL.Y = math.Round(labelTL.Y)
+	}
+
+	if style := v.style("", c.Stroke, c.StrokeWidth, strokeDash); style != "" {
+		v.g.pdf.SetLineCapStyle("round")
+		v.g.pdf.SetLineJoinStyle("round")
+		// gofpdf clips to the inside of paths only, so labels on the connection cut it with a
+		// clip of the page with a hole.
+		clipped := labelTL != nil && label.FromString(c.LabelPosition).IsOnEdge()
+		if clipped {
+			v.clipOut(labelTL.X, labelTL.Y, float64(c.LabelWidth), float64(c.LabelHeight))
+		}
+		v.path(cmds, style)
+		if clipped {
+			v.g.pdf.ClipEnd()
+		}
+		v.g.pdf.SetLineCapStyle("butt")
+		v.g.pdf.SetLineJoinStyle("miter")
+	}
+
+	if len(cmds) > 0 {
+		start, startDir, end, endDir := svg.PathEnds(cmds)
+		if c.SrcArrow != d2target.NoArrowhead {
+			v.drawArrowhead(c, false, start, startDir)
+		}
+		if c.DstArrow != d2target.NoArrowhead {
+			v.drawArrowhead(c, true, end, endDir)
+		}
+	}
+
+	if c.Label != "" {
+		if c.Fill != color.Empty {
+			v.rect(labelTL.X, labelTL.Y, float64(c.LabelWidth), float64(c.LabelHeight), 0, v.style(c.Fill, "", 0, 0))
+		}
+		v.setFont(c.FontFamily == "mono", c.Bold, c.Italic, c.Underline, float64(c.FontSize))
+		v.setTextColor(c.GetFontColor())
+		v.text(c.Label, labelTL, float64(c.LabelWidth), float64(c.LabelHeight), c.FontSize)
+	}
+
+	for _, isDst := range []bool{false, true} {
+		l := c.SrcLabel
+		if isDst {
+			l = c.DstLabel
+		}
+		if l == nil || l.Label == "" {
+			continue
+		}
+		v.setFont(false, false, true, false, float64(c.FontSize))
+		if l.Color != "" {
+			v.setTextColor(l.Color)
+		} else {
+			v.setTextColor(d2target.FG_COLOR)
+		}
+		v.text(l.Label, c.GetArrowheadLabelPosition(isDst), float64(l.LabelWidth), float64(l.LabelHeight), c.FontSize)
+	}
+}
+
+// clipOut clips what is drawn next to outside of the box at x, y until ClipEnd.
+func (v *vectorPage) clipOut(x, y, width, height float64) {
+	b := v.bounds
+	v.g.pdf.ClipPolygon([]gofpdf.PointType{
+		{X: b.TopLeft.X, Y: b.TopLeft.Y},
+		{X: b.TopLeft.X + b.Width, Y: b.TopLeft.Y},
+		{X: b.TopLeft.X + b.Width, Y: b.TopLeft.Y + b.Height},
+		{X: b.TopLeft.X, Y: b.TopLeft.Y + b.Height},
+		{X: b.TopLeft.X, Y: b.TopLeft.Y},
+		// Wound the other way, so that it is a hole.
+		{X: x, Y: y},
+		{X: x, Y: y + height},
+		{X: x + width, Y: y + height},
+		{X: x + width, Y: y},
+		{X: x, Y: y},
+	}, false)
+}
+
+// drawArrowhead draws the arrowhead of c at p pointing along dir, mapping the points of its
+// marker onto the page as gofpdf has no transforms for paths and clips to share.
+func (v *vectorPage) drawArrowhead(c d2target.Connection, isTarget bool, p geo.Point, dir geo.Point) {
+	l := math.Hypot(dir.X, dir.Y)
+	if l == 0 {
+		return
+	}
+	arrowhead := c.DstArrow
+	if !isTarget {
+		arrowhead = c.SrcArrow
+	}
+	m := arrowhead.Marker(isTarget, float64(c.StrokeWidth), c.Stroke)
+
+	cos, sin := dir.X/l, dir.Y/l
+	at := func(x, y float64) gofpdf.PointType {
+		x, y = x-m.Ref.X, y-m.Ref.Y
+		return gofpdf.PointType{X: p.X + x*cos - y*sin, Y: p.Y + x*sin + y*cos}
+	}
+
+	v.g.pdf.ClipPolygon([]gofpdf.PointType{at(0, 0), at(m.Width, 0), at(m.Width, m.Height), at(0, m.Height)}, false)
+	defer v.g.pdf.ClipEnd()
+
+	for _, el := range m.Elements {
+		style := v.style(el.Fill, el.Stroke, c.StrokeWidth, 0)
+		if style == "" {
+			continue
+		}
+		if el.Radius > 0 {
+			center := at(el.Points[0].X, el.Points[0].Y)
+			v.g.pdf.Circle(center.X, center.Y, el.Radius, style)
+			continue
+		}
+		start := at(el.Points[0].X, el.Points[0].Y)
+		v.g.pdf.MoveTo(start.X, start.Y)
+		for _, pt := range el.Points[1:] {
+			pt := at(pt.X, pt.Y)
+			v.g.pdf.LineTo(pt.X, pt.Y)
+		}
+		if el.Closed {
+			v.g.pdf.ClosePath()
+		}
+		v.g.pdf.DrawPath(style)
+	}
+}
+
+// path draws cmds with style.
+func (v *vectorPage) path(cmds []svg.PathCommand, style string) {
+	if style == "" || len(cmds) == 0 {
+		return
+	}
+	for _, c := range cmds {
+		switch c.Op {
+		case 'M':
+			v.g.pdf.MoveTo(c.Points[0].X, c.Points[0].Y)
+		case 'L':
+			v.g.pdf.LineTo(c.Points[0].X, c.Points[0].Y)
+		case 'C':
+			v.g.pdf.CurveBezierCubicTo(c.Points[0].X, c.Points[0].Y, c.Points[1].X, c.Points[1].Y, c.Points[2].X, c.Points[2].Y)
+		case 'Z':
+			v.g.pdf.ClosePath()
+		}
+	}
+	v.g.pdf.DrawPath(style)
+}
@@ -0,0 +1,96 @@
+package pdf
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"oss.terrastruct.com/util-go/assert"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2dagrelayout"
+	"oss.terrastruct.com/d2/d2lib"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/log"
+	"oss.terrastruct.com/d2/lib/textmeasure"
+)
+
+func TestAddVectorPage(t *testing.T) {
+	t.Parallel()
+
+	diagram := compile(t, `a: {shape: oval; style.multiple: true}
+b: {shape: cylinder}
+c: {shape: rectangle; style.3d: true; style.border-radius: 4}
+d: {style.double-border: true; link: https://d2lang.com}
+code: |go
+  func main() {}
+|
+t: {
+  shape: sql_table
+  id: int {constraint: primary_key}
+}
+k: {
+  shape: class
+  +field: int
+  -method(): string
+}
+a -> b: on the edge {target-arrowhead.shape: diamond}
+b <-> c: {source-arrowhead.shape: cf-many; target-arrowhead.shape: circle}
+c -- d: {style.stroke-dash: 3; style.animated: true}
+d -> code -> t -> k: {source-arrowhead: 1; target-arrowhead: *}
+`)
+	if !CanDrawVector(diagram, nil) {
+		t.Fatal("expected diagram to be drawable as vectors")
+	}
+
+	for _, scale := range []float64{1, 0.5} {
+		g := Init(nil)
+		err := g.AddVectorPage(diagram, nil, []BoardTitle{{Name: "root", BoardID: "root"}}, 0, "", 100, scale, nil, true)
+		assert.Success(t, err)
+		var buf bytes.Buffer
+		err = g.pdf.Output(&buf)
+		assert.Success(t, err)
+		if !bytes.HasPrefix(buf.Bytes(), []byte("%PDF")) {
+			t.Fatal("expected a PDF")
+		}
+	}
+}
+
+func TestCanDrawVector(t *testing.T) {
+	t.Parallel()
+
+	diagram := compile(t, `md: |md
+  # Title
+|`)
+	if CanDrawVector(diagram, nil) {
+		t.Fatal("expected markdown not to be drawable as vectors")
+	}
+
+	diagram = compile(t, `x: {icon: https://icons.terrastruct.com/essentials/004-picture.svg}`)
+	hrefs := ImageHrefs(diagram)
+	assert.Equal(t, 1, len(hrefs))
+	assert.Equal(t, "https://icons.terrastruct.com/essentials/004-picture.svg", hrefs[0])
+	if CanDrawVector(diagram, nil) {
+		t.Fatal("expected a diagram without its images not to be drawable as vectors")
+	}
+	if CanDrawVector(diagram, map[string]Image{hrefs[0]: {MimeType: "image/svg+xml"}}) {
+		t.Fatal("expected SVG icons not to be drawable as vectors")
+	}
+	if !CanDrawVector(diagram, map[string]Image{hrefs[0]: {MimeType: "image/png"}}) {
+		t.Fatal("expected PNG icons to be drawable as vectors")
+	}
+}
+
+func compile(t *testing.T, script string) *d2target.Diagram {
+	ctx := log.WithTB(context.Background(), t, nil)
+	ruler, err := textmeasure.NewRuler()
+	assert.Success(t, err)
+	diagram, _, err := d2lib.Compile(ctx, script, &d2lib.CompileOptions{
+		Ruler: ruler,
+		LayoutResolver: func(engine string) (d2graph.LayoutGraph, error) {
+			return d2dagrelayout.DefaultLayout, nil
+		},
+	}, nil)
+	assert.Success(t, err)
+	return diagram
+}
@@ -1,4 +1,4 @@
-async ({ imgString, scale }) => {
+async ({ imgString, scale, width, height, region }) => {
   const tempImg = new Image();
   const loadImage = () => {
     return new Promise((resolve, reject) => {
@@ -11,14 +11,30 @@ async ({ imgString, scale }) => {
   };
   const img = await loadImage();
   const canvas = document.createElement("canvas");
-  canvas.width = img.width * scale;
-  canvas.height = img.height * scale;
+  // width and height override scale. With both, the image is fit and centered within them.
+  if (width && height) {
+    scale = Math.min(width / img.width, height / img.height);
+  } else if (width) {
+    scale = width / img.width;
+  } else if (height) {
+    scale = height / img.height;
+  }
+  canvas.width = width || img.width * scale;
+  canvas.height = height || img.height * scale;
+  const fullWidth = canvas.width;
+  const fullHeight = canvas.height;
 
   // https://developer.mozilla.org/en-US/docs/Web/HTML/Element/canvas
   const MAX_DIMENSION = 32767;
   const MAX_AREA = 268435456;
 
-  const ratio = img.width / img.height;
+  if (region) {
+    // Regions are parts of images too large for one canvas, so only they are limited.
+    canvas.width = region.width;
+    canvas.height = region.height;
+  }
+
+  const ratio = canvas.width / canvas.height;
   if (ratio > 1) {
     if (canvas.width > MAX_DIMENSION) {
       canvas.width = MAX_DIMENSION;
@@ -42,6 +58,23 @@ async ({ imgString, scale }) => {
   if (!ctx) {
     return new Error("could not get canvas context");
   }
-  ctx.drawImage(img, 0, 0, canvas.width, canvas.height);
+  // The box the image is drawn in, the whole canvas unless fit within both width and height
+  // or drawn in a region.
+  let boxWidth = region ? fullWidth : canvas.width;
+  let boxHeight = region ? fullHeight : canvas.height;
+  let x = 0;
+  let y = 0;
+  if (width && height) {
+    const shrink = region ? 1 : canvas.width / fullWidth;
+    boxWidth = img.width * scale * shrink;
+    boxHeight = img.height * scale * shrink;
+    x = ((region ? fullWidth : canvas.width) - boxWidth) / 2;
+    y = ((region ? fullHeight : canvas.height) - boxHeight) / 2;
+  }
+  if (region) {
+    x -= region.x;
+    y -= region.y;
+  }
+  ctx.drawImage(img, x, y, boxWidth, boxHeight);
   return canvas.toDataURL("image/png");
 }
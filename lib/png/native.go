@@ -0,0 +1,684 @@
+package png
+
+import (
+	"bytes"
+	"image/color"
+	"math"
+	"strings"
+	"time"
+
+	"git.sr.ht/~sbinet/gg"
+	"github.com/mazznoer/csscolorparser"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+
+	"oss.terrastruct.com/util-go/go2"
+
+	"oss.terrastruct.com/d2/d2renderers/d2fonts"
+	"oss.terrastruct.com/d2/d2renderers/d2svg"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/d2themes"
+	"oss.terrastruct.com/d2/d2themes/d2themescatalog"
+	d2color "oss.terrastruct.com/d2/lib/color"
+	"oss.terrastruct.com/d2/lib/geo"
+	"oss.terrastruct.com/d2/lib/label"
+	"oss.terrastruct.com/d2/lib/shape"
+	"oss.terrastruct.com/d2/lib/svg"
+)
+
+// https://developer.mozilla.org/en-US/docs/Web/HTML/Element/canvas, the limits of the
+// canvas of generate_png.js.
+const (
+	maxDimension = 32767
+	maxArea      = 268435456
+)
+
+// CanDrawNative returns whether DrawNative can draw diagram as a browser would. Markdown and
+// LaTeX, which browsers lay out in foreignObjects, code, classes, tables, images, icons, 3D
+// shapes, shadows, fill patterns and the tooltips and links of the appendix need a browser.
+func CanDrawNative(diagram *d2target.Diagram) bool {
+	return len(NativeApproximations(diagram)) == 0
+}
+
+// NativeApproximations returns the features of diagram DrawNative cannot draw as a browser
+// would, for those that draw it anyway to know what they lose: markdown and code are drawn
+// as plain text, classes and tables as plain rows, fill patterns, double borders of the root,
+// 3D shapes and shadows as flat shapes, and images, icons, tooltips and links are left out.
+func NativeApproximations(diagram *d2target.Diagram) []string {
+	var features []string
+	add := func(feature string) {
+		if !go2.Contains(features, feature) {
+			features = append(features, feature)
+		}
+	}
+	if diagram.Root.DoubleBorder {
+		add("double borders")
+	}
+	if hasFillPattern(diagram.Root.FillPattern) {
+		add("fill patterns")
+	}
+	for _, s := range diagram.Shapes {
+		switch s.Type {
+		case d2target.ShapeCode:
+			add("code")
+		case d2target.ShapeClass:
+			add("classes")
+		case d2target.ShapeSQLTable:
+			add("SQL tables")
+		case d2target.ShapeImage:
+			add("images")
+		case d2target.ShapeText:
+			if s.Language == "latex" {
+				add("LaTeX")
+			} else if s.Language != "" {
+				add("markdown")
+			}
+		}
+		if s.Icon != nil {
+			add("icons")
+		}
+		if s.Tooltip != "" {
+			add("tooltips")
+		}
+		if s.Link != "" {
+			add("links")
+		}
+		if s.ThreeDee {
+			add("3D shapes")
+		}
+		if s.Shadow {
+			add("shadows")
+		}
+		if hasFillPattern(s.FillPattern) {
+			add("fill patterns")
+		}
+	}
+	for _, c := range diagram.Connections {
+		if c.Icon != nil {
+			add("icons")
+		}
+		if c.Tooltip != "" {
+			add("tooltips")
+		}
+	}
+	return features
+}
+
+func hasFillPattern(pattern string) bool {
+	return pattern != "" && pattern != "none"
+}
+
+// ConvertNative is Convert of the SVG of diagram without a browser, drawing diagram with
+// DrawNative.
+func ConvertNative(diagram *d2target.Diagram, themeID, pad int64, svgScale float64, crop *geo.Box, size Size) *Conversion {
+	c := &Conversion{
+		done: make(chan struct{}),
+	}
+	start := time.Now()
+	c.png, c.err = DrawNative(diagram, themeID, pad, svgScale, crop, size)
+	c.dur = time.Since(start)
+	close(c.done)
+	return c
+}
+
+// DrawNative draws diagram into a PNG of size the way a browser rasterizes its SVG rendered
+// with themeID, pad, svgScale, the scale of the SVG, 1 if 0, and crop, the box of the diagram
+// drawn, its bounding box if nil. Diagrams CanDrawNative cannot draw are approximated, see
+// NativeApproximations.
+func DrawNative(diagram *d2target.Diagram, themeID, pad int64, svgScale float64, crop *geo.Box, size Size) ([]byte, error) {
+	if svgScale <= 0 {
+		svgScale = 1
+	}
+	root := diagram.Root
+	tl, br := diagram.BoundingBox()
+	if crop != nil {
+		tl = d2target.NewPoint(int(math.Floor(crop.TopLeft.X)), int(math.Floor(crop.TopLeft.Y)))
+		br = d2target.NewPoint(int(math.Ceil(crop.TopLeft.X+crop.Width)), int(math.Ceil(crop.TopLeft.Y+crop.Height)))
+	}
+	left := float64(tl.X) - float64(pad)
+	top := float64(tl.Y) - float64(pad)
+	width := float64(br.X-tl.X) + float64(pad)*2
+	height := float64(br.Y-tl.Y) + float64(pad)*2
+	// Like d2svg, the background is grown to envelop its border and the view to envelop
+	// the background.
+	rootStroke := math.Ceil(float64(root.StrokeWidth) / 2)
+	bg := geo.NewBox(geo.NewPoint(left-rootStroke, top-rootStroke), width+2*rootStroke, height+2*rootStroke)
+	view := geo.NewBox(geo.NewPoint(left-2*rootStroke, top-2*rootStroke), width+4*rootStroke, height+4*rootStroke)
+
+	imgWidth := math.Ceil(svgScale * view.Width)
+	imgHeight := math.Ceil(svgScale * view.Height)
+	canvasWidth, canvasHeight, box := canvasSize(imgWidth, imgHeight, size)
+	// The SVG is fit in its image at its top left, preserving its aspect ratio, and its
+	// image is stretched over box.
+	sx := box.Width / imgWidth
+	sy := box.Height / imgHeight
+	fit := math.Min(imgWidth/view.Width, imgHeight/view.Height)
+
+	theme := d2themescatalog.Find(themeID)
+	if diagram.Config != nil {
+		theme.ApplyOverrides(diagram.Config.ThemeOverrides)
+	}
+	fontFamily := d2fonts.SourceSansPro
+	if diagram.FontFamily != nil {
+		fontFamily = *diagram.FontFamily
+	}
+	n := &nativeCanvas{
+		dc:         gg.NewContext(canvasWidth, canvasHeight),
+		theme:      &theme,
+		fontFamily: fontFamily,
+		scale:      math.Min(sx, sy) * fit,
+		opacity:    1,
+		fonts:      make(map[d2fonts.Font]*sfnt.Font),
+		faces:      make(map[faceKey]font.Face),
+	}
+	n.dc.Translate(box.TopLeft.X, box.TopLeft.Y)
+	n.dc.Scale(sx*fit, sy*fit)
+	n.dc.Translate(-view.TopLeft.X, -view.TopLeft.Y)
+	// SVG defaults to butt caps and miter joins, of which gg has bevel joins.
+	n.dc.SetLineCapButt()
+	n.dc.SetLineJoinBevel()
+
+	n.rect(bg.TopLeft.X, bg.TopLeft.Y, bg.Width, bg.Height, float64(root.BorderRadius))
+	n.paint(root.Fill, root.Stroke, root.StrokeWidth, root.StrokeDash)
+
+	var allObjects []d2svg.DiagramObject
+	allObjects, n.idToShape = d2svg.SortedObjects(diagram)
+	for _, obj := range allObjects {
+		if c, ok := obj.(d2target.Connection); ok {
+			n.drawConnection(c)
+		} else {
+			n.drawShape(obj.(d2target.Shape))
+		}
+	}
+
+	var b bytes.Buffer
+	err := n.dc.EncodePNG(&b)
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// canvasSize returns the size of the PNG of size of an image of imgWidth by imgHeight and
+// the box the image is drawn in, the way generate_png.js sizes its canvas. The box is
+// relative to the region of size, if any.
+func canvasSize(imgWidth, imgHeight float64, size Size) (width, height int, box *geo.Box) {
+	w, h, scale := fullSize(imgWidth, imgHeight, size)
+	fullWidth, fullHeight := w, h
+	if !size.Region.Empty() {
+		// Regions are parts of PNGs too large to convert at once, so only they are limited.
+		w, h = float64(size.Region.Dx()), float64(size.Region.Dy())
+	}
+
+	ratio := w / h
+	if ratio > 1 {
+		if w > maxDimension {
+			w = maxDimension
+			h = math.Floor(maxDimension / ratio)
+		}
+	} else if h > maxDimension {
+		h = maxDimension
+		w = math.Floor(maxDimension * ratio)
+	}
+	if area := w * h; area > maxArea {
+		areaRatio := maxArea / area
+		w = math.Floor(w * areaRatio)
+		h = math.Floor(h * areaRatio)
+	}
+
+	boxWidth, boxHeight := w, h
+	if !size.Region.Empty() {
+		boxWidth, boxHeight = fullWidth, fullHeight
+	}
+	box = geo.NewBox(geo.NewPoint(0, 0), boxWidth, boxHeight)
+	if size.Width > 0 && size.Height > 0 {
+		shrink := w / fullWidth
+		if !size.Region.Empty() {
+			shrink = 1
+		}
+		drawWidth := imgWidth * scale * shrink
+		drawHeight := imgHeight * scale * shrink
+		box = geo.NewBox(geo.NewPoint((boxWidth-drawWidth)/2, (boxHeight-drawHeight)/2), drawWidth, drawHeight)
+	}
+	box.TopLeft.X -= float64(size.Region.Min.X)
+	box.TopLeft.Y -= float64(size.Region.Min.Y)
+	return int(w), int(h), box
+}
+
+// fullSize returns the size of the PNG of size of an image of imgWidth by imgHeight before
+// the limits of the size of PNGs, with the scale the image is drawn at.
+func fullSize(imgWidth, imgHeight float64, size Size) (width, height, scale float64) {
+	scale = size.Scale
+	if scale <= 0 {
+		scale = SCALE
+	}
+	if size.Width > 0 && size.Height > 0 {
+		scale = math.Min(float64(size.Width)/imgWidth, float64(size.Height)/imgHeight)
+	} else if size.Width > 0 {
+		scale = float64(size.Width) / imgWidth
+	} else if size.Height > 0 {
+		scale = float64(size.Height) / imgHeight
+	}
+	width, height = float64(size.Width), float64(size.Height)
+	if size.Width <= 0 {
+		width = math.Floor(imgWidth * scale)
+	}
+	if size.Height <= 0 {
+		height = math.Floor(imgHeight * scale)
+	}
+	return width, height, scale
+}
+
+type faceKey struct {
+	font d2fonts.Font
+	size float64
+}
+
+// nativeCanvas draws the shapes and connections of a diagram in the coordinates of the
+// diagram.
+type nativeCanvas struct {
+	dc         *gg.Context
+	theme      *d2themes.Theme
+	fontFamily d2fonts.FontFamily
+	idToShape  map[string]d2target.Shape
+	// scale is the number of pixels per pixel of the diagram. gg transforms the points of
+	// paths, not the widths of lines and dashes, which are scaled by it.
+	scale float64
+	// opacity is the opacity of the object being drawn.
+	opacity float64
+
+	fonts map[d2fonts.Font]*sfnt.Font
+	faces map[faceKey]font.Face
+}
+
+// color resolves a color of the diagram, ok being false for no color.
+func (n *nativeCanvas) color(c string) (_ color.NRGBA, ok bool) {
+	switch strings.ToLower(c) {
+	case "", d2color.None, "transparent":
+		return color.NRGBA{}, false
+	}
+	c = d2themes.ResolveThemeColor(*n.theme, c)
+	parsed, err := csscolorparser.Parse(c)
+	if err != nil {
+		return color.NRGBA{}, false
+	}
+	r, g, b, _ := parsed.RGBA255()
+	a := math.Round(255 * parsed.A * math.Max(0, math.Min(1, n.opacity)))
+	if a == 0 {
+		return color.NRGBA{}, false
+	}
+	return color.NRGBA{R: r, G: g, B: b, A: uint8(a)}, true
+}
+
+// paint fills and strokes the current path and clears it.
+func (n *nativeCanvas) paint(fill, stroke string, strokeWidth int, strokeDash float64) {
+	if c, ok := n.color(fill); ok {
+		n.dc.SetColor(c)
+		n.dc.FillPreserve()
+	}
+	if c, ok := n.color(stroke); ok && strokeWidth > 0 {
+		n.dc.SetColor(c)
+		n.dc.SetLineWidth(float64(strokeWidth) * n.scale)
+		if strokeDash != 0 {
+			dashSize, gapSize := svg.GetStrokeDashAttributes(float64(strokeWidth), strokeDash)
+			n.dc.SetDash(dashSize*n.scale, gapSize*n.scale)
+		} else {
+			n.dc.SetDash()
+		}
+		n.dc.StrokePreserve()
+	}
+	n.dc.ClearPath()
+}
+
+func (n *nativeCanvas) rect(x, y, width, height, radius float64) {
+	if radius > 0 {
+		n.dc.DrawRoundedRectangle(x, y, width, height, math.Min(radius, math.Min(width, height)/2))
+	} else {
+		n.dc.DrawRectangle(x, y, width, height)
+	}
+}
+
+func (n *nativeCanvas) path(cmds []svg.PathCommand) {
+	for _, c := range cmds {
+		switch c.Op {
+		case 'M':
+			n.dc.MoveTo(c.Points[0].X, c.Points[0].Y)
+		case 'L':
+			n.dc.LineTo(c.Points[0].X, c.Points[0].Y)
+		case 'C':
+			n.dc.CubicTo(c.Points[0].X, c.Points[0].Y, c.Points[1].X, c.Points[1].Y, c.Points[2].X, c.Points[2].Y)
+		case 'Z':
+			n.dc.ClosePath()
+		}
+	}
+}
+
+// setFont sets the font of text drawn next, at size in pixels of the diagram.
+func (n *nativeCanvas) setFont(mono, bold, italic bool, size float64) {
+	family := n.fontFamily
+	if mono {
+		family = d2fonts.SourceCodePro
+	}
+	style := d2fonts.FONT_STYLE_REGULAR
+	if bold {
+		style = d2fonts.FONT_STYLE_BOLD
+	} else if italic {
+		style = d2fonts.FONT_STYLE_ITALIC
+	}
+	f := family.Font(0, style)
+	key := faceKey{font: f, size: size * n.scale}
+	face, ok := n.faces[key]
+	if !ok {
+		sf, ok := n.fonts[f]
+		if !ok {
+			ttf, ok := d2fonts.FontFaces.Lookup(f)
+			if !ok {
+				ttf = d2fonts.FontFaces.Get(family.Font(0, d2fonts.FONT_STYLE_REGULAR))
+			}
+			var err error
+			sf, err = opentype.Parse(ttf)
+			if err != nil {
+				return
+			}
+			n.fonts[f] = sf
+		}
+		var err error
+		face, err = opentype.NewFace(sf, &opentype.FaceOptions{
+			Size:    key.size,
+			DPI:     72,
+			Hinting: font.HintingNone,
+		})
+		if err != nil {
+			return
+		}
+		n.faces[key] = face
+	}
+	n.dc.SetFontFace(face)
+}
+
+// text draws the lines of text centered in the box of a label at tl, the way d2svg does.
+func (n *nativeCanvas) text(text, fontColor string, tl *geo.Point, width, height float64, fontSize int, underline bool) {
+	c, ok := n.color(fontColor)
+	if !ok {
+		if fontColor != "" {
+			return
+		}
+		c, _ = n.color(d2target.FG_COLOR)
+	}
+	lines := strings.Split(text, "\n")
+	cx := tl.X + width/2
+	y := tl.Y + float64(fontSize)
+	for _, line := range lines {
+		// Glyphs are rasterized at the size of their face, so text is drawn untransformed
+		// at the point it would be transformed to.
+		x, ty := n.dc.TransformPoint(cx, y)
+		lineWidth, _ := n.dc.MeasureString(line)
+		n.dc.Push()
+		n.dc.Identity()
+		n.dc.SetColor(c)
+		n.dc.DrawString(line, x-lineWidth/2, ty)
+		if underline {
+			n.dc.SetLineWidth(math.Max(1, float64(fontSize)*n.scale/16))
+			n.dc.SetDash()
+			uy := ty + float64(fontSize)*n.scale/10
+			n.dc.DrawLine(x-lineWidth/2, uy, x+lineWidth/2, uy)
+			n.dc.Stroke()
+		}
+		n.dc.Pop()
+		y += height / float64(len(lines))
+	}
+}
+
+func (n *nativeCanvas) drawShape(s d2target.Shape) {
+	n.opacity = s.Opacity
+	defer func() {
+		n.opacity = 1
+	}()
+	tl := geo.NewPoint(float64(s.Pos.X), float64(s.Pos.Y))
+	width := float64(s.Width)
+	height := float64(s.Height)
+	fill, stroke := d2themes.ShapeTheme(s)
+	shapeType := d2target.DSL_SHAPE_TO_SHAPE_TYPE[s.Type]
+
+	sh := shape.NewShapeWithPath(shapeType, geo.NewBox(tl, width, height), s.ShapePath)
+	if shapeType == shape.CLOUD_TYPE && s.ContentAspectRatio != nil {
+		sh.SetInnerBoxAspectRatio(*s.ContentAspectRatio)
+	}
+
+	tls := []*geo.Point{tl}
+	if s.Multiple {
+		tls = []*geo.Point{tl.AddVector(geo.NewVector(d2target.MULTIPLE_OFFSET, -d2target.MULTIPLE_OFFSET)), tl}
+	}
+
+	switch s.Type {
+	case d2target.ShapeOval:
+		for _, otl := range tls {
+			n.dc.DrawEllipse(otl.X+width/2, otl.Y+height/2, width/2, height/2)
+			n.paint(fill, stroke, s.StrokeWidth, s.StrokeDash)
+			if s.DoubleBorder {
+				n.dc.DrawEllipse(otl.X+width/2, otl.Y+height/2, width/2-d2target.INNER_BORDER_OFFSET, height/2-d2target.INNER_BORDER_OFFSET)
+				n.paint(fill, stroke, s.StrokeWidth, s.StrokeDash)
+			}
+		}
+	case d2target.ShapeRectangle, d2target.ShapeSequenceDiagram, d2target.ShapeHierarchy, "":
+		radius := float64(s.BorderRadius)
+		for _, rtl := range tls {
+			n.rect(rtl.X, rtl.Y, width, height, radius)
+			n.paint(fill, stroke, s.StrokeWidth, s.StrokeDash)
+			if s.DoubleBorder {
+				innerFill := fill
+				if rtl == tl {
+					innerFill = ""
+				}
+				n.rect(rtl.X+d2target.INNER_BORDER_OFFSET, rtl.Y+d2target.INNER_BORDER_OFFSET,
+					width-2*d2target.INNER_BORDER_OFFSET, height-2*d2target.INNER_BORDER_OFFSET, radius)
+				n.paint(innerFill, stroke, s.StrokeWidth, s.StrokeDash)
+			}
+		}
+	case d2target.ShapeText:
+	case d2target.ShapeClass, d2target.ShapeSQLTable:
+		n.drawRows(s, fill, stroke)
+		return
+	case d2target.ShapeCode:
+		n.rect(tl.X, tl.Y, width, height, float64(s.BorderRadius))
+		n.paint(fill, stroke, s.StrokeWidth, s.StrokeDash)
+		n.setFont(true, false, false, float64(s.FontSize))
+		n.leftText(s.Label, s.GetFontColor(), tl.X+(width-float64(s.LabelWidth))/2, tl.Y+(height-float64(s.LabelHeight))/2, float64(s.FontSize))
+		return
+	case d2target.ShapeImage:
+	default:
+		for _, ptl := range tls {
+			psh := sh
+			if ptl != tl {
+				psh = shape.NewShapeWithPath(shapeType, geo.NewBox(ptl, width, height), s.ShapePath)
+			}
+			for _, d := range psh.GetSVGPathData() {
+				n.path(svg.ParsePath(d))
+				n.paint(fill, stroke, s.StrokeWidth, s.StrokeDash)
+			}
+		}
+	}
+
+	if s.Label == "" || s.Opacity == 0 {
+		return
+	}
+	labelPosition := label.FromString(s.LabelPosition)
+	var box *geo.Box
+	if labelPosition.IsOutside() {
+		box = sh.GetBox().Copy()
+		if s.Multiple {
+			box.TopLeft.Y -= d2target.MULTIPLE_OFFSET
+			box.Height += d2target.MULTIPLE_OFFSET
+			box.Width += d2target.MULTIPLE_OFFSET
+		}
+	} else {
+		box = sh.GetInnerBox()
+	}
+	labelTL := labelPosition.GetPointOnBox(box, label.PADDING, float64(s.LabelWidth), float64(s.LabelHeight))
+	if s.LabelFill != "" {
+		n.rect(labelTL.X, labelTL.Y, float64(s.LabelWidth), float64(s.LabelHeight), 0)
+		n.paint(s.LabelFill, "", 0, 0)
+	}
+	n.setFont(s.FontFamily == "mono", s.Bold, s.Italic, float64(s.FontSize))
+	n.text(s.Label, s.GetFontColor(), labelTL, float64(s.LabelWidth), float64(s.LabelHeight), s.FontSize, s.Underline)
+}
+
+// drawRows draws class and sql_table shapes as a header of their label over their fields,
+// methods or columns, without the alignment of their columns in d2svg.
+func (n *nativeCanvas) drawRows(s d2target.Shape, fill, stroke string) {
+	var rows []string
+	if s.Type == d2target.ShapeClass {
+		for _, f := range s.Fields {
+			rows = append(rows, f.VisibilityToken()+" "+f.Name+" "+f.Type)
+		}
+		for _, m := range s.Methods {
+			rows = append(rows, m.VisibilityToken()+" "+m.Name+" "+m.Return)
+		}
+	} else {
+		for _, c := range s.Columns {
+			rows = append(rows, strings.TrimSpace(c.Name.Label+" "+c.Type.Label+" "+c.ConstraintAbbr()))
+		}
+	}
+	x, y := float64(s.Pos.X), float64(s.Pos.Y)
+	width, height := float64(s.Width), float64(s.Height)
+	rowHeight := height / float64(1+len(rows))
+	if s.Type == d2target.ShapeClass {
+		// Class headers are as tall as two rows in d2svg.
+		rowHeight = height / float64(2+len(rows))
+	}
+	n.rect(x, y, width, height, float64(s.BorderRadius))
+	n.paint(fill, stroke, s.StrokeWidth, s.StrokeDash)
+	headerHeight := height - rowHeight*float64(len(rows))
+	n.rect(x, y, width, headerHeight, 0)
+	n.paint(s.Fill, "", 0, 0)
+	n.setFont(false, true, false, float64(s.FontSize))
+	n.text(s.Label, s.GetFontColor(), geo.NewPoint(x, y+(headerHeight-float64(s.LabelHeight))/2), width, float64(s.LabelHeight), s.FontSize, false)
+
+	n.setFont(true, false, false, float64(s.FontSize))
+	for i, row := range rows {
+		n.leftText(row, s.PrimaryAccentColor, x+d2target.NamePadding, y+headerHeight+rowHeight*float64(i)+(rowHeight-float64(s.FontSize))/2, float64(s.FontSize))
+	}
+}
+
+// leftText draws the lines of text from x, the first one's top at y.
+func (n *nativeCanvas) leftText(text, fontColor string, x, y, fontSize float64) {
+	c, ok := n.color(fontColor)
+	if !ok {
+		c, _ = n.color(d2target.FG_COLOR)
+	}
+	for i, line := range strings.Split(text, "\n") {
+		tx, ty := n.dc.TransformPoint(x, y+fontSize*(float64(i)*1.3+0.8))
+		n.dc.Push()
+		n.dc.Identity()
+		n.dc.SetColor(c)
+		n.dc.DrawString(line, tx, ty)
+		n.dc.Pop()
+	}
+}
+
+func (n *nativeCanvas) drawConnection(c d2target.Connection) {
+	n.opacity = c.Opacity
+	defer func() {
+		n.opacity = 1
+	}()
+
+	cmds := svg.ParsePath(d2svg.ConnectionPathData(c, n.idToShape))
+	strokeDash := c.StrokeDash
+	if strokeDash == 0 && c.Animated {
+		strokeDash = 5
+	}
+
+	var labelTL *geo.Point
+	if c.Label != "" {
+		labelTL = c.GetLabelTopLeft()
+		labelTL.X = math.Round(labelTL.X)
+		labelTL.Y = math.Round(labelTL.Y)
+	}
+
+	// The stroke is masked out of the box of labels on the connection, inverting a clip to it.
+	if labelTL != nil && label.FromString(c.LabelPosition).IsOnEdge() {
+		n.dc.DrawRectangle(labelTL.X, labelTL.Y, float64(c.LabelWidth), float64(c.LabelHeight))
+		n.dc.Clip()
+		n.dc.InvertMask()
+	}
+	n.dc.SetLineCapRound()
+	n.dc.SetLineJoinRound()
+	n.path(cmds)
+	n.paint("", c.Stroke, c.StrokeWidth, strokeDash)
+	n.dc.SetLineCapButt()
+	n.dc.SetLineJoinBevel()
+	n.dc.ResetClip()
+
+	if len(cmds) > 0 {
+		start, startDir, end, endDir := svg.PathEnds(cmds)
+		if c.SrcArrow != d2target.NoArrowhead {
+			n.drawArrowhead(c, false, start, startDir)
+		}
+		if c.DstArrow != d2target.NoArrowhead {
+			n.drawArrowhead(c, true, end, endDir)
+		}
+	}
+
+	if c.Label != "" {
+		if c.Fill != d2color.Empty {
+			n.rect(labelTL.X, labelTL.Y, float64(c.LabelWidth), float64(c.LabelHeight), 0)
+			n.paint(c.Fill, "", 0, 0)
+		}
+		n.setFont(c.FontFamily == "mono", c.Bold, c.Italic, float64(c.FontSize))
+		n.text(c.Label, c.GetFontColor(), labelTL, float64(c.LabelWidth), float64(c.LabelHeight), c.FontSize, c.Underline)
+	}
+
+	for _, isDst := range []bool{false, true} {
+		l := c.SrcLabel
+		if isDst {
+			l = c.DstLabel
+		}
+		if l == nil || l.Label == "" {
+			continue
+		}
+		n.setFont(false, false, true, float64(c.FontSize))
+		fontColor := l.Color
+		if fontColor == "" {
+			fontColor = d2target.FG_COLOR
+		}
+		n.text(l.Label, fontColor, c.GetArrowheadLabelPosition(isDst), float64(l.LabelWidth), float64(l.LabelHeight), c.FontSize, false)
+	}
+}
+
+// drawArrowhead draws the arrowhead of c at p pointing along dir, transforming the context
+// into the coordinates of its marker.
+func (n *nativeCanvas) drawArrowhead(c d2target.Connection, isTarget bool, p geo.Point, dir geo.Point) {
+	if dir == (geo.Point{}) {
+		return
+	}
+	arrowhead := c.DstArrow
+	if !isTarget {
+		arrowhead = c.SrcArrow
+	}
+	m := arrowhead.Marker(isTarget, float64(c.StrokeWidth), c.Stroke)
+
+	n.dc.Push()
+	defer n.dc.Pop()
+	n.dc.Translate(p.X, p.Y)
+	n.dc.Rotate(math.Atan2(dir.Y, dir.X))
+	n.dc.Translate(-m.Ref.X, -m.Ref.Y)
+	n.dc.DrawRectangle(0, 0, m.Width, m.Height)
+	n.dc.Clip()
+	defer n.dc.ResetClip()
+
+	for _, el := range m.Elements {
+		if el.Radius > 0 {
+			n.dc.DrawCircle(el.Points[0].X, el.Points[0].Y, el.Radius)
+		} else {
+			n.dc.MoveTo(el.Points[0].X, el.Points[0].Y)
+			for _, pt := range el.Points[1:] {
+				n.dc.LineTo(pt.X, pt.Y)
+			}
+			if el.Closed {
+				n.dc.ClosePath()
+			}
+		}
+		n.paint(el.Fill, el.Stroke, c.StrokeWidth, 0)
+	}
+}
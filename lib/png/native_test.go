@@ -0,0 +1,169 @@
+package png
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"oss.terrastruct.com/util-go/assert"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2dagrelayout"
+	"oss.terrastruct.com/d2/d2lib"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/geo"
+	"oss.terrastruct.com/d2/lib/log"
+	"oss.terrastruct.com/d2/lib/textmeasure"
+)
+
+func TestDrawNative(t *testing.T) {
+	t.Parallel()
+
+	diagram := compile(t, `a: {shape: oval; style.multiple: true}
+b: {shape: cylinder; style.fill: "#ff0000"}
+c: {style.double-border: true; style.border-radius: 4; style.opacity: 0.5}
+a -> b: on the edge {target-arrowhead.shape: diamond}
+b <-> c: {source-arrowhead.shape: cf-many; target-arrowhead.shape: circle}
+c -- a: {style.stroke-dash: 3; style.animated: true; source-arrowhead: 1; target-arrowhead: *}
+`)
+	if !CanDrawNative(diagram) {
+		t.Fatal("expected diagram to be drawable without a browser")
+	}
+
+	out, err := DrawNative(diagram, 0, 100, 1, nil, Size{})
+	assert.Success(t, err)
+	img, err := png.Decode(bytes.NewReader(out))
+	assert.Success(t, err)
+	tl, br := diagram.BoundingBox()
+	assert.Equal(t, int(SCALE)*(br.X-tl.X+200), img.Bounds().Dx())
+	assert.Equal(t, int(SCALE)*(br.Y-tl.Y+200), img.Bounds().Dy())
+
+	// the padding is the background of the theme and b is filled
+	assert.Equal(t, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, pixel(img, 0, 0))
+	var b d2target.Shape
+	for _, s := range diagram.Shapes {
+		if s.ID == "b" {
+			b = s
+		}
+	}
+	x := int(SCALE) * (b.Pos.X + b.Width/2 - tl.X + 100)
+	y := int(SCALE) * (b.Pos.Y + b.Height/2 - tl.Y + 100)
+	assert.Equal(t, color.RGBA{R: 0xff, A: 0xff}, pixel(img, x, y))
+
+	// regions are the same pixels of the whole PNG
+	out, err = DrawNative(diagram, 0, 100, 1, nil, Size{Region: image.Rect(x-10, y-10, x+10, y+10)})
+	assert.Success(t, err)
+	region, err := png.Decode(bytes.NewReader(out))
+	assert.Success(t, err)
+	assert.Equal(t, 20, region.Bounds().Dx())
+	assert.Equal(t, pixel(img, x, y), pixel(region, 10, 10))
+	assert.Equal(t, pixel(img, x-10, y+9), pixel(region, 0, 19))
+
+	out, err = DrawNative(diagram, 0, 100, 1, nil, Size{Width: 300, Height: 300})
+	assert.Success(t, err)
+	cfg, err := png.DecodeConfig(bytes.NewReader(out))
+	assert.Success(t, err)
+	assert.Equal(t, 300, cfg.Width)
+	assert.Equal(t, 300, cfg.Height)
+
+	// crops are padded like the whole diagram
+	crop := geo.NewBox(geo.NewPoint(float64(b.Pos.X), float64(b.Pos.Y)), float64(b.Width), float64(b.Height))
+	out, err = DrawNative(diagram, 0, 10, 1, crop, Size{})
+	assert.Success(t, err)
+	cropped, err := png.Decode(bytes.NewReader(out))
+	assert.Success(t, err)
+	assert.Equal(t, int(SCALE)*(b.Width+20), cropped.Bounds().Dx())
+	assert.Equal(t, color.RGBA{R: 0xff, A: 0xff}, pixel(cropped, int(SCALE)*(b.Width/2+10), int(SCALE)*(b.Height/2+10)))
+}
+
+func TestCanDrawNative(t *testing.T) {
+	t.Parallel()
+
+	for _, script := range []string{
+		"md: |md\n  # Title\n|",
+		"x: {icon: https://icons.terrastruct.com/essentials/004-picture.svg}",
+		"x: {tooltip: hello}",
+		"x: {style.fill-pattern: dots}",
+		"x: {shape: sql_table; id: int}",
+	} {
+		if CanDrawNative(compile(t, script)) {
+			t.Fatalf("expected %q to need a browser", script)
+		}
+	}
+}
+
+func TestNativeApproximations(t *testing.T) {
+	t.Parallel()
+
+	diagram := compile(t, `t: {shape: sql_table; id: int}
+c: {shape: class; +f: int}
+x: {tooltip: hello; style.shadow: true}
+y: {tooltip: world}
+t -> c`)
+	assert.Equal(t, "SQL tables, classes, tooltips, shadows", strings.Join(NativeApproximations(diagram), ", "))
+
+	// approximations are still drawn
+	out, err := DrawNative(diagram, 0, 100, 1, nil, Size{})
+	assert.Success(t, err)
+	_, err = png.Decode(bytes.NewReader(out))
+	assert.Success(t, err)
+}
+
+func TestCanvasSize(t *testing.T) {
+	t.Parallel()
+
+	w, h, box := canvasSize(100, 50, Size{})
+	assert.Equal(t, 200, w)
+	assert.Equal(t, 100, h)
+	assert.Equal(t, 200., box.Width)
+
+	// with both dimensions, the image is fit and centered within them
+	w, h, box = canvasSize(100, 50, Size{Width: 300, Height: 300})
+	assert.Equal(t, 300, w)
+	assert.Equal(t, 300, h)
+	assert.Equal(t, 75., box.TopLeft.Y)
+	assert.Equal(t, 150., box.Height)
+
+	w, h, box = canvasSize(100, 50, Size{Width: 300, Height: 300, Region: image.Rect(100, 100, 200, 200)})
+	assert.Equal(t, 100, w)
+	assert.Equal(t, 100, h)
+	assert.Equal(t, -25., box.TopLeft.Y)
+
+	w, h, _ = canvasSize(100_000, 10, Size{})
+	assert.Equal(t, maxDimension, w)
+	assert.Equal(t, 3, h)
+}
+
+func TestFullSize(t *testing.T) {
+	t.Parallel()
+
+	svg := []byte(`<?xml version="1.0" encoding="utf-8"?><svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100000 10" width="100000" height="10"><svg class="d2" width="100000" height="10">`)
+	// regions can be of PNGs past the limits of the size of PNGs
+	w, h, err := FullSize(svg, Size{})
+	assert.Success(t, err)
+	assert.Equal(t, 200_000, w)
+	assert.Equal(t, 20, h)
+}
+
+func pixel(img image.Image, x, y int) color.RGBA {
+	r, g, b, a := img.At(x, y).RGBA()
+	return color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: uint8(a >> 8)}
+}
+
+func compile(t *testing.T, script string) *d2target.Diagram {
+	ctx := log.WithTB(context.Background(), t, nil)
+	ruler, err := textmeasure.NewRuler()
+	assert.Success(t, err)
+	diagram, _, err := d2lib.Compile(ctx, script, &d2lib.CompileOptions{
+		Ruler: ruler,
+		LayoutResolver: func(engine string) (d2graph.LayoutGraph, error) {
+			return d2dagrelayout.DefaultLayout, nil
+		},
+	}, nil)
+	assert.Success(t, err)
+	return diagram
+}
@@ -3,8 +3,17 @@ package png
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/binary"
 	"fmt"
+	"image"
+	"math"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	_ "embed"
 
@@ -19,17 +28,61 @@ import (
 // ConvertSVG scales the image by 2x
 const SCALE = 2.
 
+// Playwright is a browser to convert SVGs with. It is started once and reused for every
+// board and file, as launching a browser is much slower than converting an SVG.
 type Playwright struct {
 	PW      *playwright.Playwright
 	Browser playwright.Browser
-	Page    playwright.Page
+	// Page is the first page of the pool of pages Convert runs conversions on.
+	Page playwright.Page
+
+	pages *pagePool
+	// browserPath is the browser launched, Chromium downloaded by Playwright if empty.
+	browserPath string
+}
+
+// maxPages is the number of pages Convert runs conversions on in parallel.
+var maxPages = min(runtime.NumCPU(), 8)
+
+// pagePool is the pool of pages of a browser context. Pages are created as conversions
+// need them, up to maxPages.
+type pagePool struct {
+	context playwright.BrowserContext
+	sem     chan struct{}
+
+	mu   sync.Mutex
+	idle []playwright.Page
+}
+
+func (pp *pagePool) acquire() (playwright.Page, error) {
+	pp.sem <- struct{}{}
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if n := len(pp.idle); n > 0 {
+		page := pp.idle[n-1]
+		pp.idle = pp.idle[:n-1]
+		return page, nil
+	}
+	page, err := pp.context.NewPage()
+	if err != nil {
+		<-pp.sem
+		return nil, fmt.Errorf("failed to start new Playwright page: %w", err)
+	}
+	return page, nil
+}
+
+func (pp *pagePool) release(page playwright.Page) {
+	pp.mu.Lock()
+	pp.idle = append(pp.idle, page)
+	pp.mu.Unlock()
+	<-pp.sem
 }
 
 func (pw *Playwright) RestartBrowser() (Playwright, error) {
 	if err := pw.Browser.Close(); err != nil {
 		return Playwright{}, fmt.Errorf("failed to close Playwright browser: %w", err)
 	}
-	return startPlaywright(pw.PW)
+	return startPlaywright(pw.PW, pw.browserPath)
 }
 
 func (pw *Playwright) Cleanup() error {
@@ -42,9 +95,16 @@ func (pw *Playwright) Cleanup() error {
 	return nil
 }
 
-func startPlaywright(pw *playwright.Playwright) (Playwright, error) {
-	browser, err := pw.Chromium.Launch()
+func startPlaywright(pw *playwright.Playwright, browserPath string) (Playwright, error) {
+	var opts playwright.BrowserTypeLaunchOptions
+	if browserPath != "" {
+		opts.ExecutablePath = &browserPath
+	}
+	browser, err := pw.Chromium.Launch(opts)
 	if err != nil {
+		if browserPath != "" {
+			return Playwright{}, fmt.Errorf("failed to launch %s: %w", browserPath, err)
+		}
 		return Playwright{}, fmt.Errorf("failed to launch Chromium: %w", err)
 	}
 	context, err := browser.NewContext()
@@ -59,23 +119,32 @@ func startPlaywright(pw *playwright.Playwright) (Playwright, error) {
 		PW:      pw,
 		Browser: browser,
 		Page:    page,
+		pages: &pagePool{
+			context: context,
+			sem:     make(chan struct{}, maxPages),
+			idle:    []playwright.Page{page},
+		},
+		browserPath: browserPath,
 	}, nil
 }
 
-func InitPlaywright() (Playwright, error) {
+// InitPlaywright downloads Playwright, and Chromium unless browserPath is the path of an
+// installed Chromium, Chrome or Edge to launch instead, and starts the browser.
+func InitPlaywright(browserPath string) (Playwright, error) {
 	err := playwright.Install(&playwright.RunOptions{
-		Verbose:  false,
-		Browsers: []string{"chromium"},
+		Verbose:             false,
+		Browsers:            []string{"chromium"},
+		SkipInstallBrowsers: browserPath != "",
 	})
 	if err != nil {
-		return Playwright{}, fmt.Errorf("failed to install Playwright: %w", err)
+		return Playwright{}, fmt.Errorf("failed to install Playwright: %w%s", err, downloadHint(err))
 	}
 
 	pw, err := playwright.Run()
 	if err != nil {
 		return Playwright{}, fmt.Errorf("failed to run Playwright: %w", err)
 	}
-	return startPlaywright(pw)
+	return startPlaywright(pw, browserPath)
 }
 
 //go:embed generate_png.js
@@ -83,13 +152,72 @@ var genPNGScript string
 
 const pngPrefix = "data:image/png;base64,"
 
+// Size is the size of the PNG an SVG is converted into.
+type Size struct {
+	// Scale is the number of pixels of the PNG per pixel of the SVG, SCALE if 0.
+	Scale float64
+	// Width and Height are the dimensions of the PNG in pixels and override Scale. With both,
+	// the SVG is fit and centered within them, else the other follows its aspect ratio.
+	Width  int
+	Height int
+	// Region is the part of the PNG to convert in its pixels, all of it if empty, e.g. a tile
+	// of a PNG too large to convert at once. The limits of the size of PNGs only apply to
+	// the region.
+	Region image.Rectangle
+}
+
+// DPI returns the resolution of PNGs of size, a pixel of the SVG being a CSS pixel of 1/96
+// of an inch, or 0 if it depends on the size of the SVG.
+func (s Size) DPI() float64 {
+	if s.Width > 0 || s.Height > 0 {
+		return 0
+	}
+	if s.Scale > 0 {
+		return 96 * s.Scale
+	}
+	return 96 * SCALE
+}
+
+var svgDimensions = regexp.MustCompile(`<svg [^>]*?width="([0-9.]+)" height="([0-9.]+)"`)
+
+// FullSize returns the size of the PNG of size svg is converted into before the limits of the
+// size of PNGs, which its regions can exceed.
+func FullSize(svg []byte, size Size) (width, height int, err error) {
+	m := svgDimensions.FindSubmatch(svg)
+	if m == nil {
+		return 0, 0, fmt.Errorf("failed to find the dimensions of the SVG")
+	}
+	imgWidth, err := strconv.ParseFloat(string(m[1]), 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	imgHeight, err := strconv.ParseFloat(string(m[2]), 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	w, h, _ := fullSize(imgWidth, imgHeight, size)
+	return int(w), int(h), nil
+}
+
 // ConvertSVG converts the given SVG into a PNG.
 // Note that the resulting PNG has 2x the size (width and height) of the original SVG (see generate_png.js)
 func ConvertSVG(page playwright.Page, svg []byte) ([]byte, error) {
+	return ConvertSVGSize(page, svg, Size{})
+}
+
+// ConvertSVGSize converts the given SVG into a PNG of size.
+func ConvertSVGSize(page playwright.Page, svg []byte, size Size) ([]byte, error) {
+	scale := size.Scale
+	if scale <= 0 {
+		scale = SCALE
+	}
 	encodedSVG := base64.StdEncoding.EncodeToString(svg)
 	pngInterface, err := page.Evaluate(genPNGScript, map[string]interface{}{
 		"imgString": "data:image/svg+xml;charset=utf-8;base64," + encodedSVG,
-		"scale":     int(SCALE),
+		"scale":     scale,
+		"width":     size.Width,
+		"height":    size.Height,
+		"region":    region(size.Region),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate png: %w", err)
@@ -106,7 +234,91 @@ func ConvertSVG(page playwright.Page, svg []byte) ([]byte, error) {
 	return base64.StdEncoding.DecodeString(splicedPNGString)
 }
 
-func AddExif(png []byte) ([]byte, error) {
+// region returns r as the region of generate_png.js, nil if empty.
+func region(r image.Rectangle) map[string]interface{} {
+	if r.Empty() {
+		return nil
+	}
+	return map[string]interface{}{
+		"x":      r.Min.X,
+		"y":      r.Min.Y,
+		"width":  r.Dx(),
+		"height": r.Dy(),
+	}
+}
+
+// Conversion is a conversion of an SVG into a PNG started with Convert.
+type Conversion struct {
+	done chan struct{}
+	png  []byte
+	err  error
+	dur  time.Duration
+}
+
+// Wait waits for the conversion to finish and returns the PNG.
+func (c *Conversion) Wait() ([]byte, error) {
+	<-c.done
+	return c.png, c.err
+}
+
+// Duration waits for the conversion to finish and returns how long converting took, not
+// counting the wait for a free page.
+func (c *Conversion) Duration() time.Duration {
+	<-c.done
+	return c.dur
+}
+
+// Converted returns a finished conversion into png, e.g. one cached from an earlier
+// export.
+func Converted(png []byte) *Conversion {
+	c := &Conversion{
+		done: make(chan struct{}),
+		png:  png,
+	}
+	close(c.done)
+	return c
+}
+
+// Convert starts converting svg into a PNG on the next free page of the browser, so that
+// the boards of multi-board exports are converted in parallel while they are rendered.
+func (pw *Playwright) Convert(svg []byte) *Conversion {
+	return pw.ConvertSize(svg, Size{})
+}
+
+// ConvertSize is Convert into a PNG of size.
+func (pw *Playwright) ConvertSize(svg []byte, size Size) *Conversion {
+	c := &Conversion{
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(c.done)
+		page, err := pw.pages.acquire()
+		if err != nil {
+			c.err = err
+			return
+		}
+		defer pw.pages.release(page)
+		start := time.Now()
+		c.png, c.err = ConvertSVGSize(page, svg, size)
+		c.dur = time.Since(start)
+	}()
+	return c
+}
+
+// Metadata is the metadata AddExif embeds in PNGs along with the version of D2.
+type Metadata struct {
+	Title  string
+	Author string
+	// Source is a hash of the D2 source the PNG was rendered from.
+	Source string
+	// Fields are custom key-values. Keys are at most 79 printable ASCII characters.
+	Fields map[string]string
+}
+
+// AddExif embeds the version of D2 and meta in png. The title and author are written to both
+// EXIF and PNG text chunks, the source and fields only to text chunks as EXIF has no tags for
+// them.
+func AddExif(png []byte, meta Metadata) ([]byte, error) {
 	// https://pkg.go.dev/github.com/dsoprea/go-png-image-structure/v2?utm_source=godoc#example-ChunkSlice.SetExif
 	im, err := exifcommon.NewIfdMappingWithStandard()
 	if err != nil {
@@ -126,6 +338,18 @@ func AddExif(png []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if meta.Title != "" {
+		err = ib.AddStandardWithName("ImageDescription", meta.Title)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if meta.Author != "" {
+		err = ib.AddStandardWithName("Artist", meta.Author)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	pmp := pngstruct.NewPngMediaParser()
 	intfc, err := pmp.ParseBytes(png)
@@ -137,11 +361,101 @@ func AddExif(png []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	texts := []*pngstruct.Chunk{
+		textChunk("Title", meta.Title),
+		textChunk("Author", meta.Author),
+		textChunk("Source", meta.Source),
+	}
+	keys := make([]string, 0, len(meta.Fields))
+	for k := range meta.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		texts = append(texts, textChunk(k, meta.Fields[k]))
+	}
+	chunks := make([]*pngstruct.Chunk, 0, len(cs.Chunks())+len(texts))
+	for _, c := range cs.Chunks() {
+		if c.Type == "IDAT" {
+			// Text chunks before the image data are read without decoding it.
+			for _, t := range texts {
+				if t != nil {
+					chunks = append(chunks, t)
+				}
+			}
+			texts = nil
+		}
+		chunks = append(chunks, c)
+	}
 	b := new(bytes.Buffer)
-	err = cs.WriteTo(b)
+	err = pngstruct.NewChunkSlice(chunks).WriteTo(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// textChunk returns an uncompressed iTXt chunk of the UTF-8 value of keyword, nil for empty
+// values.
+func textChunk(keyword, value string) *pngstruct.Chunk {
+	if value == "" {
+		return nil
+	}
+	// The keyword, compression flag and method, and empty language tag and translated keyword.
+	data := append([]byte(keyword), 0, 0, 0, 0, 0)
+	data = append(data, value...)
+	c := &pngstruct.Chunk{
+		Type:   "iTXt",
+		Length: uint32(len(data)),
+		Data:   data,
+	}
+	c.UpdateCrc32()
+	return c
+}
+
+// SetDPI sets the resolution of png to dpi dots per inch, for image viewers and documents to
+// display it at its intended physical size rather than by its pixels.
+func SetDPI(png []byte, dpi float64) ([]byte, error) {
+	pmp := pngstruct.NewPngMediaParser()
+	intfc, err := pmp.ParseBytes(png)
 	if err != nil {
 		return nil, err
 	}
+	cs := intfc.(*pngstruct.ChunkSlice)
 
+	// The pHYs chunk is in pixels per meter.
+	ppm := uint32(math.Round(dpi / 0.0254))
+	data := make([]byte, 9)
+	binary.BigEndian.PutUint32(data[0:], ppm)
+	binary.BigEndian.PutUint32(data[4:], ppm)
+	data[8] = 1
+	phys := &pngstruct.Chunk{
+		Type:   "pHYs",
+		Length: uint32(len(data)),
+		Data:   data,
+	}
+	phys.UpdateCrc32()
+
+	chunks := make([]*pngstruct.Chunk, 0, len(cs.Chunks())+1)
+	for _, c := range cs.Chunks() {
+		switch c.Type {
+		case "pHYs":
+			continue
+		case "IDAT":
+			// pHYs must come before the image data.
+			if phys != nil {
+				chunks = append(chunks, phys)
+				phys = nil
+			}
+		}
+		chunks = append(chunks, c)
+	}
+	b := new(bytes.Buffer)
+	err = pngstruct.NewChunkSlice(chunks).WriteTo(b)
+	if err != nil {
+		return nil, err
+	}
 	return b.Bytes(), nil
 }
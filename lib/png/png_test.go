@@ -0,0 +1,93 @@
+package png
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"net/url"
+	"strings"
+	"testing"
+
+	"oss.terrastruct.com/util-go/assert"
+)
+
+func TestSetDPI(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	err := png.Encode(&b, image.NewRGBA(image.Rect(0, 0, 4, 3)))
+	assert.Success(t, err)
+
+	out, err := SetDPI(b.Bytes(), 192)
+	assert.Success(t, err)
+	img, err := png.Decode(bytes.NewReader(out))
+	assert.Success(t, err)
+	assert.Equal(t, 4, img.Bounds().Dx())
+
+	// 192 DPI is 7559 pixels per meter
+	phys := []byte{'p', 'H', 'Y', 's', 0, 0, 0x1d, 0x87, 0, 0, 0x1d, 0x87, 1}
+	i := bytes.Index(out, phys)
+	if i == -1 || i > bytes.Index(out, []byte("IDAT")) {
+		t.Fatal("expected a pHYs chunk before the image data")
+	}
+
+	// setting it again replaces it
+	out, err = SetDPI(out, 96)
+	assert.Success(t, err)
+	assert.Equal(t, 1, bytes.Count(out, []byte("pHYs")))
+}
+
+func TestAddExif(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	err := png.Encode(&b, image.NewRGBA(image.Rect(0, 0, 4, 3)))
+	assert.Success(t, err)
+
+	meta := Metadata{
+		Title:  "Network",
+		Author: "Ops",
+		Fields: map[string]string{"team": "infra", "env": "prod"},
+	}
+	out, err := AddExif(b.Bytes(), meta)
+	assert.Success(t, err)
+	_, err = png.Decode(bytes.NewReader(out))
+	assert.Success(t, err)
+
+	title := bytes.Index(out, []byte("iTXtTitle\x00\x00\x00\x00\x00Network"))
+	if title == -1 || title > bytes.Index(out, []byte("IDAT")) {
+		t.Fatal("expected a title chunk before the image data")
+	}
+	// the author is also in the EXIF, empty values are left out and fields are sorted
+	assert.Equal(t, 2, bytes.Count(out, []byte("Ops")))
+	assert.Equal(t, -1, bytes.Index(out, []byte("iTXtSource")))
+	if bytes.Index(out, []byte("iTXtenv")) > bytes.Index(out, []byte("iTXtteam")) {
+		t.Fatal("expected fields sorted by key")
+	}
+
+	again, err := AddExif(b.Bytes(), meta)
+	assert.Success(t, err)
+	assert.Equal(t, true, bytes.Equal(out, again))
+}
+
+func TestDownloadHint(t *testing.T) {
+	t.Parallel()
+
+	hint := downloadHint(errors.New("could not download driver: error: got non 200 status code: 407 (407 Proxy Authentication Required)"))
+	assert.Equal(t, true, strings.HasPrefix(hint, "\nThe proxy requires authentication"))
+	hint = downloadHint(fmt.Errorf("could not download driver: %w", &url.Error{Op: "Get", URL: "https://playwright.azureedge.net", Err: errors.New("x509: certificate signed by unknown authority")}))
+	assert.Equal(t, true, strings.HasPrefix(hint, "\nThe certificate of the download was not trusted"))
+	// failures unrelated to downloads only point to the alternatives
+	hint = downloadHint(errors.New("could not install browsers: exit status 1"))
+	assert.Equal(t, true, strings.HasPrefix(hint, "\nTo not download Chromium"))
+}
+
+func TestSizeDPI(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, 192., Size{}.DPI())
+	assert.Equal(t, 288., Size{Scale: 3}.DPI())
+	assert.Equal(t, 0., Size{Scale: 3, Width: 800}.DPI())
+}
@@ -0,0 +1,62 @@
+package pptx
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	CALLOUT_WIDTH     = EMUS_PER_INCH * 2
+	CALLOUT_FONT_SIZE = 10
+	// CALLOUT_GAP is the space between callouts and the shapes they point at.
+	CALLOUT_GAP = EMUS_PER_INCH / 4
+)
+
+// AddCallout adds a callout of text to slide, such as the tooltip of a shape, pointing at the
+// box at left, top of width by height in the pixels of the drawing of the slide. It is to the
+// right of the box unless it would be off the slide.
+func (p *Presentation) AddCallout(slide *Slide, text string, left, top, width, height float64) {
+	boxLeft := slide.ImageLeft + int(left*slide.ImageScaleFactor)
+	boxTop := slide.ImageTop + int(top*slide.ImageScaleFactor)
+	boxWidth := int(width * slide.ImageScaleFactor)
+	boxHeight := int(height * slide.ImageScaleFactor)
+
+	// An average character is half as wide as the font is tall.
+	charsPerLine := math.Max(1, float64(CALLOUT_WIDTH-2*91_440)/(CALLOUT_FONT_SIZE*EMUS_PER_POINT/2))
+	lines := 0
+	for _, line := range strings.Split(text, "\n") {
+		lines += int(math.Max(1, math.Ceil(float64(len([]rune(line)))/charsPerLine)))
+	}
+	calloutHeight := lines*CALLOUT_FONT_SIZE*EMUS_PER_POINT*6/5 + 2*45_720
+
+	tipX := boxLeft + boxWidth
+	x := tipX + CALLOUT_GAP
+	if x+CALLOUT_WIDTH > p.slideWidth() {
+		tipX = boxLeft
+		x = tipX - CALLOUT_GAP - CALLOUT_WIDTH
+	}
+	tipY := boxTop + boxHeight/2
+	y := boxTop
+	y = int(math.Max(float64(p.headerHeight()), math.Min(float64(y), float64(p.slideHeight()-calloutHeight))))
+
+	// The tip of the wedge is positioned relative to the center of the callout in
+	// thousandths of a percent of its size.
+	adj1 := (tipX - (x + CALLOUT_WIDTH/2)) * 100_000 / CALLOUT_WIDTH
+	adj2 := (tipY - (y + calloutHeight/2)) * 100_000 / calloutHeight
+
+	slide.callouts++
+	// Above the IDs of the elements of native slides.
+	id := 90_000 + slide.callouts
+	var b strings.Builder
+	fmt.Fprintf(&b, `<p:sp><p:nvSpPr><p:cNvPr id="%d" name="Callout %d"/><p:cNvSpPr/><p:nvPr/></p:nvSpPr>`, id, slide.callouts)
+	fmt.Fprintf(&b, `<p:spPr><a:xfrm><a:off x="%d" y="%d"/><a:ext cx="%d" cy="%d"/></a:xfrm>`, x, y, CALLOUT_WIDTH, calloutHeight)
+	fmt.Fprintf(&b, `<a:prstGeom prst="wedgeRectCallout"><a:avLst><a:gd name="adj1" fmla="val %d"/><a:gd name="adj2" fmla="val %d"/></a:avLst></a:prstGeom>`, adj1, adj2)
+	b.WriteString(`<a:solidFill><a:srgbClr val="FFF8DC"/></a:solidFill><a:ln w="9525"><a:solidFill><a:srgbClr val="8C8C8C"/></a:solidFill></a:ln></p:spPr>`)
+	b.WriteString(`<p:txBody><a:bodyPr wrap="square" lIns="91440" tIns="45720" rIns="91440" bIns="45720" anchor="ctr"/><a:lstStyle/>`)
+	for _, line := range strings.Split(text, "\n") {
+		fmt.Fprintf(&b, `<a:p><a:r><a:rPr lang="en-US" sz="%d" dirty="0"><a:solidFill><a:srgbClr val="333333"/></a:solidFill></a:rPr><a:t>%s</a:t></a:r></a:p>`, CALLOUT_FONT_SIZE*100, escape(line))
+	}
+	b.WriteString(`</p:txBody></p:sp>`)
+	slide.Elements += b.String()
+}
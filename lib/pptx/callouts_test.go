@@ -0,0 +1,51 @@
+package pptx
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"oss.terrastruct.com/util-go/assert"
+)
+
+func TestAddCallout(t *testing.T) {
+	t.Parallel()
+
+	p := NewPresentation("test", "", "test", "", "1", true)
+	slide := &Slide{ImageScaleFactor: 10_000, ImageLeft: 100_000, ImageTop: 500_000}
+	p.AddCallout(slide, "Where orders <begin>", 10, 10, 50, 20)
+	// near the right edge of the slide, the callout is to the left of the shape
+	p.AddCallout(slide, "Stripe", 880, 10, 20, 20)
+
+	offs := regexp.MustCompile(`<a:off x="(-?\d+)" y="(-?\d+)"/>`).FindAllStringSubmatch(slide.Elements, -1)
+	assert.Equal(t, 2, len(offs))
+	x, _ := strconv.Atoi(offs[0][1])
+	assert.Equal(t, 100_000+60*10_000+CALLOUT_GAP, x)
+	x, _ = strconv.Atoi(offs[1][1])
+	assert.Equal(t, 100_000+880*10_000-CALLOUT_GAP-CALLOUT_WIDTH, x)
+
+	if !strings.Contains(slide.Elements, "<a:t>Where orders &lt;begin&gt;</a:t>") {
+		t.Fatalf("expected the text of the callout:\n%s", slide.Elements)
+	}
+	assert.Equal(t, 2, strings.Count(slide.Elements, `prst="wedgeRectCallout"`))
+	assert.Equal(t, true, strings.Contains(slide.Elements, `id="90002"`))
+}
+
+func TestSaveCallouts(t *testing.T) {
+	t.Parallel()
+
+	p := NewPresentation("test", "", "test", "", "1", true)
+	diagram := compile(t, "a: {tooltip: Where orders begin}")
+	slide, err := p.AddNativeSlide(diagram, 0, 100, []BoardTitle{{Name: "root", BoardID: "root", LinkToSlide: 1}}, nil)
+	assert.Success(t, err)
+	// a is at the top left of the drawing, past its padding
+	a := diagram.Shapes[0]
+	p.AddCallout(slide, a.Tooltip, 100, 100, float64(a.Width), float64(a.Height))
+	pptx := saveNative(t, p, "x")
+	err = Validate(pptx, 2)
+	assert.Success(t, err)
+	if !strings.Contains(readZipFile(t, pptx, "ppt/slides/slide1.xml"), "<a:t>Where orders begin</a:t>") {
+		t.Fatal("expected the slide to have the callout")
+	}
+}
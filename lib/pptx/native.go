@@ -0,0 +1,483 @@
+package pptx
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/mazznoer/csscolorparser"
+
+	"oss.terrastruct.com/d2/d2renderers/d2fonts"
+	"oss.terrastruct.com/d2/d2renderers/d2svg"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/d2themes"
+	"oss.terrastruct.com/d2/d2themes/d2themescatalog"
+	"oss.terrastruct.com/d2/lib/color"
+	"oss.terrastruct.com/d2/lib/geo"
+	"oss.terrastruct.com/d2/lib/label"
+	"oss.terrastruct.com/d2/lib/shape"
+	"oss.terrastruct.com/d2/lib/svg"
+)
+
+// EMUS_PER_POINT is the number of EMUs in a point, the unit of font sizes and line widths.
+const EMUS_PER_POINT = 12_700
+
+// CanDrawNative returns whether AddNativeSlide can draw diagram. Markdown, LaTeX, code,
+// classes, SQL tables, images, icons and crow's foot arrowheads can only be drawn
+// rasterized.
+func CanDrawNative(diagram *d2target.Diagram) bool {
+	for _, s := range diagram.Shapes {
+		if s.Language != "" || s.Icon != nil {
+			return false
+		}
+		switch s.Type {
+		case d2target.ShapeClass, d2target.ShapeSQLTable, d2target.ShapeImage:
+			return false
+		}
+	}
+	for _, c := range diagram.Connections {
+		for _, a := range []d2target.Arrowhead{c.SrcArrow, c.DstArrow} {
+			switch a {
+			case d2target.CfOne, d2target.CfMany, d2target.CfOneRequired, d2target.CfManyRequired:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// arrowheadTypes are the DrawingML line end types of arrowheads.
+var arrowheadTypes = map[d2target.Arrowhead]string{
+	d2target.ArrowArrowhead:            "stealth",
+	d2target.UnfilledTriangleArrowhead: "triangle",
+	d2target.TriangleArrowhead:         "triangle",
+	d2target.LineArrowhead:             "arrow",
+	d2target.DiamondArrowhead:          "diamond",
+	d2target.FilledDiamondArrowhead:    "diamond",
+	d2target.CircleArrowhead:           "oval",
+	d2target.FilledCircleArrowhead:     "oval",
+}
+
+// AddNativeSlide adds a slide of diagram drawn as PowerPoint shapes, connectors and text
+// boxes rather than as a screenshot, so that its labels and colors can be edited. linkTo
+// returns the URL or else the number of the slide a link of a shape goes to. Shadows, 3D,
+// multiple and double borders, fill patterns and animations are not drawn.
+func (p *Presentation) AddNativeSlide(diagram *d2target.Diagram, themeID int64, pad int64, titlePath []BoardTitle, linkTo func(link string) (externalUrl string, slideIndex int)) (*Slide, error) {
+	tl, br := diagram.BoundingBox()
+	left := float64(tl.X) - float64(pad)
+	top := float64(tl.Y) - float64(pad)
+	width := float64(br.X-tl.X) + float64(pad)*2
+	height := float64(br.Y-tl.Y) + float64(pad)*2
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("diagram has no size")
+	}
+
+	slide := p.newSlide(titlePath, width, height, 1)
+	theme := d2themescatalog.Find(themeID)
+	if diagram.Config != nil {
+		theme.ApplyOverrides(diagram.Config.ThemeOverrides)
+	}
+	fontFamily := d2fonts.SourceSansPro
+	if diagram.FontFamily != nil {
+		fontFamily = *diagram.FontFamily
+	}
+	if p.template != nil && fontFamily == d2fonts.SourceSansPro {
+		// text takes the fonts of the theme of the template rather than the default one
+		fontFamily = ""
+	}
+	n := &nativeSlide{
+		slide:      slide,
+		theme:      &theme,
+		fontFamily: fontFamily,
+		linkTo:     linkTo,
+		left:       left,
+		top:        top,
+		scale:      slide.ImageScaleFactor,
+		// Below the IDs of the elements of the slide template.
+		nextID: 100,
+	}
+	var allObjects []d2svg.DiagramObject
+	allObjects, n.idToShape = d2svg.SortedObjects(diagram)
+	slide.elementIDs = make(map[string][]int, len(allObjects))
+	for _, obj := range allObjects {
+		firstID := n.nextID + 1
+		if c, ok := obj.(d2target.Connection); ok {
+			n.drawConnection(c)
+		} else {
+			n.drawShape(obj.(d2target.Shape))
+		}
+		for id := firstID; id <= n.nextID; id++ {
+			slide.elementIDs[obj.GetID()] = append(slide.elementIDs[obj.GetID()], id)
+		}
+	}
+	slide.Elements = n.b.String()
+	return slide, nil
+}
+
+// nativeSlide draws the shapes and connections of a diagram as the DrawingML elements of a
+// slide.
+type nativeSlide struct {
+	slide      *Slide
+	theme      *d2themes.Theme
+	fontFamily d2fonts.FontFamily
+	idToShape  map[string]d2target.Shape
+	linkTo     func(link string) (string, int)
+	// left and top are the coordinates of the diagram at the top left of the drawing.
+	left, top float64
+	// scale is the number of EMUs of a pixel of the diagram.
+	scale  float64
+	nextID int
+	b      strings.Builder
+}
+
+func (n *nativeSlide) x(x float64) int {
+	return n.slide.ImageLeft + n.emu(x-n.left)
+}
+
+func (n *nativeSlide) y(y float64) int {
+	return n.slide.ImageTop + n.emu(y-n.top)
+}
+
+// emu converts a length of the diagram to EMUs.
+func (n *nativeSlide) emu(l float64) int {
+	return int(math.Round(l * n.scale))
+}
+
+func (n *nativeSlide) id() int {
+	n.nextID++
+	return n.nextID
+}
+
+// color returns the DrawingML color of a color of the diagram, "" for no color.
+func (n *nativeSlide) color(c string, opacity float64) string {
+	switch strings.ToLower(c) {
+	case "", color.None, "transparent":
+		return ""
+	}
+	c = d2themes.ResolveThemeColor(*n.theme, c)
+	parsed, err := csscolorparser.Parse(c)
+	if err != nil {
+		return ""
+	}
+	alpha := parsed.A * math.Max(0, math.Min(1, opacity))
+	if alpha == 0 {
+		return ""
+	}
+	r, g, b, _ := parsed.RGBA255()
+	if alpha == 1 {
+		return fmt.Sprintf(`<a:srgbClr val="%02X%02X%02X"/>`, r, g, b)
+	}
+	return fmt.Sprintf(`<a:srgbClr val="%02X%02X%02X"><a:alpha val="%d"/></a:srgbClr>`, r, g, b, int(math.Round(alpha*100_000)))
+}
+
+func (n *nativeSlide) fill(c string, opacity float64) string {
+	if clr := n.color(c, opacity); clr != "" {
+		return "<a:solidFill>" + clr + "</a:solidFill>"
+	}
+	return "<a:noFill/>"
+}
+
+// line returns the line properties of a stroke, with ends for the arrowheads of
+// connections.
+func (n *nativeSlide) line(stroke string, strokeWidth int, strokeDash float64, opacity float64, ends string) string {
+	clr := n.color(stroke, opacity)
+	if clr == "" || strokeWidth <= 0 {
+		return "<a:ln><a:noFill/></a:ln>"
+	}
+	var dash string
+	if strokeDash != 0 {
+		dash = `<a:prstDash val="dash"/>`
+	}
+	return fmt.Sprintf(`<a:ln w="%d"><a:solidFill>%s</a:solidFill>%s<a:round/>%s</a:ln>`, n.emu(float64(strokeWidth)), clr, dash, ends)
+}
+
+// xfrm returns the transform of the box at x, y in the diagram.
+func (n *nativeSlide) xfrm(x, y, width, height float64) string {
+	return fmt.Sprintf(`<a:xfrm><a:off x="%d" y="%d"/><a:ext cx="%d" cy="%d"/></a:xfrm>`, n.x(x), n.y(y), n.emu(width), n.emu(height))
+}
+
+// textStyle is the style of the text of a label.
+type textStyle struct {
+	fontSize                      int
+	mono, bold, italic, underline bool
+	color                         string
+	opacity                       float64
+}
+
+// txBody returns the text body of the lines of text centered in the box inset from that of
+// its shape by insets, left, top, right and bottom.
+func (n *nativeSlide) txBody(text string, style textStyle, insets [4]float64) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<p:txBody><a:bodyPr wrap="none" lIns="%d" tIns="%d" rIns="%d" bIns="%d" anchor="ctr" rtlCol="0"><a:noAutofit/></a:bodyPr><a:lstStyle/>`,
+		n.emu(math.Max(0, insets[0])), n.emu(math.Max(0, insets[1])), n.emu(math.Max(0, insets[2])), n.emu(math.Max(0, insets[3])))
+
+	size := int(math.Round(float64(style.fontSize) * n.scale / EMUS_PER_POINT * 100))
+	// Font sizes are in hundredths of points, from 1 to 4000 points.
+	size = max(100, min(400_000, size))
+	rPr := fmt.Sprintf(`lang="en-US" sz="%d"`, size)
+	if style.bold {
+		rPr += ` b="1"`
+	}
+	if style.italic {
+		rPr += ` i="1"`
+	}
+	if style.underline {
+		rPr += ` u="sng"`
+	}
+	clr := n.color(style.color, style.opacity)
+	if clr == "" {
+		clr = n.color(d2target.FG_COLOR, style.opacity)
+	}
+	var font string
+	if style.mono {
+		font = `<a:latin typeface="Source Code Pro"/>`
+	} else if n.fontFamily == d2fonts.SourceSansPro {
+		font = `<a:latin typeface="Source Sans Pro"/>`
+	}
+	for _, line := range strings.Split(text, "\n") {
+		b.WriteString(`<a:p><a:pPr algn="ctr"/>`)
+		if line != "" {
+			fmt.Fprintf(&b, `<a:r><a:rPr %s dirty="0"><a:solidFill>%s</a:solidFill>%s</a:rPr><a:t>%s</a:t></a:r>`, rPr, clr, font, escape(line))
+		}
+		fmt.Fprintf(&b, `<a:endParaRPr %s dirty="0"/></a:p>`, rPr)
+	}
+	b.WriteString("</p:txBody>")
+	return b.String()
+}
+
+// textBox draws a text box of text in the box at tl with fill.
+func (n *nativeSlide) textBox(name, text string, style textStyle, tl *geo.Point, width, height float64, fill string) {
+	id := n.id()
+	fmt.Fprintf(&n.b, `<p:sp><p:nvSpPr><p:cNvPr id="%d" name="%s"/><p:cNvSpPr txBox="1"/><p:nvPr/></p:nvSpPr>`, id, escape(name))
+	fmt.Fprintf(&n.b, `<p:spPr>%s<a:prstGeom prst="rect"><a:avLst/></a:prstGeom>%s</p:spPr>`, n.xfrm(tl.X, tl.Y, width, height), n.fill(fill, style.opacity))
+	n.b.WriteString(n.txBody(text, style, [4]float64{}))
+	n.b.WriteString("</p:sp>")
+}
+
+// hlinkClick returns the hyperlink of a shape with link, adding it to the slide.
+func (n *nativeSlide) hlinkClick(link, tooltip string) string {
+	if link == "" {
+		return ""
+	}
+	l := &Link{
+		Index:   len(n.slide.Links),
+		ID:      fmt.Sprintf("link%d", len(n.slide.Links)+1),
+		Tooltip: tooltip,
+		onShape: true,
+	}
+	l.ExternalUrl, l.SlideIndex = n.linkTo(link)
+	n.slide.Links = append(n.slide.Links, l)
+	action := ""
+	if l.ExternalUrl == "" {
+		action = ` action="ppaction://hlinksldjump"`
+	}
+	return fmt.Sprintf(`<a:hlinkClick r:id="%s"%s tooltip="%s"/>`, l.ID, action, escape(tooltip))
+}
+
+func (n *nativeSlide) drawShape(s d2target.Shape) {
+	tl := geo.NewPoint(float64(s.Pos.X), float64(s.Pos.Y))
+	width := float64(s.Width)
+	height := float64(s.Height)
+	fill, stroke := d2themes.ShapeTheme(s)
+	if s.Type == d2target.ShapeText {
+		fill, stroke = "", ""
+	}
+	shapeType := d2target.DSL_SHAPE_TO_SHAPE_TYPE[s.Type]
+	sh := shape.NewShapeWithPath(shapeType, geo.NewBox(tl, width, height), s.ShapePath)
+	if shapeType == shape.CLOUD_TYPE && s.ContentAspectRatio != nil {
+		sh.SetInnerBoxAspectRatio(*s.ContentAspectRatio)
+	}
+
+	var geom string
+	switch s.Type {
+	case d2target.ShapeRectangle, d2target.ShapeSquare, d2target.ShapeSequenceDiagram, d2target.ShapeHierarchy, d2target.ShapeText, "":
+		if s.BorderRadius > 0 {
+			adj := math.Min(50_000, float64(s.BorderRadius)/math.Min(width, height)*100_000)
+			geom = fmt.Sprintf(`<a:prstGeom prst="roundRect"><a:avLst><a:gd name="adj" fmla="val %d"/></a:avLst></a:prstGeom>`, int(adj))
+		} else {
+			geom = `<a:prstGeom prst="rect"><a:avLst/></a:prstGeom>`
+		}
+	case d2target.ShapeOval, d2target.ShapeCircle:
+		geom = `<a:prstGeom prst="ellipse"><a:avLst/></a:prstGeom>`
+	default:
+		var paths []string
+		for _, d := range sh.GetSVGPathData() {
+			paths = append(paths, n.paths(d, tl.X, tl.Y, width, height)...)
+		}
+		geom = custGeom(paths)
+	}
+
+	// Labels inside shapes are their text, so that they move with them.
+	labelPosition := label.FromString(s.LabelPosition)
+	inside := s.Label != "" && !labelPosition.IsOutside() && s.LabelFill == ""
+	style := textStyle{
+		fontSize:  s.FontSize,
+		mono:      s.FontFamily == "mono",
+		bold:      s.Bold,
+		italic:    s.Italic,
+		underline: s.Underline,
+		color:     s.GetFontColor(),
+		opacity:   s.Opacity,
+	}
+	var labelTL *geo.Point
+	if s.Label != "" {
+		box := sh.GetInnerBox()
+		if labelPosition.IsOutside() {
+			box = sh.GetBox()
+		}
+		labelTL = labelPosition.GetPointOnBox(box, label.PADDING, float64(s.LabelWidth), float64(s.LabelHeight))
+	}
+
+	name := s.ID
+	var descr string
+	if s.Tooltip != "" {
+		descr = fmt.Sprintf(` descr="%s"`, escape(s.Tooltip))
+	}
+	tooltip := s.Tooltip
+	if tooltip == "" {
+		tooltip = s.Link
+	}
+	fmt.Fprintf(&n.b, `<p:sp><p:nvSpPr><p:cNvPr id="%d" name="%s"%s>%s</p:cNvPr><p:cNvSpPr/><p:nvPr/></p:nvSpPr>`, n.id(), escape(name), descr, n.hlinkClick(s.Link, tooltip))
+	fmt.Fprintf(&n.b, `<p:spPr>%s%s%s%s</p:spPr>`, n.xfrm(tl.X, tl.Y, width, height), geom, n.fill(fill, s.Opacity), n.line(stroke, s.StrokeWidth, s.StrokeDash, s.Opacity, ""))
+	if inside {
+		n.b.WriteString(n.txBody(s.Label, style, [4]float64{
+			labelTL.X - tl.X,
+			labelTL.Y - tl.Y,
+			tl.X + width - labelTL.X - float64(s.LabelWidth),
+			tl.Y + height - labelTL.Y - float64(s.LabelHeight),
+		}))
+	}
+	n.b.WriteString("</p:sp>")
+
+	if s.Label != "" && !inside {
+		n.textBox(name+" label", s.Label, style, labelTL, float64(s.LabelWidth), float64(s.LabelHeight), s.LabelFill)
+	}
+}
+
+func (n *nativeSlide) drawConnection(c d2target.Connection) {
+	if len(c.Route) < 2 {
+		return
+	}
+	var ends string
+	// The head of a line is its start and its tail its end.
+	if t, ok := arrowheadTypes[c.SrcArrow]; ok {
+		ends += fmt.Sprintf(`<a:headEnd type="%s" w="med" len="med"/>`, t)
+	}
+	if t, ok := arrowheadTypes[c.DstArrow]; ok {
+		ends += fmt.Sprintf(`<a:tailEnd type="%s" w="med" len="med"/>`, t)
+	}
+	strokeDash := c.StrokeDash
+	if strokeDash == 0 && c.Animated {
+		strokeDash = 5
+	}
+
+	d := d2svg.ConnectionPathData(c, n.idToShape)
+	tl, br := pathBounds(d)
+	width, height := math.Max(br.X-tl.X, 1), math.Max(br.Y-tl.Y, 1)
+	name := c.ID
+	fmt.Fprintf(&n.b, `<p:cxnSp><p:nvCxnSpPr><p:cNvPr id="%d" name="%s"/><p:cNvCxnSpPr/><p:nvPr/></p:nvCxnSpPr>`, n.id(), escape(name))
+	fmt.Fprintf(&n.b, `<p:spPr>%s%s<a:noFill/>%s</p:spPr></p:cxnSp>`,
+		n.xfrm(tl.X, tl.Y, width, height), custGeom(n.paths(d, tl.X, tl.Y, width, height)), n.line(c.Stroke, c.StrokeWidth, strokeDash, c.Opacity, ends))
+
+	if c.Label != "" {
+		labelTL := c.GetLabelTopLeft()
+		fill := c.Fill
+		if fill == color.Empty && label.FromString(c.LabelPosition).IsOnEdge() {
+			// Slides have no masks, so the connection is hidden under an opaque label instead.
+			fill = d2target.BG_COLOR
+		}
+		n.textBox(name+" label", c.Label, textStyle{
+			fontSize:  c.FontSize,
+			mono:      c.FontFamily == "mono",
+			bold:      c.Bold,
+			italic:    c.Italic,
+			underline: c.Underline,
+			color:     c.GetFontColor(),
+			opacity:   c.Opacity,
+		}, labelTL, float64(c.LabelWidth), float64(c.LabelHeight), fill)
+	}
+
+	for _, isDst := range []bool{false, true} {
+		l := c.SrcLabel
+		if isDst {
+			l = c.DstLabel
+		}
+		if l == nil || l.Label == "" {
+			continue
+		}
+		n.textBox(name+" arrowhead label", l.Label, textStyle{
+			fontSize: c.FontSize,
+			italic:   true,
+			color:    l.Color,
+			opacity:  c.Opacity,
+		}, c.GetArrowheadLabelPosition(isDst), float64(l.LabelWidth), float64(l.LabelHeight), "")
+	}
+}
+
+// custGeom returns the custom geometry of paths.
+func custGeom(paths []string) string {
+	return `<a:custGeom><a:avLst/><a:gdLst/><a:ahLst/><a:cxnLst/><a:rect l="l" t="t" r="r" b="b"/><a:pathLst>` + strings.Join(paths, "") + `</a:pathLst></a:custGeom>`
+}
+
+// paths converts the d attribute of an SVG path to DrawingML paths in the box at x, y of
+// the diagram. Subpaths that are not closed are lines and not filled.
+func (n *nativeSlide) paths(d string, x, y, width, height float64) []string {
+	var paths []string
+	var cur strings.Builder
+	closed := false
+	flush := func() {
+		if cur.Len() == 0 {
+			return
+		}
+		fill := ""
+		if !closed {
+			fill = ` fill="none"`
+		}
+		paths = append(paths, fmt.Sprintf(`<a:path w="%d" h="%d"%s>%s</a:path>`, n.emu(width), n.emu(height), fill, cur.String()))
+		cur.Reset()
+		closed = false
+	}
+	pt := func(p geo.Point) string {
+		return fmt.Sprintf(`<a:pt x="%d" y="%d"/>`, n.emu(p.X-x), n.emu(p.Y-y))
+	}
+
+	for _, cmd := range svg.ParsePath(d) {
+		switch cmd.Op {
+		case 'M':
+			flush()
+			fmt.Fprintf(&cur, "<a:moveTo>%s</a:moveTo>", pt(cmd.Points[0]))
+		case 'L':
+			fmt.Fprintf(&cur, "<a:lnTo>%s</a:lnTo>", pt(cmd.Points[0]))
+		case 'C':
+			fmt.Fprintf(&cur, "<a:cubicBezTo>%s%s%s</a:cubicBezTo>", pt(cmd.Points[0]), pt(cmd.Points[1]), pt(cmd.Points[2]))
+		case 'Z':
+			cur.WriteString("<a:close/>")
+			closed = true
+		}
+	}
+	flush()
+	return paths
+}
+
+// pathBounds returns the bounds of the points of the path d, including control points.
+func pathBounds(d string) (tl, br geo.Point) {
+	tl = geo.Point{X: math.Inf(1), Y: math.Inf(1)}
+	br = geo.Point{X: math.Inf(-1), Y: math.Inf(-1)}
+	for _, cmd := range svg.ParsePath(d) {
+		for _, p := range cmd.Points[:cmd.NumPoints()] {
+			tl.X, tl.Y = math.Min(tl.X, p.X), math.Min(tl.Y, p.Y)
+			br.X, br.Y = math.Max(br.X, p.X), math.Max(br.Y, p.Y)
+		}
+	}
+	if math.IsInf(tl.X, 0) {
+		return geo.Point{}, geo.Point{}
+	}
+	return tl, br
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
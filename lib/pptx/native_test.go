@@ -0,0 +1,118 @@
+package pptx
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"oss.terrastruct.com/util-go/assert"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2dagrelayout"
+	"oss.terrastruct.com/d2/d2lib"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/log"
+	"oss.terrastruct.com/d2/lib/textmeasure"
+)
+
+func TestAddNativeSlide(t *testing.T) {
+	t.Parallel()
+
+	diagram := compile(t, `a: Start & stop {shape: oval; link: https://d2lang.com}
+b: {shape: cylinder}
+c: {shape: person}
+d: {style.border-radius: 4; label.near: outside-top-left}
+a -> b: on the edge {target-arrowhead.shape: diamond}
+b -> c: {style.stroke-dash: 3; source-arrowhead: 1}
+c -> d: {style.animated: true}
+`)
+	if !CanDrawNative(diagram) {
+		t.Fatal("expected diagram to be drawable natively")
+	}
+
+	p := NewPresentation("test", "", "test", "", "1", true)
+	slide, err := p.AddNativeSlide(diagram, 0, 100, []BoardTitle{{Name: "root", BoardID: "root", LinkToSlide: 1}}, func(link string) (string, int) {
+		return link, 0
+	})
+	assert.Success(t, err)
+	assert.Equal(t, 1, len(slide.Links))
+
+	path := filepath.Join(t.TempDir(), "test.pptx")
+	err = p.SaveTo(path)
+	assert.Success(t, err)
+	pptx, err := os.ReadFile(path)
+	assert.Success(t, err)
+	err = Validate(pptx, 1)
+	assert.Success(t, err)
+
+	r, err := zip.NewReader(bytes.NewReader(pptx), int64(len(pptx)))
+	assert.Success(t, err)
+	f, err := r.Open("ppt/slides/slide1.xml")
+	assert.Success(t, err)
+	defer f.Close()
+	slideXML, err := io.ReadAll(f)
+	assert.Success(t, err)
+	for _, s := range []string{
+		`<a:t>Start &amp; stop</a:t>`,
+		`<a:hlinkClick r:id="link1" tooltip="https://d2lang.com"/>`,
+		`<a:prstGeom prst="ellipse">`,
+		`<a:prstGeom prst="roundRect">`,
+		`<a:custGeom>`,
+		`<p:cxnSp>`,
+		`<a:tailEnd type="diamond" w="med" len="med"/>`,
+		`<a:prstDash val="dash"/>`,
+		`<a:t>on the edge</a:t>`,
+		`name="d label"`,
+	} {
+		if !strings.Contains(string(slideXML), s) {
+			t.Fatalf("expected slide to contain %s", s)
+		}
+	}
+	if strings.Contains(string(slideXML), "<p:pic>") {
+		t.Fatal("expected slide not to have an image")
+	}
+}
+
+func TestCanDrawNative(t *testing.T) {
+	t.Parallel()
+
+	for _, script := range []string{
+		"md: |md\n  # Title\n|",
+		"x: {icon: https://icons.terrastruct.com/essentials/004-picture.svg}",
+		"t: {shape: sql_table; id: int}",
+		"a -> b: {target-arrowhead.shape: cf-many}",
+	} {
+		if CanDrawNative(compile(t, script)) {
+			t.Fatalf("expected %q not to be drawable natively", script)
+		}
+	}
+}
+
+func TestPathBounds(t *testing.T) {
+	t.Parallel()
+
+	tl, br := pathBounds("M 0 0 L 10 0 S 20 0 20 10 H 5 V 15 C 1 1 2 2 3,3 Z")
+	assert.Equal(t, 0., tl.X)
+	assert.Equal(t, 0., tl.Y)
+	assert.Equal(t, 20., br.X)
+	assert.Equal(t, 15., br.Y)
+}
+
+func compile(t *testing.T, script string) *d2target.Diagram {
+	ctx := log.WithTB(context.Background(), t, nil)
+	ruler, err := textmeasure.NewRuler()
+	assert.Success(t, err)
+	diagram, _, err := d2lib.Compile(ctx, script, &d2lib.CompileOptions{
+		Ruler: ruler,
+		LayoutResolver: func(engine string) (d2graph.LayoutGraph, error) {
+			return d2dagrelayout.DefaultLayout, nil
+		},
+	}, nil)
+	assert.Success(t, err)
+	return diagram
+}
@@ -0,0 +1,146 @@
+package pptx
+
+import (
+	"archive/zip"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"strings"
+
+	"oss.terrastruct.com/d2/d2target"
+)
+
+//go:embed templates/notes_master.xml
+var NOTES_MASTER_XML string
+
+//go:embed templates/notes_master.xml.rels
+var RELS_NOTES_MASTER_XML string
+
+//go:embed templates/notes_slide.xml
+var NOTES_SLIDE_XML string
+
+//go:embed templates/notes_slide.xml.rels
+var RELS_NOTES_SLIDE_XML string
+
+// Notes returns the speaker notes of the slide of diagram: the description of its board
+// followed by the tooltips of its shapes and connections, so that presenters get the talking
+// points of the board.
+func Notes(diagram *d2target.Diagram) string {
+	var notes []string
+	if diagram.Description != "" {
+		notes = append(notes, diagram.Description)
+	}
+	var tooltips []string
+	for _, s := range diagram.Shapes {
+		if s.Tooltip != "" {
+			tooltips = append(tooltips, noteTooltip(s.Label, s.ID, s.Tooltip))
+		}
+	}
+	for _, c := range diagram.Connections {
+		if c.Tooltip != "" {
+			tooltips = append(tooltips, noteTooltip(c.Label, c.ID, c.Tooltip))
+		}
+	}
+	if len(tooltips) > 0 {
+		notes = append(notes, strings.Join(tooltips, "\n"))
+	}
+	return strings.Join(notes, "\n\n")
+}
+
+func noteTooltip(label, id, tooltip string) string {
+	if label == "" {
+		label = id
+	}
+	return fmt.Sprintf("%s: %s", strings.Join(strings.Fields(label), " "), tooltip)
+}
+
+type RelsNotesMasterXmlContent struct {
+	Theme string
+}
+
+type NotesSlideXmlContent struct {
+	Paragraphs []string
+}
+
+type RelsNotesSlideXmlContent struct {
+	NotesMaster string
+	Slide       string
+}
+
+// addNotesMaster adds the notes master the notes slides of the presentation are laid on, if
+// it has speaker notes. It returns its part name relative to ppt, and the theme it was added
+// with unless it is the notes master of the template of the presentation.
+func (p *Presentation) addNotesMaster(w *zip.Writer) (notesMaster, theme string, err error) {
+	hasNotes := false
+	for _, slide := range p.Slides {
+		if slide.Notes != "" {
+			hasNotes = true
+			break
+		}
+	}
+	if !hasNotes {
+		return "", "", nil
+	}
+	if p.template != nil && p.template.notesMaster != "" {
+		return p.template.notesMaster, "", nil
+	}
+
+	notesMaster = "notesMasters/notesMaster1.xml"
+	theme = "theme2.xml"
+	if p.template != nil {
+		for i := 2; p.template.has("ppt/theme/" + theme); i++ {
+			theme = fmt.Sprintf("theme%d.xml", i)
+		}
+	}
+	err = addFileFromTemplate(w, "ppt/"+notesMaster, NOTES_MASTER_XML, nil)
+	if err != nil {
+		return "", "", err
+	}
+	err = addFileFromTemplate(w, "ppt/notesMasters/_rels/notesMaster1.xml.rels", RELS_NOTES_MASTER_XML, RelsNotesMasterXmlContent{
+		Theme: theme,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	// the notes master has the theme of the default template
+	themeContent, err := readPptxTemplateFile("ppt/theme/theme1.xml")
+	if err != nil {
+		return "", "", err
+	}
+	themeWriter, err := w.Create("ppt/theme/" + theme)
+	if err != nil {
+		return "", "", err
+	}
+	_, err = themeWriter.Write(themeContent)
+	if err != nil {
+		return "", "", err
+	}
+	return notesMaster, theme, nil
+}
+
+func addNotesSlide(w *zip.Writer, notesFileName, slideFileName, notesMaster, notes string) error {
+	err := addFileFromTemplate(w, fmt.Sprintf("ppt/notesSlides/_rels/%s.xml.rels", notesFileName), RELS_NOTES_SLIDE_XML, RelsNotesSlideXmlContent{
+		NotesMaster: notesMaster,
+		Slide:       slideFileName,
+	})
+	if err != nil {
+		return err
+	}
+	return addFileFromTemplate(w, fmt.Sprintf("ppt/notesSlides/%s.xml", notesFileName), NOTES_SLIDE_XML, NotesSlideXmlContent{
+		Paragraphs: strings.Split(notes, "\n"),
+	})
+}
+
+func readPptxTemplateFile(name string) ([]byte, error) {
+	zipReader, err := zip.NewReader(bytes.NewReader(PPTX_TEMPLATE), int64(len(PPTX_TEMPLATE)))
+	if err != nil {
+		return nil, err
+	}
+	f, err := zipReader.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", name, err)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
@@ -0,0 +1,68 @@
+package pptx
+
+import (
+	"strings"
+	"testing"
+
+	"oss.terrastruct.com/util-go/assert"
+)
+
+func TestNotes(t *testing.T) {
+	t.Parallel()
+
+	diagram := compile(t, `description: Start with the happy path
+a: Checkout {tooltip: Where orders begin}
+b {tooltip: Stripe & co}
+a -> b: {tooltip: "Retried 3 times"}
+c
+`)
+	assert.Equal(t, `Start with the happy path
+
+Checkout: Where orders begin
+b: Stripe & co
+(a -> b)[0]: Retried 3 times`, Notes(diagram))
+	assert.Equal(t, "", Notes(compile(t, "x -> y")))
+}
+
+func TestSaveNotes(t *testing.T) {
+	t.Parallel()
+
+	p := NewPresentation("test", "", "test", "", "1", true)
+	diagram := compile(t, "description: <first> & foremost\nx")
+	slide, err := p.AddNativeSlide(diagram, 0, 100, []BoardTitle{{Name: "root", BoardID: "root", LinkToSlide: 1}}, nil)
+	assert.Success(t, err)
+	slide.Notes = Notes(diagram)
+	pptx := saveNative(t, p, "y")
+	err = Validate(pptx, 2)
+	assert.Success(t, err)
+
+	notes := readZipFile(t, pptx, "ppt/notesSlides/notesSlide1.xml")
+	if !strings.Contains(notes, "<a:t>&lt;first&gt; &amp; foremost</a:t>") {
+		t.Fatalf("expected the notes of the slide:\n%s", notes)
+	}
+	if !strings.Contains(readZipFile(t, pptx, "ppt/slides/_rels/slide1.xml.rels"), `Target="../notesSlides/notesSlide1.xml"`) {
+		t.Fatal("expected slide to refer to its notes")
+	}
+	if strings.Contains(readZipFile(t, pptx, "ppt/slides/_rels/slide2.xml.rels"), "notesSlide") {
+		t.Fatal("expected slide without notes not to refer to notes")
+	}
+	if !strings.Contains(readZipFile(t, pptx, "ppt/presentation.xml"), `<p:notesMasterId r:id="notesMaster" />`) {
+		t.Fatal("expected presentation to have a notes master")
+	}
+	assert.Equal(t, true, strings.Contains(readZipFile(t, pptx, "docProps/app.xml"), "<Notes>1</Notes>"))
+
+	// a deck with notes as the template of another reuses its notes master
+	tmpl, err := ParseTemplate(pptx)
+	assert.Success(t, err)
+	assert.Equal(t, "notesMasters/notesMaster1.xml", tmpl.notesMaster)
+	p = NewPresentation("test", "", "test", "", "1", true)
+	p.SetTemplate(tmpl)
+	slide, err = p.AddNativeSlide(diagram, 0, 100, []BoardTitle{{Name: "root", BoardID: "root", LinkToSlide: 1}}, nil)
+	assert.Success(t, err)
+	slide.Notes = "again"
+	pptx = saveNative(t, p, "y")
+	err = Validate(pptx, 2)
+	assert.Success(t, err)
+	presentation := readZipFile(t, pptx, "ppt/presentation.xml")
+	assert.Equal(t, 1, strings.Count(presentation, "<p:notesMasterId "))
+}
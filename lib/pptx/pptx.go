@@ -15,7 +15,11 @@ import (
 	_ "embed"
 	"fmt"
 	"image/png"
+	"io"
+	"math"
 	"os"
+	"strconv"
+	"strings"
 	"text/template"
 	"time"
 )
@@ -36,6 +40,8 @@ type Presentation struct {
 	// Otherwise, it may fail to open in PowerPoint
 	D2Version  string
 	includeNav bool
+	template   *Template
+	options    SlideOptions
 
 	Slides []*Slide
 }
@@ -50,6 +56,18 @@ type Slide struct {
 	ImageTop         int
 	ImageLeft        int
 	ImageScaleFactor float64
+	// Elements are the DrawingML elements of slides drawn natively rather than from an
+	// Image, see AddNativeSlide.
+	Elements string
+	// elementIDs are the IDs of the DrawingML elements of each shape and connection of
+	// slides drawn natively.
+	elementIDs map[string][]int
+	// Timing is the animation of the slide, see AddStepsSlide.
+	Timing string
+	// callouts is the number of callouts of the slide, see AddCallout.
+	callouts int
+	// Notes are the speaker notes of the slide, see Notes.
+	Notes string
 }
 
 func (s *Slide) AddLink(link *Link) {
@@ -72,6 +90,8 @@ type Link struct {
 	SlideIndex  int
 	ExternalUrl string
 	Tooltip     string
+	// onShape is whether the link is on a native shape rather than a box over the image.
+	onShape bool
 }
 
 func NewPresentation(title, description, subject, creator, d2Version string, includeNav bool) *Presentation {
@@ -85,6 +105,98 @@ func NewPresentation(title, description, subject, creator, d2Version string, inc
 	}
 }
 
+// SetTemplate makes the presentation from t rather than from the default blank template.
+// It must be set before slides are added since they are fitted to the slide size of t.
+func (p *Presentation) SetTemplate(t *Template) {
+	p.template = t
+}
+
+// SlideOptions are the options of the slides of presentations.
+type SlideOptions struct {
+	// Width and Height are the size of slides in EMUs. By default slides have the size of
+	// the template of the presentation, or else are 16:9.
+	Width  int
+	Height int
+	// Scaling is how boards are scaled onto slides, one of Scalings. By default they are
+	// fitted.
+	Scaling string
+}
+
+const (
+	// ScalingFit fits boards within slides.
+	ScalingFit = "fit"
+	// ScalingFill fills slides with boards, cutting off what overflows.
+	ScalingFill = "fill"
+	// ScalingActual draws boards at their actual size, a pixel being 1/96 of an inch.
+	ScalingActual = "actual"
+)
+
+// SlideSizes are the named sizes of slides, in EMUs.
+var SlideSizes = map[string][2]int{
+	"16:9": {SLIDE_WIDTH, SLIDE_HEIGHT},
+	"4:3":  {SLIDE_WIDTH, 6_858_000},
+}
+
+// EMUS_PER_INCH is the number of EMUs in an inch.
+const EMUS_PER_INCH = 914_400
+
+// EMUS_PER_PIXEL is the number of EMUs in a pixel of a diagram.
+const EMUS_PER_PIXEL = EMUS_PER_INCH / 96
+
+// ParseSlideSize returns the size in EMUs of one of SlideSizes, or of a custom size in
+// inches such as 13.33x7.5. PowerPoint slides are 1 to 56 inches wide and tall.
+func ParseSlideSize(size string) (width, height int, _ error) {
+	if wh, ok := SlideSizes[size]; ok {
+		return wh[0], wh[1], nil
+	}
+	w, h, ok := strings.Cut(strings.ToLower(size), "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("slide size %q is not one of 16:9 and 4:3, or inches such as 13.33x7.5", size)
+	}
+	wf, err := strconv.ParseFloat(w, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid slide width %q", w)
+	}
+	hf, err := strconv.ParseFloat(h, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid slide height %q", h)
+	}
+	if wf < 1 || wf > 56 || hf < 1 || hf > 56 {
+		return 0, 0, fmt.Errorf("slide size %q must be between 1 and 56 inches", size)
+	}
+	return int(math.Round(wf * EMUS_PER_INCH)), int(math.Round(hf * EMUS_PER_INCH)), nil
+}
+
+// SetSlideOptions sets the size of the slides and how boards are scaled onto them. Like
+// SetTemplate, it must be set before slides are added.
+func (p *Presentation) SetSlideOptions(opts SlideOptions) {
+	p.options = opts
+}
+
+func (p *Presentation) slideWidth() int {
+	if p.options.Width > 0 {
+		return p.options.Width
+	}
+	if p.template != nil {
+		return p.template.width
+	}
+	return SLIDE_WIDTH
+}
+
+func (p *Presentation) slideHeight() int {
+	if p.options.Height > 0 {
+		return p.options.Height
+	}
+	if p.template != nil {
+		return p.template.height
+	}
+	return SLIDE_HEIGHT
+}
+
+func (p *Presentation) imageWidth() int {
+	return p.slideWidth() * IMAGE_WIDTH / SLIDE_WIDTH
+}
+
 func (p *Presentation) headerHeight() int {
 	if p.includeNav {
 		return HEADER_HEIGHT
@@ -93,22 +205,33 @@ func (p *Presentation) headerHeight() int {
 }
 
 func (p *Presentation) height() int {
-	return SLIDE_HEIGHT - p.headerHeight()
+	return p.slideHeight() - p.headerHeight()
 }
 
 func (p *Presentation) aspectRatio() float64 {
-	return float64(IMAGE_WIDTH) / float64(p.height())
+	return float64(p.imageWidth()) / float64(p.height())
 }
 
-func (p *Presentation) AddSlide(pngContent []byte, titlePath []BoardTitle) (*Slide, error) {
+// AddSlide adds a slide of titlePath drawn from the PNG screenshot of its board, pngScale
+// being the number of pixels of the screenshot per pixel of the board.
+func (p *Presentation) AddSlide(pngContent []byte, pngScale float64, titlePath []BoardTitle) (*Slide, error) {
 	src, err := png.Decode(bytes.NewReader(pngContent))
 	if err != nil {
 		return nil, fmt.Errorf("error decoding PNG image: %v", err)
 	}
 
-	var width, height int
 	srcSize := src.Bounds().Size()
-	srcWidth, srcHeight := float64(srcSize.X), float64(srcSize.Y)
+	slide := p.newSlide(titlePath, float64(srcSize.X), float64(srcSize.Y), pngScale)
+	slide.ImageId = fmt.Sprintf("slide%dImage", len(p.Slides))
+	slide.Image = pngContent
+	return slide, nil
+}
+
+// newSlide adds a slide of titlePath with the position and size of a drawing of srcWidth
+// by srcHeight scaled onto it, srcScale being the size of a pixel of the diagram of the
+// drawing.
+func (p *Presentation) newSlide(titlePath []BoardTitle, srcWidth, srcHeight, srcScale float64) *Slide {
+	var width, height int
 
 	// compute the size and position to fit the slide
 	// if the image is wider than taller and its aspect ratio is, at least, the same as the available image space aspect ratio
@@ -128,19 +251,31 @@ func (p *Presentation) AddSlide(pngContent []byte, titlePath []BoardTitle) (*Sli
 	// └──┴────────────────────────────────────────────┴──┘   ─┴─        ─┴─
 	// ├────────────────────SLIDE WIDTH───────────────────┤
 	//    ├─────────────────IMAGE WIDTH────────────────┤
-	if srcWidth/srcHeight >= p.aspectRatio() {
+	switch {
+	case p.options.Scaling == ScalingActual:
+		width = int(srcWidth / srcScale * EMUS_PER_PIXEL)
+		height = int(srcHeight / srcScale * EMUS_PER_PIXEL)
+	case p.options.Scaling == ScalingFill:
+		// the drawing covers the slide below the header, the overflow being off the slide
+		width = p.slideWidth()
+		height = int(float64(width) * (srcHeight / srcWidth))
+		if height < p.height() {
+			height = p.height()
+			width = int(float64(height) * (srcWidth / srcHeight))
+		}
+	case srcWidth/srcHeight >= p.aspectRatio():
 		// here, the image aspect ratio is, at least, equal to the slide aspect ratio
 		// so, it makes sense to expand the image horizontally to use as much as space as possible
-		width = SLIDE_WIDTH
+		width = p.slideWidth()
 		height = int(float64(width) * (srcHeight / srcWidth))
 		// first, try to make the image as wide as the slide
 		// but, if this results in a tall image, use only the
 		// image adjusted width to avoid overlapping with the header
 		if height > p.height() {
-			width = IMAGE_WIDTH
+			width = p.imageWidth()
 			height = int(float64(width) * (srcHeight / srcWidth))
 		}
-	} else {
+	default:
 		// here, the aspect ratio could be 4x3, in which the image is still wider than taller,
 		// but expanding horizontally would result in an overflow
 		// so, we expand to make it fit the available vertical space
@@ -148,12 +283,10 @@ func (p *Presentation) AddSlide(pngContent []byte, titlePath []BoardTitle) (*Sli
 		width = int(float64(height) * (srcWidth / srcHeight))
 	}
 	top := p.headerHeight() + ((p.height() - height) / 2)
-	left := (SLIDE_WIDTH - width) / 2
+	left := (p.slideWidth() - width) / 2
 
 	slide := &Slide{
 		BoardTitle:       make([]BoardTitle, len(titlePath)),
-		ImageId:          fmt.Sprintf("slide%dImage", len(p.Slides)+1),
-		Image:            pngContent,
 		ImageWidth:       width,
 		ImageHeight:      height,
 		ImageTop:         top,
@@ -167,7 +300,7 @@ func (p *Presentation) AddSlide(pngContent []byte, titlePath []BoardTitle) (*Sli
 	}
 
 	p.Slides = append(p.Slides, slide)
-	return slide, nil
+	return slide
 }
 
 func (p *Presentation) SaveTo(filePath string) error {
@@ -176,29 +309,58 @@ func (p *Presentation) SaveTo(filePath string) error {
 		return err
 	}
 	defer f.Close()
-	zipWriter := zip.NewWriter(f)
+	return p.Save(f)
+}
+
+// Save writes the presentation to w, like SaveTo.
+func (p *Presentation) Save(w io.Writer) (err error) {
+	zipWriter := zip.NewWriter(w)
 	defer zipWriter.Close()
 
-	if err = copyPptxTemplateTo(zipWriter); err != nil {
+	if p.template != nil {
+		err = p.template.copyTo(zipWriter)
+	} else {
+		err = copyPptxTemplateTo(zipWriter)
+	}
+	if err != nil {
+		return err
+	}
+
+	notesMaster, notesTheme, err := p.addNotesMaster(zipWriter)
+	if err != nil {
 		return err
 	}
 
-	var slideFileNames []string
+	var slideFileNames, notesFileNames []string
 	for i, slide := range p.Slides {
-		imageID := fmt.Sprintf("slide%dImage", i+1)
+		var imageID, notesFileName string
 		slideFileName := fmt.Sprintf("slide%d", i+1)
 		slideFileNames = append(slideFileNames, slideFileName)
 
-		imageWriter, err := zipWriter.Create(fmt.Sprintf("ppt/media/%s.png", imageID))
-		if err != nil {
-			return err
+		if slide.Notes != "" {
+			notesFileName = fmt.Sprintf("notesSlide%d", i+1)
+			notesFileNames = append(notesFileNames, notesFileName)
+			err = addNotesSlide(zipWriter, notesFileName, slideFileName, notesMaster, slide.Notes)
+			if err != nil {
+				return err
+			}
 		}
-		_, err = imageWriter.Write(slide.Image)
-		if err != nil {
-			return err
+
+		if slide.Image != nil {
+			imageID = fmt.Sprintf("slide%dImage", i+1)
+			imageWriter, err := zipWriter.Create(fmt.Sprintf("ppt/media/%s.png", imageID))
+			if err != nil {
+				return err
+			}
+			_, err = imageWriter.Write(slide.Image)
+			if err != nil {
+				return err
+			}
 		}
 
-		err = addFileFromTemplate(zipWriter, fmt.Sprintf("ppt/slides/_rels/%s.xml.rels", slideFileName), RELS_SLIDE_XML, getSlideXmlRelsContent(imageID, slide))
+		relsContent := p.getSlideXmlRelsContent(imageID, slide)
+		relsContent.NotesSlide = notesFileName
+		err = addFileFromTemplate(zipWriter, fmt.Sprintf("ppt/slides/_rels/%s.xml.rels", slideFileName), RELS_SLIDE_XML, relsContent)
 		if err != nil {
 			return err
 		}
@@ -209,19 +371,43 @@ func (p *Presentation) SaveTo(filePath string) error {
 		}
 	}
 
-	err = addFileFromTemplate(zipWriter, "[Content_Types].xml", CONTENT_TYPES_XML, ContentTypesXmlContent{
-		FileNames: slideFileNames,
-	})
+	contentTypes := ContentTypesXmlContent{
+		FileNames:      slideFileNames,
+		NotesFileNames: notesFileNames,
+	}
+	if notesTheme != "" {
+		contentTypes.NotesMaster = notesMaster
+		contentTypes.NotesTheme = notesTheme
+	}
+	if p.template != nil {
+		contentTypes.Defaults = p.template.Defaults
+		contentTypes.Overrides = p.template.Overrides
+	}
+	err = addFileFromTemplate(zipWriter, "[Content_Types].xml", CONTENT_TYPES_XML, contentTypes)
 	if err != nil {
 		return err
 	}
 
-	err = addFileFromTemplate(zipWriter, "ppt/_rels/presentation.xml.rels", RELS_PRESENTATION_XML, getRelsPresentationXmlContent(slideFileNames))
+	relsPresentation := p.getRelsPresentationXmlContent(slideFileNames)
+	if notesTheme != "" {
+		relsPresentation.NotesMaster = notesMaster
+	}
+	err = addFileFromTemplate(zipWriter, "ppt/_rels/presentation.xml.rels", RELS_PRESENTATION_XML, relsPresentation)
 	if err != nil {
 		return err
 	}
 
-	err = addFileFromTemplate(zipWriter, "ppt/presentation.xml", PRESENTATION_XML, getPresentationXmlContent(slideFileNames))
+	if p.template != nil {
+		var w io.Writer
+		w, err = zipWriter.Create("ppt/presentation.xml")
+		if err == nil {
+			_, err = io.WriteString(w, p.template.presentationXML(slideFileNames, p.slideWidth(), p.slideHeight(), notesTheme != ""))
+		}
+	} else {
+		presentation := p.getPresentationXmlContent(slideFileNames)
+		presentation.NotesMaster = notesTheme != ""
+		err = addFileFromTemplate(zipWriter, "ppt/presentation.xml", PRESENTATION_XML, presentation)
+	}
 	if err != nil {
 		return err
 	}
@@ -246,6 +432,7 @@ func (p *Presentation) SaveTo(filePath string) error {
 	}
 	err = addFileFromTemplate(zipWriter, "docProps/app.xml", APP_XML, AppXmlContent{
 		SlideCount:         len(p.Slides),
+		NotesCount:         len(notesFileNames),
 		TitlesOfPartsCount: len(p.Slides) + 3, // + 3 for fonts and theme
 		D2Version:          p.D2Version,
 		Titles:             titles,
@@ -296,16 +483,22 @@ type RelsSlideXmlLinkContent struct {
 }
 
 type RelsSlideXmlContent struct {
+	Layout         string
 	FileName       string
 	RelationshipID string
+	NotesSlide     string
 	Links          []RelsSlideXmlLinkContent
 }
 
-func getSlideXmlRelsContent(imageID string, slide *Slide) RelsSlideXmlContent {
+func (p *Presentation) getSlideXmlRelsContent(imageID string, slide *Slide) RelsSlideXmlContent {
 	content := RelsSlideXmlContent{
+		Layout:         "../slideLayouts/slideLayout7.xml",
 		FileName:       imageID,
 		RelationshipID: imageID,
 	}
+	if p.template != nil {
+		content.Layout = p.template.layout
+	}
 
 	for _, link := range slide.Links {
 		content.Links = append(content.Links, RelsSlideXmlLinkContent{
@@ -348,12 +541,15 @@ type SlideXmlContent struct {
 	Title        string
 	TitlePrefix  []SlideXmlTitlePathContent
 	Description  string
+	HeaderWidth  int
 	HeaderHeight int
 	ImageID      string
 	ImageLeft    int
 	ImageTop     int
 	ImageWidth   int
 	ImageHeight  int
+	Elements     string
+	Timing       string
 
 	Links []SlideLinkXmlContent
 }
@@ -369,12 +565,15 @@ func (p *Presentation) getSlideXmlContent(imageID string, slide *Slide) SlideXml
 	}
 	content := SlideXmlContent{
 		Description:  slide.BoardTitle[len(slide.BoardTitle)-1].BoardID,
+		HeaderWidth:  p.slideWidth() - 8002,
 		HeaderHeight: p.headerHeight(),
 		ImageID:      imageID,
 		ImageLeft:    slide.ImageLeft,
 		ImageTop:     slide.ImageTop,
 		ImageWidth:   slide.ImageWidth,
 		ImageHeight:  slide.ImageHeight,
+		Elements:     slide.Elements,
+		Timing:       slide.Timing,
 	}
 	if p.includeNav {
 		content.Title = slide.BoardTitle[len(slide.BoardTitle)-1].Name
@@ -382,6 +581,9 @@ func (p *Presentation) getSlideXmlContent(imageID string, slide *Slide) SlideXml
 	}
 
 	for _, link := range slide.Links {
+		if link.onShape {
+			continue
+		}
 		var action string
 		if link.ExternalUrl == "" {
 			action = "ppaction://hlinksldjump"
@@ -410,11 +612,17 @@ type RelsPresentationSlideXmlContent struct {
 }
 
 type RelsPresentationXmlContent struct {
-	Slides []RelsPresentationSlideXmlContent
+	// Relationships are the relationships of the template of the presentation, if any.
+	Relationships []Relationship
+	NotesMaster   string
+	Slides        []RelsPresentationSlideXmlContent
 }
 
-func getRelsPresentationXmlContent(slideFileNames []string) RelsPresentationXmlContent {
+func (p *Presentation) getRelsPresentationXmlContent(slideFileNames []string) RelsPresentationXmlContent {
 	var content RelsPresentationXmlContent
+	if p.template != nil {
+		content.Relationships = p.template.Relationships
+	}
 	for _, name := range slideFileNames {
 		content.Slides = append(content.Slides, RelsPresentationSlideXmlContent{
 			RelationshipID: name,
@@ -430,6 +638,14 @@ var CONTENT_TYPES_XML string
 
 type ContentTypesXmlContent struct {
 	FileNames []string
+	// Defaults and Overrides are the content types of the template of the presentation, if any.
+	Defaults  []ContentTypeDefault
+	Overrides []ContentTypeOverride
+	// NotesMaster and NotesTheme are the notes master added for the speaker notes of
+	// NotesFileNames, if any.
+	NotesMaster    string
+	NotesTheme     string
+	NotesFileNames []string
 }
 
 //go:embed templates/presentation.xml
@@ -443,13 +659,14 @@ type PresentationSlideXmlContent struct {
 type PresentationXmlContent struct {
 	SlideWidth  int
 	SlideHeight int
+	NotesMaster bool
 	Slides      []PresentationSlideXmlContent
 }
 
-func getPresentationXmlContent(slideFileNames []string) PresentationXmlContent {
+func (p *Presentation) getPresentationXmlContent(slideFileNames []string) PresentationXmlContent {
 	content := PresentationXmlContent{
-		SlideWidth:  SLIDE_WIDTH,
-		SlideHeight: SLIDE_HEIGHT,
+		SlideWidth:  p.slideWidth(),
+		SlideHeight: p.slideHeight(),
 	}
 	for i, name := range slideFileNames {
 		content.Slides = append(content.Slides, PresentationSlideXmlContent{
@@ -479,6 +696,7 @@ var APP_XML string
 
 type AppXmlContent struct {
 	SlideCount         int
+	NotesCount         int
 	TitlesOfPartsCount int
 	Titles             []string
 	D2Version          string
@@ -0,0 +1,53 @@
+package pptx
+
+import (
+	"testing"
+
+	"oss.terrastruct.com/util-go/assert"
+)
+
+func TestParseSlideSize(t *testing.T) {
+	t.Parallel()
+
+	w, h, err := ParseSlideSize("4:3")
+	assert.Success(t, err)
+	assert.Equal(t, 9_144_000, w)
+	assert.Equal(t, 6_858_000, h)
+	w, h, err = ParseSlideSize("13.333x7.5")
+	assert.Success(t, err)
+	assert.Equal(t, 12_191_695, w)
+	assert.Equal(t, 6_858_000, h)
+
+	_, _, err = ParseSlideSize("A4")
+	assert.ErrorString(t, err, `slide size "A4" is not one of 16:9 and 4:3, or inches such as 13.33x7.5`)
+	_, _, err = ParseSlideSize("60x10")
+	assert.ErrorString(t, err, `slide size "60x10" must be between 1 and 56 inches`)
+}
+
+func TestScaling(t *testing.T) {
+	t.Parallel()
+
+	path := []BoardTitle{{Name: "root", BoardID: "root", LinkToSlide: 1}}
+	p := NewPresentation("test", "", "test", "", "1", false)
+
+	// a square board is as tall as 16:9 slides when fitted
+	slide := p.newSlide(path, 400, 400, 2)
+	assert.Equal(t, SLIDE_HEIGHT, slide.ImageHeight)
+	assert.Equal(t, SLIDE_HEIGHT, slide.ImageWidth)
+	assert.Equal(t, 0, slide.ImageTop)
+
+	// and as wide when filling them
+	p.SetSlideOptions(SlideOptions{Scaling: ScalingFill})
+	slide = p.newSlide(path, 400, 400, 2)
+	assert.Equal(t, SLIDE_WIDTH, slide.ImageWidth)
+	assert.Equal(t, SLIDE_WIDTH, slide.ImageHeight)
+	assert.Equal(t, 0, slide.ImageLeft)
+	assert.Equal(t, (SLIDE_HEIGHT-SLIDE_WIDTH)/2, slide.ImageTop)
+
+	// a 200px board drawn at 2x is 200px wide at its actual size
+	p.SetSlideOptions(SlideOptions{Width: 4 * EMUS_PER_INCH, Height: 3 * EMUS_PER_INCH, Scaling: ScalingActual})
+	slide = p.newSlide(path, 400, 200, 2)
+	assert.Equal(t, 200*EMUS_PER_PIXEL, slide.ImageWidth)
+	assert.Equal(t, 100*EMUS_PER_PIXEL, slide.ImageHeight)
+	assert.Equal(t, (4*EMUS_PER_INCH-200*EMUS_PER_PIXEL)/2, slide.ImageLeft)
+}
@@ -0,0 +1,59 @@
+package pptx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AddTitleSlide adds a slide opening the presentation with title and, below it, subtitle,
+// such as the date and version of the presentation.
+func (p *Presentation) AddTitleSlide(title, subtitle string) *Slide {
+	slide := p.newTextSlide("title")
+	var b strings.Builder
+	// Above the middle of the slide
+	writeTextBox(&b, 101, "Title", title, 4400, true, p.slideHeight()/8, p.slideHeight()*3/8, p.slideWidth(), "b")
+	if subtitle != "" {
+		writeTextBox(&b, 102, "Subtitle", subtitle, 2000, false, p.slideHeight()/2+EMUS_PER_INCH/8, p.slideHeight()/4, p.slideWidth(), "t")
+	}
+	slide.Elements = b.String()
+	return slide
+}
+
+// AddSectionSlide adds a slide dividing the presentation into sections, before the slides
+// of the board boardID named title.
+func (p *Presentation) AddSectionSlide(title, boardID string) *Slide {
+	slide := p.newTextSlide(boardID + " section")
+	var b strings.Builder
+	writeTextBox(&b, 101, "Section", title, 3600, true, p.slideHeight()/4, p.slideHeight()/2, p.slideWidth(), "ctr")
+	slide.Elements = b.String()
+	return slide
+}
+
+// newTextSlide adds a slide of text rather than of a board, with an empty header linking to
+// the slide itself.
+func (p *Presentation) newTextSlide(boardID string) *Slide {
+	slide := &Slide{
+		BoardTitle:       []BoardTitle{{BoardID: boardID, LinkID: "navLink0", LinkToSlide: len(p.Slides) + 1}},
+		ImageScaleFactor: 1,
+	}
+	p.Slides = append(p.Slides, slide)
+	return slide
+}
+
+// writeTextBox writes a text box of text spanning the slide of slideWidth from top to
+// top+height, with its text of size, in hundredths of a point, centered horizontally and
+// anchored at anchor.
+func writeTextBox(b *strings.Builder, id int, name, text string, size int, bold bool, top, height, slideWidth int, anchor string) {
+	margin := EMUS_PER_INCH / 2
+	fmt.Fprintf(b, `<p:sp><p:nvSpPr><p:cNvPr id="%d" name="%s"/><p:cNvSpPr txBox="1"/><p:nvPr/></p:nvSpPr>`, id, name)
+	fmt.Fprintf(b, `<p:spPr><a:xfrm><a:off x="%d" y="%d"/><a:ext cx="%d" cy="%d"/></a:xfrm><a:prstGeom prst="rect"><a:avLst/></a:prstGeom><a:noFill/></p:spPr>`, margin, top, slideWidth-2*margin, height)
+	fmt.Fprintf(b, `<p:txBody><a:bodyPr wrap="square" anchor="%s"><a:normAutofit/></a:bodyPr><a:lstStyle/>`, anchor)
+	var bAttr string
+	if bold {
+		bAttr = ` b="1"`
+	}
+	for _, line := range strings.Split(text, "\n") {
+		fmt.Fprintf(b, `<a:p><a:pPr algn="ctr"/><a:r><a:rPr lang="en-US" sz="%d"%s dirty="0"/><a:t>%s</a:t></a:r></a:p>`, size, bAttr, escape(line))
+	}
+	b.WriteString(`</p:txBody></p:sp>`)
+}
@@ -0,0 +1,34 @@
+package pptx
+
+import (
+	"strings"
+	"testing"
+
+	"oss.terrastruct.com/util-go/assert"
+)
+
+func TestTitleAndSectionSlides(t *testing.T) {
+	t.Parallel()
+
+	p := NewPresentation("test", "", "test", "", "1", true)
+	p.AddTitleSlide("<Checkout> & co", "October 17, 2026\nD2 v0.7.1")
+	p.AddSectionSlide("Payments", "root.layers.payments")
+	pptx := saveNative(t, p, "a -> b")
+	err := Validate(pptx, 3)
+	assert.Success(t, err)
+
+	title := readZipFile(t, pptx, "ppt/slides/slide1.xml")
+	for _, text := range []string{"<a:t>&lt;Checkout&gt; &amp; co</a:t>", "<a:t>October 17, 2026</a:t>", "<a:t>D2 v0.7.1</a:t>"} {
+		if !strings.Contains(title, text) {
+			t.Fatalf("expected the title slide to have %s:\n%s", text, title)
+		}
+	}
+	section := readZipFile(t, pptx, "ppt/slides/slide2.xml")
+	if !strings.Contains(section, "<a:t>Payments</a:t>") {
+		t.Fatalf("expected the section slide to be titled:\n%s", section)
+	}
+	if !strings.Contains(readZipFile(t, pptx, "ppt/slides/_rels/slide2.xml.rels"), `Target="slide2.xml"`) {
+		t.Fatal("expected the header of the section slide to link to itself")
+	}
+	assert.Equal(t, true, strings.Contains(readZipFile(t, pptx, "docProps/app.xml"), "<vt:lpstr>root.layers.payments section</vt:lpstr>"))
+}
@@ -0,0 +1,93 @@
+package pptx
+
+import (
+	"fmt"
+	"strings"
+
+	"oss.terrastruct.com/d2/d2target"
+)
+
+// AddStepsSlide adds a single slide of the steps boards steps, drawn natively, that reveals
+// them one click after the other like --animate-interval does in SVGs: it is drawn from the
+// last step, and the shapes and connections first appearing in each subsequent step come
+// into view with an entrance animation. Shapes and connections removed by a later step are
+// not drawn and the ones appearing in the first step are there from the start.
+func (p *Presentation) AddStepsSlide(steps []*d2target.Diagram, themeID int64, pad int64, titlePath []BoardTitle, linkTo func(link string) (externalUrl string, slideIndex int)) (*Slide, error) {
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("no steps")
+	}
+	slide, err := p.AddNativeSlide(steps[len(steps)-1], themeID, pad, titlePath, linkTo)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+	var clicks [][]int
+	for i, step := range steps {
+		var ids []int
+		for _, obj := range stepObjects(step) {
+			if _, ok := seen[obj]; ok {
+				continue
+			}
+			seen[obj] = struct{}{}
+			if i > 0 {
+				ids = append(ids, slide.elementIDs[obj]...)
+			}
+		}
+		if len(ids) > 0 {
+			clicks = append(clicks, ids)
+		}
+	}
+	slide.Timing = entranceTiming(clicks)
+	return slide, nil
+}
+
+// stepObjects returns the IDs of the shapes and connections of step in drawing order.
+func stepObjects(step *d2target.Diagram) []string {
+	ids := make([]string, 0, len(step.Shapes)+len(step.Connections))
+	for _, s := range step.Shapes {
+		ids = append(ids, s.ID)
+	}
+	for _, c := range step.Connections {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
+// entranceTiming returns the p:timing of a slide where the elements of each of clicks
+// appear together on a click, in order.
+func entranceTiming(clicks [][]int) string {
+	if len(clicks) == 0 {
+		return ""
+	}
+	// 1 and 2 are the IDs of the root and main sequence time nodes.
+	ctnID := 2
+	next := func() int {
+		ctnID++
+		return ctnID
+	}
+	var b strings.Builder
+	b.WriteString(`<p:timing><p:tnLst><p:par><p:cTn id="1" dur="indefinite" restart="never" nodeType="tmRoot"><p:childTnLst>`)
+	b.WriteString(`<p:seq concurrent="1" nextAc="seek"><p:cTn id="2" dur="indefinite" nodeType="mainSeq"><p:childTnLst>`)
+	for _, ids := range clicks {
+		fmt.Fprintf(&b, `<p:par><p:cTn id="%d" fill="hold"><p:stCondLst><p:cond delay="indefinite"/></p:stCondLst><p:childTnLst>`, next())
+		fmt.Fprintf(&b, `<p:par><p:cTn id="%d" fill="hold"><p:stCondLst><p:cond delay="0"/></p:stCondLst><p:childTnLst>`, next())
+		for i, id := range ids {
+			nodeType := "withEffect"
+			if i == 0 {
+				nodeType = "clickEffect"
+			}
+			// The Appear entrance effect.
+			fmt.Fprintf(&b, `<p:par><p:cTn id="%d" presetID="1" presetClass="entr" presetSubtype="0" fill="hold" nodeType="%s"><p:stCondLst><p:cond delay="0"/></p:stCondLst><p:childTnLst>`, next(), nodeType)
+			fmt.Fprintf(&b, `<p:set><p:cBhvr><p:cTn id="%d" dur="1" fill="hold"><p:stCondLst><p:cond delay="0"/></p:stCondLst></p:cTn><p:tgtEl><p:spTgt spid="%d"/></p:tgtEl><p:attrNameLst><p:attrName>style.visibility</p:attrName></p:attrNameLst></p:cBhvr><p:to><p:strVal val="visible"/></p:to></p:set>`, next(), id)
+			b.WriteString(`</p:childTnLst></p:cTn></p:par>`)
+		}
+		b.WriteString(`</p:childTnLst></p:cTn></p:par>`)
+		b.WriteString(`</p:childTnLst></p:cTn></p:par>`)
+	}
+	b.WriteString(`</p:childTnLst></p:cTn>`)
+	b.WriteString(`<p:prevCondLst><p:cond evt="onPrev" delay="0"><p:tgtEl><p:sldTgt/></p:tgtEl></p:cond></p:prevCondLst>`)
+	b.WriteString(`<p:nextCondLst><p:cond evt="onNext" delay="0"><p:tgtEl><p:sldTgt/></p:tgtEl></p:cond></p:nextCondLst>`)
+	b.WriteString(`</p:seq></p:childTnLst></p:cTn></p:par></p:tnLst></p:timing>`)
+	return b.String()
+}
@@ -0,0 +1,63 @@
+package pptx
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"oss.terrastruct.com/util-go/assert"
+)
+
+func TestAddStepsSlide(t *testing.T) {
+	t.Parallel()
+
+	diagram := compile(t, `steps: {
+  1: {a}
+  2: {b; a -> b}
+  3: {a.style.fill: red}
+  4: {c}
+}
+`)
+	p := NewPresentation("test", "", "test", "", "1", true)
+	slide, err := p.AddStepsSlide(diagram.Steps, 0, 100, []BoardTitle{{Name: "1", BoardID: "root.steps.1", LinkToSlide: 1}}, nil)
+	assert.Success(t, err)
+
+	// a is there from the start, b and a -> b appear on the first click and c on the second
+	for _, id := range []string{"a", "b", "(a -> b)[0]", "c"} {
+		if len(slide.elementIDs[id]) == 0 {
+			t.Fatalf("expected %s to be drawn", id)
+		}
+	}
+	assert.Equal(t, 2, strings.Count(slide.Timing, `nodeType="clickEffect"`))
+	for _, id := range []string{"b", "(a -> b)[0]", "c"} {
+		for _, spid := range slide.elementIDs[id] {
+			if !strings.Contains(slide.Timing, `<p:spTgt spid="`+strconv.Itoa(spid)+`"/>`) {
+				t.Fatalf("expected %s to appear", id)
+			}
+		}
+	}
+	for _, spid := range slide.elementIDs["a"] {
+		if strings.Contains(slide.Timing, `<p:spTgt spid="`+strconv.Itoa(spid)+`"/>`) {
+			t.Fatal("expected a not to appear")
+		}
+	}
+	if strings.Index(slide.Timing, `spid="`+strconv.Itoa(slide.elementIDs["c"][0])+`"`) < strings.Index(slide.Timing, `spid="`+strconv.Itoa(slide.elementIDs["b"][0])+`"`) {
+		t.Fatal("expected c to appear after b")
+	}
+
+	path := filepath.Join(t.TempDir(), "test.pptx")
+	assert.Success(t, p.SaveTo(path))
+	pptx, err := os.ReadFile(path)
+	assert.Success(t, err)
+	assert.Success(t, Validate(pptx, 1))
+	if !strings.Contains(readZipFile(t, pptx, "ppt/slides/slide1.xml"), "<p:timing>") {
+		t.Fatal("expected the slide to be animated")
+	}
+
+	// a single step has nothing to reveal
+	slide, err = p.AddStepsSlide(diagram.Steps[:1], 0, 100, []BoardTitle{{Name: "1", BoardID: "root.steps.1", LinkToSlide: 1}}, nil)
+	assert.Success(t, err)
+	assert.Equal(t, "", slide.Timing)
+}
@@ -0,0 +1,266 @@
+package pptx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Template is a user-provided presentation (.pptx) or template (.potx) whose slide masters,
+// layouts, theme, fonts and media presentations are made from instead of the default blank
+// template, so that exported decks carry corporate backgrounds, logos and fonts. The slides
+// of the template itself are dropped.
+type Template struct {
+	files        []*zip.File
+	presentation string
+	// layout is the target of the layout of the slides, relative to ppt/slides.
+	layout string
+	// notesMaster is the part name of the notes master of the template relative to ppt, if
+	// it has one.
+	notesMaster   string
+	width, height int
+
+	Defaults      []ContentTypeDefault
+	Overrides     []ContentTypeOverride
+	Relationships []Relationship
+}
+
+type ContentTypeDefault struct {
+	Extension   string `xml:"Extension,attr"`
+	ContentType string `xml:"ContentType,attr"`
+}
+
+type ContentTypeOverride struct {
+	PartName    string `xml:"PartName,attr"`
+	ContentType string `xml:"ContentType,attr"`
+}
+
+type Relationship struct {
+	ID         string `xml:"Id,attr"`
+	Type       string `xml:"Type,attr"`
+	Target     string `xml:"Target,attr"`
+	TargetMode string `xml:"TargetMode,attr"`
+}
+
+// generatedParts are the parts of a template that SaveTo writes itself.
+var generatedParts = map[string]bool{
+	"[Content_Types].xml":             true,
+	"ppt/presentation.xml":            true,
+	"ppt/_rels/presentation.xml.rels": true,
+	"docProps/core.xml":               true,
+	"docProps/app.xml":                true,
+}
+
+// isTemplateSlidePart returns whether name is a slide of a template or belongs to one.
+func isTemplateSlidePart(name string) bool {
+	name = strings.TrimPrefix(name, "/")
+	return strings.HasPrefix(name, "ppt/slides/") || strings.HasPrefix(name, "ppt/notesSlides/")
+}
+
+var (
+	sldIdLstRegex   = regexp.MustCompile(`(?s)<p:sldIdLst\s*/>|<p:sldIdLst>.*?</p:sldIdLst>`)
+	custShowRegex   = regexp.MustCompile(`(?s)<p:custShowLst\s*/>|<p:custShowLst>.*?</p:custShowLst>`)
+	sectionLstRegex = regexp.MustCompile(`(?s)<p:ext uri="\{521415D9-36F7-43E2-AB2F-B90AF26B5E84\}">.*?</p:ext>`)
+	sldSzRegex      = regexp.MustCompile(`<p:sldSz\s[^>]*/>`)
+)
+
+// ParseTemplate reads the presentation or template pptxContent to make presentations from.
+func ParseTemplate(pptxContent []byte) (*Template, error) {
+	zipReader, err := zip.NewReader(bytes.NewReader(pptxContent), int64(len(pptxContent)))
+	if err != nil {
+		return nil, fmt.Errorf("error reading template: %v", err)
+	}
+	files := make(map[string]*zip.File, len(zipReader.File))
+	for _, f := range zipReader.File {
+		files[f.Name] = f
+	}
+	read := func(name string) ([]byte, error) {
+		f, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("template has no %s", name)
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("error opening %s: %v", name, err)
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+	t := &Template{}
+
+	var types struct {
+		Defaults  []ContentTypeDefault  `xml:"Default"`
+		Overrides []ContentTypeOverride `xml:"Override"`
+	}
+	content, err := read("[Content_Types].xml")
+	if err != nil {
+		return nil, err
+	}
+	if err := xml.Unmarshal(content, &types); err != nil {
+		return nil, fmt.Errorf("error parsing [Content_Types].xml: %v", err)
+	}
+	for _, d := range types.Defaults {
+		// declared by the content types template
+		switch strings.ToLower(d.Extension) {
+		case "jpeg", "png", "rels", "xml":
+			continue
+		}
+		t.Defaults = append(t.Defaults, d)
+	}
+	for _, o := range types.Overrides {
+		if generatedParts[strings.TrimPrefix(o.PartName, "/")] || isTemplateSlidePart(o.PartName) {
+			continue
+		}
+		t.Overrides = append(t.Overrides, o)
+	}
+
+	var rels struct {
+		Relationships []Relationship `xml:"Relationship"`
+	}
+	content, err = read("ppt/_rels/presentation.xml.rels")
+	if err != nil {
+		return nil, err
+	}
+	if err := xml.Unmarshal(content, &rels); err != nil {
+		return nil, fmt.Errorf("error parsing presentation.xml.rels: %v", err)
+	}
+	relTargets := make(map[string]string)
+	for _, rel := range rels.Relationships {
+		if strings.HasSuffix(rel.Type, "/slide") {
+			continue
+		}
+		t.Relationships = append(t.Relationships, rel)
+		relTargets[rel.ID] = resolveTarget("ppt", rel.Target)
+		if strings.HasSuffix(rel.Type, "/notesMaster") {
+			t.notesMaster = strings.TrimPrefix(relTargets[rel.ID], "ppt/")
+		}
+	}
+
+	var presentation struct {
+		SldSz struct {
+			Cx int `xml:"cx,attr"`
+			Cy int `xml:"cy,attr"`
+		} `xml:"sldSz"`
+		SldMasterIDs []struct {
+			RelationshipID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sldMasterIdLst>sldMasterId"`
+	}
+	content, err = read("ppt/presentation.xml")
+	if err != nil {
+		return nil, err
+	}
+	if err := xml.Unmarshal(content, &presentation); err != nil {
+		return nil, fmt.Errorf("error parsing presentation.xml: %v", err)
+	}
+	if presentation.SldSz.Cx <= 0 || presentation.SldSz.Cy <= 0 {
+		return nil, fmt.Errorf("template has no slide size")
+	}
+	t.width = presentation.SldSz.Cx
+	t.height = presentation.SldSz.Cy
+	if len(presentation.SldMasterIDs) == 0 {
+		return nil, fmt.Errorf("template has no slide master")
+	}
+	// slides and what refers to them are replaced by the slides of the presentation
+	t.presentation = sldIdLstRegex.ReplaceAllString(string(content), "")
+	t.presentation = custShowRegex.ReplaceAllString(t.presentation, "")
+	t.presentation = sectionLstRegex.ReplaceAllString(t.presentation, "")
+	if !strings.Contains(t.presentation, "<p:sldSz") {
+		return nil, fmt.Errorf("template has no slide size")
+	}
+
+	master := relTargets[presentation.SldMasterIDs[0].RelationshipID]
+	content, err = read(path.Join(path.Dir(master), "_rels", path.Base(master)+".rels"))
+	if err != nil {
+		return nil, err
+	}
+	rels.Relationships = nil
+	if err := xml.Unmarshal(content, &rels); err != nil {
+		return nil, fmt.Errorf("error parsing %s relationships: %v", master, err)
+	}
+	for _, rel := range rels.Relationships {
+		if !strings.HasSuffix(rel.Type, "/slideLayout") {
+			continue
+		}
+		layout := resolveTarget(path.Dir(master), rel.Target)
+		content, err := read(layout)
+		if err != nil {
+			return nil, err
+		}
+		var sldLayout struct {
+			Type string `xml:"type,attr"`
+		}
+		if err := xml.Unmarshal(content, &sldLayout); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", layout, err)
+		}
+		// the slides are laid on the blank layout or else the first one of the master
+		if t.layout == "" || sldLayout.Type == "blank" {
+			t.layout = "../" + strings.TrimPrefix(layout, "ppt/")
+		}
+		if sldLayout.Type == "blank" {
+			break
+		}
+	}
+	if t.layout == "" {
+		return nil, fmt.Errorf("template has no slide layout")
+	}
+
+	for _, f := range zipReader.File {
+		if generatedParts[f.Name] || isTemplateSlidePart(f.Name) {
+			continue
+		}
+		t.files = append(t.files, f)
+	}
+	return t, nil
+}
+
+// resolveTarget returns the part name of a relationship target relative to dir.
+func resolveTarget(dir, target string) string {
+	if strings.HasPrefix(target, "/") {
+		return strings.TrimPrefix(target, "/")
+	}
+	return path.Join(dir, target)
+}
+
+func (t *Template) copyTo(w *zip.Writer) error {
+	for _, f := range t.files {
+		if err := w.Copy(f); err != nil {
+			return fmt.Errorf("error copying %s: %v", f.Name, err)
+		}
+	}
+	return nil
+}
+
+func (t *Template) has(name string) bool {
+	for _, f := range t.files {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// presentationXML returns the presentation.xml of the template with the slides of
+// slideFileNames of width by height, and the notes master added to it if addNotesMaster.
+func (t *Template) presentationXML(slideFileNames []string, width, height int, addNotesMaster bool) string {
+	presentation := t.presentation
+	if width != t.width || height != t.height {
+		presentation = sldSzRegex.ReplaceAllString(presentation, fmt.Sprintf(`<p:sldSz cx="%d" cy="%d"/>`, width, height))
+	}
+	if addNotesMaster {
+		i := strings.Index(presentation, "</p:sldMasterIdLst>") + len("</p:sldMasterIdLst>")
+		presentation = presentation[:i] + `<p:notesMasterIdLst><p:notesMasterId r:id="notesMaster"/></p:notesMasterIdLst>` + presentation[i:]
+	}
+	var b strings.Builder
+	b.WriteString("<p:sldIdLst>")
+	for i, name := range slideFileNames {
+		fmt.Fprintf(&b, `<p:sldId id="%d" r:id="%s"/>`, 256+i, name)
+	}
+	b.WriteString("</p:sldIdLst>")
+	i := strings.Index(presentation, "<p:sldSz")
+	return presentation[:i] + b.String() + presentation[i:]
+}
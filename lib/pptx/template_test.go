@@ -0,0 +1,138 @@
+package pptx
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"oss.terrastruct.com/util-go/assert"
+)
+
+func TestTemplate(t *testing.T) {
+	t.Parallel()
+
+	// a 16:9 deck of one slide, as exported by d2, stands in for a corporate template
+	deck := saveNative(t, NewPresentation("template", "", "template", "", "1", true), "a -> b")
+	template := rewriteZip(t, deck, func(name string, content []byte) []byte {
+		if name == "ppt/presentation.xml" {
+			return bytes.Replace(content, []byte(`cx="9144000" cy="5143500"`), []byte(`cx="12192000" cy="6858000"`), 1)
+		}
+		return content
+	})
+	tmpl, err := ParseTemplate(template)
+	assert.Success(t, err)
+	assert.Equal(t, 12192000, tmpl.width)
+	assert.Equal(t, 6858000, tmpl.height)
+	assert.Equal(t, "../slideLayouts/slideLayout7.xml", tmpl.layout)
+
+	p := NewPresentation("test", "", "test", "", "1", true)
+	p.SetTemplate(tmpl)
+	pptx := saveNative(t, p, "x -> y -> z")
+	err = Validate(pptx, 1)
+	assert.Success(t, err)
+
+	presentation := readZipFile(t, pptx, "ppt/presentation.xml")
+	if !strings.Contains(presentation, `<p:sldIdLst><p:sldId id="256" r:id="slide1"/></p:sldIdLst><p:sldSz cx="12192000" cy="6858000"`) {
+		t.Fatalf("expected presentation.xml to list the slide in the size of the template:\n%s", presentation)
+	}
+	if strings.Count(presentation, "<p:sldIdLst>") != 1 {
+		t.Fatalf("expected the slides of the template to be dropped:\n%s", presentation)
+	}
+	if !strings.Contains(readZipFile(t, pptx, "ppt/slides/_rels/slide1.xml.rels"), `Target="../slideLayouts/slideLayout7.xml"`) {
+		t.Fatal("expected slide to be laid on the blank layout")
+	}
+	slide := readZipFile(t, pptx, "ppt/slides/slide1.xml")
+	if !strings.Contains(slide, `<a:ext cx="12183998" cy="392471" />`) {
+		t.Fatal("expected the header to span the slide of the template")
+	}
+	if strings.Contains(slide, `typeface="Source Sans Pro"`) {
+		t.Fatal("expected text to take the fonts of the template")
+	}
+	contentTypes := readZipFile(t, pptx, "[Content_Types].xml")
+	if strings.Count(contentTypes, `PartName="/ppt/slides/slide1.xml"`) != 1 || strings.Count(contentTypes, `PartName="/ppt/presentation.xml"`) != 1 {
+		t.Fatalf("expected slides and the presentation to be declared once:\n%s", contentTypes)
+	}
+	if !strings.Contains(contentTypes, `PartName="/ppt/slideMasters/slideMaster1.xml"`) {
+		t.Fatalf("expected the master of the template to be declared:\n%s", contentTypes)
+	}
+
+	// the size of slides set explicitly overrides the one of the template
+	p = NewPresentation("test", "", "test", "", "1", true)
+	p.SetTemplate(tmpl)
+	p.SetSlideOptions(SlideOptions{Width: 9_144_000, Height: 6_858_000})
+	presentation = readZipFile(t, saveNative(t, p, "x"), "ppt/presentation.xml")
+	if !strings.Contains(presentation, `<p:sldSz cx="9144000" cy="6858000"/>`) {
+		t.Fatalf("expected presentation.xml to have 4:3 slides:\n%s", presentation)
+	}
+}
+
+func TestParseTemplateErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := ParseTemplate([]byte("not a zip"))
+	assert.Error(t, err)
+
+	deck := saveNative(t, NewPresentation("template", "", "template", "", "1", true), "a")
+	_, err = ParseTemplate(rewriteZip(t, deck, func(name string, content []byte) []byte {
+		if name == "ppt/presentation.xml" {
+			return nil
+		}
+		return content
+	}))
+	assert.ErrorString(t, err, "template has no ppt/presentation.xml")
+}
+
+func saveNative(t *testing.T, p *Presentation, script string) []byte {
+	diagram := compile(t, script)
+	_, err := p.AddNativeSlide(diagram, 0, 100, []BoardTitle{{Name: "root", BoardID: "root", LinkToSlide: 1}}, func(link string) (string, int) {
+		return link, 0
+	})
+	assert.Success(t, err)
+	path := filepath.Join(t.TempDir(), "test.pptx")
+	err = p.SaveTo(path)
+	assert.Success(t, err)
+	pptx, err := os.ReadFile(path)
+	assert.Success(t, err)
+	return pptx
+}
+
+// rewriteZip returns pptx with the content of its files replaced by rewrite. Files
+// rewritten to nil are removed.
+func rewriteZip(t *testing.T, pptx []byte, rewrite func(name string, content []byte) []byte) []byte {
+	r, err := zip.NewReader(bytes.NewReader(pptx), int64(len(pptx)))
+	assert.Success(t, err)
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for _, f := range r.File {
+		rc, err := f.Open()
+		assert.Success(t, err)
+		content, err := io.ReadAll(rc)
+		assert.Success(t, err)
+		rc.Close()
+		content = rewrite(f.Name, content)
+		if content == nil {
+			continue
+		}
+		fw, err := w.Create(f.Name)
+		assert.Success(t, err)
+		_, err = fw.Write(content)
+		assert.Success(t, err)
+	}
+	assert.Success(t, w.Close())
+	return b.Bytes()
+}
+
+func readZipFile(t *testing.T, pptx []byte, name string) string {
+	r, err := zip.NewReader(bytes.NewReader(pptx), int64(len(pptx)))
+	assert.Success(t, err)
+	f, err := r.Open(name)
+	assert.Success(t, err)
+	defer f.Close()
+	content, err := io.ReadAll(f)
+	assert.Success(t, err)
+	return string(content)
+}
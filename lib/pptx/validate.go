@@ -16,7 +16,16 @@ func Validate(pptxContent []byte, nSlides int) error {
 		fmt.Printf("error reading pptx content: %v", err)
 	}
 
-	expectedCount := getExpectedPptxFileCount(nSlides)
+	var nImages, nNotes int
+	for _, file := range zipReader.File {
+		if strings.HasPrefix(file.Name, "ppt/media/slide") {
+			nImages++
+		}
+		if strings.HasPrefix(file.Name, "ppt/notesSlides/notesSlide") {
+			nNotes++
+		}
+	}
+	expectedCount := getExpectedPptxFileCount(nSlides, nImages, nNotes)
 	if len(zipReader.File) != expectedCount {
 		return fmt.Errorf("expected %d files, got %d", expectedCount, len(zipReader.File))
 	}
@@ -28,9 +37,17 @@ func Validate(pptxContent []byte, nSlides int) error {
 		if err := checkFile(zipReader, fmt.Sprintf("ppt/slides/_rels/slide%d.xml.rels", i+1)); err != nil {
 			return err
 		}
-		if err := checkFile(zipReader, fmt.Sprintf("ppt/media/slide%dImage.png", i+1)); err != nil {
+		// Slides drawn natively have no image.
+		rels, err := readFile(zipReader, fmt.Sprintf("ppt/slides/_rels/slide%d.xml.rels", i+1))
+		if err != nil {
 			return err
 		}
+		image := fmt.Sprintf("slide%dImage.png", i+1)
+		if bytes.Contains(rels, []byte("../media/"+image)) {
+			if err := checkFile(zipReader, "ppt/media/"+image); err != nil {
+				return err
+			}
+		}
 	}
 
 	for _, file := range zipReader.File {
@@ -69,7 +86,19 @@ func checkFile(reader *zip.Reader, fname string) error {
 	return nil
 }
 
-func getExpectedPptxFileCount(nSlides int) int {
+func readFile(reader *zip.Reader, fname string) ([]byte, error) {
+	f, err := reader.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file %s: %v", fname, err)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// getExpectedPptxFileCount returns the number of files of a presentation of nSlides slides,
+// nImages of which are drawn from images rather than natively and nNotes of which have
+// speaker notes.
+func getExpectedPptxFileCount(nSlides, nImages, nNotes int) int {
 	reader := bytes.NewReader(PPTX_TEMPLATE)
 	zipReader, err := zip.NewReader(reader, reader.Size())
 	if err != nil {
@@ -77,6 +106,10 @@ func getExpectedPptxFileCount(nSlides int) int {
 	}
 	baseFiles := len(zipReader.File)
 	presentationFiles := 5    // presentation, rels, app, core, content types
-	slideFiles := 3 * nSlides // slides, rels, images
-	return baseFiles + presentationFiles + slideFiles
+	slideFiles := 2 * nSlides // slides, rels
+	notesFiles := 2 * nNotes  // notes slides, rels
+	if nNotes > 0 {
+		notesFiles += 3 // notes master, rels, theme
+	}
+	return baseFiles + presentationFiles + slideFiles + nImages + notesFiles
 }
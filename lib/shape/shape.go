@@ -26,6 +26,7 @@ const (
 	CIRCLE_TYPE        = "Circle"
 	HEXAGON_TYPE       = "Hexagon"
 	CLOUD_TYPE         = "Cloud"
+	CUSTOM_TYPE        = "Custom"
 
 	TABLE_TYPE = "Table"
 	CLASS_TYPE = "Class"
@@ -135,6 +136,8 @@ func NewShape(shapeType string, box *geo.Box) Shape {
 		return NewCloud(box)
 	case CODE_TYPE:
 		return NewCode(box)
+	case CUSTOM_TYPE:
+		return NewCustom(box, "")
 	case CYLINDER_TYPE:
 		return NewCylinder(box)
 	case DIAMOND_TYPE:
@@ -0,0 +1,157 @@
+package shape
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"strings"
+
+	"oss.terrastruct.com/d2/lib/geo"
+	"oss.terrastruct.com/d2/lib/svg"
+	"oss.terrastruct.com/util-go/go2"
+)
+
+type shapeCustom struct {
+	*baseShape
+	// cmds are the commands of the path of the shape, scaled to fit in a 1x1 box.
+	cmds []svg.PathCommand
+}
+
+// NewCustom returns a shape drawn with the SVG path data pathData, stretched to fill box.
+// The shape is a rectangle if pathData draws nothing.
+func NewCustom(box *geo.Box, pathData string) Shape {
+	shape := shapeCustom{
+		baseShape: &baseShape{
+			Type: CUSTOM_TYPE,
+			Box:  box,
+		},
+		cmds: normalizePath(svg.ParsePath(pathData)),
+	}
+	shape.FullShape = go2.Pointer(Shape(shape))
+	return shape
+}
+
+// NewShapeWithPath is NewShape for shapes that may be custom, pathData being the path of
+// custom ones.
+func NewShapeWithPath(shapeType string, box *geo.Box, pathData string) Shape {
+	if shapeType == CUSTOM_TYPE {
+		return NewCustom(box, pathData)
+	}
+	return NewShape(shapeType, box)
+}
+
+var pathDataChars = regexp.MustCompile(`^[\sMmLlHhVvCcSsQqTtAaZz0-9eE.,+-]*$`)
+
+// ValidatePath returns why pathData can't be the path of a custom shape, if it can't.
+func ValidatePath(pathData string) error {
+	if !pathDataChars.MatchString(pathData) {
+		return errors.New("path data must only contain SVG path commands and numbers")
+	}
+	trimmed := strings.TrimSpace(pathData)
+	if trimmed == "" || (trimmed[0] != 'M' && trimmed[0] != 'm') {
+		return errors.New("path data must start with a move command")
+	}
+	tl, br, ok := pathBounds(svg.ParsePath(pathData))
+	if !ok || br.X-tl.X <= 0 || br.Y-tl.Y <= 0 {
+		return errors.New("path data must draw an area")
+	}
+	return nil
+}
+
+// pathBounds returns the corners of the bounding box of the path of cmds, sampling curves.
+func pathBounds(cmds []svg.PathCommand) (tl, br geo.Point, ok bool) {
+	tl = geo.Point{X: math.Inf(1), Y: math.Inf(1)}
+	br = geo.Point{X: math.Inf(-1), Y: math.Inf(-1)}
+	add := func(p geo.Point) {
+		tl.X, tl.Y = math.Min(tl.X, p.X), math.Min(tl.Y, p.Y)
+		br.X, br.Y = math.Max(br.X, p.X), math.Max(br.Y, p.Y)
+	}
+	var cur geo.Point
+	for _, c := range cmds {
+		switch c.Op {
+		case 'M', 'L':
+			add(c.Points[0])
+			cur = c.Points[0]
+		case 'C':
+			curve := geo.NewBezierCurve([]*geo.Point{&cur, &c.Points[0], &c.Points[1], &c.Points[2]})
+			for t := 0.; t <= 1; t += 1. / 16 {
+				add(*curve.At(t))
+			}
+			add(c.Points[2])
+			cur = c.Points[2]
+		}
+	}
+	return tl, br, !math.IsInf(tl.X, 1)
+}
+
+// normalizePath returns cmds scaled and translated so that their path fits in a 1x1 box, nil
+// if their path has no area.
+func normalizePath(cmds []svg.PathCommand) []svg.PathCommand {
+	tl, br, ok := pathBounds(cmds)
+	if !ok || br.X-tl.X <= 0 || br.Y-tl.Y <= 0 {
+		return nil
+	}
+	w, h := br.X-tl.X, br.Y-tl.Y
+	normalized := make([]svg.PathCommand, len(cmds))
+	for i, c := range cmds {
+		normalized[i] = c
+		for j := 0; j < c.NumPoints(); j++ {
+			normalized[i].Points[j] = geo.Point{
+				X: (c.Points[j].X - tl.X) / w,
+				Y: (c.Points[j].Y - tl.Y) / h,
+			}
+		}
+	}
+	return normalized
+}
+
+func (s shapeCustom) GetInnerBox() *geo.Box {
+	width := s.Box.Width
+	height := s.Box.Height
+	tl := s.Box.TopLeft.Copy()
+	tl.X += width / 6.
+	width /= 1.5
+	tl.Y += height / 6.
+	height /= 1.5
+	return geo.NewBox(tl, width, height)
+}
+
+func customPath(box *geo.Box, cmds []svg.PathCommand) *svg.SvgPathContext {
+	if len(cmds) == 0 {
+		return boxPath(box)
+	}
+	pc := svg.NewSVGPathContext(box.TopLeft, box.Width, box.Height)
+	for _, c := range cmds {
+		switch c.Op {
+		case 'M':
+			pc.StartAt(pc.Absolute(c.Points[0].X, c.Points[0].Y))
+		case 'L':
+			pc.L(false, c.Points[0].X, c.Points[0].Y)
+		case 'C':
+			pc.C(false, c.Points[0].X, c.Points[0].Y, c.Points[1].X, c.Points[1].Y, c.Points[2].X, c.Points[2].Y)
+		case 'Z':
+			pc.Z()
+		}
+	}
+	return pc
+}
+
+func (s shapeCustom) Perimeter() []geo.Intersectable {
+	return customPath(s.Box, s.cmds).Path
+}
+
+func (s shapeCustom) GetSVGPathData() []string {
+	return []string{
+		customPath(s.Box, s.cmds).PathData(),
+	}
+}
+
+func (s shapeCustom) GetDimensionsToFit(width, height, paddingX, paddingY float64) (float64, float64) {
+	totalWidth := 1.5 * (width + paddingX)
+	totalHeight := 1.5 * (height + paddingY)
+	return math.Ceil(totalWidth), math.Ceil(totalHeight)
+}
+
+func (s shapeCustom) GetDefaultPadding() (paddingX, paddingY float64) {
+	return defaultPadding / 2, defaultPadding / 2
+}
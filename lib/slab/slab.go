@@ -0,0 +1,29 @@
+// Package slab allocates values in chunks rather than one by one, for the nodes compiling
+// large diagrams allocates the most of. A chunk is freed once none of its values are
+// referenced anymore.
+package slab
+
+const (
+	minChunk = 16
+	maxChunk = 1024
+)
+
+// Slab allocates values of T. The zero value is ready to use.
+//
+// Chunks start small and double up to maxChunk so that compiling small diagrams doesn't
+// allocate more than it did before.
+type Slab[T any] struct {
+	free []T
+	size int
+}
+
+// New returns a pointer to a zero T.
+func (s *Slab[T]) New() *T {
+	if len(s.free) == 0 {
+		s.size = min(max(s.size*2, minChunk), maxChunk)
+		s.free = make([]T, s.size)
+	}
+	v := &s.free[0]
+	s.free = s.free[1:]
+	return v
+}
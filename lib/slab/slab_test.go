@@ -0,0 +1,33 @@
+package slab_test
+
+import (
+	"testing"
+
+	"oss.terrastruct.com/d2/lib/slab"
+)
+
+func TestSlab(t *testing.T) {
+	var s slab.Slab[int]
+	seen := make(map[*int]struct{})
+	for i := 0; i < 5000; i++ {
+		v := s.New()
+		if *v != 0 {
+			t.Fatalf("expected zero value, got %d", *v)
+		}
+		if _, ok := seen[v]; ok {
+			t.Fatalf("value %d allocated twice", i)
+		}
+		seen[v] = struct{}{}
+		*v = i
+	}
+
+	var s2 slab.Slab[int]
+	allocs := testing.AllocsPerRun(1, func() {
+		for i := 0; i < 1024*8; i++ {
+			s2.New()
+		}
+	})
+	if allocs > 16 {
+		t.Fatalf("expected at most 16 allocations, got %v", allocs)
+	}
+}
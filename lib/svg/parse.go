@@ -0,0 +1,383 @@
+package svg
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"oss.terrastruct.com/d2/lib/geo"
+)
+
+// PathCommand is a command of a path normalized to absolute M, L, C and Z commands.
+type PathCommand struct {
+	Op     byte
+	Points [3]geo.Point
+}
+
+var pathTokens = regexp.MustCompile(`[MmLlHhVvCcSsQqTtAaZz]|[-+]?(?:\d*\.\d+|\d+\.?)(?:[eE][-+]?\d+)?`)
+
+// ParsePath parses the d attribute of an SVG path. Quadratic curves and arcs are converted
+// into cubic curves. Parsing stops at the first malformed command.
+func ParsePath(d string) []PathCommand {
+	tokens := pathTokens.FindAllString(d, -1)
+	var cmds []PathCommand
+	var cur, start, lastCtrl geo.Point
+	// lastOp is the last command parsed, for S and T to reflect the control point of the
+	// curve before them.
+	var op, lastOp byte
+	i := 0
+	num := func() (float64, bool) {
+		if i >= len(tokens) {
+			return 0, false
+		}
+		f, err := strconv.ParseFloat(tokens[i], 64)
+		if err != nil {
+			return 0, false
+		}
+		i++
+		return f, true
+	}
+	// flag reads a flag of an arc, which need not be separated from what follows it.
+	flag := func() (bool, bool) {
+		if i >= len(tokens) || tokens[i][0] != '0' && tokens[i][0] != '1' {
+			return false, false
+		}
+		f := tokens[i][0] == '1'
+		if len(tokens[i]) > 1 {
+			tokens[i] = tokens[i][1:]
+		} else {
+			i++
+		}
+		return f, true
+	}
+	point := func(rel bool) (geo.Point, bool) {
+		x, ok := num()
+		if !ok {
+			return geo.Point{}, false
+		}
+		y, ok := num()
+		if !ok {
+			return geo.Point{}, false
+		}
+		if rel {
+			x += cur.X
+			y += cur.Y
+		}
+		return geo.Point{X: x, Y: y}, true
+	}
+
+	for i < len(tokens) {
+		if c := tokens[i][0]; strings.IndexByte("MmLlHhVvCcSsQqTtAaZz", c) >= 0 {
+			op = c
+			i++
+		} else if op == 0 {
+			return cmds
+		}
+		rel := op >= 'a'
+		cmdOp := op &^ 0x20
+		switch op {
+		case 'M', 'm':
+			p, ok := point(rel)
+			if !ok {
+				return cmds
+			}
+			cmds = append(cmds, PathCommand{Op: 'M', Points: [3]geo.Point{p}})
+			cur, start = p, p
+			// Coordinates after those of a move are lines.
+			op = 'L' + (op - 'M')
+		case 'L', 'l', 'H', 'h', 'V', 'v':
+			p := cur
+			var ok bool
+			switch op {
+			case 'L', 'l':
+				p, ok = point(rel)
+			case 'H', 'h':
+				p.X, ok = num()
+				if rel {
+					p.X += cur.X
+				}
+			case 'V', 'v':
+				p.Y, ok = num()
+				if rel {
+					p.Y += cur.Y
+				}
+			}
+			if !ok {
+				return cmds
+			}
+			cmds = append(cmds, PathCommand{Op: 'L', Points: [3]geo.Point{p}})
+			cur = p
+		case 'C', 'c', 'S', 's':
+			var pts [3]geo.Point
+			j := 0
+			if op == 'S' || op == 's' {
+				pts[0] = cur
+				if lastOp == 'C' || lastOp == 'S' {
+					pts[0] = geo.Point{X: 2*cur.X - lastCtrl.X, Y: 2*cur.Y - lastCtrl.Y}
+				}
+				j = 1
+			}
+			for ; j < 3; j++ {
+				p, ok := point(rel)
+				if !ok {
+					return cmds
+				}
+				pts[j] = p
+			}
+			cmds = append(cmds, PathCommand{Op: 'C', Points: pts})
+			lastCtrl = pts[1]
+			cur = pts[2]
+		case 'Q', 'q', 'T', 't':
+			ctrl := cur
+			if op == 'Q' || op == 'q' {
+				var ok bool
+				ctrl, ok = point(rel)
+				if !ok {
+					return cmds
+				}
+			} else if lastOp == 'Q' || lastOp == 'T' {
+				ctrl = geo.Point{X: 2*cur.X - lastCtrl.X, Y: 2*cur.Y - lastCtrl.Y}
+			}
+			p, ok := point(rel)
+			if !ok {
+				return cmds
+			}
+			cmds = append(cmds, PathCommand{Op: 'C', Points: [3]geo.Point{
+				{X: cur.X + 2./3*(ctrl.X-cur.X), Y: cur.Y + 2./3*(ctrl.Y-cur.Y)},
+				{X: p.X + 2./3*(ctrl.X-p.X), Y: p.Y + 2./3*(ctrl.Y-p.Y)},
+				p,
+			}})
+			lastCtrl = ctrl
+			cur = p
+		case 'A', 'a':
+			rx, ok1 := num()
+			ry, ok2 := num()
+			rotation, ok3 := num()
+			largeArc, ok4 := flag()
+			sweep, ok5 := flag()
+			if !(ok1 && ok2 && ok3 && ok4 && ok5) {
+				return cmds
+			}
+			p, ok := point(rel)
+			if !ok {
+				return cmds
+			}
+			cmds = append(cmds, arcCommands(cur, p, rx, ry, rotation, largeArc, sweep)...)
+			cur = p
+		case 'Z', 'z':
+			cmds = append(cmds, PathCommand{Op: 'Z'})
+			cur = start
+			op = 0
+		}
+		lastOp = cmdOp
+	}
+	return cmds
+}
+
+// arcCommands returns the cubic curves approximating the arc of an A command from p1 to p2,
+// per the implementation notes of the SVG specification.
+func arcCommands(p1, p2 geo.Point, rx, ry, rotation float64, largeArc, sweep bool) []PathCommand {
+	if p1 == p2 {
+		return nil
+	}
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 {
+		return []PathCommand{{Op: 'L', Points: [3]geo.Point{p2}}}
+	}
+	sin, cos := math.Sincos(rotation * math.Pi / 180)
+	dx, dy := (p1.X-p2.X)/2, (p1.Y-p2.Y)/2
+	x1 := cos*dx + sin*dy
+	y1 := -sin*dx + cos*dy
+	// Radii too small for the arc to reach p2 are scaled up.
+	if l := x1*x1/(rx*rx) + y1*y1/(ry*ry); l > 1 {
+		rx *= math.Sqrt(l)
+		ry *= math.Sqrt(l)
+	}
+	num := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	den := rx*rx*y1*y1 + ry*ry*x1*x1
+	coef := math.Sqrt(math.Max(0, num/den))
+	if largeArc == sweep {
+		coef = -coef
+	}
+	cx1, cy1 := coef*rx*y1/ry, -coef*ry*x1/rx
+	cx := cos*cx1 - sin*cy1 + (p1.X+p2.X)/2
+	cy := sin*cx1 + cos*cy1 + (p1.Y+p2.Y)/2
+
+	angle := func(ux, uy, vx, vy float64) float64 {
+		return math.Atan2(ux*vy-uy*vx, ux*vx+uy*vy)
+	}
+	theta := angle(1, 0, (x1-cx1)/rx, (y1-cy1)/ry)
+	delta := angle((x1-cx1)/rx, (y1-cy1)/ry, (-x1-cx1)/rx, (-y1-cy1)/ry)
+	if !sweep && delta > 0 {
+		delta -= 2 * math.Pi
+	} else if sweep && delta < 0 {
+		delta += 2 * math.Pi
+	}
+
+	// Every curve spans at most a quarter of the ellipse.
+	n := int(math.Ceil(math.Abs(delta) / (math.Pi / 2)))
+	step := delta / float64(n)
+	k := 4. / 3 * math.Tan(step/4)
+	onEllipse := func(ux, uy float64) geo.Point {
+		return geo.Point{X: cx + rx*ux*cos - ry*uy*sin, Y: cy + rx*ux*sin + ry*uy*cos}
+	}
+	cmds := make([]PathCommand, n)
+	for i := range cmds {
+		a1 := theta + float64(i)*step
+		a2 := a1 + step
+		sin1, cos1 := math.Sincos(a1)
+		sin2, cos2 := math.Sincos(a2)
+		cmds[i] = PathCommand{Op: 'C', Points: [3]geo.Point{
+			onEllipse(cos1-k*sin1, sin1+k*cos1),
+			onEllipse(cos2+k*sin2, sin2-k*cos2),
+			onEllipse(cos2, sin2),
+		}}
+	}
+	cmds[n-1].Points[2] = p2
+	return cmds
+}
+
+// PathEnds returns the ends of the path of cmds with the directions of the path at them,
+// the way SVG orients markers.
+func PathEnds(cmds []PathCommand) (start, startDir, end, endDir geo.Point) {
+	start = cmds[0].Points[0]
+	cur := start
+	for _, c := range cmds[1:] {
+		if c.Op == 'Z' {
+			break
+		}
+		for _, p := range c.Points[:c.NumPoints()] {
+			if p != start {
+				startDir = geo.Point{X: p.X - start.X, Y: p.Y - start.Y}
+				break
+			}
+		}
+		if startDir != (geo.Point{}) {
+			break
+		}
+	}
+
+	prev := start
+	for _, c := range cmds {
+		if c.Op == 'Z' {
+			continue
+		}
+		n := c.NumPoints()
+		for _, p := range c.Points[:n] {
+			if p != cur {
+				prev = cur
+				cur = p
+			}
+		}
+	}
+	// The direction at the end is the one from the last distinct point before it, which
+	// for curves is their last control point.
+	return start, startDir, cur, geo.Point{X: cur.X - prev.X, Y: cur.Y - prev.Y}
+}
+
+// NumPoints returns the number of points of c, the end point being the last.
+func (c PathCommand) NumPoints() int {
+	switch c.Op {
+	case 'C':
+		return 3
+	case 'Z':
+		return 0
+	}
+	return 1
+}
+
+// ExtractPathData returns the shapes of the SVG document svg as the d attribute of a single
+// path: every path, polygon, polyline, rect, circle and ellipse outside of definitions.
+// Transforms are ignored.
+func ExtractPathData(svg []byte) (string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(svg))
+	var paths []string
+	// skipped is the depth in elements that aren't drawn, e.g. defs.
+	skipped := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to parse SVG: %w", err)
+		}
+		switch el := tok.(type) {
+		case xml.StartElement:
+			if skipped > 0 {
+				skipped++
+				continue
+			}
+			switch el.Name.Local {
+			case "defs", "clipPath", "mask", "symbol", "pattern", "marker":
+				skipped = 1
+				continue
+			}
+			if d := elementPathData(el); d != "" {
+				paths = append(paths, d)
+			}
+		case xml.EndElement:
+			if skipped > 0 {
+				skipped--
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return "", errors.New("SVG has no paths")
+	}
+	return strings.Join(paths, " "), nil
+}
+
+// elementPathData returns the d attribute of a path equivalent to el.
+func elementPathData(el xml.StartElement) string {
+	attrs := make(map[string]string, len(el.Attr))
+	for _, a := range el.Attr {
+		attrs[a.Name.Local] = a.Value
+	}
+	num := func(name string) float64 {
+		f, _ := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(attrs[name]), "px"), 64)
+		return f
+	}
+	f := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	switch el.Name.Local {
+	case "path":
+		return strings.TrimSpace(attrs["d"])
+	case "polygon", "polyline":
+		coords := pathTokens.FindAllString(attrs["points"], -1)
+		if len(coords) < 4 {
+			return ""
+		}
+		d := "M " + strings.Join(coords, " ")
+		if el.Name.Local == "polygon" {
+			d += " Z"
+		}
+		return d
+	case "rect":
+		x, y, w, h := num("x"), num("y"), num("width"), num("height")
+		if w <= 0 || h <= 0 {
+			return ""
+		}
+		return "M " + f(x) + " " + f(y) + " h " + f(w) + " v " + f(h) + " h " + f(-w) + " Z"
+	case "circle", "ellipse":
+		cx, cy := num("cx"), num("cy")
+		rx, ry := num("rx"), num("ry")
+		if el.Name.Local == "circle" {
+			rx, ry = num("r"), num("r")
+		}
+		if rx <= 0 || ry <= 0 {
+			return ""
+		}
+		return "M " + f(cx-rx) + " " + f(cy) +
+			" A " + f(rx) + " " + f(ry) + " 0 1 0 " + f(cx+rx) + " " + f(cy) +
+			" A " + f(rx) + " " + f(ry) + " 0 1 0 " + f(cx-rx) + " " + f(cy) + " Z"
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package svg
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"oss.terrastruct.com/util-go/assert"
+
+	"oss.terrastruct.com/d2/lib/geo"
+)
+
+func TestParsePath(t *testing.T) {
+	t.Parallel()
+
+	cmds := ParsePath("M 0 0 L 10 0 S 20 0 20 10 h -5 v5 c 1,1 2,2 3,3 Z m 1e1 -1")
+	if !reflect.DeepEqual([]PathCommand{
+		{Op: 'M', Points: [3]geo.Point{{X: 0, Y: 0}}},
+		{Op: 'L', Points: [3]geo.Point{{X: 10, Y: 0}}},
+		{Op: 'C', Points: [3]geo.Point{{X: 10, Y: 0}, {X: 20, Y: 0}, {X: 20, Y: 10}}},
+		{Op: 'L', Points: [3]geo.Point{{X: 15, Y: 10}}},
+		{Op: 'L', Points: [3]geo.Point{{X: 15, Y: 15}}},
+		{Op: 'C', Points: [3]geo.Point{{X: 16, Y: 16}, {X: 17, Y: 17}, {X: 18, Y: 18}}},
+		{Op: 'Z'},
+		{Op: 'M', Points: [3]geo.Point{{X: 10, Y: -1}}},
+	}, cmds) {
+		t.Fatalf("unexpected commands %v", cmds)
+	}
+
+	start, startDir, end, endDir := PathEnds(cmds[:6])
+	assert.Equal(t, geo.Point{X: 0, Y: 0}, start)
+	assert.Equal(t, geo.Point{X: 10, Y: 0}, startDir)
+	assert.Equal(t, geo.Point{X: 18, Y: 18}, end)
+	assert.Equal(t, geo.Point{X: 1, Y: 1}, endDir)
+}
+
+func TestParsePathCurves(t *testing.T) {
+	t.Parallel()
+
+	cmds := ParsePath("M0 0 Q 3 3 6 0 T 12 0 A 5 5 0 0 1 22 0 a5 5 0 1110 0")
+	assert.Equal(t, 7, len(cmds))
+	assert.Equal(t, PathCommand{Op: 'C', Points: [3]geo.Point{{X: 2, Y: 2}, {X: 4, Y: 2}, {X: 6, Y: 0}}}, cmds[1])
+	// The control point of T is the reflection of the one of Q.
+	assert.Equal(t, PathCommand{Op: 'C', Points: [3]geo.Point{{X: 8, Y: -2}, {X: 10, Y: -2}, {X: 12, Y: 0}}}, cmds[2])
+	// A half circle is two quarters, the first ending at its top.
+	top := cmds[3].Points[2]
+	if math.Abs(top.X-17) > 1e-9 || math.Abs(top.Y+5) > 1e-9 {
+		t.Fatalf("unexpected arc %v", cmds[3:5])
+	}
+	assert.Equal(t, geo.Point{X: 22, Y: 0}, cmds[4].Points[2])
+	// Compact flags.
+	assert.Equal(t, geo.Point{X: 32, Y: 0}, cmds[6].Points[2])
+}
+
+func TestExtractPathData(t *testing.T) {
+	t.Parallel()
+
+	d, err := ExtractPathData([]byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10">
+<defs><path d="M 0 0 L 1 1"/></defs>
+<g><path d="M 0 0 L 10 0 L 5 10 Z"/></g>
+<polygon points="0,0 1,0 1,1"/>
+<rect x="1" y="2" width="3" height="4"/>
+</svg>`))
+	assert.Success(t, err)
+	assert.Equal(t, "M 0 0 L 10 0 L 5 10 Z M 0 0 1 0 1 1 Z M 1 2 h 3 v 4 h -3 Z", d)
+
+	_, err = ExtractPathData([]byte(`<svg xmlns="http://www.w3.org/2000/svg"><text>hi</text></svg>`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,72 @@
+package textmeasure
+
+import (
+	"sync"
+
+	"oss.terrastruct.com/d2/d2renderers/d2fonts"
+)
+
+// Cache caches the measurements of text by font, size and string so that labels repeated
+// across boards, or across the compilations of watch mode, are only shaped once. A Cache
+// may be shared by rulers, including concurrently, as long as they measure with the same
+// font families.
+type Cache struct {
+	mu           sync.Mutex
+	measurements map[cacheKey]measurement
+	hits         int
+	misses       int
+}
+
+// CacheStats are the statistics of a Cache.
+type CacheStats struct {
+	Hits    int
+	Misses  int
+	Entries int
+}
+
+type cacheKey struct {
+	font d2fonts.Font
+	s    string
+	// Both affect the bounds of the text.
+	lineHeightFactor float64
+	boundsWithDot    bool
+}
+
+type measurement struct {
+	width  float64
+	height float64
+}
+
+func NewCache() *Cache {
+	return &Cache{
+		measurements: make(map[cacheKey]measurement),
+	}
+}
+
+func (c *Cache) get(k cacheKey) (measurement, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m, ok := c.measurements[k]
+	if ok {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	return m, ok
+}
+
+func (c *Cache) set(k cacheKey, m measurement) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.measurements[k] = m
+}
+
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Hits:    c.hits,
+		Misses:  c.misses,
+		Entries: len(c.measurements),
+	}
+}
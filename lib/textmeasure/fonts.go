@@ -0,0 +1,38 @@
+package textmeasure
+
+import (
+	"sync"
+
+	"github.com/golang/freetype/truetype"
+)
+
+// parsedFonts holds the fonts parsed by all rulers of the process, by face. Faces are keyed
+// by identity rather than by family and style as the same family name may be registered
+// again with different faces, e.g. custom fonts, and families share faces they fall back to.
+var parsedFonts sync.Map
+
+type faceKey struct {
+	data *byte
+	len  int
+}
+
+type parsedFont struct {
+	once sync.Once
+	ttf  *truetype.Font
+	err  error
+}
+
+// parseFont parses face, or returns the font it was already parsed into. A parsed
+// truetype.Font is safe to share as faces of any size are created from it without
+// modifying it.
+func parseFont(face []byte) (*truetype.Font, error) {
+	if len(face) == 0 {
+		return truetype.Parse(face)
+	}
+	v, _ := parsedFonts.LoadOrStore(faceKey{&face[0], len(face)}, &parsedFont{})
+	pf := v.(*parsedFont)
+	pf.once.Do(func() {
+		pf.ttf, pf.err = truetype.Parse(face)
+	})
+	return pf.ttf, pf.err
+}
@@ -0,0 +1,47 @@
+package textmeasure
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/util-go/go2"
+
+	"oss.terrastruct.com/d2/d2renderers/d2fonts"
+)
+
+func TestLazyFonts(t *testing.T) {
+	r1, err := NewRuler()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(r1.ttfs))
+
+	r1.Measure(d2fonts.SourceSansPro.Font(d2fonts.FONT_SIZE_M, d2fonts.FONT_STYLE_BOLD), "x")
+	assert.Equal(t, 1, len(r1.ttfs))
+
+	// Rulers share the fonts parsed, as do styles falling back to the same face.
+	r2, err := NewRuler()
+	assert.Nil(t, err)
+	assert.True(t, r2.HasFontFamilyLoaded(go2.Pointer(d2fonts.HandDrawn)))
+	assert.Equal(t, r2.ttfs[d2fonts.HandDrawn.Font(0, d2fonts.FONT_STYLE_REGULAR)], r2.ttfs[d2fonts.HandDrawn.Font(0, d2fonts.FONT_STYLE_ITALIC)])
+	r2.Measure(d2fonts.SourceSansPro.Font(d2fonts.FONT_SIZE_L, d2fonts.FONT_STYLE_BOLD), "x")
+	sizeless := d2fonts.SourceSansPro.Font(0, d2fonts.FONT_STYLE_BOLD)
+	assert.Same(t, r1.ttfs[sizeless], r2.ttfs[sizeless])
+
+	_, err = parseFont([]byte("not a font"))
+	assert.NotNil(t, err)
+}
+
+func TestBadFont(t *testing.T) {
+	bad := d2fonts.FontFamily("bad")
+	for _, style := range d2fonts.FontStyles {
+		d2fonts.FontFaces.Set(bad.Font(0, style), []byte("not a font"))
+	}
+
+	r, err := NewRuler()
+	assert.Nil(t, err)
+	assert.EqualError(t, r.LoadFontFamily(&bad), `failed to parse font bad regular: freetype: invalid TrueType format: TTF data is too short`)
+	assert.False(t, r.HasFontFamilyLoaded(&bad))
+
+	w, _ := r.Measure(bad.Font(d2fonts.FONT_SIZE_M, d2fonts.FONT_STYLE_REGULAR), "x")
+	assert.Greater(t, w, 0)
+}
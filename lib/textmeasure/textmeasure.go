@@ -4,6 +4,7 @@
 package textmeasure
 
 import (
+	"fmt"
 	"math"
 	"strings"
 	"unicode"
@@ -92,6 +93,10 @@ type Ruler struct {
 
 	// when drawing text also union Ruler.bounds with Dot
 	boundsWithDot bool
+
+	// Cache caches measurements. NewRuler gives every Ruler its own, which can be replaced
+	// with one shared across rulers.
+	Cache *Cache
 }
 
 // New creates a new Ruler capable of drawing runes contained in the provided atlas. Orig and Dot
@@ -117,27 +122,7 @@ func NewRuler() (*Ruler, error) {
 		tabWidths:        make(map[d2fonts.Font]float64),
 		atlases:          make(map[d2fonts.Font]*atlas),
 		ttfs:             make(map[d2fonts.Font]*truetype.Font),
-	}
-
-	for _, fontFamily := range d2fonts.FontFamilies {
-		for _, fontStyle := range d2fonts.FontStyles {
-			font := d2fonts.Font{
-				Family: fontFamily,
-				Style:  fontStyle,
-			}
-			// Note: FontFaces lookup is size-agnostic
-			face, has := d2fonts.FontFaces.Lookup(font)
-			if !has {
-				continue
-			}
-			if _, loaded := r.ttfs[font]; !loaded {
-				ttf, err := truetype.Parse(face)
-				if err != nil {
-					return nil, err
-				}
-				r.ttfs[font] = ttf
-			}
-		}
+		Cache:            NewCache(),
 	}
 
 	r.clear()
@@ -145,26 +130,63 @@ func NewRuler() (*Ruler, error) {
 	return r, nil
 }
 
+// HasFontFamilyLoaded returns whether every style of fontFamily has a face that parses.
 func (r *Ruler) HasFontFamilyLoaded(fontFamily *d2fonts.FontFamily) bool {
+	return r.LoadFontFamily(fontFamily) == nil
+}
+
+// LoadFontFamily parses every style of fontFamily, returning the error of the first that
+// doesn't have a face that parses. Measuring with a family it failed for measures with
+// the default family instead.
+func (r *Ruler) LoadFontFamily(fontFamily *d2fonts.FontFamily) error {
 	for _, fontStyle := range d2fonts.FontStyles {
 		font := d2fonts.Font{
 			Family: *fontFamily,
 			Style:  fontStyle,
 			Size:   SIZELESS_FONT_SIZE,
 		}
-		_, ok := r.ttfs[font]
-		if !ok {
-			return false
+		_, err := r.loadFont(font)
+		if err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	return true
+// loadFont returns the parsed face of font, which must be sizeless. Fonts are only parsed
+// once measured with, and once per process rather than per Ruler.
+func (r *Ruler) loadFont(font d2fonts.Font) (*truetype.Font, error) {
+	if ttf, ok := r.ttfs[font]; ok {
+		return ttf, nil
+	}
+	// Note: FontFaces lookup is size-agnostic
+	face, has := d2fonts.FontFaces.Lookup(font)
+	if !has {
+		return nil, fmt.Errorf("font %s %s is not loaded", font.Family, font.Style)
+	}
+	ttf, err := parseFont(face)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse font %s %s: %w", font.Family, font.Style, err)
+	}
+	r.ttfs[font] = ttf
+	return ttf, nil
 }
 
 func (r *Ruler) addFontSize(font d2fonts.Font) {
 	sizeless := font
 	sizeless.Size = SIZELESS_FONT_SIZE
-	face := truetype.NewFace(r.ttfs[sizeless], &truetype.Options{
+	ttf, err := r.loadFont(sizeless)
+	if err != nil {
+		// Custom fonts are checked with LoadFontFamily before measuring, which reports the
+		// error, so this only measures something rather than nothing.
+		sizeless.Family = d2fonts.SourceSansPro
+		ttf, err = r.loadFont(sizeless)
+		if err != nil {
+			// The embedded fonts always parse.
+			panic(err)
+		}
+	}
+	face := truetype.NewFace(ttf, &truetype.Options{
 		Size: float64(font.Size),
 	})
 	atlas := NewAtlas(face, ASCII)
@@ -231,13 +253,31 @@ func (t *Ruler) Measure(font d2fonts.Font, s string) (width, height int) {
 }
 
 func (t *Ruler) MeasurePrecise(font d2fonts.Font, s string) (width, height float64) {
+	// Loaded even on cache hits as scaleUnicode draws with the atlas.
 	if _, ok := t.atlases[font]; !ok {
 		t.addFontSize(font)
 	}
+
+	var key cacheKey
+	if t.Cache != nil {
+		key = cacheKey{
+			font:             font,
+			s:                s,
+			lineHeightFactor: t.LineHeightFactor,
+			boundsWithDot:    t.boundsWithDot,
+		}
+		if m, ok := t.Cache.get(key); ok {
+			return m.width, m.height
+		}
+	}
+
 	t.clear()
 	t.buf = append(t.buf, s...)
 	t.drawBuf(font)
 	b := t.bounds
+	if t.Cache != nil {
+		t.Cache.set(key, measurement{width: b.w(), height: b.h()})
+	}
 	return b.w(), b.h()
 }
 
@@ -119,3 +119,41 @@ func TestTextMeasureMarkdown(t *testing.T) {
 	}
 
 }
+
+func TestCache(t *testing.T) {
+	ruler1, err := textmeasure.NewRuler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ruler2, err := textmeasure.NewRuler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := textmeasure.NewCache()
+	ruler1.Cache = cache
+	ruler2.Cache = cache
+
+	font := d2fonts.SourceSansPro.Font(d2fonts.FONT_SIZE_M, d2fonts.FONT_STYLE_REGULAR)
+	w1, h1 := ruler1.Measure(font, "hello\nwörld 你好")
+	w2, h2 := ruler2.Measure(font, "hello\nwörld 你好")
+	assert.Equal(t, w1, w2)
+	assert.Equal(t, h1, h2)
+	stats := cache.Stats()
+	assert.Equal(t, stats.Hits, 3)
+	assert.Equal(t, stats.Misses, 3)
+
+	// The line height factor changes the height of multiline text.
+	ruler2.LineHeightFactor = 2
+	_, h3 := ruler2.Measure(font, "hello\nwörld 你好")
+	assert.Less(t, h2, h3)
+
+	// The same text is measured as without a cache.
+	ruler3, err := textmeasure.NewRuler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ruler3.Cache = nil
+	w4, h4 := ruler3.Measure(font, "hello\nwörld 你好")
+	assert.Equal(t, w1, w4)
+	assert.Equal(t, h1, h4)
+}